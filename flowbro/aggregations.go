@@ -0,0 +1,208 @@
+package flowbro
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+type aggregationJSON struct {
+	Name          string  `json:"name"`
+	Topic         string  `json:"topic"`
+	Function      string  `json:"function"`
+	Field         string  `json:"field"`
+	GroupBy       string  `json:"groupBy"`
+	WindowSeconds float64 `json:"windowSeconds"`
+	Filter        string  `json:"filter"`
+}
+
+// aggregation counts messages, sums a field or counts distinct values of a
+// field per group of a topic's messages over tumbling windows, reporting
+// each window as an aggregation event once it closes.
+type aggregation struct {
+	name     string
+	topic    *regexp.Regexp
+	function string
+	field    *celProgram
+	groupBy  *celProgram
+	filter   *celProgram // only messages it matches are aggregated, if set
+	window   time.Duration
+	start    time.Time
+	values   map[string]float64
+	distinct map[string]map[string]bool
+	closed   []event
+}
+
+const defaultAggregationWindow = time.Minute
+
+// aggregationMaxGroups bounds the groups per window; messages of new groups
+// are ignored once reached.
+const aggregationMaxGroups = 10000
+
+func processAggregations(aggregationsJSON []aggregationJSON) ([]*aggregation, error) {
+	aggregations := []*aggregation{}
+	for _, a := range aggregationsJSON {
+		if len(a.Name) == 0 || len(a.Topic) == 0 {
+			return nil, fmt.Errorf("Please define name and topic for your aggregation %v", a)
+		}
+		topic, err := regexp.Compile("^(?:" + a.Topic + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid topic regex for aggregation %v. err=%v", a.Name, err)
+		}
+		function := a.Function
+		if len(function) == 0 {
+			function = "count"
+		}
+		field := a.Field
+		switch function {
+		case "count":
+		case "sum":
+			if len(field) == 0 {
+				return nil, fmt.Errorf("Please define the field to sum for aggregation %v", a.Name)
+			}
+		case "distinct":
+			if len(field) == 0 {
+				field = "key"
+			}
+		default:
+			return nil, fmt.Errorf("Unknown function %v for aggregation %v; use count, sum or distinct", function, a.Name)
+		}
+		ag := &aggregation{name: a.Name, topic: topic, function: function}
+		if len(field) > 0 {
+			if ag.field, err = compileCEL(field); err != nil {
+				return nil, fmt.Errorf("Invalid field for aggregation %v. err=%v", a.Name, err)
+			}
+		}
+		groupBy := a.GroupBy
+		if len(groupBy) == 0 {
+			groupBy = "key"
+		}
+		if ag.groupBy, err = compileCEL(groupBy); err != nil {
+			return nil, fmt.Errorf("Invalid groupBy for aggregation %v. err=%v", a.Name, err)
+		}
+		if len(a.Filter) > 0 {
+			if ag.filter, err = compileCEL(a.Filter); err != nil {
+				return nil, fmt.Errorf("Invalid filter for aggregation %v. err=%v", a.Name, err)
+			}
+		}
+		ag.window = time.Duration(a.WindowSeconds * float64(time.Second))
+		if ag.window <= 0 {
+			ag.window = defaultAggregationWindow
+		}
+		ag.reset(time.Now())
+		aggregations = append(aggregations, ag)
+	}
+	return aggregations, nil
+}
+
+func (a *aggregation) reset(now time.Time) {
+	a.start = now.Truncate(a.window)
+	a.values = map[string]float64{}
+	a.distinct = map[string]map[string]bool{}
+}
+
+// roll closes the current window if now is past its end.
+func (a *aggregation) roll(now time.Time) {
+	if now.Sub(a.start) < a.window {
+		return
+	}
+	a.closed = append(a.closed, a.result())
+	a.reset(now)
+}
+
+func (a *aggregation) onMessage(m message, now time.Time) {
+	if !a.topic.MatchString(m.Topic) {
+		return
+	}
+	a.roll(now)
+	group, v, ok := a.valueOf(m)
+	if !ok {
+		return
+	}
+	if _, ok := a.values[group]; !ok && len(a.values) >= aggregationMaxGroups {
+		return
+	}
+
+	switch a.function {
+	case "count":
+		a.values[group]++
+	case "sum":
+		a.values[group] += v.(float64)
+	case "distinct":
+		if _, ok := a.distinct[group]; !ok {
+			a.distinct[group] = map[string]bool{}
+		}
+		a.distinct[group][fmt.Sprint(v)] = true
+		a.values[group] = float64(len(a.distinct[group]))
+	}
+}
+
+// valueOf returns the group of m and what it adds to it: nothing to count,
+// the number to sum or the value to count distinctly; ok is false if m
+// isn't aggregated.
+func (a *aggregation) valueOf(m message) (group string, v interface{}, ok bool) {
+	if !a.topic.MatchString(m.Topic) {
+		return "", nil, false
+	}
+	if a.filter != nil {
+		if ok, _ := a.filter.match(m); !ok {
+			return "", nil, false
+		}
+	}
+	g, err := a.groupBy.eval(m)
+	if err != nil || g == nil {
+		return "", nil, false
+	}
+	if a.function == "count" {
+		return fmt.Sprint(g), nil, true
+	}
+	if v, err = a.field.eval(m); err != nil {
+		return "", nil, false
+	}
+	if a.function == "sum" {
+		if v, ok = celNumber(v); !ok {
+			return "", nil, false
+		}
+	}
+	return fmt.Sprint(g), v, true
+}
+
+func (a *aggregation) result() event {
+	groups := []string{}
+	for g := range a.values {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+	rows := []map[string]interface{}{}
+	for _, g := range groups {
+		rows = append(rows, map[string]interface{}{"group": g, "value": a.values[g]})
+	}
+	return event{
+		EventType: "aggregation",
+		Text:      fmt.Sprintf("Aggregation [%v]: %v of %v groups between %v and %v.", a.name, a.function, len(groups), a.start.Format(time.RFC3339), a.start.Add(a.window).Format(time.RFC3339)),
+		JSON:      rows,
+	}
+}
+
+// report returns an aggregation event for every window that closed.
+func (a *aggregation) report(now time.Time) []event {
+	a.roll(now)
+	closed := a.closed
+	a.closed = nil
+	return closed
+}
+
+func aggregateMessage(aggregations []*aggregation, m message, now time.Time) {
+	for _, a := range aggregations {
+		a.onMessage(m, now)
+	}
+}
+
+func reportAggregations(aggregations []*aggregation, now time.Time) []event {
+	events := []event{}
+	for _, a := range aggregations {
+		events = append(events, a.report(now)...)
+	}
+	return events
+}
@@ -0,0 +1,66 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAggregations(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	order := func(customer, total string) message {
+		return message{Topic: "orders", Key: "o" + total, Value: newValueFrom(`{"customer":"` + customer + `","total":` + total + `}`)}
+	}
+	messages := []message{order("a", "1"), order("a", "2"), order("b", "5"), order("a", "2"), {Topic: "payments", Key: "p"}}
+
+	tests := []struct {
+		aggregation aggregationJSON
+		expected    map[string]float64
+	}{
+		{aggregationJSON{Name: "n", Topic: "orders"}, map[string]float64{"o1": 1, "o2": 2, "o5": 1}},
+		{aggregationJSON{Name: "n", Topic: "orders", GroupBy: "value.customer"}, map[string]float64{"a": 3, "b": 1}},
+		{aggregationJSON{Name: "n", Topic: "orders", Function: "sum", Field: "value.total", GroupBy: "value.customer"}, map[string]float64{"a": 5, "b": 5}},
+		{aggregationJSON{Name: "n", Topic: "orders", Function: "distinct", GroupBy: "value.customer"}, map[string]float64{"a": 2, "b": 1}},
+		{aggregationJSON{Name: "n", Topic: "orders", Function: "distinct", Field: "value.customer", GroupBy: "topic"}, map[string]float64{"orders": 2}},
+	}
+	for _, tt := range tests {
+		aggregations, err := processAggregations([]aggregationJSON{tt.aggregation})
+		if err != nil {
+			t.Fatalf("on '%+v': shouldn't have failed but did with %v", tt.aggregation, err)
+		}
+		aggregations[0].reset(start)
+		for _, m := range messages {
+			aggregateMessage(aggregations, m, start.Add(time.Second))
+		}
+		if events := reportAggregations(aggregations, start.Add(30*time.Second)); len(events) != 0 {
+			t.Errorf("on '%+v': expected no events before the window closed but got %+v", tt.aggregation, events)
+		}
+		events := reportAggregations(aggregations, start.Add(time.Minute))
+		if len(events) != 1 || events[0].EventType != "aggregation" || len(events[0].JSON) != len(tt.expected) {
+			t.Errorf("on '%+v': expected an aggregation event with %v rows but got %+v", tt.aggregation, len(tt.expected), events)
+			continue
+		}
+		for _, row := range events[0].JSON {
+			if tt.expected[row["group"].(string)] != row["value"] {
+				t.Errorf("on '%+v': expected %v but got %v for group %v", tt.aggregation, tt.expected[row["group"].(string)], row["value"], row["group"])
+			}
+		}
+		if events := reportAggregations(aggregations, start.Add(90*time.Second)); len(events) != 0 {
+			t.Errorf("on '%+v': expected the next window to still be open but got %+v", tt.aggregation, events)
+		}
+	}
+}
+
+func TestInvalidAggregations(t *testing.T) {
+	configs := []aggregationJSON{
+		{Topic: "orders"},
+		{Name: "n", Topic: "("},
+		{Name: "n", Topic: "orders", Function: "avg"},
+		{Name: "n", Topic: "orders", Function: "sum"},
+		{Name: "n", Topic: "orders", GroupBy: "value.id =="},
+	}
+	for _, c := range configs {
+		if _, err := processAggregations([]aggregationJSON{c}); err == nil {
+			t.Errorf("on '%+v': expected processAggregations to fail", c)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+type alertJSON struct {
+	Name            string         `json:"name"`
+	Topic           string         `json:"topic"`
+	Patterns        []pattern      `json:"patterns"`
+	Expr            string         `json:"expr"`
+	SilenceSeconds  int            `json:"silenceSeconds"`
+	LagAbove        int64          `json:"lagAbove"`
+	ComponentId     string         `json:"componentId"`
+	Text            string         `json:"text"`
+	Webhook         string         `json:"webhook"`
+	Notifiers       []notifierJSON `json:"notifiers"`
+	CooldownSeconds *int           `json:"cooldownSeconds"`
+	SLA             *slaJSON       `json:"sla"`
+}
+
+type alert struct {
+	alertJSON
+	topic     *regexp.Regexp
+	expr      *celProgram
+	sla       *pair
+	cooldown  time.Duration
+	notifiers []notifier
+}
+
+type alertState struct {
+	lastSeen  time.Time
+	lastFired time.Time
+	silent    bool // fired for silence, which hasn't ended since
+	lagging   bool // fired for lag, which hasn't dropped since
+}
+
+// alerter evaluates alert rules against consumed messages and, periodically,
+// against silence and lag thresholds.
+type alerter struct {
+	alerts  []alert
+	states  []alertState
+	offsets map[string]map[int32]int64
+	hwms    func() map[string]map[int32]int64
+	notify  func(alert, string)
+}
+
+const defaultAlertCooldown = 60 * time.Second
+
+// ownedConn is implemented by the conns streaming a config flowbro was
+// started with, rather than one a client sent. Only their alerts notify, so
+// that clients can't have the server post wherever they like.
+type ownedConn interface {
+	conn
+	owned()
+}
+
+// notifying returns whether the alerts of the config streamed to ws may
+// notify, along with a warning if some of them won't.
+func notifying(ws conn, alerts []alert) (bool, []event) {
+	if _, ok := ws.(ownedConn); ok {
+		return true, nil
+	}
+	for _, al := range alerts {
+		if len(al.notifiers) > 0 {
+			return false, []event{{EventType: "log", Text: "Only alerts of configs flowbro was started with notify; this view's alerts only show here.", Color: "warning"}}
+		}
+	}
+	return false, nil
+}
+
+func processAlerts(alertsJSON []alertJSON) ([]alert, error) {
+	alerts := []alert{}
+	for _, a := range alertsJSON {
+		if len(a.Name) == 0 {
+			return alerts, fmt.Errorf("Please define a name for your alert %v", a)
+		}
+		if len(a.Patterns) == 0 && len(a.Expr) == 0 && a.SilenceSeconds <= 0 && a.LagAbove <= 0 && a.SLA == nil {
+			return alerts, fmt.Errorf("Alert %v needs patterns, expr, silenceSeconds, lagAbove or sla", a.Name)
+		}
+		topic, err := regexp.Compile(a.Topic)
+		if err != nil {
+			return alerts, fmt.Errorf("Invalid topic regex for alert %v. err=%v", a.Name, err)
+		}
+		for _, p := range a.Patterns {
+			if _, err := regexp.Compile(p.Pattern); err != nil {
+				return alerts, fmt.Errorf("Invalid pattern for alert %v. err=%v", a.Name, err)
+			}
+		}
+
+		var expr *celProgram
+		if len(a.Expr) > 0 {
+			if expr, err = compileCEL(a.Expr); err != nil {
+				return alerts, fmt.Errorf("Invalid expr for alert %v. err=%v", a.Name, err)
+			}
+		}
+
+		sla, err := processSLA(a.Name, a.ComponentId, a.SLA)
+		if err != nil {
+			return alerts, fmt.Errorf("Invalid sla for alert %v. err=%v", a.Name, err)
+		}
+
+		notifiers, err := processNotifiers(a.Notifiers, a.Webhook)
+		if err != nil {
+			return alerts, fmt.Errorf("Invalid notifiers for alert %v. err=%v", a.Name, err)
+		}
+
+		cooldown := defaultAlertCooldown
+		if a.CooldownSeconds != nil {
+			cooldown = time.Duration(*a.CooldownSeconds) * time.Second
+		}
+		alerts = append(alerts, alert{alertJSON: a, topic: topic, expr: expr, sla: sla, cooldown: cooldown, notifiers: notifiers})
+	}
+	return alerts, nil
+}
+
+func newAlerter(alerts []alert, hwms func() map[string]map[int32]int64) *alerter {
+	now := time.Now()
+	states := make([]alertState, len(alerts))
+	for i := range states {
+		states[i].lastSeen = now
+	}
+	return &alerter{
+		alerts:  alerts,
+		states:  states,
+		offsets: map[string]map[int32]int64{},
+		hwms:    hwms,
+		notify:  notifyAlert,
+	}
+}
+
+// onMessage returns the alert events triggered by m.
+func (a *alerter) onMessage(m message, now time.Time) []event {
+	if m.Count == 0 { // bookie counts don't come from a partition
+		if _, ok := a.offsets[m.Topic]; !ok {
+			a.offsets[m.Topic] = map[int32]int64{}
+		}
+		a.offsets[m.Topic][m.Partition] = m.Offset
+	}
+
+	events := []event{}
+	for i, al := range a.alerts {
+		if al.sla != nil {
+			events = append(events, a.onSLAMessage(i, m, now)...)
+			continue
+		}
+		if !al.topic.MatchString(m.Topic) {
+			continue
+		}
+		a.states[i].lastSeen = now
+
+		if len(al.Patterns) == 0 && al.expr == nil {
+			continue
+		}
+		matched, err := matchPatterns(al.Patterns, m)
+		if err == nil && matched && al.expr != nil {
+			matched, err = al.expr.match(m)
+		}
+		if err != nil || !matched {
+			continue
+		}
+
+		text := fmt.Sprintf("Alert [%v] triggered by message on topic %v", al.Name, m.Topic)
+		if len(al.Text) > 0 {
+			if b, err := parseTempl(al.Text, m); err == nil {
+				text = string(b)
+			}
+		}
+		if e, ok := a.fire(i, text, now); ok {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// check returns the alert events for silence and lag thresholds crossed by now.
+func (a *alerter) check(now time.Time) []event {
+	events := []event{}
+	var hwms map[string]map[int32]int64
+	for i, al := range a.alerts {
+		if al.sla != nil {
+			events = append(events, a.checkSLAs(i, now)...)
+			continue
+		}
+		if al.SilenceSeconds > 0 {
+			silence := now.Sub(a.states[i].lastSeen)
+			if silence < time.Duration(al.SilenceSeconds)*time.Second {
+				a.states[i].silent = false
+			} else if !a.states[i].silent {
+				if e, ok := a.fire(i, fmt.Sprintf("Alert [%v]: no messages on topic %v for %v", al.Name, al.Topic, durationRound(silence, time.Second)), now); ok {
+					a.states[i].silent = true
+					events = append(events, e)
+				}
+			}
+		}
+
+		if al.LagAbove > 0 && a.hwms != nil {
+			if hwms == nil {
+				hwms = a.hwms()
+			}
+			lag := a.lag(al, hwms)
+			if lag <= al.LagAbove {
+				a.states[i].lagging = false
+			} else if !a.states[i].lagging {
+				if e, ok := a.fire(i, fmt.Sprintf("Alert [%v]: lag on topic %v is %v messages", al.Name, al.Topic, lag), now); ok {
+					a.states[i].lagging = true
+					events = append(events, e)
+				}
+			}
+		}
+	}
+	return events
+}
+
+func (a *alerter) lag(al alert, hwms map[string]map[int32]int64) int64 {
+	lag := int64(0)
+	for topic, partitions := range hwms {
+		if !al.topic.MatchString(topic) {
+			continue
+		}
+		for partition, hwm := range partitions {
+			if o, ok := a.offsets[topic][partition]; ok {
+				lag += hwm - o - 1
+			}
+		}
+	}
+	return lag
+}
+
+func (a *alerter) fire(i int, text string, now time.Time) (event, bool) {
+	al := a.alerts[i]
+	if !a.states[i].lastFired.IsZero() && now.Sub(a.states[i].lastFired) < al.cooldown {
+		return event{}, false
+	}
+	a.states[i].lastFired = now
+
+	if a.notify != nil {
+		a.notify(al, text)
+	}
+	return event{EventType: "alert", SourceId: al.ComponentId, Text: text, Color: "error", Highlight: true}, true
+}
+
+func matchPatterns(patterns []pattern, m message) (bool, error) {
+	for _, p := range patterns {
+		b, err := parseTempl(p.Field, m)
+		if err != nil {
+			return false, err
+		}
+
+		matched, err := regexp.Match(p.Pattern, b)
+		if err != nil {
+			return false, err
+		}
+
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// serverAlerts evaluates the alerts of the config flowbro was started with
+// -alerts, whether or not anybody has a dashboard open: they notify once,
+// and their events are sent to every connected client.
+type serverAlerts struct {
+	raw json.RawMessage
+
+	l       sync.Mutex
+	clients map[chan event]bool
+}
+
+const (
+	serverAlertsRetryInterval = 5 * time.Second
+	serverAlertsQueueSize     = 100
+)
+
+func newServerAlerts(path string) (*serverAlerts, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read alerts config %v. err=%v", path, err)
+	}
+	var c configJSON
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, fmt.Errorf("Invalid alerts config %v. err=%v", path, err)
+	}
+	if len(c.Alerts) == 0 {
+		return nil, fmt.Errorf("Invalid alerts config %v; please define its alerts", path)
+	}
+	if _, err := processConfig(&c); err != nil {
+		return nil, fmt.Errorf("Invalid alerts config %v. err=%v", path, err)
+	}
+	return &serverAlerts{raw: raw, clients: map[chan event]bool{}}, nil
+}
+
+// run evaluates the alerts until ctx is done, restarting their stream if it
+// ends, e.g. because its cluster went away.
+func (a *serverAlerts) run(ctx context.Context, f *flowbro) {
+	for {
+		var c configJSON
+		json.Unmarshal(a.raw, &c) // validated by newServerAlerts
+		r := (&http.Request{Method: "GET", URL: &url.URL{Path: "/alerts"}, RemoteAddr: "alerts"}).WithContext(ctx)
+		f.stream(&alertsConn{alerts: a, r: r}, operator, a.raw, &c, nil, nil)
+		if !sleep(ctx, serverAlertsRetryInterval) {
+			return
+		}
+	}
+}
+
+// subscribe returns the alert events for a client, until unsubscribed.
+func (a *serverAlerts) subscribe() (<-chan event, func()) {
+	if a == nil {
+		return nil, func() {}
+	}
+	a.l.Lock()
+	defer a.l.Unlock()
+	c := make(chan event, serverAlertsQueueSize)
+	a.clients[c] = true
+	return c, func() {
+		a.l.Lock()
+		defer a.l.Unlock()
+		delete(a.clients, c)
+	}
+}
+
+// broadcast sends e to every client, dropping it for those that are behind.
+func (a *serverAlerts) broadcast(e event) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	for c := range a.clients {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// alertsConn hands the alert events of the -alerts config to every client.
+type alertsConn struct {
+	alerts *serverAlerts
+	r      *http.Request
+}
+
+func (c *alertsConn) owned() {}
+
+func (c *alertsConn) Write(b []byte) (int, error) {
+	var events []event
+	if err := json.Unmarshal(b, &events); err != nil {
+		return 0, err
+	}
+	for _, e := range events {
+		if e.EventType == "alert" || e.EventType == "slaBreach" {
+			c.alerts.broadcast(e)
+		}
+	}
+	return len(b), nil
+}
+
+func (c *alertsConn) Close() error {
+	return nil
+}
+
+func (c *alertsConn) Request() *http.Request {
+	return c.r
+}
@@ -0,0 +1,129 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlerterFiresOnMatchingMessages(t *testing.T) {
+	alerts, err := processAlerts([]alertJSON{
+		{Name: "errors", Topic: "payments", ComponentId: "Payments", Patterns: []pattern{{Field: `{{index .Value "status"}}`, Pattern: "ERROR"}}},
+	})
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	a := newAlerter(alerts, nil)
+	a.notify = nil
+
+	now := time.Now()
+	tests := []struct {
+		name     string
+		m        message
+		at       time.Time
+		expected int
+	}{
+		{name: "non matching value", m: message{Topic: "payments", Value: newValueFrom(`{"status":"OK"}`)}, at: now, expected: 0},
+		{name: "non matching topic", m: message{Topic: "orders", Value: newValueFrom(`{"status":"ERROR"}`)}, at: now, expected: 0},
+		{name: "matching", m: message{Topic: "payments", Value: newValueFrom(`{"status":"ERROR"}`)}, at: now, expected: 1},
+		{name: "matching within cooldown", m: message{Topic: "payments", Value: newValueFrom(`{"status":"ERROR"}`)}, at: now.Add(time.Second), expected: 0},
+		{name: "matching after cooldown", m: message{Topic: "payments", Value: newValueFrom(`{"status":"ERROR"}`)}, at: now.Add(2 * defaultAlertCooldown), expected: 1},
+	}
+
+	for _, ts := range tests {
+		es := a.onMessage(ts.m, ts.at)
+		if len(es) != ts.expected {
+			t.Errorf("on '%v': expected %v alert events but got %+v", ts.name, ts.expected, es)
+		}
+		for _, e := range es {
+			if e.EventType != "alert" || e.SourceId != "Payments" {
+				t.Errorf("on '%v': unexpected alert event %+v", ts.name, e)
+			}
+		}
+	}
+}
+
+func TestAlerterFiresOnSilenceAndLag(t *testing.T) {
+	alerts, err := processAlerts([]alertJSON{
+		{Name: "silent", Topic: "orders", SilenceSeconds: 60},
+		{Name: "lagging", Topic: "orders", LagAbove: 10},
+	})
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	hwm := int64(5)
+	a := newAlerter(alerts, func() map[string]map[int32]int64 { return map[string]map[int32]int64{"orders": {0: hwm}} })
+	a.notify = nil
+
+	now := time.Now()
+	a.onMessage(message{Topic: "orders", Offset: 4}, now)
+	if es := a.check(now.Add(time.Second)); len(es) != 0 {
+		t.Errorf("expected no alerts yet but got %+v", es)
+	}
+
+	hwm = 100
+	if es := a.check(now.Add(2 * time.Minute)); len(es) != 2 {
+		t.Errorf("expected silence and lag alerts but got %+v", es)
+	}
+	if es := a.check(now.Add(10 * time.Minute)); len(es) != 0 {
+		t.Errorf("expected alerts not to fire again while firing but got %+v", es)
+	}
+}
+
+func TestAlerterFiresForSilenceAndLagApart(t *testing.T) {
+	cooldown := 0
+	alerts, err := processAlerts([]alertJSON{{Name: "stuck", Topic: "orders", SilenceSeconds: 60, LagAbove: 10, CooldownSeconds: &cooldown}})
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	hwm := int64(5)
+	a := newAlerter(alerts, func() map[string]map[int32]int64 { return map[string]map[int32]int64{"orders": {0: hwm}} })
+	a.notify = nil
+
+	now := time.Now()
+	a.onMessage(message{Topic: "orders", Offset: 4}, now)
+	tests := []struct {
+		name     string
+		change   func()
+		at       time.Duration
+		expected int
+	}{
+		{name: "silent", at: 2 * time.Minute, expected: 1},
+		{name: "still silent", at: 3 * time.Minute, expected: 0},
+		{name: "lagging after a message", change: func() { hwm = 100; a.onMessage(message{Topic: "orders", Offset: 4}, now.Add(4*time.Minute)) }, at: 4 * time.Minute, expected: 1},
+		{name: "still lagging", at: 4*time.Minute + time.Second, expected: 0},
+		{name: "lagging and silent", at: 6 * time.Minute, expected: 1},
+		{name: "still both", at: 7 * time.Minute, expected: 0},
+	}
+	for _, ts := range tests {
+		if ts.change != nil {
+			ts.change()
+		}
+		if es := a.check(now.Add(ts.at)); len(es) != ts.expected {
+			t.Errorf("on '%v': expected %v alert events but got %+v", ts.name, ts.expected, es)
+		}
+	}
+}
+
+func TestOnlyOwnedConfigsNotify(t *testing.T) {
+	alerts, err := processAlerts([]alertJSON{{Name: "errors", Topic: "payments", Expr: "value.status == 'ERROR'", Webhook: "http://169.254.169.254/"}})
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	tests := []struct {
+		name     string
+		ws       conn
+		alerts   []alert
+		notifies bool
+		warns    bool
+	}{
+		{name: "client config", ws: &ndjsonConn{}, alerts: alerts, warns: true},
+		{name: "client config without notifiers", ws: &ndjsonConn{}},
+		{name: "headless config", ws: &printConn{}, alerts: alerts, notifies: true},
+	}
+	for _, ts := range tests {
+		notifies, events := notifying(ts.ws, ts.alerts)
+		if notifies != ts.notifies || ts.warns != (len(events) == 1) {
+			t.Errorf("on '%v': expected notifying %v and warning %v but got %v and %+v", ts.name, ts.notifies, ts.warns, notifies, events)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// role is what a principal may do: viewers can only stream and read, while
+// operators can also seek, pause, replay and change what's stored or
+// produced.
+type role int
+
+const (
+	viewer role = iota + 1
+	operator
+)
+
+var roleNames = map[role]string{viewer: "viewer", operator: "operator"}
+
+func (r role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return "none"
+}
+
+func parseRole(name string) (role, error) {
+	for r, n := range roleNames {
+		if n == name {
+			return r, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown role %v; use viewer or operator", name)
+}
+
+// readOnly strips from config, for connections of viewers, what would write
+// somewhere: the topic replayed messages are re-produced to, dead letters,
+// recordings and the notifiers of alerts. It returns a warning telling so.
+func readOnly(c *config) []event {
+	stripped := []string{}
+	if c.replayFilter != nil && len(c.replayFilter.produceTo) > 0 {
+		c.replayFilter.produceTo = ""
+		stripped = append(stripped, "re-produce replayed messages")
+	}
+	if c.deadLetterJSON != nil {
+		c.deadLetterJSON = nil
+		stripped = append(stripped, "keep dead letters")
+	}
+	if c.recordingJSON != nil {
+		c.recordingJSON = nil
+		stripped = append(stripped, "record messages")
+	}
+	notifiers := false
+	for i := range c.alerts {
+		notifiers = notifiers || len(c.alerts[i].notifiers) > 0
+		c.alerts[i].notifiers = nil
+	}
+	if notifiers {
+		stripped = append(stripped, "notify of alerts")
+	}
+	if len(stripped) == 0 {
+		return nil
+	}
+	return []event{{EventType: "log", Text: fmt.Sprintf("Only operators may %v; this view doesn't.", strings.Join(stripped, ", ")), Color: "warning"}}
+}
+
+// principal is who sent a request, and the boards they may open if not all.
+type principal struct {
+	Name   string   `json:"name"`
+	Role   role     `json:"-"`
+	Boards []string `json:"boards,omitempty"`
+}
+
+func (p principal) mayOpen(board string) bool {
+	if p.Boards == nil {
+		return true
+	}
+	for _, b := range p.Boards {
+		if b == board {
+			return true
+		}
+	}
+	return false
+}
+
+// mayRead tells whether p may read what was consumed for board: messages,
+// tables, recordings and the state of streams. What wasn't consumed for a
+// board ("") is only for principals who may open every board.
+func (p principal) mayRead(board string) bool {
+	return p.Boards == nil || (len(board) > 0 && p.mayOpen(board))
+}
+
+// authenticator identifies who sent a request; ok is false if the request
+// carries no valid credentials.
+type authenticator interface {
+	authenticate(r *http.Request) (p principal, ok bool, err error)
+}
+
+const tokenCookie = "flowbro_token"
+
+// requestToken returns the bearer token of r, from its Authorization header,
+// its access_token query parameter or the cookie set from the latter.
+func requestToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	if t := r.URL.Query().Get("access_token"); len(t) > 0 {
+		return t
+	}
+	if c, err := r.Cookie(tokenCookie); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// tokenAuthenticator authenticates static tokens read from a file like
+// {"<token>": {"name": "alice", "role": "operator"}}.
+type tokenAuthenticator struct {
+	tokens map[string]principal
+}
+
+func readTokens(path string) (*tokenAuthenticator, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read tokens. err=%v", err)
+	}
+	var tokens map[string]struct {
+		Name string `json:"name"`
+		Role string `json:"role"`
+	}
+	if err := json.Unmarshal(byt, &tokens); err != nil {
+		return nil, fmt.Errorf("Invalid tokens file %v. err=%v", path, err)
+	}
+	a := &tokenAuthenticator{tokens: map[string]principal{}}
+	for t, p := range tokens {
+		r, err := parseRole(p.Role)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid role for %v. err=%v", p.Name, err)
+		}
+		a.tokens[t] = principal{Name: p.Name, Role: r}
+	}
+	return a, nil
+}
+
+func (a *tokenAuthenticator) authenticate(r *http.Request) (principal, bool, error) {
+	t := requestToken(r)
+	if len(t) == 0 {
+		return principal{}, false, nil
+	}
+	p, ok := a.tokens[t]
+	return p, ok, nil
+}
+
+// challenger is an authenticator telling clients without credentials how to
+// authenticate, in a WWW-Authenticate header.
+type challenger interface {
+	challenge() string
+}
+
+type principalKey struct{}
+
+// authorize lets requests through to next only if their principal's role
+// allows them: reading takes a viewer, anything else an operator. Without
+// an authenticator everyone is an operator. Browsers opening the UI without
+// credentials are sent to log in if the authenticator has a login flow.
+func (f *flowbro) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := principal{Role: operator}
+		lf, isLoginFlow := f.auth.(loginFlow)
+		if isLoginFlow && r.URL.Path == oidcCallbackPath {
+			lf.callback(w, r)
+			return
+		}
+		if f.auth != nil {
+			var ok bool
+			var err error
+			if p, ok, err = f.auth.authenticate(r); err != nil {
+				writeError(w, http.StatusInternalServerError, fmt.Errorf("Could not authenticate. err=%v", err))
+				return
+			}
+			if !ok && isLoginFlow && r.Method == "GET" && r.URL.Path == "/" {
+				lf.login(w, r)
+				return
+			}
+			if c, isChallenger := f.auth.(challenger); !ok && isChallenger {
+				w.Header().Set("WWW-Authenticate", c.challenge())
+			}
+			if !ok {
+				writeError(w, http.StatusUnauthorized, fmt.Errorf("Please log in"))
+				return
+			}
+			if t := r.URL.Query().Get("access_token"); len(t) > 0 {
+				http.SetCookie(w, &http.Cookie{Name: tokenCookie, Value: t, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode, Secure: overTLS(r)})
+			}
+		}
+		if forgeable(r) {
+			writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("Please %v to %v with Content-Type application/json", r.Method, r.URL.Path))
+			return
+		}
+		required := viewer
+		if r.Method != "GET" && r.Method != "HEAD" {
+			required = operator
+		}
+		if p.Role < required {
+			writeError(w, http.StatusForbidden, fmt.Errorf("Role %v may not %v %v", p.Role, r.Method, r.URL.Path))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	})
+}
+
+// forgeable tells whether a page of another site could have made the
+// browser send r along with its cookies: a POST that isn't authenticated by
+// a header, and isn't JSON, which other sites can't send without a CORS
+// preflight. Other methods changing state need a preflight anyway.
+func forgeable(r *http.Request) bool {
+	if r.Method != "POST" || len(r.Header.Get("Authorization")) > 0 {
+		return false
+	}
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err != nil || t != "application/json"
+}
+
+// overTLS tells whether r reached flowbro, or the proxy in front of it, over
+// TLS, so cookies set in response are only sent over TLS too.
+func overTLS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// sameOrigin is the WebSocket handshake refusing connections opened by pages
+// of other sites, which browsers would send the token cookie along with.
+// Clients that aren't browsers don't send an Origin, and are let through.
+func sameOrigin(config *websocket.Config, r *http.Request) error {
+	if len(r.Header.Get("Origin")) == 0 {
+		return nil
+	}
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || origin.Host != r.Host {
+		return fmt.Errorf("Origin %v may not open WebSockets on %v", r.Header.Get("Origin"), r.Host)
+	}
+	config.Origin = origin
+	return nil
+}
+
+// principalOf returns the principal authorize found for r.
+func principalOf(r *http.Request) principal {
+	p, _ := r.Context().Value(principalKey{}).(principal)
+	return p
+}
@@ -0,0 +1,208 @@
+package flowbro
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func testAuthenticator() *tokenAuthenticator {
+	return &tokenAuthenticator{tokens: map[string]principal{
+		"v": {Name: "vera", Role: viewer},
+		"o": {Name: "otto", Role: operator},
+	}}
+}
+
+func TestAuthorize(t *testing.T) {
+	tests := []struct {
+		auth          authenticator
+		method, url   string
+		header        string
+		contentType   string
+		status        int
+		expectedRole  role
+		expectsCookie bool
+	}{
+		{nil, "POST", "/api/bookmarks", "", "application/json", http.StatusOK, operator, false},
+		{nil, "POST", "/api/bookmarks", "", "text/plain", http.StatusUnsupportedMediaType, 0, false},
+		{testAuthenticator(), "GET", "/api/bookmarks", "", "", http.StatusUnauthorized, 0, false},
+		{testAuthenticator(), "GET", "/api/bookmarks", "Bearer nope", "", http.StatusUnauthorized, 0, false},
+		{testAuthenticator(), "GET", "/api/bookmarks", "Bearer v", "", http.StatusOK, viewer, false},
+		{testAuthenticator(), "POST", "/api/bookmarks", "Bearer v", "application/json", http.StatusForbidden, 0, false},
+		{testAuthenticator(), "POST", "/api/bookmarks", "Bearer o", "application/x-www-form-urlencoded", http.StatusOK, operator, false},
+		{testAuthenticator(), "POST", "/api/bookmarks?access_token=o", "", "application/x-www-form-urlencoded", http.StatusUnsupportedMediaType, 0, true},
+		{testAuthenticator(), "DELETE", "/api/sessions?name=a", "Bearer o", "", http.StatusOK, operator, false},
+		{testAuthenticator(), "GET", "/?access_token=v", "", "", http.StatusOK, viewer, true},
+	}
+	for _, tt := range tests {
+		var got principal
+		f := &flowbro{auth: tt.auth}
+		h := f.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = principalOf(r) }))
+		r := httptest.NewRequest(tt.method, tt.url, nil)
+		if len(tt.header) > 0 {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if len(tt.contentType) > 0 {
+			r.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status || got.Role != tt.expectedRole {
+			t.Errorf("on '%v %v %v': expected %v as %v but got %v as %v", tt.method, tt.url, tt.header, tt.status, tt.expectedRole, w.Code, got.Role)
+		}
+		if cookie := strings.Contains(w.Header().Get("Set-Cookie"), tokenCookie); cookie != tt.expectsCookie {
+			t.Errorf("on '%v %v': expected cookie=%v but got %v", tt.method, tt.url, tt.expectsCookie, w.Header().Get("Set-Cookie"))
+		}
+		if cookie := w.Header().Get("Set-Cookie"); len(cookie) > 0 && !strings.Contains(cookie, "SameSite=Strict") {
+			t.Errorf("on '%v %v': expected a same-site cookie but got %v", tt.method, tt.url, cookie)
+		}
+	}
+}
+
+func TestWebSocketsRefuseOtherOrigins(t *testing.T) {
+	path := writeTempFile(t, `{"topic":"requests","value":{}}`)
+	defer os.Remove(path)
+	s := httptestServer(&flowbro{mockPath: path})
+	defer s.Close()
+	url := strings.Replace(s.URL, "http", "ws", 1) + "/ws"
+
+	if ws, err := websocket.Dial(url, "", "https://evil.example.com"); err == nil {
+		ws.Close()
+		t.Errorf("expected WebSockets opened by another site to be refused")
+	}
+	ws, err := websocket.Dial(url, "", s.URL)
+	if err != nil {
+		t.Fatalf("expected WebSockets opened by flowbro's own pages to be accepted. err=%v", err)
+	}
+	ws.Close()
+}
+
+func TestRequestTokenFromCookie(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: tokenCookie, Value: "o"})
+	if p, ok, _ := testAuthenticator().authenticate(r); !ok || p.Name != "otto" {
+		t.Errorf("expected the cookie to authenticate otto but got %+v", p)
+	}
+}
+
+func TestReadTokens(t *testing.T) {
+	tests := []struct {
+		content string
+		valid   bool
+	}{
+		{`{"t1":{"name":"a","role":"viewer"},"t2":{"name":"b","role":"operator"}}`, true},
+		{`{"t1":{"name":"a","role":"admin"}}`, false},
+		{`{`, false},
+	}
+	for _, tt := range tests {
+		path := writeTempFile(t, tt.content)
+		a, err := readTokens(path)
+		os.Remove(path)
+		if (err == nil) != tt.valid {
+			t.Errorf("on '%v': expected valid=%v but got err=%v", tt.content, tt.valid, err)
+		}
+		if err == nil && a.tokens["t2"].Role != operator {
+			t.Errorf("on '%v': expected t2 to be an operator but got %+v", tt.content, a.tokens)
+		}
+	}
+	if _, err := readTokens("/nonexistent"); err == nil {
+		t.Errorf("expected a missing tokens file to fail")
+	}
+}
+
+func TestViewersCantSendControls(t *testing.T) {
+	path := writeTempFile(t, `{"topic":"requests","value":{}}`)
+	defer os.Remove(path)
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := httptestServer(&flowbro{mockPath: path, dataDir: dir, auth: testAuthenticator()})
+	defer s.Close()
+	url := strings.Replace(s.URL, "http", "ws", 1) + "/ws"
+
+	if ws, err := websocket.Dial(url, "", s.URL); err == nil {
+		ws.Close()
+		t.Errorf("expected anonymous WebSockets to be refused")
+	}
+	ws, err := websocket.Dial(url+"?access_token=v", "", s.URL)
+	if err != nil {
+		t.Fatalf("Could not open WebSocket: %v", err)
+	}
+	defer ws.Close()
+	websocket.JSON.Send(ws, configJSON{HeartbeatUUID: "uuid"})
+	websocket.JSON.Send(ws, map[string]interface{}{"action": "pause"})
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var es []event
+		if err := websocket.JSON.Receive(ws, &es); err != nil {
+			t.Fatalf("Didn't receive an answer to the pause control. err=%v", err)
+		}
+		for _, e := range es {
+			if e.Color == "error" && strings.Contains(e.Text, "Only operators may pause") {
+				return
+			}
+		}
+	}
+}
+
+func TestViewersCantWrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   configJSON
+		stripped func(c *config) bool
+	}{
+		{
+			name:     "replay produceTo",
+			config:   configJSON{Replay: &replayJSON{Recording: "orders", ProduceTo: "orders-replayed"}},
+			stripped: func(c *config) bool { return c.replayFilter == nil || len(c.replayFilter.produceTo) == 0 },
+		},
+		{
+			name:     "dead letter topic",
+			config:   configJSON{DeadLetter: &deadLetterJSON{Topic: "orders-dlq", Brokers: "kafka:9092"}},
+			stripped: func(c *config) bool { return c.deadLetterJSON == nil },
+		},
+		{
+			name:     "alert webhook",
+			config:   configJSON{Alerts: []alertJSON{{Name: "errors", Topic: "orders", Expr: "true", Webhook: "http://hooks/alerts"}}},
+			stripped: func(c *config) bool { return len(c.alerts) == 1 && len(c.alerts[0].notifiers) == 0 },
+		},
+		{
+			name:     "alert notifiers",
+			config:   configJSON{Alerts: []alertJSON{{Name: "errors", Topic: "orders", Expr: "true", Notifiers: []notifierJSON{{Type: "slack", URL: "http://slack/hook"}}}}},
+			stripped: func(c *config) bool { return len(c.alerts) == 1 && len(c.alerts[0].notifiers) == 0 },
+		},
+		{
+			name:     "recording",
+			config:   configJSON{Recording: &recordingJSON{Name: "orders"}},
+			stripped: func(c *config) bool { return c.recordingJSON == nil },
+		},
+	}
+
+	for _, ts := range tests {
+		c, err := processConfig(&ts.config)
+		if err != nil {
+			t.Errorf("on '%v': shouldn't have failed, but did with %v", ts.name, err)
+			continue
+		}
+		events := readOnly(c)
+		if !ts.stripped(c) {
+			t.Errorf("on '%v': expected it to be stripped for viewers", ts.name)
+		}
+		if len(events) != 1 || events[0].Color != "warning" {
+			t.Errorf("on '%v': expected a warning but got %+v", ts.name, events)
+		}
+	}
+	if c, _ := processConfig(&configJSON{}); len(readOnly(c)) != 0 {
+		t.Errorf("expected no warning for configs that don't write")
+	}
+}
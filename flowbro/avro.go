@@ -0,0 +1,274 @@
+package flowbro
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// avroSchema is a parsed Avro schema, enough to decode the binary encoding
+// into the same shapes encoding/json produces. Logical types are decoded as
+// their underlying type.
+type avroSchema struct {
+	kind    string
+	name    string
+	fields  []avroField
+	symbols []string
+	items   *avroSchema
+	values  *avroSchema
+	size    int
+	union   []*avroSchema
+}
+
+type avroField struct {
+	name   string
+	schema *avroSchema
+}
+
+func parseAvroSchema(raw string) (*avroSchema, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		return nil, fmt.Errorf("Invalid Avro schema. err=%v", err)
+	}
+	named := map[string]*avroSchema{}
+	return avroParse(v, "", named)
+}
+
+func avroFullName(name, namespace string) string {
+	if strings.Contains(name, ".") || len(namespace) == 0 {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func avroParse(v interface{}, namespace string, named map[string]*avroSchema) (*avroSchema, error) {
+	switch t := v.(type) {
+	case string:
+		switch t {
+		case "null", "boolean", "int", "long", "float", "double", "bytes", "string":
+			return &avroSchema{kind: t}, nil
+		}
+		if s, ok := named[avroFullName(t, namespace)]; ok {
+			return s, nil
+		}
+		if s, ok := named[t]; ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("Unknown Avro type %v", t)
+	case []interface{}:
+		s := &avroSchema{kind: "union"}
+		for _, u := range t {
+			us, err := avroParse(u, namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			s.union = append(s.union, us)
+		}
+		return s, nil
+	case map[string]interface{}:
+		kind, _ := t["type"].(string)
+		if ns, ok := t["namespace"].(string); ok {
+			namespace = ns
+		}
+		name, _ := t["name"].(string)
+		s := &avroSchema{kind: kind, name: avroFullName(name, namespace)}
+
+		switch kind {
+		case "record", "error":
+			s.kind = "record"
+			named[s.name] = s // registered first so records may refer to themselves
+			if i := strings.LastIndex(s.name, "."); i >= 0 {
+				namespace = s.name[:i]
+			}
+			fields, _ := t["fields"].([]interface{})
+			for _, f := range fields {
+				fm, ok := f.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("Invalid field in Avro record %v", s.name)
+				}
+				fs, err := avroParse(fm["type"], namespace, named)
+				if err != nil {
+					return nil, err
+				}
+				fname, _ := fm["name"].(string)
+				s.fields = append(s.fields, avroField{name: fname, schema: fs})
+			}
+		case "enum":
+			named[s.name] = s
+			symbols, _ := t["symbols"].([]interface{})
+			for _, sym := range symbols {
+				s.symbols = append(s.symbols, fmt.Sprint(sym))
+			}
+		case "fixed":
+			named[s.name] = s
+			size, _ := t["size"].(float64)
+			s.size = int(size)
+		case "array":
+			items, err := avroParse(t["items"], namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			s.items = items
+		case "map":
+			values, err := avroParse(t["values"], namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			s.values = values
+		default:
+			return avroParse(t["type"], namespace, named)
+		}
+		return s, nil
+	}
+	return nil, fmt.Errorf("Invalid Avro schema %v", v)
+}
+
+type avroDecoder struct {
+	b []byte
+	i int
+}
+
+func avroDecode(s *avroSchema, b []byte) (interface{}, error) {
+	d := &avroDecoder{b: b}
+	v, err := d.decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode Avro value. err=%v", err)
+	}
+	return v, nil
+}
+
+func (d *avroDecoder) long() (int64, error) {
+	v, n := binary.Varint(d.b[d.i:])
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid varint")
+	}
+	d.i += n
+	return v, nil
+}
+
+func (d *avroDecoder) next(n int64) ([]byte, error) {
+	if n < 0 || int64(d.i)+n > int64(len(d.b)) {
+		return nil, fmt.Errorf("unexpected end of data")
+	}
+	b := d.b[d.i : d.i+int(n)]
+	d.i += int(n)
+	return b, nil
+}
+
+func (d *avroDecoder) decode(s *avroSchema) (interface{}, error) {
+	switch s.kind {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case "int", "long":
+		return d.long()
+	case "float":
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), nil
+	case "double":
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case "bytes", "string":
+		n, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		if s.kind == "bytes" {
+			return base64.StdEncoding.EncodeToString(b), nil
+		}
+		return string(b), nil
+	case "fixed":
+		b, err := d.next(int64(s.size))
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	case "enum":
+		i, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(s.symbols)) {
+			return nil, fmt.Errorf("enum index %v out of range", i)
+		}
+		return s.symbols[i], nil
+	case "union":
+		i, err := d.long()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= int64(len(s.union)) {
+			return nil, fmt.Errorf("union index %v out of range", i)
+		}
+		return d.decode(s.union[i])
+	case "record":
+		m := map[string]interface{}{}
+		for _, f := range s.fields {
+			v, err := d.decode(f.schema)
+			if err != nil {
+				return nil, err
+			}
+			m[f.name] = v
+		}
+		return m, nil
+	case "array", "map":
+		a, m := []interface{}{}, map[string]interface{}{}
+		for {
+			n, err := d.long()
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				break
+			}
+			if n < 0 { // block size in bytes follows negative counts
+				n = -n
+				if _, err := d.long(); err != nil {
+					return nil, err
+				}
+			}
+			for ; n > 0; n-- {
+				if s.kind == "array" {
+					v, err := d.decode(s.items)
+					if err != nil {
+						return nil, err
+					}
+					a = append(a, v)
+					continue
+				}
+				k, err := d.decode(&avroSchema{kind: "string"})
+				if err != nil {
+					return nil, err
+				}
+				v, err := d.decode(s.values)
+				if err != nil {
+					return nil, err
+				}
+				m[k.(string)] = v
+			}
+		}
+		if s.kind == "array" {
+			return a, nil
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported Avro type %v", s.kind)
+}
@@ -1,4 +1,4 @@
-package main
+package flowbro
 
 import (
 	"html/template"
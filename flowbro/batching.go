@@ -0,0 +1,171 @@
+package flowbro
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// batchingBackend is where a batchingSink sends its batches: webhooks,
+// Splunk, Loki and syslog.
+type batchingBackend interface {
+	// encode renders batch as the body of one post, timing items without
+	// their own timestamp at now.
+	encode(batch []interface{}, now time.Time) ([]byte, error)
+	// post sends body, returning whether a failure may succeed when retried.
+	post(body []byte) (bool, error)
+}
+
+// batchingCloser is a batchingBackend holding a connection to close once
+// its sink stops.
+type batchingCloser interface {
+	close()
+}
+
+// batchingSink queues what is forwarded to a backend, dropping it if the
+// queue is full, and sends it from its own goroutine in batches, once a
+// batch is full or flush has passed since its first item. Batches are
+// retried with backoff and dropped, and reported, once retries run out.
+type batchingSink struct {
+	name      string // e.g. webhook [big-orders], in reports and logs
+	unit      string // what is sent, e.g. messages
+	backend   batchingBackend
+	batchSize int
+	flush     time.Duration
+	policy    connectionPolicy
+	count     func(outcome string) // success, failure or dropped
+
+	in       chan interface{}
+	failures chan event
+}
+
+// Sinks send batches of up to defaultSinkBatchSize items, at most
+// defaultSinkFlush after the first one, retrying defaultSinkRetries times,
+// unless their configs say otherwise.
+const (
+	defaultSinkBatchSize = 100
+	defaultSinkFlush     = time.Second
+	defaultSinkRetries   = 3
+	batchingQueueSize    = 10000
+)
+
+func newBatchingSink(name, unit string, backend batchingBackend, count func(outcome string)) batchingSink {
+	return batchingSink{
+		name:      name,
+		unit:      unit,
+		backend:   backend,
+		batchSize: defaultSinkBatchSize,
+		flush:     defaultSinkFlush,
+		policy:    connectionPolicy{retries: defaultSinkRetries, initialBackoff: reconnectBase, maxBackoff: reconnectMax},
+		count:     count,
+	}
+}
+
+// start runs b in a goroutine of life, until it stops; until then nothing
+// is queued.
+func (b *batchingSink) start(life *lifecycle) {
+	b.in, b.failures = make(chan interface{}, batchingQueueSize), make(chan event, 10)
+	life.spawn(b.run)
+}
+
+func (b *batchingSink) started() bool {
+	return b.in != nil
+}
+
+// queue queues item if b started, dropping it if the queue is full.
+func (b *batchingSink) queue(item interface{}) {
+	if b.in == nil {
+		return
+	}
+	select {
+	case b.in <- item:
+	default:
+		b.count("dropped")
+	}
+}
+
+// reported drains the events about batches that couldn't be delivered.
+func (b *batchingSink) reported() []event {
+	events := []event{}
+	for done := false; !done; {
+		select {
+		case e := <-b.failures:
+			events = append(events, e)
+		default:
+			done = true
+		}
+	}
+	return events
+}
+
+// run sends a batch once it is full or flush has passed since its first
+// item, sending what is still queued, without retrying, when ctx is done.
+func (b *batchingSink) run(ctx context.Context) {
+	batch := []interface{}{}
+	if c, ok := b.backend.(batchingCloser); ok {
+		defer c.close()
+	}
+	timer := time.NewTimer(b.flush)
+	timer.Stop()
+	defer timer.Stop()
+	send := func(ctx context.Context) {
+		timer.Stop()
+		if len(batch) > 0 {
+			b.send(ctx, batch, time.Now())
+			batch = []interface{}{}
+		}
+	}
+	for {
+		select {
+		case item := <-b.in:
+			if len(batch) == 0 {
+				timer.Reset(b.flush)
+			}
+			if batch = append(batch, item); len(batch) >= b.batchSize {
+				send(ctx)
+			}
+		case <-timer.C:
+			send(ctx)
+		case <-ctx.Done():
+			for len(b.in) > 0 {
+				if batch = append(batch, <-b.in); len(batch) >= b.batchSize {
+					send(ctx)
+				}
+			}
+			send(ctx)
+			return
+		}
+	}
+}
+
+// send posts batch, retrying with backoff, and reports it as failed if the
+// backend didn't accept it. A done ctx sends once without retrying.
+func (b *batchingSink) send(ctx context.Context, batch []interface{}, now time.Time) {
+	body, err := b.backend.encode(batch, now)
+	if err != nil {
+		b.fail(fmt.Sprintf("Could not render %v %v for %v. err=%v", len(batch), b.unit, b.name, err))
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		retry, err := b.backend.post(body)
+		if err == nil {
+			b.count("success")
+			return
+		}
+		if !retry || b.policy.exhausted(attempt) || ctx.Err() != nil || !sleep(ctx, b.policy.backoff(attempt)) {
+			b.count("failure")
+			b.fail(fmt.Sprintf("Dropped %v %v for %v after %v attempts. err=%v", len(batch), b.unit, b.name, attempt, err))
+			return
+		}
+	}
+}
+
+func (b *batchingSink) fail(text string) {
+	log.WithFields(log.Fields{"sink": b.name}).Error(text)
+	select {
+	case b.failures <- event{EventType: "log", Text: text, Color: "error"}:
+	default:
+	}
+}
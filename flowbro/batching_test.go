@@ -0,0 +1,131 @@
+package flowbro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBackend joins batches of strings with commas and fails the posts
+// whose failures are listed, in turn, until there are no more.
+type fakeBackend struct {
+	sync.Mutex
+	failures []bool // whether each failure may be retried
+	posts    []string
+}
+
+func (b *fakeBackend) encode(batch []interface{}, now time.Time) ([]byte, error) {
+	items := []string{}
+	for _, i := range batch {
+		items = append(items, i.(string))
+	}
+	return []byte(strings.Join(items, ",")), nil
+}
+
+func (b *fakeBackend) post(body []byte) (bool, error) {
+	b.Lock()
+	defer b.Unlock()
+	b.posts = append(b.posts, string(body))
+	if len(b.failures) == 0 {
+		return false, nil
+	}
+	retry := b.failures[0]
+	b.failures = b.failures[1:]
+	return retry, errors.New("failed")
+}
+
+func (b *fakeBackend) posted() []string {
+	b.Lock()
+	defer b.Unlock()
+	return append([]string{}, b.posts...)
+}
+
+func TestBatchingSinkBatches(t *testing.T) {
+	backend, outcomes := &fakeBackend{}, []string{}
+	s := newBatchingSink("fake", "items", backend, func(outcome string) { outcomes = append(outcomes, outcome) })
+	s.batchSize, s.flush = 2, time.Minute
+	s.queue("ignored before starting")
+	life := newLifecycle(context.Background())
+	s.start(life)
+	for _, i := range []string{"a", "b", "c"} {
+		s.queue(i)
+	}
+	life.stop()
+	life.wait()
+
+	if posts := backend.posted(); fmt.Sprint(posts) != "[a,b c]" {
+		t.Errorf("expected a full batch and the rest sent on stop but got %v", posts)
+	}
+	if fmt.Sprint(outcomes) != "[success success]" {
+		t.Errorf("expected two successful posts to be counted but got %v", outcomes)
+	}
+}
+
+func TestBatchingSinkFlushes(t *testing.T) {
+	backend := &fakeBackend{}
+	s := newBatchingSink("fake", "items", backend, func(string) {})
+	s.batchSize, s.flush = 100, 10*time.Millisecond
+	life := newLifecycle(context.Background())
+	defer life.wait()
+	defer life.stop()
+	s.start(life)
+	s.queue("a")
+	for deadline := time.Now().Add(2 * time.Second); len(backend.posted()) == 0 && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if posts := backend.posted(); fmt.Sprint(posts) != "[a]" {
+		t.Errorf("expected the batch to be sent once flush passed but got %v", posts)
+	}
+}
+
+func TestBatchingSinkRetries(t *testing.T) {
+	ts := []struct {
+		failures         []bool
+		retries          int
+		expectedPosts    int
+		expectedFailures int
+	}{
+		{[]bool{true, true}, 3, 3, 0},
+		{[]bool{true, true, true}, 2, 3, 1},
+		{[]bool{false}, 3, 1, 1},
+		{[]bool{true}, 1, 2, 0},
+	}
+
+	for _, tc := range ts {
+		backend := &fakeBackend{failures: tc.failures}
+		s := newBatchingSink("fake", "items", backend, func(string) {})
+		s.policy.retries, s.policy.initialBackoff, s.policy.maxBackoff = tc.retries, time.Millisecond, time.Millisecond
+		s.failures = make(chan event, 10)
+
+		s.send(context.Background(), []interface{}{"a"}, time.Now())
+		if posts := backend.posted(); len(posts) != tc.expectedPosts {
+			t.Errorf("on '%v': expected %v posts but got %v", tc.failures, tc.expectedPosts, posts)
+		}
+		failures := s.reported()
+		if len(failures) != tc.expectedFailures {
+			t.Errorf("on '%v': expected %v failures but got %+v", tc.failures, tc.expectedFailures, failures)
+		}
+		if len(failures) > 0 && !strings.Contains(failures[0].Text, "Dropped 1 items for fake after") {
+			t.Errorf("on '%v': expected the failure to tell what was dropped but got %v", tc.failures, failures[0].Text)
+		}
+	}
+}
+
+func TestBatchingSinkDropsWhenFull(t *testing.T) {
+	dropped := 0
+	s := newBatchingSink("fake", "items", &fakeBackend{}, func(outcome string) {
+		if outcome == "dropped" {
+			dropped++
+		}
+	})
+	s.in = make(chan interface{}, 1)
+	s.queue("a")
+	s.queue("b")
+	if dropped != 1 {
+		t.Errorf("expected the item that didn't fit to be dropped but got %v", dropped)
+	}
+}
@@ -0,0 +1,58 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// readBoard reads the config of the board name from dir. Boards are configs
+// kept on the server, so that the teams sharing a deployment only see the
+// topics their board consumes.
+func readBoard(dir, name string) (json.RawMessage, *configJSON, error) {
+	if !safeName(name) {
+		return nil, nil, fmt.Errorf("Invalid board name %v", name)
+	}
+	byt, err := ioutil.ReadFile(filepath.Join(dir, name+".json"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not read board %v. err=%v", name, err)
+	}
+	var c configJSON
+	if err := json.Unmarshal(byt, &c); err != nil {
+		return nil, nil, fmt.Errorf("Invalid config for board %v. err=%v", name, err)
+	}
+	return json.RawMessage(byt), &c, nil
+}
+
+// onBoardConnected streams the board named by the path, e.g. /ws/payments.
+// The config the client sends only provides its session's heartbeat UUID
+// and fsmId; everything else comes from the board.
+func (f *flowbro) onBoardConnected() func(ws *websocket.Conn) {
+	return func(ws *websocket.Conn) {
+		name := strings.TrimPrefix(ws.Request().URL.Path, "/ws/")
+		log.Printf("Opened WebSocket connection to board %v!", name)
+
+		var client configJSON
+		if err := websocket.JSON.Receive(ws, &client); err != nil {
+			ws.Close()
+			log.Println("Didn't receive config from WebSocket!", err)
+			return
+		}
+		raw, configJSON, err := readBoard(f.boardsDir, name)
+		if err == nil && !principalOf(ws.Request()).mayOpen(name) {
+			err = fmt.Errorf("You may not open board %v", name)
+		}
+		if err != nil {
+			sendError(fmt.Sprintf("Closing WebSocket connection due to: %v\n", err), ws)
+			ws.Close()
+			return
+		}
+		configJSON.HeartbeatUUID, configJSON.FSMId, configJSON.board = client.HeartbeatUUID, client.FSMId, name
+		f.stream(ws, principalOf(ws.Request()).Role, raw, configJSON, nil, nil)
+	}
+}
@@ -0,0 +1,152 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestBoards(t *testing.T) {
+	fixtures := `{"topic":"payments","key":"1","value":{}}
+{"topic":"orders","key":"1","value":{}}
+`
+	path := writeTempFile(t, fixtures)
+	defer os.Remove(path)
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	board, _ := json.Marshal(configJSON{Rules: []rule{{
+		Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "payments"}},
+		Events:   []event{{EventType: "message", SourceId: "a", TargetId: "b"}},
+	}}})
+	if err := ioutil.WriteFile(filepath.Join(dir, "payments.json"), board, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptestServer(&flowbro{mockPath: path, boardsDir: dir})
+	defer s.Close()
+	url := strings.Replace(s.URL, "http", "ws", 1)
+
+	if ws, err := websocket.Dial(url+"/ws", "", s.URL); err == nil {
+		ws.Close()
+		t.Errorf("expected clients not to be able to send their own config when boards are served")
+	}
+
+	ws, err := websocket.Dial(url+"/ws/payments", "", s.URL)
+	if err != nil {
+		t.Fatalf("Could not open WebSocket: %v", err)
+	}
+	defer ws.Close()
+	client := configJSON{
+		Rules:         []rule{{Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "orders"}}, Events: []event{{EventType: "message", SourceId: "c", TargetId: "d"}}}},
+		HeartbeatUUID: "uuid",
+	}
+	if err := websocket.JSON.Send(ws, client); err != nil {
+		t.Fatalf("Could not send config: %v", err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	messages := []event{}
+	for {
+		var es []event
+		if err := websocket.JSON.Receive(ws, &es); err != nil {
+			break
+		}
+		for _, e := range es {
+			if e.EventType == "message" {
+				messages = append(messages, e)
+			}
+		}
+	}
+	if len(messages) != 1 || messages[0].SourceId != "a" {
+		t.Errorf("expected only the board's rules to apply but got %+v", messages)
+	}
+}
+
+func TestReadBoard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0644)
+
+	for _, name := range []string{"../payments", ".hidden", "missing", "broken"} {
+		if _, _, err := readBoard(dir, name); err == nil {
+			t.Errorf("on '%v': expected readBoard to fail", name)
+		}
+	}
+}
+
+func TestBoardRestrictedViewersOnlyReadTheirBoards(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f := &flowbro{boardsDir: dir, dataDir: dir, search: newSearchIndex(10), auth: &tokenAuthenticator{tokens: map[string]principal{
+		"p": {Name: "bill", Role: viewer, Boards: []string{"billing"}},
+	}}}
+	s := httptestServer(f)
+	defer s.Close()
+
+	for _, board := range []string{"billing", "shipping"} {
+		m := message{Topic: board, Key: "42", Value: newValueFrom(`{"status":"FAILED"}`)}
+		f.search.add(m, board, time.Now())
+		tables.upsert(tableKeyOf(board, "", board, nil), m)
+		r, err := openRecording(&recordingJSON{Name: board}, dir, json.RawMessage(`{}`), board)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.record(m, time.Now())
+		r.close()
+		st := newStreamState("websocket", nil, &config{board: board}, clusters{})
+		activeStreams.add(st)
+		defer activeStreams.remove(st)
+	}
+
+	tests := []struct {
+		path     string
+		status   int
+		contains string
+	}{
+		{"/api/search?q=failed", http.StatusOK, `"board":"billing"`},
+		{"/api/search?q=failed&recording=billing", http.StatusOK, `"topic":"billing"`},
+		{"/api/search?q=failed&recording=shipping", http.StatusForbidden, ""},
+		{"/api/table/billing?key=42&board=billing", http.StatusOK, `"key":"42"`},
+		{"/api/table/shipping?key=42&board=shipping", http.StatusForbidden, ""},
+		{"/api/table/shipping?key=42", http.StatusForbidden, ""},
+		{"/api/state", http.StatusOK, `"board":"billing"`},
+		{"/api/export?recording=billing", http.StatusOK, `"topic":"billing"`},
+		{"/api/export?recording=shipping", http.StatusForbidden, ""},
+		{"/api/export.csv?recording=shipping", http.StatusForbidden, ""},
+		{"/api/export.parquet?recording=shipping", http.StatusForbidden, ""},
+		{"/api/key-offsets?brokers=localhost:9092&topic=shipping&key=42", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest("GET", s.URL+tt.path, nil)
+		req.Header.Set("Authorization", "Bearer p")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byt, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || !strings.Contains(string(byt), tt.contains) {
+			t.Errorf("on '%v': expected %v with %q but got %v with %s", tt.path, tt.status, tt.contains, resp.StatusCode, byt)
+		}
+		if resp.StatusCode == http.StatusOK && strings.Contains(string(byt), "shipping") {
+			t.Errorf("on '%v': expected nothing of the shipping board but got %s", tt.path, byt)
+		}
+	}
+}
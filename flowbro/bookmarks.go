@@ -0,0 +1,163 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+type bookmark struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Note      string    `json:"note"`
+	Created   time.Time `json:"created"`
+}
+
+type annotation struct {
+	Id      string    `json:"id"`
+	FSMId   string    `json:"fsmId"`
+	Text    string    `json:"text"`
+	Author  string    `json:"author"`
+	Created time.Time `json:"created"`
+}
+
+type bookmarks struct {
+	store *store
+}
+
+type annotations struct {
+	store *store
+}
+
+func newBookmarks(dataDir string) *bookmarks {
+	return &bookmarks{store: newStore(dataDir, "bookmarks.json")}
+}
+
+func newAnnotations(dataDir string) *annotations {
+	return &annotations{store: newStore(dataDir, "annotations.json")}
+}
+
+func (b *bookmarks) handler(w http.ResponseWriter, r *http.Request) {
+	bms := []bookmark{}
+	switch r.Method {
+	case "GET":
+		if err := b.store.read(&bms); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		sort.Slice(bms, func(i, j int) bool { return bms[i].Created.Before(bms[j].Created) })
+		writeJSON(w, http.StatusOK, bms)
+	case "POST":
+		var bm bookmark
+		if err := json.NewDecoder(r.Body).Decode(&bm); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid bookmark. err=%v", err))
+			return
+		}
+		if len(bm.Name) == 0 || len(bm.Topic) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Please define name and topic for your bookmark"))
+			return
+		}
+		bm.Id, bm.Created = newId(), time.Now().UTC()
+		err := b.store.update(&bms, func() error {
+			bms = append(bms, bm)
+			return nil
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, bm)
+	case "DELETE":
+		id := r.URL.Query().Get("id")
+		found := false
+		err := b.store.update(&bms, func() error {
+			for i, bm := range bms {
+				if bm.Id == id {
+					bms, found = append(bms[:i], bms[i+1:]...), true
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, fmt.Errorf("Bookmark %v not found", id))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+	}
+}
+
+func (a *annotations) handler(w http.ResponseWriter, r *http.Request) {
+	as := []annotation{}
+	switch r.Method {
+	case "GET":
+		if err := a.store.read(&as); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		fsmId := r.URL.Query().Get("fsmId")
+		filtered := []annotation{}
+		for _, an := range as {
+			if len(fsmId) == 0 || an.FSMId == fsmId {
+				filtered = append(filtered, an)
+			}
+		}
+		sort.Slice(filtered, func(i, j int) bool { return filtered[i].Created.Before(filtered[j].Created) })
+		writeJSON(w, http.StatusOK, filtered)
+	case "POST":
+		var an annotation
+		if err := json.NewDecoder(r.Body).Decode(&an); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid annotation. err=%v", err))
+			return
+		}
+		if len(an.FSMId) == 0 || len(an.Text) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Please define fsmId and text for your annotation"))
+			return
+		}
+		an.Id, an.Created = newId(), time.Now().UTC()
+		err := a.store.update(&as, func() error {
+			as = append(as, an)
+			return nil
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, an)
+	case "DELETE":
+		id := r.URL.Query().Get("id")
+		found := false
+		err := a.store.update(&as, func() error {
+			for i, an := range as {
+				if an.Id == id {
+					as, found = append(as[:i], as[i+1:]...), true
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, fmt.Errorf("Annotation %v not found", id))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+	}
+}
@@ -0,0 +1,396 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+// busJSON configures the Kafka topic the instances of a deployment share
+// events on: publishers consume the boards and produce the events they make
+// of them to it, while frontends serve those events to websocket clients
+// without consuming the boards' topics themselves, so that adding viewers
+// doesn't add load on the boards' clusters.
+type busJSON struct {
+	Brokers string `json:"brokers"`
+	Topic   string `json:"topic"`
+}
+
+const (
+	busRetryInterval  = 5 * time.Second
+	busRescanInterval = 10 * time.Second
+	busQueueSize      = 100
+)
+
+var (
+	busPublished = newCounter("flowbro_bus_published_batches_total", "Batches of events of a board produced to the event bus.", "board")
+	busDropped   = newCounter("flowbro_bus_dropped_batches_total", "Batches of events of a board a frontend dropped because a client didn't keep up.", "board")
+)
+
+func readBus(path string) (*busJSON, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read bus config %v. err=%v", path, err)
+	}
+	var c busJSON
+	if err := json.Unmarshal(byt, &c); err != nil {
+		return nil, fmt.Errorf("Invalid bus config %v. err=%v", path, err)
+	}
+	if len(c.Brokers) == 0 || len(c.Topic) == 0 {
+		return nil, fmt.Errorf("Invalid bus config %v; please set its brokers and topic", path)
+	}
+	return &c, nil
+}
+
+// busPublisher streams every board to the bus, each batch of events sent
+// to clients becoming a message keyed by the board's name, so that the
+// events of a board stay in order.
+type busPublisher struct {
+	brokers  []string
+	topic    string
+	producer sarama.SyncProducer
+}
+
+func newBusPublisher(c *busJSON) *busPublisher {
+	return &busPublisher{brokers: strings.Split(c.Brokers, ","), topic: c.Topic}
+}
+
+// run publishes the boards of f until ctx is done, picking up boards added
+// since, stopping those removed, and restarting the streams that ended, e.g.
+// because their cluster went away.
+func (p *busPublisher) run(ctx context.Context, f *flowbro) {
+	for p.producer == nil {
+		producer, err := newSyncProducer(p.brokers)
+		if err == nil {
+			p.producer = producer
+			break
+		}
+		log.WithFields(log.Fields{"err": err, "brokers": p.brokers}).Error("Could not connect to the event bus.")
+		if !sleep(ctx, busRetryInterval) {
+			return
+		}
+	}
+	defer p.producer.Close()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	publishers := boardPublishers{}
+	for {
+		names, err := boardNames(f.boardsDir)
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("Could not list the boards to publish.")
+		} else {
+			publishers.update(ctx, names, func(ctx context.Context, name string) {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					p.publish(ctx, f, name)
+				}()
+			})
+		}
+		if !sleep(ctx, busRescanInterval) {
+			return
+		}
+	}
+}
+
+// publish streams board name as an operator would, so that its sinks and
+// webhooks forward as they do when the board is viewed without a bus.
+func (p *busPublisher) publish(ctx context.Context, f *flowbro, name string) {
+	for {
+		raw, c, err := readBoard(f.boardsDir, name)
+		if err != nil {
+			log.WithFields(log.Fields{"err": err, "board": name}).Error("Could not publish board to the event bus.")
+		} else {
+			c.board = name
+			r := (&http.Request{Method: "GET", URL: &url.URL{Path: "/bus/" + name}, RemoteAddr: "bus"}).WithContext(ctx)
+			f.stream(&busConn{board: name, topic: p.topic, producer: p.producer, r: r}, operator, raw, c, nil, nil)
+		}
+		if !sleep(ctx, busRetryInterval) {
+			return
+		}
+	}
+}
+
+// boardPublishers cancel the publisher of each board, by name.
+type boardPublishers map[string]context.CancelFunc
+
+// update starts publishing the boards of names that aren't yet with start,
+// and stops publishing those that aren't listed anymore.
+func (ps boardPublishers) update(ctx context.Context, names []string, start func(ctx context.Context, name string)) {
+	listed := map[string]bool{}
+	for _, name := range names {
+		listed[name] = true
+		if ps[name] != nil {
+			continue
+		}
+		boardCtx, cancel := context.WithCancel(ctx)
+		ps[name] = cancel
+		start(boardCtx, name)
+	}
+	for name, cancel := range ps {
+		if !listed[name] {
+			cancel()
+			delete(ps, name)
+		}
+	}
+}
+
+// boardNames lists the boards configured in dir.
+func boardNames(dir string) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, fi := range fis {
+		name := strings.TrimSuffix(fi.Name(), ".json")
+		if !fi.IsDir() && filepath.Ext(fi.Name()) == ".json" && safeName(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// busConn produces what's streamed to it to the bus, keyed by its board.
+type busConn struct {
+	board    string
+	topic    string
+	producer sarama.SyncProducer
+	r        *http.Request
+}
+
+func (c *busConn) Write(b []byte) (int, error) {
+	msg := &sarama.ProducerMessage{Topic: c.topic, Key: sarama.StringEncoder(c.board), Value: sarama.ByteEncoder(b)}
+	if _, _, err := c.producer.SendMessage(msg); err != nil {
+		return 0, fmt.Errorf("Could not produce events of board %v to the event bus. err=%v", c.board, err)
+	}
+	busPublished.inc(c.board)
+	return len(b), nil
+}
+
+func (c *busConn) Close() error {
+	return nil
+}
+
+func (c *busConn) Request() *http.Request {
+	return c.r
+}
+
+// busFrontend consumes the bus from its newest offsets and hands the events
+// of every board to the clients viewing it.
+type busFrontend struct {
+	brokers []string
+	topic   string
+
+	l       sync.Mutex
+	viewers map[string]map[chan []byte]bool
+}
+
+func newBusFrontend(c *busJSON) *busFrontend {
+	return &busFrontend{brokers: strings.Split(c.Brokers, ","), topic: c.Topic, viewers: map[string]map[chan []byte]bool{}}
+}
+
+// run consumes the bus until ctx is done, reconnecting if it fails.
+func (b *busFrontend) run(ctx context.Context) {
+	for {
+		if err := b.connect(ctx); err != nil {
+			log.WithFields(log.Fields{"err": err, "brokers": b.brokers}).Error("Lost the event bus.")
+		}
+		if !sleep(ctx, busRetryInterval) {
+			return
+		}
+	}
+}
+
+func (b *busFrontend) connect(ctx context.Context) error {
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Version = sarama.V0_10_0_0
+	saramaConfig.ClientID = defaultClientId()
+	client, err := sarama.NewClient(b.brokers, saramaConfig)
+	if err != nil {
+		return fmt.Errorf("Error creating client for %v. err=%v", strings.Join(b.brokers, ","), err)
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return fmt.Errorf("Error creating consumer for %v. err=%v", strings.Join(b.brokers, ","), err)
+	}
+	defer consumer.Close()
+	return b.consume(ctx, consumer)
+}
+
+// consume hands the messages of every partition of the bus to the viewers
+// of their board, until ctx is done or a partition consumer fails.
+func (b *busFrontend) consume(ctx context.Context, consumer sarama.Consumer) error {
+	partitions, err := consumer.Partitions(b.topic)
+	if err != nil {
+		return fmt.Errorf("Error fetching partitions of bus topic %v. err=%v", b.topic, err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(partitions))
+	var wg sync.WaitGroup
+	for _, partition := range partitions {
+		pc, err := consumer.ConsumePartition(b.topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			cancel()
+			wg.Wait()
+			return fmt.Errorf("Error consuming partition %v of bus topic %v. err=%v", partition, b.topic, err)
+		}
+		wg.Add(1)
+		go func(pc sarama.PartitionConsumer) {
+			defer wg.Done()
+			defer pc.Close()
+			for {
+				select {
+				case m, ok := <-pc.Messages():
+					if !ok {
+						errs <- fmt.Errorf("Stopped consuming bus topic %v", b.topic)
+						return
+					}
+					b.publish(string(m.Key), m.Value)
+				case err, ok := <-pc.Errors():
+					if !ok {
+						errs <- fmt.Errorf("Stopped consuming bus topic %v", b.topic)
+						return
+					}
+					errs <- err
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(pc)
+	}
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+	}
+	cancel()
+	wg.Wait()
+	return err
+}
+
+// publish hands events to every viewer of board, dropping them for those
+// that are behind rather than holding the others up.
+func (b *busFrontend) publish(board string, events []byte) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	for c := range b.viewers[board] {
+		select {
+		case c <- events:
+		default:
+			busDropped.inc(board)
+		}
+	}
+}
+
+func (b *busFrontend) subscribe(board string) (<-chan []byte, func()) {
+	b.l.Lock()
+	defer b.l.Unlock()
+	c := make(chan []byte, busQueueSize)
+	if b.viewers[board] == nil {
+		b.viewers[board] = map[chan []byte]bool{}
+	}
+	b.viewers[board][c] = true
+	return c, func() {
+		b.l.Lock()
+		defer b.l.Unlock()
+		delete(b.viewers[board], c)
+		if len(b.viewers[board]) == 0 {
+			delete(b.viewers, board)
+		}
+	}
+}
+
+// onBusConnected serves the board named by the path, e.g. /ws/payments,
+// from the bus. The config the client sends only provides its heartbeat
+// UUID; the board's events are made by the publishers, so this view can't
+// seek, pause or replay.
+func (f *flowbro) onBusConnected() func(ws *websocket.Conn) {
+	return func(ws *websocket.Conn) {
+		name := strings.TrimPrefix(ws.Request().URL.Path, "/ws/")
+		log.Printf("Opened WebSocket connection to board %v on the event bus!", name)
+
+		var client configJSON
+		if err := websocket.JSON.Receive(ws, &client); err != nil {
+			ws.Close()
+			log.Println("Didn't receive config from WebSocket!", err)
+			return
+		}
+		var err error
+		switch {
+		case !safeName(name):
+			err = fmt.Errorf("Invalid board name %v", name)
+		case !principalOf(ws.Request()).mayOpen(name):
+			err = fmt.Errorf("You may not open board %v", name)
+		case !f.drain.enter():
+			err = fmt.Errorf("flowbro is draining before shutting down; please reconnect")
+		}
+		if err != nil {
+			sendError(fmt.Sprintf("Closing WebSocket connection due to: %v\n", err), ws)
+			ws.Close()
+			return
+		}
+		defer f.drain.leave()
+		connectedClients.add(1, "websocket")
+		defer connectedClients.add(-1, "websocket")
+		timeout := f.heartbeatTimeout
+		if timeout <= 0 {
+			timeout = defaultHeartbeatTimeout
+		}
+		f.bus.serve(ws, name, client.HeartbeatUUID, timeout, f.drain.signal())
+	}
+}
+
+// serve sends the events of board to ws until the client goes away, stops
+// sending heartbeats or the server drains.
+func (b *busFrontend) serve(ws *websocket.Conn, board, heartbeatUUID string, timeout time.Duration, draining <-chan struct{}) {
+	events, unsubscribe := b.subscribe(board)
+	defer unsubscribe()
+	life := newLifecycle(ws.Request().Context())
+	ctx := life.context()
+	hbCh, controls := make(chan struct{}), make(chan control)
+	life.spawn(func(ctx context.Context) {
+		processHeartbeats(ctx, receiverOf(ws, controls, ctx), hbCh, heartbeatUUID, timeout)
+	})
+	defer func() {
+		life.stop()
+		ws.Close()
+		life.wait()
+	}()
+
+	sendSuccess(fmt.Sprintf("Serving board %v from the event bus!", board), ws)
+	for {
+		select {
+		case byt := <-events:
+			if err := (sender{}).Send(ctx, ws, string(byt)); err != nil {
+				log.Printf("Error while trying to send to WebSocket: err=%v\n", err)
+				return
+			}
+		case ctl := <-controls:
+			sendError(fmt.Sprintf("Board %v is served from the event bus, which can't %v", board, ctl.Action), ws)
+		case <-draining:
+			sendEvents([]event{{EventType: "log", Text: "Flowbro is shutting down; please reconnect.", Color: "warning"}}, ws)
+			return
+		case <-hbCh:
+			sendError("Timing out due to heartbeat not received.", ws)
+			return
+		case <-ctx.Done():
+			sendError(fmt.Sprintf("Closing WebSocket connection due to: %v", ctx.Err()), ws)
+			return
+		}
+	}
+}
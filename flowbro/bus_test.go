@@ -0,0 +1,144 @@
+package flowbro
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"golang.org/x/net/websocket"
+)
+
+func TestReadBus(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name  string
+		json  string
+		fails bool
+	}{
+		{name: "valid", json: `{"brokers": "kafka-1:9092,kafka-2:9092", "topic": "flowbro.events"}`},
+		{name: "no topic", json: `{"brokers": "kafka-1:9092"}`, fails: true},
+		{name: "no brokers", json: `{"topic": "flowbro.events"}`, fails: true},
+		{name: "invalid", json: `{"brokers": `, fails: true},
+	}
+	for _, ts := range tests {
+		path := filepath.Join(dir, "bus.json")
+		ioutil.WriteFile(path, []byte(ts.json), 0644)
+		if _, err := readBus(path); ts.fails != (err != nil) {
+			t.Errorf("on '%v': expected failure %v but got %v", ts.name, ts.fails, err)
+		}
+	}
+}
+
+func TestBusConnProducesEventsKeyedByBoard(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(v []byte) error {
+		if string(v) != `[{"eventType":"message"}]` {
+			return errors.New("unexpected events " + string(v))
+		}
+		return nil
+	})
+	producer.ExpectSendMessageAndFail(sarama.ErrLeaderNotAvailable)
+	c := &busConn{board: "payments", topic: "flowbro.events", producer: producer}
+
+	if _, err := c.Write([]byte(`[{"eventType":"message"}]`)); err != nil {
+		t.Errorf("expected the events to be produced but got %v", err)
+	}
+	if _, err := c.Write([]byte(`[]`)); err == nil {
+		t.Errorf("expected failing to produce to end the stream")
+	}
+	producer.Close()
+}
+
+func TestBusFrontendHandsEventsToViewers(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.SetTopicMetadata(map[string][]int32{"flowbro.events": {0}})
+	mpc := consumer.ExpectConsumePartition("flowbro.events", 0, sarama.OffsetNewest)
+	b := newBusFrontend(&busJSON{Brokers: "kafka-1:9092", Topic: "flowbro.events"})
+	payments, unsubscribe := b.subscribe("payments")
+	defer unsubscribe()
+	orders, stop := b.subscribe("orders")
+
+	consumed := make(chan error)
+	go func() { consumed <- b.consume(context.Background(), consumer) }()
+	stop()
+	mpc.YieldMessage(&sarama.ConsumerMessage{Key: []byte("payments"), Value: []byte(`[{"eventType":"message"}]`)})
+	select {
+	case events := <-payments:
+		if string(events) != `[{"eventType":"message"}]` {
+			t.Errorf("expected the board's events but got %s", events)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the viewer of the board to get its events")
+	}
+	if len(orders) != 0 {
+		t.Errorf("expected viewers of other boards not to get them")
+	}
+
+	mpc.YieldError(sarama.ErrOffsetOutOfRange)
+	select {
+	case err := <-consumed:
+		if err == nil {
+			t.Errorf("expected the partition consumer's error to be returned to reconnect")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected consuming to stop once a partition consumer failed")
+	}
+}
+
+func TestBusFrontendServesBoards(t *testing.T) {
+	f := &flowbro{bus: newBusFrontend(&busJSON{Brokers: "kafka-1:9092", Topic: "flowbro.events"}), heartbeatTimeout: time.Minute}
+	srv := httptest.NewServer(websocket.Handler(f.onBusConnected()))
+	defer srv.Close()
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/payments", "", srv.URL)
+	if err != nil {
+		t.Fatalf("Could not open WebSocket: %v", err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	websocket.JSON.Send(ws, configJSON{HeartbeatUUID: "uuid"})
+
+	var es []event
+	if err := websocket.JSON.Receive(ws, &es); err != nil || len(es) != 1 || es[0].Color != "happy" {
+		t.Fatalf("expected to be told the board is served from the bus but got %+v and %v", es, err)
+	}
+	f.bus.publish("orders", []byte(`[{"eventType":"message","sourceId":"orders"}]`))
+	f.bus.publish("payments", []byte(`[{"eventType":"message","sourceId":"payments"}]`))
+	if err := websocket.JSON.Receive(ws, &es); err != nil || len(es) != 1 || es[0].SourceId != "payments" {
+		t.Errorf("expected only the events of the board but got %+v and %v", es, err)
+	}
+	websocket.JSON.Send(ws, control{Action: "pause"})
+	if err := websocket.JSON.Receive(ws, &es); err != nil || len(es) != 1 || es[0].Color != "error" {
+		t.Errorf("expected controls to be refused but got %+v and %v", es, err)
+	}
+}
+
+func TestBoardPublishersFollowTheBoards(t *testing.T) {
+	ps := boardPublishers{}
+	started := map[string]context.Context{}
+	start := func(ctx context.Context, name string) { started[name] = ctx }
+
+	ps.update(context.Background(), []string{"orders", "payments"}, start)
+	orders, payments := started["orders"], started["payments"]
+	if len(started) != 2 {
+		t.Fatalf("expected every board to be published but got %v", started)
+	}
+	ps.update(context.Background(), []string{"payments", "refunds"}, start)
+	if orders.Err() == nil {
+		t.Errorf("expected the publisher of a removed board to stop")
+	}
+	if payments.Err() != nil || started["payments"] != payments {
+		t.Errorf("expected the publisher of a board still listed to go on")
+	}
+	if started["refunds"] == nil {
+		t.Errorf("expected the publisher of an added board to start")
+	}
+}
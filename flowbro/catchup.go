@@ -0,0 +1,86 @@
+package flowbro
+
+import (
+	"fmt"
+	"time"
+)
+
+type catchUpJSON struct {
+	Sample int `json:"sample"`
+}
+
+const defaultCatchUpSample = 10
+
+// catchUp tracks consumption of the backlog that existed when the session
+// started. Until every partition reaches the offset that was newest back
+// then, only one in sample messages is kept, so the backlog shows up quickly
+// as a sampled overview before switching to full-fidelity live streaming.
+type catchUp struct {
+	sample    int
+	targets   map[string]map[int32]int64
+	counts    map[string]map[int32]int
+	started   time.Time
+	skipped   int64
+	live      bool
+	announced bool
+}
+
+func newCatchUp(c *catchUpJSON, targets map[string]map[int32]int64) *catchUp {
+	if c == nil {
+		return nil
+	}
+	sample := c.Sample
+	if sample <= 0 {
+		sample = defaultCatchUpSample
+	}
+	cu := &catchUp{sample: sample, targets: map[string]map[int32]int64{}, counts: map[string]map[int32]int{}, started: time.Now()}
+	for t, ps := range targets {
+		for p, o := range ps {
+			if _, ok := cu.targets[t]; !ok {
+				cu.targets[t], cu.counts[t] = map[int32]int64{}, map[int32]int{}
+			}
+			cu.targets[t][p] = o
+		}
+	}
+	return cu
+}
+
+// onMessage reports whether the message at offset should be kept.
+func (c *catchUp) onMessage(topic string, partition int32, offset int64) bool {
+	if c.live {
+		return true
+	}
+	target, ok := c.targets[topic][partition]
+	if !ok {
+		return true
+	}
+	if offset >= target-1 {
+		delete(c.targets[topic], partition)
+		if len(c.targets[topic]) == 0 {
+			delete(c.targets, topic)
+		}
+		c.live = len(c.targets) == 0
+		return true
+	}
+
+	c.counts[topic][partition]++
+	if c.sample == 1 || c.counts[topic][partition]%c.sample == 1 {
+		return true
+	}
+	c.skipped++
+	return false
+}
+
+// announce returns the event telling clients the backlog was consumed, once.
+func (c *catchUp) announce() (event, bool) {
+	c.live = c.live || len(c.targets) == 0
+	if !c.live || c.announced {
+		return event{}, false
+	}
+	c.announced = true
+	return event{
+		EventType: "caughtUp",
+		Text:      fmt.Sprintf("Caught up with the backlog in %v, showing 1 in %v messages (%v skipped); now streaming live.", time.Since(c.started)/time.Millisecond*time.Millisecond, c.sample, c.skipped),
+		Color:     "happy",
+	}, true
+}
@@ -0,0 +1,47 @@
+package flowbro
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCatchUp(t *testing.T) {
+	cu := newCatchUp(&catchUpJSON{Sample: 3}, map[string]map[int32]int64{"orders": {0: 8, 1: 2}})
+
+	kept := []int64{}
+	for o := int64(0); o < 10; o++ {
+		if cu.onMessage("orders", 0, o) {
+			kept = append(kept, o)
+		}
+		if _, ok := cu.announce(); ok {
+			t.Errorf("expected no announcement while partition 1 is behind")
+		}
+	}
+	if expected := []int64{0, 3, 6, 7, 8, 9}; !reflect.DeepEqual(kept, expected) {
+		t.Errorf("expected to keep offsets %v but kept %v", expected, kept)
+	}
+	if !cu.onMessage("payments", 0, 0) {
+		t.Errorf("expected partitions without a backlog to be kept")
+	}
+
+	cu.onMessage("orders", 1, 1)
+	e, ok := cu.announce()
+	if !ok || e.EventType != "caughtUp" {
+		t.Errorf("expected a caughtUp event once all partitions caught up but got %v", e)
+	}
+	if _, ok := cu.announce(); ok {
+		t.Errorf("expected a single announcement")
+	}
+	if !cu.onMessage("orders", 0, 2) {
+		t.Errorf("expected every message to be kept once live")
+	}
+}
+
+func TestCatchUpWithoutBacklog(t *testing.T) {
+	if newCatchUp(nil, nil) != nil {
+		t.Errorf("expected catch up to be disabled by default")
+	}
+	if _, ok := newCatchUp(&catchUpJSON{}, nil).announce(); !ok {
+		t.Errorf("expected sessions without a backlog to be announced live right away")
+	}
+}
@@ -0,0 +1,223 @@
+package flowbro
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// cborDecoder decodes CBOR (RFC 7049) items into the same shapes
+// encoding/json produces, so CBOR values can flow through rules and the UI
+// like JSON ones. Byte strings become base64 strings and tags are dropped.
+type cborDecoder struct {
+	b []byte
+	i int
+}
+
+const cborMaxDepth = 512
+
+func cborToMap(b []byte) (map[string]interface{}, error) {
+	d := &cborDecoder{b: b}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode CBOR value. err=%v", err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("CBOR value is not a map: %v", v)
+	}
+	return m, nil
+}
+
+func (d *cborDecoder) next(n int) ([]byte, error) {
+	if n < 0 || d.i+n > len(d.b) {
+		return nil, fmt.Errorf("unexpected end of data")
+	}
+	b := d.b[d.i : d.i+n]
+	d.i += n
+	return b, nil
+}
+
+// header returns the major type, the additional info and its argument.
+func (d *cborDecoder) header() (byte, byte, uint64, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info == 24:
+		b, err = d.next(1)
+		if err == nil {
+			arg = uint64(b[0])
+		}
+	case info == 25:
+		b, err = d.next(2)
+		if err == nil {
+			arg = uint64(binary.BigEndian.Uint16(b))
+		}
+	case info == 26:
+		b, err = d.next(4)
+		if err == nil {
+			arg = uint64(binary.BigEndian.Uint32(b))
+		}
+	case info == 27:
+		b, err = d.next(8)
+		if err == nil {
+			arg = binary.BigEndian.Uint64(b)
+		}
+	case info == 31:
+	default:
+		err = fmt.Errorf("invalid additional info %v", info)
+	}
+	return major, info, arg, err
+}
+
+func (d *cborDecoder) isBreak() bool {
+	if d.i < len(d.b) && d.b[d.i] == 0xff {
+		d.i++
+		return true
+	}
+	return false
+}
+
+func (d *cborDecoder) item(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, fmt.Errorf("nesting too deep")
+	}
+	major, info, arg, err := d.header()
+	if err != nil {
+		return nil, err
+	}
+	indefinite := info == 31
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return arg, nil
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return -1 - float64(arg), nil
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		s, err := d.str(major, indefinite, arg)
+		if err != nil {
+			return nil, err
+		}
+		if major == 2 {
+			return base64.StdEncoding.EncodeToString(s), nil
+		}
+		return string(s), nil
+	case 4:
+		a := []interface{}{}
+		for n := uint64(0); indefinite || n < arg; n++ {
+			if indefinite && d.isBreak() {
+				break
+			}
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		return a, nil
+	case 5:
+		m := map[string]interface{}{}
+		for n := uint64(0); indefinite || n < arg; n++ {
+			if indefinite && d.isBreak() {
+				break
+			}
+			k, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if ks, ok := k.(string); ok {
+				m[ks] = v
+			} else {
+				m[fmt.Sprint(k)] = v
+			}
+		}
+		return m, nil
+	case 6:
+		return d.item(depth + 1)
+	}
+
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return float16(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	}
+	if info < 24 || info == 24 {
+		return arg, nil // unassigned simple value
+	}
+	return nil, fmt.Errorf("unexpected break")
+}
+
+func (d *cborDecoder) str(major byte, indefinite bool, arg uint64) ([]byte, error) {
+	if !indefinite {
+		if arg > uint64(len(d.b)) {
+			return nil, fmt.Errorf("string longer than data")
+		}
+		return d.next(int(arg))
+	}
+
+	s := []byte{}
+	for !d.isBreak() {
+		m, info, arg, err := d.header()
+		if err != nil {
+			return nil, err
+		}
+		if m != major || info == 31 {
+			return nil, fmt.Errorf("invalid indefinite length string chunk")
+		}
+		chunk, err := d.str(major, false, arg)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, chunk...)
+	}
+	return s, nil
+}
+
+func float16(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var v float64
+	switch exp {
+	case 0:
+		v = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			v = math.Inf(1)
+		} else {
+			v = math.NaN()
+		}
+	default:
+		v = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,893 @@
+package flowbro
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// celProgram is a compiled expression in the subset of Google's Common
+// Expression Language that flowbro understands: literals, lists and maps,
+// field selection and indexing, arithmetic, comparisons, `in`, logical
+// operators, the ternary operator, has() and the size, contains, startsWith,
+// endsWith, matches, int, double and string functions. Expressions are
+// compiled when the config loads, so unknown variables, unknown functions and
+// invalid regexes are reported right away.
+type celProgram struct {
+	src  string
+	root celNode
+}
+
+// celVariables are the names a message exposes to expressions.
+var celVariables = map[string]bool{
+	"topic":     true,
+	"partition": true,
+	"offset":    true,
+	"key":       true,
+	"keyValue":  true,
+	"value":     true,
+	"tags":      true,
+	"timestamp": true,
+	"cluster":   true,
+	"view":      true,
+}
+
+// celFunctions maps each function to its argument count, receiver included
+// for functions called as methods.
+var celFunctions = map[string]int{
+	"size":       1,
+	"contains":   2,
+	"startsWith": 2,
+	"endsWith":   2,
+	"matches":    2,
+	"int":        1,
+	"double":     1,
+	"string":     1,
+}
+
+func compileCEL(src string) (*celProgram, error) {
+	tokens, err := celTokens(src)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid expression %q. err=%v", src, err)
+	}
+	p := &celParser{tokens: tokens}
+	root, err := p.expr()
+	if err == nil && p.i < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.i].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid expression %q. err=%v", src, err)
+	}
+	return &celProgram{src: src, root: root}, nil
+}
+
+// match evaluates the program against m. Evaluation errors, such as
+// selecting a field the message doesn't have, are returned as in CEL, where
+// has() guards against them.
+func (p *celProgram) match(m message) (bool, error) {
+	v, err := p.root.eval(celActivation(m))
+	if err != nil {
+		return false, fmt.Errorf("Could not evaluate expression %q. err=%v", p.src, err)
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("Expression %q evaluated to %v instead of a bool", p.src, v)
+	}
+	return b, nil
+}
+
+// eval evaluates the program against m, returning its result.
+func (p *celProgram) eval(m message) (interface{}, error) {
+	return p.root.eval(celActivation(m))
+}
+
+func celActivation(m message) map[string]interface{} {
+	tags := map[string]interface{}{}
+	for k, v := range m.Tags {
+		tags[k] = v
+	}
+	value := map[string]interface{}{}
+	if m.Value != nil {
+		value = m.Value
+	}
+	return map[string]interface{}{
+		"topic":     m.Topic,
+		"partition": int64(m.Partition),
+		"offset":    m.Offset,
+		"key":       m.Key,
+		"keyValue":  m.KeyValue,
+		"value":     value,
+		"tags":      tags,
+		"timestamp": m.Timestamp,
+		"cluster":   m.Cluster,
+		"view":      m.View,
+	}
+}
+
+type celToken struct {
+	kind string // ident, number, string or op
+	text string
+	val  interface{}
+}
+
+func celTokens(src string) ([]celToken, error) {
+	tokens := []celToken{}
+	rs := []rune(src)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, celToken{kind: "ident", text: string(rs[i:j])})
+			i = j - 1
+		case unicode.IsDigit(r):
+			j, float := i, false
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.' || rs[j] == 'e' || rs[j] == 'E' ||
+				((rs[j] == '-' || rs[j] == '+') && (rs[j-1] == 'e' || rs[j-1] == 'E'))) {
+				float = float || !unicode.IsDigit(rs[j])
+				j++
+			}
+			text := string(rs[i:j])
+			var val interface{}
+			var err error
+			if float {
+				val, err = strconv.ParseFloat(text, 64)
+			} else {
+				val, err = strconv.ParseInt(text, 0, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("bad number %v", text)
+			}
+			tokens = append(tokens, celToken{kind: "number", text: text, val: val})
+			i = j - 1
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(rs) && rs[j] != r {
+				if rs[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			s, err := celUnquote(rs[i+1 : j])
+			if err != nil {
+				return nil, fmt.Errorf("bad string %v", string(rs[i:j+1]))
+			}
+			tokens = append(tokens, celToken{kind: "string", text: string(rs[i : j+1]), val: s})
+			i = j
+		default:
+			op := string(r)
+			if i+1 < len(rs) {
+				switch two := string(rs[i : i+2]); two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					op = two
+				}
+			}
+			if !celOperators[op] {
+				return nil, fmt.Errorf("unexpected %q", op)
+			}
+			tokens = append(tokens, celToken{kind: "op", text: op})
+			i += len(op) - 1
+		}
+	}
+	return tokens, nil
+}
+
+// celUnquote returns the string literal whose content, between its quotes,
+// is rs, undoing the escapes of Go's double-quoted strings and \', which CEL
+// allows in either kind of quotes.
+func celUnquote(rs []rune) (string, error) {
+	quoted := []rune{'"'}
+	for i := 0; i < len(rs); i++ {
+		switch {
+		case rs[i] == '\\' && i+1 < len(rs) && rs[i+1] == '\'':
+			quoted = append(quoted, '\'')
+			i++
+		case rs[i] == '\\' && i+1 < len(rs):
+			quoted = append(quoted, rs[i], rs[i+1])
+			i++
+		case rs[i] == '"':
+			quoted = append(quoted, '\\', '"')
+		default:
+			quoted = append(quoted, rs[i])
+		}
+	}
+	return strconv.Unquote(string(append(quoted, '"')))
+}
+
+var celOperators = map[string]bool{
+	"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "&&": true, "||": true, "!": true,
+	"+": true, "-": true, "*": true, "/": true, "%": true,
+	"(": true, ")": true, "[": true, "]": true, "{": true, "}": true, ".": true, ",": true, "?": true, ":": true,
+}
+
+type celParser struct {
+	tokens []celToken
+	i      int
+}
+
+func (p *celParser) peek() string {
+	if p.i < len(p.tokens) && (p.tokens[p.i].kind == "op" || p.tokens[p.i].text == "in") {
+		return p.tokens[p.i].text
+	}
+	return ""
+}
+
+func (p *celParser) expect(op string) error {
+	if p.peek() != op {
+		if p.i < len(p.tokens) {
+			return fmt.Errorf("expected %q but got %q", op, p.tokens[p.i].text)
+		}
+		return fmt.Errorf("expected %q at end of expression", op)
+	}
+	p.i++
+	return nil
+}
+
+func (p *celParser) expr() (celNode, error) {
+	cond, err := p.binary(0)
+	if err != nil || p.peek() != "?" {
+		return cond, err
+	}
+	p.i++
+	then, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return celTernary{cond, then, otherwise}, nil
+}
+
+// celPrecedence lists binary operators from loosest to tightest binding.
+var celPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// celComparisons is the level of celPrecedence whose operators don't chain:
+// a < b < c compares a bool with c, so it's rejected rather than parsed as
+// (a < b) < c.
+const celComparisons = 2
+
+func (p *celParser) binary(level int) (celNode, error) {
+	if level == len(celPrecedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; ; i++ {
+		op, found := p.peek(), false
+		for _, o := range celPrecedence[level] {
+			found = found || o == op
+		}
+		if !found || op == "" {
+			return left, nil
+		}
+		if level == celComparisons && i > 0 {
+			return nil, fmt.Errorf("comparisons don't chain; please join them with &&, e.g. a < b && b < c")
+		}
+		p.i++
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = celBinary{op, left, right}
+	}
+}
+
+func (p *celParser) unary() (celNode, error) {
+	switch op := p.peek(); op {
+	case "!", "-":
+		p.i++
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return celUnary{op, x}, nil
+	}
+	return p.member()
+}
+
+func (p *celParser) member() (celNode, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case ".":
+			p.i++
+			if p.i >= len(p.tokens) || p.tokens[p.i].kind != "ident" {
+				return nil, fmt.Errorf("expected a field name after .")
+			}
+			name := p.tokens[p.i].text
+			p.i++
+			if p.peek() != "(" {
+				n = celSelect{n, name}
+				continue
+			}
+			args, err := p.args(")")
+			if err != nil {
+				return nil, err
+			}
+			if n, err = newCELCall(name, append([]celNode{n}, args...)); err != nil {
+				return nil, err
+			}
+		case "[":
+			p.i++
+			index, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = celIndex{n, index}
+		default:
+			return n, nil
+		}
+	}
+}
+
+// args parses a comma separated list of expressions up to the closing
+// token, the opening one having been peeked at.
+func (p *celParser) args(closing string) ([]celNode, error) {
+	p.i++
+	args := []celNode{}
+	for p.peek() != closing {
+		a, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, a)
+		if p.peek() != "," {
+			break
+		}
+		p.i++
+	}
+	return args, p.expect(closing)
+}
+
+func (p *celParser) primary() (celNode, error) {
+	if p.i >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.i]
+	switch t.kind {
+	case "number", "string":
+		p.i++
+		return celLiteral{t.val}, nil
+	case "ident":
+		p.i++
+		switch t.text {
+		case "true", "false":
+			return celLiteral{t.text == "true"}, nil
+		case "null":
+			return celLiteral{nil}, nil
+		case "has":
+			args, err := p.args(")")
+			if err != nil {
+				return nil, err
+			}
+			if len(args) != 1 {
+				return nil, fmt.Errorf("has() takes a single field selection")
+			}
+			sel, ok := args[0].(celSelect)
+			if !ok {
+				return nil, fmt.Errorf("has() takes a field selection such as has(value.id)")
+			}
+			return celHas{sel}, nil
+		}
+		if p.peek() == "(" {
+			args, err := p.args(")")
+			if err != nil {
+				return nil, err
+			}
+			return newCELCall(t.text, args)
+		}
+		if !celVariables[t.text] {
+			return nil, fmt.Errorf("undeclared reference to %q", t.text)
+		}
+		return celIdent{t.text}, nil
+	}
+
+	switch t.text {
+	case "(":
+		p.i++
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case "[":
+		elems, err := p.args("]")
+		if err != nil {
+			return nil, err
+		}
+		return celList{elems}, nil
+	case "{":
+		p.i++
+		m := celMap{}
+		for p.peek() != "}" {
+			k, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			v, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			m.keys, m.values = append(m.keys, k), append(m.values, v)
+			if p.peek() != "," {
+				break
+			}
+			p.i++
+		}
+		return m, p.expect("}")
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+func newCELCall(fn string, args []celNode) (celNode, error) {
+	arity, ok := celFunctions[fn]
+	if !ok {
+		return nil, fmt.Errorf("undeclared function %q", fn)
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("%v() takes %v arguments but got %v", fn, arity, len(args))
+	}
+	c := celCall{fn: fn, args: args}
+	if lit, ok := args[len(args)-1].(celLiteral); ok && fn == "matches" {
+		s, ok := lit.v.(string)
+		if !ok {
+			return nil, fmt.Errorf("matches() takes a string regex")
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q. err=%v", s, err)
+		}
+		c.re = re
+	}
+	return c, nil
+}
+
+type celNode interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type celLiteral struct{ v interface{} }
+
+type celIdent struct{ name string }
+
+type celSelect struct {
+	operand celNode
+	field   string
+}
+
+type celHas struct{ sel celSelect }
+
+type celIndex struct{ operand, index celNode }
+
+type celUnary struct {
+	op string
+	x  celNode
+}
+
+type celBinary struct {
+	op          string
+	left, right celNode
+}
+
+type celTernary struct{ cond, then, otherwise celNode }
+
+type celList struct{ elems []celNode }
+
+type celMap struct{ keys, values []celNode }
+
+type celCall struct {
+	fn   string
+	args []celNode
+	re   *regexp.Regexp
+}
+
+func (n celLiteral) eval(vars map[string]interface{}) (interface{}, error) {
+	return n.v, nil
+}
+
+func (n celIdent) eval(vars map[string]interface{}) (interface{}, error) {
+	return vars[n.name], nil
+}
+
+func (n celSelect) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot select %v from %v", n.field, v)
+	}
+	f, ok := m[n.field]
+	if !ok {
+		return nil, fmt.Errorf("no such key %v", n.field)
+	}
+	return f, nil
+}
+
+func (n celHas) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.sel.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+	_, ok = m[n.sel.field]
+	return ok, nil
+}
+
+func (n celIndex) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	i, err := n.index.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch t := v.(type) {
+	case map[string]interface{}:
+		f, ok := t[fmt.Sprint(i)]
+		if !ok {
+			return nil, fmt.Errorf("no such key %v", i)
+		}
+		return f, nil
+	case []interface{}:
+		f, ok := celNumber(i)
+		if !ok || f < 0 || int(f) >= len(t) {
+			return nil, fmt.Errorf("index %v out of range", i)
+		}
+		return t[int(f)], nil
+	}
+	return nil, fmt.Errorf("cannot index %v", v)
+}
+
+func (n celUnary) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.x.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	switch t := v.(type) {
+	case bool:
+		if n.op == "!" {
+			return !t, nil
+		}
+	case int64:
+		if n.op == "-" {
+			return -t, nil
+		}
+	case float64:
+		if n.op == "-" {
+			return -t, nil
+		}
+	}
+	return nil, fmt.Errorf("no such overload %v%v", n.op, v)
+}
+
+func (n celTernary) eval(vars map[string]interface{}) (interface{}, error) {
+	c, err := n.cond.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := c.(bool)
+	if !ok {
+		return nil, fmt.Errorf("ternary condition %v is not a bool", c)
+	}
+	if b {
+		return n.then.eval(vars)
+	}
+	return n.otherwise.eval(vars)
+}
+
+func (n celList) eval(vars map[string]interface{}) (interface{}, error) {
+	l := []interface{}{}
+	for _, e := range n.elems {
+		v, err := e.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, v)
+	}
+	return l, nil
+}
+
+func (n celMap) eval(vars map[string]interface{}) (interface{}, error) {
+	m := map[string]interface{}{}
+	for i := range n.keys {
+		k, err := n.keys[i].eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		v, err := n.values[i].eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		m[fmt.Sprint(k)] = v
+	}
+	return m, nil
+}
+
+func (n celBinary) eval(vars map[string]interface{}) (interface{}, error) {
+	l, lerr := n.left.eval(vars)
+	if n.op == "&&" || n.op == "||" {
+		// like CEL, a false (or true) side wins over an error on the other side
+		short := n.op == "||"
+		if b, ok := l.(bool); lerr == nil && ok && b == short {
+			return short, nil
+		}
+		r, rerr := n.right.eval(vars)
+		if b, ok := r.(bool); rerr == nil && ok && b == short {
+			return short, nil
+		}
+		if lerr != nil {
+			return nil, lerr
+		}
+		if rerr != nil {
+			return nil, rerr
+		}
+		_, lok := l.(bool)
+		_, rok := r.(bool)
+		if !lok || !rok {
+			return nil, fmt.Errorf("no such overload %v %v %v", l, n.op, r)
+		}
+		return !short, nil
+	}
+	if lerr != nil {
+		return nil, lerr
+	}
+	r, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return celEqual(l, r), nil
+	case "!=":
+		return !celEqual(l, r), nil
+	case "in":
+		switch t := r.(type) {
+		case []interface{}:
+			for _, e := range t {
+				if celEqual(l, e) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			_, ok := t[fmt.Sprint(l)]
+			return ok, nil
+		}
+	case "<", "<=", ">", ">=":
+		c, err := celCompare(l, r)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "<":
+			return c < 0, nil
+		case "<=":
+			return c <= 0, nil
+		case ">":
+			return c > 0, nil
+		}
+		return c >= 0, nil
+	case "+":
+		switch lt := l.(type) {
+		case string:
+			if rt, ok := r.(string); ok {
+				return lt + rt, nil
+			}
+		case []interface{}:
+			if rt, ok := r.([]interface{}); ok {
+				return append(append([]interface{}{}, lt...), rt...), nil
+			}
+		}
+		return celArithmetic(n.op, l, r)
+	case "-", "*", "/", "%":
+		return celArithmetic(n.op, l, r)
+	}
+	return nil, fmt.Errorf("no such overload %v %v %v", l, n.op, r)
+}
+
+func (n celCall) eval(vars map[string]interface{}) (interface{}, error) {
+	args := []interface{}{}
+	for _, a := range n.args {
+		v, err := a.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	switch n.fn {
+	case "size":
+		switch t := args[0].(type) {
+		case string:
+			return int64(len([]rune(t))), nil
+		case []interface{}:
+			return int64(len(t)), nil
+		case map[string]interface{}:
+			return int64(len(t)), nil
+		}
+	case "int":
+		switch t := args[0].(type) {
+		case string:
+			return strconv.ParseInt(t, 10, 64)
+		case time.Time:
+			return t.Unix(), nil
+		}
+		if f, ok := celNumber(args[0]); ok {
+			return int64(f), nil
+		}
+	case "double":
+		if s, ok := args[0].(string); ok {
+			return strconv.ParseFloat(s, 64)
+		}
+		if f, ok := celNumber(args[0]); ok {
+			return f, nil
+		}
+	case "string":
+		if t, ok := args[0].(time.Time); ok {
+			return t.Format(time.RFC3339Nano), nil
+		}
+		return fmt.Sprint(args[0]), nil
+	default:
+		s, ok := args[0].(string)
+		arg, argOk := args[1].(string)
+		if !ok || !argOk {
+			break
+		}
+		switch n.fn {
+		case "contains":
+			return strings.Contains(s, arg), nil
+		case "startsWith":
+			return strings.HasPrefix(s, arg), nil
+		case "endsWith":
+			return strings.HasSuffix(s, arg), nil
+		case "matches":
+			re := n.re
+			if re == nil {
+				var err error
+				if re, err = regexp.Compile(arg); err != nil {
+					return nil, err
+				}
+			}
+			return re.MatchString(s), nil
+		}
+	}
+	return nil, fmt.Errorf("no such overload %v%v", n.fn, args)
+}
+
+// celNumber widens the numeric types found in messages, decoded JSON values
+// being float64 while CEL integer literals are int64.
+func celNumber(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case int64:
+		return float64(t), true
+	case int32:
+		return float64(t), true
+	case int:
+		return float64(t), true
+	case uint64:
+		return float64(t), true
+	case float64:
+		return t, true
+	}
+	return 0, false
+}
+
+func celEqual(l, r interface{}) bool {
+	lf, lok := celNumber(l)
+	rf, rok := celNumber(r)
+	if lok && rok {
+		return lf == rf
+	}
+	return reflect.DeepEqual(l, r)
+}
+
+func celCompare(l, r interface{}) (int, error) {
+	lf, lok := celNumber(l)
+	rf, rok := celNumber(r)
+	switch {
+	case lok && rok:
+		switch {
+		case lf < rf:
+			return -1, nil
+		case lf > rf:
+			return 1, nil
+		}
+		return 0, nil
+	}
+	if ls, ok := l.(string); ok {
+		if rs, ok := r.(string); ok {
+			return strings.Compare(ls, rs), nil
+		}
+	}
+	if lt, ok := l.(time.Time); ok {
+		if rt, ok := r.(time.Time); ok {
+			switch {
+			case lt.Before(rt):
+				return -1, nil
+			case lt.After(rt):
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot compare %v with %v", l, r)
+}
+
+func celArithmetic(op string, l, r interface{}) (interface{}, error) {
+	li, lint := l.(int64)
+	ri, rint := r.(int64)
+	if lint && rint {
+		switch op {
+		case "+":
+			return li + ri, nil
+		case "-":
+			return li - ri, nil
+		case "*":
+			return li * ri, nil
+		case "/", "%":
+			if ri == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				return li / ri, nil
+			}
+			return li % ri, nil
+		}
+	}
+	lf, lok := celNumber(l)
+	rf, rok := celNumber(r)
+	if !lok || !rok {
+		return nil, fmt.Errorf("no such overload %v %v %v", l, op, r)
+	}
+	switch op {
+	case "+":
+		return lf + rf, nil
+	case "-":
+		return lf - rf, nil
+	case "*":
+		return lf * rf, nil
+	case "/":
+		return lf / rf, nil
+	}
+	return nil, fmt.Errorf("no such overload %v %% %v", l, r)
+}
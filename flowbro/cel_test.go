@@ -0,0 +1,100 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCELMatch(t *testing.T) {
+	m := message{
+		Key:       "order-1",
+		Value:     newValueFrom(`{"status":"PAID","amount":42.5,"items":[{"sku":"a"},{"sku":"b"}],"meta":{"retries":0}}`),
+		Topic:     "orders",
+		Partition: 3,
+		Offset:    100,
+		Timestamp: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+		Tags:      map[string]string{"region": "eu"},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected bool
+		fails    bool
+	}{
+		{name: "string equality", expr: `value.status == "PAID"`, expected: true},
+		{name: "json numbers compare with int literals", expr: `value.amount > 40 && value.amount < 50`, expected: true},
+		{name: "int variables", expr: `partition == 3 && offset >= 100`, expected: true},
+		{name: "in list", expr: `topic in ['orders', 'payments']`, expected: true},
+		{name: "in map", expr: `'region' in tags`, expected: true},
+		{name: "methods", expr: `key.startsWith("order-") && !topic.contains("dlq")`, expected: true},
+		{name: "matches", expr: `key.matches("^order-[0-9]+$")`, expected: true},
+		{name: "size and indexing", expr: `size(value.items) == 2 && value.items[1].sku == "b"`, expected: true},
+		{name: "has", expr: `has(value.meta.retries) && !has(value.error)`, expected: true},
+		{name: "missing fields fail", expr: `value.error.code == 500`, fails: true},
+		{name: "false wins over errors", expr: `value.error.code == 500 || tags.region == "eu"`, expected: true},
+		{name: "ternary and arithmetic", expr: `(value.amount > 100 ? "big" : "small") == "small" && offset % 7 == 2`, expected: true},
+		{name: "conversions", expr: `string(partition) + "/" + string(offset) == "3/100" && int("7") * 2 == 14`, expected: true},
+		{name: "timestamps", expr: `int(timestamp) == 1483228800`, expected: true},
+		{name: "single-quoted strings", expr: `'say "hi"' + 'it\'s' + 'a\"b' == "say \"hi\"it's" + 'a"b'`, expected: true},
+		{name: "escapes in either quotes", expr: `"it\'s\t" == 'it\'s\t' && size('\\') == 1`, expected: true},
+	}
+
+	for _, ts := range tests {
+		p, err := compileCEL(ts.expr)
+		if err != nil {
+			t.Errorf("on '%v': shouldn't have failed compiling, but did with %v", ts.name, err)
+			continue
+		}
+		actual, err := p.match(m)
+		if ts.fails != (err != nil) {
+			t.Errorf("on '%v': expected failure to be %v, but err was %v", ts.name, ts.fails, err)
+		}
+		if actual != ts.expected {
+			t.Errorf("on '%v': expected %v but got %v", ts.name, ts.expected, actual)
+		}
+	}
+}
+
+func TestCELCompileErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{name: "unknown variable", expr: `vaule.status == "PAID"`},
+		{name: "unknown function", expr: `value.status.startswith("P")`},
+		{name: "wrong argument count", expr: `size(key, topic)`},
+		{name: "invalid regex", expr: `key.matches("(")`},
+		{name: "unbalanced parens", expr: `(topic == "orders"`},
+		{name: "single =", expr: `topic = "orders"`},
+		{name: "trailing tokens", expr: `topic "orders"`},
+		{name: "chained comparisons", expr: `1 < offset < 200`},
+		{name: "chained equality", expr: `topic == "orders" == true`},
+	}
+
+	for _, ts := range tests {
+		if _, err := compileCEL(ts.expr); err == nil {
+			t.Errorf("on '%v': expected compiling %v to fail", ts.name, ts.expr)
+		}
+	}
+}
+
+func TestConfigRejectsInvalidExpressions(t *testing.T) {
+	configs := []configJSON{
+		{Rules: []rule{{Expr: `topc == "orders"`}}},
+		{Kafka: kafka{Filter: `value.x ==`}},
+		{Alerts: []alertJSON{{Name: "a", Expr: `nope()`}}},
+	}
+	for i, c := range configs {
+		if _, err := processConfig(&c); err == nil {
+			t.Errorf("on config %v: expected processConfig to fail", i)
+		}
+	}
+}
+
+func TestNonBoolExpressionsFail(t *testing.T) {
+	p, _ := compileCEL(`value.amount + 1`)
+	if _, err := p.match(message{Value: newValueFrom(`{"amount":1}`)}); err == nil {
+		t.Errorf("expected a non-bool expression to fail")
+	}
+}
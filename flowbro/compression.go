@@ -0,0 +1,141 @@
+package flowbro
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	xerial "github.com/eapache/go-xerial-snappy"
+	"github.com/golang/snappy"
+	"github.com/pierrec/lz4"
+)
+
+var compressions = map[string]bool{"": true, "auto": true, "none": true, "gzip": true, "zlib": true, "snappy": true, "lz4": true}
+
+var (
+	snappyFramedMagic = []byte("\xff\x06\x00\x00sNaPpY")
+	snappyXerialMagic = []byte{130, 83, 78, 65, 80, 80, 89, 0}
+	lz4FrameMagic     = []byte{0x04, 0x22, 0x4d, 0x18}
+)
+
+// defaultMaxDecompressed is how big a value may get once decompressed,
+// unless its decoder sets maxDecompressedBytes, so that a small but highly
+// compressed message can't exhaust flowbro's memory.
+const defaultMaxDecompressed = 16 << 20
+
+// decompressedTooLarge is the error of values that would decompress to more
+// than the bytes it holds.
+type decompressedTooLarge int64
+
+func (e decompressedTooLarge) Error() string {
+	return fmt.Sprintf("Value decompresses to more than %v bytes; please raise the decoder's maxDecompressedBytes if that's expected", int64(e))
+}
+
+// decompress undoes application-level compression on a message value, to
+// at most max bytes (defaultMaxDecompressed if max isn't positive). With
+// "auto" (the default) the codec is sniffed from the value's magic bytes, and
+// values that don't look compressed, or that the sniffed codec can't decode,
+// are returned untouched: a plain value may start like a zlib header. Values
+// decompressing to more than max fail either way.
+func decompress(b []byte, compression string, max int64) ([]byte, error) {
+	if max <= 0 {
+		max = defaultMaxDecompressed
+	}
+	if compression == "" || compression == "auto" {
+		sniffed := sniffCompression(b)
+		v, err := decompress(b, sniffed, max)
+		if _, tooLarge := err.(decompressedTooLarge); err != nil && !tooLarge {
+			return b, nil
+		}
+		return v, err
+	}
+
+	switch compression {
+	case "none":
+		return b, nil
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("Could not gunzip value. err=%v", err)
+		}
+		defer r.Close()
+		return readAtMost(r, max)
+	case "zlib":
+		r, err := zlib.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, fmt.Errorf("Could not inflate zlib value. err=%v", err)
+		}
+		defer r.Close()
+		return readAtMost(r, max)
+	case "snappy":
+		if bytes.HasPrefix(b, snappyFramedMagic) {
+			v, err := readAtMost(snappy.NewReader(bytes.NewReader(b)), max)
+			if _, tooLarge := err.(decompressedTooLarge); err != nil && !tooLarge {
+				return nil, fmt.Errorf("Could not decode framed snappy value. err=%v", err)
+			}
+			return v, err
+		}
+		decode := func(b []byte) ([]byte, error) {
+			if n, err := snappy.DecodedLen(b); err == nil && int64(n) > max {
+				return nil, decompressedTooLarge(max)
+			}
+			return snappy.Decode(nil, b)
+		}
+		if bytes.HasPrefix(b, snappyXerialMagic) {
+			decode = xerial.Decode
+		}
+		v, err := decode(b)
+		if _, tooLarge := err.(decompressedTooLarge); tooLarge {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Could not decode snappy value. err=%v", err)
+		}
+		if int64(len(v)) > max {
+			return nil, decompressedTooLarge(max)
+		}
+		return v, nil
+	case "lz4":
+		v, err := readAtMost(lz4.NewReader(bytes.NewReader(b)), max)
+		if _, tooLarge := err.(decompressedTooLarge); err != nil && !tooLarge {
+			return nil, fmt.Errorf("Could not decode lz4 frame. err=%v", err)
+		}
+		return v, err
+	}
+	return nil, fmt.Errorf("Unknown compression %v", compression)
+}
+
+// readAtMost reads r to its end, failing if it holds more than max bytes.
+func readAtMost(r io.Reader, max int64) ([]byte, error) {
+	v, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(v)) > max {
+		return nil, decompressedTooLarge(max)
+	}
+	return v, nil
+}
+
+func sniffCompression(b []byte) string {
+	if len(b) < 2 {
+		return "none"
+	}
+	if b[0] == 0x1f && b[1] == 0x8b {
+		return "gzip"
+	}
+	if bytes.HasPrefix(b, snappyFramedMagic) || bytes.HasPrefix(b, snappyXerialMagic) {
+		return "snappy"
+	}
+	if bytes.HasPrefix(b, lz4FrameMagic) {
+		return "lz4"
+	}
+	// zlib: deflate method with a header checksum divisible by 31
+	if b[0]&0x0f == 8 && b[0]>>4 <= 7 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0 {
+		return "zlib"
+	}
+	return "none"
+}
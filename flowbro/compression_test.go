@@ -0,0 +1,98 @@
+package flowbro
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/pierrec/lz4"
+)
+
+func TestDecompress(t *testing.T) {
+	var gz, zl bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(`{"a":1}`))
+	gw.Close()
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte(`{"a":1}`))
+	zw.Close()
+	var sf, lz bytes.Buffer
+	sw := snappy.NewWriter(&sf)
+	sw.Write([]byte(`{"a":1}`))
+	sw.Close()
+	lw := lz4.NewWriter(&lz)
+	lw.Write([]byte(`{"a":1}`))
+	lw.Close()
+
+	tests := []struct {
+		name        string
+		value       []byte
+		compression string
+		expected    string
+		fails       bool
+	}{
+		{name: "plain json sniffed", value: []byte(`{"a":1}`), compression: "auto", expected: `{"a":1}`},
+		{name: "gzip sniffed", value: gz.Bytes(), compression: "", expected: `{"a":1}`},
+		{name: "zlib sniffed", value: zl.Bytes(), compression: "auto", expected: `{"a":1}`},
+		{name: "framed snappy sniffed", value: sf.Bytes(), compression: "auto", expected: `{"a":1}`},
+		{name: "raw snappy configured", value: snappy.Encode(nil, []byte(`{"a":1}`)), compression: "snappy", expected: `{"a":1}`},
+		{name: "lz4 sniffed", value: lz.Bytes(), compression: "auto", expected: `{"a":1}`},
+		{name: "plain text sniffed as zlib", value: []byte("x marks the spot"), compression: "auto", expected: "x marks the spot"},
+		{name: "plain text sniffed as gzip", value: []byte("\x1f\x8b not really"), compression: "", expected: "\x1f\x8b not really"},
+		{name: "gzip configured", value: gz.Bytes(), compression: "gzip", expected: `{"a":1}`},
+		{name: "none configured", value: gz.Bytes(), compression: "none", expected: string(gz.Bytes())},
+		{name: "gzip configured on plain value", value: []byte(`{"a":1}`), compression: "gzip", fails: true},
+	}
+
+	for _, ts := range tests {
+		actual, err := decompress(ts.value, ts.compression, 0)
+		if ts.fails != (err != nil) {
+			t.Errorf("'%v' expected failure to be %v, but err was %v", ts.name, ts.fails, err)
+			continue
+		}
+		if !ts.fails && string(actual) != ts.expected {
+			t.Errorf("on '%v': expected %q but got %q", ts.name, ts.expected, actual)
+		}
+	}
+}
+
+func TestDecompressStopsAtTheLimit(t *testing.T) {
+	zeros := make([]byte, 1<<20)
+	var gz, zl, lz bytes.Buffer
+	gw, zw, lw := gzip.NewWriter(&gz), zlib.NewWriter(&zl), lz4.NewWriter(&lz)
+	for i := 0; i < 4; i++ {
+		gw.Write(zeros)
+		zw.Write(zeros)
+		lw.Write(zeros)
+	}
+	gw.Close()
+	zw.Close()
+	lw.Close()
+	sf := snappy.Encode(nil, make([]byte, 4<<20))
+
+	tests := []struct {
+		name        string
+		value       []byte
+		compression string
+	}{
+		{"gzip sniffed", gz.Bytes(), "auto"},
+		{"gzip configured", gz.Bytes(), "gzip"},
+		{"zlib sniffed", zl.Bytes(), ""},
+		{"lz4 sniffed", lz.Bytes(), "auto"},
+		{"lz4 configured", lz.Bytes(), "lz4"},
+		{"raw snappy configured", sf, "snappy"},
+	}
+	for _, ts := range tests {
+		if len(ts.value)*16 > 4<<20 {
+			t.Fatalf("on '%v': expected a high compression ratio but the value is %v bytes", ts.name, len(ts.value))
+		}
+		if _, err := decompress(ts.value, ts.compression, 1<<20); err == nil {
+			t.Errorf("on '%v': expected 4MiB of zeros to exceed a 1MiB limit", ts.name)
+		}
+		if v, err := decompress(ts.value, ts.compression, 8<<20); err != nil || len(v) != 4<<20 {
+			t.Errorf("on '%v': expected 4MiB of zeros within an 8MiB limit but got %v bytes, err=%v", ts.name, len(v), err)
+		}
+	}
+}
@@ -0,0 +1,521 @@
+package flowbro
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type consumerConfigJson struct {
+	Brokers         string  `json:"brokers,omitempty"`
+	Cluster         string  `json:"cluster,omitempty"`
+	Partition       *int    `json:"partition,omitempty"`
+	Topic           string  `json:"topic"`
+	Offset          string  `json:"offset,omitempty"`
+	BookieCountOnly bool    `json:"bookieCountOnly,omitempty"`
+	Compression     string  `json:"compression,omitempty"`
+	Format          string  `json:"format,omitempty"`
+	KeyFormat       string  `json:"keyFormat,omitempty"`
+	MaxPerSecond    float64 `json:"maxPerSecond,omitempty"`
+	Table           bool    `json:"table,omitempty"`
+	ClientId        string  `json:"clientId,omitempty"`
+	View            string  `json:"view,omitempty"`
+	TimestampType   string  `json:"timestampType,omitempty"`
+}
+
+type clusterJSON struct {
+	Alias      string          `json:"alias"`
+	Brokers    string          `json:"brokers"`
+	Connection *connectionJSON `json:"connection,omitempty"`
+	Version    string          `json:"version,omitempty"`
+}
+
+type kafka struct {
+	Brokers      string               `json:"brokers,omitempty"`
+	Alias        string               `json:"alias,omitempty"`
+	Clusters     []clusterJSON        `json:"clusters,omitempty"`
+	Consumers    []consumerConfigJson `json:"consumers"`
+	Grep         string               `json:"grep"`
+	Offset       string               `json:"offset"`
+	Filter       string               `json:"filter"`
+	MaxPerSecond float64              `json:"maxPerSecond"`
+	CatchUp      *catchUpJSON         `json:"catchUp"`
+	Mirrors      []mirrorJSON         `json:"mirrors"`
+	Stats        *statsJSON           `json:"stats"`
+	Gaps         *gapsJSON            `json:"gaps"`
+	Connection   *connectionJSON      `json:"connection"`
+	ClientId     string               `json:"clientId"`
+	Version      string               `json:"version"`
+	RestProxy    *restProxyJSON       `json:"restProxy"`
+	Internal     *internalTopicsJSON  `json:"internal"`
+}
+
+type event struct {
+	EventType     string                   `json:"eventType"`
+	SourceId      string                   `json:"sourceId"`
+	TargetId      string                   `json:"targetId"`
+	Text          string                   `json:"text"`
+	FSMId         string                   `json:"fsmId"`
+	FSMIdAlias    string                   `json:"fsmIdAlias"`
+	JSON          []map[string]interface{} `json:"json"`
+	Aggregate     bool                     `json:"aggregate"`
+	Color         string                   `json:"color"`
+	Count         int64                    `json:"count"`
+	NoJSON        bool                     `json:"noJSON,omitempty"`
+	Highlight     bool                     `json:"highlight,omitempty"`
+	Key           interface{}              `json:"key,omitempty"`
+	KeyRaw        string                   `json:"keyRaw,omitempty"`
+	Tags          map[string]string        `json:"tags,omitempty"`
+	Cluster       string                   `json:"cluster,omitempty"`
+	Brokers       string                   `json:"brokers,omitempty"`
+	View          string                   `json:"view,omitempty"`
+	Topic         string                   `json:"topic,omitempty"`
+	Partition     *int32                   `json:"partition,omitempty"`
+	Stats         *partitionStats          `json:"stats,omitempty"`
+	Flow          *flowStats               `json:"flow,omitempty"`
+	Latency       *latencyStats            `json:"latency,omitempty"`
+	Rows          []tableRow               `json:"rows,omitempty"`
+	Raw           string                   `json:"raw,omitempty"`
+	LatencyMs     *float64                 `json:"latencyMs,omitempty"`
+	Timestamp     *time.Time               `json:"timestamp,omitempty"`
+	TimestampType string                   `json:"timestampType,omitempty"`
+	ContentType   string                   `json:"contentType,omitempty"`
+	Diff          []valueChange            `json:"diff,omitempty"`
+	Offset        *int64                   `json:"offset,omitempty"`
+	Watch         string                   `json:"watch,omitempty"` // the id of the watch a watchHit is for
+	Query         string                   `json:"query,omitempty"` // the id of the query a queryRow or queryResult is for
+}
+
+type pattern struct {
+	Field   string
+	Pattern string
+}
+
+type rule struct {
+	Patterns []pattern
+	Expr     string
+	Events   []event
+
+	expr *celProgram
+}
+
+type configJSON struct {
+	Rules          []rule              `json:"rules"`
+	Kafka          kafka               `json:"kafka"`
+	FSMId          string              `json:"fsmId"`
+	Config         string              `json:"config"` // the name of the config on the server, whose edits apply to the connection
+	HeartbeatUUID  string              `json:"heartbeatUUID"`
+	Tutorial       bool                `json:"tutorial"`
+	BookieURL      string              `json:"bookieURL"`
+	Alerts         []alertJSON         `json:"alerts"`
+	SchemaRegistry *schemaRegistryJSON `json:"schemaRegistry"`
+	Decoders       []decoderJSON       `json:"decoders"`
+	Scripts        []scriptJSON        `json:"scripts"`
+	Transforms     []transformJSON     `json:"transforms"`
+	Redactions     []redactionJSON     `json:"redactions"`
+	FlowStats      *flowStatsJSON      `json:"flowStats"`
+	Ordering       []orderingJSON      `json:"ordering"`
+	Diffs          []diffJSON          `json:"diffs"`
+	SchemaDrift    *schemaDriftJSON    `json:"schemaDrift"`
+	DeadLetter     *deadLetterJSON     `json:"deadLetter"`
+	RetryTopics    *retryTopicsJSON    `json:"retryTopics"`
+	Pairs          []pairJSON          `json:"pairs"`
+	Discovery      *discoveryJSON      `json:"discovery"`
+	Recording      *recordingJSON      `json:"recording"`
+	Replay         *replayJSON         `json:"replay"`
+	Snapshot       *snapshotJSON       `json:"snapshot"`
+	Joins          []joinJSON          `json:"joins"`
+	Aggregations   []aggregationJSON   `json:"aggregations"`
+	Queries        []queryJSON         `json:"queries"`
+	KafkaSinks     []kafkaSinkJSON     `json:"kafkaSinks"`
+	Webhooks       []webhookJSON       `json:"webhooks"`
+	Influx         *influxJSON         `json:"influx"`
+	Sanitize       *sanitizeJSON       `json:"sanitize"`
+	Splunk         *splunkJSON         `json:"splunk"`
+	Syslog         *syslogJSON         `json:"syslog"`
+	Loki           *lokiJSON           `json:"loki"`
+	Session        string              `json:"session"`
+	Share          string              `json:"share"`
+
+	extraFilter string // added to Kafka.Filter by the server
+	dataDir     string // where files the config names are, set by the server
+	board       string // the board the config is, if any
+}
+
+type consumerConfig struct {
+	cluster      string
+	brokers      []string
+	partition    int
+	topic        string
+	offset       string
+	maxPerSecond float64
+	policy       connectionPolicy
+	clientId     string
+	version      sarama.KafkaVersion
+	view         string
+}
+
+type config struct {
+	consumers       []consumerConfig
+	brokers         []string
+	rules           []rule
+	fsmId           string
+	heartbeatUUID   string
+	bookieCountOnly []string
+	bookieUrl       string
+	tutorial        bool
+	mockPath        string
+	dataDir         string
+	alerts          []alert
+	registry        *schemaRegistry
+	decoders        []decoder
+	decodings       map[string]decoding
+	scriptsJSON     []scriptJSON
+	scripts         []*script
+	filter          *celProgram
+	transforms      []transform
+	redactions      []redaction
+	maxPerSecond    float64
+	catchUpJSON     *catchUpJSON
+	catchUp         *catchUp
+	mirrors         *mirrors
+	statsJSON       *statsJSON
+	flowStatsJSON   *flowStatsJSON
+	gaps            *gapDetector
+	orderings       []*ordering
+	diffs           []*keyDiffer
+	schemaDrift     *schemaDrift
+	deadLetterJSON  *deadLetterJSON
+	deadLetters     *deadLetters
+	retryTopics     *retryTopics
+	pairs           []*pair
+	discovery       *discoveryConfig
+	recordingJSON   *recordingJSON
+	recording       *recording
+	search          *searchIndex
+	replay          *replayJSON
+	replayFilter    *replayFilter
+	tables          map[string]bool
+	snapshot        *snapshotJSON
+	joins           []*join
+	aggregations    []*aggregation
+	queries         queries
+	savedQueries    *savedQueries
+	flowName        string
+	extraFilter     string // on top of the config's kafka filter, e.g. from the command line
+	flows           *flows
+	state           *streamState
+	draining        <-chan struct{} // closed once the server drains before shutting down
+	serverAlerts    <-chan event    // fired by the -alerts config
+	forwarding      <-chan event    // failures of the forwarder forwarding this config
+	sinks           []*kafkaSink
+	webhooks        []*webhook
+	influx          *influxWriter
+	sanitizer       *sanitizer
+	splunk          *splunkSink
+	syslog          *syslogSink
+	loki            *lokiSink
+	session         *namedSession
+	board           string // what's consumed is for, if a board
+	role            role
+	window          *replayWindow
+	restProxy       *restProxy
+
+	heartbeatTimeout time.Duration
+}
+
+// andFilter returns a filter matching what both filters match.
+func andFilter(a, b string) string {
+	switch {
+	case len(a) == 0:
+		return b
+	case len(b) == 0:
+		return a
+	}
+	return fmt.Sprintf("(%v) && (%v)", a, b)
+}
+
+// processRules compiles the exprs of rules.
+func processRules(rules []rule) error {
+	for i, r := range rules {
+		if len(r.Expr) == 0 {
+			continue
+		}
+		expr, err := compileCEL(r.Expr)
+		if err != nil {
+			return fmt.Errorf("Invalid expr for rule %v. err=%v", i, err)
+		}
+		rules[i].expr = expr
+	}
+	return nil
+}
+
+func processConfig(configJSON *configJSON) (*config, error) {
+	config := &config{
+		brokers:         strings.Split(configJSON.Kafka.Brokers, ","),
+		rules:           configJSON.Rules,
+		fsmId:           configJSON.FSMId,
+		flowName:        configJSON.Config,
+		heartbeatUUID:   configJSON.HeartbeatUUID,
+		bookieCountOnly: []string{},
+		decodings:       map[string]decoding{},
+		tables:          map[string]bool{},
+		bookieUrl:       configJSON.BookieURL,
+		tutorial:        configJSON.Tutorial,
+		scriptsJSON:     configJSON.Scripts,
+		maxPerSecond:    configJSON.Kafka.MaxPerSecond,
+		catchUpJSON:     configJSON.Kafka.CatchUp,
+		statsJSON:       configJSON.Kafka.Stats,
+		flowStatsJSON:   configJSON.FlowStats,
+		deadLetterJSON:  configJSON.DeadLetter,
+		recordingJSON:   configJSON.Recording,
+		replay:          configJSON.Replay,
+		snapshot:        configJSON.Snapshot,
+	}
+
+	if err := processRules(config.rules); err != nil {
+		return config, err
+	}
+
+	if len(configJSON.Kafka.Filter) > 0 {
+		filter, err := compileCEL(configJSON.Kafka.Filter)
+		if err != nil {
+			return config, fmt.Errorf("Invalid kafka filter. err=%v", err)
+		}
+		config.filter = filter
+	}
+
+	alerts, err := processAlerts(configJSON.Alerts)
+	if err != nil {
+		return config, err
+	}
+	config.alerts = alerts
+
+	if config.transforms, err = processTransforms(configJSON.Transforms); err != nil {
+		return config, err
+	}
+	if config.redactions, err = processRedactions(configJSON.Redactions); err != nil {
+		return config, err
+	}
+	if config.joins, err = processJoins(configJSON.Joins); err != nil {
+		return config, err
+	}
+	if config.aggregations, err = processAggregations(configJSON.Aggregations); err != nil {
+		return config, err
+	}
+	if config.queries, err = processQueries(configJSON.Queries); err != nil {
+		return config, err
+	}
+	if config.pairs, err = processPairs(configJSON.Pairs); err != nil {
+		return config, err
+	}
+	if config.webhooks, err = processWebhooks(configJSON.Webhooks); err != nil {
+		return config, err
+	}
+	if config.influx, err = processInflux(configJSON.Influx); err != nil {
+		return config, err
+	}
+	if config.sanitizer, err = processSanitize(configJSON.Sanitize); err != nil {
+		return config, err
+	}
+	if config.splunk, err = processSplunk(configJSON.Splunk); err != nil {
+		return config, err
+	}
+	if config.syslog, err = processSyslog(configJSON.Syslog); err != nil {
+		return config, err
+	}
+	if config.loki, err = processLoki(configJSON.Loki); err != nil {
+		return config, err
+	}
+	if configJSON.Replay != nil {
+		if config.replayFilter, err = newReplayFilter(configJSON.Replay.Filter, configJSON.Replay.ProduceTo, nil, config.brokers); err != nil {
+			return config, err
+		}
+	}
+	if config.discovery, err = processDiscovery(configJSON.Discovery); err != nil {
+		return config, err
+	}
+	if config.retryTopics, err = processRetryTopics(configJSON.RetryTopics); err != nil {
+		return config, err
+	}
+	if config.schemaDrift, err = processSchemaDrift(configJSON.SchemaDrift); err != nil {
+		return config, err
+	}
+	if config.orderings, err = processOrderings(configJSON.Ordering); err != nil {
+		return config, err
+	}
+	if config.diffs, err = processDiffs(configJSON.Diffs); err != nil {
+		return config, err
+	}
+	if config.gaps, err = processGaps(configJSON.Kafka.Gaps); err != nil {
+		return config, err
+	}
+	if config.mirrors, err = processMirrors(configJSON.Kafka.Mirrors); err != nil {
+		return config, err
+	}
+
+	if configJSON.SchemaRegistry != nil {
+		if config.registry, err = newSchemaRegistry(*configJSON.SchemaRegistry, configJSON.dataDir); err != nil {
+			return config, err
+		}
+	}
+
+	defaultPolicy, err := processConnection(configJSON.Kafka.Connection, nil)
+	if err != nil {
+		return config, err
+	}
+	if config.restProxy, err = newRestProxy(configJSON.Kafka.RestProxy, defaultPolicy, configJSON.dataDir); err != nil {
+		return config, err
+	}
+	defaultVersion, _, err := processKafkaVersion(configJSON.Kafka.Version)
+	if err != nil {
+		return config, err
+	}
+	versions := map[string]sarama.KafkaVersion{}
+	clusters, policies := map[string][]string{}, map[string]connectionPolicy{}
+	for _, c := range configJSON.Kafka.Clusters {
+		if len(c.Alias) == 0 || len(c.Brokers) == 0 {
+			return config, fmt.Errorf("Please define both alias and brokers for cluster %v", c)
+		}
+		clusters[c.Alias] = strings.Split(c.Brokers, ",")
+		if policies[c.Alias], err = processConnection(configJSON.Kafka.Connection, c.Connection); err != nil {
+			return config, fmt.Errorf("Invalid connection settings for cluster %v. err=%v", c.Alias, err)
+		}
+		versions[c.Alias] = defaultVersion
+		if len(c.Version) > 0 {
+			if versions[c.Alias], _, err = processKafkaVersion(c.Version); err != nil {
+				return config, fmt.Errorf("Invalid version for cluster %v. err=%v", c.Alias, err)
+			}
+		}
+	}
+
+	if config.sinks, err = processKafkaSinks(configJSON.KafkaSinks, clusters, config.brokers); err != nil {
+		return config, err
+	}
+
+	internal, err := processInternalTopics(configJSON.Kafka.Internal)
+	if err != nil {
+		return config, err
+	}
+
+	globalOffset := configJSON.Kafka.Offset
+	for _, consumerJSON := range configJSON.Kafka.Consumers {
+		if consumerJSON.BookieCountOnly {
+			config.bookieCountOnly = append(config.bookieCountOnly, consumerJSON.Topic)
+			continue
+		}
+
+		consumer := consumerConfig{}
+
+		if len(consumerJSON.Topic) == 0 {
+			return config, fmt.Errorf("Please define topic name for your consumer %v", consumerJSON)
+		}
+		if err := internal.check(consumerJSON.Topic); err != nil {
+			return config, err
+		}
+		consumer.topic = consumerJSON.Topic
+		consumer.cluster, consumer.brokers, consumer.policy = configJSON.Kafka.Alias, config.brokers, defaultPolicy
+		consumer.version = defaultVersion
+		switch {
+		case len(consumerJSON.Cluster) > 0:
+			brokers, ok := clusters[consumerJSON.Cluster]
+			if !ok {
+				return config, fmt.Errorf("Unknown cluster %v for consumer of topic %v", consumerJSON.Cluster, consumerJSON.Topic)
+			}
+			consumer.cluster, consumer.brokers, consumer.policy = consumerJSON.Cluster, brokers, policies[consumerJSON.Cluster]
+			consumer.version = versions[consumerJSON.Cluster]
+		case len(consumerJSON.Brokers) > 0:
+			consumer.cluster, consumer.brokers = "", strings.Split(consumerJSON.Brokers, ",")
+		}
+		consumer.maxPerSecond, consumer.view = consumerJSON.MaxPerSecond, consumerJSON.View
+		if consumer.clientId, err = processClientId(consumerJSON.ClientId, configJSON.Kafka.ClientId); err != nil {
+			return config, err
+		}
+
+		if len(consumerJSON.Offset) == 0 {
+			if len(globalOffset) > 0 {
+				consumer.offset = globalOffset
+			} else {
+				consumer.offset = "newest"
+			}
+		} else {
+			consumer.offset = consumerJSON.Offset
+		}
+
+		if len(consumerJSON.Format) > 0 || len(consumerJSON.Compression) > 0 || len(consumerJSON.KeyFormat) > 0 || len(consumerJSON.TimestampType) > 0 {
+			d, err := newDecoder(decoderJSON{Topic: regexp.QuoteMeta(consumerJSON.Topic), Format: consumerJSON.Format, Compression: consumerJSON.Compression, KeyFormat: consumerJSON.KeyFormat, TimestampType: consumerJSON.TimestampType}, config.registry, configJSON.dataDir)
+			if err != nil {
+				return config, err
+			}
+			config.decoders = append(config.decoders, d)
+		}
+
+		if consumerJSON.Table {
+			consumer.offset = "oldest"
+			config.tables[consumerJSON.Topic] = true
+		}
+
+		if consumerJSON.Partition != nil {
+			consumer.partition = *consumerJSON.Partition
+		} else {
+			consumer.partition = -1
+		}
+		config.consumers = append(config.consumers, consumer)
+	}
+
+	if err := checkViews(config.consumers); err != nil {
+		return config, err
+	}
+
+	for _, decoderJSON := range configJSON.Decoders {
+		d, err := newDecoder(decoderJSON, config.registry, configJSON.dataDir)
+		if err != nil {
+			return config, err
+		}
+		config.decoders = append(config.decoders, d)
+	}
+
+	return config, nil
+}
+
+// checkViews fails if consumers of the same cluster read a partition twice
+// in the same view, as only consumers in different views can tell their
+// messages apart.
+func checkViews(consumers []consumerConfig) error {
+	for i, a := range consumers {
+		for _, b := range consumers[i+1:] {
+			if a.topic != b.topic || a.view != b.view || a.cluster != b.cluster || strings.Join(a.brokers, ",") != strings.Join(b.brokers, ",") {
+				continue
+			}
+			if a.partition < 0 || b.partition < 0 || a.partition == b.partition {
+				return fmt.Errorf("Topic %v is consumed twice from the same partitions; please give its consumers different views, e.g. \"view\": \"live\"", a.topic)
+			}
+		}
+	}
+	return nil
+}
+
+// decoding returns how values of topic should be decoded: the first decoder
+// matching the topic wins, and topics without one are taken to hold JSON,
+// except for __consumer_offsets.
+func (c *config) decoding(topic string) decoding {
+	if d, ok := c.decodings[topic]; ok {
+		return d
+	}
+
+	d := decoding{registry: c.registry}
+	if topic == consumerOffsetsTopic {
+		d.format = "consumerOffsets"
+	}
+	for _, decoder := range c.decoders {
+		if decoder.topic.MatchString(topic) {
+			d = decoder.decoding
+			break
+		}
+	}
+	c.decodings[topic] = d
+	return d
+}
@@ -0,0 +1,83 @@
+package flowbro
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConsumerClusters(t *testing.T) {
+	conf := configJSON{Kafka: kafka{
+		Brokers:    "local:9092",
+		Alias:      "local",
+		Clusters:   []clusterJSON{{Alias: "eu", Brokers: "eu1:9092,eu2:9092", Connection: &connectionJSON{Retries: 10}}},
+		Connection: &connectionJSON{Retries: 3},
+		Consumers: []consumerConfigJson{
+			{Topic: "a"},
+			{Topic: "b", Cluster: "eu", ClientId: "eu-audit"},
+			{Topic: "c", Brokers: "other:9092"},
+		},
+	}}
+
+	c, err := processConfig(&conf)
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+
+	expected := []struct {
+		cluster string
+		brokers []string
+	}{
+		{"local", []string{"local:9092"}},
+		{"eu", []string{"eu1:9092", "eu2:9092"}},
+		{"", []string{"other:9092"}},
+	}
+	for i, e := range expected {
+		if c.consumers[i].cluster != e.cluster || !reflect.DeepEqual(c.consumers[i].brokers, e.brokers) {
+			t.Errorf("on consumer %v: expected cluster %v with brokers %v but got %v with %v", i, e.cluster, e.brokers, c.consumers[i].cluster, c.consumers[i].brokers)
+		}
+	}
+
+	if c.consumers[0].clientId != defaultClientId() || c.consumers[1].clientId != "eu-audit" {
+		t.Errorf("expected consumers to default to the default client id unless overridden but got %+v", c.consumers)
+	}
+	if c.consumers[0].policy.retries != 3 || c.consumers[1].policy.retries != 10 {
+		t.Errorf("expected cluster eu to override the retries of all clusters but got %+v", c.consumers)
+	}
+
+	conf.Kafka.Consumers = []consumerConfigJson{{Topic: "a", Cluster: "us"}}
+	if _, err := processConfig(&conf); err == nil {
+		t.Errorf("expected consumers of unknown clusters to be rejected")
+	}
+}
+
+func TestConsumerViews(t *testing.T) {
+	zero, one := 0, 1
+	ts := []struct {
+		consumers []consumerConfigJson
+		fails     bool
+	}{
+		{[]consumerConfigJson{{Topic: "a", View: "live"}, {Topic: "a", Offset: "-1h", View: "hour-ago"}}, false},
+		{[]consumerConfigJson{{Topic: "a"}, {Topic: "a", View: "hour-ago"}}, false},
+		{[]consumerConfigJson{{Topic: "a", Partition: &zero}, {Topic: "a", Partition: &one}}, false},
+		{[]consumerConfigJson{{Topic: "a"}, {Topic: "a", Brokers: "other:9092"}}, false},
+		{[]consumerConfigJson{{Topic: "a"}, {Topic: "a"}}, true},
+		{[]consumerConfigJson{{Topic: "a", View: "live"}, {Topic: "a", Partition: &one, View: "live"}}, true},
+	}
+
+	for _, tc := range ts {
+		c, err := processConfig(&configJSON{Kafka: kafka{Brokers: "local:9092", Consumers: tc.consumers}})
+		if tc.fails {
+			if err == nil {
+				t.Errorf("on '%+v': expected reading a partition twice in the same view to be rejected", tc.consumers)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on '%+v': shouldn't have failed but did with %v", tc.consumers, err)
+			continue
+		}
+		if same := clusterName(c.consumers[0]) == clusterName(c.consumers[1]); same != (tc.consumers[0].View == tc.consumers[1].View && tc.consumers[0].Brokers == tc.consumers[1].Brokers) {
+			t.Errorf("on '%+v': expected only consumers of the same view to share a connection", tc.consumers)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package flowbro
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type connectionJSON struct {
+	Retries               int     `json:"retries"`
+	InitialBackoffSeconds float64 `json:"initialBackoffSeconds"`
+	MaxBackoffSeconds     float64 `json:"maxBackoffSeconds"`
+	TimeoutSeconds        float64 `json:"timeoutSeconds"`
+}
+
+// connectionPolicy is how hard flowbro tries to reach a cluster: how often
+// it retries connecting or restarting a partition consumer before giving up
+// (never when retries is 0), how long it waits in between, and how long it
+// waits for a broker to answer.
+type connectionPolicy struct {
+	retries        int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	timeout        time.Duration
+}
+
+const (
+	reconnectBase = time.Second
+	reconnectMax  = 30 * time.Second
+)
+
+var defaultConnectionPolicy = connectionPolicy{initialBackoff: reconnectBase, maxBackoff: reconnectMax, timeout: 30 * time.Second}
+
+// processConnection returns the policy of a cluster, overriding the defaults
+// of all clusters with the settings of the cluster itself.
+func processConnection(defaults, override *connectionJSON) (connectionPolicy, error) {
+	p := defaultConnectionPolicy
+	for _, c := range []*connectionJSON{defaults, override} {
+		if c == nil {
+			continue
+		}
+		if c.Retries < 0 || c.InitialBackoffSeconds < 0 || c.MaxBackoffSeconds < 0 || c.TimeoutSeconds < 0 {
+			return p, fmt.Errorf("Invalid connection settings %+v; retries, backoffs and timeout can't be negative", *c)
+		}
+		if c.Retries > 0 {
+			p.retries = c.Retries
+		}
+		if c.InitialBackoffSeconds > 0 {
+			p.initialBackoff = time.Duration(c.InitialBackoffSeconds * float64(time.Second))
+		}
+		if c.MaxBackoffSeconds > 0 {
+			p.maxBackoff = time.Duration(c.MaxBackoffSeconds * float64(time.Second))
+		}
+		if c.TimeoutSeconds > 0 {
+			p.timeout = time.Duration(c.TimeoutSeconds * float64(time.Second))
+		}
+	}
+	if p.initialBackoff > p.maxBackoff {
+		return p, fmt.Errorf("Initial backoff %v exceeds maximum backoff %v", p.initialBackoff, p.maxBackoff)
+	}
+	return p, nil
+}
+
+// backoff doubles the wait after each failed attempt, up to the maximum,
+// picking a random wait between half and all of it so sessions don't
+// reconnect in lockstep.
+func (p connectionPolicy) backoff(attempt int) time.Duration {
+	d, max := p.initialBackoff, p.maxBackoff
+	if d <= 0 {
+		d = reconnectBase
+	}
+	if max <= 0 {
+		max = reconnectMax
+	}
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// exhausted tells whether attempt was the last one allowed.
+func (p connectionPolicy) exhausted(attempt int) bool {
+	return p.retries > 0 && attempt > p.retries
+}
+
+func (p connectionPolicy) apply(saramaConfig *sarama.Config) {
+	if p.timeout <= 0 {
+		return
+	}
+	saramaConfig.Net.DialTimeout = p.timeout
+	saramaConfig.Net.ReadTimeout = p.timeout
+	saramaConfig.Net.WriteTimeout = p.timeout
+}
+
+// validClientId is what Kafka brokers accept as a client id.
+var (
+	validClientId       = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	invalidClientIdChar = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+)
+
+// defaultClientId identifies flowbro's traffic in broker logs and quotas by
+// the host it runs on, e.g. flowbro-web-1.
+func defaultClientId() string {
+	host, err := os.Hostname()
+	if err != nil || len(host) == 0 {
+		return "flowbro"
+	}
+	return "flowbro-" + invalidClientIdChar.ReplaceAllString(host, "-")
+}
+
+// processClientId returns the first of ids that is set, or the default.
+func processClientId(ids ...string) (string, error) {
+	for _, id := range ids {
+		if len(id) == 0 {
+			continue
+		}
+		if !validClientId.MatchString(id) {
+			return "", fmt.Errorf("Invalid client id %v; only letters, digits, '.', '_' and '-' are allowed", id)
+		}
+		return id, nil
+	}
+	return defaultClientId(), nil
+}
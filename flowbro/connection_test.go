@@ -0,0 +1,112 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConnectionBackoff(t *testing.T) {
+	tests := []struct {
+		policy   connectionPolicy
+		attempt  int
+		min, max time.Duration
+	}{
+		{policy: defaultConnectionPolicy, attempt: 1, min: 500 * time.Millisecond, max: time.Second},
+		{policy: defaultConnectionPolicy, attempt: 2, min: time.Second, max: 2 * time.Second},
+		{policy: defaultConnectionPolicy, attempt: 4, min: 4 * time.Second, max: 8 * time.Second},
+		{policy: defaultConnectionPolicy, attempt: 50, min: reconnectMax / 2, max: reconnectMax},
+		{policy: connectionPolicy{}, attempt: 1, min: 500 * time.Millisecond, max: time.Second},
+		{policy: connectionPolicy{initialBackoff: 100 * time.Millisecond, maxBackoff: 300 * time.Millisecond}, attempt: 2, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+		{policy: connectionPolicy{initialBackoff: 100 * time.Millisecond, maxBackoff: 300 * time.Millisecond}, attempt: 10, min: 150 * time.Millisecond, max: 300 * time.Millisecond},
+	}
+
+	for _, ts := range tests {
+		for i := 0; i < 20; i++ {
+			if d := ts.policy.backoff(ts.attempt); d < ts.min || d > ts.max {
+				t.Errorf("on attempt %v of %+v: expected a wait between %v and %v but got %v", ts.attempt, ts.policy, ts.min, ts.max, d)
+			}
+		}
+	}
+}
+
+func TestProcessConnection(t *testing.T) {
+	tests := []struct {
+		defaults, override *connectionJSON
+		expected           connectionPolicy
+		fails              bool
+	}{
+		{expected: defaultConnectionPolicy},
+		{
+			defaults: &connectionJSON{Retries: 5, TimeoutSeconds: 10},
+			expected: connectionPolicy{retries: 5, initialBackoff: reconnectBase, maxBackoff: reconnectMax, timeout: 10 * time.Second},
+		},
+		{
+			defaults: &connectionJSON{Retries: 5, TimeoutSeconds: 10},
+			override: &connectionJSON{Retries: 50, InitialBackoffSeconds: 0.5, MaxBackoffSeconds: 5},
+			expected: connectionPolicy{retries: 50, initialBackoff: 500 * time.Millisecond, maxBackoff: 5 * time.Second, timeout: 10 * time.Second},
+		},
+		{override: &connectionJSON{Retries: -1}, fails: true},
+		{override: &connectionJSON{InitialBackoffSeconds: 60, MaxBackoffSeconds: 10}, fails: true},
+	}
+
+	for _, ts := range tests {
+		actual, err := processConnection(ts.defaults, ts.override)
+		if ts.fails {
+			if err == nil {
+				t.Errorf("on '%+v %+v': expected an error", ts.defaults, ts.override)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on '%+v %+v': shouldn't have failed, but did with %v", ts.defaults, ts.override, err)
+			continue
+		}
+		if actual != ts.expected {
+			t.Errorf("on '%+v %+v': expected %+v but got %+v", ts.defaults, ts.override, ts.expected, actual)
+		}
+	}
+}
+
+func TestExhaustedConnectionPolicy(t *testing.T) {
+	tests := []struct {
+		retries, attempt int
+		expected         bool
+	}{
+		{retries: 0, attempt: 1000, expected: false},
+		{retries: 3, attempt: 3, expected: false},
+		{retries: 3, attempt: 4, expected: true},
+	}
+
+	for _, ts := range tests {
+		if actual := (connectionPolicy{retries: ts.retries}).exhausted(ts.attempt); actual != ts.expected {
+			t.Errorf("on '%v of %v': expected %v but got %v", ts.attempt, ts.retries, ts.expected, actual)
+		}
+	}
+}
+
+func TestProcessClientId(t *testing.T) {
+	tests := []struct {
+		ids      []string
+		expected string
+		fails    bool
+	}{
+		{ids: []string{"", ""}, expected: defaultClientId()},
+		{ids: []string{"", "flowbro-prod"}, expected: "flowbro-prod"},
+		{ids: []string{"orders-audit", "flowbro-prod"}, expected: "orders-audit"},
+		{ids: []string{"orders audit", "flowbro-prod"}, fails: true},
+	}
+
+	for _, ts := range tests {
+		actual, err := processClientId(ts.ids...)
+		if ts.fails != (err != nil) {
+			t.Errorf("on '%v': expected failure %v but got %v", ts.ids, ts.fails, err)
+			continue
+		}
+		if !ts.fails && actual != ts.expected {
+			t.Errorf("on '%v': expected %v but got %v", ts.ids, ts.expected, actual)
+		}
+	}
+	if !validClientId.MatchString(defaultClientId()) {
+		t.Errorf("expected the default client id %v to be valid", defaultClientId())
+	}
+}
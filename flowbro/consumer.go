@@ -0,0 +1,393 @@
+package flowbro
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"encoding/json"
+)
+
+type message struct {
+	Key           string                 `json:"key"`
+	KeyValue      interface{}            `json:"keyValue,omitempty"` // only set for keys decoded with a keyFormat
+	KeyRaw        []byte                 `json:"keyRaw,omitempty"`
+	Value         map[string]interface{} `json:"value"`
+	Topic         string                 `json:"topic"`
+	Partition     int32                  `json:"partition"`
+	Offset        int64                  `json:"offset"`
+	Timestamp     time.Time              `json:"timestamp"`               // only set if kafka is version 0.10+
+	TimestampType string                 `json:"timestampType,omitempty"` // CreateTime or LogAppendTime, if set by kafka
+	Tags          map[string]string      `json:"tags,omitempty"`          // set by scripts
+	Output        map[string]interface{} `json:"-"`                       // set by transforms; sent to the UI instead of Value
+	Cluster       string                 `json:"cluster,omitempty"`
+	Brokers       string                 `json:"brokers,omitempty"`
+	View          string                 `json:"view,omitempty"`        // of the consumer, when reading a partition more than once
+	ContentType   string                 `json:"contentType,omitempty"` // sniffed from the decompressed value
+	Diff          []valueChange          `json:"diff,omitempty"`        // from the previous value of the key, if diffed
+	Count         int64                  // only for bookie counts
+	FSMId         string                 // only for bookie counts
+}
+
+type iSender interface {
+	Send(context.Context, conn, string) error
+}
+
+type sender struct{}
+
+// Send sends msg to ws unless ctx is done, giving up at ctx's deadline.
+func (s sender) Send(ctx context.Context, ws conn, msg string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d, ok := ws.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		if deadline, ok := ctx.Deadline(); ok {
+			d.SetWriteDeadline(deadline)
+		}
+	}
+	_, err := ws.Write([]byte(msg))
+	return err
+}
+
+func process(ws conn, c <-chan *SourceMessage, sender iSender, config *config, bookieCounts map[string]int64, alerter *alerter, clusters clusters, life *lifecycle) {
+	ctx := life.context()
+	rules, globalFSMId := config.rules, config.fsmId
+	flowVersion := config.flows.version(config.flowName)
+	ticker := time.NewTicker(time.Millisecond * 100)
+
+	buffer, notices := []message{}, []event{}
+	for t, c := range bookieCounts {
+		buffer = append(buffer, message{Count: c, Topic: t, FSMId: globalFSMId})
+	}
+
+	fsmIdAliases := map[string]string{}
+	if config.snapshot != nil {
+		snapshot := snapshotEvents(config.snapshot, clusters, config)
+		config.sanitizer.events(snapshot)
+		byt, err := json.Marshal(snapshot)
+		if err == nil {
+			err = sender.Send(ctx, ws, string(byt))
+		}
+		if err != nil {
+			log.Printf("Error while trying to send snapshots to WebSocket: err=%v\n", err)
+		}
+	}
+	sendSuccess("Starting to send messages!", ws)
+
+	p := &pipeline{
+		ws:         ws,
+		config:     config,
+		window:     config.window,
+		replays:    config.replayFilter,
+		throughput: newThroughput(config.statsJSON, clusters.highWaterMarksOf, time.Now()),
+		discovery:  newDiscovery(config.discovery, time.Now()),
+		querying:   config.queries,
+	}
+	flows := newFlowCounter(config.flowStatsJSON, time.Now())
+	defer func() { p.replays.close() }()
+
+	hbCh, controls := make(chan struct{}), make(chan control)
+	timeout := config.heartbeatTimeout
+	if timeout <= 0 {
+		timeout = defaultHeartbeatTimeout
+	}
+	life.spawn(func(ctx context.Context) {
+		processHeartbeats(ctx, receiverOf(ws, controls, ctx), hbCh, config.heartbeatUUID, timeout)
+	})
+
+	paused := config.session != nil && config.session.session.Paused
+	draining, drainSignal := false, config.draining
+	defer func() {
+		if err := config.session.save(time.Now(), true); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	for {
+		in := c
+		if paused || draining {
+			in = nil
+		}
+		select {
+		case cMsg := <-in:
+			kept, events := p.run(cMsg, time.Now())
+			notices = append(notices, events...)
+			buffer = append(buffer, kept...)
+		case <-ticker.C:
+			events := notices
+			notices = []event{}
+			incompleteEvents := []event{}
+			now := time.Now()
+			for i := 0; len(buffer) > 0 && i < 1000; i++ {
+				produced := &events
+				if config.influx != nil {
+					produced = &[]event{}
+				}
+				err := processMessage(buffer[0], rules, fsmIdAliases, produced, &incompleteEvents, globalFSMId)
+				if err != nil {
+					sendError(fmt.Sprintf("Error while processing message: err=%v", err), ws)
+					break
+				}
+				if config.influx != nil {
+					config.influx.onEvents(buffer[0], *produced)
+					for _, e := range *produced {
+						events = aggregate(events, e, e.Aggregate, globalFSMId)
+					}
+				}
+				events = append(events, alerter.onMessage(buffer[0], now)...)
+				buffer = buffer[1:]
+			}
+			events = append(events, alerter.check(now)...)
+			events = append(events, expirePairs(config.pairs, now)...)
+			events = append(events, webhookFailures(config.webhooks)...)
+			events = append(events, config.influx.flush(now)...)
+			expireJoins(config.joins, now)
+			events = append(events, reportAggregations(config.aggregations, now)...)
+			events = append(events, p.querying.report(now)...)
+			if r, ok := config.flows.since(config.flowName, flowVersion); ok {
+				events = append(events, applyFlowRevision(config, r, &rules))
+				flowVersion = r.version
+			}
+			config.state.tick(config, paused, len(buffer), len(notices))
+			if err := config.session.save(now, false); err != nil {
+				events = append(events, event{EventType: "log", Text: err.Error(), Color: "error"})
+			}
+			if p.discovery != nil {
+				events = append(events, p.discovery.report(now)...)
+			}
+			if p.throughput != nil {
+				events = append(events, p.throughput.report(now)...)
+			}
+			if config.catchUp != nil {
+				if e, ok := config.catchUp.announce(); ok {
+					events = append(events, e)
+				}
+			}
+
+			for _, ie := range incompleteEvents {
+				events = aggregate(events, ie, ie.Aggregate, globalFSMId)
+			}
+			observeFlows(events, now)
+			if flows != nil {
+				flows.onEvents(events, now)
+				events = append(events, flows.report(now)...)
+			}
+
+			config.sanitizer.events(events)
+			forwarded := events
+			events = append(events, forwardToSplunk(config.splunk, forwarded)...)
+			events = append(events, forwardToSyslog(config.syslog, forwarded)...)
+			events = append(events, forwardToLoki(config.loki, forwarded)...)
+			drained := draining && len(buffer) == 0
+			if drained {
+				events = append(events, event{EventType: "log", Text: "Flowbro is shutting down; every buffered message was sent. Please reconnect.", Color: "warning"})
+			}
+			if len(events) == 0 {
+				break
+			}
+
+			byt, err := json.Marshal(events)
+			if err != nil {
+				sendError(fmt.Sprintf("Error while marshalling events: err=%v\n", err), ws)
+				continue
+			}
+
+			err = sender.Send(ctx, ws, string(byt))
+			if err != nil {
+				log.Printf("Error while trying to send to WebSocket: err=%v\n", err)
+				return
+			}
+			if drained {
+				return
+			}
+		case ctl := <-controls:
+			if ctl.Action == "watch" || ctl.Action == "unwatch" {
+				if text, err := p.watching.apply(ctl); err != nil {
+					sendError(err.Error(), ws)
+				} else {
+					sendSuccess(text, ws)
+				}
+				continue
+			}
+			if ctl.Action == "query" || ctl.Action == "stopQuery" || ctl.Action == "subscribe" || ctl.Action == "unsubscribe" {
+				if text, err := p.querying.apply(ctl, config.savedQueries); err != nil {
+					sendError(err.Error(), ws)
+				} else {
+					sendSuccess(text, ws)
+				}
+				continue
+			}
+			if config.role < operator {
+				sendError(fmt.Sprintf("Only operators may %v; this view is read-only", ctl.Action), ws)
+				continue
+			}
+			switch ctl.Action {
+			case "pause", "resume":
+				paused = ctl.Action == "pause"
+				if config.session != nil {
+					if err := config.session.pause(paused, time.Now()); err != nil {
+						sendError(err.Error(), ws)
+					}
+				}
+				if paused {
+					sendSuccess("Paused consuming", ws)
+				} else {
+					sendSuccess("Resumed consuming", ws)
+				}
+				continue
+			case "view":
+				if config.session == nil {
+					sendError("Views can only be saved in named sessions", ws)
+				} else if err := config.session.view(ctl.View, time.Now()); err != nil {
+					sendError(err.Error(), ws)
+				} else {
+					sendSuccess("Saved the view of the session", ws)
+				}
+				continue
+			}
+			filter, err := newReplayFilter(ctl.Filter, ctl.ProduceTo, map[string]map[int32]int64{}, config.brokers)
+			if err != nil {
+				sendError(err.Error(), ws)
+				break
+			}
+			hwms := clusters.highWaterMarks() // reseeked partitions forget theirs
+			text, seeked, err := applyControl(ctl, clusters)
+			if err != nil {
+				sendError(err.Error(), ws)
+				break
+			}
+			p.window = nil
+			if ctl.Action == "replayWindow" {
+				if p.window, err = newReplayWindow(clusters, ctl.Cluster, seeked, *ctl.From, *ctl.To); err != nil {
+					sendError(err.Error(), ws)
+				} else {
+					notices = append(notices, p.window.begin())
+					if e, ok := p.window.end(); ok {
+						notices = append(notices, e)
+					}
+				}
+			}
+			if filter != nil {
+				filter.until = replayedUntil(seeked, hwms)
+				p.replays.close()
+				p.replays = filter
+				if len(ctl.Filter) > 0 {
+					text += fmt.Sprintf(", only replaying messages matching %v", ctl.Filter)
+				}
+				if len(ctl.ProduceTo) > 0 {
+					text += fmt.Sprintf(", re-producing them to topic %v", ctl.ProduceTo)
+				}
+			}
+			buffered := len(buffer)
+			buffer = dropSeeked(buffer, seeked)
+			config.state.drop("seeked", buffered-len(buffer))
+			if config.gaps != nil {
+				config.gaps.forget(seeked)
+			}
+			sendSuccess(text, ws)
+		case e := <-config.serverAlerts:
+			notices = append(notices, e)
+		case e := <-config.forwarding:
+			notices = append(notices, e)
+		case <-drainSignal:
+			draining, drainSignal = true, nil
+			notices = append(notices, event{EventType: "log", Text: fmt.Sprintf("Flowbro is draining before shutting down: stopped consuming, sending the %v buffered messages.", len(buffer)), Color: "warning"})
+		case <-hbCh:
+			sendError("Timing out due to heartbeat not received.", ws)
+			return
+		case <-ctx.Done():
+			sendError(fmt.Sprintf("Closing WebSocket connection due to: %v", ctx.Err()), ws)
+			return
+		}
+	}
+}
+
+func newMessage(cm SourceMessage, d decoding) (message, error) {
+	if d.format == "consumerOffsets" {
+		return newConsumerOffsetsMessage(cm)
+	}
+
+	b, err := decompress(cm.Value, d.compression, d.maxDecompressed)
+	if err != nil {
+		return message{}, err
+	}
+	d.compression = "none"
+	v, err := decodeValue(b, d)
+	if err != nil {
+		return message{}, err
+	}
+
+	kv, k, err := decodeKey(cm.Key, d)
+	if err != nil {
+		return message{}, err
+	}
+
+	return message{
+		Key:           k,
+		KeyValue:      kv,
+		KeyRaw:        cm.Key,
+		Value:         v,
+		Topic:         cm.Topic,
+		Partition:     cm.Partition,
+		Offset:        cm.Offset,
+		Timestamp:     cm.Timestamp,
+		TimestampType: timestampType(cm, d.timestampType),
+		ContentType:   sniffContentType(b),
+	}, nil
+}
+
+// newConsumerOffsetsMessage turns a record of __consumer_offsets into a
+// message whose structured key is the group, topic and partition committed.
+func newConsumerOffsetsMessage(cm SourceMessage) (message, error) {
+	kv, v, err := decodeConsumerOffsets(cm.Key, cm.Value)
+	if err != nil {
+		return message{}, err
+	}
+	k, err := json.Marshal(kv)
+	if err != nil {
+		return message{}, err
+	}
+	return message{
+		Key:           string(k),
+		KeyValue:      kv,
+		KeyRaw:        cm.Key,
+		Value:         v,
+		Topic:         cm.Topic,
+		Partition:     cm.Partition,
+		Offset:        cm.Offset,
+		Timestamp:     cm.Timestamp,
+		TimestampType: timestampType(cm, ""),
+	}, nil
+}
+
+// timestampType tells who set the timestamp of cm. The vendored sarama
+// doesn't expose the timestamp type bit of message attributes, and patching
+// vendor/ would be lost on the next update, so topics whose
+// message.timestamp.type is LogAppendTime declare it in their decoding.
+func timestampType(cm SourceMessage, declared string) string {
+	switch {
+	case cm.Timestamp.UnixNano() <= 0:
+		return ""
+	case len(declared) > 0:
+		return declared
+	}
+	return "CreateTime"
+}
+
+func sliceInsert(slice []message, index int, value message) []message {
+	if index == 0 {
+		return append([]message{value}, slice...)
+	}
+	if index >= len(slice) {
+		return append(slice, value)
+	}
+	// Grow the slice by one element.
+	slice = slice[0 : len(slice)+1]
+	// Use copy to move the upper part of the slice out of the way and open a hole.
+	copy(slice[index+1:], slice[index:])
+	// Store the new value.
+	slice[index] = value
+	// Return the result.
+	return slice
+}
@@ -0,0 +1,25 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimestampType(t *testing.T) {
+	tests := []struct {
+		name     string
+		cm       SourceMessage
+		declared string
+		expected string
+	}{
+		{name: "kafka before 0.10", cm: SourceMessage{}, declared: "LogAppendTime", expected: ""},
+		{name: "producer timestamp", cm: SourceMessage{Timestamp: time.Unix(1483228800, 0)}, expected: "CreateTime"},
+		{name: "broker timestamp", cm: SourceMessage{Timestamp: time.Unix(1483228800, 0)}, declared: "LogAppendTime", expected: "LogAppendTime"},
+	}
+
+	for _, ts := range tests {
+		if actual := timestampType(ts.cm, ts.declared); actual != ts.expected {
+			t.Errorf("on '%v': expected %v but got %v", ts.name, ts.expected, actual)
+		}
+	}
+}
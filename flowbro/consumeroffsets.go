@@ -0,0 +1,205 @@
+package flowbro
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// consumerOffsetsTopic is Kafka's internal topic holding the offsets consumer
+// groups commit and the groups' metadata, decoded with the consumerOffsets
+// format unless another decoder matches it.
+const consumerOffsetsTopic = "__consumer_offsets"
+
+// decodeConsumerOffsets decodes a record of __consumer_offsets, whose key
+// tells whether its value is an offset commit or a group's metadata. Null
+// values are tombstones, for expired offsets and deleted groups. Only the
+// versions that predate Kafka's flexible encoding are supported.
+func decodeConsumerOffsets(key, value []byte) (map[string]interface{}, map[string]interface{}, error) {
+	r := &binaryReader{b: key}
+	version := r.int16()
+	k := map[string]interface{}{"group": r.string()}
+	switch version {
+	case 0, 1:
+		k["topic"], k["partition"] = r.string(), int64(r.int32())
+	case 2:
+	default:
+		return nil, nil, fmt.Errorf("Unsupported __consumer_offsets key version %v", version)
+	}
+	if r.err != nil {
+		return nil, nil, fmt.Errorf("Could not decode __consumer_offsets key. err=%v", r.err)
+	}
+
+	v := map[string]interface{}{}
+	for f, fv := range k {
+		v[f] = fv
+	}
+	var err error
+	switch {
+	case value == nil && version == 2:
+		v["type"] = "groupDeleted"
+	case value == nil:
+		v["type"] = "offsetDeleted"
+	case version == 2:
+		v["type"] = "groupMetadata"
+		err = decodeGroupMetadata(value, v)
+	default:
+		v["type"] = "offsetCommit"
+		err = decodeOffsetCommit(value, v)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return k, v, nil
+}
+
+func decodeOffsetCommit(b []byte, v map[string]interface{}) error {
+	r := &binaryReader{b: b}
+	version := r.int16()
+	if version < 0 || version > 3 {
+		return fmt.Errorf("Unsupported __consumer_offsets offset commit version %v", version)
+	}
+	v["offset"] = r.int64()
+	if version == 3 {
+		if epoch := r.int32(); epoch >= 0 {
+			v["leaderEpoch"] = int64(epoch)
+		}
+	}
+	v["metadata"] = r.string()
+	v["commitTimestamp"] = millisString(r.int64())
+	if version == 1 {
+		if expire := r.int64(); expire >= 0 {
+			v["expireTimestamp"] = millisString(expire)
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("Could not decode __consumer_offsets offset commit. err=%v", r.err)
+	}
+	return nil
+}
+
+// decodeGroupMetadata decodes a group's generation and members, along with
+// the partitions assigned to each member of consumer groups.
+func decodeGroupMetadata(b []byte, v map[string]interface{}) error {
+	r := &binaryReader{b: b}
+	version := r.int16()
+	if version < 0 || version > 3 {
+		return fmt.Errorf("Unsupported __consumer_offsets group metadata version %v", version)
+	}
+	protocolType := r.string()
+	v["protocolType"], v["generation"] = protocolType, int64(r.int32())
+	v["protocol"], v["leader"] = r.string(), r.string()
+	if version >= 2 {
+		if ts := r.int64(); ts >= 0 {
+			v["currentStateTimestamp"] = millisString(ts)
+		}
+	}
+	members := []interface{}{}
+	for i, n := 0, r.int32(); i < int(n) && r.err == nil; i++ {
+		m := map[string]interface{}{"memberId": r.string()}
+		if version >= 3 {
+			if id := r.string(); len(id) > 0 {
+				m["groupInstanceId"] = id
+			}
+		}
+		m["clientId"], m["clientHost"] = r.string(), r.string()
+		if version >= 1 {
+			m["rebalanceTimeoutMs"] = int64(r.int32())
+		}
+		m["sessionTimeoutMs"] = int64(r.int32())
+		r.bytes() // subscription
+		assignment := r.bytes()
+		if protocolType == "consumer" && len(assignment) > 0 {
+			if a, err := decodeAssignment(assignment); err == nil {
+				m["assignment"] = a
+			}
+		}
+		members = append(members, m)
+	}
+	v["members"] = members
+	if r.err != nil {
+		return fmt.Errorf("Could not decode __consumer_offsets group metadata. err=%v", r.err)
+	}
+	return nil
+}
+
+// decodeAssignment decodes the partitions of each topic a consumer group
+// member was assigned.
+func decodeAssignment(b []byte) (map[string]interface{}, error) {
+	r := &binaryReader{b: b}
+	r.int16()
+	topics := map[string]interface{}{}
+	for i, n := 0, r.int32(); i < int(n) && r.err == nil; i++ {
+		topic, partitions := r.string(), []interface{}{}
+		for j, m := 0, r.int32(); j < int(m) && r.err == nil; j++ {
+			partitions = append(partitions, int64(r.int32()))
+		}
+		topics[topic] = partitions
+	}
+	return topics, r.err
+}
+
+// millisString formats a Kafka timestamp in milliseconds like JSON does.
+func millisString(ms int64) string {
+	return millisTime(ms).UTC().Format(time.RFC3339Nano)
+}
+
+// binaryReader reads Kafka's big endian primitives, remembering the first
+// error so callers can check once after reading a whole structure.
+type binaryReader struct {
+	b   []byte
+	err error
+}
+
+func (r *binaryReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.b) < n {
+		r.err = fmt.Errorf("Expected %v more bytes but only %v are left", n, len(r.b))
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *binaryReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *binaryReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *binaryReader) int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads an int16 length prefixed string; nullable strings read as
+// empty ones.
+func (r *binaryReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+// bytes reads int32 length prefixed bytes.
+func (r *binaryReader) bytes() []byte {
+	n := r.int32()
+	if n < 0 {
+		return nil
+	}
+	return r.next(int(n))
+}
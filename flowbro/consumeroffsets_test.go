@@ -0,0 +1,100 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// kafkaBinary encodes Kafka's primitives: int16, int32, int64, strings
+// (int16 length prefixed) and []byte (int32 length prefixed).
+func kafkaBinary(fields ...interface{}) []byte {
+	var b bytes.Buffer
+	for _, f := range fields {
+		switch v := f.(type) {
+		case string:
+			binary.Write(&b, binary.BigEndian, int16(len(v)))
+			b.WriteString(v)
+		case []byte:
+			binary.Write(&b, binary.BigEndian, int32(len(v)))
+			b.Write(v)
+		default:
+			binary.Write(&b, binary.BigEndian, v)
+		}
+	}
+	return b.Bytes()
+}
+
+func TestDecodeConsumerOffsets(t *testing.T) {
+	commitKey := kafkaBinary(int16(1), "billing", "orders", int32(3))
+	groupKey := kafkaBinary(int16(2), "billing")
+	assignment := kafkaBinary(int16(0), int32(1), "orders", int32(2), int32(0), int32(3), []byte{})
+	ts := []struct {
+		key, value []byte
+		expected   map[string]interface{}
+		fails      bool
+	}{
+		{
+			key:      commitKey,
+			value:    kafkaBinary(int16(3), int64(42), int32(5), "meta", int64(1500000000000)),
+			expected: map[string]interface{}{"type": "offsetCommit", "group": "billing", "topic": "orders", "partition": int64(3), "offset": int64(42), "leaderEpoch": int64(5), "metadata": "meta", "commitTimestamp": "2017-07-14T02:40:00Z"},
+		},
+		{
+			key:      commitKey,
+			value:    kafkaBinary(int16(1), int64(42), "", int64(1500000000000), int64(1500000001000)),
+			expected: map[string]interface{}{"type": "offsetCommit", "group": "billing", "topic": "orders", "partition": int64(3), "offset": int64(42), "metadata": "", "commitTimestamp": "2017-07-14T02:40:00Z", "expireTimestamp": "2017-07-14T02:40:01Z"},
+		},
+		{
+			key:      commitKey,
+			expected: map[string]interface{}{"type": "offsetDeleted", "group": "billing", "topic": "orders", "partition": int64(3)},
+		},
+		{
+			key:   groupKey,
+			value: kafkaBinary(int16(1), "consumer", int32(7), "range", "m1", int32(1), "m1", "app", "/10.0.0.1", int32(60000), int32(10000), []byte{}, assignment),
+			expected: map[string]interface{}{"type": "groupMetadata", "group": "billing", "protocolType": "consumer", "generation": int64(7), "protocol": "range", "leader": "m1", "members": []interface{}{
+				map[string]interface{}{"memberId": "m1", "clientId": "app", "clientHost": "/10.0.0.1", "rebalanceTimeoutMs": int64(60000), "sessionTimeoutMs": int64(10000), "assignment": map[string]interface{}{"orders": []interface{}{int64(0), int64(3)}}},
+			}},
+		},
+		{
+			key:      groupKey,
+			expected: map[string]interface{}{"type": "groupDeleted", "group": "billing"},
+		},
+		{key: commitKey, value: kafkaBinary(int16(4), int64(42)), fails: true},
+		{key: commitKey, value: kafkaBinary(int16(0), int64(42)), fails: true},
+		{key: kafkaBinary(int16(3), "billing"), fails: true},
+		{key: []byte{0}, fails: true},
+	}
+
+	for _, tc := range ts {
+		_, v, err := decodeConsumerOffsets(tc.key, tc.value)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("on '%v/%v': expected decoding to fail but got %v", tc.key, tc.value, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on '%v/%v': shouldn't have failed but did with %v", tc.key, tc.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, tc.expected) {
+			t.Errorf("on '%v/%v': expected %v but got %v", tc.key, tc.value, tc.expected, v)
+		}
+	}
+}
+
+func TestConsumerOffsetsAreDecodedByDefault(t *testing.T) {
+	config, err := processConfig(&configJSON{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := SourceMessage{Topic: consumerOffsetsTopic, Key: kafkaBinary(int16(1), "billing", "orders", int32(3)), Value: kafkaBinary(int16(0), int64(42), "", int64(0))}
+	m, err := newMessage(cm, config.decoding(cm.Topic))
+	if err != nil {
+		t.Fatalf("shouldn't have failed decoding the commit but did with %v", err)
+	}
+	if m.Key != `{"group":"billing","partition":3,"topic":"orders"}` || m.Value["offset"] != int64(42) {
+		t.Errorf("expected a commit of offset 42 keyed by group, topic and partition but got %+v", m)
+	}
+}
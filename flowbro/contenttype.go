@@ -0,0 +1,69 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"unicode/utf8"
+)
+
+const (
+	contentTypeJSON   = "application/json"
+	contentTypeXML    = "application/xml"
+	contentTypeText   = "text/plain"
+	contentTypeBinary = "application/octet-stream"
+)
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// sniffContentType tells whether b is JSON, XML, plain text or binary, so
+// the UI can pick a renderer for a value without guessing. Empty values,
+// like tombstones, have no content type.
+func sniffContentType(b []byte) string {
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(b, utf8BOM))
+	switch {
+	case len(b) == 0:
+		return ""
+	case len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed):
+		return contentTypeJSON
+	case len(trimmed) > 0 && trimmed[0] == '<' && isXML(trimmed):
+		return contentTypeXML
+	case isText(b):
+		return contentTypeText
+	}
+	return contentTypeBinary
+}
+
+// isXML reports whether b is well-formed XML with a root element.
+func isXML(b []byte) bool {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = true
+	root := false
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return root
+		}
+		if err != nil {
+			return false
+		}
+		if _, ok := t.(xml.StartElement); ok {
+			root = true
+		}
+	}
+}
+
+// isText reports whether b is valid UTF-8 without control characters other
+// than whitespace.
+func isText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if (r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f') || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package flowbro
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func TestSniffContentType(t *testing.T) {
+	ts := []struct {
+		value    string
+		expected string
+	}{
+		{``, ""},
+		{`{"target":"phone"}`, contentTypeJSON},
+		{"\xef\xbb\xbf  [1, 2]\n", contentTypeJSON},
+		{`{"target":`, contentTypeText},
+		{`<order id="1"><item/></order>`, contentTypeXML},
+		{`<?xml version="1.0"?>` + "\n<order/>", contentTypeXML},
+		{`<order>`, contentTypeText},
+		{`<!-- nothing -->`, contentTypeText},
+		{"hello\tworld\n", contentTypeText},
+		{`42`, contentTypeText},
+		{"caf\xc3\xa9", contentTypeText},
+		{"\x00\x00\x00\x01", contentTypeBinary},
+		{"\xff\xfe", contentTypeBinary},
+		{"\x1f\x8b\x08\x00", contentTypeBinary},
+	}
+
+	for _, tc := range ts {
+		if actual := sniffContentType([]byte(tc.value)); actual != tc.expected {
+			t.Errorf("on '%q': expected %q but got %q", tc.value, tc.expected, actual)
+		}
+	}
+}
+
+func TestNewMessageSniffsDecompressedValue(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(`{"a":1}`))
+	gw.Close()
+
+	ts := []struct {
+		cm       SourceMessage
+		d        decoding
+		expected string
+	}{
+		{SourceMessage{Value: []byte(`{"a":1}`)}, decoding{}, contentTypeJSON},
+		{SourceMessage{Value: gz.Bytes()}, decoding{}, contentTypeJSON},
+		{SourceMessage{Value: []byte(`<a>1</a>`)}, decoding{format: "xml"}, contentTypeXML},
+	}
+
+	for _, tc := range ts {
+		m, err := newMessage(tc.cm, tc.d)
+		if err != nil {
+			t.Errorf("on '%q': shouldn't have failed but did with %v", tc.cm.Value, err)
+			continue
+		}
+		if m.ContentType != tc.expected {
+			t.Errorf("on '%q': expected %q but got %q", tc.cm.Value, tc.expected, m.ContentType)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// control is an action requested by the UI over the websocket, next to the
+// heartbeats, e.g. {"action":"seek","topic":"orders","partition":0,"offset":42}.
+type control struct {
+	Action     string          `json:"action"`
+	Cluster    string          `json:"cluster"`
+	Topic      string          `json:"topic"`
+	Partition  *int32          `json:"partition"`
+	Offset     *int64          `json:"offset"`
+	Timestamp  *int64          `json:"timestamp"` // milliseconds since epoch
+	Duration   string          `json:"duration"`  // e.g. 5m, for rewinds
+	Filter     string          `json:"filter"`    // only replay matching messages
+	ProduceTo  string          `json:"produceTo"` // re-produce replayed messages to this topic
+	From       *int64          `json:"from"`      // milliseconds since epoch, for replay windows
+	To         *int64          `json:"to"`
+	View       json.RawMessage `json:"view"` // UI state to save in a named session
+	Id         string          `json:"id"`   // of a watch or query
+	KeyRegex   string          `json:"keyRegex"`
+	ValueRegex string          `json:"valueRegex"`
+	SQL        string          `json:"sql"` // of a query
+}
+
+// applyControl carries out ctl, returning a description of what was done and
+// the partitions that were reseeked, whose buffered messages are now stale.
+func applyControl(ctl control, cs clusters) (string, map[string][]int32, error) {
+	switch ctl.Action {
+	case "seek":
+		return seekControl(ctl, cs)
+	case "rewind":
+		return rewindControl(ctl, cs)
+	case "replayWindow":
+		return replayWindowControl(ctl, cs)
+	}
+	return "", nil, fmt.Errorf("Unknown control action %v", ctl.Action)
+}
+
+func seekControl(ctl control, cs clusters) (string, map[string][]int32, error) {
+	if len(ctl.Topic) == 0 {
+		return "", nil, fmt.Errorf("Please define the topic to seek")
+	}
+	if (ctl.Offset == nil) == (ctl.Timestamp == nil) {
+		return "", nil, fmt.Errorf("Please define either offset or timestamp to seek topic %v", ctl.Topic)
+	}
+	partition := int32(-1)
+	if ctl.Partition != nil {
+		partition = *ctl.Partition
+	}
+
+	var resolve func(client sarama.Client, p int32) (int64, error)
+	var position string
+	if ctl.Offset != nil {
+		resolve = func(client sarama.Client, p int32) (int64, error) { return *ctl.Offset, nil }
+		position = fmt.Sprintf("offset %v", *ctl.Offset)
+	} else {
+		resolve = func(client sarama.Client, p int32) (int64, error) {
+			return client.GetOffset(ctl.Topic, p, *ctl.Timestamp)
+		}
+		position = millisTime(*ctl.Timestamp).Format(time.RFC3339)
+	}
+
+	targets, err := cs.consuming(ctl.Cluster, ctl.Topic)
+	if err != nil {
+		return "", nil, err
+	}
+	seeked := []int32{}
+	for _, c := range targets {
+		partitions, err := c.seek(ctl.Topic, partition, resolve)
+		if err != nil {
+			return "", nil, err
+		}
+		seeked = append(seeked, partitions...)
+	}
+	return fmt.Sprintf("Seeked topic %v partitions %v to %v", ctl.Topic, seeked, position), map[string][]int32{ctl.Topic: seeked}, nil
+}
+
+// rewindControl reseeks every partition being consumed to how it was
+// duration ago, so what just happened can be replayed.
+func rewindControl(ctl control, cs clusters) (string, map[string][]int32, error) {
+	d, err := time.ParseDuration(ctl.Duration)
+	if err != nil || d <= 0 {
+		return "", nil, fmt.Errorf("Please define a positive duration to rewind, e.g. 5m")
+	}
+	since := time.Now().Add(-d).UnixNano() / int64(time.Millisecond)
+
+	seeked, err := seekAll(ctl.Cluster, cs, since)
+	if err != nil {
+		return "", seeked, err
+	}
+	if len(seeked) == 0 {
+		return "", nil, fmt.Errorf("Rewinding is only possible when connected to Kafka")
+	}
+	return fmt.Sprintf("Rewound to %v ago", d), seeked, nil
+}
+
+// replayWindowControl reseeks every partition being consumed to the start of
+// the window; the window's end is watched by a replayWindow.
+func replayWindowControl(ctl control, cs clusters) (string, map[string][]int32, error) {
+	if ctl.From == nil || ctl.To == nil || *ctl.From >= *ctl.To {
+		return "", nil, fmt.Errorf("Please define the from and to timestamps of the window to replay, from before to")
+	}
+	seeked, err := seekAll(ctl.Cluster, cs, *ctl.From)
+	if err != nil {
+		return "", seeked, err
+	}
+	if len(seeked) == 0 {
+		return "", nil, fmt.Errorf("Replaying a window is only possible when connected to Kafka")
+	}
+	return fmt.Sprintf("Replaying from %v to %v", millisTime(*ctl.From).Format(time.RFC3339), millisTime(*ctl.To).Format(time.RFC3339)), seeked, nil
+}
+
+// seekAll reseeks every partition being consumed, of the cluster with the
+// given alias if any, to the first offset at or after timestamp.
+func seekAll(alias string, cs clusters, timestamp int64) (map[string][]int32, error) {
+	seeked := map[string][]int32{}
+	for _, c := range cs {
+		if len(alias) > 0 && c.alias != alias {
+			continue
+		}
+		for _, topic := range c.topics() {
+			topic := topic
+			partitions, err := c.seek(topic, -1, func(client sarama.Client, p int32) (int64, error) { return client.GetOffset(topic, p, timestamp) })
+			if err != nil {
+				return seeked, err
+			}
+			seeked[topic] = append(seeked[topic], partitions...)
+		}
+	}
+	return seeked, nil
+}
+
+func millisTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// dropSeeked removes buffered messages from the given partitions.
+func dropSeeked(buffer []message, seeked map[string][]int32) []message {
+	kept := []message{}
+	for _, m := range buffer {
+		stale := false
+		for _, p := range seeked[m.Topic] {
+			stale = stale || (p == m.Partition && m.Count == 0)
+		}
+		if !stale {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,80 @@
+package flowbro
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestSeekControlValidation(t *testing.T) {
+	offset, ts, later := int64(3), int64(1483228800000), int64(1483232400000)
+	tests := []struct {
+		name string
+		ctl  control
+	}{
+		{name: "unknown action", ctl: control{Action: "jump"}},
+		{name: "missing topic", ctl: control{Action: "seek", Offset: &offset}},
+		{name: "missing position", ctl: control{Action: "seek", Topic: "orders"}},
+		{name: "both offset and timestamp", ctl: control{Action: "seek", Topic: "orders", Offset: &offset, Timestamp: &ts}},
+		{name: "not connected to kafka", ctl: control{Action: "seek", Topic: "orders", Offset: &offset}},
+		{name: "rewind without duration", ctl: control{Action: "rewind"}},
+		{name: "rewind with negative duration", ctl: control{Action: "rewind", Duration: "-5m"}},
+		{name: "rewind not connected to kafka", ctl: control{Action: "rewind", Duration: "5m"}},
+		{name: "replay window without end", ctl: control{Action: "replayWindow", From: &ts}},
+		{name: "replay window ending before it starts", ctl: control{Action: "replayWindow", From: &later, To: &ts}},
+		{name: "replay window not connected to kafka", ctl: control{Action: "replayWindow", From: &ts, To: &later}},
+	}
+
+	for _, ts := range tests {
+		if _, _, err := applyControl(ts.ctl, clusters{&cluster{}}); err == nil {
+			t.Errorf("on '%v': expected control to fail", ts.name)
+		}
+	}
+}
+
+func TestDropSeeked(t *testing.T) {
+	buffer := []message{
+		{Topic: "orders", Partition: 0, Offset: 1},
+		{Topic: "orders", Partition: 1, Offset: 1},
+		{Topic: "payments", Partition: 0, Offset: 1},
+		{Topic: "orders", Count: 5}, // bookie counts aren't tied to partitions
+	}
+	expected := []message{buffer[1], buffer[2], buffer[3]}
+	if actual := dropSeeked(buffer, map[string][]int32{"orders": {0}}); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v but got %v", expected, actual)
+	}
+}
+
+func TestControlsAreAnsweredOverWebSocket(t *testing.T) {
+	path := writeTempFile(t, `{"topic":"requests","value":{}}`)
+	defer os.Remove(path)
+
+	s := httptestServer(&flowbro{mockPath: path})
+	defer s.Close()
+
+	ws, err := websocket.Dial(strings.Replace(s.URL, "http", "ws", 1)+"/ws", "", s.URL)
+	if err != nil {
+		t.Fatalf("Could not open WebSocket: %v", err)
+	}
+	defer ws.Close()
+
+	websocket.JSON.Send(ws, configJSON{HeartbeatUUID: "uuid"})
+	websocket.JSON.Send(ws, map[string]interface{}{"action": "seek", "topic": "requests", "offset": 0})
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var es []event
+		if err := websocket.JSON.Receive(ws, &es); err != nil {
+			t.Fatalf("Didn't receive an answer to the seek control. err=%v", err)
+		}
+		for _, e := range es {
+			if e.Color == "error" && strings.Contains(e.Text, "connected to Kafka") {
+				return
+			}
+		}
+	}
+}
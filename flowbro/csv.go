@@ -0,0 +1,137 @@
+package flowbro
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var csvColumns = []string{"received", "timestamp", "cluster", "topic", "partition", "offset", "key"}
+
+// writeCSV writes the recorded messages matching filter as CSV rows, with
+// the value of every field expression in a column of its own.
+func writeCSV(w io.Writer, dataDir, name string, from, to *time.Time, filter *celProgram, fields []string) error {
+	programs := []*celProgram{}
+	for _, f := range fields {
+		p, err := compileCEL(f)
+		if err != nil {
+			return fmt.Errorf("Invalid field %v. err=%v", f, err)
+		}
+		programs = append(programs, p)
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append(append([]string{}, csvColumns...), fields...)); err != nil {
+		return err
+	}
+	err := eachRecorded(dataDir, name, from, to, func(r recordedMessage) error {
+		m, err := r.message()
+		if err != nil {
+			return err
+		}
+		if filter != nil {
+			if keep, err := filter.match(m); err != nil || !keep {
+				return nil
+			}
+		}
+		row := []string{
+			r.Received.Format(time.RFC3339Nano),
+			m.Timestamp.Format(time.RFC3339Nano),
+			m.Cluster,
+			m.Topic,
+			strconv.Itoa(int(m.Partition)),
+			strconv.FormatInt(m.Offset, 10),
+			m.Key,
+		}
+		for _, p := range programs {
+			v, err := p.eval(m)
+			if err != nil {
+				v = nil
+			}
+			row = append(row, csvValue(v))
+		}
+		return cw.Write(row)
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// csvValue writes scalars as they are and lists and objects as JSON.
+func csvValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	byt, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(byt)
+}
+
+func (f *flowbro) exportCSVHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+		return
+	}
+	q := r.URL.Query()
+	from, err := parseTimeParam(r, "from")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	to, err := parseTimeParam(r, "to")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var filter *celProgram
+	if len(q.Get("filter")) > 0 {
+		if filter, err = compileCEL(q.Get("filter")); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid filter. err=%v", err))
+			return
+		}
+	}
+	for _, field := range q["field"] {
+		if _, err := compileCEL(field); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid field %v. err=%v", field, err))
+			return
+		}
+	}
+	name := q.Get("recording")
+	dir, err := recordingDir(f.dataDir, name)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := mayReadRecording(r, f.dataDir, name); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	if _, err := os.Stat(filepath.Join(dir, "messages.jsonl")); err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Unknown recording %v", name))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".csv"))
+	if err := writeCSV(w, f.dataDir, name, from, to, filter, q["field"]); err != nil {
+		// the header is already sent, so all that's left is to say so in the body
+		fmt.Fprintf(w, "\n%v\n", err)
+	}
+}
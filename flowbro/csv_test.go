@@ -0,0 +1,41 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCSVExportsFilteredRecordedMessages(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+
+	r, err := openRecording(&recordingJSON{Name: "orders"}, dir, json.RawMessage(`{}`), "")
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	at := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	r.record(message{Topic: "orders", Offset: 1, Key: "a", Value: newValueFrom(`{"customerId":123,"status":"PAID","items":[1,2]}`), Timestamp: at}, at)
+	r.record(message{Topic: "orders", Offset: 2, Key: "b", Value: newValueFrom(`{"customerId":456,"status":"NEW"}`), Timestamp: at}, at)
+	r.record(message{Topic: "orders", Offset: 3, Key: "c", Value: newValueFrom(`{"customerId":123,"status":"has \"quotes\", commas"}`), Timestamp: at}, at)
+	r.close()
+
+	filter, _ := compileCEL("value.customerId == 123")
+	var b bytes.Buffer
+	if err := writeCSV(&b, dir, "orders", nil, nil, filter, []string{"value.status", "value.items"}); err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	expected := "received,timestamp,cluster,topic,partition,offset,key,value.status,value.items\n" +
+		"2017-01-01T00:00:00Z,2017-01-01T00:00:00Z,,orders,0,1,a,PAID,\"[1,2]\"\n" +
+		"2017-01-01T00:00:00Z,2017-01-01T00:00:00Z,,orders,0,3,c,\"has \"\"quotes\"\", commas\",\n"
+	if b.String() != expected {
+		t.Errorf("expected\n%v\nbut got\n%v", expected, b.String())
+	}
+
+	if err := writeCSV(&b, dir, "orders", nil, nil, nil, []string{"value.("}); err == nil {
+		t.Errorf("expected an invalid field to fail")
+	}
+}
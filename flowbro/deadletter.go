@@ -0,0 +1,150 @@
+package flowbro
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type deadLetterJSON struct {
+	File    string `json:"file"`
+	Topic   string `json:"topic"`
+	Brokers string `json:"brokers"`
+}
+
+// deadLetter is a message that couldn't be decoded or filtered, kept with
+// its raw key and value for later analysis.
+type deadLetter struct {
+	Cluster   string    `json:"cluster,omitempty"`
+	Topic     string    `json:"topic"`
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+	Key       []byte    `json:"key"`
+	Value     []byte    `json:"value"`
+	Error     string    `json:"error"`
+}
+
+// deadLetters writes dead letters as JSON lines to a file in the data
+// directory and/or to a Kafka topic.
+type deadLetters struct {
+	file     *os.File
+	topic    string
+	producer sarama.SyncProducer
+}
+
+func newDeadLetter(cMsg *SourceMessage, err error) deadLetter {
+	return deadLetter{
+		Cluster:   cMsg.cluster,
+		Topic:     cMsg.Topic,
+		Partition: cMsg.Partition,
+		Offset:    cMsg.Offset,
+		Timestamp: cMsg.Timestamp,
+		Key:       cMsg.Key,
+		Value:     cMsg.Value,
+		Error:     err.Error(),
+	}
+}
+
+func openDeadLetters(c *deadLetterJSON, dataDir string, brokers []string) (*deadLetters, error) {
+	if c == nil {
+		return nil, nil
+	}
+	d := &deadLetters{topic: c.Topic}
+	if len(c.File) > 0 {
+		if !safeName(c.File) {
+			return nil, fmt.Errorf("Invalid dead letter file %v; dead letters are written to a file in the data directory", c.File)
+		}
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(filepath.Join(dataDir, c.File), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("Could not open dead letter file %v. err=%v", c.File, err)
+		}
+		d.file = f
+	}
+	if len(c.Topic) > 0 {
+		if len(c.Brokers) > 0 {
+			brokers = strings.Split(c.Brokers, ",")
+		}
+		producer, err := newSyncProducer(brokers)
+		if err != nil {
+			d.close()
+			return nil, fmt.Errorf("Could not create producer for dead letter topic %v. err=%v", c.Topic, err)
+		}
+		d.producer = producer
+	}
+	return d, nil
+}
+
+func (d *deadLetters) write(l deadLetter) error {
+	byt, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if d.file != nil {
+		if _, err := d.file.Write(append(byt, '\n')); err != nil {
+			return fmt.Errorf("Could not write dead letter. err=%v", err)
+		}
+	}
+	if d.producer != nil {
+		_, _, err := d.producer.SendMessage(&sarama.ProducerMessage{Topic: d.topic, Key: sarama.ByteEncoder(l.Key), Value: sarama.ByteEncoder(byt)})
+		if err != nil {
+			return fmt.Errorf("Could not produce dead letter to topic %v. err=%v", d.topic, err)
+		}
+	}
+	return nil
+}
+
+func (d *deadLetters) close() {
+	if d == nil {
+		return
+	}
+	if d.file != nil {
+		d.file.Close()
+	}
+	if d.producer != nil {
+		d.producer.Close()
+	}
+}
+
+// event tells clients about the dead letter, including its raw value.
+// event tells clients about l, with its raw value unless redacted: values of
+// topics with redactions are left out, since they couldn't be redacted.
+func (l deadLetter) event(redacted bool) event {
+	partition := l.Partition
+	e := event{
+		EventType:   "decode_error",
+		Text:        fmt.Sprintf("Could not process message at offset %v of topic %v partition %v. err=%v", l.Offset, l.Topic, l.Partition, l.Error),
+		Color:       "error",
+		KeyRaw:      base64.StdEncoding.EncodeToString(l.Key),
+		ContentType: sniffContentType(l.Value),
+		Cluster:     l.Cluster,
+		Topic:       l.Topic,
+		Partition:   &partition,
+	}
+	if !redacted {
+		e.Raw = base64.StdEncoding.EncodeToString(l.Value)
+	}
+	return e
+}
+
+// reject records cMsg as a dead letter, if configured, returning the events
+// telling clients about it.
+func (d *deadLetters) reject(cMsg *SourceMessage, err error, redacted bool) []event {
+	l := newDeadLetter(cMsg, err)
+	events := []event{l.event(redacted)}
+	if d != nil {
+		if err := d.write(l); err != nil {
+			events = append(events, event{EventType: "log", Text: err.Error(), Color: "error"})
+		}
+	}
+	return events
+}
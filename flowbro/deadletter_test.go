@@ -0,0 +1,80 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+func TestDeadLettersAreWrittenToFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+
+	d, err := openDeadLetters(&deadLetterJSON{File: "dead.jsonl"}, dir, nil)
+	if err != nil {
+		t.Fatalf("shouldn't have failed opening dead letters but did with %v", err)
+	}
+	cMsg := &SourceMessage{Topic: "orders", Partition: 1, Offset: 42, Key: []byte("k"), Value: []byte("{nope"), cluster: "eu"}
+	events := d.reject(cMsg, errors.New("invalid JSON"), false)
+	d.close()
+
+	if len(events) != 1 || events[0].EventType != "decode_error" || events[0].Raw != "e25vcGU=" || events[0].Topic != "orders" || events[0].ContentType != contentTypeText {
+		t.Errorf("expected a decode_error event with the raw value but got %+v", events)
+	}
+
+	byt, _ := ioutil.ReadFile(filepath.Join(dir, "dead.jsonl"))
+	var l deadLetter
+	if err := json.Unmarshal(byt, &l); err != nil {
+		t.Fatalf("expected a JSON dead letter but got %s", byt)
+	}
+	if l.Offset != 42 || l.Cluster != "eu" || string(l.Value) != "{nope" || l.Error != "invalid JSON" {
+		t.Errorf("unexpected dead letter %+v", l)
+	}
+}
+
+func TestDeadLettersAreProducedToTopic(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	producer.ExpectSendMessageAndFail(sarama.ErrLeaderNotAvailable)
+	d := &deadLetters{topic: "orders-dlq", producer: producer}
+
+	cMsg := &SourceMessage{Topic: "orders", Value: []byte("?")}
+	if events := d.reject(cMsg, errors.New("boom"), false); len(events) != 1 {
+		t.Errorf("expected only a decode_error event but got %+v", events)
+	}
+	if events := d.reject(cMsg, errors.New("boom"), false); len(events) != 2 || events[1].Color != "error" {
+		t.Errorf("expected failing to produce the dead letter to be reported but got %+v", events)
+	}
+	d.close()
+}
+
+func TestRejectWithoutDeadLetters(t *testing.T) {
+	var d *deadLetters
+	cMsg := &SourceMessage{Topic: "orders", Value: []byte("?")}
+	if events := d.reject(cMsg, errors.New("boom"), false); len(events) != 1 || events[0].EventType != "decode_error" {
+		t.Errorf("expected a decode_error event but got %+v", events)
+	}
+}
+
+func TestRejectLeavesRedactedValuesOut(t *testing.T) {
+	var d *deadLetters
+	cMsg := &SourceMessage{Topic: "users", Value: []byte(`{"email": "a@b.c"`)}
+	redactions, _ := processRedactions([]redactionJSON{{Topic: "users", Path: "$.email", Strategy: "drop"}})
+	if events := d.reject(cMsg, errors.New("boom"), redacts(redactions, cMsg.Topic)); len(events) != 1 || events[0].Raw != "" {
+		t.Errorf("expected a decode_error event without the raw value but got %+v", events)
+	}
+}
+
+func TestInvalidDeadLetterFile(t *testing.T) {
+	for _, f := range []string{"../escape.jsonl", ".hidden", "a/b"} {
+		if _, err := openDeadLetters(&deadLetterJSON{File: f}, os.TempDir(), nil); err == nil {
+			t.Errorf("on '%v': expected opening dead letters to fail", f)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package flowbro
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var formats = map[string]bool{"": true, "json": true, "xml": true, "cbor": true, "avro": true, "registry": true, "consumerOffsets": true}
+
+var keyFormats = map[string]bool{"": true, "string": true, "json": true, "xml": true, "cbor": true, "avro": true, "registry": true, "int32": true, "int64": true, "uuid": true, "hex": true}
+
+var timestampTypes = map[string]bool{"": true, "CreateTime": true, "LogAppendTime": true}
+
+// decoding describes how values of a topic are turned into messages.
+type decoding struct {
+	compression     string
+	format          string
+	keyFormat       string
+	timestampType   string
+	registry        *schemaRegistry
+	maxDecompressed int64 // bytes, defaultMaxDecompressed if 0
+}
+
+type decoderJSON struct {
+	Topic                string              `json:"topic"`
+	Format               string              `json:"format"`
+	Compression          string              `json:"compression"`
+	KeyFormat            string              `json:"keyFormat"`
+	TimestampType        string              `json:"timestampType"`
+	SchemaRegistry       *schemaRegistryJSON `json:"schemaRegistry"`
+	MaxDecompressedBytes int64               `json:"maxDecompressedBytes"`
+}
+
+// decoder applies a decoding to the topics matching its regex.
+type decoder struct {
+	topic *regexp.Regexp
+	decoding
+}
+
+func newDecoder(d decoderJSON, registry *schemaRegistry, dataDir string) (decoder, error) {
+	topic, err := regexp.Compile("^(?:" + d.Topic + ")$")
+	if err != nil {
+		return decoder{}, fmt.Errorf("Invalid topic regex %v for decoder. err=%v", d.Topic, err)
+	}
+	if !compressions[d.Compression] {
+		return decoder{}, fmt.Errorf("Unknown compression %v for topic %v; use auto, none, gzip, zlib, snappy or lz4", d.Compression, d.Topic)
+	}
+	if !formats[d.Format] {
+		return decoder{}, fmt.Errorf("Unknown format %v for topic %v; use json, xml, cbor, avro, registry or consumerOffsets", d.Format, d.Topic)
+	}
+	if !keyFormats[d.KeyFormat] {
+		return decoder{}, fmt.Errorf("Unknown key format %v for topic %v; use string, json, xml, cbor, avro, registry, int32, int64, uuid or hex", d.KeyFormat, d.Topic)
+	}
+	if !timestampTypes[d.TimestampType] {
+		return decoder{}, fmt.Errorf("Unknown timestamp type %v for topic %v; use CreateTime or LogAppendTime", d.TimestampType, d.Topic)
+	}
+	if d.MaxDecompressedBytes < 0 {
+		return decoder{}, fmt.Errorf("Invalid maxDecompressedBytes %v for topic %v; please use a positive number of bytes", d.MaxDecompressedBytes, d.Topic)
+	}
+	if d.SchemaRegistry != nil {
+		if registry, err = newSchemaRegistry(*d.SchemaRegistry, dataDir); err != nil {
+			return decoder{}, err
+		}
+	}
+	if (d.Format == "avro" || d.Format == "registry" || d.KeyFormat == "avro" || d.KeyFormat == "registry") && registry == nil {
+		return decoder{}, fmt.Errorf("Please configure schemaRegistry to consume %v topic %v", d.Format, d.Topic)
+	}
+	return decoder{topic: topic, decoding: decoding{compression: d.Compression, format: d.Format, keyFormat: d.KeyFormat, timestampType: d.TimestampType, registry: registry, maxDecompressed: d.MaxDecompressedBytes}}, nil
+}
+
+func decodeValue(b []byte, d decoding) (map[string]interface{}, error) {
+	b, err := decompress(b, d.compression, d.maxDecompressed)
+	if err != nil {
+		return nil, err
+	}
+
+	switch d.format {
+	case "xml":
+		return xmlToMap(b)
+	case "cbor":
+		return cborToMap(b)
+	case "avro", "registry":
+		v, err := decodeConfluent(b, d.registry)
+		if _, ok := err.(registryUnavailableError); ok {
+			return rawValue(b, err), nil
+		}
+		return v, err
+	case "consumerOffsets":
+		return nil, fmt.Errorf("Values of %v are decoded along with their keys", consumerOffsetsTopic)
+	case "", "json":
+		if len(b) > 0 && b[0] == 0 && d.registry != nil { // framed by a schema registry serializer
+			return decodeValue(b, decoding{format: "registry", registry: d.registry})
+		}
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Value is not a JSON object: %v", string(b))
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("Unknown format %v", d.format)
+}
+
+// decodeKey decodes a message key independently from its value. It returns
+// the structured key (nil for plain string keys) and its string form, which
+// is what rules see as .Key.
+func decodeKey(b []byte, d decoding) (interface{}, string, error) {
+	var v interface{}
+	switch d.keyFormat {
+	case "", "string":
+		return nil, string(b), nil
+	case "int32":
+		if len(b) != 4 {
+			return nil, "", fmt.Errorf("Key is %v bytes long; int32 keys must be 4", len(b))
+		}
+		v = int64(int32(binary.BigEndian.Uint32(b)))
+	case "int64":
+		if len(b) != 8 {
+			return nil, "", fmt.Errorf("Key is %v bytes long; int64 keys must be 8", len(b))
+		}
+		v = int64(binary.BigEndian.Uint64(b))
+	case "uuid":
+		if len(b) != 16 {
+			return nil, "", fmt.Errorf("Key is %v bytes long; uuid keys must be 16", len(b))
+		}
+		h := hex.EncodeToString(b)
+		v = h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+	case "hex":
+		v = hex.EncodeToString(b)
+	case "json":
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, "", fmt.Errorf("Could not decode JSON key. err=%v", err)
+		}
+	case "avro", "registry":
+		var err error
+		if v, err = decodeConfluentAny(b, d.registry); err != nil {
+			if _, ok := err.(registryUnavailableError); !ok {
+				return nil, "", fmt.Errorf("Could not decode key. err=%v", err)
+			}
+			v = rawValue(b, err)
+		}
+	default:
+		m, err := decodeValue(b, decoding{format: d.keyFormat, registry: d.registry})
+		if err != nil {
+			return nil, "", fmt.Errorf("Could not decode key. err=%v", err)
+		}
+		v = m
+	}
+
+	switch k := v.(type) {
+	case string:
+		return v, k, nil
+	case int64:
+		return v, strconv.FormatInt(k, 10), nil
+	}
+	byt, err := json.Marshal(v)
+	if err != nil {
+		return nil, "", err
+	}
+	return v, string(byt), nil
+}
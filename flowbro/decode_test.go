@@ -0,0 +1,129 @@
+package flowbro
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    []byte
+		d        decoding
+		expected map[string]interface{}
+		fails    bool
+	}{
+		{name: "json object", value: []byte(`{"a":"b"}`), expected: map[string]interface{}{"a": "b"}},
+		{name: "json non object", value: []byte(`[1,2]`), fails: true},
+		{name: "invalid json", value: []byte(`<order/>`), fails: true},
+		{
+			name:     "xml with only text",
+			value:    []byte(`<status>OK</status>`),
+			d:        decoding{format: "xml"},
+			expected: map[string]interface{}{"status": "OK"},
+		},
+		{
+			name:  "xml with attributes and repeated children",
+			value: []byte(`<?xml version="1.0"?><order id="42"><item>a</item><item>b</item><note lang="en">hi</note></order>`),
+			d:     decoding{format: "xml"},
+			expected: map[string]interface{}{"order": map[string]interface{}{
+				"@id":  "42",
+				"item": []interface{}{"a", "b"},
+				"note": map[string]interface{}{"@lang": "en", "#text": "hi"},
+			}},
+		},
+		{
+			name:  "cbor map",
+			value: []byte{0xa4, 0x61, 'a', 0x01, 0x61, 'b', 0x82, 0xf5, 0xf6, 0x61, 'c', 0x42, 0x01, 0x02, 0x61, 'd', 0xf9, 0x3e, 0x00},
+			d:     decoding{format: "cbor"},
+			expected: map[string]interface{}{
+				"a": int64(1),
+				"b": []interface{}{true, nil},
+				"c": "AQI=",
+				"d": 1.5,
+			},
+		},
+		{
+			name:     "cbor indefinite length map and string",
+			value:    []byte{0xbf, 0x7f, 0x61, 'k', 0x61, 'y', 0xff, 0x20, 0xff},
+			d:        decoding{format: "cbor"},
+			expected: map[string]interface{}{"ky": int64(-1)},
+		},
+		{name: "cbor non map", value: []byte{0x01}, d: decoding{format: "cbor"}, fails: true},
+		{name: "truncated cbor", value: []byte{0xa1, 0x61}, d: decoding{format: "cbor"}, fails: true},
+		{name: "broken xml", value: []byte(`<order><item>`), d: decoding{format: "xml"}, fails: true},
+	}
+
+	for _, ts := range tests {
+		actual, err := decodeValue(ts.value, ts.d)
+		if ts.fails != (err != nil) {
+			t.Errorf("'%v' expected failure to be %v, but err was %v", ts.name, ts.fails, err)
+			continue
+		}
+		if !ts.fails && !reflect.DeepEqual(actual, ts.expected) {
+			t.Errorf("on '%v': expected %+v but got %+v", ts.name, ts.expected, actual)
+		}
+	}
+}
+
+func TestConfigDecodingPicksFirstMatchingDecoder(t *testing.T) {
+	c, err := processConfig(&configJSON{
+		Kafka: kafka{Consumers: []consumerConfigJson{{Topic: "legacy.orders", Format: "xml"}, {Topic: "plain"}}},
+		Decoders: []decoderJSON{
+			{Topic: `legacy\..*`, Format: "cbor"},
+			{Topic: "events-.*", Compression: "gzip"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+
+	tests := []struct {
+		topic    string
+		expected decoding
+	}{
+		{topic: "legacy.orders", expected: decoding{format: "xml"}},
+		{topic: "legacy.payments", expected: decoding{format: "cbor"}},
+		{topic: "events-1", expected: decoding{compression: "gzip"}},
+		{topic: "plain", expected: decoding{}},
+		{topic: "xevents-1", expected: decoding{}},
+	}
+	for _, ts := range tests {
+		if actual := c.decoding(ts.topic); actual != ts.expected {
+			t.Errorf("on '%v': expected %+v but got %+v", ts.topic, ts.expected, actual)
+		}
+	}
+
+	if _, err := processConfig(&configJSON{Decoders: []decoderJSON{{Topic: "x", Format: "avro"}}}); err == nil {
+		t.Errorf("expected avro decoder without schema registry to fail")
+	}
+}
+
+func TestDecodeKey(t *testing.T) {
+	tests := []struct {
+		name           string
+		key            []byte
+		keyFormat      string
+		expectedValue  interface{}
+		expectedString string
+		fails          bool
+	}{
+		{name: "string", key: []byte("abc"), expectedString: "abc"},
+		{name: "int32", key: []byte{0, 0, 1, 0}, keyFormat: "int32", expectedValue: int64(256), expectedString: "256"},
+		{name: "int64", key: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}, keyFormat: "int64", expectedValue: int64(-2), expectedString: "-2"},
+		{name: "wrong width", key: []byte{0, 1}, keyFormat: "int32", fails: true},
+		{name: "uuid", key: []byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00}, keyFormat: "uuid", expectedValue: "123e4567-e89b-12d3-a456-426614174000", expectedString: "123e4567-e89b-12d3-a456-426614174000"},
+		{name: "json", key: []byte(`{"id":1}`), keyFormat: "json", expectedValue: map[string]interface{}{"id": float64(1)}, expectedString: `{"id":1}`},
+	}
+
+	for _, ts := range tests {
+		v, s, err := decodeKey(ts.key, decoding{keyFormat: ts.keyFormat})
+		if ts.fails != (err != nil) {
+			t.Errorf("'%v' expected failure to be %v, but err was %v", ts.name, ts.fails, err)
+			continue
+		}
+		if !ts.fails && (!reflect.DeepEqual(v, ts.expectedValue) || s != ts.expectedString) {
+			t.Errorf("on '%v': expected %+v (%v) but got %+v (%v)", ts.name, ts.expectedValue, ts.expectedString, v, s)
+		}
+	}
+}
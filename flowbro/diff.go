@@ -0,0 +1,142 @@
+package flowbro
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type diffJSON struct {
+	Topic   string `json:"topic"`
+	MaxKeys int    `json:"maxKeys"`
+}
+
+// keyDiffer diffs every value of the topics matching its regex against the
+// previous value of the same key, so changes to entity snapshots stand out.
+type keyDiffer struct {
+	topic   *regexp.Regexp
+	maxKeys int
+	last    map[string]map[string]interface{}
+}
+
+// valueChange is one difference between two values, at a JSONPath such as
+// $.address.city or $.items[2].
+type valueChange struct {
+	Op   string      `json:"op"` // add, remove or replace
+	Path string      `json:"path"`
+	From interface{} `json:"from,omitempty"`
+	To   interface{} `json:"to,omitempty"`
+}
+
+// defaultDiffMaxKeys bounds the keys remembered per differ; once reached
+// they're forgotten and diffing starts over.
+const defaultDiffMaxKeys = 100000
+
+var diffPathName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+func processDiffs(diffsJSON []diffJSON) ([]*keyDiffer, error) {
+	diffs := []*keyDiffer{}
+	for _, d := range diffsJSON {
+		topic, err := regexp.Compile("^(?:" + d.Topic + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid topic regex %v for diff. err=%v", d.Topic, err)
+		}
+		if d.MaxKeys < 0 {
+			return nil, fmt.Errorf("Invalid maxKeys %v for diff of %v; it can't be negative", d.MaxKeys, d.Topic)
+		}
+		maxKeys := d.MaxKeys
+		if maxKeys == 0 {
+			maxKeys = defaultDiffMaxKeys
+		}
+		diffs = append(diffs, &keyDiffer{topic: topic, maxKeys: maxKeys, last: map[string]map[string]interface{}{}})
+	}
+	return diffs, nil
+}
+
+// applyDiffs sets m's diff to the changes from the previous value of its
+// key, if its topic is diffed and the key was seen before.
+func applyDiffs(diffs []*keyDiffer, m message) message {
+	for _, d := range diffs {
+		if !d.topic.MatchString(m.Topic) || m.Value == nil {
+			continue
+		}
+		id := m.Cluster + "|" + m.Topic + "|" + m.Key
+		last, seen := d.last[id]
+		if len(d.last) >= d.maxKeys && !seen {
+			d.last = map[string]map[string]interface{}{}
+		}
+		d.last[id] = m.Value
+		if seen {
+			m.Diff = diffValues("$", last, m.Value, []valueChange{})
+		}
+		break
+	}
+	return m
+}
+
+// diffValues appends the changes from a to b, at path, to changes. Objects
+// are compared field by field and arrays index by index; anything else that
+// differs is replaced.
+func diffValues(path string, a, b interface{}, changes []valueChange) []valueChange {
+	switch ta := a.(type) {
+	case map[string]interface{}:
+		tb, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		names := []string{}
+		for k := range ta {
+			names = append(names, k)
+		}
+		for k := range tb {
+			if _, ok := ta[k]; !ok {
+				names = append(names, k)
+			}
+		}
+		sort.Strings(names)
+		for _, k := range names {
+			va, inA := ta[k]
+			vb, inB := tb[k]
+			p := diffPath(path, k)
+			switch {
+			case !inA:
+				changes = append(changes, valueChange{Op: "add", Path: p, To: vb})
+			case !inB:
+				changes = append(changes, valueChange{Op: "remove", Path: p, From: va})
+			default:
+				changes = diffValues(p, va, vb, changes)
+			}
+		}
+		return changes
+	case []interface{}:
+		tb, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(ta) || i < len(tb); i++ {
+			p := fmt.Sprintf("%v[%v]", path, i)
+			switch {
+			case i >= len(ta):
+				changes = append(changes, valueChange{Op: "add", Path: p, To: tb[i]})
+			case i >= len(tb):
+				changes = append(changes, valueChange{Op: "remove", Path: p, From: ta[i]})
+			default:
+				changes = diffValues(p, ta[i], tb[i], changes)
+			}
+		}
+		return changes
+	}
+	if !reflect.DeepEqual(a, b) {
+		changes = append(changes, valueChange{Op: "replace", Path: path, From: a, To: b})
+	}
+	return changes
+}
+
+func diffPath(path, name string) string {
+	if diffPathName.MatchString(name) {
+		return path + "." + name
+	}
+	return path + "['" + strings.Replace(name, "'", `\'`, -1) + "']"
+}
@@ -0,0 +1,80 @@
+package flowbro
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffValues(t *testing.T) {
+	ts := []struct {
+		a, b     string
+		expected []valueChange
+	}{
+		{`{"a":1}`, `{"a":1}`, []valueChange{}},
+		{`{"a":1,"b":true}`, `{"a":2,"c":"x"}`, []valueChange{
+			{Op: "replace", Path: "$.a", From: 1.0, To: 2.0},
+			{Op: "remove", Path: "$.b", From: true},
+			{Op: "add", Path: "$.c", To: "x"},
+		}},
+		{`{"address":{"city":"Paris","zip":"75001"}}`, `{"address":{"city":"Lyon","zip":"75001"}}`, []valueChange{
+			{Op: "replace", Path: "$.address.city", From: "Paris", To: "Lyon"},
+		}},
+		{`{"items":[1,2,3]}`, `{"items":[1,5]}`, []valueChange{
+			{Op: "replace", Path: "$.items[1]", From: 2.0, To: 5.0},
+			{Op: "remove", Path: "$.items[2]", From: 3.0},
+		}},
+		{`{"items":[{"q":1}]}`, `{"items":[{"q":2},{"q":1}]}`, []valueChange{
+			{Op: "replace", Path: "$.items[0].q", From: 1.0, To: 2.0},
+			{Op: "add", Path: "$.items[1]", To: map[string]interface{}{"q": 1.0}},
+		}},
+		{`{"a":{"b":1}}`, `{"a":[1]}`, []valueChange{
+			{Op: "replace", Path: "$.a", From: map[string]interface{}{"b": 1.0}, To: []interface{}{1.0}},
+		}},
+		{`{"first name":"a","it's":1}`, `{"first name":"b","it's":2}`, []valueChange{
+			{Op: "replace", Path: "$['first name']", From: "a", To: "b"},
+			{Op: "replace", Path: `$['it\'s']`, From: 1.0, To: 2.0},
+		}},
+	}
+
+	for _, tc := range ts {
+		actual := diffValues("$", newValueFrom(tc.a), newValueFrom(tc.b), []valueChange{})
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Errorf("on '%v' -> '%v': expected %+v but got %+v", tc.a, tc.b, tc.expected, actual)
+		}
+	}
+}
+
+func TestApplyDiffs(t *testing.T) {
+	diffs, err := processDiffs([]diffJSON{{Topic: "customers.*", MaxKeys: 2}})
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		m        message
+		expected []valueChange
+	}{
+		{name: "first of key", m: message{Topic: "customers", Key: "a", Value: newValueFrom(`{"name":"Ann"}`)}},
+		{name: "changed", m: message{Topic: "customers", Key: "a", Value: newValueFrom(`{"name":"Anna"}`)}, expected: []valueChange{{Op: "replace", Path: "$.name", From: "Ann", To: "Anna"}}},
+		{name: "unchanged", m: message{Topic: "customers", Key: "a", Value: newValueFrom(`{"name":"Anna"}`)}, expected: []valueChange{}},
+		{name: "other cluster", m: message{Cluster: "us", Topic: "customers", Key: "a", Value: newValueFrom(`{"name":"Bob"}`)}},
+		{name: "undiffed topic", m: message{Topic: "orders", Key: "a", Value: newValueFrom(`{"name":"Ann"}`)}},
+		{name: "forgotten once full", m: message{Topic: "customers", Key: "b", Value: newValueFrom(`{"name":"Ben"}`)}},
+		{name: "starting over", m: message{Topic: "customers", Key: "a", Value: newValueFrom(`{"name":"Ann"}`)}},
+	}
+
+	for _, ts := range tests {
+		m := applyDiffs(diffs, ts.m)
+		if !reflect.DeepEqual(m.Diff, ts.expected) {
+			t.Errorf("on '%v': expected diff %+v but got %+v", ts.name, ts.expected, m.Diff)
+		}
+	}
+
+	if _, err := processDiffs([]diffJSON{{Topic: "("}}); err == nil {
+		t.Errorf("expected an invalid topic regex to be rejected")
+	}
+	if _, err := processDiffs([]diffJSON{{Topic: "a", MaxKeys: -1}}); err == nil {
+		t.Errorf("expected a negative maxKeys to be rejected")
+	}
+}
@@ -0,0 +1,253 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+type discoveryJSON struct {
+	Correlations []string `json:"correlations"`
+	Seconds      float64  `json:"seconds"`
+	MinShared    int      `json:"minShared"`
+}
+
+type discoveryConfig struct {
+	correlations []*celProgram
+	duration     time.Duration
+	minShared    int
+}
+
+const (
+	defaultDiscoveryDuration  = 60 * time.Second
+	defaultDiscoveryMinShared = 2
+	// discoveryMaxIdentities bounds the identities remembered while
+	// discovering; once reached only known identities are followed.
+	discoveryMaxIdentities = 100000
+	discoveryMaxDelays     = 1000
+)
+
+// discovery observes which topics' messages share identities (their key or
+// the value of a correlation expression) and in which order, to suggest the
+// components and rules of a flow config.
+type discovery struct {
+	*discoveryConfig
+	started  time.Time
+	done     bool
+	messages int64
+	topics   map[string]int64
+	last     map[string]sighting
+	edges    map[edge]*sharedIdentities
+}
+
+type sighting struct {
+	topic string
+	at    time.Time
+}
+
+type sharedIdentities struct {
+	count  int
+	delays []time.Duration
+}
+
+// discoveredFlow is the suggested flow: a component per topic, laid out by
+// depth, and a rule per topic animating messages from the topic its
+// identities are most often seen on before.
+type discoveredFlow struct {
+	Components []discoveredComponent    `json:"components"`
+	Rules      []map[string]interface{} `json:"rules"`
+	Edges      []discoveredEdge         `json:"edges"`
+}
+
+type discoveredComponent struct {
+	Id   string `json:"id"`
+	Top  int    `json:"top"`
+	Left int    `json:"left"`
+}
+
+type discoveredEdge struct {
+	From          string  `json:"from"`
+	To            string  `json:"to"`
+	Shared        int     `json:"shared"`
+	MedianDelayMs float64 `json:"medianDelayMs"`
+}
+
+// lastDiscovered is the flow most recently discovered by any client, for
+// /api/graph.dot and /api/graph.mmd.
+var lastDiscovered = struct {
+	sync.Mutex
+	flow *discoveredFlow
+}{}
+
+func processDiscovery(c *discoveryJSON) (*discoveryConfig, error) {
+	if c == nil {
+		return nil, nil
+	}
+	d := &discoveryConfig{correlations: []*celProgram{}, duration: defaultDiscoveryDuration, minShared: defaultDiscoveryMinShared}
+	for _, expr := range c.Correlations {
+		p, err := compileCEL(expr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid discovery correlation %v. err=%v", expr, err)
+		}
+		d.correlations = append(d.correlations, p)
+	}
+	if c.Seconds > 0 {
+		d.duration = time.Duration(c.Seconds * float64(time.Second))
+	}
+	if c.MinShared > 0 {
+		d.minShared = c.MinShared
+	}
+	return d, nil
+}
+
+func newDiscovery(c *discoveryConfig, now time.Time) *discovery {
+	if c == nil {
+		return nil
+	}
+	return &discovery{
+		discoveryConfig: c,
+		started:         now,
+		topics:          map[string]int64{},
+		last:            map[string]sighting{},
+		edges:           map[edge]*sharedIdentities{},
+	}
+}
+
+func (d *discovery) identities(m message) []string {
+	ids := []string{}
+	if len(m.Key) > 0 {
+		ids = append(ids, m.Key)
+	}
+	for _, p := range d.correlations {
+		if id, err := p.eval(m); err == nil && id != nil {
+			ids = append(ids, fmt.Sprint(id))
+		}
+	}
+	return ids
+}
+
+func (d *discovery) onMessage(m message) {
+	if d.done {
+		return
+	}
+	d.messages++
+	d.topics[m.Topic]++
+
+	counted := map[edge]bool{}
+	for _, id := range d.identities(m) {
+		last, seen := d.last[id]
+		if !seen && len(d.last) >= discoveryMaxIdentities {
+			continue
+		}
+		d.last[id] = sighting{topic: m.Topic, at: m.Timestamp}
+		if !seen || last.topic == m.Topic {
+			continue
+		}
+		e := edge{last.topic, m.Topic}
+		if counted[e] {
+			continue
+		}
+		counted[e] = true
+		if _, ok := d.edges[e]; !ok {
+			d.edges[e] = &sharedIdentities{}
+		}
+		d.edges[e].count++
+		if len(d.edges[e].delays) < discoveryMaxDelays {
+			d.edges[e].delays = append(d.edges[e].delays, m.Timestamp.Sub(last.at))
+		}
+	}
+}
+
+// report returns the discoveredFlow event once the observation period is
+// over.
+func (d *discovery) report(now time.Time) []event {
+	if d.done || now.Sub(d.started) < d.duration {
+		return nil
+	}
+	d.done = true
+	d.last = nil
+
+	flow := d.flow()
+	lastDiscovered.Lock()
+	lastDiscovered.flow = &flow
+	lastDiscovered.Unlock()
+
+	byt, err := json.Marshal(flow)
+	if err != nil {
+		return []event{{EventType: "log", Text: fmt.Sprintf("Could not encode the discovered flow. err=%v", err), Color: "error"}}
+	}
+	var suggestion map[string]interface{}
+	if err := json.Unmarshal(byt, &suggestion); err != nil {
+		return []event{{EventType: "log", Text: fmt.Sprintf("Could not encode the discovered flow. err=%v", err), Color: "error"}}
+	}
+	return []event{{
+		EventType: "discoveredFlow",
+		Text:      fmt.Sprintf("Discovered %v edges between %v topics from %v messages; save its components and rules as your flow config.", len(flow.Edges), len(flow.Components), d.messages),
+		JSON:      []map[string]interface{}{suggestion},
+		Color:     "happy",
+	}}
+}
+
+func (d *discovery) flow() discoveredFlow {
+	flow := discoveredFlow{Components: []discoveredComponent{}, Rules: []map[string]interface{}{}, Edges: []discoveredEdge{}}
+
+	// keep edges shared by enough identities, in their typical direction
+	for e, s := range d.edges {
+		if s.count < d.minShared {
+			continue
+		}
+		if r, ok := d.edges[edge{e.target, e.source}]; ok && (r.count > s.count || r.count == s.count && e.target < e.source) {
+			continue
+		}
+		sort.Slice(s.delays, func(i, j int) bool { return s.delays[i] < s.delays[j] })
+		median := float64(s.delays[len(s.delays)/2]) / float64(time.Millisecond)
+		flow.Edges = append(flow.Edges, discoveredEdge{From: e.source, To: e.target, Shared: s.count, MedianDelayMs: median})
+	}
+	sort.Slice(flow.Edges, func(i, j int) bool {
+		a, b := flow.Edges[i], flow.Edges[j]
+		return a.From < b.From || a.From == b.From && a.To < b.To
+	})
+
+	// the longest path from a topic without predecessors sets its depth;
+	// relaxing once per topic keeps cycles from looping forever
+	topics := []string{}
+	for t := range d.topics {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	depths := map[string]int{}
+	for range topics {
+		for _, e := range flow.Edges {
+			if depths[e.From]+1 > depths[e.To] && depths[e.From]+1 < len(topics) {
+				depths[e.To] = depths[e.From] + 1
+			}
+		}
+	}
+	columns := map[int]int{}
+	for _, t := range topics {
+		depth := depths[t]
+		flow.Components = append(flow.Components, discoveredComponent{Id: t, Top: 50 + 200*depth, Left: 70 + 230*columns[depth]})
+		columns[depth]++
+	}
+
+	predecessors := map[string]discoveredEdge{}
+	for _, e := range flow.Edges {
+		if p, ok := predecessors[e.To]; !ok || e.Shared > p.Shared {
+			predecessors[e.To] = e
+		}
+	}
+	for _, t := range topics {
+		p, ok := predecessors[t]
+		if !ok {
+			continue
+		}
+		flow.Rules = append(flow.Rules, map[string]interface{}{
+			"patterns": []map[string]string{{"field": "{{ .Topic }}", "pattern": "^" + regexp.QuoteMeta(t) + "$"}},
+			"events":   []map[string]interface{}{{"eventType": "message", "sourceId": p.From, "targetId": t, "text": t, "aggregate": true}},
+		})
+	}
+	return flow
+}
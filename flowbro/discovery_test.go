@@ -0,0 +1,63 @@
+package flowbro
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiscoveryInfersFlowFromSharedIdentities(t *testing.T) {
+	c, err := processDiscovery(&discoveryJSON{Correlations: []string{"value.orderId"}, Seconds: 30})
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	d := newDiscovery(c, start)
+
+	for i, id := range []string{"1", "2", "3"} {
+		at := start.Add(time.Duration(i) * time.Minute)
+		d.onMessage(message{Topic: "orders", Key: id, Timestamp: at})
+		d.onMessage(message{Topic: "payments", Value: newValueFrom(`{"orderId":"` + id + `"}`), Timestamp: at.Add(time.Second)})
+		d.onMessage(message{Topic: "shipments", Value: newValueFrom(`{"orderId":"` + id + `"}`), Timestamp: at.Add(3 * time.Second)})
+	}
+	d.onMessage(message{Topic: "audit", Key: "1", Timestamp: start.Add(time.Hour)})
+
+	if events := d.report(start.Add(10 * time.Second)); len(events) != 0 {
+		t.Errorf("expected nothing before the observation period is over but got %+v", events)
+	}
+
+	flow := d.flow()
+	expectedEdges := []discoveredEdge{
+		{From: "orders", To: "payments", Shared: 3, MedianDelayMs: 1000},
+		{From: "payments", To: "shipments", Shared: 3, MedianDelayMs: 2000},
+	}
+	if !reflect.DeepEqual(flow.Edges, expectedEdges) {
+		t.Errorf("expected edges %+v but got %+v", expectedEdges, flow.Edges)
+	}
+	expectedComponents := []discoveredComponent{
+		{Id: "audit", Top: 50, Left: 70},
+		{Id: "orders", Top: 50, Left: 300},
+		{Id: "payments", Top: 250, Left: 70},
+		{Id: "shipments", Top: 450, Left: 70},
+	}
+	if !reflect.DeepEqual(flow.Components, expectedComponents) {
+		t.Errorf("expected components %+v but got %+v", expectedComponents, flow.Components)
+	}
+	if len(flow.Rules) != 2 {
+		t.Errorf("expected a rule for payments and shipments but got %+v", flow.Rules)
+	}
+
+	events := d.report(start.Add(time.Minute))
+	if len(events) != 1 || events[0].EventType != "discoveredFlow" || len(events[0].JSON) != 1 {
+		t.Errorf("expected a discoveredFlow event but got %+v", events)
+	}
+	if events := d.report(start.Add(2 * time.Minute)); len(events) != 0 {
+		t.Errorf("expected the flow to only be reported once but got %+v", events)
+	}
+}
+
+func TestDiscoveryValidatesCorrelations(t *testing.T) {
+	if _, err := processDiscovery(&discoveryJSON{Correlations: []string{"value.("}}); err == nil {
+		t.Errorf("expected an invalid correlation to fail")
+	}
+}
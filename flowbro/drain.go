@@ -0,0 +1,126 @@
+package flowbro
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// drain lets a server be taken out of a rolling deploy without truncating
+// what its clients are looking at: once started, connections stop pulling
+// messages from Kafka, send the events of those they've buffered, flush
+// their sinks and close, and new connections are turned away. The server
+// shuts down once every connection is gone or the timeout is up.
+type drain struct {
+	l        sync.Mutex
+	timeout  time.Duration
+	started  time.Time
+	streams  int
+	draining chan struct{} // closed once started
+	drained  chan struct{} // closed once no connection is left, or on timeout
+	once     sync.Once
+}
+
+const defaultDrainTimeout = 30 * time.Second
+
+func newDrain(timeout time.Duration) *drain {
+	return &drain{timeout: timeout, draining: make(chan struct{}), drained: make(chan struct{})}
+}
+
+// enter counts a connection in, unless the server is draining.
+func (d *drain) enter() bool {
+	if d == nil {
+		return true
+	}
+	d.l.Lock()
+	defer d.l.Unlock()
+	if !d.started.IsZero() {
+		return false
+	}
+	d.streams++
+	return true
+}
+
+// leave counts a connection out once it has flushed everything.
+func (d *drain) leave() {
+	if d == nil {
+		return
+	}
+	d.l.Lock()
+	defer d.l.Unlock()
+	if d.streams--; d.streams == 0 && !d.started.IsZero() {
+		d.finish()
+	}
+}
+
+// start drains the server, returning false if it already was.
+func (d *drain) start(now time.Time) bool {
+	d.l.Lock()
+	defer d.l.Unlock()
+	if !d.started.IsZero() {
+		return false
+	}
+	d.started = now
+	close(d.draining)
+	if d.streams == 0 {
+		d.finish()
+	}
+	time.AfterFunc(d.timeout, d.finish)
+	return true
+}
+
+func (d *drain) finish() {
+	d.once.Do(func() { close(d.drained) })
+}
+
+// signal is closed once the server starts draining.
+func (d *drain) signal() <-chan struct{} {
+	if d == nil {
+		return nil
+	}
+	return d.draining
+}
+
+// done is closed once the server has drained and may shut down.
+func (d *drain) done() <-chan struct{} {
+	if d == nil {
+		return nil
+	}
+	return d.drained
+}
+
+func (d *drain) status() map[string]interface{} {
+	d.l.Lock()
+	defer d.l.Unlock()
+	s := map[string]interface{}{"draining": !d.started.IsZero(), "connections": d.streams}
+	if !d.started.IsZero() {
+		s["since"] = d.started
+		s["deadline"] = d.started.Add(d.timeout)
+	}
+	return s
+}
+
+// handler serves /api/drain: GET tells whether the server is draining and
+// how many connections are still flushing, POST starts draining it. POSTs
+// must be JSON, which browsers don't send cross-site without a CORS
+// preflight, so that other sites can't shut the server down.
+func (d *drain) handler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, d.status())
+	case "POST":
+		if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+			writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("Please POST to /api/drain with Content-Type application/json"))
+			return
+		}
+		status := http.StatusOK
+		if d.start(time.Now().UTC()) {
+			status = http.StatusAccepted
+		}
+		writeJSON(w, status, d.status())
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+	}
+}
@@ -0,0 +1,71 @@
+package flowbro
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDrainWaitsForConnectionsToLeave(t *testing.T) {
+	d := newDrain(time.Minute)
+	d.enter()
+	d.enter()
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		status      int
+		leave       bool
+		drained     bool
+	}{
+		{name: "status", method: "GET", status: http.StatusOK},
+		{name: "cross-site form", method: "POST", contentType: "application/x-www-form-urlencoded", status: http.StatusUnsupportedMediaType},
+		{name: "no content type", method: "POST", status: http.StatusUnsupportedMediaType},
+		{name: "start", method: "POST", contentType: "application/json; charset=utf-8", status: http.StatusAccepted},
+		{name: "already draining", method: "POST", contentType: "application/json", status: http.StatusOK},
+		{name: "one left", method: "GET", status: http.StatusOK, leave: true},
+		{name: "none left", method: "GET", status: http.StatusOK, leave: true, drained: true},
+		{name: "delete", method: "DELETE", status: http.StatusMethodNotAllowed, drained: true},
+	}
+	for _, ts := range tests {
+		if ts.leave {
+			d.leave()
+		}
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(ts.method, "/api/drain", nil)
+		if len(ts.contentType) > 0 {
+			r.Header.Set("Content-Type", ts.contentType)
+		}
+		d.handler(w, r)
+		if w.Code != ts.status {
+			t.Errorf("on '%v': expected status %v but got %v: %v", ts.name, ts.status, w.Code, w.Body.String())
+		}
+		select {
+		case <-d.done():
+			if !ts.drained {
+				t.Errorf("on '%v': expected connections to still be draining", ts.name)
+			}
+		default:
+			if ts.drained {
+				t.Errorf("on '%v': expected every connection to have drained", ts.name)
+			}
+		}
+	}
+	if d.enter() {
+		t.Errorf("expected new connections to be turned away once draining")
+	}
+}
+
+func TestDrainGivesUpAtTheTimeout(t *testing.T) {
+	d := newDrain(10 * time.Millisecond)
+	d.enter()
+	d.start(time.Now())
+	select {
+	case <-d.done():
+	case <-time.After(time.Second):
+		t.Errorf("expected draining to end at the timeout even though a connection is left")
+	}
+	d.leave()
+}
@@ -0,0 +1,151 @@
+package flowbro
+
+import (
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type schemaDriftJSON struct {
+	Topic string `json:"topic"`
+}
+
+// schemaDrift remembers the fields, and their types, and the schema ids seen
+// on every topic matching its regex, and reports new ones so accidental
+// producer-side schema changes show up in the flow view. The first message
+// of a topic sets its baseline.
+type schemaDrift struct {
+	topic     *regexp.Regexp
+	fields    map[string]map[string]string
+	schemaIDs map[string]map[int32]bool
+}
+
+func processSchemaDrift(c *schemaDriftJSON) (*schemaDrift, error) {
+	if c == nil {
+		return nil, nil
+	}
+	src := c.Topic
+	if len(src) == 0 {
+		src = ".*"
+	}
+	topic, err := regexp.Compile("^(?:" + src + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid topic regex %v for schemaDrift. err=%v", c.Topic, err)
+	}
+	return &schemaDrift{topic: topic, fields: map[string]map[string]string{}, schemaIDs: map[string]map[int32]bool{}}, nil
+}
+
+// check returns schemaDrift events for the fields and schema id of m that
+// are new or changed type on its topic. schemaID is negative for values not
+// framed by a schema registry serializer.
+func (d *schemaDrift) check(m message, schemaID int32) []event {
+	if !d.topic.MatchString(m.Topic) {
+		return nil
+	}
+	changes := []string{}
+
+	if schemaID >= 0 {
+		ids, baseline := d.schemaIDs[m.Topic]
+		if !baseline {
+			ids = map[int32]bool{}
+			d.schemaIDs[m.Topic] = ids
+		}
+		if !ids[schemaID] && baseline {
+			changes = append(changes, fmt.Sprintf("new schema id %v", schemaID))
+		}
+		ids[schemaID] = true
+	}
+
+	types := map[string]string{}
+	fieldTypes(m.Value, "", types)
+	known, baseline := d.fields[m.Topic]
+	if !baseline {
+		known = map[string]string{}
+		d.fields[m.Topic] = known
+	}
+	added, changed := []string{}, []string{}
+	for f, t := range types {
+		previous, ok := known[f]
+		switch {
+		case !ok && baseline:
+			added = append(added, fmt.Sprintf("%v (%v)", f, t))
+		case ok && previous != t:
+			changed = append(changed, fmt.Sprintf("%v from %v to %v", f, previous, t))
+		}
+		known[f] = t
+	}
+	sort.Strings(added)
+	sort.Strings(changed)
+	if len(added) > 0 {
+		changes = append(changes, "new fields "+strings.Join(added, ", "))
+	}
+	if len(changed) > 0 {
+		changes = append(changes, "changed types of "+strings.Join(changed, ", "))
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+	partition := m.Partition
+	return []event{{
+		EventType: "schemaDrift",
+		Text:      fmt.Sprintf("Schema of topic %v drifted at offset %v of partition %v: %v.", m.Topic, m.Offset, m.Partition, strings.Join(changes, "; ")),
+		Color:     "warning",
+		Cluster:   m.Cluster,
+		Topic:     m.Topic,
+		Partition: &partition,
+	}}
+}
+
+// fieldTypes records the JSON type of every field in v by its dotted path,
+// with [] standing for array elements. Nulls are skipped, as optional fields
+// are commonly null.
+func fieldTypes(v interface{}, path string, types map[string]string) {
+	t := ""
+	switch tv := v.(type) {
+	case nil:
+		return
+	case map[string]interface{}:
+		t = "object"
+		for k, child := range tv {
+			if len(path) == 0 {
+				fieldTypes(child, k, types)
+			} else {
+				fieldTypes(child, path+"."+k, types)
+			}
+		}
+	case []interface{}:
+		t = "array"
+		for _, child := range tv {
+			fieldTypes(child, path+"[]", types)
+		}
+	case string:
+		t = "string"
+	case bool:
+		t = "bool"
+	default:
+		if _, ok := celNumber(v); ok {
+			t = "number"
+		} else {
+			t = fmt.Sprintf("%T", v)
+		}
+	}
+	if len(path) > 0 {
+		types[path] = t
+	}
+}
+
+// valueSchemaID returns the schema id of a value framed by a schema registry
+// serializer, or -1.
+func valueSchemaID(b []byte, d decoding) int32 {
+	if d.format != "avro" && d.format != "registry" && d.registry == nil {
+		return -1
+	}
+	b, err := decompress(b, d.compression, d.maxDecompressed)
+	if err != nil || len(b) < 5 || b[0] != 0 {
+		return -1
+	}
+	return int32(binary.BigEndian.Uint32(b[1:5]))
+}
@@ -0,0 +1,68 @@
+package flowbro
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSchemaDrift(t *testing.T) {
+	d, err := processSchemaDrift(&schemaDriftJSON{Topic: "orders"})
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		m        message
+		schemaID int32
+		expected []string
+	}{
+		{name: "baseline", m: message{Topic: "orders", Value: newValueFrom(`{"id":1,"items":[{"sku":"a"}]}`)}, schemaID: 1},
+		{name: "same schema", m: message{Topic: "orders", Value: newValueFrom(`{"id":2,"items":[]}`)}, schemaID: 1},
+		{name: "nulls are ignored", m: message{Topic: "orders", Value: newValueFrom(`{"id":null}`)}, schemaID: 1},
+		{name: "new field", m: message{Topic: "orders", Value: newValueFrom(`{"id":3,"items":[{"sku":"a","qty":1}]}`)}, schemaID: 1, expected: []string{"new fields items[].qty (number)"}},
+		{name: "changed type", m: message{Topic: "orders", Value: newValueFrom(`{"id":"4"}`)}, schemaID: 1, expected: []string{"changed types of id from number to string"}},
+		{name: "new schema id", m: message{Topic: "orders", Value: newValueFrom(`{"id":"5"}`)}, schemaID: 2, expected: []string{"new schema id 2"}},
+		{name: "unchecked topic", m: message{Topic: "users", Value: newValueFrom(`{"id":1}`)}, schemaID: -1},
+		{name: "values without schema id", m: message{Topic: "orders", Value: newValueFrom(`{"id":"6","note":"x"}`)}, schemaID: -1, expected: []string{"new fields note (string)"}},
+	}
+
+	for _, ts := range tests {
+		events := d.check(ts.m, ts.schemaID)
+		if len(ts.expected) == 0 {
+			if len(events) > 0 {
+				t.Errorf("on '%v': expected no events but got %+v", ts.name, events)
+			}
+			continue
+		}
+		if len(events) != 1 || events[0].EventType != "schemaDrift" {
+			t.Errorf("on '%v': expected a schemaDrift event but got %+v", ts.name, events)
+			continue
+		}
+		for _, e := range ts.expected {
+			if !strings.Contains(events[0].Text, e) {
+				t.Errorf("on '%v': expected %q to mention %q", ts.name, events[0].Text, e)
+			}
+		}
+	}
+}
+
+func TestValueSchemaID(t *testing.T) {
+	framed := []byte{0, 0, 0, 1, 2, 'x'}
+	tests := []struct {
+		name     string
+		b        []byte
+		d        decoding
+		expected int32
+	}{
+		{name: "framed registry value", b: framed, d: decoding{format: "registry"}, expected: 258},
+		{name: "plain json", b: framed, d: decoding{format: "json"}, expected: -1},
+		{name: "not framed", b: []byte(`{"a":1}`), d: decoding{format: "avro"}, expected: -1},
+	}
+
+	for _, ts := range tests {
+		if actual := valueSchemaID(ts.b, ts.d); actual != ts.expected {
+			t.Errorf("on '%v': expected %v but got %v", ts.name, ts.expected, actual)
+		}
+	}
+}
@@ -1,29 +1,21 @@
-package main
+package flowbro
 
 import (
 	"bytes"
-	"regexp"
+	"encoding/base64"
 	"text/template"
 )
 
 func processMessage(m message, rules []rule, fsmIdAliases map[string]string, events *[]event, incompleteEvents *[]event, globalFSMId string) error {
 	for _, r := range rules {
-		pass := true
-		for _, p := range r.Patterns {
-			b, err := parseTempl(p.Field, m)
-			if err != nil {
-				return err
-			}
-
-			matched, err := regexp.Match(p.Pattern, b)
-			if err != nil {
+		pass, err := matchPatterns(r.Patterns, m)
+		if err != nil {
+			return err
+		}
+		if pass && r.expr != nil {
+			if pass, err = r.expr.match(m); err != nil {
 				return err
 			}
-
-			if !matched {
-				pass = false
-				break
-			}
 		}
 		if !pass {
 			continue
@@ -80,20 +72,27 @@ func processMessage(m message, rules []rule, fsmIdAliases map[string]string, eve
 			}
 
 			json := []map[string]interface{}{}
-			if !e.NoJSON {
+			if !e.NoJSON && m.Output != nil {
+				json = []map[string]interface{}{m.Output}
+			} else if !e.NoJSON {
 				json = []map[string]interface{}{m.Value}
 			}
 
 			if len(fsmId) == 0 && len(fsmIdAlias) > 0 {
 				*incompleteEvents = append(*incompleteEvents, event{
-					EventType:  string(bEventType),
-					FSMIdAlias: fsmIdAlias,
-					SourceId:   string(bSourceId),
-					TargetId:   string(bTargetId),
-					Text:       string(bText),
-					JSON:       json,
-					Aggregate:  e.Aggregate,
-					Highlight:  e.Highlight,
+					EventType:   string(bEventType),
+					FSMIdAlias:  fsmIdAlias,
+					SourceId:    string(bSourceId),
+					TargetId:    string(bTargetId),
+					Text:        string(bText),
+					JSON:        json,
+					Aggregate:   e.Aggregate,
+					Highlight:   e.Highlight,
+					Cluster:     m.Cluster,
+					Brokers:     m.Brokers,
+					View:        m.View,
+					ContentType: m.ContentType,
+					Diff:        m.Diff,
 				})
 				continue
 			}
@@ -108,15 +107,28 @@ func processMessage(m message, rules []rule, fsmIdAliases map[string]string, eve
 			}
 
 			newE := event{
-				EventType: string(bEventType),
-				FSMId:     fsmId,
-				SourceId:  string(bSourceId),
-				TargetId:  string(bTargetId),
-				Text:      string(bText),
-				JSON:      json,
-				Count:     count,
-				Aggregate: e.Aggregate,
-				Highlight: e.Highlight,
+				EventType:   string(bEventType),
+				FSMId:       fsmId,
+				SourceId:    string(bSourceId),
+				TargetId:    string(bTargetId),
+				Text:        string(bText),
+				JSON:        json,
+				Count:       count,
+				Aggregate:   e.Aggregate,
+				Highlight:   e.Highlight,
+				Tags:        m.Tags,
+				Cluster:     m.Cluster,
+				Brokers:     m.Brokers,
+				View:        m.View,
+				ContentType: m.ContentType,
+				Diff:        m.Diff,
+			}
+			if m.KeyValue != nil {
+				newE.Key, newE.KeyRaw = m.KeyValue, base64.StdEncoding.EncodeToString(m.KeyRaw)
+			}
+			if len(m.TimestampType) > 0 {
+				timestamp := m.Timestamp
+				newE.Timestamp, newE.TimestampType = &timestamp, m.TimestampType
 			}
 
 			*events = aggregate(*events, newE, e.Aggregate, globalFSMId)
@@ -1,4 +1,4 @@
-package main
+package flowbro
 
 import (
 	"encoding/json"
@@ -51,6 +51,26 @@ func TestProcessMessage(t *testing.T) {
 			},
 			expectedFa: map[string]string{},
 		},
+		{
+			name: "kafka timestamps are included",
+			m: message{
+				Value:         newValueFrom("{}"),
+				Topic:         "topic",
+				Timestamp:     now,
+				TimestampType: "LogAppendTime",
+			},
+			rs: []rule{
+				{
+					Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "topic"}},
+					Events:   []event{{EventType: "message", SourceId: "A", TargetId: "B", FSMId: "456"}},
+				},
+			},
+			fa: map[string]string{},
+			expectedEvents: []event{
+				{EventType: "message", SourceId: "A", TargetId: "B", FSMId: "456", JSON: newSliceFrom("{}"), Count: 1, Timestamp: &now, TimestampType: "LogAppendTime"},
+			},
+			expectedFa: map[string]string{},
+		},
 		{
 			name: "matching topic name and producing 2 events",
 			m: message{
@@ -0,0 +1,393 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// flowJSON is the part of a config that draws its diagram: the components,
+// their colours and the rules mapping messages to events between them.
+type flowJSON struct {
+	Title         string          `json:"title"`
+	Components    json.RawMessage `json:"components"`
+	Rules         json.RawMessage `json:"rules"`
+	ColourPalette json.RawMessage `json:"colourPalette,omitempty"`
+}
+
+type componentJSON struct {
+	Id              string `json:"id"`
+	Icon            string `json:"icon"` // the URL of an image to show in the component
+	BackgroundColor string `json:"backgroundColor"`
+}
+
+type flowEdgeJSON struct {
+	SourceId string `json:"sourceId"`
+	TargetId string `json:"targetId"`
+}
+
+var cssColor = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+|(rgb|rgba|hsl|hsla)\([0-9.,%\s]+\))$`)
+
+// flowRevision is a version of the flow of a config, edited on /api/flow or
+// reloaded from its file.
+type flowRevision struct {
+	version int64
+	flow    flowJSON
+	rules   []rule
+	filter  string // the config's kafka filter
+}
+
+// flows serves the diagrams of the configs in dir on /api/flow, and hands
+// the revisions of those edited there or on disk to the connections
+// streaming them.
+type flows struct {
+	dir string
+
+	l         sync.Mutex
+	revisions map[string]flowRevision
+	modified  map[string]time.Time // of the files, when last read
+	scanned   bool
+}
+
+// flowReloadInterval is how often config files are checked for changes.
+const flowReloadInterval = 2 * time.Second
+
+func newFlows(dir string) *flows {
+	return &flows{dir: dir, revisions: map[string]flowRevision{}, modified: map[string]time.Time{}}
+}
+
+// read returns the keys of the config named name.
+func (fl *flows) read(name string) (map[string]json.RawMessage, error) {
+	if !safeName(name) {
+		return nil, fmt.Errorf("Invalid config name %v", name)
+	}
+	byt, err := ioutil.ReadFile(filepath.Join(fl.dir, name+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("Could not read config %v. err=%v", name, err)
+	}
+	keys := map[string]json.RawMessage{}
+	if err := json.Unmarshal(byt, &keys); err != nil {
+		return nil, fmt.Errorf("Invalid config %v. err=%v", name, err)
+	}
+	return keys, nil
+}
+
+func (fl *flows) write(name string, keys map[string]json.RawMessage) error {
+	byt, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(fl.dir, name+".json")
+	if err := ioutil.WriteFile(path+".tmp", byt, 0644); err != nil {
+		return fmt.Errorf("Could not write config %v. err=%v", name, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil {
+		fl.modified[name] = info.ModTime()
+	}
+	return nil
+}
+
+// publish makes the flow of the config keys, whose rules are validated, the
+// next revision of name.
+func (fl *flows) publish(name string, keys map[string]json.RawMessage, rules []rule) int64 {
+	r := flowRevision{version: fl.revisions[name].version + 1, flow: flowOf(keys), rules: rules}
+	var k struct {
+		Filter string `json:"filter"`
+	}
+	json.Unmarshal(keys["kafka"], &k)
+	r.filter = k.Filter
+	fl.revisions[name] = r
+	return r.version
+}
+
+func flowOf(keys map[string]json.RawMessage) flowJSON {
+	var f flowJSON
+	json.Unmarshal(keys["title"], &f.Title)
+	f.Components, f.Rules, f.ColourPalette = keys["components"], keys["rules"], keys["colourPalette"]
+	return f
+}
+
+// reload publishes the flows of the config files changed since they were
+// last read, returning their names. Files that fail to validate are logged
+// and skipped until they change again. The first reload only takes note of
+// the files.
+func (fl *flows) reload() []string {
+	files, err := ioutil.ReadDir(fl.dir)
+	if err != nil {
+		log.Printf("Could not read configs to reload. err=%v\n", err)
+		return nil
+	}
+	fl.l.Lock()
+	defer fl.l.Unlock()
+	first := !fl.scanned
+	fl.scanned = true
+	reloaded := []string{}
+	for _, file := range files {
+		if filepath.Ext(file.Name()) != ".json" {
+			continue
+		}
+		name := strings.TrimSuffix(file.Name(), ".json")
+		if seen, ok := fl.modified[name]; ok && seen.Equal(file.ModTime()) {
+			continue
+		}
+		fl.modified[name] = file.ModTime()
+		if first {
+			continue
+		}
+		keys, err := fl.read(name)
+		if err == nil {
+			var rules []rule
+			if rules, err = validateFlow(keys, flowOf(keys)); err == nil {
+				fl.publish(name, keys, rules)
+				reloaded = append(reloaded, name)
+				continue
+			}
+		}
+		log.Printf("Not reloading config %v. err=%v\n", name, err)
+	}
+	return reloaded
+}
+
+// watch reloads the config files every interval until ctx is done.
+func (fl *flows) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fl.reload()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// version returns the latest revision of the flow of name; 0 if it was
+// neither edited nor reloaded.
+func (fl *flows) version(name string) int64 {
+	if fl == nil {
+		return 0
+	}
+	fl.l.Lock()
+	defer fl.l.Unlock()
+	return fl.revisions[name].version
+}
+
+// since returns the latest revision of the flow of name, its rules
+// compiled, if it's newer than version.
+func (fl *flows) since(name string, version int64) (flowRevision, bool) {
+	if fl == nil || len(name) == 0 {
+		return flowRevision{}, false
+	}
+	fl.l.Lock()
+	r := fl.revisions[name]
+	r.rules = append([]rule{}, r.rules...)
+	fl.l.Unlock()
+	if r.version <= version {
+		return flowRevision{}, false
+	}
+	if err := processRules(r.rules); err != nil {
+		return flowRevision{}, false
+	}
+	return r, true
+}
+
+// applyFlowRevision makes a connection's rules and filter those of r,
+// returning the event that tells its client. The filter stays as it was if
+// it doesn't compile along with the connection's own.
+func applyFlowRevision(config *config, r flowRevision, rules *[]rule) event {
+	*rules = r.rules
+	e := r.event(config.flowName)
+	filter := andFilter(r.filter, config.extraFilter)
+	if len(filter) == 0 {
+		config.filter = nil
+		return e
+	}
+	f, err := compileCEL(filter)
+	if err != nil {
+		e.Text, e.Color = fmt.Sprintf("%v; kept the previous filter as the new one is invalid. err=%v", e.Text, err), "error"
+		return e
+	}
+	config.filter = f
+	return e
+}
+
+// event is the configUpdate event telling clients to redraw the flow.
+func (r flowRevision) event(name string) event {
+	return configUpdate(fmt.Sprintf("Applied version %v of config %v", r.version, name), r.flow, r.filter)
+}
+
+// configUpdate is the event telling clients to draw the flow fl, filtered by
+// filter.
+func configUpdate(text string, fl flowJSON, filter string) event {
+	return event{
+		EventType: "configUpdate",
+		Text:      text,
+		JSON: []map[string]interface{}{{
+			"title":         fl.Title,
+			"components":    fl.Components,
+			"rules":         fl.Rules,
+			"colourPalette": fl.ColourPalette,
+			"filter":        filter,
+		}},
+	}
+}
+
+// validateFlow checks the flow f would make of the config keys, returning
+// its rules.
+func validateFlow(keys map[string]json.RawMessage, f flowJSON) ([]rule, error) {
+	components := []componentJSON{}
+	if err := json.Unmarshal(f.Components, &components); err != nil {
+		return nil, fmt.Errorf("Invalid components. err=%v", err)
+	}
+	known := map[string]bool{}
+	for i, c := range components {
+		if len(c.Id) == 0 {
+			return nil, fmt.Errorf("Please define the id of component %v", i)
+		}
+		if known[c.Id] {
+			return nil, fmt.Errorf("Component %v is defined more than once", c.Id)
+		}
+		known[c.Id] = true
+		if len(c.BackgroundColor) > 0 && !cssColor.MatchString(c.BackgroundColor) {
+			return nil, fmt.Errorf("Invalid backgroundColor %v for component %v", c.BackgroundColor, c.Id)
+		}
+		if len(c.Icon) > 0 {
+			u, err := url.Parse(c.Icon)
+			if err != nil || (u.Scheme != "" && u.Scheme != "http" && u.Scheme != "https") {
+				return nil, fmt.Errorf("Invalid icon %v for component %v; use a path or an http(s) URL", c.Icon, c.Id)
+			}
+		}
+	}
+
+	rules := []rule{}
+	if err := json.Unmarshal(f.Rules, &rules); err != nil {
+		return nil, fmt.Errorf("Invalid rules. err=%v", err)
+	}
+	for i, r := range rules {
+		for _, p := range r.Patterns {
+			if _, err := template.New("").Parse(p.Field); err != nil {
+				return nil, fmt.Errorf("Invalid field %v in rule %v. err=%v", p.Field, i, err)
+			}
+			if _, err := regexp.Compile(p.Pattern); err != nil {
+				return nil, fmt.Errorf("Invalid pattern %v in rule %v. err=%v", p.Pattern, i, err)
+			}
+		}
+		for _, e := range r.Events {
+			for _, s := range []string{e.EventType, e.FSMId, e.FSMIdAlias, e.SourceId, e.TargetId, e.Text} {
+				if _, err := template.New("").Parse(s); err != nil {
+					return nil, fmt.Errorf("Invalid template %v in rule %v. err=%v", s, i, err)
+				}
+			}
+			for _, id := range []string{e.SourceId, e.TargetId} {
+				if len(id) > 0 && !strings.Contains(id, "{{") && !known[id] {
+					return nil, fmt.Errorf("Rule %v sends events between %v and %v, but %v isn't a component", i, e.SourceId, e.TargetId, id)
+				}
+			}
+		}
+	}
+
+	merged := map[string]json.RawMessage{}
+	for k, v := range keys {
+		merged[k] = v
+	}
+	title, _ := json.Marshal(f.Title)
+	merged["title"], merged["components"], merged["rules"] = title, f.Components, f.Rules
+	if len(f.ColourPalette) > 0 {
+		merged["colourPalette"] = f.ColourPalette
+	}
+	byt, _ := json.Marshal(merged)
+	var c configJSON
+	if err := json.Unmarshal(byt, &c); err != nil {
+		return nil, fmt.Errorf("Invalid config. err=%v", err)
+	}
+	if _, err := processConfig(&c); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// handler serves the flow of the config named by the config query
+// parameter on GET, along with the edges its rules animate messages along,
+// and replaces it on PUT. Connections streaming the config apply the new
+// revision right away.
+func (fl *flows) handler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("config")
+	switch r.Method {
+	case "GET":
+		keys, err := fl.read(name)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		f := flowOf(keys)
+		g, err := configGraph(fl.dir, name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		edges := []flowEdgeJSON{}
+		for _, e := range g.edges {
+			edges = append(edges, flowEdgeJSON{SourceId: e.source, TargetId: e.target})
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"config":        name,
+			"version":       fl.version(name),
+			"title":         f.Title,
+			"components":    f.Components,
+			"rules":         f.Rules,
+			"colourPalette": f.ColourPalette,
+			"edges":         edges,
+		})
+	case "PUT":
+		var f flowJSON
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid flow. err=%v", err))
+			return
+		}
+		if len(f.Components) == 0 || len(f.Rules) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Please define the components and rules of the flow"))
+			return
+		}
+
+		fl.l.Lock()
+		defer fl.l.Unlock()
+		keys, err := fl.read(name)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		rules, err := validateFlow(keys, f)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		title, _ := json.Marshal(f.Title)
+		keys["title"], keys["components"], keys["rules"] = title, f.Components, f.Rules
+		if len(f.ColourPalette) > 0 {
+			keys["colourPalette"] = f.ColourPalette
+		}
+		if err := fl.write(name, keys); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"config": name, "version": fl.publish(name, keys, rules)})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+	}
+}
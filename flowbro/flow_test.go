@@ -0,0 +1,151 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlowsServeAndReplaceTheDiagram(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+	config := `{"title": "Shop", "kafka": {"brokers": "localhost:9092", "topics": ["orders"]}, "components": [{"id": "Web"}, {"id": "Orders"}], "rules": [
+		{"patterns": [{"field": "{{ .Topic }}", "pattern": "orders"}], "events": [{"eventType": "message", "sourceId": "Web", "targetId": "Orders"}]}
+	]}`
+	ioutil.WriteFile(filepath.Join(dir, "shop.json"), []byte(config), 0644)
+	fl := newFlows(dir)
+
+	components := `[{"id": "Web", "icon": "images/web.png", "backgroundColor": "#3f51b5"}, {"id": "Orders"}, {"id": "Payments"}]`
+	rules := `[{"patterns": [{"field": "{{ .Topic }}", "pattern": "payments"}], "events": [{"eventType": "message", "sourceId": "Orders", "targetId": "Payments"}]}]`
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{name: "get", method: "GET", path: "/api/flow?config=shop", status: http.StatusOK},
+		{name: "unknown config", method: "GET", path: "/api/flow?config=other", status: http.StatusNotFound},
+		{name: "outside the configs", method: "GET", path: "/api/flow?config=../shop", status: http.StatusNotFound},
+		{name: "no rules", method: "PUT", path: "/api/flow?config=shop", body: `{"components": ` + components + `}`, status: http.StatusBadRequest},
+		{name: "duplicate component", method: "PUT", path: "/api/flow?config=shop", body: `{"components": [{"id": "Web"}, {"id": "Web"}], "rules": []}`, status: http.StatusBadRequest},
+		{name: "unknown component", method: "PUT", path: "/api/flow?config=shop", body: `{"components": [{"id": "Web"}], "rules": ` + rules + `}`, status: http.StatusBadRequest},
+		{name: "script icon", method: "PUT", path: "/api/flow?config=shop", body: `{"components": [{"id": "Web", "icon": "javascript:alert(1)"}], "rules": []}`, status: http.StatusBadRequest},
+		{name: "invalid colour", method: "PUT", path: "/api/flow?config=shop", body: `{"components": [{"id": "Web", "backgroundColor": "red;display:none"}], "rules": []}`, status: http.StatusBadRequest},
+		{name: "invalid template", method: "PUT", path: "/api/flow?config=shop", body: `{"components": ` + components + `, "rules": [{"events": [{"eventType": "log", "text": "{{ .Value"}]}]}`, status: http.StatusBadRequest},
+		{name: "invalid expr", method: "PUT", path: "/api/flow?config=shop", body: `{"components": ` + components + `, "rules": [{"expr": "value.a ==", "events": []}]}`, status: http.StatusBadRequest},
+		{name: "put", method: "PUT", path: "/api/flow?config=shop", body: `{"title": "Shop v2", "components": ` + components + `, "rules": ` + rules + `}`, status: http.StatusOK},
+		{name: "delete", method: "DELETE", path: "/api/flow?config=shop", status: http.StatusMethodNotAllowed},
+	}
+	for _, ts := range tests {
+		w := httptest.NewRecorder()
+		fl.handler(w, httptest.NewRequest(ts.method, ts.path, strings.NewReader(ts.body)))
+		if w.Code != ts.status {
+			t.Errorf("on '%v': expected status %v but got %v: %v", ts.name, ts.status, w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	fl.handler(w, httptest.NewRequest("GET", "/api/flow?config=shop", nil))
+	var actual struct {
+		Title      string          `json:"title"`
+		Version    int64           `json:"version"`
+		Components []componentJSON `json:"components"`
+		Edges      []flowEdgeJSON  `json:"edges"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &actual)
+	if actual.Title != "Shop v2" || actual.Version != 1 || len(actual.Components) != 3 || actual.Components[0].Icon != "images/web.png" ||
+		len(actual.Edges) != 1 || actual.Edges[0] != (flowEdgeJSON{SourceId: "Orders", TargetId: "Payments"}) {
+		t.Errorf("expected the replaced flow but got %v", w.Body.String())
+	}
+	if _, c, err := readBoard(dir, "shop"); err != nil || c.Kafka.Brokers != "localhost:9092" {
+		t.Errorf("expected the rest of the config to be kept but got %+v and %v", c, err)
+	}
+}
+
+func TestFlowsHandRevisionsToConnections(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "shop.json"), []byte(`{"components": [{"id": "Web"}], "rules": []}`), 0644)
+	fl := newFlows(dir)
+
+	version := fl.version("shop")
+	if _, ok := fl.since("shop", version); ok {
+		t.Errorf("expected no revision before the flow is edited")
+	}
+	body := `{"components": [{"id": "Web"}, {"id": "Orders"}], "rules": [{"expr": "topic == 'orders'", "events": [{"eventType": "message", "sourceId": "Web", "targetId": "Orders"}]}]}`
+	fl.handler(httptest.NewRecorder(), httptest.NewRequest("PUT", "/api/flow?config=shop", strings.NewReader(body)))
+
+	r, ok := fl.since("shop", version)
+	if !ok || r.version != 1 || len(r.rules) != 1 || r.rules[0].expr == nil {
+		t.Fatalf("expected the edited rules, compiled, but got %+v (ok %v)", r, ok)
+	}
+	if _, ok := fl.since("shop", r.version); ok {
+		t.Errorf("expected no revision once the latest was applied")
+	}
+	if _, ok := fl.since("other", 0); ok {
+		t.Errorf("expected no revision for configs that weren't edited")
+	}
+	if reloaded := fl.reload(); len(reloaded) != 0 {
+		t.Errorf("expected the edit not to be reloaded from disk again but got %v", reloaded)
+	}
+}
+
+func TestFlowsReloadChangedFiles(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+	write := func(name, config string, age time.Duration) {
+		path := filepath.Join(dir, name+".json")
+		ioutil.WriteFile(path, []byte(config), 0644)
+		os.Chtimes(path, time.Now().Add(-age), time.Now().Add(-age))
+	}
+	write("shop", `{"kafka": {"filter": "key != ''"}, "components": [{"id": "Web"}], "rules": []}`, time.Hour)
+	write("bank", `{"components": [{"id": "Teller"}], "rules": []}`, time.Hour)
+	fl := newFlows(dir)
+
+	tests := []struct {
+		name     string
+		change   func()
+		expected []string
+	}{
+		{name: "first scan", change: func() {}, expected: []string{}},
+		{name: "unchanged", change: func() {}, expected: []string{}},
+		{name: "changed", change: func() {
+			write("shop", `{"kafka": {"filter": "key == 'vip'"}, "components": [{"id": "Web"}, {"id": "Orders"}], "rules": []}`, 0)
+		}, expected: []string{"shop"}},
+		{name: "invalid", change: func() {
+			write("bank", `{"components": [{"id": "Teller"}], "rules": [{"events": [{"eventType": "message", "sourceId": "Teller", "targetId": "Vault"}]}]}`, 0)
+		}, expected: []string{}},
+		{name: "added", change: func() { write("zoo", `{"components": [], "rules": []}`, 0) }, expected: []string{"zoo"}},
+	}
+	for _, ts := range tests {
+		ts.change()
+		if actual := fl.reload(); strings.Join(actual, ",") != strings.Join(ts.expected, ",") {
+			t.Errorf("on '%v': expected %v to be reloaded but got %v", ts.name, ts.expected, actual)
+		}
+	}
+
+	r, ok := fl.since("shop", 0)
+	if !ok {
+		t.Fatalf("expected a revision of the changed config")
+	}
+	config := &config{flowName: "shop", extraFilter: "partition == 0"}
+	var rules []rule
+	e := applyFlowRevision(config, r, &rules)
+	if e.EventType != "configUpdate" || e.Color == "error" || e.JSON[0]["filter"] != "key == 'vip'" || string(e.JSON[0]["components"].(json.RawMessage)) != `[{"id": "Web"}, {"id": "Orders"}]` {
+		t.Errorf("expected a configUpdate with the new components and filter but got %+v", e)
+	}
+	if config.filter == nil || config.filter.src != "(key == 'vip') && (partition == 0)" {
+		t.Errorf("expected the new filter along with the connection's own but got %+v", config.filter)
+	}
+	r.filter = "key =="
+	if e := applyFlowRevision(config, r, &rules); e.Color != "error" || config.filter.src != "(key == 'vip') && (partition == 0)" {
+		t.Errorf("expected an invalid filter to be reported and the previous one kept but got %+v and %+v", e, config.filter)
+	}
+}
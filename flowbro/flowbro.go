@@ -0,0 +1,337 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+type flowbro struct {
+	mockPath    string
+	dataDir     string
+	scriptsDir  string
+	wasmRuntime string
+	boardsDir   string
+	sessions    *sessions
+	shares      *shares
+	auth        authenticator
+	grafana     *grafanaHistory
+	search      *searchIndex
+	queries     *savedQueries
+	flows       *flows
+	drain       *drain
+	bus         *busFrontend
+	alerts      *serverAlerts
+	forwarders  *forwarders
+
+	newSource        func(config *config, f fsm, status func(event) error) (Source, string)
+	decoders         []decoderJSON
+	filter           string
+	redactions       []redactionJSON
+	heartbeatTimeout time.Duration
+}
+
+func (f *flowbro) onConnected() func(ws *websocket.Conn) {
+	return func(ws *websocket.Conn) {
+		log.Println("Opened WebSocket connection!")
+
+		var raw json.RawMessage
+		err := websocket.JSON.Receive(ws, &raw)
+		if err != nil {
+			ws.Close()
+			log.Println("Didn't receive config from WebSocket!", err)
+			return
+		}
+		var configJSON configJSON
+		if err := json.Unmarshal(raw, &configJSON); err != nil {
+			sendError(fmt.Sprintf("Closing WebSocket connection due to: invalid config. err=%v\n", err), ws)
+			ws.Close()
+			return
+		}
+		c := &configJSON
+		var sess *namedSession
+		var sh *share
+		switch {
+		case len(configJSON.Share) > 0:
+			var s share
+			if s, err = f.shares.get(configJSON.Share, time.Now()); err == nil {
+				sh = &s
+				raw, c, err = f.shares.config(s, configJSON.HeartbeatUUID)
+			}
+		case len(configJSON.Session) > 0:
+			if sess, err = f.sessions.open(configJSON.Session, principalOf(ws.Request()).Name, raw); err == nil {
+				raw, c, err = sess.config(raw, c)
+			}
+		}
+		if err != nil {
+			sendError(fmt.Sprintf("Closing WebSocket connection due to: %v\n", err), ws)
+			ws.Close()
+			return
+		}
+		f.stream(ws, principalOf(ws.Request()).Role, raw, c, sess, sh)
+	}
+}
+
+// stream streams the flow configured by configJSON, raw being its source, to
+// ws until the connection is closed, keeping the named session sess if any,
+// or as the read-only view shared by sh. Controls are only accepted from
+// operators.
+func (f *flowbro) stream(ws conn, r role, raw json.RawMessage, configJSON *configJSON, sess *namedSession, sh *share) {
+	kind := "stream"
+	if _, ok := ws.(*websocket.Conn); ok {
+		kind = "websocket"
+	}
+	if !f.drain.enter() {
+		sendError("Closing WebSocket connection due to: flowbro is draining before shutting down; please reconnect", ws)
+		ws.Close()
+		return
+	}
+	defer f.drain.leave()
+	connectedClients.add(1, kind)
+	defer connectedClients.add(-1, kind)
+
+	forwarded := *configJSON
+	configJSON.Decoders = append(configJSON.Decoders, f.decoders...)
+	configJSON.Kafka.Filter = andFilter(configJSON.Kafka.Filter, f.filter)
+	configJSON.Redactions = append(append([]redactionJSON{}, f.redactions...), configJSON.Redactions...)
+	configJSON.dataDir = f.dataDir
+	config, err := processConfig(configJSON)
+	if err != nil {
+		sendError(fmt.Sprintf("Closing WebSocket connection due to: %v\n", err), ws)
+		ws.Close()
+		return
+	}
+	config.extraFilter = andFilter(configJSON.extraFilter, f.filter)
+	if sh != nil {
+		r = viewer
+	}
+	config.mockPath, config.dataDir, config.session, config.role, config.board = f.mockPath, f.dataDir, sess, r, configJSON.board
+	config.heartbeatTimeout = f.heartbeatTimeout
+	if config.role < operator {
+		if events := readOnly(config); len(events) > 0 {
+			sendEvents(events, ws)
+		}
+	}
+
+	if config.scripts, err = startScripts(config.scriptsJSON, f.scriptsDir, f.wasmRuntime); err != nil {
+		sendError(fmt.Sprintf("Closing WebSocket connection due to: %v\n", err), ws)
+		ws.Close()
+		return
+	}
+	defer stopScripts(config.scripts)
+
+	if config.deadLetters, err = openDeadLetters(config.deadLetterJSON, f.dataDir, config.brokers); err != nil {
+		sendError(fmt.Sprintf("Closing WebSocket connection due to: %v\n", err), ws)
+		ws.Close()
+		return
+	}
+	defer config.deadLetters.close()
+
+	if config.recording, err = openRecording(config.recordingJSON, f.dataDir, raw, config.board); err != nil {
+		sendError(fmt.Sprintf("Closing WebSocket connection due to: %v\n", err), ws)
+		ws.Close()
+		return
+	}
+	defer config.recording.close()
+	config.search, config.savedQueries, config.flows, config.draining = f.search, f.queries, f.flows, f.drain.signal()
+	_, forwarder := ws.(*forwardConn)
+	if _, ok := ws.(*alertsConn); !ok && !forwarder {
+		alerts, unsubscribe := f.alerts.subscribe()
+		defer unsubscribe()
+		config.serverAlerts = alerts
+	}
+
+	life := newLifecycle(ws.Request().Context())
+	src, c, bookieCounts, ok := f.openSource(ws, config, life.context())
+	if !ok {
+		return
+	}
+	clusters := sourceClusters(src)
+	config.state = newStreamState(kind, ws.Request(), config, clusters)
+	activeStreams.add(config.state)
+	defer activeStreams.remove(config.state)
+
+	if config.session != nil {
+		if events := config.session.restore(clusters); len(events) > 0 {
+			sendEvents(events, ws)
+		}
+	}
+	if sh != nil {
+		events := []event{sh.flow(raw, configJSON)}
+		var window []event
+		config.window, window = sh.window(clusters)
+		sendEvents(append(events, window...), ws)
+	}
+	if len(config.sinks) > 0 || len(config.webhooks) > 0 || config.influx != nil || config.splunk != nil || config.syslog != nil || config.loki != nil {
+		if config.role < operator {
+			sendEvents([]event{{EventType: "log", Text: "Only operators may forward messages to kafka sinks and webhooks, statistics to influx or events to Splunk, syslog and Loki; this view doesn't forward.", Color: "warning"}}, ws)
+		} else if _, owned := ws.(ownedConn); !owned && !forwarder && f.forwarders != nil {
+			failures, leave := f.forwarders.join(f, forwarded)
+			defer leave()
+			config.forwarding = failures
+		} else {
+			if err := openKafkaSinks(config.sinks); err != nil {
+				sendEvents([]event{{EventType: "log", Text: err.Error(), Color: "error"}}, ws)
+			}
+			startWebhooks(config.webhooks, life)
+			startInflux(config.influx, life)
+			startSplunk(config.splunk, life)
+			startSyslog(config.syslog, life)
+			startLoki(config.loki, life)
+		}
+		defer closeKafkaSinks(config.sinks)
+	}
+	config.catchUp = newCatchUp(config.catchUpJSON, clusters.backlogTargets())
+	alerter := newAlerter(config.alerts, clusters.highWaterMarks)
+	if notify, events := notifying(ws, config.alerts); !notify {
+		alerter.notify = nil
+		if len(events) > 0 {
+			sendEvents(events, ws)
+		}
+	}
+	process(ws, c, sender{}, config, bookieCounts, alerter, clusters, life)
+
+	life.stop()
+	src.Close()
+	ws.Close()
+	life.wait()
+}
+
+// openSource starts the source of the messages config asks for, along with
+// the message counts of topics only counted by Bookie.
+func (f *flowbro) openSource(ws conn, config *config, ctx context.Context) (Source, <-chan *SourceMessage, map[string]int64, bool) {
+	bookieCounts := map[string]int64{}
+	bookie, fsmInfo := bookie{}, fsm{}
+	var err error
+	if config.bookieUrl != "" {
+		bookie = newBookie(config.bookieUrl)
+		fsmInfo, err = bookie.fsm(config.fsmId)
+		if len(config.fsmId) > 0 && err != nil {
+			log.WithFields(log.Fields{"err": err, "fsmId": config.fsmId, "url": bookie.url}).Warn("Failed to fetch FSMId from Bookie.")
+		}
+	}
+
+	open := newSource
+	if f.newSource != nil {
+		open = f.newSource
+	}
+	src, notice := open(config, fsmInfo, func(e event) error { return sendEvents([]event{e}, ws) })
+	c, err := src.Start(ctx)
+	if err != nil {
+		sendError(fmt.Sprintf("Closing WebSocket connection due to: %v", err), ws)
+		ws.Close()
+		return nil, nil, bookieCounts, false
+	}
+	if len(notice) > 0 {
+		sendSuccess(notice, ws)
+		return src, c, bookieCounts, true
+	}
+
+	for _, t := range config.bookieCountOnly {
+		if len(config.fsmId) == 0 {
+			sendError(fmt.Sprintf("Note that, since fsmId is not set, you won't see any events coming from topic %v", t), ws)
+			continue
+		}
+
+		if ti, ok := fsmInfo.Topics[t]; ok {
+			bookieCounts[t] = ti.Count
+			continue
+		}
+		sendError(fmt.Sprintf("Didn't find message count for topic %v for fsmID %v on Bookie", t, fsmInfo.Id), ws)
+	}
+
+	return src, c, bookieCounts, true
+}
+
+func sendEvents(events []event, ws conn) error {
+	byt, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	_, err = ws.Write(byt)
+	return err
+}
+
+func sendError(error string, ws conn) {
+	log.Printf(error)
+
+	byt, err := json.Marshal([]event{{EventType: "log", Text: error, Color: "error"}})
+	if err != nil {
+		log.Printf("Error while marshalling error: err=%v\n", err)
+		return
+	}
+
+	ws.Write(byt)
+}
+
+func sendSuccess(text string, ws conn) {
+	log.Printf(text)
+
+	byt, err := json.Marshal([]event{{EventType: "log", Text: text, Color: "happy"}})
+	if err != nil {
+		log.Printf("Error while marshalling error: err=%v\n", err)
+		return
+	}
+
+	ws.Write(byt)
+}
+
+func (f *flowbro) baseHandler(template *template.Template) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" && r.URL.RawQuery == "" {
+			if err := serveBaseHTML(template, w, r); err != nil {
+				log.Printf("Loading base page failed; ignoring. err=%v\n", err)
+			}
+		} else {
+			http.FileServer(http.Dir("webroot")).ServeHTTP(w, r)
+		}
+	}
+}
+
+func (f *flowbro) handler(baseTemplate *template.Template) http.Handler {
+	f.sessions = newSessions(f.dataDir)
+	f.shares = newShares(f.dataDir, f.sessions)
+	f.queries = newSavedQueries(f.dataDir)
+	f.flows = newFlows(mainPath)
+	f.drain = newDrain(defaultDrainTimeout)
+	f.forwarders = newForwarders()
+	mux := http.NewServeMux()
+	if f.bus != nil {
+		mux.Handle("/ws/", websocket.Server{Handler: f.onBusConnected(), Handshake: sameOrigin})
+	} else if len(f.boardsDir) > 0 {
+		mux.Handle("/ws/", websocket.Server{Handler: f.onBoardConnected(), Handshake: sameOrigin})
+	} else {
+		mux.Handle("/ws", websocket.Server{Handler: f.onConnected(), Handshake: sameOrigin})
+	}
+	mux.HandleFunc("/api/bookmarks", newBookmarks(f.dataDir).handler)
+	mux.HandleFunc("/api/annotations", newAnnotations(f.dataDir).handler)
+	mux.HandleFunc("/api/flow", f.flows.handler)
+	mux.HandleFunc("/api/state", stateHandler)
+	mux.HandleFunc("/api/drain", f.drain.handler)
+	mux.HandleFunc("/api/graph.dot", graphHandler("text/vnd.graphviz; charset=utf-8", graph.dot))
+	mux.HandleFunc("/api/graph.mmd", graphHandler("text/plain; charset=utf-8", graph.mermaid))
+	mux.HandleFunc("/api/export", f.exportHandler)
+	mux.HandleFunc("/api/import", f.importHandler)
+	mux.HandleFunc("/api/export.csv", f.exportCSVHandler)
+	mux.HandleFunc("/api/export.parquet", f.exportParquetHandler)
+	mux.HandleFunc("/api/table/", tableHandler)
+	mux.HandleFunc("/api/partition-for-key", partitionForKeyHandler)
+	mux.HandleFunc("/api/key-offsets", keyOffsetsHandler)
+	mux.HandleFunc("/api/search", f.searchHandler)
+	mux.HandleFunc("/api/queries", f.queries.handler)
+	mux.HandleFunc("/api/sessions", f.sessions.handler)
+	mux.HandleFunc("/api/share", f.shares.handler)
+	mux.HandleFunc("/stream", f.streamHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	if f.grafana != nil {
+		mux.HandleFunc("/api/grafana/", f.grafana.handler)
+	}
+	mux.HandleFunc("/", f.baseHandler(baseTemplate))
+	return f.authorize(mux)
+}
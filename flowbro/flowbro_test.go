@@ -0,0 +1,106 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestMockedPipelineOverWebSocket(t *testing.T) {
+	fixtures := `{"topic":"requests","key":"1","value":{"target":"phone"},"cluster":"eu"}
+{"topic":"notifications","key":"1","value":{"target":"phone"}}
+`
+	path := writeTempFile(t, fixtures)
+	defer os.Remove(path)
+
+	s := httptestServer(&flowbro{mockPath: path})
+	defer s.Close()
+
+	ws, err := websocket.Dial(strings.Replace(s.URL, "http", "ws", 1)+"/ws", "", s.URL)
+	if err != nil {
+		t.Fatalf("Could not open WebSocket: %v", err)
+	}
+	defer ws.Close()
+
+	conf := configJSON{
+		Rules: []rule{
+			{
+				Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "requests"}},
+				Events:   []event{{EventType: "message", SourceId: "Endpoint", TargetId: "Server", Text: "{{.Value.target}}", FSMId: "{{.Key}}"}},
+			},
+			{
+				Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "notifications"}},
+				Events:   []event{{EventType: "message", SourceId: "Server", TargetId: "Phone", FSMId: "{{.Key}}"}},
+			},
+		},
+		HeartbeatUUID: "uuid",
+	}
+	if err := websocket.JSON.Send(ws, conf); err != nil {
+		t.Fatalf("Could not send config: %v", err)
+	}
+
+	expected := []event{
+		{EventType: "message", SourceId: "Endpoint", TargetId: "Server", Text: "phone", FSMId: "1", JSON: newSliceFrom(`{"target":"phone"}`), Count: 1, Cluster: "eu", ContentType: contentTypeJSON},
+		{EventType: "message", SourceId: "Server", TargetId: "Phone", FSMId: "1", JSON: newSliceFrom(`{"target":"phone"}`), Count: 1, ContentType: contentTypeJSON},
+	}
+
+	actual := []event{}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(actual) < len(expected) {
+		var es []event
+		if err := websocket.JSON.Receive(ws, &es); err != nil {
+			t.Fatalf("Didn't receive expected events; got %+v before err=%v", actual, err)
+		}
+		for _, e := range es {
+			if e.EventType == "message" {
+				actual = append(actual, e)
+			}
+		}
+	}
+
+	if !eventsEqual(actual, expected) {
+		t.Errorf("events mismatch; expected %+v but got %+v", expected, actual)
+	}
+}
+
+func TestReadMockFixturesAcceptsArrays(t *testing.T) {
+	path := writeTempFile(t, `[{"topic":"a","value":"raw"},{"topic":"b","value":{"x":1}}]`)
+	defer os.Remove(path)
+
+	fs, err := readMockFixtures(path)
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	if len(fs) != 2 || string(mockValue(fs[0].Value)) != "raw" || string(mockValue(fs[1].Value)) != `{"x":1}` {
+		t.Errorf("unexpected fixtures %+v", fs)
+	}
+}
+
+func httptestServer(f *flowbro) *httptest.Server {
+	return httptest.NewServer(f.handler(mustParseBasePageTemplate()))
+}
+
+func writeTempFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+// eventsEqual compares events through their JSON form, as the UI sees them.
+func eventsEqual(a, b []event) bool {
+	ba, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return string(ba) == string(bb)
+}
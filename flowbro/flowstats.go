@@ -0,0 +1,183 @@
+package flowbro
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type flowStatsJSON struct {
+	WindowsSeconds  []int   `json:"windowsSeconds"`
+	IntervalSeconds float64 `json:"intervalSeconds"`
+}
+
+var defaultFlowStatsWindows = []int{60}
+
+// flowStats are rolled-up message counts per edge and per component of the
+// flow over each window, sent periodically as flowStats events for
+// dashboards that only need to animate the boxes and arrows.
+type flowStats struct {
+	Windows []flowWindow `json:"windows"`
+}
+
+type flowWindow struct {
+	Seconds    int              `json:"seconds"`
+	Edges      []edgeCount      `json:"edges"`
+	Components []componentCount `json:"components"`
+}
+
+type edgeCount struct {
+	SourceId string `json:"sourceId"`
+	TargetId string `json:"targetId"`
+	Count    int64  `json:"count"`
+}
+
+type componentCount struct {
+	Id  string `json:"id"`
+	In  int64  `json:"in"`
+	Out int64  `json:"out"`
+}
+
+type edge struct {
+	source, target string
+}
+
+// flowCounter counts message events per edge in one second buckets, kept for
+// as long as the largest window.
+type flowCounter struct {
+	windows    []int
+	interval   time.Duration
+	lastReport time.Time
+	buckets    map[int64]map[edge]int64
+}
+
+func newFlowCounter(c *flowStatsJSON, now time.Time) *flowCounter {
+	if c == nil {
+		return nil
+	}
+	windows := []int{}
+	for _, w := range c.WindowsSeconds {
+		if w > 0 {
+			windows = append(windows, w)
+		}
+	}
+	if len(windows) == 0 {
+		windows = defaultFlowStatsWindows
+	}
+	sort.Ints(windows)
+	interval := time.Duration(c.IntervalSeconds * float64(time.Second))
+	if interval <= 0 {
+		interval = defaultStatsInterval
+	}
+	return &flowCounter{windows: windows, interval: interval, lastReport: now, buckets: map[int64]map[edge]int64{}}
+}
+
+func (f *flowCounter) onEvents(events []event, now time.Time) {
+	second := now.Unix()
+	for _, e := range events {
+		if e.EventType != "message" {
+			continue
+		}
+		if _, ok := f.buckets[second]; !ok {
+			f.buckets[second] = map[edge]int64{}
+		}
+		f.buckets[second][edge{e.SourceId, e.TargetId}] += e.Count
+	}
+}
+
+// report returns a flowStats event once every interval, forgetting counts
+// older than the largest window.
+func (f *flowCounter) report(now time.Time) []event {
+	if now.Sub(f.lastReport) < f.interval {
+		return nil
+	}
+	f.lastReport = now
+	f.forget(now)
+
+	stats := &flowStats{Windows: []flowWindow{}}
+	for _, w := range f.windows {
+		stats.Windows = append(stats.Windows, f.window(w, now))
+	}
+	return []event{{EventType: "flowStats", Flow: stats}}
+}
+
+func (f *flowCounter) forget(now time.Time) {
+	second := now.Unix()
+	for s := range f.buckets {
+		if s <= second-int64(f.windows[len(f.windows)-1]) {
+			delete(f.buckets, s)
+		}
+	}
+}
+
+// edges returns the message counts per edge over the last w seconds.
+func (f *flowCounter) edges(w int, now time.Time) map[edge]int64 {
+	second, edges := now.Unix(), map[edge]int64{}
+	for s, counts := range f.buckets {
+		if s <= second-int64(w) {
+			continue
+		}
+		for e, c := range counts {
+			edges[e] += c
+		}
+	}
+	return edges
+}
+
+func (f *flowCounter) window(w int, now time.Time) flowWindow {
+	components := map[string]*componentCount{}
+	window := flowWindow{Seconds: w, Edges: []edgeCount{}, Components: []componentCount{}}
+	for e, c := range f.edges(w, now) {
+		window.Edges = append(window.Edges, edgeCount{SourceId: e.source, TargetId: e.target, Count: c})
+		for _, id := range []string{e.source, e.target} {
+			if _, ok := components[id]; !ok {
+				components[id] = &componentCount{Id: id}
+			}
+		}
+		components[e.source].Out += c
+		components[e.target].In += c
+	}
+	for _, c := range components {
+		window.Components = append(window.Components, *c)
+	}
+	sort.Slice(window.Edges, func(i, j int) bool {
+		a, b := window.Edges[i], window.Edges[j]
+		return a.SourceId < b.SourceId || a.SourceId == b.SourceId && a.TargetId < b.TargetId
+	})
+	sort.Slice(window.Components, func(i, j int) bool { return window.Components[i].Id < window.Components[j].Id })
+	return window
+}
+
+// observedFlows counts the message events sent to every client over the last
+// minute, to annotate exported graphs with their throughput.
+var observedFlows = struct {
+	sync.Mutex
+	*flowCounter
+}{flowCounter: newFlowCounter(&flowStatsJSON{WindowsSeconds: []int{60}}, time.Now())}
+
+var (
+	edgeMessages  = newCounter("flowbro_flow_edge_messages_total", "Message events sent to clients, per edge of the flow.", "source", "target")
+	edgeLatencies = newHistogram("flowbro_flow_edge_latency_seconds", "Time between a message's Kafka timestamp and its event being sent to clients, per edge of the flow.", []float64{.01, .05, .1, .5, 1, 5, 10, 60, 300, 3600}, "source", "target")
+)
+
+func observeFlows(events []event, now time.Time) {
+	for _, e := range events {
+		if e.EventType != "message" {
+			continue
+		}
+		edgeMessages.add(float64(e.Count), e.SourceId, e.TargetId)
+		if e.Timestamp != nil {
+			edgeLatencies.observe(now.Sub(*e.Timestamp).Seconds(), e.SourceId, e.TargetId)
+		}
+	}
+	observedFlows.Lock()
+	defer observedFlows.Unlock()
+	observedFlows.onEvents(events, now)
+	observedFlows.forget(now)
+}
+
+func observedThroughput(now time.Time) map[edge]int64 {
+	observedFlows.Lock()
+	defer observedFlows.Unlock()
+	return observedFlows.edges(60, now)
+}
@@ -0,0 +1,48 @@
+package flowbro
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFlowCounterReport(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := newFlowCounter(&flowStatsJSON{WindowsSeconds: []int{60, 10}, IntervalSeconds: 5}, start)
+
+	f.onEvents([]event{{EventType: "message", SourceId: "api", TargetId: "orders", Count: 3}}, start)
+	f.onEvents([]event{
+		{EventType: "message", SourceId: "api", TargetId: "orders", Count: 1},
+		{EventType: "message", SourceId: "orders", TargetId: "billing", Count: 2},
+		{EventType: "alert", SourceId: "orders"},
+	}, start.Add(50*time.Second))
+
+	if events := f.report(start.Add(time.Second)); len(events) != 0 {
+		t.Errorf("expected no stats before the interval elapsed but got %+v", events)
+	}
+
+	events := f.report(start.Add(55 * time.Second))
+	if len(events) != 1 || events[0].EventType != "flowStats" {
+		t.Fatalf("expected a single flowStats event but got %+v", events)
+	}
+	expected := []flowWindow{
+		{
+			Seconds:    10,
+			Edges:      []edgeCount{{"api", "orders", 1}, {"orders", "billing", 2}},
+			Components: []componentCount{{"api", 0, 1}, {"billing", 2, 0}, {"orders", 1, 2}},
+		},
+		{
+			Seconds:    60,
+			Edges:      []edgeCount{{"api", "orders", 4}, {"orders", "billing", 2}},
+			Components: []componentCount{{"api", 0, 4}, {"billing", 2, 0}, {"orders", 4, 2}},
+		},
+	}
+	if !reflect.DeepEqual(events[0].Flow.Windows, expected) {
+		t.Errorf("expected %+v but got %+v", expected, events[0].Flow.Windows)
+	}
+
+	events = f.report(start.Add(120 * time.Second))
+	if len(events[0].Flow.Windows[1].Edges) != 0 || len(f.buckets) != 0 {
+		t.Errorf("expected counts older than the largest window to be forgotten but got %+v", events[0].Flow)
+	}
+}
@@ -0,0 +1,149 @@
+package flowbro
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// forwarders forward what configs send to kafka sinks, webhooks, Influx,
+// Splunk, syslog and Loki once per config, however many operators stream
+// it: the first operator streaming a config starts a stream of the server's
+// own that forwards, which the others share and which stops once the last of
+// them leaves. Its failures are sent to all of them.
+type forwarders struct {
+	l       sync.Mutex
+	running map[string]*forwarder
+}
+
+// forwarder is the stream forwarding a config.
+type forwarder struct {
+	stop context.CancelFunc
+
+	l       sync.Mutex
+	clients map[chan event]bool
+}
+
+// forwardedConfig is a config as its forwarder streams it: without what
+// only concerns the connections streaming it, like their heartbeats,
+// sessions, recordings and alerts.
+type forwardedConfig struct {
+	raw         []byte
+	board       string
+	extraFilter string
+}
+
+const (
+	forwarderRetryInterval = 5 * time.Second
+	forwarderQueueSize     = 100
+)
+
+func newForwarders() *forwarders {
+	return &forwarders{running: map[string]*forwarder{}}
+}
+
+// forwards tells whether c forwards anything.
+func forwards(c *configJSON) bool {
+	return len(c.KafkaSinks) > 0 || len(c.Webhooks) > 0 || c.Influx != nil || c.Splunk != nil || c.Syslog != nil || c.Loki != nil
+}
+
+// forwarding returns c as its forwarder streams it, along with the key of
+// the configs that forward the same.
+func forwarding(c configJSON) (forwardedConfig, string) {
+	c.HeartbeatUUID, c.Session, c.Share = "", "", ""
+	c.Alerts, c.Queries, c.Recording, c.Replay, c.Snapshot, c.DeadLetter = nil, nil, nil, nil, nil, nil
+	raw, _ := json.Marshal(c) // c was unmarshalled
+	fc := forwardedConfig{raw: raw, board: c.board, extraFilter: c.extraFilter}
+	sum := sha256.Sum256([]byte(string(raw) + "\x00" + c.board + "\x00" + c.extraFilter))
+	return fc, hex.EncodeToString(sum[:])
+}
+
+// join has c forwarded until the returned func is called, returning the
+// failures of its forwarder meanwhile.
+func (fs *forwarders) join(f *flowbro, c configJSON) (<-chan event, func()) {
+	fc, key := forwarding(c)
+	fs.l.Lock()
+	defer fs.l.Unlock()
+	fw, ok := fs.running[key]
+	if !ok {
+		ctx, stop := context.WithCancel(context.Background())
+		fw = &forwarder{stop: stop, clients: map[chan event]bool{}}
+		fs.running[key] = fw
+		go fw.run(ctx, f, fc)
+	}
+	failures := make(chan event, forwarderQueueSize)
+	fw.l.Lock()
+	fw.clients[failures] = true
+	fw.l.Unlock()
+	return failures, func() {
+		fs.l.Lock()
+		defer fs.l.Unlock()
+		fw.l.Lock()
+		defer fw.l.Unlock()
+		delete(fw.clients, failures)
+		if len(fw.clients) == 0 {
+			fw.stop()
+			delete(fs.running, key)
+		}
+	}
+}
+
+// run forwards c until ctx is done, restarting its stream if it ends, e.g.
+// because its cluster went away.
+func (fw *forwarder) run(ctx context.Context, f *flowbro, c forwardedConfig) {
+	for {
+		var configJSON configJSON
+		json.Unmarshal(c.raw, &configJSON)
+		configJSON.board, configJSON.extraFilter = c.board, c.extraFilter
+		r := (&http.Request{Method: "GET", URL: &url.URL{Path: "/forward"}, RemoteAddr: "forwarder"}).WithContext(ctx)
+		f.stream(&forwardConn{forwarder: fw, r: r}, operator, c.raw, &configJSON, nil, nil)
+		if !sleep(ctx, forwarderRetryInterval) {
+			return
+		}
+	}
+}
+
+// broadcast sends e to every client, dropping it for those that are behind.
+func (fw *forwarder) broadcast(e event) {
+	fw.l.Lock()
+	defer fw.l.Unlock()
+	for c := range fw.clients {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
+
+// forwardConn hands the errors of a forwarder to the clients whose config
+// it forwards.
+type forwardConn struct {
+	forwarder *forwarder
+	r         *http.Request
+}
+
+func (c *forwardConn) Write(b []byte) (int, error) {
+	var events []event
+	if err := json.Unmarshal(b, &events); err != nil {
+		return 0, err
+	}
+	for _, e := range events {
+		if e.EventType == "log" && e.Color == "error" {
+			c.forwarder.broadcast(e)
+		}
+	}
+	return len(b), nil
+}
+
+func (c *forwardConn) Close() error {
+	return nil
+}
+
+func (c *forwardConn) Request() *http.Request {
+	return c.r
+}
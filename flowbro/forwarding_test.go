@@ -0,0 +1,85 @@
+package flowbro
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestForwardersForwardOncePerConfig(t *testing.T) {
+	var l sync.Mutex
+	posts := []string{}
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		l.Lock()
+		posts = append(posts, string(body))
+		l.Unlock()
+	}))
+	defer hook.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(
+		WithListener(ln),
+		WithSources(func() (Source, string) {
+			messages := make(chan *SourceMessage, 1)
+			messages <- &SourceMessage{Topic: "orders", Value: []byte(`{"id": 1}`)}
+			return chanSource{messages}, ""
+		}),
+		WithHeartbeatTimeout(100*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	conf := configJSON{
+		Webhooks:      []webhookJSON{{Name: "orders", Topics: "orders", URL: hook.URL, FlushSeconds: 0.01}},
+		HeartbeatUUID: "uuid",
+	}
+	wss := []*websocket.Conn{}
+	for i := 0; i < 2; i++ {
+		ws, err := websocket.Dial("ws://"+s.addr().String()+"/ws", "", "http://"+s.addr().String())
+		if err != nil {
+			t.Fatalf("Could not open WebSocket: %v", err)
+		}
+		defer ws.Close()
+		if err := websocket.JSON.Send(ws, conf); err != nil {
+			t.Fatalf("Could not send config: %v", err)
+		}
+		wss = append(wss, ws)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	l.Lock()
+	if len(posts) != 1 {
+		t.Errorf("expected the message to be posted once, however many operators stream the config, but got %v", posts)
+	}
+	l.Unlock()
+
+	for _, ws := range wss {
+		ws.Close()
+	}
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		s.f.forwarders.l.Lock()
+		running := len(s.f.forwarders.running)
+		s.f.forwarders.l.Unlock()
+		if running == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the forwarder to stop once nobody streams its config, but %v still run", running)
+		}
+	}
+}
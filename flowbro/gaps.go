@@ -0,0 +1,84 @@
+package flowbro
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+type gapsJSON struct {
+	IgnoreTopics string `json:"ignoreTopics"`
+}
+
+// gapDetector tracks the offset expected next on every partition, and warns
+// when messages are skipped, to tell "the producer stopped" apart from
+// "flowbro is dropping messages". Compacted topics and aborted transactions
+// legitimately leave gaps, so their topics can be ignored.
+type gapDetector struct {
+	ignore *regexp.Regexp
+	next   map[string]map[string]map[int32]int64
+}
+
+var (
+	offsetGaps     = newCounter("flowbro_offset_gaps_total", "Gaps found between consecutive offsets of a partition.", "cluster", "topic", "partition")
+	skippedOffsets = newCounter("flowbro_skipped_offsets_total", "Offsets missing in gaps between consecutive offsets of a partition.", "cluster", "topic", "partition")
+)
+
+func processGaps(c *gapsJSON) (*gapDetector, error) {
+	if c == nil {
+		return nil, nil
+	}
+	g := &gapDetector{next: map[string]map[string]map[int32]int64{}}
+	if len(c.IgnoreTopics) > 0 {
+		ignore, err := regexp.Compile("^(?:" + c.IgnoreTopics + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid topic regex %v for gaps. err=%v", c.IgnoreTopics, err)
+		}
+		g.ignore = ignore
+	}
+	return g, nil
+}
+
+// onMessage returns an offsetGap event if offsets were skipped before offset.
+// Every view of a partition is tracked on its own.
+func (g *gapDetector) onMessage(cluster, view, topic string, partition int32, offset int64) (event, bool) {
+	if g.ignore != nil && g.ignore.MatchString(topic) {
+		return event{}, false
+	}
+	source := cluster + "|" + view
+	if _, ok := g.next[source]; !ok {
+		g.next[source] = map[string]map[int32]int64{}
+	}
+	if _, ok := g.next[source][topic]; !ok {
+		g.next[source][topic] = map[int32]int64{}
+	}
+	next, seen := g.next[source][topic][partition]
+	g.next[source][topic][partition] = offset + 1
+	if !seen || offset <= next {
+		return event{}, false
+	}
+
+	p := strconv.Itoa(int(partition))
+	offsetGaps.inc(cluster, topic, p)
+	skippedOffsets.add(float64(offset-next), cluster, topic, p)
+	return event{
+		EventType: "offsetGap",
+		Text:      fmt.Sprintf("Offsets %v to %v of topic %v partition %v were skipped.", next, offset-1, topic, partition),
+		Color:     "warning",
+		Cluster:   cluster,
+		View:      view,
+		Topic:     topic,
+		Partition: &partition,
+	}, true
+}
+
+// forget stops expecting offsets on partitions that were reseeked.
+func (g *gapDetector) forget(seeked map[string][]int32) {
+	for _, topics := range g.next {
+		for topic, ps := range seeked {
+			for _, p := range ps {
+				delete(topics[topic], p)
+			}
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package flowbro
+
+import "testing"
+
+func TestGapDetector(t *testing.T) {
+	g, err := processGaps(&gapsJSON{IgnoreTopics: "compacted-.*"})
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		topic     string
+		partition int32
+		offset    int64
+		gap       bool
+	}{
+		{name: "first message", topic: "orders", partition: 0, offset: 10},
+		{name: "next offset", topic: "orders", partition: 0, offset: 11},
+		{name: "other partition", topic: "orders", partition: 1, offset: 50},
+		{name: "skipped offsets", topic: "orders", partition: 0, offset: 15, gap: true},
+		{name: "redelivered offset", topic: "orders", partition: 0, offset: 15},
+		{name: "ignored topic", topic: "compacted-users", partition: 0, offset: 1},
+		{name: "ignored topic gap", topic: "compacted-users", partition: 0, offset: 9},
+	}
+
+	for _, ts := range tests {
+		e, gap := g.onMessage("eu", "", ts.topic, ts.partition, ts.offset)
+		if gap != ts.gap {
+			t.Errorf("on '%v': expected gap to be %v but got %v", ts.name, ts.gap, gap)
+		}
+		if gap && (e.EventType != "offsetGap" || e.Topic != ts.topic || *e.Partition != ts.partition || e.Cluster != "eu") {
+			t.Errorf("on '%v': unexpected event %+v", ts.name, e)
+		}
+	}
+	if v := skippedOffsets.values[`cluster="eu",topic="orders",partition="0"`]; v != 3 {
+		t.Errorf("expected 3 skipped offsets to be counted but got %v", v)
+	}
+
+	g.forget(map[string][]int32{"orders": {0}})
+	if _, gap := g.onMessage("eu", "", "orders", 0, 100); gap {
+		t.Errorf("expected no gap after seeking")
+	}
+	if _, gap := g.onMessage("eu", "hour-ago", "orders", 0, 40); gap {
+		t.Errorf("expected another view of the partition to be tracked on its own")
+	}
+	if _, gap := g.onMessage("eu", "", "orders", 0, 101); gap {
+		t.Errorf("expected no gap after a message of another view")
+	}
+}
+
+func TestInvalidGapsConfig(t *testing.T) {
+	if _, err := processGaps(&gapsJSON{IgnoreTopics: "("}); err == nil {
+		t.Errorf("expected an invalid ignoreTopics regex to fail")
+	}
+}
@@ -0,0 +1,243 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultGrafanaInterval is how often flowbro samples the metrics it keeps
+// for Grafana when run with -grafana.
+const DefaultGrafanaInterval = 10 * time.Second
+
+const defaultGrafanaRetention = 24 * time.Hour
+
+// grafanaHistory samples flowbro's metrics every interval and keeps them
+// for retention, serving them to Grafana's JSON datasources under
+// /api/grafana/ so flow throughput can be charted next to other dashboards.
+// Counters are kept as their rate per second, gauges as their value and
+// histograms as the mean of the observations of each interval, named
+// <histogram>_mean.
+type grafanaHistory struct {
+	interval  time.Duration
+	retention time.Duration
+
+	l      sync.Mutex
+	last   map[string]float64
+	lastAt time.Time
+	series map[string][]grafanaPoint
+}
+
+type grafanaPoint struct {
+	value float64
+	at    time.Time
+}
+
+type grafanaQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Targets []struct {
+		Target string `json:"target"`
+	} `json:"targets"`
+	MaxDataPoints int `json:"maxDataPoints"`
+}
+
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+func newGrafanaHistory(interval, retention time.Duration) *grafanaHistory {
+	return &grafanaHistory{interval: interval, retention: retention, last: map[string]float64{}, series: map[string][]grafanaPoint{}}
+}
+
+func (h *grafanaHistory) run(ctx context.Context) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			h.sample(collectMetrics(), now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// seriesName formats labels like Prometheus does, e.g.
+// flowbro_consumed_messages_total{cluster="eu",topic="orders"}.
+func seriesName(labels [][2]string) string {
+	name, pairs := "", []string{}
+	for _, l := range labels {
+		if l[0] == "__name__" {
+			name = l[1]
+			continue
+		}
+		pairs = append(pairs, fmt.Sprintf("%v=%q", l[0], l[1]))
+	}
+	if len(pairs) == 0 {
+		return name
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (h *grafanaHistory) sample(series []promSeries, now time.Time) {
+	h.l.Lock()
+	defer h.l.Unlock()
+	elapsed := now.Sub(h.lastAt).Seconds()
+	first := h.lastAt.IsZero()
+	h.lastAt = now
+
+	sums, counts := map[string]float64{}, map[string]float64{}
+	for _, s := range series {
+		name := seriesName(s.labels)
+		switch s.kind {
+		case "gauge":
+			h.add(name, s.value, now)
+		case "counter":
+			last, seen := h.last[name]
+			h.last[name] = s.value
+			if seen && elapsed > 0 {
+				h.add(name, (s.value-last)/elapsed, now)
+			}
+		case "histogram":
+			metric, labels := s.labels[0][1], s.labels[1:]
+			mean := func(suffix string) string {
+				return seriesName(append([][2]string{{"__name__", strings.TrimSuffix(metric, suffix) + "_mean"}}, labels...))
+			}
+			if strings.HasSuffix(metric, "_sum") {
+				sums[mean("_sum")] = s.value
+			} else if strings.HasSuffix(metric, "_count") {
+				counts[mean("_count")] = s.value
+			}
+		}
+	}
+	for mean, count := range counts {
+		lastCount, lastSum := h.last[mean+" count"], h.last[mean+" sum"]
+		h.last[mean+" count"], h.last[mean+" sum"] = count, sums[mean]
+		if !first && count > lastCount {
+			h.add(mean, (sums[mean]-lastSum)/(count-lastCount), now)
+		}
+	}
+	for name, points := range h.series {
+		i := 0
+		for i < len(points) && now.Sub(points[i].at) > h.retention {
+			i++
+		}
+		if i == len(points) {
+			delete(h.series, name)
+		} else {
+			h.series[name] = points[i:]
+		}
+	}
+}
+
+func (h *grafanaHistory) add(name string, value float64, at time.Time) {
+	h.series[name] = append(h.series[name], grafanaPoint{value: value, at: at})
+}
+
+// names returns the names of the series containing filter, sorted.
+func (h *grafanaHistory) names(filter string) []string {
+	h.l.Lock()
+	defer h.l.Unlock()
+	names := []string{}
+	for name := range h.series {
+		if strings.Contains(name, filter) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// query returns the points of the series named target, or else of every
+// series matching it as a regex, between from and to, keeping at most max
+// points per series by skipping evenly.
+func (h *grafanaHistory) query(target string, from, to time.Time, max int) ([]grafanaSeries, error) {
+	h.l.Lock()
+	defer h.l.Unlock()
+	names := []string{}
+	if _, ok := h.series[target]; ok {
+		names = append(names, target)
+	} else {
+		re, err := regexp.Compile("^(?:" + target + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("No series %v, and it's not a valid regex. err=%v", target, err)
+		}
+		for name := range h.series {
+			if re.MatchString(name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+
+	result := []grafanaSeries{}
+	for _, name := range names {
+		points := [][2]float64{}
+		for _, p := range h.series[name] {
+			if !p.at.Before(from) && !p.at.After(to) {
+				points = append(points, [2]float64{p.value, float64(p.at.UnixNano() / int64(time.Millisecond))})
+			}
+		}
+		if max > 0 && len(points) > max {
+			step, kept := float64(len(points))/float64(max), [][2]float64{}
+			for i := 0; i < max; i++ {
+				kept = append(kept, points[int(float64(i)*step)])
+			}
+			points = kept
+		}
+		result = append(result, grafanaSeries{Target: name, Datapoints: points})
+	}
+	return result, nil
+}
+
+// handler serves the endpoints of Grafana's JSON datasources: / to test the
+// connection, /search to list series and /query for their points.
+func (h *grafanaHistory) handler(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, "/api/grafana") {
+	case "", "/":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case "/search":
+		var body struct {
+			Target string `json:"target"`
+		}
+		if r.Method == "POST" {
+			json.NewDecoder(r.Body).Decode(&body) // an empty search lists every series
+		}
+		writeJSON(w, http.StatusOK, h.names(body.Target))
+	case "/query":
+		if r.Method != "POST" {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+			return
+		}
+		var q grafanaQuery
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid query. err=%v", err))
+			return
+		}
+		if q.Range.To.IsZero() {
+			q.Range.To = time.Now()
+		}
+		result := []grafanaSeries{}
+		for _, t := range q.Targets {
+			series, err := h.query(t.Target, q.Range.From, q.Range.To, q.MaxDataPoints)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			result = append(result, series...)
+		}
+		writeJSON(w, http.StatusOK, result)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("No Grafana endpoint %v", r.URL.Path))
+	}
+}
@@ -0,0 +1,125 @@
+package flowbro
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGrafanaHistorySample(t *testing.T) {
+	consumed := [][2]string{{"__name__", "flowbro_consumed_messages_total"}, {"topic", "orders"}}
+	clients := [][2]string{{"__name__", "flowbro_connected_clients"}, {"kind", "websocket"}}
+	sum := [][2]string{{"__name__", "latency_seconds_sum"}, {"topic", "orders"}}
+	count := [][2]string{{"__name__", "latency_seconds_count"}, {"topic", "orders"}}
+	bucket := [][2]string{{"__name__", "latency_seconds_bucket"}, {"topic", "orders"}, {"le", "+Inf"}}
+	start := time.Unix(1500000000, 0)
+
+	h := newGrafanaHistory(10*time.Second, time.Minute)
+	for i, sample := range [][]promSeries{
+		{{labels: consumed, value: 100, kind: "counter"}, {labels: clients, value: 1, kind: "gauge"}, {labels: sum, value: 2, kind: "histogram"}, {labels: count, value: 4, kind: "histogram"}},
+		{{labels: consumed, value: 150, kind: "counter"}, {labels: clients, value: 2, kind: "gauge"}, {labels: sum, value: 5, kind: "histogram"}, {labels: count, value: 6, kind: "histogram"}, {labels: bucket, value: 6, kind: "histogram"}},
+		{{labels: consumed, value: 250, kind: "counter"}, {labels: sum, value: 5, kind: "histogram"}, {labels: count, value: 6, kind: "histogram"}},
+	} {
+		h.sample(sample, start.Add(time.Duration(i)*10*time.Second))
+	}
+
+	expected := map[string][]float64{
+		`flowbro_consumed_messages_total{topic="orders"}`: {5, 10},
+		`flowbro_connected_clients{kind="websocket"}`:     {1, 2},
+		`latency_seconds_mean{topic="orders"}`:            {1.5},
+	}
+	if names := h.names(""); len(names) != len(expected) {
+		t.Errorf("expected series %v but got %v", expected, names)
+	}
+	for name, values := range expected {
+		actual := []float64{}
+		for _, p := range h.series[name] {
+			actual = append(actual, p.value)
+		}
+		if !reflect.DeepEqual(actual, values) {
+			t.Errorf("on '%v': expected %v but got %v", name, values, actual)
+		}
+	}
+
+	h.sample(nil, start.Add(75*time.Second))
+	if points := h.series[`flowbro_consumed_messages_total{topic="orders"}`]; len(points) != 1 {
+		t.Errorf("expected points older than the retention to be forgotten but got %v", points)
+	}
+}
+
+func TestGrafanaHistoryQuery(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	h := newGrafanaHistory(time.Second, time.Hour)
+	for i := 0; i < 10; i++ {
+		h.add(`edges{source="a"}`, float64(i), start.Add(time.Duration(i)*time.Second))
+		h.add(`edges{source="b"}`, float64(i), start.Add(time.Duration(i)*time.Second))
+	}
+
+	ts := []struct {
+		target          string
+		from, to        time.Time
+		max             int
+		expectedTargets []string
+		expectedPoints  int
+		fails           bool
+	}{
+		{`edges{source="a"}`, start, start.Add(time.Hour), 0, []string{`edges{source="a"}`}, 10, false},
+		{`edges.*`, start.Add(5 * time.Second), start.Add(time.Hour), 0, []string{`edges{source="a"}`, `edges{source="b"}`}, 5, false},
+		{`edges.*`, start, start.Add(time.Hour), 3, []string{`edges{source="a"}`, `edges{source="b"}`}, 3, false},
+		{`unknown`, start, start.Add(time.Hour), 0, []string{}, 0, false},
+		{`edges(`, start, start.Add(time.Hour), 0, nil, 0, true},
+	}
+
+	for _, tc := range ts {
+		series, err := h.query(tc.target, tc.from, tc.to, tc.max)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("on '%v': expected the query to fail", tc.target)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on '%v': shouldn't have failed but did with %v", tc.target, err)
+			continue
+		}
+		targets := []string{}
+		for _, s := range series {
+			targets = append(targets, s.Target)
+			if len(s.Datapoints) != tc.expectedPoints {
+				t.Errorf("on '%v': expected %v points but got %v", tc.target, tc.expectedPoints, s.Datapoints)
+			}
+		}
+		if !reflect.DeepEqual(targets, tc.expectedTargets) {
+			t.Errorf("on '%v': expected targets %v but got %v", tc.target, tc.expectedTargets, targets)
+		}
+	}
+}
+
+func TestGrafanaHandler(t *testing.T) {
+	h := newGrafanaHistory(time.Second, time.Hour)
+	h.add(`edges{source="a"}`, 4, time.Unix(1500000000, 0))
+
+	ts := []struct {
+		method, path, body string
+		expectedStatus     int
+		expectedBody       string
+	}{
+		{"GET", "/api/grafana/", "", http.StatusOK, `{"status":"ok"}`},
+		{"POST", "/api/grafana/search", `{"target":"edges"}`, http.StatusOK, `["edges{source=\"a\"}"]`},
+		{"POST", "/api/grafana/query", `{"range":{"from":"2017-07-14T00:00:00Z","to":"2017-07-15T00:00:00Z"},"targets":[{"target":"edges.*"}]}`, http.StatusOK, `[{"target":"edges{source=\"a\"}","datapoints":[[4,1500000000000]]}]`},
+		{"GET", "/api/grafana/query", "", http.StatusMethodNotAllowed, ""},
+		{"POST", "/api/grafana/query", `{`, http.StatusBadRequest, ""},
+		{"GET", "/api/grafana/annotations", "", http.StatusNotFound, ""},
+	}
+
+	for _, tc := range ts {
+		w := httptest.NewRecorder()
+		h.handler(w, httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body)))
+		if w.Code != tc.expectedStatus || len(tc.expectedBody) > 0 && strings.TrimSpace(w.Body.String()) != tc.expectedBody {
+			t.Errorf("on '%v %v': expected %v %v but got %v %v", tc.method, tc.path, tc.expectedStatus, tc.expectedBody, w.Code, w.Body.String())
+		}
+	}
+}
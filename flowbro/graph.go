@@ -0,0 +1,164 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// graph is the topology of a flow: its components and the edges its rules
+// animate messages along.
+type graph struct {
+	components []string
+	edges      []edge
+}
+
+type graphConfigJSON struct {
+	Components []struct {
+		Id string `json:"id"`
+	} `json:"components"`
+	Rules []rule `json:"rules"`
+}
+
+func newGraph(components []string, rules []rule) graph {
+	g := graph{components: components, edges: []edge{}}
+	known, seen := map[string]bool{}, map[edge]bool{}
+	for _, c := range components {
+		known[c] = true
+	}
+	for _, r := range rules {
+		for _, e := range r.Events {
+			ed := edge{e.SourceId, e.TargetId}
+			if e.EventType != "message" || len(e.SourceId) == 0 || len(e.TargetId) == 0 || seen[ed] {
+				continue
+			}
+			seen[ed] = true
+			g.edges = append(g.edges, ed)
+			for _, id := range []string{e.SourceId, e.TargetId} {
+				if !known[id] {
+					known[id] = true
+					g.components = append(g.components, id)
+				}
+			}
+		}
+	}
+	return g
+}
+
+// configGraph reads the graph of the config named name in dir.
+func configGraph(dir, name string) (graph, error) {
+	if !safeName(name) {
+		return graph{}, fmt.Errorf("Invalid config name %v", name)
+	}
+	byt, err := ioutil.ReadFile(filepath.Join(dir, name+".json"))
+	if err != nil {
+		return graph{}, fmt.Errorf("Could not read config %v. err=%v", name, err)
+	}
+	var c graphConfigJSON
+	if err := json.Unmarshal(byt, &c); err != nil {
+		return graph{}, fmt.Errorf("Invalid config %v. err=%v", name, err)
+	}
+	components := []string{}
+	for _, cm := range c.Components {
+		components = append(components, cm.Id)
+	}
+	return newGraph(components, c.Rules), nil
+}
+
+func discoveredGraph(flow discoveredFlow) graph {
+	components, edges := []string{}, []edge{}
+	for _, c := range flow.Components {
+		components = append(components, c.Id)
+	}
+	for _, e := range flow.Edges {
+		edges = append(edges, edge{e.From, e.To})
+	}
+	return graph{components: components, edges: edges}
+}
+
+func throughputLabel(counts map[edge]int64, e edge) string {
+	if c, ok := counts[e]; ok {
+		return fmt.Sprintf("%v msg/min", c)
+	}
+	return ""
+}
+
+// dot renders g in Graphviz' DOT language, labelling edges with the messages
+// seen on them over the last minute.
+func (g graph) dot(counts map[edge]int64) []byte {
+	var b bytes.Buffer
+	b.WriteString("digraph flowbro {\n\trankdir=TB;\n\tnode [shape=box];\n")
+	for _, c := range g.components {
+		fmt.Fprintf(&b, "\t%q;\n", c)
+	}
+	for _, e := range g.edges {
+		if label := throughputLabel(counts, e); len(label) > 0 {
+			fmt.Fprintf(&b, "\t%q -> %q [label=%q];\n", e.source, e.target, label)
+		} else {
+			fmt.Fprintf(&b, "\t%q -> %q;\n", e.source, e.target)
+		}
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// mermaid renders g as a Mermaid flowchart. Component ids can hold any
+// character, so nodes get generated ids and the component id as label.
+func (g graph) mermaid(counts map[edge]int64) []byte {
+	var b bytes.Buffer
+	b.WriteString("flowchart TD\n")
+	ids := map[string]string{}
+	for i, c := range g.components {
+		ids[c] = fmt.Sprintf("n%v", i)
+		fmt.Fprintf(&b, "    %v[\"%v\"]\n", ids[c], mermaidEscape(c))
+	}
+	for _, e := range g.edges {
+		if label := throughputLabel(counts, e); len(label) > 0 {
+			fmt.Fprintf(&b, "    %v -->|\"%v\"| %v\n", ids[e.source], label, ids[e.target])
+		} else {
+			fmt.Fprintf(&b, "    %v --> %v\n", ids[e.source], ids[e.target])
+		}
+	}
+	return b.Bytes()
+}
+
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "\n", " ").Replace(s)
+}
+
+// graphHandler serves the graph of the config named by the config query
+// parameter, or of the most recently discovered flow if there's none.
+func graphHandler(contentType string, render func(graph, map[edge]int64) []byte) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+			return
+		}
+
+		var g graph
+		if name := r.URL.Query().Get("config"); len(name) > 0 {
+			var err error
+			if g, err = configGraph(mainPath, name); err != nil {
+				writeError(w, http.StatusNotFound, err)
+				return
+			}
+		} else {
+			lastDiscovered.Lock()
+			flow := lastDiscovered.flow
+			lastDiscovered.Unlock()
+			if flow == nil {
+				writeError(w, http.StatusNotFound, fmt.Errorf("No flow was discovered yet; pass ?config= to export a configured flow"))
+				return
+			}
+			g = discoveredGraph(*flow)
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Write(render(g, observedThroughput(time.Now())))
+	}
+}
@@ -0,0 +1,63 @@
+package flowbro
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGraphRendersConfiguredFlow(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+	config := `{"components": [{"id": "Endpoint"}, {"id": "Server"}], "rules": [
+		{"patterns": [{"field": "{{ .Topic }}", "pattern": "requests"}], "events": [{"eventType": "message", "sourceId": "Endpoint", "targetId": "Server"}]},
+		{"patterns": [{"field": "{{ .Topic }}", "pattern": "retries"}], "events": [{"eventType": "message", "sourceId": "Endpoint", "targetId": "Server"}]},
+		{"patterns": [{"field": "{{ .Topic }}", "pattern": "pushes"}], "events": [{"eventType": "message", "sourceId": "Server", "targetId": "Phone \"X\""}, {"eventType": "log", "text": "pushed"}]}
+	]}`
+	if err := ioutil.WriteFile(filepath.Join(dir, "example.json"), []byte(config), 0644); err != nil {
+		t.Fatalf("couldn't write config: %v", err)
+	}
+
+	g, err := configGraph(dir, "example")
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	counts := map[edge]int64{{"Endpoint", "Server"}: 42}
+
+	tests := []struct {
+		name     string
+		actual   string
+		expected string
+	}{
+		{
+			name:   "dot",
+			actual: string(g.dot(counts)),
+			expected: "digraph flowbro {\n\trankdir=TB;\n\tnode [shape=box];\n" +
+				"\t\"Endpoint\";\n\t\"Server\";\n\t\"Phone \\\"X\\\"\";\n" +
+				"\t\"Endpoint\" -> \"Server\" [label=\"42 msg/min\"];\n" +
+				"\t\"Server\" -> \"Phone \\\"X\\\"\";\n}\n",
+		},
+		{
+			name:   "mermaid",
+			actual: string(g.mermaid(counts)),
+			expected: "flowchart TD\n" +
+				"    n0[\"Endpoint\"]\n    n1[\"Server\"]\n    n2[\"Phone #quot;X#quot;\"]\n" +
+				"    n0 -->|\"42 msg/min\"| n1\n" +
+				"    n1 --> n2\n",
+		},
+	}
+	for _, ts := range tests {
+		if ts.actual != ts.expected {
+			t.Errorf("on '%v': expected\n%v\nbut got\n%v", ts.name, ts.expected, ts.actual)
+		}
+	}
+}
+
+func TestGraphRejectsConfigNamesOutsideTheConfigsDirectory(t *testing.T) {
+	for _, name := range []string{"", "../flowbro", ".hidden", `a\b`} {
+		if _, err := configGraph(os.TempDir(), name); err == nil {
+			t.Errorf("on '%v': expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package flowbro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"text/template"
+)
+
+// headless prints the events of a flow instead of serving them, as JSON
+// lines or through a template, making flowbro a console consumer that
+// decodes, filters and names what it reads, or shows them in a tui.
+type headless struct {
+	raw      json.RawMessage
+	template *template.Template
+	w        io.Writer
+	tui      *tui
+}
+
+func newHeadless(configPath, tmpl string, w io.Writer) (*headless, error) {
+	raw, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read config %v. err=%v", configPath, err)
+	}
+	if err := json.Unmarshal(raw, &configJSON{}); err != nil {
+		return nil, fmt.Errorf("Invalid config %v. err=%v", configPath, err)
+	}
+	h := &headless{raw: raw, w: w}
+	if len(tmpl) > 0 {
+		if h.template, err = template.New("headless").Funcs(transformFuncs).Option("missingkey=zero").Parse(tmpl); err != nil {
+			return nil, fmt.Errorf("Invalid template %v. err=%v", tmpl, err)
+		}
+	}
+	return h, nil
+}
+
+// run prints the flow until ctx is done or its source ends. Printing runs
+// as an operator: whoever starts flowbro headless owns its config.
+func (h *headless) run(ctx context.Context, f *flowbro) error {
+	var configJSON configJSON
+	json.Unmarshal(h.raw, &configJSON) // validated by newHeadless
+	if h.tui != nil {
+		if configJSON.Kafka.Stats == nil {
+			configJSON.Kafka.Stats = &statsJSON{IntervalSeconds: tuiStatsInterval.Seconds()}
+		}
+		return h.tui.run(ctx, func(c conn) {
+			f.stream(c, operator, h.raw, &configJSON, nil, nil)
+		})
+	}
+	r := (&http.Request{Method: "GET", URL: &url.URL{Path: "/headless"}, RemoteAddr: "stdout"}).WithContext(ctx)
+	f.stream(&printConn{w: h.w, r: r, template: h.template}, operator, h.raw, &configJSON, nil, nil)
+	return nil
+}
+
+// printConn writes events to w one per line, as JSON or rendered through
+// template; events the template renders empty are left out, so templates
+// can pick the events they print.
+type printConn struct {
+	w        io.Writer
+	r        *http.Request
+	template *template.Template
+
+	l      sync.Mutex
+	closed bool
+}
+
+func (c *printConn) owned() {}
+
+func (c *printConn) Write(b []byte) (int, error) {
+	var events []json.RawMessage
+	if err := json.Unmarshal(b, &events); err != nil {
+		return 0, err
+	}
+
+	var out bytes.Buffer
+	for _, raw := range events {
+		if c.template == nil {
+			out.Write(append(raw, '\n'))
+			continue
+		}
+		var e event
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return 0, err
+		}
+		var line bytes.Buffer
+		if err := c.template.Execute(&line, e); err != nil {
+			return 0, fmt.Errorf("Could not render event. err=%v", err)
+		}
+		if line.Len() == 0 {
+			continue
+		}
+		if !bytes.HasSuffix(line.Bytes(), []byte("\n")) {
+			line.WriteByte('\n')
+		}
+		out.Write(line.Bytes())
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if _, err := c.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close stops printing but leaves w open; it's usually stdout.
+func (c *printConn) Close() error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *printConn) Request() *http.Request {
+	return c.r
+}
@@ -0,0 +1,136 @@
+package flowbro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestNewHeadless(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	valid, invalid := filepath.Join(dir, "valid.json"), filepath.Join(dir, "invalid.json")
+	ioutil.WriteFile(valid, []byte(`{"rules":[]}`), 0644)
+	ioutil.WriteFile(invalid, []byte(`{"rules":`), 0644)
+
+	ts := []struct {
+		configPath, template string
+		fails                bool
+	}{
+		{valid, "", false},
+		{valid, "{{.Topic}} {{.Text}}", false},
+		{valid, "{{.Topic", true},
+		{invalid, "", true},
+		{filepath.Join(dir, "missing.json"), "", true},
+	}
+
+	for _, tc := range ts {
+		_, err := newHeadless(tc.configPath, tc.template, ioutil.Discard)
+		if tc.fails != (err != nil) {
+			t.Errorf("on '%v %v': expected failing to be %v but got err=%v", tc.configPath, tc.template, tc.fails, err)
+		}
+	}
+}
+
+func TestPrintConnWrite(t *testing.T) {
+	events, _ := json.Marshal([]event{{EventType: "message", Topic: "payments", Text: "paid"}, {EventType: "log", Text: "hello"}})
+	ts := []struct {
+		template string
+		expected string
+	}{
+		{"", `{"eventType":"message",`},
+		{"{{.Topic}}: {{.Text}}", "payments: paid\n: hello\n"},
+		{`{{if eq .EventType "message"}}{{.Text}}{{end}}`, "paid\n"},
+	}
+
+	for _, tc := range ts {
+		var b bytes.Buffer
+		c := &printConn{w: &b}
+		if len(tc.template) > 0 {
+			c.template = template.Must(template.New("").Parse(tc.template))
+		}
+		if _, err := c.Write(events); err != nil {
+			t.Errorf("on '%v': shouldn't have failed but did with %v", tc.template, err)
+			continue
+		}
+		if len(tc.template) == 0 {
+			if lines := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n"); len(lines) != 2 || !strings.HasPrefix(lines[0], tc.expected) {
+				t.Errorf("on '%v': expected an event per line but got %v", tc.template, b.String())
+			}
+			continue
+		}
+		if b.String() != tc.expected {
+			t.Errorf("on '%v': expected %q but got %q", tc.template, tc.expected, b.String())
+		}
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe to write and read concurrently.
+type lockedBuffer struct {
+	sync.Mutex
+	b bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.b.String()
+}
+
+func TestHeadlessPrintsFlow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixtures := filepath.Join(dir, "fixtures.json")
+	ioutil.WriteFile(fixtures, []byte(`{"topic":"payments","key":"1","value":{}}`), 0644)
+	conf, _ := json.Marshal(configJSON{Rules: []rule{{
+		Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "payments"}},
+		Events:   []event{{EventType: "message", SourceId: "a", TargetId: "b", FSMId: "{{.Key}}"}},
+	}}})
+	confPath := filepath.Join(dir, "payments.json")
+	ioutil.WriteFile(confPath, conf, 0644)
+
+	var out lockedBuffer
+	s, err := NewServer(WithMockPath(fixtures), WithHeadless(confPath, `{{if eq .EventType "message"}}{{.SourceId}}->{{.TargetId}} {{.FSMId}}{{end}}`, &out))
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	if s.listener != nil {
+		t.Errorf("expected headless servers not to listen but got %v", s.listener.Addr())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	for deadline := time.Now().Add(2 * time.Second); !strings.Contains(out.String(), "a->b 1\n") && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.HasPrefix(out.String(), "a->b 1\n") {
+		t.Errorf("expected only the payment's event to be printed but got %q", out.String())
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected printing to stop once the context is done")
+	}
+}
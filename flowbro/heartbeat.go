@@ -0,0 +1,115 @@
+package flowbro
+
+import (
+	"context"
+	"io"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/websocket"
+)
+
+type heartbeat struct {
+	UUID string `json:"uuid"`
+}
+
+const defaultHeartbeatTimeout = 10 * time.Second
+
+// processHeartbeats closes out when no heartbeat with uuid was received
+// within the timeout, until ctx is done.
+func processHeartbeats(ctx context.Context, wr wsRecv, out chan struct{}, uuid string, timeoutDuration time.Duration) {
+	hbCh := make(chan struct{})
+	timeout := time.NewTimer(timeoutDuration)
+	defer timeout.Stop()
+
+	go readHeartbeats(ctx, wr, hbCh, uuid)
+
+	for {
+		select {
+		case <-timeout.C:
+			close(out)
+			return
+		case <-hbCh:
+			timeout.Reset(timeoutDuration)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// readHeartbeats reads heartbeats until the connection is closed or ctx is
+// done.
+func readHeartbeats(ctx context.Context, wr wsRecv, out chan struct{}, uuid string) {
+	for {
+		hb, err := wr.recv()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"err": err}).Error("Error while reading heartbeat.")
+			return
+		}
+
+		if hb.UUID == uuid {
+			select {
+			case out <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+type wsRecv interface {
+	recv() (heartbeat, error)
+}
+
+// receiverOf returns what receives heartbeats and controls from ws.
+// Clients without a websocket can't send either, so they're alive for as
+// long as their request.
+func receiverOf(ws conn, controls chan control, ctx context.Context) wsRecv {
+	if c, ok := ws.(*websocket.Conn); ok {
+		return wsReceiver{ws: c, controls: controls, ctx: ctx}
+	}
+	return requestReceiver{ctx: ws.Request().Context()}
+}
+
+// requestReceiver sends the empty heartbeat every second until its request
+// is done.
+type requestReceiver struct {
+	ctx context.Context
+}
+
+func (rr requestReceiver) recv() (heartbeat, error) {
+	if !sleep(rr.ctx, time.Second) {
+		return heartbeat{}, io.EOF
+	}
+	return heartbeat{}, nil
+}
+
+type wsReceiver struct {
+	ws       *websocket.Conn
+	controls chan control
+	ctx      context.Context
+}
+
+// recv returns the next heartbeat, passing control messages received in
+// between on to the controls channel.
+func (wr wsReceiver) recv() (heartbeat, error) {
+	for {
+		var msg struct {
+			heartbeat
+			control
+		}
+		if err := websocket.JSON.Receive(wr.ws, &msg); err != nil {
+			return heartbeat{}, err
+		}
+		if len(msg.Action) == 0 || wr.controls == nil {
+			return msg.heartbeat, nil
+		}
+		select {
+		case wr.controls <- msg.control:
+		case <-wr.ctx.Done():
+		}
+	}
+}
@@ -1,6 +1,7 @@
-package main
+package flowbro
 
 import (
+	"context"
 	"testing"
 	"time"
 )
@@ -8,7 +9,7 @@ import (
 func TestProcessHeartbeatTimesOut(t *testing.T) {
 	timeout := make(chan struct{})
 
-	go processHeartbeats(blockingWR{}, timeout, "uuid", 10*time.Millisecond)
+	go processHeartbeats(context.Background(), blockingWR{}, timeout, "uuid", 10*time.Millisecond)
 
 	select {
 	case <-timeout:
@@ -20,7 +21,7 @@ func TestProcessHeartbeatTimesOut(t *testing.T) {
 func TestProcessHeartbeatTimesOutGivenWrongUUID(t *testing.T) {
 	timeout := make(chan struct{})
 
-	go processHeartbeats(invalidWR{}, timeout, "uuid", 10*time.Millisecond)
+	go processHeartbeats(context.Background(), invalidWR{}, timeout, "uuid", 10*time.Millisecond)
 
 	select {
 	case <-timeout:
@@ -32,7 +33,7 @@ func TestProcessHeartbeatTimesOutGivenWrongUUID(t *testing.T) {
 func TestProcessHeartbeatDoesntTimeout(t *testing.T) {
 	timeout := make(chan struct{})
 
-	go processHeartbeats(validWR{}, timeout, "uuid", 10*time.Millisecond)
+	go processHeartbeats(context.Background(), validWR{}, timeout, "uuid", 10*time.Millisecond)
 
 	select {
 	case <-timeout:
@@ -0,0 +1,296 @@
+package flowbro
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type influxJSON struct {
+	URL             string            `json:"url"`
+	Token           string            `json:"token"`
+	Username        string            `json:"username"`
+	Password        string            `json:"password"`
+	Measurement     string            `json:"measurement"`
+	GroupBy         []string          `json:"groupBy"`
+	Tags            map[string]string `json:"tags"`
+	IntervalSeconds float64           `json:"intervalSeconds"`
+}
+
+const (
+	defaultInfluxMeasurement = "flowbro"
+	defaultInfluxInterval    = 10 * time.Second
+	influxQueueSize          = 10
+)
+
+var influxWrites = newCounter("flowbro_influx_writes_total", "Batches of statistics written to InfluxDB, by outcome.", "outcome")
+
+// influxKey is what statistics are counted by: always the edge, and its
+// cluster and topic if grouped by them.
+type influxKey struct {
+	cluster, topic, source, target string
+}
+
+// influxWriter writes how many message events crossed each edge of the flow,
+// and went in and out of each component, over every interval as InfluxDB
+// line protocol, over HTTP or UDP, from a goroutine of its own.
+type influxWriter struct {
+	url         *url.URL
+	token       string
+	username    string
+	password    string
+	measurement string
+	byCluster   bool
+	byTopic     bool
+	tags        string
+	interval    time.Duration
+	client      *http.Client
+
+	counts    map[influxKey]int64
+	lastFlush time.Time
+	out       chan []byte
+	failures  chan event
+}
+
+func processInflux(c *influxJSON) (*influxWriter, error) {
+	if c == nil {
+		return nil, nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "udp") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("Invalid influx url %v; please use http(s)://<host>/<write path> or udp://<host:port>", c.URL)
+	}
+	if c.IntervalSeconds < 0 {
+		return nil, fmt.Errorf("Invalid influx intervalSeconds %v; it can't be negative", c.IntervalSeconds)
+	}
+	w := &influxWriter{
+		url:         u,
+		token:       c.Token,
+		username:    c.Username,
+		password:    c.Password,
+		measurement: c.Measurement,
+		interval:    defaultInfluxInterval,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		counts:      map[influxKey]int64{},
+	}
+	if len(w.measurement) == 0 {
+		w.measurement = defaultInfluxMeasurement
+	}
+	if c.IntervalSeconds > 0 {
+		w.interval = time.Duration(c.IntervalSeconds * float64(time.Second))
+	}
+	groupBy := c.GroupBy
+	if groupBy == nil {
+		groupBy = []string{"cluster", "topic"}
+	}
+	for _, g := range groupBy {
+		switch g {
+		case "cluster":
+			w.byCluster = true
+		case "topic":
+			w.byTopic = true
+		default:
+			return nil, fmt.Errorf("Invalid influx groupBy %v; please use cluster and/or topic", g)
+		}
+	}
+	names := []string{}
+	for name := range c.Tags {
+		switch name {
+		case "cluster", "topic", "source", "target", "component":
+			return nil, fmt.Errorf("Influx tag %v is set by flowbro; please pick another name", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.tags += "," + influxEscape(name) + "=" + influxEscape(c.Tags[name])
+	}
+	return w, nil
+}
+
+// startInflux starts writing; until then, statistics are neither counted
+// nor written.
+func startInflux(w *influxWriter, life *lifecycle) {
+	if w == nil {
+		return
+	}
+	w.out, w.failures = make(chan []byte, influxQueueSize), make(chan event, 10)
+	w.lastFlush = time.Now()
+	life.spawn(w.run)
+}
+
+// onEvents counts the message events m produced.
+func (w *influxWriter) onEvents(m message, events []event) {
+	if w == nil || w.out == nil {
+		return
+	}
+	for _, e := range events {
+		if e.EventType != "message" {
+			continue
+		}
+		k := influxKey{source: e.SourceId, target: e.TargetId}
+		if w.byCluster {
+			k.cluster = m.Cluster
+		}
+		if w.byTopic {
+			k.topic = m.Topic
+		}
+		count := e.Count
+		if count <= 0 {
+			count = 1
+		}
+		w.counts[k] += count
+	}
+}
+
+// flush queues the statistics of the interval once it has passed, returning
+// the failures of previous writes.
+func (w *influxWriter) flush(now time.Time) []event {
+	if w == nil || w.out == nil {
+		return nil
+	}
+	events := []event{}
+	for done := false; !done; {
+		select {
+		case e := <-w.failures:
+			events = append(events, e)
+		default:
+			done = true
+		}
+	}
+	elapsed := now.Sub(w.lastFlush)
+	if elapsed < w.interval {
+		return events
+	}
+	w.lastFlush = now
+	if len(w.counts) == 0 {
+		return events
+	}
+	select {
+	case w.out <- w.lines(elapsed, now):
+	default:
+		influxWrites.inc("dropped")
+	}
+	w.counts = map[influxKey]int64{}
+	return events
+}
+
+// lines renders the counts as line protocol, e.g.
+// flowbro_edges,cluster=eu,topic=orders,source=shop,target=billing messages=42i,rate=4.2 <ns>
+// and flowbro_components,cluster=eu,topic=orders,component=billing in=42i,out=0i <ns>.
+func (w *influxWriter) lines(elapsed time.Duration, now time.Time) []byte {
+	type componentKey struct{ cluster, topic, component string }
+	components := map[componentKey][2]int64{}
+	keys := []influxKey{}
+	for k, c := range w.counts {
+		keys = append(keys, k)
+		in, out := componentKey{k.cluster, k.topic, k.target}, componentKey{k.cluster, k.topic, k.source}
+		components[in] = [2]int64{components[in][0] + c, components[in][1]}
+		components[out] = [2]int64{components[out][0], components[out][1] + c}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		return fmt.Sprint(a.cluster, "\x00", a.topic, "\x00", a.source, "\x00", a.target) < fmt.Sprint(b.cluster, "\x00", b.topic, "\x00", b.source, "\x00", b.target)
+	})
+	group := func(cluster, topic string) string {
+		tags := ""
+		if w.byCluster && len(cluster) > 0 {
+			tags += ",cluster=" + influxEscape(cluster)
+		}
+		if w.byTopic && len(topic) > 0 {
+			tags += ",topic=" + influxEscape(topic)
+		}
+		return tags
+	}
+
+	var b bytes.Buffer
+	ts := now.UnixNano()
+	measurement := strings.NewReplacer(",", `\,`, " ", `\ `).Replace(w.measurement)
+	for _, k := range keys {
+		c := w.counts[k]
+		fmt.Fprintf(&b, "%v_edges%v%v,source=%v,target=%v messages=%vi,rate=%v %v\n", measurement, w.tags, group(k.cluster, k.topic), influxEscape(k.source), influxEscape(k.target), c, float64(c)/elapsed.Seconds(), ts)
+	}
+	ckeys := []componentKey{}
+	for k := range components {
+		ckeys = append(ckeys, k)
+	}
+	sort.Slice(ckeys, func(i, j int) bool {
+		a, b := ckeys[i], ckeys[j]
+		return fmt.Sprint(a.cluster, "\x00", a.topic, "\x00", a.component) < fmt.Sprint(b.cluster, "\x00", b.topic, "\x00", b.component)
+	})
+	for _, k := range ckeys {
+		c := components[k]
+		fmt.Fprintf(&b, "%v_components%v%v,component=%v in=%vi,out=%vi %v\n", measurement, w.tags, group(k.cluster, k.topic), influxEscape(k.component), c[0], c[1], ts)
+	}
+	return b.Bytes()
+}
+
+// influxEscape escapes tag keys and values; empty values, which line
+// protocol doesn't allow, become "none".
+func influxEscape(s string) string {
+	if len(s) == 0 {
+		return "none"
+	}
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`).Replace(s)
+}
+
+func (w *influxWriter) run(ctx context.Context) {
+	for {
+		select {
+		case body := <-w.out:
+			if err := w.write(ctx, body); err != nil {
+				influxWrites.inc("failure")
+				text := fmt.Sprintf("Could not write statistics to influx %v. err=%v", w.url.Host, err)
+				log.WithFields(log.Fields{"url": w.url.Host, "err": err}).Error(text)
+				select {
+				case w.failures <- event{EventType: "log", Text: text, Color: "error"}:
+				default:
+				}
+				continue
+			}
+			influxWrites.inc("success")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *influxWriter) write(ctx context.Context, body []byte) error {
+	if w.url.Scheme == "udp" {
+		c, err := net.Dial("udp", w.url.Host)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		_, err = c.Write(body)
+		return err
+	}
+	req, err := http.NewRequest("POST", w.url.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if len(w.token) > 0 {
+		req.Header.Set("Authorization", "Token "+w.token)
+	} else if len(w.username) > 0 {
+		req.SetBasicAuth(w.username, w.password)
+	}
+	r, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if r.StatusCode >= 300 {
+		return fmt.Errorf("%v answered with status %v", w.url.Host, r.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package flowbro
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessInflux(t *testing.T) {
+	ts := []struct {
+		c                 *influxJSON
+		expectedByCluster bool
+		expectedByTopic   bool
+		expectedTags      string
+		expectedInterval  time.Duration
+		fails             bool
+	}{
+		{&influxJSON{URL: "http://influx:8086/write?db=flows"}, true, true, "", defaultInfluxInterval, false},
+		{&influxJSON{URL: "udp://influx:8089", GroupBy: []string{"topic"}, Tags: map[string]string{"env": "prod", "dc": "eu 1"}, IntervalSeconds: 5}, false, true, `,dc=eu\ 1,env=prod`, 5 * time.Second, false},
+		{&influxJSON{URL: "https://influx/api/v2/write", GroupBy: []string{}}, false, false, "", defaultInfluxInterval, false},
+		{&influxJSON{URL: "http://influx:8086/write", GroupBy: []string{"partition"}}, false, false, "", 0, true},
+		{&influxJSON{URL: "http://influx:8086/write", Tags: map[string]string{"topic": "x"}}, false, false, "", 0, true},
+		{&influxJSON{URL: "http://influx:8086/write", IntervalSeconds: -1}, false, false, "", 0, true},
+		{&influxJSON{URL: "tcp://influx:8086"}, false, false, "", 0, true},
+		{&influxJSON{}, false, false, "", 0, true},
+	}
+
+	for _, tc := range ts {
+		w, err := processInflux(tc.c)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("on '%+v': expected processing to fail", tc.c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on '%+v': shouldn't have failed but did with %v", tc.c, err)
+			continue
+		}
+		if w.byCluster != tc.expectedByCluster || w.byTopic != tc.expectedByTopic || w.tags != tc.expectedTags || w.interval != tc.expectedInterval {
+			t.Errorf("on '%+v': expected cluster %v, topic %v, tags %q and interval %v but got %+v", tc.c, tc.expectedByCluster, tc.expectedByTopic, tc.expectedTags, tc.expectedInterval, w)
+		}
+	}
+}
+
+func TestInfluxLines(t *testing.T) {
+	w, err := processInflux(&influxJSON{URL: "udp://influx:8089", Tags: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.out = make(chan []byte, 1)
+	orders := message{Cluster: "eu", Topic: "orders"}
+	w.onEvents(orders, []event{{EventType: "message", SourceId: "shop", TargetId: "billing", Count: 1}, {EventType: "alias", FSMId: "1"}})
+	w.onEvents(orders, []event{{EventType: "message", SourceId: "shop", TargetId: "billing", Count: 3}})
+	w.onEvents(message{Cluster: "eu", Topic: "invoices"}, []event{{EventType: "message", SourceId: "billing", TargetId: "mail room", Count: 1}})
+
+	now := time.Unix(1500000000, 0)
+	expected := strings.Join([]string{
+		`flowbro_edges,env=prod,cluster=eu,topic=invoices,source=billing,target=mail\ room messages=1i,rate=0.1 1500000000000000000`,
+		`flowbro_edges,env=prod,cluster=eu,topic=orders,source=shop,target=billing messages=4i,rate=0.4 1500000000000000000`,
+		`flowbro_components,env=prod,cluster=eu,topic=invoices,component=billing in=0i,out=1i 1500000000000000000`,
+		`flowbro_components,env=prod,cluster=eu,topic=invoices,component=mail\ room in=1i,out=0i 1500000000000000000`,
+		`flowbro_components,env=prod,cluster=eu,topic=orders,component=billing in=4i,out=0i 1500000000000000000`,
+		`flowbro_components,env=prod,cluster=eu,topic=orders,component=shop in=0i,out=4i 1500000000000000000`,
+	}, "\n") + "\n"
+	if actual := string(w.lines(10*time.Second, now)); actual != expected {
+		t.Errorf("expected\n%v\nbut got\n%v", expected, actual)
+	}
+}
+
+func TestInfluxFlush(t *testing.T) {
+	w, err := processInflux(&influxJSON{URL: "udp://influx:8089", IntervalSeconds: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.onEvents(message{Topic: "orders"}, []event{{EventType: "message", SourceId: "a", TargetId: "b", Count: 1}})
+	if len(w.counts) != 0 {
+		t.Errorf("expected nothing to be counted before starting but got %v", w.counts)
+	}
+
+	start := time.Now()
+	w.out, w.failures, w.lastFlush = make(chan []byte, 1), make(chan event, 1), start
+	w.onEvents(message{Topic: "orders"}, []event{{EventType: "message", SourceId: "a", TargetId: "b", Count: 1}})
+	w.failures <- event{EventType: "log", Text: "failed"}
+	if events := w.flush(start.Add(5 * time.Second)); len(events) != 1 || len(w.out) != 0 {
+		t.Errorf("expected the failure before the interval ended, and nothing written, but got %v", events)
+	}
+	if events := w.flush(start.Add(10 * time.Second)); len(events) != 0 || len(w.out) != 1 || len(w.counts) != 0 {
+		t.Errorf("expected the interval's counts to be written once it ended but got %v queued", len(w.out))
+	}
+}
+
+func TestInfluxWrite(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+
+	for _, c := range []influxJSON{{URL: server.URL + "/api/v2/write?bucket=flows", Token: "secret"}, {URL: "udp://" + agent.LocalAddr().String()}} {
+		w, err := processInflux(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.write(context.Background(), []byte("flowbro_edges,source=a,target=b messages=1i 1\n")); err != nil {
+			t.Errorf("on '%v': shouldn't have failed writing but did with %v", c.URL, err)
+			continue
+		}
+		var body string
+		if w.url.Scheme == "udp" {
+			b := make([]byte, 1024)
+			agent.SetReadDeadline(time.Now().Add(2 * time.Second))
+			n, _, err := agent.ReadFrom(b)
+			if err != nil {
+				t.Fatalf("expected a packet. err=%v", err)
+			}
+			body = string(b[:n])
+		} else {
+			body = <-received
+		}
+		if !strings.HasPrefix(body, "flowbro_edges,source=a") {
+			t.Errorf("on '%v': expected the lines to be written but got %q", c.URL, body)
+		}
+	}
+
+	w, _ := processInflux(&influxJSON{URL: server.URL + "/write"})
+	if err := w.write(context.Background(), []byte("x")); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected the rejection to fail the write but got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package flowbro
+
+import (
+	"fmt"
+	"regexp"
+)
+
+type internalTopicsJSON struct {
+	Include bool   `json:"include"`
+	Topics  string `json:"topics"`
+}
+
+// defaultInternalTopics matches the topics Kafka and its ecosystem keep
+// their own state in: __consumer_offsets, __transaction_state, _schemas and
+// Kafka Connect's configs, offsets and status topics.
+const defaultInternalTopics = `_.*|(.*-)?connect-(configs|offsets|status)`
+
+// internalTopics tells which topics hold state rather than application
+// messages, which flowbro only consumes when asked to.
+type internalTopics struct {
+	include bool
+	topics  *regexp.Regexp
+}
+
+func processInternalTopics(c *internalTopicsJSON) (internalTopics, error) {
+	if c == nil {
+		c = &internalTopicsJSON{}
+	}
+	expr := c.Topics
+	if len(expr) == 0 {
+		expr = defaultInternalTopics
+	}
+	topics, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return internalTopics{}, fmt.Errorf("Invalid internal topics regex %v. err=%v", c.Topics, err)
+	}
+	return internalTopics{include: c.Include, topics: topics}, nil
+}
+
+func (i internalTopics) internal(topic string) bool {
+	return i.topics.MatchString(topic)
+}
+
+// check fails for consumers of internal topics unless they are included.
+func (i internalTopics) check(topic string) error {
+	if i.include || !i.internal(topic) {
+		return nil
+	}
+	return fmt.Errorf("Topic %v is an internal topic; set \"kafka\": {\"internal\": {\"include\": true}} to consume it", topic)
+}
@@ -0,0 +1,55 @@
+package flowbro
+
+import "testing"
+
+func TestInternalTopics(t *testing.T) {
+	ts := []struct {
+		c        *internalTopicsJSON
+		topic    string
+		internal bool
+		fails    bool
+	}{
+		{nil, "orders", false, false},
+		{nil, "__consumer_offsets", true, true},
+		{nil, "__transaction_state", true, true},
+		{nil, "_schemas", true, true},
+		{nil, "connect-offsets", true, true},
+		{nil, "docker-connect-status", true, true},
+		{nil, "connect-orders", false, false},
+		{&internalTopicsJSON{Include: true}, "__consumer_offsets", true, false},
+		{&internalTopicsJSON{Topics: "__.*|ops-.*"}, "_schemas", false, false},
+		{&internalTopicsJSON{Topics: "__.*|ops-.*"}, "ops-heartbeats", true, true},
+	}
+
+	for _, tc := range ts {
+		i, err := processInternalTopics(tc.c)
+		if err != nil {
+			t.Errorf("on '%+v': shouldn't have failed but did with %v", tc.c, err)
+			continue
+		}
+		if i.internal(tc.topic) != tc.internal {
+			t.Errorf("on '%+v': expected %v being internal to be %v", tc.c, tc.topic, tc.internal)
+		}
+		if err := i.check(tc.topic); (err != nil) != tc.fails {
+			t.Errorf("on '%+v': expected consuming %v to fail to be %v but got %v", tc.c, tc.topic, tc.fails, err)
+		}
+	}
+}
+
+func TestInternalTopicsConfig(t *testing.T) {
+	ts := []struct {
+		kafka kafka
+		fails bool
+	}{
+		{kafka{Brokers: "local:9092", Consumers: []consumerConfigJson{{Topic: "orders"}}}, false},
+		{kafka{Brokers: "local:9092", Consumers: []consumerConfigJson{{Topic: "__consumer_offsets"}}}, true},
+		{kafka{Brokers: "local:9092", Consumers: []consumerConfigJson{{Topic: "__consumer_offsets"}}, Internal: &internalTopicsJSON{Include: true}}, false},
+		{kafka{Brokers: "local:9092", Internal: &internalTopicsJSON{Topics: "("}}, true},
+	}
+
+	for _, tc := range ts {
+		if _, err := processConfig(&configJSON{Kafka: tc.kafka}); (err != nil) != tc.fails {
+			t.Errorf("on '%+v': expected failing to be %v but got %v", tc.kafka, tc.fails, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package flowbro
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+type joinJSON struct {
+	Name          string  `json:"name"`
+	Left          string  `json:"left"`
+	Right         string  `json:"right"`
+	LeftKey       string  `json:"leftKey"`
+	RightKey      string  `json:"rightKey"`
+	WindowSeconds float64 `json:"windowSeconds"`
+}
+
+// join combines messages of two topics with the same key whose timestamps
+// are within the window of each other into a message on a topic named after
+// the join, e.g. an order with its payment, for rules to draw as one edge.
+type join struct {
+	name          string
+	left, right   *regexp.Regexp
+	leftKey       *celProgram
+	rightKey      *celProgram
+	window        time.Duration
+	lefts, rights map[string]joinSide
+}
+
+type joinSide struct {
+	m    message
+	seen time.Time
+}
+
+const defaultJoinWindow = time.Minute
+
+// joinMaxKeys bounds the messages waiting for their counterpart per side of
+// a join; once reached only known keys are updated until others expire.
+const joinMaxKeys = 100000
+
+func processJoins(joinsJSON []joinJSON) ([]*join, error) {
+	joins := []*join{}
+	for _, j := range joinsJSON {
+		if len(j.Name) == 0 || len(j.Left) == 0 || len(j.Right) == 0 || len(j.LeftKey) == 0 {
+			return nil, fmt.Errorf("Please define name, left, right and leftKey for your join %v", j)
+		}
+		left, err := regexp.Compile("^(?:" + j.Left + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid left topic regex for join %v. err=%v", j.Name, err)
+		}
+		right, err := regexp.Compile("^(?:" + j.Right + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid right topic regex for join %v. err=%v", j.Name, err)
+		}
+		leftKey, err := compileCEL(j.LeftKey)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid leftKey for join %v. err=%v", j.Name, err)
+		}
+		rightKey := leftKey
+		if len(j.RightKey) > 0 {
+			if rightKey, err = compileCEL(j.RightKey); err != nil {
+				return nil, fmt.Errorf("Invalid rightKey for join %v. err=%v", j.Name, err)
+			}
+		}
+		window := time.Duration(j.WindowSeconds * float64(time.Second))
+		if window <= 0 {
+			window = defaultJoinWindow
+		}
+		joins = append(joins, &join{
+			name:     j.Name,
+			left:     left,
+			right:    right,
+			leftKey:  leftKey,
+			rightKey: rightKey,
+			window:   window,
+			lefts:    map[string]joinSide{},
+			rights:   map[string]joinSide{},
+		})
+	}
+	return joins, nil
+}
+
+// applyJoins returns the joined messages m completes.
+func applyJoins(joins []*join, m message, now time.Time) []message {
+	joined := []message{}
+	for _, j := range joins {
+		if j.left.MatchString(m.Topic) {
+			if jm, ok := j.onMessage(m, now, j.leftKey, j.lefts, j.rights, true); ok {
+				joined = append(joined, jm)
+			}
+		}
+		if j.right.MatchString(m.Topic) {
+			if jm, ok := j.onMessage(m, now, j.rightKey, j.rights, j.lefts, false); ok {
+				joined = append(joined, jm)
+			}
+		}
+	}
+	return joined
+}
+
+func (j *join) onMessage(m message, now time.Time, key *celProgram, own, other map[string]joinSide, isLeft bool) (message, bool) {
+	k, err := key.eval(m)
+	if err != nil || k == nil {
+		return message{}, false
+	}
+	id := fmt.Sprint(k)
+	if _, ok := own[id]; ok || len(own) < joinMaxKeys {
+		own[id] = joinSide{m: m, seen: now}
+	}
+
+	o, ok := other[id]
+	if !ok {
+		return message{}, false
+	}
+	apart := m.Timestamp.Sub(o.m.Timestamp)
+	if apart < 0 {
+		apart = -apart
+	}
+	if apart > j.window {
+		return message{}, false
+	}
+
+	left, right := m, o.m
+	if !isLeft {
+		left, right = o.m, m
+	}
+	return message{
+		Key:       id,
+		Value:     map[string]interface{}{"left": left.Value, "right": right.Value},
+		Topic:     j.name,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Timestamp: m.Timestamp,
+		Cluster:   m.Cluster,
+		Tags:      map[string]string{"left": left.Topic, "right": right.Topic},
+	}, true
+}
+
+// expireJoins forgets messages that have waited for their counterpart for
+// longer than the window.
+func expireJoins(joins []*join, now time.Time) {
+	for _, j := range joins {
+		for _, side := range []map[string]joinSide{j.lefts, j.rights} {
+			for id, s := range side {
+				if now.Sub(s.seen) > j.window {
+					delete(side, id)
+				}
+			}
+		}
+	}
+}
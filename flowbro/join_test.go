@@ -0,0 +1,62 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJoins(t *testing.T) {
+	joins, err := processJoins([]joinJSON{{Name: "paid-orders", Left: "orders", Right: "payments", LeftKey: "key", RightKey: "value.orderId", WindowSeconds: 10}})
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	order := message{Topic: "orders", Key: "42", Value: newValueFrom(`{"total":10}`), Timestamp: start}
+	if joined := applyJoins(joins, order, start); len(joined) != 0 {
+		t.Errorf("expected no joins for an order without payment but got %+v", joined)
+	}
+	late := message{Topic: "payments", Value: newValueFrom(`{"orderId":"42","late":true}`), Timestamp: start.Add(time.Minute)}
+	if joined := applyJoins(joins, late, start.Add(time.Second)); len(joined) != 0 {
+		t.Errorf("expected no joins for a payment outside the window but got %+v", joined)
+	}
+
+	payment := message{Topic: "payments", Value: newValueFrom(`{"orderId":"42"}`), Timestamp: start.Add(3 * time.Second)}
+	joined := applyJoins(joins, payment, start.Add(3*time.Second))
+	if len(joined) != 1 {
+		t.Fatalf("expected the payment to join its order but got %+v", joined)
+	}
+	j := joined[0]
+	if j.Topic != "paid-orders" || j.Key != "42" || j.Tags["left"] != "orders" || j.Tags["right"] != "payments" {
+		t.Errorf("expected a paid-orders message for order 42 but got %+v", j)
+	}
+	if left, ok := j.Value["left"].(map[string]interface{}); !ok || left["total"] != float64(10) {
+		t.Errorf("expected the order on the left but got %+v", j.Value)
+	}
+	if right, ok := j.Value["right"].(map[string]interface{}); !ok || right["orderId"] != "42" {
+		t.Errorf("expected the payment on the right but got %+v", j.Value)
+	}
+
+	expireJoins(joins, start.Add(5*time.Second))
+	if len(joins[0].lefts) != 1 || len(joins[0].rights) != 1 {
+		t.Errorf("expected nothing to expire within the window")
+	}
+	expireJoins(joins, start.Add(20*time.Second))
+	if len(joins[0].lefts) != 0 || len(joins[0].rights) != 0 {
+		t.Errorf("expected messages older than the window to be forgotten")
+	}
+}
+
+func TestInvalidJoins(t *testing.T) {
+	configs := [][]joinJSON{
+		{{Left: "a", Right: "b", LeftKey: "key"}},
+		{{Name: "j", Left: "a", Right: "b"}},
+		{{Name: "j", Left: "(", Right: "b", LeftKey: "key"}},
+		{{Name: "j", Left: "a", Right: "b", LeftKey: "key", RightKey: "value.id =="}},
+	}
+	for i, c := range configs {
+		if _, err := processJoins(c); err == nil {
+			t.Errorf("on config %v: expected processJoins to fail", i)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package flowbro
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is a parsed JSONPath selector, supporting the subset useful to
+// point at fields of decoded values: $.a.b, $['a'], $.a[0], $.a[*], $.* and
+// recursive descent with $..a.
+type jsonPath []jsonPathStep
+
+type jsonPathStep struct {
+	kind  string // field, index, wildcard or descendant
+	name  string
+	index int
+}
+
+func parseJSONPath(src string) (jsonPath, error) {
+	s := strings.TrimSpace(src)
+	if !strings.HasPrefix(s, "$") {
+		return nil, fmt.Errorf("Invalid JSONPath %v: must start with $", src)
+	}
+	s = s[1:]
+
+	p := jsonPath{}
+	for len(s) > 0 {
+		switch {
+		case strings.HasPrefix(s, ".."):
+			s = s[2:]
+			name := jsonPathName(s)
+			if len(name) == 0 {
+				return nil, fmt.Errorf("Invalid JSONPath %v: expected a field name after ..", src)
+			}
+			p, s = append(p, jsonPathStep{kind: "descendant", name: name}), s[len(name):]
+		case strings.HasPrefix(s, ".*"):
+			p, s = append(p, jsonPathStep{kind: "wildcard"}), s[2:]
+		case strings.HasPrefix(s, "."):
+			name := jsonPathName(s[1:])
+			if len(name) == 0 {
+				return nil, fmt.Errorf("Invalid JSONPath %v: expected a field name after .", src)
+			}
+			p, s = append(p, jsonPathStep{kind: "field", name: name}), s[1+len(name):]
+		case strings.HasPrefix(s, "["):
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("Invalid JSONPath %v: unterminated [", src)
+			}
+			inner := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+			switch {
+			case inner == "*":
+				p = append(p, jsonPathStep{kind: "wildcard"})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				p = append(p, jsonPathStep{kind: "field", name: inner[1 : len(inner)-1]})
+			default:
+				i, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid JSONPath %v: bad index %v", src, inner)
+				}
+				p = append(p, jsonPathStep{kind: "index", index: i})
+			}
+		default:
+			return nil, fmt.Errorf("Invalid JSONPath %v: unexpected %v", src, s)
+		}
+	}
+	if len(p) == 0 {
+		return nil, fmt.Errorf("Invalid JSONPath %v: selects the whole value", src)
+	}
+	return p, nil
+}
+
+func jsonPathName(s string) string {
+	i := strings.IndexAny(s, ".[")
+	if i < 0 {
+		return s
+	}
+	return s[:i]
+}
+
+// update calls f with every value selected in v, replacing each with what f
+// returns, or removing it when f returns false.
+func (p jsonPath) update(v interface{}, f func(interface{}) (interface{}, bool)) {
+	if len(p) == 0 {
+		return
+	}
+	step, rest := p[0], p[1:]
+
+	visit := func(child interface{}, set func(interface{}), remove func()) {
+		if len(rest) > 0 {
+			rest.update(child, f)
+			return
+		}
+		if nv, keep := f(child); keep {
+			set(nv)
+		} else {
+			remove()
+		}
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			k := k
+			set, remove := func(nv interface{}) { t[k] = nv }, func() { delete(t, k) }
+			switch {
+			case step.kind == "wildcard", step.kind == "field" && k == step.name, step.kind == "descendant" && k == step.name:
+				visit(child, set, remove)
+			}
+			if step.kind == "descendant" && k != step.name {
+				p.update(child, f)
+			}
+		}
+	case []interface{}:
+		for i, child := range t {
+			i := i
+			set, remove := func(nv interface{}) { t[i] = nv }, func() { t[i] = nil }
+			switch {
+			case step.kind == "wildcard", step.kind == "index" && (i == step.index || i == len(t)+step.index):
+				visit(child, set, remove)
+			case step.kind == "descendant":
+				p.update(child, f)
+			}
+		}
+	}
+}
@@ -0,0 +1,703 @@
+package flowbro
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+type cluster struct {
+	alias    string
+	brokers  []string
+	policy   connectionPolicy
+	clientId string
+	version  sarama.KafkaVersion
+	view     string
+	consumer sarama.Consumer
+	client   sarama.Client
+
+	partitionConsumers []sarama.PartitionConsumer
+	assignments        map[string]map[int32]assignment
+	pcLock             sync.Mutex
+	closing            bool
+
+	backlog     map[string]map[int32]int64
+	backlogLock sync.Mutex
+
+	out     chan *SourceMessage
+	limiter *rateLimiter
+	life    *lifecycle
+	status  func(event) error
+
+	es errorlist
+}
+
+// fromConsumerMessage turns a message consumed from Kafka into one of a
+// source.
+func fromConsumerMessage(cm *sarama.ConsumerMessage) *SourceMessage {
+	return &SourceMessage{Topic: cm.Topic, Partition: cm.Partition, Offset: cm.Offset, Key: cm.Key, Value: cm.Value, Timestamp: cm.Timestamp}
+}
+
+// clusters are all the clusters a session consumes from.
+type clusters []*cluster
+
+// assignment is a partition being consumed, remembered so it can be
+// reseeked or restarted.
+type assignment struct {
+	pc      sarama.PartitionConsumer
+	ch      <-chan *sarama.ConsumerMessage
+	offset  int64
+	limiter *rateLimiter
+}
+
+func newAssignment(pc sarama.PartitionConsumer, offset int64, limiter *rateLimiter) assignment {
+	return assignment{pc: pc, ch: throttle(pc.Messages(), limiter), offset: offset, limiter: limiter}
+}
+
+func (c *cluster) addPartitionConsumer(topic string, partition int32, a assignment) {
+	c.pcLock.Lock()
+	c.partitionConsumers = append(c.partitionConsumers, a.pc)
+	if c.assignments == nil {
+		c.assignments = map[string]map[int32]assignment{}
+	}
+	if _, ok := c.assignments[topic]; !ok {
+		c.assignments[topic] = map[int32]assignment{}
+	}
+	c.assignments[topic][partition] = a
+	c.pcLock.Unlock()
+	go c.drainErrors(topic, partition, a.pc)
+}
+
+// drainErrors logs, counts and reports the errors of pc until it's closed.
+func (c *cluster) drainErrors(topic string, partition int32, pc sarama.PartitionConsumer) {
+	for err := range pc.Errors() {
+		log.WithFields(log.Fields{"cluster": c.alias, "brokers": c.brokers, "topic": topic, "partition": partition, "err": err.Err}).Error("Partition consumer failed.")
+		consumerErrors.inc(c.alias, topic, strconv.Itoa(int(partition)))
+		text := fmt.Sprintf("Error consuming topic %v partition %v of cluster %v. err=%v", topic, partition, c, err.Err)
+		if err.Err == errUnsupportedCompression {
+			text = fmt.Sprintf("Topic %v partition %v of cluster %v holds batches in a codec flowbro's Kafka client can't fetch, likely zstd which needs Kafka version %v; %v", topic, partition, c, zstdVersion, upgradeHint(zstdVersion, "please have the producer use another codec"))
+		}
+		c.report(event{
+			EventType: "consumerError",
+			Text:      text,
+			Color:     "error",
+			Cluster:   c.alias,
+			Brokers:   strings.Join(c.brokers, ","),
+			Topic:     topic,
+			Partition: &partition,
+		})
+	}
+}
+
+// addBacklog records the newest offset of a partition being consumed from
+// an older offset, i.e. how far its backlog goes.
+func (c *cluster) addBacklog(topic string, partition int32, offset int64) {
+	newest, err := c.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return
+	}
+	if offset == sarama.OffsetOldest {
+		if offset, err = c.client.GetOffset(topic, partition, sarama.OffsetOldest); err != nil {
+			return
+		}
+	}
+	if offset == sarama.OffsetNewest || offset >= newest {
+		return
+	}
+
+	c.backlogLock.Lock()
+	defer c.backlogLock.Unlock()
+	if c.backlog == nil {
+		c.backlog = map[string]map[int32]int64{}
+	}
+	if _, ok := c.backlog[topic]; !ok {
+		c.backlog[topic] = map[int32]int64{}
+	}
+	c.backlog[topic][partition] = newest
+}
+
+// backlogTargets returns, per partition with a backlog, the offset to reach
+// to have caught up with it.
+func (c *cluster) backlogTargets() map[string]map[int32]int64 {
+	c.backlogLock.Lock()
+	defer c.backlogLock.Unlock()
+	return c.backlog
+}
+
+func (c *cluster) highWaterMarks() map[string]map[int32]int64 {
+	if c.consumer == nil {
+		return map[string]map[int32]int64{}
+	}
+	return c.consumer.HighWaterMarks()
+}
+
+func (c *cluster) close() {
+	log.Printf("Trying to close cluster %v", c)
+
+	c.pcLock.Lock()
+	defer c.pcLock.Unlock()
+	c.closing = true
+
+	log.Printf("Trying to close %v partition consumers for cluster %v", len(c.partitionConsumers), c)
+	cc := 0
+	for _, pc := range c.partitionConsumers {
+		if err := pc.Close(); err != nil {
+			log.Printf("Error while trying to close partition consumer for cluster %v. err=%v", c, err)
+		}
+		cc++
+	}
+
+	if cc > 0 {
+		log.Printf("Trying to close consumer for cluster %v", c)
+		if err := c.consumer.Close(); err != nil {
+			log.Printf("Error while trying to close consumer for cluster %v. err=%v", c, err)
+		} else {
+			log.Printf("Successfully closed consumer for cluster %v", c)
+		}
+		log.Printf("Trying to close client for cluster %v", c)
+		if err := c.client.Close(); err != nil {
+			log.Printf("Error while trying to close client for cluster %v. err=%v", c, err)
+		} else {
+			log.Printf("Successfully closed client for cluster %v", c)
+		}
+	}
+	log.Printf("Finished trying to close cluster %v", c)
+}
+
+// String names the cluster in logs by its alias and brokers.
+func (c *cluster) String() string {
+	if len(c.alias) == 0 {
+		return fmt.Sprintf("with brokers %v", c.brokers)
+	}
+	return fmt.Sprintf("%v with brokers %v", c.alias, c.brokers)
+}
+
+func (c *cluster) addConsumer(conf consumerConfig, fsm fsm) {
+	topic, partition := conf.topic, conf.partition
+	client, consumer := c.client, c.consumer
+
+	partitions, err := resolvePartitions(topic, partition, consumer)
+	if err != nil {
+		c.es.add(err.Error())
+		return
+	}
+
+	limiter := newRateLimiter(conf.maxPerSecond) // shared by all partitions of the consumer
+	for _, partition := range partitions {
+		offset, err := resolveOffset(fsm, conf.offset, topic, partition, client)
+		if err != nil {
+			c.es.add(fmt.Sprintf("Could not resolve offset for %v, %v, %v. err=%v", c, topic, partition, err))
+			return
+		}
+
+		partitionConsumer, err := consumer.ConsumePartition(topic, int32(partition), offset)
+		if err != nil {
+			c.es.add(fmt.Sprintf("Failed to consume partition %v of cluster %v err=%v\n", partition, c, err))
+			return
+		}
+
+		c.addPartitionConsumer(topic, int32(partition), newAssignment(partitionConsumer, offset, limiter))
+		c.addBacklog(topic, int32(partition), offset)
+		log.Printf("Consuming topic [%v], partition [%v] from offset [%v] of cluster %v", topic, partition, offset, c)
+	}
+}
+
+// setupClusters connects to every cluster the consumers refer to, retrying
+// unreachable ones until status fails to tell the client about it or ctx is
+// done.
+func setupClusters(ctx context.Context, conf *config, f fsm, status func(event) error) clusters {
+	cs, byName := clusters{}, map[string]*cluster{}
+	for _, consumerConf := range conf.consumers {
+		name := clusterName(consumerConf)
+		if _, ok := byName[name]; !ok {
+			byName[name] = &cluster{alias: consumerConf.cluster, brokers: consumerConf.brokers, policy: consumerConf.policy, clientId: consumerConf.clientId, version: consumerConf.version, view: consumerConf.view}
+			cs = append(cs, byName[name])
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range cs {
+		consumers := []consumerConfig{}
+		for _, consumerConf := range conf.consumers {
+			if byName[clusterName(consumerConf)] == c {
+				consumers = append(consumers, consumerConf)
+			}
+		}
+		wg.Add(1)
+		go func(c *cluster, consumers []consumerConfig) {
+			defer wg.Done()
+			c.setup(ctx, consumers, f, status)
+		}(c, consumers)
+	}
+	wg.Wait()
+
+	return cs
+}
+
+// kafkaSource consumes the Kafka clusters the consumers of config refer to.
+type kafkaSource struct {
+	config   *config
+	fsm      fsm
+	status   func(event) error
+	clusters clusters
+	life     *lifecycle
+}
+
+func (s *kafkaSource) Start(ctx context.Context) (<-chan *SourceMessage, error) {
+	s.clusters = setupClusters(ctx, s.config, s.fsm, s.status)
+	if errors := s.clusters.errors(); len(errors) > 0 {
+		s.clusters.close()
+		return nil, fmt.Errorf("Could not set up partition consumers. err=%v", errors)
+	}
+	s.life = newLifecycle(ctx)
+	return joinMessages(s.clusters, newRateLimiter(s.config.maxPerSecond), s.life), nil
+}
+
+func (s *kafkaSource) kafkaClusters() clusters {
+	return s.clusters
+}
+
+func (s *kafkaSource) Close() {
+	if s.life == nil {
+		return
+	}
+	s.life.stop()
+	s.clusters.close()
+	s.life.wait()
+}
+
+// clusterName identifies the connection a consumer shares with others, as
+// consumers with their own client id or view need a connection of their own.
+func clusterName(conf consumerConfig) string {
+	return conf.cluster + "|" + strings.Join(conf.brokers, ",") + "|" + conf.clientId + "|" + conf.view
+}
+
+func (c *cluster) setup(ctx context.Context, consumers []consumerConfig, f fsm, status func(event) error) {
+	c.status = status
+	for attempt := 1; ; attempt++ {
+		err := c.connect()
+		if err == nil {
+			if attempt > 1 {
+				status(c.statusEvent(fmt.Sprintf("Reconnected to cluster %v.", c), "happy"))
+			}
+			break
+		}
+
+		if c.policy.exhausted(attempt) {
+			text := fmt.Sprintf("Gave up connecting to cluster %v after %v retries. err=%v", c, attempt-1, err)
+			c.es.add(text)
+			status(c.statusEvent(text, "error"))
+			return
+		}
+		wait := c.policy.backoff(attempt)
+		log.WithFields(log.Fields{"cluster": c.alias, "brokers": c.brokers, "attempt": attempt, "wait": wait, "err": err}).Warn("Cluster unreachable; reconnecting.")
+		if serr := status(c.statusEvent(fmt.Sprintf("Cluster %v is unreachable (attempt %v); reconnecting in %v. err=%v", c, attempt, wait, err), "error")); serr != nil {
+			c.es.add(fmt.Sprintf("Gave up connecting to cluster %v. err=%v", c, err))
+			return
+		}
+		if !sleep(ctx, wait) {
+			c.es.add(fmt.Sprintf("Gave up connecting to cluster %v. err=%v", c, ctx.Err()))
+			return
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, consumerConf := range consumers {
+		wg.Add(1)
+		go func(consumerConf consumerConfig, f fsm, c *cluster, wg *sync.WaitGroup) {
+			defer wg.Done()
+			c.addConsumer(consumerConf, f)
+		}(consumerConf, f, c, &wg)
+	}
+	wg.Wait()
+}
+
+// consuming returns the clusters consuming topic, only considering the one
+// with the given alias if set.
+func (cs clusters) consuming(alias string, topic string) (clusters, error) {
+	found, connected := clusters{}, false
+	for _, c := range cs {
+		if len(alias) > 0 && c.alias != alias {
+			continue
+		}
+		connected = connected || c.consumer != nil
+		for _, t := range c.topics() {
+			if t == topic {
+				found = append(found, c)
+			}
+		}
+	}
+	if !connected {
+		return nil, fmt.Errorf("Seeking is only possible when connected to Kafka")
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("Not consuming topic %v", topic)
+	}
+	return found, nil
+}
+
+// connect creates the client and consumer of the cluster.
+func (c *cluster) connect() error {
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Version = sarama.V0_10_0_0
+	if c.version != (sarama.KafkaVersion{}) {
+		saramaConfig.Version = c.version
+	}
+	saramaConfig.Consumer.Return.Errors = true
+	if len(c.clientId) > 0 {
+		saramaConfig.ClientID = c.clientId
+	}
+	c.policy.apply(saramaConfig)
+	client, err := sarama.NewClient(c.brokers, saramaConfig)
+	if err != nil {
+		return fmt.Errorf("Error creating client for cluster %v. err=%v", c, err)
+	}
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("Error creating consumer for cluster %v. err=%v", c, err)
+	}
+
+	c.client = client
+	c.consumer = consumer
+	return nil
+}
+
+// statusEvent tells clients about the connection to the cluster.
+func (c *cluster) statusEvent(text, color string) event {
+	return event{EventType: "clusterStatus", Text: text, Color: color, Cluster: c.alias, Brokers: strings.Join(c.brokers, ",")}
+}
+
+func (cs clusters) errors() []error {
+	errors := []error{}
+	for _, c := range cs {
+		errors = append(errors, c.es.errors...)
+	}
+	return errors
+}
+
+func (cs clusters) close() {
+	for _, c := range cs {
+		c.close()
+	}
+}
+
+func (cs clusters) highWaterMarks() map[string]map[int32]int64 {
+	hwms := map[string]map[int32]int64{}
+	for _, c := range cs {
+		mergeOffsets(hwms, c.highWaterMarks())
+	}
+	return hwms
+}
+
+// highWaterMarksOf returns the high water marks of the cluster with the
+// given alias.
+func (cs clusters) highWaterMarksOf(alias string) map[string]map[int32]int64 {
+	hwms := map[string]map[int32]int64{}
+	for _, c := range cs {
+		if c.alias == alias {
+			mergeOffsets(hwms, c.highWaterMarks())
+		}
+	}
+	return hwms
+}
+
+func (cs clusters) backlogTargets() map[string]map[int32]int64 {
+	targets := map[string]map[int32]int64{}
+	for _, c := range cs {
+		mergeOffsets(targets, c.backlogTargets())
+	}
+	return targets
+}
+
+func mergeOffsets(dst, src map[string]map[int32]int64) {
+	for t, ps := range src {
+		if _, ok := dst[t]; !ok {
+			dst[t] = map[int32]int64{}
+		}
+		for p, o := range ps {
+			dst[t][p] = o
+		}
+	}
+}
+
+func resolvePartitions(topic string, partition int, consumer sarama.Consumer) ([]int32, error) {
+	var partitions []int32
+	if partition == -1 {
+		var err error
+
+		partitions, err = consumer.Partitions(topic)
+		if err != nil {
+			return partitions, fmt.Errorf("Error fetching partitions for topic %v. err=%v", topic, err)
+		}
+	} else {
+		partitions = append(partitions, int32(partition))
+	}
+	return partitions, nil
+}
+
+func resolveOffset(fsm fsm, configOffset string, topic string, partition int32, client sarama.Client) (int64, error) {
+	oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+
+	bookieOffset, ok := fsm.offset(topic, partition)
+	if ok {
+		if bookieOffset >= oldest {
+			return bookieOffset, nil
+		}
+
+		log.WithFields(log.Fields{"oldest": oldest, "bookieOffset": bookieOffset}).Error("Bookie's offset is older than Kafka's oldest.")
+		return oldest, nil
+	}
+
+	if configOffset == "oldest" {
+		return sarama.OffsetOldest, nil
+	}
+
+	if configOffset == "newest" {
+		return sarama.OffsetNewest, nil
+	}
+
+	numericOffset, err := strconv.ParseInt(configOffset, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value for consumer offset")
+	}
+
+	if numericOffset >= -2 {
+		return numericOffset, nil
+	}
+
+	newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+
+	if newest+numericOffset < oldest {
+		return oldest, nil
+	}
+
+	return newest + numericOffset, nil
+}
+
+// joinMessages merges the partition channels of all clusters into one, at
+// most as fast as the optional global limiter allows.
+func joinMessages(cs clusters, limiter *rateLimiter, life *lifecycle) chan *SourceMessage {
+	out := make(chan *SourceMessage)
+	for _, c := range cs {
+		c.out, c.limiter, c.life = out, limiter, life
+		c.pcLock.Lock()
+		for topic, ps := range c.assignments {
+			for p, a := range ps {
+				c.spawnForward(topic, p, a)
+			}
+		}
+		c.pcLock.Unlock()
+	}
+	return out
+}
+
+func (c *cluster) sourceMessage(msg *sarama.ConsumerMessage, brokers string) *SourceMessage {
+	m := fromConsumerMessage(msg)
+	m.cluster, m.brokers, m.view = c.alias, brokers, c.view
+	return m
+}
+
+func (c *cluster) spawnForward(topic string, partition int32, a assignment) {
+	c.life.spawn(func(ctx context.Context) { c.forward(ctx, topic, partition, a) })
+}
+
+// forward sends the messages of a partition to the joined channel. When its
+// channel closes without the partition being reseeked or the cluster being
+// closed, sarama gave up on the partition, so it's restarted. Once the
+// ctx is done messages are dropped until the cluster is closed.
+func (c *cluster) forward(ctx context.Context, topic string, partition int32, a assignment) {
+	brokers := strings.Join(c.brokers, ",")
+	next := a.offset
+	for msg := range a.ch {
+		if ctx.Err() != nil {
+			continue
+		}
+		if c.limiter != nil {
+			c.limiter.wait()
+		}
+		next = msg.Offset + 1
+		select {
+		case c.out <- c.sourceMessage(msg, brokers):
+		case <-ctx.Done():
+		}
+	}
+	c.supervise(ctx, topic, partition, a.pc, next)
+}
+
+// supervise restarts the consumer of a partition whose consumer pc stopped,
+// from offset next, retrying with backoff until it succeeds, the partition
+// is no longer consumed by pc or ctx is done.
+func (c *cluster) supervise(ctx context.Context, topic string, partition int32, pc sarama.PartitionConsumer, next int64) {
+	for attempt := 1; ; attempt++ {
+		c.pcLock.Lock()
+		a, ok := c.assignments[topic][partition]
+		if c.closing || !ok || a.pc != pc || ctx.Err() != nil {
+			c.pcLock.Unlock()
+			return
+		}
+
+		offset, err := c.restartOffset(topic, partition, next)
+		if err == nil {
+			var restarted sarama.PartitionConsumer
+			if restarted, err = c.consumer.ConsumePartition(topic, partition, offset); err == nil {
+				for i, existing := range c.partitionConsumers {
+					if existing == pc {
+						c.partitionConsumers[i] = restarted
+					}
+				}
+				a = newAssignment(restarted, offset, a.limiter)
+				c.assignments[topic][partition] = a
+				c.pcLock.Unlock()
+				go c.drainErrors(topic, partition, restarted)
+
+				log.Printf("Restarted topic [%v], partition [%v] from offset [%v] of cluster %v", topic, partition, offset, c)
+				c.report(c.statusEvent(fmt.Sprintf("Restarted consuming topic %v partition %v of cluster %v from offset %v.", topic, partition, c, offset), "happy"))
+				c.spawnForward(topic, partition, a)
+				return
+			}
+		}
+		c.pcLock.Unlock()
+
+		if c.policy.exhausted(attempt) {
+			log.WithFields(log.Fields{"cluster": c.alias, "topic": topic, "partition": partition, "attempt": attempt, "err": err}).Error("Partition consumer stopped; giving up.")
+			c.report(c.statusEvent(fmt.Sprintf("Gave up restarting topic %v partition %v of cluster %v after %v retries. err=%v", topic, partition, c, attempt-1, err), "error"))
+			return
+		}
+		wait := c.policy.backoff(attempt)
+		log.WithFields(log.Fields{"cluster": c.alias, "topic": topic, "partition": partition, "attempt": attempt, "wait": wait, "err": err}).Warn("Partition consumer stopped; restarting.")
+		c.report(c.statusEvent(fmt.Sprintf("Stopped consuming topic %v partition %v of cluster %v (attempt %v); restarting in %v. err=%v", topic, partition, c, attempt, wait, err), "error"))
+		if !sleep(ctx, wait) {
+			return
+		}
+	}
+}
+
+// restartOffset re-resolves the offset to restart a partition from, as next
+// may have been deleted by retention or the partition may have been
+// truncated since.
+func (c *cluster) restartOffset(topic string, partition int32, next int64) (int64, error) {
+	if next < 0 {
+		return next, nil
+	}
+	oldest, err := c.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, err
+	}
+	newest, err := c.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, err
+	}
+	return clampOffset(next, oldest, newest), nil
+}
+
+func newSyncProducer(brokers []string) (sarama.SyncProducer, error) {
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Version = sarama.V0_10_0_0
+	saramaConfig.Producer.Return.Successes = true
+	saramaConfig.ClientID = defaultClientId()
+	return sarama.NewSyncProducer(brokers, saramaConfig)
+}
+
+func clampOffset(offset, oldest, newest int64) int64 {
+	switch {
+	case offset < oldest:
+		return oldest
+	case offset > newest:
+		return newest
+	}
+	return offset
+}
+
+// report sends e to the client if the cluster has a way to.
+func (c *cluster) report(e event) {
+	if c.status != nil {
+		c.status(e)
+	}
+}
+
+// seek closes the consumers of the partitions of topic (or only of
+// partition, if not negative) and reopens them at the offset returned by
+// resolve, feeding their messages into the cluster's joined channel.
+func (c *cluster) seek(topic string, partition int32, resolve func(client sarama.Client, partition int32) (int64, error)) ([]int32, error) {
+	if c.consumer == nil || c.out == nil {
+		return nil, fmt.Errorf("Seeking is only possible when connected to Kafka")
+	}
+
+	c.pcLock.Lock()
+	defer c.pcLock.Unlock()
+
+	partitions := []int32{}
+	for p := range c.assignments[topic] {
+		if partition < 0 || p == partition {
+			partitions = append(partitions, p)
+		}
+	}
+	if len(partitions) == 0 {
+		return nil, fmt.Errorf("Not consuming topic %v partition %v", topic, partition)
+	}
+
+	for _, p := range partitions {
+		offset, err := resolve(c.client, p)
+		if err != nil {
+			return nil, fmt.Errorf("Could not resolve offset to seek topic %v partition %v. err=%v", topic, p, err)
+		}
+
+		old := c.assignments[topic][p]
+		if err := old.pc.Close(); err != nil {
+			log.WithFields(log.Fields{"err": err, "topic": topic, "partition": p}).Warn("Error closing partition consumer before seeking.")
+		}
+		pc, err := c.consumer.ConsumePartition(topic, p, offset)
+		if err != nil {
+			delete(c.assignments[topic], p)
+			c.removePartitionConsumer(old.pc)
+			return nil, fmt.Errorf("Failed to consume topic %v partition %v from offset %v. err=%v", topic, p, offset, err)
+		}
+
+		for i, existing := range c.partitionConsumers {
+			if existing == old.pc {
+				c.partitionConsumers[i] = pc
+			}
+		}
+		a := newAssignment(pc, offset, old.limiter)
+		c.assignments[topic][p] = a
+		c.spawnForward(topic, p, a)
+		go c.drainErrors(topic, p, pc)
+		log.Printf("Seeked topic [%v], partition [%v] to offset [%v] of cluster %v", topic, p, offset, c)
+	}
+	return partitions, nil
+}
+
+// topics returns the topics being consumed.
+func (c *cluster) topics() []string {
+	c.pcLock.Lock()
+	defer c.pcLock.Unlock()
+	topics := []string{}
+	for t := range c.assignments {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+func (c *cluster) removePartitionConsumer(pc sarama.PartitionConsumer) {
+	for i, existing := range c.partitionConsumers {
+		if existing == pc {
+			c.partitionConsumers = append(c.partitionConsumers[:i], c.partitionConsumers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package flowbro
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+func TestUnreachableClusterReportsStatusUntilGivingUp(t *testing.T) {
+	statuses := []event{}
+	status := func(e event) error {
+		statuses = append(statuses, e)
+		return errors.New("client went away")
+	}
+
+	conf := &config{consumers: []consumerConfig{{cluster: "eu", brokers: []string{"127.0.0.1:1"}, topic: "orders", partition: -1, offset: "newest"}}}
+	cs := setupClusters(context.Background(), conf, fsm{}, status)
+
+	if len(statuses) != 1 || statuses[0].EventType != "clusterStatus" || statuses[0].Cluster != "eu" {
+		t.Errorf("expected a single clusterStatus event for cluster eu but got %+v", statuses)
+	}
+	if len(cs.errors()) == 0 {
+		t.Errorf("expected the cluster to report an error after giving up")
+	}
+}
+
+func TestUnreachableClusterGivesUpAfterRetries(t *testing.T) {
+	statuses := []event{}
+	status := func(e event) error {
+		statuses = append(statuses, e)
+		return nil
+	}
+
+	policy := connectionPolicy{retries: 2, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond, timeout: 100 * time.Millisecond}
+	conf := &config{consumers: []consumerConfig{{cluster: "eu", brokers: []string{"127.0.0.1:1"}, topic: "orders", partition: -1, offset: "newest", policy: policy}}}
+	cs := setupClusters(context.Background(), conf, fsm{}, status)
+
+	if len(statuses) != 3 || statuses[2].Color != "error" || !strings.Contains(statuses[2].Text, "Gave up") {
+		t.Errorf("expected two reconnection statuses and one giving up but got %+v", statuses)
+	}
+	if len(cs.errors()) != 1 {
+		t.Errorf("expected the cluster to report an error after giving up but got %v", cs.errors())
+	}
+}
+
+func TestClampOffset(t *testing.T) {
+	tests := []struct {
+		offset, expected int64
+	}{
+		{offset: 5, expected: 10},
+		{offset: 15, expected: 15},
+		{offset: 25, expected: 20},
+	}
+
+	for _, ts := range tests {
+		if actual := clampOffset(ts.offset, 10, 20); actual != ts.expected {
+			t.Errorf("on '%v': expected %v but got %v", ts.offset, ts.expected, actual)
+		}
+	}
+}
+
+func TestStoppedPartitionConsumerIsRestarted(t *testing.T) {
+	first := mocks.NewConsumer(t, nil)
+	stopped := first.ExpectConsumePartition("orders", 0, sarama.OffsetOldest)
+	pc, _ := first.ConsumePartition("orders", 0, sarama.OffsetOldest)
+
+	statuses := make(chan event, 10)
+	c := &cluster{alias: "eu", status: func(e event) error { statuses <- e; return nil }}
+	c.addPartitionConsumer("orders", 0, newAssignment(pc, sarama.OffsetOldest, nil))
+
+	second := mocks.NewConsumer(t, nil)
+	restarted := second.ExpectConsumePartition("orders", 0, sarama.OffsetOldest)
+	c.consumer = second
+
+	life := newLifecycle(context.Background())
+	defer life.stop()
+	out := joinMessages(clusters{c}, nil, life)
+	stopped.AsyncClose()
+	restarted.YieldMessage(&sarama.ConsumerMessage{Topic: "orders", Offset: 0})
+
+	select {
+	case m := <-out:
+		if m.Topic != "orders" || m.cluster != "eu" {
+			t.Errorf("expected a message of topic orders from cluster eu but got %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the partition consumer to be restarted")
+	}
+	if e := <-statuses; e.EventType != "clusterStatus" || e.Color != "happy" {
+		t.Errorf("expected a clusterStatus event about the restart but got %+v", e)
+	}
+	if c.assignments["orders"][0].pc != restarted || len(c.partitionConsumers) != 1 {
+		t.Errorf("expected the restarted partition consumer to replace the stopped one")
+	}
+}
+
+func TestPartitionConsumerErrorsAreReported(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	mpc := consumer.ExpectConsumePartition("orders", 2, sarama.OffsetNewest)
+	pc, _ := consumer.ConsumePartition("orders", 2, sarama.OffsetNewest)
+
+	statuses := make(chan event, 10)
+	c := &cluster{alias: "eu", status: func(e event) error { statuses <- e; return nil }}
+	c.addPartitionConsumer("orders", 2, newAssignment(pc, sarama.OffsetNewest, nil))
+	mpc.YieldError(sarama.ErrNotLeaderForPartition)
+
+	select {
+	case e := <-statuses:
+		if e.EventType != "consumerError" || e.Topic != "orders" || e.Partition == nil || *e.Partition != 2 || e.Cluster != "eu" {
+			t.Errorf("expected a consumerError event for orders/2 of cluster eu but got %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the error to be reported")
+	}
+	if v := consumerErrors.values[`cluster="eu",topic="orders",partition="2"`]; v != 1 {
+		t.Errorf("expected the error to be counted once but got %v", v)
+	}
+}
@@ -0,0 +1,99 @@
+package flowbro
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// kafkaVersions are the protocol versions flowbro's Kafka client speaks,
+// oldest first.
+var kafkaVersions = []struct {
+	name    string
+	version sarama.KafkaVersion
+}{
+	{"0.8.2.0", sarama.V0_8_2_0},
+	{"0.8.2.1", sarama.V0_8_2_1},
+	{"0.8.2.2", sarama.V0_8_2_2},
+	{"0.9.0.0", sarama.V0_9_0_0},
+	{"0.9.0.1", sarama.V0_9_0_1},
+	{"0.10.0.0", sarama.V0_10_0_0},
+	{"0.10.0.1", sarama.V0_10_0_1},
+	{"0.10.1.0", sarama.V0_10_1_0},
+}
+
+const defaultKafkaVersion = "0.10.0.0"
+
+// zstdVersion is the Kafka version that introduced zstd-compressed batches.
+const zstdVersion = "2.1.0"
+
+// errUnsupportedCompression is what brokers answer fetches of batches in a
+// codec the request's version predates, i.e. zstd.
+const errUnsupportedCompression = sarama.KError(76)
+
+// kafkaVersion is a parsed version like 2.1.0 or 0.10.1.0.
+type kafkaVersion []int
+
+func parseKafkaVersion(s string) (kafkaVersion, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 || len(parts) > 4 {
+		return nil, fmt.Errorf("Invalid Kafka version %v; please use e.g. 0.10.1.0 or 2.1.0", s)
+	}
+	v := kafkaVersion{0, 0, 0, 0}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid Kafka version %v; please use e.g. 0.10.1.0 or 2.1.0", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+func (v kafkaVersion) atLeast(other kafkaVersion) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] > other[i]
+		}
+	}
+	return true
+}
+
+func mustParseKafkaVersion(s string) kafkaVersion {
+	v, err := parseKafkaVersion(s)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// processKafkaVersion returns the newest protocol version the client speaks
+// that brokers of version s understand, and the version features like
+// timestamp lookups can rely on.
+func processKafkaVersion(s string) (sarama.KafkaVersion, string, error) {
+	if len(s) == 0 {
+		s = defaultKafkaVersion
+	}
+	v, err := parseKafkaVersion(s)
+	if err != nil {
+		return sarama.KafkaVersion{}, "", err
+	}
+	for i := len(kafkaVersions) - 1; i >= 0; i-- {
+		if v.atLeast(mustParseKafkaVersion(kafkaVersions[i].name)) {
+			return kafkaVersions[i].version, kafkaVersions[i].name, nil
+		}
+	}
+	return sarama.KafkaVersion{}, "", fmt.Errorf("Kafka version %v is too old; flowbro needs at least %v", s, kafkaVersions[0].name)
+}
+
+// upgradeHint tells how to get to the required version, or else to fall back
+// to the alternative.
+func upgradeHint(required, alternative string) string {
+	newest := kafkaVersions[len(kafkaVersions)-1].name
+	if !mustParseKafkaVersion(newest).atLeast(mustParseKafkaVersion(required)) {
+		return fmt.Sprintf("flowbro's Kafka client supports versions up to %v, so %v", newest, alternative)
+	}
+	return "please configure the cluster's version"
+}
@@ -0,0 +1,35 @@
+package flowbro
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestProcessKafkaVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected sarama.KafkaVersion
+		name     string
+		fails    bool
+	}{
+		{version: "", expected: sarama.V0_10_0_0, name: "0.10.0.0"},
+		{version: "0.9.0.1", expected: sarama.V0_9_0_1, name: "0.9.0.1"},
+		{version: "0.10.0", expected: sarama.V0_10_0_0, name: "0.10.0.0"},
+		{version: "2.1.0", expected: sarama.V0_10_1_0, name: "0.10.1.0"},
+		{version: "0.8.1", fails: true},
+		{version: "latest", fails: true},
+		{version: "1.2.3.4.5", fails: true},
+	}
+
+	for _, ts := range tests {
+		actual, name, err := processKafkaVersion(ts.version)
+		if ts.fails != (err != nil) {
+			t.Errorf("on '%v': expected failure %v but got %v", ts.version, ts.fails, err)
+			continue
+		}
+		if !ts.fails && (actual != ts.expected || name != ts.name) {
+			t.Errorf("on '%v': expected %v but got %v", ts.version, ts.name, name)
+		}
+	}
+}
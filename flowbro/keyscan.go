@@ -0,0 +1,255 @@
+package flowbro
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// offsetGetter is the part of sarama.Client a key scan needs.
+type offsetGetter interface {
+	GetOffset(topic string, partition int32, time int64) (int64, error)
+}
+
+// keyScan looks for the offsets of the messages with one key on a topic,
+// between optional timestamps and offsets, reading at most budget messages
+// so scans of large topics stay bounded.
+type keyScan struct {
+	topic      string
+	key        []byte
+	partition  int32 // all of them if negative
+	from, to   *time.Time
+	fromOffset int64 // inclusive, if not negative
+	toOffset   int64 // inclusive, if not negative
+	budget     int
+	idle       time.Duration // after which a partition with nothing more to read is done
+}
+
+type keyScanMatch struct {
+	Partition int32     `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type keyScanResult struct {
+	Topic    string         `json:"topic"`
+	Key      string         `json:"key"`
+	Matches  []keyScanMatch `json:"matches"`
+	Scanned  int            `json:"scanned"`
+	Complete bool           `json:"complete"` // false if the budget ran out first
+}
+
+const (
+	defaultKeyScanBudget = 100000
+	keyScanIdle          = 5 * time.Second
+)
+
+// timeOffsetsVersion is the Kafka version from which brokers find the first
+// offset at a timestamp, rather than of the log segment around it.
+const timeOffsetsVersion = "0.10.1.0"
+
+// bounds returns the first offset to scan on partition and the first not to,
+// which are equal if there's nothing to scan.
+func (s keyScan) bounds(client offsetGetter, partition int32) (int64, int64, error) {
+	start, err := client.GetOffset(s.topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := client.GetOffset(s.topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, err
+	}
+	if s.from != nil {
+		offset, err := client.GetOffset(s.topic, partition, millis(*s.from))
+		if err != nil {
+			return 0, 0, err
+		}
+		if offset < 0 { // nothing was produced since
+			return end, end, nil
+		}
+		start = offset
+	}
+	if s.to != nil {
+		offset, err := client.GetOffset(s.topic, partition, millis(*s.to)+1)
+		if err != nil {
+			return 0, 0, err
+		}
+		if offset >= 0 && offset < end {
+			end = offset
+		}
+	}
+	if s.fromOffset > start {
+		start = s.fromOffset
+	}
+	if s.toOffset >= 0 && s.toOffset+1 < end {
+		end = s.toOffset + 1
+	}
+	if start > end {
+		start = end
+	}
+	return start, end, nil
+}
+
+// run scans the partitions one after the other until they are done, the
+// budget runs out or ctx is done.
+func (s keyScan) run(ctx context.Context, client offsetGetter, consumer sarama.Consumer) (keyScanResult, error) {
+	result := keyScanResult{Topic: s.topic, Key: string(s.key), Matches: []keyScanMatch{}, Complete: true}
+	partitions, err := resolvePartitions(s.topic, int(s.partition), consumer)
+	if err != nil {
+		return result, err
+	}
+	for _, p := range partitions {
+		start, end, err := s.bounds(client, p)
+		if err != nil {
+			return result, fmt.Errorf("Could not resolve the offsets to scan on topic %v partition %v. err=%v", s.topic, p, err)
+		}
+		if start >= end {
+			continue
+		}
+		if result.Scanned >= s.budget {
+			result.Complete = false
+			break
+		}
+		if err := s.scan(ctx, consumer, p, start, end, &result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (s keyScan) scan(ctx context.Context, consumer sarama.Consumer, partition int32, start, end int64, result *keyScanResult) error {
+	pc, err := consumer.ConsumePartition(s.topic, partition, start)
+	if err != nil {
+		return fmt.Errorf("Failed to consume topic %v partition %v from offset %v. err=%v", s.topic, partition, start, err)
+	}
+	defer pc.Close()
+	idle := time.NewTimer(s.idle)
+	defer func() { idle.Stop() }()
+	for {
+		select {
+		case m, ok := <-pc.Messages():
+			if !ok {
+				return nil
+			}
+			if m.Offset >= end {
+				return nil
+			}
+			result.Scanned++
+			if bytes.Equal(m.Key, s.key) {
+				result.Matches = append(result.Matches, keyScanMatch{Partition: partition, Offset: m.Offset, Timestamp: m.Timestamp})
+			}
+			if m.Offset+1 >= end {
+				return nil
+			}
+			if result.Scanned >= s.budget {
+				result.Complete = false
+				return nil
+			}
+			idle.Stop()
+			idle = time.NewTimer(s.idle)
+		case err := <-pc.Errors():
+			return fmt.Errorf("Failed to scan topic %v partition %v. err=%v", s.topic, partition, err)
+		case <-idle.C: // e.g. the rest of the partition was compacted away
+			return nil
+		case <-ctx.Done():
+			result.Complete = false
+			return ctx.Err()
+		}
+	}
+}
+
+// parseKeyScan reads a key scan from the query of
+// /api/key-offsets?topic=orders&key=order-42&brokers=kafka:9092, with
+// optional partition, from, to, fromOffset, toOffset, max and version.
+func parseKeyScan(r *http.Request) (keyScan, []string, sarama.KafkaVersion, error) {
+	q := r.URL.Query()
+	s := keyScan{topic: q.Get("topic"), key: []byte(q.Get("key")), partition: -1, fromOffset: -1, toOffset: -1, budget: defaultKeyScanBudget, idle: keyScanIdle}
+	if _, ok := q["key"]; !ok || len(s.topic) == 0 || len(q.Get("brokers")) == 0 {
+		return s, nil, sarama.KafkaVersion{}, fmt.Errorf("Please define the topic, key and brokers, e.g. /api/key-offsets?topic=orders&key=order-42&brokers=kafka:9092")
+	}
+	for name, dst := range map[string]*int64{"fromOffset": &s.fromOffset, "toOffset": &s.toOffset} {
+		if v := q.Get(name); len(v) > 0 {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return s, nil, sarama.KafkaVersion{}, fmt.Errorf("Invalid %v %v", name, v)
+			}
+			*dst = n
+		}
+	}
+	if v := q.Get("partition"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return s, nil, sarama.KafkaVersion{}, fmt.Errorf("Invalid partition %v", v)
+		}
+		s.partition = int32(n)
+	}
+	if v := q.Get("max"); len(v) > 0 {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return s, nil, sarama.KafkaVersion{}, fmt.Errorf("Invalid max %v", v)
+		}
+		s.budget = n
+	}
+	var err error
+	if s.from, err = parseTimeParam(r, "from"); err != nil {
+		return s, nil, sarama.KafkaVersion{}, err
+	}
+	if s.to, err = parseTimeParam(r, "to"); err != nil {
+		return s, nil, sarama.KafkaVersion{}, err
+	}
+	version, versionName, err := processKafkaVersion(q.Get("version"))
+	if err != nil {
+		return s, nil, sarama.KafkaVersion{}, err
+	}
+	if (s.from != nil || s.to != nil) && !mustParseKafkaVersion(versionName).atLeast(mustParseKafkaVersion(timeOffsetsVersion)) {
+		return s, nil, sarama.KafkaVersion{}, fmt.Errorf("Scanning between timestamps needs Kafka version %v or newer, but flowbro would speak version %v; please set version, or use fromOffset and toOffset", timeOffsetsVersion, versionName)
+	}
+	return s, strings.Split(q.Get("brokers"), ","), version, nil
+}
+
+// keyOffsetsHandler serves the offsets of the messages with a key, for
+// jumping straight to the history of one entity. As it reads any topic of
+// any brokers, it's not for principals restricted to some boards.
+func keyOffsetsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+		return
+	}
+	if !principalOf(r).mayRead("") {
+		writeError(w, http.StatusForbidden, fmt.Errorf("You may only read the boards you may open"))
+		return
+	}
+	s, brokers, version, err := parseKeyScan(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.Version = version
+	saramaConfig.ClientID = defaultClientId()
+	client, err := sarama.NewClient(brokers, saramaConfig)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("Error creating client for %v. err=%v", strings.Join(brokers, ","), err))
+		return
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("Error creating consumer for %v. err=%v", strings.Join(brokers, ","), err))
+		return
+	}
+	defer consumer.Close()
+
+	result, err := s.run(r.Context(), client, consumer)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,123 @@
+package flowbro
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+// fakeOffsets answers the oldest and newest offsets of partitions, and the
+// first offset at or after a timestamp from a sorted list of them.
+type fakeOffsets struct {
+	oldest, newest map[int32]int64
+	times          map[int32][]int64 // timestamp in millis of every offset from oldest
+}
+
+func (f fakeOffsets) GetOffset(topic string, partition int32, at int64) (int64, error) {
+	switch at {
+	case sarama.OffsetOldest:
+		return f.oldest[partition], nil
+	case sarama.OffsetNewest:
+		return f.newest[partition], nil
+	}
+	for i, t := range f.times[partition] {
+		if t >= at {
+			return f.oldest[partition] + int64(i), nil
+		}
+	}
+	return -1, nil
+}
+
+func TestKeyScanBounds(t *testing.T) {
+	offsets := fakeOffsets{oldest: map[int32]int64{0: 10}, newest: map[int32]int64{0: 15}, times: map[int32][]int64{0: {1000, 2000, 3000, 4000, 5000}}}
+	at := func(ms int64) *time.Time { t := millisTime(ms); return &t }
+
+	tests := []struct {
+		name          string
+		s             keyScan
+		expectedStart int64
+		expectedEnd   int64
+	}{
+		{name: "whole partition", s: keyScan{fromOffset: -1, toOffset: -1}, expectedStart: 10, expectedEnd: 15},
+		{name: "between timestamps", s: keyScan{from: at(2000), to: at(3500), fromOffset: -1, toOffset: -1}, expectedStart: 11, expectedEnd: 13},
+		{name: "after the last message", s: keyScan{from: at(6000), fromOffset: -1, toOffset: -1}, expectedStart: 15, expectedEnd: 15},
+		{name: "until after the last message", s: keyScan{to: at(9000), fromOffset: -1, toOffset: -1}, expectedStart: 10, expectedEnd: 15},
+		{name: "between offsets", s: keyScan{fromOffset: 12, toOffset: 12}, expectedStart: 12, expectedEnd: 13},
+		{name: "offsets before the oldest", s: keyScan{fromOffset: 0, toOffset: 5}, expectedStart: 6, expectedEnd: 6},
+	}
+	for _, ts := range tests {
+		start, end, err := ts.s.bounds(offsets, 0)
+		if err != nil || start != ts.expectedStart || end != ts.expectedEnd {
+			t.Errorf("on '%v': expected %v to %v but got %v to %v (err=%v)", ts.name, ts.expectedStart, ts.expectedEnd, start, end, err)
+		}
+	}
+}
+
+func TestKeyScanFindsTheOffsetsOfAKey(t *testing.T) {
+	tests := []struct {
+		name             string
+		budget           int
+		expectedOffsets  []int64
+		expectedScanned  int
+		expectedComplete bool
+	}{
+		{name: "within budget", budget: 100, expectedOffsets: []int64{2, 4, 2}, expectedScanned: 6, expectedComplete: true},
+		{name: "out of budget", budget: 3, expectedOffsets: []int64{2}, expectedScanned: 3, expectedComplete: false},
+	}
+
+	for _, ts := range tests {
+		consumer := mocks.NewConsumer(t, nil)
+		consumer.SetTopicMetadata(map[string][]int32{"orders": {0, 1, 2}})
+		first := consumer.ExpectConsumePartition("orders", 0, 1)
+		for _, k := range []string{"41", "42", "43", "42"} {
+			first.YieldMessage(&sarama.ConsumerMessage{Key: []byte(k)}) // at offsets 1 to 4
+		}
+		if ts.expectedComplete {
+			second := consumer.ExpectConsumePartition("orders", 2, 1)
+			second.YieldMessage(&sarama.ConsumerMessage{Key: []byte("40")})
+			second.YieldMessage(&sarama.ConsumerMessage{Key: []byte("42")})
+		}
+		offsets := fakeOffsets{oldest: map[int32]int64{0: 1, 1: 0, 2: 1}, newest: map[int32]int64{0: 5, 1: 0, 2: 3}}
+
+		s := keyScan{topic: "orders", key: []byte("42"), partition: -1, fromOffset: -1, toOffset: -1, budget: ts.budget, idle: time.Second}
+		result, err := s.run(context.Background(), offsets, consumer)
+		if err != nil {
+			t.Errorf("on '%v': shouldn't have failed but did with %v", ts.name, err)
+			continue
+		}
+		actual := []int64{}
+		for _, m := range result.Matches {
+			actual = append(actual, m.Offset)
+		}
+		if !reflect.DeepEqual(actual, ts.expectedOffsets) || result.Scanned != ts.expectedScanned || result.Complete != ts.expectedComplete {
+			t.Errorf("on '%v': expected offsets %v after scanning %v (complete %v) but got %+v", ts.name, ts.expectedOffsets, ts.expectedScanned, ts.expectedComplete, result)
+		}
+	}
+}
+
+func TestParseKeyScan(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{query: "topic=orders&key=42&brokers=kafka:9092&partition=3&fromOffset=10&toOffset=20&max=500"},
+		{query: "topic=orders&key=42&brokers=kafka:9092&from=2017-01-01T10:00:00Z&version=0.10.1.0"},
+		{query: "topic=orders&key=42", expected: "Please define the topic, key and brokers"},
+		{query: "topic=orders&key=42&brokers=kafka:9092&max=0", expected: "Invalid max 0"},
+		{query: "topic=orders&key=42&brokers=kafka:9092&toOffset=-1", expected: "Invalid toOffset -1"},
+		{query: "topic=orders&key=42&brokers=kafka:9092&from=yesterday", expected: "Invalid from yesterday"},
+		{query: "topic=orders&key=42&brokers=kafka:9092&to=2017-01-01T10:00:00Z", expected: "needs Kafka version 0.10.1.0"},
+	}
+	for _, ts := range tests {
+		_, _, _, err := parseKeyScan(httptest.NewRequest("GET", "/api/key-offsets?"+ts.query, nil))
+		if len(ts.expected) == 0 && err != nil || len(ts.expected) > 0 && (err == nil || !strings.Contains(err.Error(), ts.expected)) {
+			t.Errorf("on '%v': expected error %q but got %v", ts.query, ts.expected, err)
+		}
+	}
+}
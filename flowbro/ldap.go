@@ -0,0 +1,361 @@
+package flowbro
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ldapJSON struct {
+	URL         string            `json:"url"` // ldap://host:389 or ldaps://host:636
+	UserDN      string            `json:"userDn"`
+	GroupBaseDN string            `json:"groupBaseDn"`
+	GroupMember string            `json:"groupMemberAttribute"`
+	Roles       map[string]string `json:"roles"` // group DN to role
+	DefaultRole string            `json:"defaultRole"`
+}
+
+// ldapAuthenticator authenticates HTTP basic credentials by binding to an
+// LDAP or Active Directory server as the user, e.g. uid=%s,ou=people,... or
+// %s@example.com, and maps the groups listing the user as a member to a
+// role. Successful logins are remembered for a minute, so that every request
+// doesn't bind again.
+type ldapAuthenticator struct {
+	config      ldapJSON
+	host        string
+	address     string
+	tls         bool
+	roles       map[string]role
+	defaultRole role
+
+	l     sync.Mutex
+	cache map[[32]byte]cachedLogin
+}
+
+type cachedLogin struct {
+	p       principal
+	expires time.Time
+}
+
+const (
+	ldapCacheTTL = time.Minute
+	ldapTimeout  = 5 * time.Second
+)
+
+func readLDAP(path string) (*ldapAuthenticator, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read LDAP config. err=%v", err)
+	}
+	var c ldapJSON
+	if err := json.Unmarshal(byt, &c); err != nil {
+		return nil, fmt.Errorf("Invalid LDAP config %v. err=%v", path, err)
+	}
+	return newLDAP(c)
+}
+
+func newLDAP(c ldapJSON) (*ldapAuthenticator, error) {
+	if len(c.URL) == 0 || !strings.Contains(c.UserDN, "%s") {
+		return nil, fmt.Errorf("Please define the url and the userDn, with %%s for the user name, for LDAP")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+		return nil, fmt.Errorf("Invalid LDAP url %v; use ldap://host:389 or ldaps://host:636", c.URL)
+	}
+	a := &ldapAuthenticator{config: c, host: u.Hostname(), address: u.Host, tls: u.Scheme == "ldaps", roles: map[string]role{}, cache: map[[32]byte]cachedLogin{}}
+	if len(u.Port()) == 0 && a.tls {
+		a.address = net.JoinHostPort(u.Host, "636")
+	} else if len(u.Port()) == 0 {
+		a.address = net.JoinHostPort(u.Host, "389")
+	}
+	if len(a.config.GroupMember) == 0 {
+		a.config.GroupMember = "member"
+	}
+	for dn, name := range c.Roles {
+		r, err := parseRole(name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid LDAP role for %v. err=%v", dn, err)
+		}
+		a.roles[strings.ToLower(dn)] = r
+	}
+	if len(c.DefaultRole) > 0 {
+		if a.defaultRole, err = parseRole(c.DefaultRole); err != nil {
+			return nil, fmt.Errorf("Invalid LDAP default role. err=%v", err)
+		}
+	}
+	return a, nil
+}
+
+func (a *ldapAuthenticator) challenge() string {
+	return `Basic realm="flowbro"`
+}
+
+func (a *ldapAuthenticator) authenticate(r *http.Request) (principal, bool, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok || len(user) == 0 || len(password) == 0 { // an empty password would bind anonymously
+		return principal{}, false, nil
+	}
+	key := sha256.Sum256([]byte(user + "\x00" + password))
+	now := time.Now()
+	a.l.Lock()
+	c, cached := a.cache[key]
+	a.l.Unlock()
+	if cached && now.Before(c.expires) {
+		return c.p, true, nil
+	}
+
+	p, ok, err := a.login(user, password)
+	if err != nil || !ok {
+		return principal{}, false, err
+	}
+	a.l.Lock()
+	for k, c := range a.cache {
+		if now.After(c.expires) {
+			delete(a.cache, k)
+		}
+	}
+	a.cache[key] = cachedLogin{p: p, expires: now.Add(ldapCacheTTL)}
+	a.l.Unlock()
+	return p, true, nil
+}
+
+// login binds as user, then looks up their groups.
+func (a *ldapAuthenticator) login(user, password string) (principal, bool, error) {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: ldapTimeout}
+	if a.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", a.address, &tls.Config{ServerName: a.host})
+	} else {
+		conn, err = dialer.Dial("tcp", a.address)
+	}
+	if err != nil {
+		return principal{}, false, fmt.Errorf("Could not connect to LDAP server %v. err=%v", a.address, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ldapTimeout))
+	rd := bufio.NewReader(conn)
+
+	dn := fmt.Sprintf(a.config.UserDN, escapeDN(user))
+	bind := berTLV(0x60, berInt(0x02, 3), berString(0x04, dn), berString(0x80, password))
+	if _, err := conn.Write(ldapMessage(1, bind)); err != nil {
+		return principal{}, false, fmt.Errorf("Could not bind to LDAP server. err=%v", err)
+	}
+	op, err := readLDAPMessage(rd)
+	if err != nil {
+		return principal{}, false, err
+	}
+	code, err := ldapResultCode(op, 0x61)
+	if err != nil {
+		return principal{}, false, err
+	}
+	if code != 0 { // e.g. 49, invalid credentials
+		return principal{}, false, nil
+	}
+
+	p := principal{Name: user, Role: a.defaultRole}
+	if len(a.config.GroupBaseDN) > 0 {
+		groups, err := a.groups(conn, rd, dn)
+		if err != nil {
+			return principal{}, false, err
+		}
+		for _, g := range groups {
+			if r, ok := a.roles[strings.ToLower(g)]; ok && r > p.Role {
+				p.Role = r
+			}
+		}
+	}
+	conn.Write(ldapMessage(3, []byte{0x42, 0x00})) // unbind
+	return p, true, nil
+}
+
+// groups returns the DNs of the groups under the group base DN listing dn as
+// a member.
+func (a *ldapAuthenticator) groups(w io.Writer, rd *bufio.Reader, dn string) ([]string, error) {
+	search := berTLV(0x63,
+		berString(0x04, a.config.GroupBaseDN),
+		berInt(0x0a, 2), // whole subtree
+		berInt(0x0a, 0), // never deref aliases
+		berInt(0x02, 0),
+		berInt(0x02, 0),
+		berTLV(0x01, []byte{0x00}),
+		berTLV(0xa3, berString(0x04, a.config.GroupMember), berString(0x04, dn)),
+		berTLV(0x30, berString(0x04, "1.1")), // no attributes
+	)
+	if _, err := w.Write(ldapMessage(2, search)); err != nil {
+		return nil, fmt.Errorf("Could not search LDAP groups. err=%v", err)
+	}
+	groups := []string{}
+	for {
+		op, err := readLDAPMessage(rd)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case 0x64: // search result entry
+			fields, err := berElements(op.content)
+			if err != nil || len(fields) == 0 {
+				return nil, fmt.Errorf("Malformed LDAP search result")
+			}
+			groups = append(groups, string(fields[0].content))
+		case 0x65: // search result done
+			code, err := ldapResultCode(op, 0x65)
+			if err != nil {
+				return nil, err
+			}
+			if code != 0 {
+				return nil, fmt.Errorf("LDAP group search failed with result code %v", code)
+			}
+			return groups, nil
+		}
+	}
+}
+
+// escapeDN escapes the characters of an attribute value that are special in
+// distinguished names.
+func escapeDN(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if strings.ContainsRune(`,+"\<>;=`, r) || (i == 0 && (r == '#' || r == ' ')) || (i == len(s)-1 && r == ' ') {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type berElement struct {
+	tag     byte
+	content []byte
+}
+
+func berLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	bs := []byte{}
+	for ; n > 0; n >>= 8 {
+		bs = append([]byte{byte(n)}, bs...)
+	}
+	return append([]byte{0x80 | byte(len(bs))}, bs...)
+}
+
+func berTLV(tag byte, content ...[]byte) []byte {
+	c := []byte{}
+	for _, part := range content {
+		c = append(c, part...)
+	}
+	return append(append([]byte{tag}, berLength(len(c))...), c...)
+}
+
+func berString(tag byte, s string) []byte {
+	return berTLV(tag, []byte(s))
+}
+
+func berInt(tag byte, v int64) []byte {
+	bs := []byte{byte(v)}
+	for v >>= 8; v > 0; v >>= 8 {
+		bs = append([]byte{byte(v)}, bs...)
+	}
+	if bs[0]&0x80 != 0 {
+		bs = append([]byte{0}, bs...)
+	}
+	return berTLV(tag, bs)
+}
+
+func berIntValue(bs []byte) int64 {
+	v := int64(0)
+	for _, b := range bs {
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+func ldapMessage(id int64, op []byte) []byte {
+	return berTLV(0x30, berInt(0x02, id), op)
+}
+
+// readBER reads one element; LDAP messages are small, so lengths beyond 16MB
+// are refused.
+func readBER(rd *bufio.Reader) (berElement, error) {
+	tag, err := rd.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	l, err := rd.ReadByte()
+	if err != nil {
+		return berElement{}, err
+	}
+	n := int(l)
+	if l&0x80 != 0 {
+		if l&0x7f > 3 {
+			return berElement{}, fmt.Errorf("LDAP message too long")
+		}
+		n = 0
+		for i := 0; i < int(l&0x7f); i++ {
+			b, err := rd.ReadByte()
+			if err != nil {
+				return berElement{}, err
+			}
+			n = n<<8 | int(b)
+		}
+	}
+	content := make([]byte, n)
+	if _, err := io.ReadFull(rd, content); err != nil {
+		return berElement{}, err
+	}
+	return berElement{tag: tag, content: content}, nil
+}
+
+// berElements splits constructed content into its elements.
+func berElements(content []byte) ([]berElement, error) {
+	es := []berElement{}
+	rd := bufio.NewReader(bytes.NewReader(content))
+	for {
+		e, err := readBER(rd)
+		if err == io.EOF {
+			return es, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+	}
+}
+
+// readLDAPMessage returns the protocol operation of the next message.
+func readLDAPMessage(rd *bufio.Reader) (berElement, error) {
+	msg, err := readBER(rd)
+	if err != nil {
+		return berElement{}, fmt.Errorf("Could not read from LDAP server. err=%v", err)
+	}
+	fields, err := berElements(msg.content)
+	if err != nil || msg.tag != 0x30 || len(fields) < 2 {
+		return berElement{}, fmt.Errorf("Malformed LDAP message")
+	}
+	return fields[1], nil
+}
+
+// ldapResultCode returns the result code of an operation expected to be of
+// type tag.
+func ldapResultCode(op berElement, tag byte) (int64, error) {
+	if op.tag != tag {
+		return 0, fmt.Errorf("Unexpected LDAP operation %#x", op.tag)
+	}
+	fields, err := berElements(op.content)
+	if err != nil || len(fields) == 0 {
+		return 0, fmt.Errorf("Malformed LDAP result")
+	}
+	return berIntValue(fields[0].content), nil
+}
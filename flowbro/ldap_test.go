@@ -0,0 +1,170 @@
+package flowbro
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeLDAP answers binds of the users it knows and searches for the groups
+// listing them as members.
+type fakeLDAP struct {
+	net.Listener
+	passwords map[string]string   // by DN
+	groups    map[string][]string // member DNs by group DN
+	binds     int32
+}
+
+func newFakeLDAP(t *testing.T) *fakeLDAP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeLDAP{
+		Listener:  l,
+		passwords: map[string]string{"uid=alice,ou=people,dc=example": "secret", `uid=bob\,jr,ou=people,dc=example`: "pw"},
+		groups: map[string][]string{
+			"cn=SRE,ou=groups,dc=example": {"uid=alice,ou=people,dc=example"},
+			"cn=dev,ou=groups,dc=example": {"uid=alice,ou=people,dc=example", `uid=bob\,jr,ou=people,dc=example`},
+		},
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeLDAP) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		msg, err := readBER(rd)
+		if err != nil {
+			return
+		}
+		fields, _ := berElements(msg.content)
+		id, op := berIntValue(fields[0].content), fields[1]
+		args, _ := berElements(op.content)
+		switch op.tag {
+		case 0x60:
+			atomic.AddInt32(&s.binds, 1)
+			code := int64(49)
+			if p, ok := s.passwords[string(args[1].content)]; ok && p == string(args[2].content) {
+				code = 0
+			}
+			conn.Write(ldapMessage(id, berTLV(0x61, berInt(0x0a, code), berString(0x04, ""), berString(0x04, ""))))
+		case 0x63:
+			assertion, _ := berElements(args[6].content)
+			member := string(assertion[1].content)
+			for g, members := range s.groups {
+				for _, m := range members {
+					if m == member {
+						conn.Write(ldapMessage(id, berTLV(0x64, berString(0x04, g), berTLV(0x30))))
+					}
+				}
+			}
+			conn.Write(ldapMessage(id, berTLV(0x65, berInt(0x0a, 0), berString(0x04, ""), berString(0x04, ""))))
+		default:
+			return
+		}
+	}
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	server := newFakeLDAP(t)
+	defer server.Close()
+	a, err := newLDAP(ldapJSON{
+		URL:         "ldap://" + server.Addr().String(),
+		UserDN:      "uid=%s,ou=people,dc=example",
+		GroupBaseDN: "ou=groups,dc=example",
+		Roles:       map[string]string{"cn=sre,ou=groups,dc=example": "operator", "cn=dev,ou=groups,dc=example": "viewer"},
+	})
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+
+	tests := []struct {
+		user, password string
+		ok             bool
+		expectedRole   role
+	}{
+		{"alice", "secret", true, operator},
+		{"bob,jr", "pw", true, viewer},
+		{"alice", "wrong", false, 0},
+		{"alice", "", false, 0},
+		{"mallory", "secret", false, 0},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.SetBasicAuth(tt.user, tt.password)
+		p, ok, err := a.authenticate(r)
+		if err != nil || ok != tt.ok || p.Role != tt.expectedRole {
+			t.Errorf("on '%v': expected ok=%v as %v but got ok=%v as %v, err=%v", tt.user, tt.ok, tt.expectedRole, ok, p.Role, err)
+		}
+	}
+
+	binds := atomic.LoadInt32(&server.binds)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("alice", "secret")
+	if p, ok, _ := a.authenticate(r); !ok || p.Name != "alice" || atomic.LoadInt32(&server.binds) != binds {
+		t.Errorf("expected alice's login to be remembered without binding again")
+	}
+
+	f := &flowbro{auth: a}
+	w := httptest.NewRecorder()
+	f.authorize(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != `Basic realm="flowbro"` {
+		t.Errorf("expected browsers to be asked for credentials but got %v with %v", w.Code, w.Header())
+	}
+}
+
+func TestLDAPUnreachable(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+	a, _ := newLDAP(ldapJSON{URL: "ldap://" + addr, UserDN: "uid=%s"})
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("alice", "secret")
+	if _, ok, err := a.authenticate(r); ok || err == nil {
+		t.Errorf("expected an unreachable server to fail authentication with an error")
+	}
+}
+
+func TestInvalidLDAP(t *testing.T) {
+	configs := []ldapJSON{
+		{UserDN: "uid=%s"},
+		{URL: "ldap://localhost", UserDN: "uid=alice"},
+		{URL: "http://localhost", UserDN: "uid=%s"},
+		{URL: "ldap://localhost", UserDN: "uid=%s", Roles: map[string]string{"cn=sre": "admin"}},
+	}
+	for _, c := range configs {
+		if _, err := newLDAP(c); err == nil {
+			t.Errorf("on '%+v': expected newLDAP to fail", c)
+		}
+	}
+}
+
+func TestEscapeDN(t *testing.T) {
+	tests := map[string]string{
+		"alice":       "alice",
+		"bob,jr":      `bob\,jr`,
+		"a=b+c":       `a\=b\+c`,
+		"#x":          `\#x`,
+		" padded ":    `\ padded\ `,
+		`quote"back\`: `quote\"back\\`,
+	}
+	for in, expected := range tests {
+		if actual := escapeDN(in); actual != expected {
+			t.Errorf("on '%v': expected %v but got %v", in, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package flowbro
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// lifecycle is the goroutines serving one websocket connection: stopping it
+// cancels the context they were spawned with, and wait returns once they
+// all have returned, so none of them outlive the connection.
+type lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// newLifecycle returns a lifecycle that is also stopped when parent is done,
+// e.g. when the server shuts down.
+func newLifecycle(parent context.Context) *lifecycle {
+	ctx, cancel := context.WithCancel(parent)
+	return &lifecycle{ctx: ctx, cancel: cancel}
+}
+
+// spawn runs f with the lifecycle's context in a goroutine that wait waits
+// for.
+func (l *lifecycle) spawn(f func(ctx context.Context)) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		f(l.ctx)
+	}()
+}
+
+func (l *lifecycle) context() context.Context {
+	return l.ctx
+}
+
+func (l *lifecycle) stop() {
+	l.cancel()
+}
+
+func (l *lifecycle) wait() {
+	l.wg.Wait()
+}
+
+// sleep waits for d, returning false if ctx was done before.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,131 @@
+package flowbro
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+// waitFor fails the test if the lifecycle's goroutines don't all return
+// within a second.
+func waitFor(t *testing.T, life *lifecycle, what string) {
+	done := make(chan struct{})
+	go func() {
+		life.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("expected %v to return once the lifecycle was stopped", what)
+	}
+}
+
+func TestLifecycleWaitsForSpawnedGoroutines(t *testing.T) {
+	life, returned := newLifecycle(context.Background()), make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		i := i
+		life.spawn(func(ctx context.Context) {
+			<-ctx.Done()
+			returned <- i
+		})
+	}
+
+	life.stop()
+	life.stop()
+	waitFor(t, life, "the spawned goroutines")
+	if len(returned) != 3 || life.context().Err() == nil {
+		t.Errorf("expected all 3 goroutines to have returned but got %v", len(returned))
+	}
+}
+
+func TestMockMessagesStopWhenNobodyReads(t *testing.T) {
+	tests := []struct {
+		name     string
+		fixtures []mockFixture
+	}{
+		{name: "sending", fixtures: []mockFixture{{Topic: "orders"}, {Topic: "orders"}}},
+		{name: "sleeping", fixtures: []mockFixture{{Topic: "orders", DelayMs: 60000}}},
+	}
+
+	for _, ts := range tests {
+		life := newLifecycle(context.Background())
+		c := make(chan *SourceMessage)
+		life.spawn(func(ctx context.Context) { pushMockMessages(ctx, c, ts.fixtures) })
+		life.stop()
+		waitFor(t, life, "pushing mock messages while "+ts.name)
+	}
+}
+
+func TestTutorialStopsWhenNobodyReads(t *testing.T) {
+	src := &tutorialSource{}
+	if _, err := src.Start(context.Background()); err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	closed := make(chan struct{})
+	go func() {
+		src.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Errorf("expected closing the tutorial to stop it")
+	}
+}
+
+func TestForwardingStopsOnceClusterIsClosed(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	mpc := consumer.ExpectConsumePartition("orders", 0, sarama.OffsetOldest)
+	pc, _ := consumer.ConsumePartition("orders", 0, sarama.OffsetOldest)
+
+	c := &cluster{alias: "eu"}
+	c.addPartitionConsumer("orders", 0, newAssignment(pc, sarama.OffsetOldest, nil))
+	life := newLifecycle(context.Background())
+	joinMessages(clusters{c}, nil, life)
+	mpc.YieldMessage(&sarama.ConsumerMessage{Topic: "orders", Offset: 0})
+	mpc.YieldMessage(&sarama.ConsumerMessage{Topic: "orders", Offset: 1})
+
+	life.stop()
+	c.pcLock.Lock()
+	c.closing = true
+	c.pcLock.Unlock()
+	mpc.AsyncClose()
+	waitFor(t, life, "forwarding nobody reads")
+}
+
+func TestHeartbeatsStopWhenQuit(t *testing.T) {
+	life, timeout := newLifecycle(context.Background()), make(chan struct{})
+	life.spawn(func(ctx context.Context) { processHeartbeats(ctx, validWR{}, timeout, "uuid", time.Minute) })
+	life.stop()
+	waitFor(t, life, "processing heartbeats")
+}
+
+func TestLifecycleStopsWithItsParent(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	life := newLifecycle(parent)
+	life.spawn(func(ctx context.Context) { <-ctx.Done() })
+	cancel()
+	waitFor(t, life, "a goroutine of a lifecycle whose parent was cancelled")
+}
+
+func TestSetupClustersGivesUpWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	conf := &config{consumers: []consumerConfig{{cluster: "eu", brokers: []string{"127.0.0.1:1"}, topic: "orders", partition: -1, offset: "newest"}}}
+	cs := setupClusters(ctx, conf, fsm{}, func(e event) error { return nil })
+	if len(cs.errors()) != 1 {
+		t.Errorf("expected the cluster to give up connecting once cancelled but got %v", cs.errors())
+	}
+}
+
+func TestSenderDoesntSendOnceCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := (sender{}).Send(ctx, nil, "[]"); err != context.Canceled {
+		t.Errorf("expected sending to fail with %v but got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,242 @@
+package flowbro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+type loadgenJSON struct {
+	Brokers         string            `json:"brokers"`
+	RatePerSecond   float64           `json:"ratePerSecond"`
+	Keys            int               `json:"keys"`
+	Flows           int               `json:"flows"`
+	DurationSeconds float64           `json:"durationSeconds"`
+	Steps           []loadgenStepJSON `json:"steps"`
+}
+
+type loadgenStepJSON struct {
+	Topic   string `json:"topic"`
+	DelayMs int64  `json:"delayMs"`
+	Value   string `json:"value"`
+}
+
+const (
+	defaultLoadgenRate = 1
+	defaultLoadgenKeys = 100
+)
+
+// loadgen produces synthetic flows: every flow picks one of keys keys and
+// produces a message to the topic of each step in turn, after the step's
+// delay, so that flowbro shows them as one correlated flow.
+type loadgen struct {
+	brokers  []string
+	period   time.Duration
+	keys     int
+	flows    int
+	duration time.Duration
+	steps    []loadgenStep
+	random   *rand.Rand
+}
+
+type loadgenStep struct {
+	topic string
+	delay time.Duration
+	value *template.Template
+}
+
+// loadgenMessage is what step values render, e.g.
+// {"orderId": "{{.Id}}", "customer": "{{.Key}}"}.
+type loadgenMessage struct {
+	Id        string
+	Key       string
+	Flow      int
+	Step      int
+	Topic     string
+	Timestamp time.Time
+
+	due time.Time
+}
+
+var loadgenProduced = newCounter("flowbro_loadgen_messages_total", "Messages produced by the load generator, by topic and outcome.", "topic", "outcome")
+
+// RunLoadgen runs `flowbro loadgen`, producing the flows of the config file
+// given with -config until they're all produced, the duration passes or ctx
+// is done.
+func RunLoadgen(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("loadgen", flag.ContinueOnError)
+	path := fs.String("config", "", "load generator config file defining the brokers and the steps of every flow")
+	brokers := fs.String("brokers", "", "comma-separated brokers to produce to, overriding the config's")
+	rate := fs.Float64("rate", 0, "flows started per second, overriding the config's ratePerSecond")
+	keys := fs.Int("keys", 0, "distinct keys the flows pick from, overriding the config's keys")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(*path) == 0 {
+		return fmt.Errorf("Please define the flows to generate with -config")
+	}
+	byt, err := ioutil.ReadFile(*path)
+	if err != nil {
+		return fmt.Errorf("Could not read load generator config. err=%v", err)
+	}
+	var c loadgenJSON
+	if err := json.Unmarshal(byt, &c); err != nil {
+		return fmt.Errorf("Invalid load generator config %v. err=%v", *path, err)
+	}
+	if len(*brokers) > 0 {
+		c.Brokers = *brokers
+	}
+	if *rate > 0 {
+		c.RatePerSecond = *rate
+	}
+	if *keys > 0 {
+		c.Keys = *keys
+	}
+	g, err := newLoadgen(c)
+	if err != nil {
+		return err
+	}
+	producer, err := newSyncProducer(g.brokers)
+	if err != nil {
+		return fmt.Errorf("Could not connect to %v. err=%v", g.brokers, err)
+	}
+	defer producer.Close()
+	log.WithFields(log.Fields{"brokers": g.brokers, "flowsPerSecond": float64(time.Second) / float64(g.period), "keys": g.keys}).Info("Generating load.")
+	flows := g.run(ctx, producer, time.Now())
+	log.WithFields(log.Fields{"flows": flows}).Info("Stopped generating load.")
+	return nil
+}
+
+func newLoadgen(c loadgenJSON) (*loadgen, error) {
+	if len(c.Brokers) == 0 {
+		c.Brokers = "localhost:9092"
+	}
+	if len(c.Steps) == 0 {
+		return nil, fmt.Errorf("Please define the steps of the flows to generate")
+	}
+	if c.RatePerSecond < 0 || c.Keys < 0 || c.Flows < 0 || c.DurationSeconds < 0 {
+		return nil, fmt.Errorf("Invalid load generator config; ratePerSecond, keys, flows and durationSeconds can't be negative")
+	}
+	g := &loadgen{
+		brokers:  strings.Split(c.Brokers, ","),
+		period:   time.Duration(float64(time.Second) / defaultLoadgenRate),
+		keys:     defaultLoadgenKeys,
+		flows:    c.Flows,
+		duration: time.Duration(c.DurationSeconds * float64(time.Second)),
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	if c.RatePerSecond > 0 {
+		g.period = time.Duration(float64(time.Second) / c.RatePerSecond)
+	}
+	if c.Keys > 0 {
+		g.keys = c.Keys
+	}
+	for i, s := range c.Steps {
+		if len(s.Topic) == 0 {
+			return nil, fmt.Errorf("Please define the topic of load generator step %v", i)
+		}
+		if s.DelayMs < 0 {
+			return nil, fmt.Errorf("Invalid delayMs %v for load generator step %v; it can't be negative", s.DelayMs, i)
+		}
+		step := loadgenStep{topic: s.Topic, delay: time.Duration(s.DelayMs) * time.Millisecond}
+		if len(s.Value) > 0 {
+			t, err := template.New(s.Topic).Funcs(transformFuncs).Option("missingkey=zero").Parse(s.Value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid value template for load generator step %v. err=%v", i, err)
+			}
+			step.value = t
+		}
+		g.steps = append(g.steps, step)
+	}
+	return g, nil
+}
+
+// flow returns the messages of the n-th flow, due after their steps'
+// delays, which add up.
+func (g *loadgen) flow(n int, start time.Time) []loadgenMessage {
+	key := fmt.Sprintf("key-%v", g.random.Intn(g.keys))
+	id := fmt.Sprintf("%v-%v", start.UnixNano(), n)
+	messages := []loadgenMessage{}
+	due := start
+	for i, s := range g.steps {
+		due = due.Add(s.delay)
+		messages = append(messages, loadgenMessage{Id: id, Key: key, Flow: n, Step: i, Topic: s.topic, due: due})
+	}
+	return messages
+}
+
+// render renders the value of m's step, or else a JSON object with m's id,
+// key, step and timestamp.
+func (g *loadgen) render(m loadgenMessage) ([]byte, error) {
+	t := g.steps[m.Step].value
+	if t == nil {
+		return json.Marshal(map[string]interface{}{"id": m.Id, "key": m.Key, "step": m.Step, "topic": m.Topic, "timestamp": m.Timestamp})
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, m); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// run starts a flow every period and produces every message once due,
+// returning how many flows were started. Failures are logged and counted,
+// but don't stop the load.
+func (g *loadgen) run(ctx context.Context, producer sarama.SyncProducer, start time.Time) int {
+	started, nextFlow := 0, start
+	pending := []loadgenMessage{}
+	for {
+		more := (g.flows == 0 || started < g.flows) && (g.duration == 0 || nextFlow.Sub(start) < g.duration)
+		if !more && len(pending) == 0 {
+			return started
+		}
+		next := nextFlow
+		if len(pending) > 0 && (!more || pending[0].due.Before(next)) {
+			next = pending[0].due
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return started
+		}
+
+		now := time.Now()
+		if more && !now.Before(nextFlow) {
+			pending = append(pending, g.flow(started, nextFlow)...)
+			sort.SliceStable(pending, func(i, j int) bool { return pending[i].due.Before(pending[j].due) })
+			started++
+			nextFlow = nextFlow.Add(g.period)
+		}
+		for len(pending) > 0 && !pending[0].due.After(now) {
+			g.produce(producer, pending[0], now)
+			pending = pending[1:]
+		}
+	}
+}
+
+func (g *loadgen) produce(producer sarama.SyncProducer, m loadgenMessage, now time.Time) {
+	m.Timestamp = now
+	value, err := g.render(m)
+	if err == nil {
+		_, _, err = producer.SendMessage(&sarama.ProducerMessage{Topic: m.Topic, Key: sarama.StringEncoder(m.Key), Value: sarama.ByteEncoder(value)})
+	}
+	if err != nil {
+		loadgenProduced.inc(m.Topic, "failure")
+		log.WithFields(log.Fields{"topic": m.Topic, "key": m.Key, "err": err}).Error("Failed to produce generated message.")
+		return
+	}
+	loadgenProduced.inc(m.Topic, "success")
+}
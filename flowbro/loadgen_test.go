@@ -0,0 +1,135 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+func TestNewLoadgen(t *testing.T) {
+	steps := []loadgenStepJSON{{Topic: "orders"}, {Topic: "payments", DelayMs: 100}}
+	ts := []struct {
+		c               loadgenJSON
+		expectedBrokers []string
+		expectedPeriod  time.Duration
+		expectedKeys    int
+		fails           bool
+	}{
+		{loadgenJSON{Steps: steps}, []string{"localhost:9092"}, time.Second, defaultLoadgenKeys, false},
+		{loadgenJSON{Brokers: "a:9092,b:9092", RatePerSecond: 20, Keys: 5, Steps: steps}, []string{"a:9092", "b:9092"}, 50 * time.Millisecond, 5, false},
+		{loadgenJSON{}, nil, 0, 0, true},
+		{loadgenJSON{Steps: []loadgenStepJSON{{DelayMs: 1}}}, nil, 0, 0, true},
+		{loadgenJSON{Steps: []loadgenStepJSON{{Topic: "orders", DelayMs: -1}}}, nil, 0, 0, true},
+		{loadgenJSON{Steps: []loadgenStepJSON{{Topic: "orders", Value: "{{.Key"}}}, nil, 0, 0, true},
+		{loadgenJSON{RatePerSecond: -1, Steps: steps}, nil, 0, 0, true},
+	}
+
+	for _, tc := range ts {
+		g, err := newLoadgen(tc.c)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("on '%+v': expected the config to be rejected", tc.c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on '%+v': shouldn't have failed but did with %v", tc.c, err)
+			continue
+		}
+		if fmt.Sprint(g.brokers) != fmt.Sprint(tc.expectedBrokers) || g.period != tc.expectedPeriod || g.keys != tc.expectedKeys {
+			t.Errorf("on '%+v': expected brokers %v, period %v and %v keys but got %v, %v and %v", tc.c, tc.expectedBrokers, tc.expectedPeriod, tc.expectedKeys, g.brokers, g.period, g.keys)
+		}
+	}
+}
+
+func TestLoadgenFlow(t *testing.T) {
+	g, err := newLoadgen(loadgenJSON{Keys: 3, Steps: []loadgenStepJSON{
+		{Topic: "orders", Value: `{"orderId":"{{.Id}}","customer":"{{.Key}}"}`},
+		{Topic: "payments", DelayMs: 100},
+		{Topic: "shipments", DelayMs: 50},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1500000000, 0)
+	messages := g.flow(7, start)
+
+	expectedTopics := []string{"orders", "payments", "shipments"}
+	expectedDelays := []time.Duration{0, 100 * time.Millisecond, 150 * time.Millisecond}
+	for i, m := range messages {
+		if m.Topic != expectedTopics[i] || m.due.Sub(start) != expectedDelays[i] || m.Id != messages[0].Id || m.Key != messages[0].Key {
+			t.Errorf("on step %v: expected %v after %v correlated with the first step but got %+v", i, expectedTopics[i], expectedDelays[i], m)
+		}
+	}
+	if k := messages[0].Key; k != "key-0" && k != "key-1" && k != "key-2" {
+		t.Errorf("expected one of 3 keys but got %v", k)
+	}
+
+	value, err := g.render(messages[0])
+	if expected := fmt.Sprintf(`{"orderId":"%v","customer":"%v"}`, messages[0].Id, messages[0].Key); err != nil || string(value) != expected {
+		t.Errorf("expected the template to render %v but got %s (err=%v)", expected, value, err)
+	}
+	value, err = g.render(messages[1])
+	var v map[string]interface{}
+	if err != nil || json.Unmarshal(value, &v) != nil || v["id"] != messages[1].Id || v["topic"] != "payments" {
+		t.Errorf("expected a default JSON value but got %s (err=%v)", value, err)
+	}
+}
+
+func TestLoadgenRun(t *testing.T) {
+	g, err := newLoadgen(loadgenJSON{RatePerSecond: 1000, Keys: 2, Flows: 3, Steps: []loadgenStepJSON{{Topic: "orders"}, {Topic: "payments", DelayMs: 5}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var l sync.Mutex
+	flows := map[string][]string{}
+	producer := mocks.NewSyncProducer(t, nil)
+	for i := 0; i < 6; i++ {
+		producer.ExpectSendMessageWithCheckerFunctionAndSucceed(func(val []byte) error {
+			var v map[string]interface{}
+			if err := json.Unmarshal(val, &v); err != nil {
+				return err
+			}
+			l.Lock()
+			defer l.Unlock()
+			flows[v["id"].(string)] = append(flows[v["id"].(string)], v["topic"].(string))
+			return nil
+		})
+	}
+
+	if started := g.run(context.Background(), producer, time.Now()); started != 3 {
+		t.Errorf("expected 3 flows to be started but got %v", started)
+	}
+	producer.Close()
+	for id, topics := range flows {
+		if strings.Join(topics, ",") != "orders,payments" {
+			t.Errorf("on '%v': expected the flow to go through orders then payments but got %v", id, topics)
+		}
+	}
+	if len(flows) != 3 {
+		t.Errorf("expected 3 flows but got %v", flows)
+	}
+}
+
+func TestLoadgenStopsWithContext(t *testing.T) {
+	g, err := newLoadgen(loadgenJSON{RatePerSecond: 0.001, Steps: []loadgenStepJSON{{Topic: "orders"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(sarama.ErrLeaderNotAvailable)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if started := g.run(ctx, producer, time.Now()); started != 1 {
+		t.Errorf("expected only the first flow to be started before stopping but got %v", started)
+	}
+	producer.Close()
+}
@@ -0,0 +1,262 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type lokiJSON struct {
+	URL          string            `json:"url"`
+	Tenant       string            `json:"tenant"`
+	Username     string            `json:"username"`
+	Password     string            `json:"password"`
+	BearerToken  string            `json:"bearerToken"`
+	Labels       map[string]string `json:"labels"`
+	Tags         map[string]string `json:"tags"`
+	EventTypes   string            `json:"eventTypes"`
+	BatchSize    int               `json:"batchSize"`
+	FlushSeconds float64           `json:"flushSeconds"`
+	Retries      *int              `json:"retries"`
+}
+
+// lokiSink pushes the events of the flow, as sent to the UI, to Grafana
+// Loki as JSON log lines, labelled from their fields, so they can be
+// queried with LogQL next to the services' own logs.
+type lokiSink struct {
+	batchingSink
+	url         string
+	tenant      string
+	username    string
+	password    string
+	bearerToken string
+	labels      [][2]string // label name and event field, sorted by name
+	tags        map[string]string
+	eventTypes  *regexp.Regexp
+	client      *http.Client
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+const (
+	defaultLokiPath = "/loki/api/v1/push"
+	defaultLokiJob  = "flowbro" // Loki refuses streams without labels
+)
+
+// lokiFields are the event fields labels may be mapped from.
+var lokiFields = map[string]func(e event) string{
+	"cluster":   func(e event) string { return e.Cluster },
+	"topic":     func(e event) string { return e.Topic },
+	"sourceId":  func(e event) string { return e.SourceId },
+	"targetId":  func(e event) string { return e.TargetId },
+	"eventType": func(e event) string { return e.EventType },
+	"view":      func(e event) string { return e.View },
+}
+
+var defaultLokiLabels = map[string]string{"cluster": "cluster", "topic": "topic", "component": "sourceId"}
+
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+var (
+	lokiPushes  = newCounter("flowbro_loki_pushes_total", "Batches of events pushed to Loki, by outcome.", "outcome")
+	lokiDropped = newCounter("flowbro_loki_dropped_events_total", "Events dropped because the Loki queue was full.")
+)
+
+func processLoki(c *lokiJSON) (*lokiSink, error) {
+	if c == nil {
+		return nil, nil
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("Invalid Loki url %v; please use http(s)://<host:port>", c.URL)
+	}
+	if len(u.Path) == 0 || u.Path == "/" {
+		u.Path = defaultLokiPath
+	}
+	if c.BatchSize < 0 || c.FlushSeconds < 0 || c.Retries != nil && *c.Retries < 0 {
+		return nil, fmt.Errorf("Invalid Loki sink; batchSize, flushSeconds and retries can't be negative")
+	}
+	l := &lokiSink{
+		url:         u.String(),
+		tenant:      c.Tenant,
+		username:    c.Username,
+		password:    c.Password,
+		bearerToken: c.BearerToken,
+		tags:        map[string]string{"job": defaultLokiJob},
+		client:      &http.Client{Timeout: 5 * time.Second},
+	}
+	l.batchingSink = newBatchingSink("Loki", "events", l, func(outcome string) {
+		if outcome == "dropped" {
+			lokiDropped.inc()
+		} else {
+			lokiPushes.inc(outcome)
+		}
+	})
+	labels := c.Labels
+	if labels == nil {
+		labels = defaultLokiLabels
+	}
+	for name, field := range labels {
+		if !lokiLabelName.MatchString(name) {
+			return nil, fmt.Errorf("Invalid Loki label name %v", name)
+		}
+		if _, ok := lokiFields[field]; !ok {
+			return nil, fmt.Errorf("Unknown event field %v for Loki label %v; use cluster, topic, sourceId, targetId, eventType or view", field, name)
+		}
+		if _, ok := c.Tags[name]; ok {
+			return nil, fmt.Errorf("Loki label %v is both mapped and set in tags", name)
+		}
+		l.labels = append(l.labels, [2]string{name, field})
+	}
+	sort.Slice(l.labels, func(i, j int) bool { return l.labels[i][0] < l.labels[j][0] })
+	if c.Tags != nil {
+		l.tags = c.Tags
+	}
+	for name := range l.tags {
+		if !lokiLabelName.MatchString(name) {
+			return nil, fmt.Errorf("Invalid Loki label name %v", name)
+		}
+	}
+	if len(c.EventTypes) > 0 {
+		if l.eventTypes, err = regexp.Compile("^(?:" + c.EventTypes + ")$"); err != nil {
+			return nil, fmt.Errorf("Invalid eventTypes regex for Loki. err=%v", err)
+		}
+	}
+	if c.BatchSize > 0 {
+		l.batchSize = c.BatchSize
+	}
+	if c.FlushSeconds > 0 {
+		l.flush = time.Duration(c.FlushSeconds * float64(time.Second))
+	}
+	if c.Retries != nil {
+		l.policy.retries = *c.Retries
+	}
+	return l, nil
+}
+
+// startLoki runs l in a goroutine of life, until it stops; until then
+// events aren't queued.
+func startLoki(l *lokiSink, life *lifecycle) {
+	if l != nil {
+		l.start(life)
+	}
+}
+
+// forwardToLoki queues the events of the matching types, dropping them if
+// the queue is full, and returns the failures of earlier batches.
+func forwardToLoki(l *lokiSink, events []event) []event {
+	if l == nil || !l.started() {
+		return nil
+	}
+	for _, e := range events {
+		if l.eventTypes == nil || l.eventTypes.MatchString(e.EventType) {
+			l.queue(e)
+		}
+	}
+	return l.reported()
+}
+
+// push groups batch into streams by their labels, ordering each stream's
+// lines by the events' timestamps, or else now.
+func (l *lokiSink) push(batch []event, now time.Time) (lokiPush, error) {
+	type entry struct {
+		at   int64
+		line string
+	}
+	streams, entries := map[string]map[string]string{}, map[string][]entry{}
+	keys := []string{}
+	for _, e := range batch {
+		labels := map[string]string{}
+		for k, v := range l.tags {
+			labels[k] = v
+		}
+		key := []string{}
+		for _, lf := range l.labels {
+			if v := lokiFields[lf[1]](e); len(v) > 0 {
+				labels[lf[0]] = v
+				key = append(key, lf[0]+"="+strconv.Quote(v))
+			}
+		}
+		k := strings.Join(key, ",")
+		if _, ok := streams[k]; !ok {
+			streams[k], keys = labels, append(keys, k)
+		}
+		at := now
+		if e.Timestamp != nil {
+			at = *e.Timestamp
+		}
+		line, err := json.Marshal(e)
+		if err != nil {
+			return lokiPush{}, err
+		}
+		entries[k] = append(entries[k], entry{at: at.UnixNano(), line: string(line)})
+	}
+	sort.Strings(keys)
+	p := lokiPush{Streams: []lokiStream{}}
+	for _, k := range keys {
+		es := entries[k]
+		sort.SliceStable(es, func(i, j int) bool { return es[i].at < es[j].at })
+		s := lokiStream{Stream: streams[k], Values: [][2]string{}}
+		for _, e := range es {
+			s.Values = append(s.Values, [2]string{strconv.FormatInt(e.at, 10), e.line})
+		}
+		p.Streams = append(p.Streams, s)
+	}
+	return p, nil
+}
+
+// encode renders batch as a push.
+func (l *lokiSink) encode(batch []interface{}, now time.Time) ([]byte, error) {
+	events := []event{}
+	for _, e := range batch {
+		events = append(events, e.(event))
+	}
+	p, err := l.push(events, now)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(p)
+}
+
+// post returns whether a failed push may succeed when retried: connection
+// errors, 429s and 5xxs may, other rejections, like out of order lines,
+// won't.
+func (l *lokiSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", l.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(l.tenant) > 0 {
+		req.Header.Set("X-Scope-OrgID", l.tenant)
+	}
+	switch {
+	case len(l.bearerToken) > 0:
+		req.Header.Set("Authorization", "Bearer "+l.bearerToken)
+	case len(l.username) > 0:
+		req.SetBasicAuth(l.username, l.password)
+	}
+	r, err := l.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	r.Body.Close()
+	if r.StatusCode >= 300 {
+		return r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500, fmt.Errorf("%v answered with status %v", l.url, r.StatusCode)
+	}
+	return false, nil
+}
@@ -0,0 +1,125 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessLoki(t *testing.T) {
+	ts := []struct {
+		c              *lokiJSON
+		expectedURL    string
+		expectedLabels [][2]string
+		fails          bool
+	}{
+		{&lokiJSON{URL: "http://loki:3100"}, "http://loki:3100/loki/api/v1/push", [][2]string{{"cluster", "cluster"}, {"component", "sourceId"}, {"topic", "topic"}}, false},
+		{&lokiJSON{URL: "https://logs.example.com/api/prom/push", Labels: map[string]string{"type": "eventType"}, Tags: map[string]string{"env": "prod"}}, "https://logs.example.com/api/prom/push", [][2]string{{"type", "eventType"}}, false},
+		{&lokiJSON{URL: "loki:3100"}, "", nil, true},
+		{&lokiJSON{URL: "http://loki:3100", Labels: map[string]string{"type": "value"}}, "", nil, true},
+		{&lokiJSON{URL: "http://loki:3100", Labels: map[string]string{"my-type": "eventType"}}, "", nil, true},
+		{&lokiJSON{URL: "http://loki:3100", Labels: map[string]string{"env": "cluster"}, Tags: map[string]string{"env": "prod"}}, "", nil, true},
+		{&lokiJSON{URL: "http://loki:3100", EventTypes: "("}, "", nil, true},
+	}
+
+	for _, tc := range ts {
+		l, err := processLoki(tc.c)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("on '%+v': expected the config to be rejected", tc.c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on '%+v': shouldn't have failed but did with %v", tc.c, err)
+			continue
+		}
+		if l.url != tc.expectedURL || !reflect.DeepEqual(l.labels, tc.expectedLabels) {
+			t.Errorf("on '%+v': expected url %v and labels %v but got %v and %v", tc.c, tc.expectedURL, tc.expectedLabels, l.url, l.labels)
+		}
+	}
+}
+
+func TestLokiPush(t *testing.T) {
+	l, _ := processLoki(&lokiJSON{URL: "http://loki:3100"})
+	now := time.Unix(1500000010, 0)
+	earlier := time.Unix(1500000000, 0)
+	p, err := l.push([]event{
+		{EventType: "message", SourceId: "shop", Cluster: "eu"},
+		{EventType: "message", SourceId: "billing", Cluster: "eu"},
+		{EventType: "message", SourceId: "shop", Cluster: "eu", Timestamp: &earlier},
+		{EventType: "log", Text: "hi"},
+	}, now)
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+
+	expected := []struct {
+		stream map[string]string
+		times  []string
+	}{
+		{map[string]string{"job": "flowbro"}, []string{"1500000010000000000"}},
+		{map[string]string{"job": "flowbro", "cluster": "eu", "component": "billing"}, []string{"1500000010000000000"}},
+		{map[string]string{"job": "flowbro", "cluster": "eu", "component": "shop"}, []string{"1500000000000000000", "1500000010000000000"}},
+	}
+	if len(p.Streams) != len(expected) {
+		t.Fatalf("expected %v streams but got %+v", len(expected), p.Streams)
+	}
+	for i, s := range p.Streams {
+		times := []string{}
+		for _, v := range s.Values {
+			times = append(times, v[0])
+			var e event
+			if err := json.Unmarshal([]byte(v[1]), &e); err != nil {
+				t.Errorf("on stream %v: expected JSON lines but got %v", i, v[1])
+			}
+		}
+		if !reflect.DeepEqual(s.Stream, expected[i].stream) || !reflect.DeepEqual(times, expected[i].times) {
+			t.Errorf("on stream %v: expected %v at %v but got %v at %v", i, expected[i].stream, expected[i].times, s.Stream, times)
+		}
+	}
+}
+
+func TestLokiPushesToTenant(t *testing.T) {
+	received := make(chan lokiPush, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Scope-OrgID") != "ops" || r.Header.Get("Authorization") != "Bearer secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var p lokiPush
+		json.NewDecoder(r.Body).Decode(&p)
+		received <- p
+		rw.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	l, err := processLoki(&lokiJSON{URL: server.URL, Tenant: "ops", BearerToken: "secret", EventTypes: "alert"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	life := newLifecycle(context.Background())
+	startLoki(l, life)
+	forwardToLoki(l, []event{{EventType: "message"}, {EventType: "alert", SourceId: "billing", Text: "late"}})
+	life.stop()
+	life.wait()
+
+	select {
+	case p := <-received:
+		if len(p.Streams) != 1 || p.Streams[0].Stream["component"] != "billing" || !strings.Contains(p.Streams[0].Values[0][1], `"text":"late"`) {
+			t.Errorf("expected only the alert to be pushed but got %+v", p)
+		}
+	default:
+		t.Errorf("expected the queued alert to be pushed when stopping")
+	}
+
+	l.tenant = "other"
+	if retry, err := l.post([]byte("{}")); retry || err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expected the rejected push not to be retried but got %v, %v", retry, err)
+	}
+}
@@ -0,0 +1,195 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// luaScript runs a Lua script in flowbro's embedded interpreter, kept for
+// the whole session. The script defines a transform function, which gets
+// every message as a table shaped like the JSON WebAssembly modules read,
+// and returns a table shaped like the JSON they answer with, or nil to pass
+// the message on untouched:
+//
+//	function transform(m)
+//	  if m.value.amount > 1000 then
+//	    return {tags = {big = "yes"}}
+//	  end
+//	end
+//
+// Only the base, table, string and math libraries are loaded, so scripts
+// can't reach files, processes or the network, and every call is cut short
+// after scriptTimeout.
+type luaScript struct {
+	name string
+	path string
+
+	state     *lua.LState
+	transform *lua.LFunction
+}
+
+// luaMaxDepth bounds how deep the tables a script returns may nest, so that
+// one referring to itself can't hang flowbro.
+const luaMaxDepth = 64
+
+func (s *luaScript) start() error {
+	l := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{{lua.BaseLibName, lua.OpenBase}, {lua.TabLibName, lua.OpenTable}, {lua.StringLibName, lua.OpenString}, {lua.MathLibName, lua.OpenMath}} {
+		l.Push(l.NewFunction(lib.open))
+		l.Push(lua.LString(lib.name))
+		l.Call(1, 0)
+	}
+	for _, unsafe := range []string{"dofile", "loadfile", "load", "loadstring", "require", "module"} {
+		l.SetGlobal(unsafe, lua.LNil)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	l.SetContext(ctx)
+	defer l.RemoveContext()
+	if err := l.DoFile(s.path); err != nil {
+		l.Close()
+		return fmt.Errorf("Could not load script %v. err=%v", s.name, err)
+	}
+	transform, ok := l.GetGlobal("transform").(*lua.LFunction)
+	if !ok {
+		l.Close()
+		return fmt.Errorf("Script %v doesn't define a transform function", s.name)
+	}
+	s.state, s.transform = l, transform
+	return nil
+}
+
+func (s *luaScript) stop() {
+	if s.state == nil {
+		return
+	}
+	s.state.Close()
+	s.state = nil
+}
+
+// run calls the script's transform with m. A script that timed out is
+// reloaded on the next message.
+func (s *luaScript) run(m scriptMessage) (scriptVerdict, error) {
+	var v scriptVerdict
+	if s.state == nil {
+		if err := s.start(); err != nil {
+			return v, err
+		}
+	}
+
+	var arg interface{}
+	byt, err := json.Marshal(m)
+	if err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal(byt, &arg); err != nil {
+		return v, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	s.state.SetContext(ctx)
+	err = s.state.CallByParam(lua.P{Fn: s.transform, NRet: 1, Protect: true}, toLua(s.state, arg))
+	s.state.RemoveContext()
+	if err != nil {
+		if ctx.Err() != nil {
+			s.stop()
+			return v, fmt.Errorf("Script %v didn't answer within %v", s.name, scriptTimeout)
+		}
+		return v, fmt.Errorf("Script %v failed. err=%v", s.name, err)
+	}
+	ret := s.state.Get(-1)
+	s.state.Pop(1)
+	if ret == lua.LNil {
+		return v, nil
+	}
+	if _, ok := ret.(*lua.LTable); !ok {
+		return v, fmt.Errorf("Script %v returned a %v instead of a table", s.name, ret.Type())
+	}
+	verdict, err := fromLua(ret, 0)
+	if err != nil {
+		return v, fmt.Errorf("Script %v returned an invalid verdict. err=%v", s.name, err)
+	}
+	if byt, err = json.Marshal(verdict); err != nil {
+		return v, fmt.Errorf("Script %v returned an invalid verdict. err=%v", s.name, err)
+	}
+	if err := json.Unmarshal(byt, &v); err != nil {
+		return v, fmt.Errorf("Script %v returned an invalid verdict. err=%v", s.name, err)
+	}
+	return v, nil
+}
+
+// toLua turns a decoded JSON value into a Lua value; arrays become
+// sequences.
+func toLua(l *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		t := l.CreateTable(0, len(v))
+		for k, e := range v {
+			t.RawSetString(k, toLua(l, e))
+		}
+		return t
+	case []interface{}:
+		t := l.CreateTable(len(v), 0)
+		for i, e := range v {
+			t.RawSetInt(i+1, toLua(l, e))
+		}
+		return t
+	case string:
+		return lua.LString(v)
+	case float64:
+		return lua.LNumber(v)
+	case bool:
+		return lua.LBool(v)
+	}
+	return lua.LNil
+}
+
+// fromLua turns a Lua value into one that encodes as JSON: sequences become
+// arrays and other tables objects.
+func fromLua(v lua.LValue, depth int) (interface{}, error) {
+	switch v := v.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LNumber:
+		return float64(v), nil
+	case lua.LString:
+		return string(v), nil
+	case *lua.LTable:
+		if depth >= luaMaxDepth {
+			return nil, fmt.Errorf("tables nest deeper than %v levels", luaMaxDepth)
+		}
+		keys := 0
+		v.ForEach(func(lua.LValue, lua.LValue) { keys++ })
+		if n := v.Len(); n > 0 && n == keys {
+			a := make([]interface{}, n)
+			for i := range a {
+				e, err := fromLua(v.RawGetInt(i+1), depth+1)
+				if err != nil {
+					return nil, err
+				}
+				a[i] = e
+			}
+			return a, nil
+		}
+		o := map[string]interface{}{}
+		var err error
+		v.ForEach(func(k, e lua.LValue) {
+			if err != nil {
+				return
+			}
+			o[k.String()], err = fromLua(e, depth+1)
+		})
+		return o, err
+	}
+	return nil, fmt.Errorf("can't return a %v", v.Type())
+}
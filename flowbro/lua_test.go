@@ -0,0 +1,48 @@
+package flowbro
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLuaScripts(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		script   string
+		expected scriptVerdict
+		fails    string
+	}{
+		{name: "untouched", script: `function transform(m) end`},
+		{name: "rewritten", script: `function transform(m) m.value.items[2] = "c"; return {value = m.value, tags = {topic = m.topic}} end`, expected: scriptVerdict{Value: map[string]interface{}{"items": []interface{}{"a", "c"}}, Tags: map[string]string{"topic": "orders"}}},
+		{name: "no transform", script: `x = 1`, fails: "doesn't define a transform function"},
+		{name: "no files", script: `function transform(m) return {key = io.open("/etc/passwd"):read("*a")} end`, fails: "failed"},
+		{name: "no dofile", script: `function transform(m) dofile("/etc/passwd") end`, fails: "failed"},
+		{name: "not a table", script: `function transform(m) return "drop" end`, fails: "instead of a table"},
+		{name: "nested forever", script: `function transform(m) local t = {}; t.t = t; return {value = t} end`, fails: "nest deeper"},
+		{name: "stuck", script: `function transform(m) while true do end end`, fails: "didn't answer within"},
+	}
+
+	for _, ts := range tests {
+		path := filepath.Join(dir, "script.lua")
+		ioutil.WriteFile(path, []byte(ts.script), 0644)
+		s := &luaScript{name: ts.name, path: path}
+		v, err := s.run(scriptMessage{Topic: "orders", Value: map[string]interface{}{"items": []interface{}{"a", "b"}}})
+		s.stop()
+		if len(ts.fails) > 0 {
+			if err == nil || !strings.Contains(err.Error(), ts.fails) {
+				t.Errorf("on '%v': expected error %q but got %v", ts.name, ts.fails, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(v, ts.expected) {
+			t.Errorf("on '%v': expected %+v but got %+v with %v", ts.name, ts.expected, v, err)
+		}
+	}
+}
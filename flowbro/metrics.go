@@ -0,0 +1,275 @@
+package flowbro
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// counter is a monotonically increasing metric, split by label values and
+// exposed on /metrics in Prometheus' text format.
+type counter struct {
+	name   string
+	help   string
+	labels []string
+
+	l      sync.Mutex
+	values map[string]float64
+}
+
+// gauge is a metric that goes up and down, split by label values and
+// exposed on /metrics in Prometheus' text format.
+type gauge struct {
+	counter
+}
+
+// histogram counts observations in cumulative buckets, split by label
+// values and exposed on /metrics in Prometheus' text format.
+type histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	l      sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type metric interface {
+	write(w io.Writer)
+	collect() []promSeries
+}
+
+// promSeries is the current value of one series of a metric, labelled with
+// its name as __name__, as pushed by remote write and StatsD. kind is
+// counter, gauge or histogram.
+type promSeries struct {
+	labels [][2]string
+	value  float64
+	kind   string
+}
+
+var (
+	metricsLock sync.Mutex
+	allMetrics  = []metric{}
+)
+
+var (
+	consumerErrors   = newCounter("flowbro_consumer_errors_total", "Errors reported by Kafka partition consumers.", "cluster", "topic", "partition")
+	consumedMessages = newCounter("flowbro_consumed_messages_total", "Messages consumed by every client, per cluster and topic.", "cluster", "topic")
+	connectedClients = newGauge("flowbro_connected_clients", "Clients streaming a flow, over websockets or otherwise.", "kind")
+	latencies        = newHistogram("flowbro_message_latency_seconds", "Time between a message's Kafka timestamp and flowbro receiving it.", []float64{.01, .05, .1, .5, 1, 5, 10, 60, 300, 3600}, "topic")
+)
+
+func register(m metric) {
+	metricsLock.Lock()
+	allMetrics = append(allMetrics, m)
+	metricsLock.Unlock()
+}
+
+func newCounter(name, help string, labels ...string) *counter {
+	c := &counter{name: name, help: help, labels: labels, values: map[string]float64{}}
+	register(c)
+	return c
+}
+
+func newGauge(name, help string, labels ...string) *gauge {
+	g := &gauge{counter{name: name, help: help, labels: labels, values: map[string]float64{}}}
+	register(g)
+	return g
+}
+
+func newHistogram(name, help string, buckets []float64, labels ...string) *histogram {
+	h := &histogram{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	register(h)
+	return h
+}
+
+func labelKey(labels []string, values []string) string {
+	pairs := []string{}
+	for i, l := range labels {
+		if i < len(values) {
+			pairs = append(pairs, fmt.Sprintf("%v=%q", l, values[i]))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (c *counter) add(v float64, labelValues ...string) {
+	key := labelKey(c.labels, labelValues)
+	c.l.Lock()
+	c.values[key] += v
+	c.l.Unlock()
+}
+
+func (c *counter) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+func (c *counter) write(w io.Writer) {
+	c.writeAs(w, "counter")
+}
+
+func (c *counter) writeAs(w io.Writer, kind string) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", c.name, c.help, c.name, kind)
+	keys := []string{}
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if len(k) == 0 {
+			fmt.Fprintf(w, "%v %v\n", c.name, c.values[k])
+		} else {
+			fmt.Fprintf(w, "%v{%v} %v\n", c.name, k, c.values[k])
+		}
+	}
+}
+
+func (c *counter) collect() []promSeries {
+	return c.collectAs("counter")
+}
+
+func (c *counter) collectAs(kind string) []promSeries {
+	c.l.Lock()
+	defer c.l.Unlock()
+	series := []promSeries{}
+	for k, v := range c.values {
+		series = append(series, promSeries{labels: append([][2]string{{"__name__", c.name}}, parseLabelKey(k)...), value: v, kind: kind})
+	}
+	return series
+}
+
+func (g *gauge) set(v float64, labelValues ...string) {
+	key := labelKey(g.labels, labelValues)
+	g.l.Lock()
+	g.values[key] = v
+	g.l.Unlock()
+}
+
+func (g *gauge) write(w io.Writer) {
+	g.writeAs(w, "gauge")
+}
+
+func (g *gauge) collect() []promSeries {
+	return g.collectAs("gauge")
+}
+
+// parseLabelKey reverses labelKey.
+func parseLabelKey(key string) [][2]string {
+	pairs := [][2]string{}
+	for len(key) > 0 {
+		i := strings.Index(key, "=")
+		if i < 0 {
+			break
+		}
+		quoted, err := strconv.QuotedPrefix(key[i+1:])
+		if err != nil {
+			break
+		}
+		value, _ := strconv.Unquote(quoted)
+		pairs = append(pairs, [2]string{key[:i], value})
+		key = strings.TrimPrefix(key[i+1+len(quoted):], ",")
+	}
+	return pairs
+}
+
+// collectMetrics returns every series of every metric.
+func collectMetrics() []promSeries {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	series := []promSeries{}
+	for _, m := range allMetrics {
+		series = append(series, m.collect()...)
+	}
+	return series
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	for _, c := range allMetrics {
+		c.write(w)
+	}
+}
+
+func (h *histogram) observe(v float64, labelValues ...string) {
+	key := labelKey(h.labels, labelValues)
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.l.Lock()
+	defer h.l.Unlock()
+
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v histogram\n", h.name, h.help, h.name)
+	keys := []string{}
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s, prefix := h.series[k], ""
+		if len(k) > 0 {
+			prefix = k + ","
+		}
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%v_bucket{%vle=\"%v\"} %v\n", h.name, prefix, b, s.counts[i])
+		}
+		fmt.Fprintf(w, "%v_bucket{%vle=\"+Inf\"} %v\n", h.name, prefix, s.count)
+		if len(k) == 0 {
+			fmt.Fprintf(w, "%v_sum %v\n%v_count %v\n", h.name, s.sum, h.name, s.count)
+		} else {
+			fmt.Fprintf(w, "%v_sum{%v} %v\n%v_count{%v} %v\n", h.name, k, s.sum, h.name, k, s.count)
+		}
+	}
+}
+
+func (h *histogram) collect() []promSeries {
+	h.l.Lock()
+	defer h.l.Unlock()
+	series := []promSeries{}
+	for k, s := range h.series {
+		labels := parseLabelKey(k)
+		named := func(suffix string, extra ...[2]string) [][2]string {
+			return append(append([][2]string{{"__name__", h.name + suffix}}, labels...), extra...)
+		}
+		for i, b := range h.buckets {
+			series = append(series, promSeries{labels: named("_bucket", [2]string{"le", fmt.Sprint(b)}), value: float64(s.counts[i]), kind: "histogram"})
+		}
+		series = append(series,
+			promSeries{labels: named("_bucket", [2]string{"le", "+Inf"}), value: float64(s.count), kind: "histogram"},
+			promSeries{labels: named("_sum"), value: s.sum, kind: "histogram"},
+			promSeries{labels: named("_count"), value: float64(s.count), kind: "histogram"},
+		)
+	}
+	return series
+}
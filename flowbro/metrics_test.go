@@ -0,0 +1,105 @@
+package flowbro
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCounterWrite(t *testing.T) {
+	c := &counter{name: "requests_total", help: "Requests.", labels: []string{"code"}, values: map[string]float64{}}
+	c.inc("500")
+	c.add(2, "200")
+	c.inc("500")
+
+	var buf bytes.Buffer
+	c.write(&buf)
+
+	expected := "# HELP requests_total Requests.\n# TYPE requests_total counter\n" +
+		"requests_total{code=\"200\"} 2\nrequests_total{code=\"500\"} 2\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q but got %q", expected, buf.String())
+	}
+}
+
+func TestGaugeWrite(t *testing.T) {
+	g := &gauge{counter{name: "clients", help: "Clients.", labels: []string{"kind"}, values: map[string]float64{}}}
+	g.add(2, "websocket")
+	g.add(-1, "websocket")
+	g.set(5, "stream")
+
+	var buf bytes.Buffer
+	g.write(&buf)
+
+	expected := "# HELP clients Clients.\n# TYPE clients gauge\n" +
+		"clients{kind=\"stream\"} 5\nclients{kind=\"websocket\"} 1\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q but got %q", expected, buf.String())
+	}
+}
+
+func TestHistogramWrite(t *testing.T) {
+	h := &histogram{name: "latency_seconds", help: "Latency.", labels: []string{"topic"}, buckets: []float64{1, 5}, series: map[string]*histogramSeries{}}
+	h.observe(0.5, "orders")
+	h.observe(3, "orders")
+	h.observe(10, "orders")
+
+	var buf bytes.Buffer
+	h.write(&buf)
+
+	expected := "# HELP latency_seconds Latency.\n# TYPE latency_seconds histogram\n" +
+		"latency_seconds_bucket{topic=\"orders\",le=\"1\"} 1\n" +
+		"latency_seconds_bucket{topic=\"orders\",le=\"5\"} 2\n" +
+		"latency_seconds_bucket{topic=\"orders\",le=\"+Inf\"} 3\n" +
+		"latency_seconds_sum{topic=\"orders\"} 13.5\n" +
+		"latency_seconds_count{topic=\"orders\"} 3\n"
+	if buf.String() != expected {
+		t.Errorf("expected %q but got %q", expected, buf.String())
+	}
+}
+
+func TestMetricsHandlerExposesConsumerErrors(t *testing.T) {
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(w.Body.String(), "# TYPE flowbro_consumer_errors_total counter") {
+		t.Errorf("expected consumer errors to be exposed but got %v", w.Body.String())
+	}
+}
+
+func TestParseLabelKey(t *testing.T) {
+	ts := [][]string{
+		{},
+		{"topic", "orders"},
+		{"topic", `a "quoted", value=1`, "partition", "3"},
+	}
+
+	for _, tc := range ts {
+		labels, values := []string{}, []string{}
+		expected := [][2]string{}
+		for i := 0; i < len(tc); i += 2 {
+			labels, values = append(labels, tc[i]), append(values, tc[i+1])
+			expected = append(expected, [2]string{tc[i], tc[i+1]})
+		}
+		if actual := parseLabelKey(labelKey(labels, values)); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("on '%v': expected %v but got %v", tc, expected, actual)
+		}
+	}
+}
+
+func TestHistogramCollect(t *testing.T) {
+	h := &histogram{name: "latency_seconds", labels: []string{"topic"}, buckets: []float64{1}, series: map[string]*histogramSeries{}}
+	h.observe(0.5, "orders")
+	h.observe(3, "orders")
+
+	expected := []promSeries{
+		{labels: [][2]string{{"__name__", "latency_seconds_bucket"}, {"topic", "orders"}, {"le", "1"}}, value: 1, kind: "histogram"},
+		{labels: [][2]string{{"__name__", "latency_seconds_bucket"}, {"topic", "orders"}, {"le", "+Inf"}}, value: 2, kind: "histogram"},
+		{labels: [][2]string{{"__name__", "latency_seconds_sum"}, {"topic", "orders"}}, value: 3.5, kind: "histogram"},
+		{labels: [][2]string{{"__name__", "latency_seconds_count"}, {"topic", "orders"}}, value: 2, kind: "histogram"},
+	}
+	if actual := h.collect(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v but got %v", expected, actual)
+	}
+}
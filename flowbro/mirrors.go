@@ -0,0 +1,108 @@
+package flowbro
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+type mirrorJSON struct {
+	Topic       string `json:"topic"`
+	ToleranceMs int    `json:"toleranceMs"`
+}
+
+// mirror treats the topics matching its regex on every cluster as one
+// logical topic, as replicated by MirrorMaker. If the regex has a capturing
+// group, it extracts the logical name, e.g. (?:eu\.|us\.)?(orders) for
+// prefixed replicas. A message is a duplicate when another cluster already
+// delivered one with the same key and a timestamp within the tolerance.
+type mirror struct {
+	topic     *regexp.Regexp
+	tolerance time.Duration
+}
+
+type mirrorSighting struct {
+	cluster   string
+	timestamp time.Time
+	seen      time.Time
+}
+
+// mirrors deduplicates replicated messages across clusters.
+type mirrors struct {
+	mirrors   []mirror
+	sightings map[string][]mirrorSighting
+	lastSweep time.Time
+}
+
+const defaultMirrorTolerance = time.Second
+
+// mirrorRetention is how long sightings are remembered, at least, so that
+// replicas arriving late because of replication lag are still recognised.
+const mirrorRetention = time.Minute
+
+func processMirrors(mirrorsJSON []mirrorJSON) (*mirrors, error) {
+	if len(mirrorsJSON) == 0 {
+		return nil, nil
+	}
+	ms := &mirrors{sightings: map[string][]mirrorSighting{}, lastSweep: time.Now()}
+	for _, m := range mirrorsJSON {
+		topic, err := regexp.Compile("^(?:" + m.Topic + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid topic regex %v for mirror. err=%v", m.Topic, err)
+		}
+		tolerance := time.Duration(m.ToleranceMs) * time.Millisecond
+		if tolerance <= 0 {
+			tolerance = defaultMirrorTolerance
+		}
+		ms.mirrors = append(ms.mirrors, mirror{topic: topic, tolerance: tolerance})
+	}
+	return ms, nil
+}
+
+// dedupe renames m's topic to its logical name and reports whether m should
+// be kept, i.e. it isn't a replica of a message already seen.
+func (ms *mirrors) dedupe(m message, now time.Time) (message, bool) {
+	for _, mr := range ms.mirrors {
+		match := mr.topic.FindStringSubmatch(m.Topic)
+		if match == nil {
+			continue
+		}
+		if len(match) > 1 && len(match[1]) > 0 {
+			m.Topic = match[1]
+		}
+		ms.sweep(now)
+
+		id := m.Topic + "/" + m.Key
+		for _, s := range ms.sightings[id] {
+			if s.cluster == m.Cluster {
+				continue
+			}
+			if d := s.timestamp.Sub(m.Timestamp); d <= mr.tolerance && d >= -mr.tolerance {
+				return m, false
+			}
+		}
+		ms.sightings[id] = append(ms.sightings[id], mirrorSighting{cluster: m.Cluster, timestamp: m.Timestamp, seen: now})
+		return m, true
+	}
+	return m, true
+}
+
+func (ms *mirrors) sweep(now time.Time) {
+	if now.Sub(ms.lastSweep) < mirrorRetention {
+		return
+	}
+	ms.lastSweep = now
+	for id, ss := range ms.sightings {
+		kept := []mirrorSighting{}
+		for _, s := range ss {
+			if now.Sub(s.seen) < mirrorRetention {
+				kept = append(kept, s)
+			}
+		}
+		if len(kept) == 0 {
+			delete(ms.sightings, id)
+			continue
+		}
+		ms.sightings[id] = kept
+	}
+}
@@ -0,0 +1,40 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMirrorsDedupe(t *testing.T) {
+	ms, err := processMirrors([]mirrorJSON{{Topic: `(?:eu\.|us\.)?(orders)`, ToleranceMs: 500}})
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	now, ts := time.Now(), time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		m             message
+		expectedKeep  bool
+		expectedTopic string
+	}{
+		{name: "first sighting", m: message{Topic: "orders", Cluster: "eu", Key: "1", Timestamp: ts}, expectedKeep: true, expectedTopic: "orders"},
+		{name: "replica within tolerance", m: message{Topic: "us.orders", Cluster: "us", Key: "1", Timestamp: ts.Add(300 * time.Millisecond)}, expectedKeep: false, expectedTopic: "orders"},
+		{name: "same cluster isn't a replica", m: message{Topic: "orders", Cluster: "eu", Key: "1", Timestamp: ts.Add(100 * time.Millisecond)}, expectedKeep: true, expectedTopic: "orders"},
+		{name: "outside tolerance", m: message{Topic: "us.orders", Cluster: "us", Key: "1", Timestamp: ts.Add(2 * time.Second)}, expectedKeep: true, expectedTopic: "orders"},
+		{name: "other key", m: message{Topic: "us.orders", Cluster: "us", Key: "2", Timestamp: ts}, expectedKeep: true, expectedTopic: "orders"},
+		{name: "unmirrored topic", m: message{Topic: "payments", Cluster: "us", Key: "1", Timestamp: ts}, expectedKeep: true, expectedTopic: "payments"},
+	}
+
+	for _, ts := range tests {
+		m, keep := ms.dedupe(ts.m, now)
+		if keep != ts.expectedKeep || m.Topic != ts.expectedTopic {
+			t.Errorf("on '%v': expected keep=%v on topic %v but got keep=%v on topic %v", ts.name, ts.expectedKeep, ts.expectedTopic, keep, m.Topic)
+		}
+	}
+
+	ms.dedupe(message{Topic: "orders", Cluster: "eu", Key: "3", Timestamp: ts}, now)
+	if _, keep := ms.dedupe(message{Topic: "orders", Cluster: "us", Key: "3", Timestamp: ts}, now.Add(2*mirrorRetention)); !keep {
+		t.Errorf("expected sightings to be forgotten after the retention")
+	}
+}
@@ -0,0 +1,129 @@
+package flowbro
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type mockFixture struct {
+	Topic     string          `json:"topic"`
+	Partition int32           `json:"partition"`
+	Offset    int64           `json:"offset"`
+	Key       string          `json:"key"`
+	Value     json.RawMessage `json:"value"`
+	Timestamp time.Time       `json:"timestamp"`
+	DelayMs   int64           `json:"delayMs"`
+	Cluster   string          `json:"cluster"`
+}
+
+// mockSource serves the scripted messages in the fixtures file at path as if
+// they were coming from Kafka, so the whole pipeline can run without a
+// broker.
+type mockSource struct {
+	path string
+	life *lifecycle
+}
+
+func (s *mockSource) Start(ctx context.Context) (<-chan *SourceMessage, error) {
+	fixtures, err := readMockFixtures(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load mock fixtures. err=%v", err)
+	}
+	return s.push(ctx, fixtures), nil
+}
+
+func (s *mockSource) push(ctx context.Context, fixtures []mockFixture) <-chan *SourceMessage {
+	c := make(chan *SourceMessage)
+	s.life = newLifecycle(ctx)
+	s.life.spawn(func(ctx context.Context) { pushMockMessages(ctx, c, fixtures) })
+	return c
+}
+
+func (s *mockSource) Close() {
+	if s.life != nil {
+		s.life.stop()
+		s.life.wait()
+	}
+}
+
+// readMockFixtures reads either a JSON array of fixtures or one fixture per line.
+func readMockFixtures(path string) ([]mockFixture, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	first, err := r.Peek(1)
+	for err == nil && strings.TrimSpace(string(first)) == "" {
+		r.ReadByte()
+		first, err = r.Peek(1)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Mock fixtures file %v is empty", path)
+	}
+
+	fixtures := []mockFixture{}
+	d := json.NewDecoder(r)
+	if first[0] == '[' {
+		if err := d.Decode(&fixtures); err != nil {
+			return nil, fmt.Errorf("Could not parse mock fixtures file %v. err=%v", path, err)
+		}
+		return fixtures, nil
+	}
+
+	for d.More() {
+		var f mockFixture
+		if err := d.Decode(&f); err != nil {
+			return nil, fmt.Errorf("Could not parse mock fixtures file %v. err=%v", path, err)
+		}
+		fixtures = append(fixtures, f)
+	}
+	return fixtures, nil
+}
+
+func pushMockMessages(ctx context.Context, c chan *SourceMessage, fixtures []mockFixture) {
+	offsets := map[string]int64{}
+	for _, f := range fixtures {
+		if f.DelayMs > 0 && !sleep(ctx, time.Duration(f.DelayMs)*time.Millisecond) {
+			return
+		}
+
+		tp := fmt.Sprintf("%v/%v", f.Topic, f.Partition)
+		if f.Offset == 0 {
+			f.Offset = offsets[tp]
+		}
+		offsets[tp] = f.Offset + 1
+
+		m := &SourceMessage{
+			Topic:     f.Topic,
+			Partition: f.Partition,
+			Offset:    f.Offset,
+			Key:       []byte(f.Key),
+			Value:     mockValue(f.Value),
+			Timestamp: f.Timestamp,
+			cluster:   f.Cluster,
+		}
+		select {
+		case c <- m:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// mockValue lets fixtures define values either as JSON or as a JSON string
+// holding the raw payload.
+func mockValue(raw json.RawMessage) []byte {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return []byte(s)
+	}
+	return []byte(raw)
+}
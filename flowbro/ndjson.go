@@ -0,0 +1,143 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// conn is a client the events of a flow are streamed to, as JSON arrays of
+// events each written at once; a websocket or an NDJSON response.
+type conn interface {
+	io.Writer
+	Close() error
+	Request() *http.Request
+}
+
+// ndjsonConn streams events as newline-delimited JSON, one event per line,
+// over a chunked HTTP response or a socket.
+type ndjsonConn struct {
+	w io.Writer
+	r *http.Request
+
+	l      sync.Mutex
+	closed bool
+}
+
+func (c *ndjsonConn) Write(b []byte) (int, error) {
+	var events []json.RawMessage
+	if err := json.Unmarshal(b, &events); err != nil {
+		return 0, err
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	for _, e := range events {
+		if _, err := c.w.Write(append(e, '\n')); err != nil {
+			return 0, err
+		}
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return len(b), nil
+}
+
+func (c *ndjsonConn) Close() error {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.closed = true
+	if closer, ok := c.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *ndjsonConn) Request() *http.Request {
+	return c.r
+}
+
+// streamHandler streams the events of a flow as NDJSON, e.g.
+// GET /stream?config=payments&filter=value.amount > 100 | jq. The flow is
+// the config of that name, the board of that name or the shared view of
+// that token, narrowed down with the same filter, grep and fsmId as
+// websocket clients use. A query adds a SQL query to it, e.g.
+// &query=SELECT key FROM orders WHERE value.amount > 100.
+func (f *flowbro) streamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+		return
+	}
+	raw, c, sh, status, err := f.streamConfig(r)
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	q := r.URL.Query()
+	c.Kafka.Filter = andFilter(c.Kafka.Filter, q.Get("filter"))
+	c.extraFilter = q.Get("filter")
+	if grep := q.Get("grep"); len(grep) > 0 {
+		c.Kafka.Grep = grep
+	}
+	if fsmId := q.Get("fsmId"); len(fsmId) > 0 {
+		c.FSMId = fsmId
+	}
+	if sql := q.Get("query"); len(sql) > 0 {
+		c.Queries = append(c.Queries, queryJSON{Id: "query", SQL: sql})
+	}
+	c.HeartbeatUUID, c.Session = "", ""
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if fl, ok := w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	f.stream(&ndjsonConn{w: w, r: r}, principalOf(r).Role, raw, c, nil, sh)
+}
+
+// streamConfig returns the config a stream request asks for, along with
+// the share it's a view of, if any, or the status to fail with.
+func (f *flowbro) streamConfig(r *http.Request) (json.RawMessage, *configJSON, *share, int, error) {
+	q := r.URL.Query()
+	switch {
+	case len(q.Get("share")) > 0:
+		sh, err := f.shares.get(q.Get("share"), time.Now())
+		if err != nil {
+			return nil, nil, nil, http.StatusNotFound, err
+		}
+		raw, c, err := f.shares.config(sh, "")
+		if err != nil {
+			return nil, nil, nil, http.StatusInternalServerError, err
+		}
+		return raw, c, &sh, 0, nil
+	case len(q.Get("board")) > 0:
+		if len(f.boardsDir) == 0 {
+			return nil, nil, nil, http.StatusNotFound, fmt.Errorf("Boards aren't served; please start flowbro with -boards-dir")
+		}
+		name := q.Get("board")
+		if !principalOf(r).mayOpen(name) {
+			return nil, nil, nil, http.StatusForbidden, fmt.Errorf("You may not open board %v", name)
+		}
+		raw, c, err := readBoard(f.boardsDir, name)
+		if err != nil {
+			return nil, nil, nil, http.StatusNotFound, err
+		}
+		c.board = name
+		return raw, c, nil, 0, nil
+	case len(q.Get("config")) > 0:
+		raw, c, err := readBoard(mainPath, q.Get("config"))
+		if err != nil {
+			return nil, nil, nil, http.StatusNotFound, err
+		}
+		c.Config = q.Get("config")
+		return raw, c, nil, 0, nil
+	}
+	return nil, nil, nil, http.StatusBadRequest, fmt.Errorf("Please choose the flow to stream with config, board or share")
+}
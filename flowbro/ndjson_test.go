@@ -0,0 +1,84 @@
+package flowbro
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStreamHandler(t *testing.T) {
+	fixtures := `{"topic":"payments","key":"1","value":{"amount":10}}
+{"topic":"payments","key":"2","value":{"amount":500}}
+`
+	path := writeTempFile(t, fixtures)
+	defer os.Remove(path)
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	board, _ := json.Marshal(configJSON{Rules: []rule{{
+		Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "payments"}},
+		Events:   []event{{EventType: "message", SourceId: "a", TargetId: "b", FSMId: "{{.Key}}"}},
+	}}})
+	if err := ioutil.WriteFile(filepath.Join(dir, "payments.json"), board, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := httptestServer(&flowbro{mockPath: path, boardsDir: dir})
+	defer s.Close()
+
+	tests := []struct {
+		method, query string
+		status        int
+	}{
+		{method: "POST", query: "board=payments", status: http.StatusMethodNotAllowed},
+		{method: "GET", query: "", status: http.StatusBadRequest},
+		{method: "GET", query: "board=orders", status: http.StatusNotFound},
+		{method: "GET", query: "share=unknown", status: http.StatusNotFound},
+	}
+	for _, ts := range tests {
+		req, _ := http.NewRequest(ts.method, s.URL+"/stream?"+ts.query, nil)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != ts.status {
+			t.Errorf("on '%v %v': expected status %v but got %v", ts.method, ts.query, ts.status, resp.StatusCode)
+		}
+	}
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get(s.URL + "/stream?board=payments&filter=" + url.QueryEscape("value.amount > 100"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("expected NDJSON but got %v", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		var e event
+		if err := json.Unmarshal(lines.Bytes(), &e); err != nil {
+			t.Fatalf("expected a JSON event per line but got %v", lines.Text())
+		}
+		if e.EventType != "message" {
+			continue
+		}
+		if e.FSMId != "2" {
+			t.Errorf("expected only the payment matching the filter but got %+v", e)
+		}
+		return
+	}
+	t.Errorf("expected a message event before the stream ended. err=%v", lines.Err())
+}
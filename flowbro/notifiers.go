@@ -0,0 +1,124 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type notifierJSON struct {
+	Type     string `json:"type"`
+	URL      string `json:"url"`
+	Channel  string `json:"channel"`
+	Template string `json:"template"`
+}
+
+type notifier struct {
+	notifierJSON
+	template *template.Template
+}
+
+type notification struct {
+	Alert       string
+	Text        string
+	Topic       string
+	ComponentId string
+	Time        time.Time
+}
+
+func processNotifiers(notifiersJSON []notifierJSON, webhook string) ([]notifier, error) {
+	if len(webhook) > 0 {
+		notifiersJSON = append(notifiersJSON, notifierJSON{Type: "webhook", URL: webhook})
+	}
+
+	notifiers := []notifier{}
+	for _, n := range notifiersJSON {
+		switch n.Type {
+		case "webhook", "slack", "teams":
+		case "":
+			n.Type = "webhook"
+		default:
+			return notifiers, fmt.Errorf("Unknown notifier type %v; use webhook, slack or teams", n.Type)
+		}
+		if len(n.URL) == 0 {
+			return notifiers, fmt.Errorf("Please define a url for your %v notifier", n.Type)
+		}
+
+		var t *template.Template
+		if len(n.Template) > 0 {
+			var err error
+			if t, err = template.New("").Parse(n.Template); err != nil {
+				return notifiers, fmt.Errorf("Invalid template for %v notifier. err=%v", n.Type, err)
+			}
+		}
+		notifiers = append(notifiers, notifier{notifierJSON: n, template: t})
+	}
+	return notifiers, nil
+}
+
+func notifyAlert(al alert, text string) {
+	n := notification{Alert: al.Name, Text: text, Topic: al.Topic, ComponentId: al.ComponentId, Time: time.Now().UTC()}
+	for _, nt := range al.notifiers {
+		go nt.send(n)
+	}
+}
+
+func (nt notifier) send(n notification) {
+	byt, err := nt.payload(n)
+	if err != nil {
+		log.WithFields(log.Fields{"err": err, "alert": n.Alert, "type": nt.Type}).Error("Failed to build alert notification.")
+		return
+	}
+
+	client := http.Client{Timeout: time.Duration(5 * time.Second)}
+	r, err := client.Post(nt.URL, "application/json", bytes.NewReader(byt))
+	if err != nil {
+		log.WithFields(log.Fields{"err": err, "alert": n.Alert, "type": nt.Type, "url": nt.URL}).Error("Failed to send alert notification.")
+		return
+	}
+	r.Body.Close()
+	if r.StatusCode >= 300 {
+		log.WithFields(log.Fields{"status": r.StatusCode, "alert": n.Alert, "type": nt.Type, "url": nt.URL}).Error("Alert notification was rejected.")
+	}
+}
+
+func (nt notifier) payload(n notification) ([]byte, error) {
+	text := n.Text
+	if nt.template != nil {
+		var b bytes.Buffer
+		if err := nt.template.Execute(&b, n); err != nil {
+			return nil, err
+		}
+		text = b.String()
+	}
+
+	switch nt.Type {
+	case "slack":
+		p := map[string]string{"text": text}
+		if len(nt.Channel) > 0 {
+			p["channel"] = nt.Channel
+		}
+		return json.Marshal(p)
+	case "teams":
+		return json.Marshal(map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "http://schema.org/extensions",
+			"themeColor": "E53A40",
+			"summary":    n.Alert,
+			"title":      "Flowbro alert: " + n.Alert,
+			"text":       text,
+		})
+	}
+	return json.Marshal(map[string]interface{}{
+		"alert":       n.Alert,
+		"text":        text,
+		"topic":       n.Topic,
+		"componentId": n.ComponentId,
+		"time":        n.Time,
+	})
+}
@@ -0,0 +1,340 @@
+package flowbro
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type oidcJSON struct {
+	Issuer       string            `json:"issuer"`
+	ClientId     string            `json:"clientId"`
+	ClientSecret string            `json:"clientSecret"`
+	RedirectURL  string            `json:"redirectUrl"`
+	Scopes       []string          `json:"scopes"`
+	NameClaim    string            `json:"nameClaim"`
+	RolesClaim   string            `json:"rolesClaim"`
+	Roles        map[string]string `json:"roles"` // claim value to role
+	DefaultRole  string            `json:"defaultRole"`
+	BoardsClaim  string            `json:"boardsClaim"`
+}
+
+// oidc logs browsers in with an OpenID Connect provider's authorization code
+// flow, keeping the ID token it gets in a cookie, and authenticates requests
+// carrying a valid ID token, mapping its claims to a role and the boards the
+// principal may open.
+type oidc struct {
+	config       oidcJSON
+	roles        map[string]role
+	defaultRole  role
+	authorizeURL string
+	tokenURL     string
+	jwksURL      string
+	client       *http.Client
+
+	l         sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	keysFetch time.Time
+}
+
+const (
+	oidcCallbackPath = "/auth/callback"
+	oidcStateCookie  = "flowbro_oidc_state"
+	jwksRefresh      = time.Minute
+)
+
+// loginFlow is an authenticator that logs browsers in by redirecting them to
+// another site and back.
+type loginFlow interface {
+	authenticator
+	login(w http.ResponseWriter, r *http.Request)
+	callback(w http.ResponseWriter, r *http.Request)
+}
+
+func readOIDC(path string) (*oidc, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read OIDC config. err=%v", err)
+	}
+	var c oidcJSON
+	if err := json.Unmarshal(byt, &c); err != nil {
+		return nil, fmt.Errorf("Invalid OIDC config %v. err=%v", path, err)
+	}
+	return newOIDC(c)
+}
+
+// newOIDC discovers the endpoints of the issuer.
+func newOIDC(c oidcJSON) (*oidc, error) {
+	if len(c.Issuer) == 0 || len(c.ClientId) == 0 || len(c.RedirectURL) == 0 {
+		return nil, fmt.Errorf("Please define issuer, clientId and redirectUrl for OIDC")
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = []string{"openid", "email"}
+	}
+	if len(c.NameClaim) == 0 {
+		c.NameClaim = "email"
+	}
+	o := &oidc{config: c, roles: map[string]role{}, client: &http.Client{Timeout: time.Duration(5 * time.Second)}}
+	for claim, name := range c.Roles {
+		r, err := parseRole(name)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid OIDC role for %v. err=%v", claim, err)
+		}
+		o.roles[claim] = r
+	}
+	if len(c.DefaultRole) > 0 {
+		r, err := parseRole(c.DefaultRole)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid OIDC default role. err=%v", err)
+		}
+		o.defaultRole = r
+	}
+
+	var discovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := o.getJSON(strings.TrimSuffix(c.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("Could not discover OIDC issuer %v. err=%v", c.Issuer, err)
+	}
+	if discovery.Issuer != c.Issuer {
+		return nil, fmt.Errorf("OIDC issuer %v calls itself %v", c.Issuer, discovery.Issuer)
+	}
+	o.authorizeURL, o.tokenURL, o.jwksURL = discovery.AuthorizationEndpoint, discovery.TokenEndpoint, discovery.JWKSURI
+	return o, nil
+}
+
+func (o *oidc) getJSON(u string, v interface{}) error {
+	resp, err := o.client.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %v returned %v", u, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (o *oidc) authenticate(r *http.Request) (principal, bool, error) {
+	t := requestToken(r)
+	if len(t) == 0 {
+		return principal{}, false, nil
+	}
+	claims, err := o.verify(t, time.Now())
+	if err != nil {
+		return principal{}, false, nil
+	}
+	return o.principal(claims), true, nil
+}
+
+// principal maps the claims of an ID token to who they identify.
+func (o *oidc) principal(claims map[string]interface{}) principal {
+	p := principal{Name: fmt.Sprint(claims[o.config.NameClaim]), Role: o.defaultRole}
+	for _, v := range claimValues(claims[o.config.RolesClaim]) {
+		if r, ok := o.roles[v]; ok && r > p.Role {
+			p.Role = r
+		}
+	}
+	if len(o.config.BoardsClaim) > 0 {
+		p.Boards = claimValues(claims[o.config.BoardsClaim])
+	}
+	return p
+}
+
+// claimValues returns a claim holding a string or a list of strings as a
+// list.
+func claimValues(claim interface{}) []string {
+	switch c := claim.(type) {
+	case string:
+		return []string{c}
+	case []interface{}:
+		values := []string{}
+		for _, v := range c {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return []string{}
+}
+
+// verify checks the RS256 signature, issuer, audience and expiry of the ID
+// token t, returning its claims.
+func (o *oidc) verify(t string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(t, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("Unsupported token algorithm %v", header.Alg)
+	}
+	key, err := o.key(header.Kid, now)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed token signature. err=%v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("Invalid token signature. err=%v", err)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if claims["iss"] != o.config.Issuer {
+		return nil, fmt.Errorf("Token issued by %v", claims["iss"])
+	}
+	audience := false
+	for _, aud := range claimValues(claims["aud"]) {
+		audience = audience || aud == o.config.ClientId
+	}
+	if !audience {
+		return nil, fmt.Errorf("Token not meant for %v", o.config.ClientId)
+	}
+	if exp, ok := claims["exp"].(float64); !ok || now.Unix() >= int64(exp) {
+		return nil, fmt.Errorf("Token expired")
+	}
+	return claims, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	byt, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("Malformed token. err=%v", err)
+	}
+	if err := json.Unmarshal(byt, v); err != nil {
+		return fmt.Errorf("Malformed token. err=%v", err)
+	}
+	return nil
+}
+
+// key returns the issuer's signing key kid, refetching the keys at most once
+// a minute when it's unknown, e.g. after the issuer rotated them.
+func (o *oidc) key(kid string, now time.Time) (*rsa.PublicKey, error) {
+	o.l.Lock()
+	defer o.l.Unlock()
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	if now.Sub(o.keysFetch) < jwksRefresh {
+		return nil, fmt.Errorf("Unknown signing key %v", kid)
+	}
+	o.keysFetch = now
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(o.jwksURL, &jwks); err != nil {
+		return nil, fmt.Errorf("Could not fetch the issuer's signing keys. err=%v", err)
+	}
+	o.keys = map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		o.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if k, ok := o.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("Unknown signing key %v", kid)
+}
+
+// login redirects the browser to the issuer, remembering where it was going.
+func (o *oidc) login(w http.ResponseWriter, r *http.Request) {
+	state := newId() + newId()
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Value: state + ":" + r.URL.RequestURI(), Path: oidcCallbackPath, HttpOnly: true, MaxAge: 600, SameSite: http.SameSiteLaxMode, Secure: overTLS(r)})
+	q := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.config.ClientId},
+		"redirect_uri":  {o.config.RedirectURL},
+		"scope":         {strings.Join(o.config.Scopes, " ")},
+		"state":         {state},
+	}
+	http.Redirect(w, r, o.authorizeURL+"?"+q.Encode(), http.StatusFound)
+}
+
+// callback exchanges the code the issuer redirected back with for an ID
+// token, keeping it in the token cookie.
+func (o *oidc) callback(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Login expired; please try again"))
+		return
+	}
+	i := strings.Index(c.Value, ":")
+	if i < 0 || c.Value[:i] != r.URL.Query().Get("state") {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid login state"))
+		return
+	}
+	next := c.Value[i+1:]
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/"
+	}
+
+	resp, err := o.client.PostForm(o.tokenURL, url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {r.URL.Query().Get("code")},
+		"redirect_uri":  {o.config.RedirectURL},
+		"client_id":     {o.config.ClientId},
+		"client_secret": {o.config.ClientSecret},
+	})
+	if err != nil {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("Could not exchange the login code. err=%v", err))
+		return
+	}
+	defer resp.Body.Close()
+	var token struct {
+		IdToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil || resp.StatusCode != http.StatusOK {
+		writeError(w, http.StatusBadGateway, fmt.Errorf("Could not exchange the login code; the issuer returned %v", resp.Status))
+		return
+	}
+	claims, err := o.verify(token.IdToken, time.Now())
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	exp := time.Unix(int64(claims["exp"].(float64)), 0)
+	// Lax rather than Strict, as the browser comes back from the issuer and
+	// wouldn't send a Strict cookie along the redirect that follows
+	http.SetCookie(w, &http.Cookie{Name: tokenCookie, Value: token.IdToken, Path: "/", HttpOnly: true, Expires: exp, SameSite: http.SameSiteLaxMode, Secure: overTLS(r)})
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: oidcCallbackPath, MaxAge: -1})
+	http.Redirect(w, r, next, http.StatusFound)
+}
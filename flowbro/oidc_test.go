@@ -0,0 +1,200 @@
+package flowbro
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeIssuer struct {
+	*httptest.Server
+	key     *rsa.PrivateKey
+	idToken string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := &fakeIssuer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 i.URL,
+			"authorization_endpoint": i.URL + "/authorize",
+			"token_endpoint":         i.URL + "/token",
+			"jwks_uri":               i.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("code") != "good" || r.PostFormValue("client_secret") != "secret" {
+			writeError(w, http.StatusBadRequest, nil)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"id_token": i.idToken})
+	})
+	i.Server = httptest.NewServer(mux)
+	return i
+}
+
+func (i *fakeIssuer) sign(t *testing.T, key *rsa.PrivateKey, header, claims map[string]interface{}) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (i *fakeIssuer) oidc(t *testing.T) *oidc {
+	o, err := newOIDC(oidcJSON{
+		Issuer:       i.URL,
+		ClientId:     "flowbro",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:41234/auth/callback",
+		RolesClaim:   "groups",
+		Roles:        map[string]string{"dev": "viewer", "sre": "operator"},
+		BoardsClaim:  "boards",
+	})
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	return o
+}
+
+func TestOIDCVerify(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.Close()
+	o := issuer.oidc(t)
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	now := time.Now()
+	claims := func(iss, aud string, exp time.Time) map[string]interface{} {
+		return map[string]interface{}{"iss": iss, "aud": aud, "exp": exp.Unix(), "email": "alice@example.com", "groups": []string{"dev", "sre"}, "boards": "payments"}
+	}
+	rs256 := map[string]interface{}{"alg": "RS256", "kid": "k1"}
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"valid", issuer.sign(t, issuer.key, rs256, claims(issuer.URL, "flowbro", now.Add(time.Hour))), true},
+		{"expired", issuer.sign(t, issuer.key, rs256, claims(issuer.URL, "flowbro", now.Add(-time.Second))), false},
+		{"other audience", issuer.sign(t, issuer.key, rs256, claims(issuer.URL, "other", now.Add(time.Hour))), false},
+		{"other issuer", issuer.sign(t, issuer.key, rs256, claims("https://evil", "flowbro", now.Add(time.Hour))), false},
+		{"other key", issuer.sign(t, other, rs256, claims(issuer.URL, "flowbro", now.Add(time.Hour))), false},
+		{"unknown key", issuer.sign(t, issuer.key, map[string]interface{}{"alg": "RS256", "kid": "k2"}, claims(issuer.URL, "flowbro", now.Add(time.Hour))), false},
+		{"none", issuer.sign(t, issuer.key, map[string]interface{}{"alg": "none"}, claims(issuer.URL, "flowbro", now.Add(time.Hour))), false},
+		{"malformed", "a.b", false},
+	}
+	for _, tt := range tests {
+		c, err := o.verify(tt.token, now)
+		if (err == nil) != tt.valid {
+			t.Errorf("on '%v': expected valid=%v but got err=%v", tt.name, tt.valid, err)
+		}
+		if err == nil {
+			p := o.principal(c)
+			if p.Name != "alice@example.com" || p.Role != operator || !p.mayOpen("payments") || p.mayOpen("orders") {
+				t.Errorf("on '%v': expected alice to be an operator of the payments board but got %+v", tt.name, p)
+			}
+		}
+	}
+	if p := o.principal(map[string]interface{}{"email": "bob", "groups": "marketing"}); p.Role != 0 || p.mayOpen("orders") {
+		t.Errorf("expected unmapped groups and missing boards to get no role nor board but got %+v", p)
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.Close()
+	f := &flowbro{auth: issuer.oidc(t)}
+	var got principal
+	h := f.authorize(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = principalOf(r) }))
+	issuer.idToken = issuer.sign(t, issuer.key, map[string]interface{}{"alg": "RS256", "kid": "k1"}, map[string]interface{}{
+		"iss": issuer.URL, "aud": []string{"flowbro"}, "exp": time.Now().Add(time.Hour).Unix(), "email": "alice", "groups": []string{"dev"},
+	})
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/?config=payments", nil))
+	location, _ := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusFound || !strings.HasPrefix(location.String(), issuer.URL+"/authorize") || location.Query().Get("client_id") != "flowbro" {
+		t.Fatalf("expected to be sent to log in but got %v to %v", w.Code, location)
+	}
+	state := w.Result().Cookies()[0]
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/api/bookmarks", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected API calls without a token to be refused but got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", oidcCallbackPath+"?code=good&state=wrong", nil)
+	r.AddCookie(state)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected a wrong state to be refused but got %v", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", oidcCallbackPath+"?code=good&state="+location.Query().Get("state"), nil)
+	r.AddCookie(state)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/?config=payments" {
+		t.Fatalf("expected to be sent back after logging in but got %v to %v: %v", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+	var token *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == tokenCookie {
+			token = c
+		}
+	}
+	if token == nil {
+		t.Fatalf("expected the ID token to be kept in a cookie")
+	}
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest("GET", "/api/bookmarks", nil)
+	r.AddCookie(token)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || got.Name != "alice" || got.Role != viewer {
+		t.Errorf("expected alice to read as a viewer but got %v as %+v", w.Code, got)
+	}
+}
+
+func TestInvalidOIDC(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.Close()
+	configs := []oidcJSON{
+		{ClientId: "flowbro", RedirectURL: "http://localhost/auth/callback"},
+		{Issuer: issuer.URL, ClientId: "flowbro", RedirectURL: "http://localhost/auth/callback", Roles: map[string]string{"sre": "admin"}},
+		{Issuer: issuer.URL + "/other", ClientId: "flowbro", RedirectURL: "http://localhost/auth/callback"},
+	}
+	for _, c := range configs {
+		if _, err := newOIDC(c); err == nil {
+			t.Errorf("on '%+v': expected newOIDC to fail", c)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package flowbro
+
+import (
+	"fmt"
+	"regexp"
+)
+
+type orderingJSON struct {
+	Topic    string `json:"topic"`
+	Sequence string `json:"sequence"`
+}
+
+// ordering checks that the messages of every key of the topics matching its
+// regex arrive in order, i.e. that their sequence never decreases. The
+// sequence is an expression such as value.seq, or the message timestamp.
+type ordering struct {
+	topic    *regexp.Regexp
+	src      string
+	sequence *celProgram
+	last     map[string]interface{}
+}
+
+const defaultOrderingSequence = "timestamp"
+
+// orderingMaxKeys bounds the keys remembered per ordering; once reached
+// they're forgotten and checking starts over.
+const orderingMaxKeys = 100000
+
+func processOrderings(orderingsJSON []orderingJSON) ([]*ordering, error) {
+	orderings := []*ordering{}
+	for _, o := range orderingsJSON {
+		topic, err := regexp.Compile("^(?:" + o.Topic + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid topic regex %v for ordering. err=%v", o.Topic, err)
+		}
+		src := o.Sequence
+		if len(src) == 0 {
+			src = defaultOrderingSequence
+		}
+		sequence, err := compileCEL(src)
+		if err != nil {
+			return nil, err
+		}
+		orderings = append(orderings, &ordering{topic: topic, src: src, sequence: sequence, last: map[string]interface{}{}})
+	}
+	return orderings, nil
+}
+
+// checkOrder tags m with outOfOrder, and returns an outOfOrder event, if
+// its sequence is lower than that of an earlier message with the same key.
+func checkOrder(orderings []*ordering, m message) (message, []event) {
+	events := []event{}
+	for _, o := range orderings {
+		if !o.topic.MatchString(m.Topic) {
+			continue
+		}
+		seq, err := o.sequence.eval(m)
+		if err != nil {
+			continue
+		}
+
+		id := m.Topic + "|" + m.Key
+		last, seen := o.last[id]
+		if len(o.last) >= orderingMaxKeys && !seen {
+			o.last = map[string]interface{}{}
+		}
+		if c, err := celCompare(seq, last); seen && err == nil && c < 0 {
+			tags := map[string]string{"outOfOrder": fmt.Sprint(last)}
+			for k, v := range m.Tags {
+				tags[k] = v
+			}
+			m.Tags = tags
+			partition := m.Partition
+			events = append(events, event{
+				EventType: "outOfOrder",
+				Text:      fmt.Sprintf("Message at offset %v of topic %v partition %v with key %v arrived out of order: its %v is %v, after %v.", m.Offset, m.Topic, m.Partition, m.Key, o.src, seq, last),
+				Color:     "warning",
+				Highlight: true,
+				Cluster:   m.Cluster,
+				Topic:     m.Topic,
+				Partition: &partition,
+			})
+			continue
+		}
+		o.last[id] = seq
+	}
+	return m, events
+}
@@ -0,0 +1,43 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckOrder(t *testing.T) {
+	orderings, err := processOrderings([]orderingJSON{{Topic: "orders", Sequence: "value.seq"}, {Topic: "payments"}})
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		m          message
+		outOfOrder bool
+	}{
+		{name: "first of key", m: message{Topic: "orders", Key: "a", Value: newValueFrom(`{"seq":2}`)}},
+		{name: "increasing", m: message{Topic: "orders", Key: "a", Value: newValueFrom(`{"seq":3}`)}},
+		{name: "other key", m: message{Topic: "orders", Key: "b", Value: newValueFrom(`{"seq":1}`)}},
+		{name: "decreasing", m: message{Topic: "orders", Key: "a", Value: newValueFrom(`{"seq":1}`)}, outOfOrder: true},
+		{name: "compared to the highest", m: message{Topic: "orders", Key: "a", Value: newValueFrom(`{"seq":2}`)}, outOfOrder: true},
+		{name: "no sequence", m: message{Topic: "orders", Key: "a", Value: newValueFrom(`{}`)}},
+		{name: "unchecked topic", m: message{Topic: "users", Key: "a"}},
+		{name: "timestamps", m: message{Topic: "payments", Key: "a", Timestamp: start.Add(time.Second)}},
+		{name: "earlier timestamp", m: message{Topic: "payments", Key: "a", Timestamp: start}, outOfOrder: true},
+	}
+
+	for _, ts := range tests {
+		m, events := checkOrder(orderings, ts.m)
+		if _, tagged := m.Tags["outOfOrder"]; tagged != ts.outOfOrder {
+			t.Errorf("on '%v': expected outOfOrder tag to be %v but got %v", ts.name, ts.outOfOrder, m.Tags)
+		}
+		if ts.outOfOrder && (len(events) != 1 || events[0].EventType != "outOfOrder") {
+			t.Errorf("on '%v': expected an outOfOrder event but got %+v", ts.name, events)
+		}
+		if !ts.outOfOrder && len(events) > 0 {
+			t.Errorf("on '%v': expected no events but got %+v", ts.name, events)
+		}
+	}
+}
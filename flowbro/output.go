@@ -0,0 +1,98 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// output publishes the events of a flow as NDJSON to every client of a unix
+// socket or TCP listener, e.g. `nc -U flowbro.sock | jq`, each client
+// getting a stream of its own.
+type output struct {
+	listener net.Listener
+	raw      json.RawMessage
+}
+
+// parseOutputAddress parses unix:<path> or tcp:<host:port>.
+func parseOutputAddress(s string) (string, string, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return "", "", fmt.Errorf("Invalid output %v; please use unix:<path> or tcp:<host:port>", s)
+	}
+	network, address := s[:i], s[i+1:]
+	if (network != "unix" && network != "tcp") || len(address) == 0 {
+		return "", "", fmt.Errorf("Invalid output %v; please use unix:<path> or tcp:<host:port>", s)
+	}
+	return network, address, nil
+}
+
+func newOutput(address, configPath string) (*output, error) {
+	network, address, err := parseOutputAddress(address)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read output config %v. err=%v", configPath, err)
+	}
+	if err := json.Unmarshal(raw, &configJSON{}); err != nil {
+		return nil, fmt.Errorf("Invalid output config %v. err=%v", configPath, err)
+	}
+	if network == "unix" {
+		if fi, err := os.Stat(address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(address) // left behind by a previous run
+		}
+	}
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("Could not listen on output %v:%v. err=%v", network, address, err)
+	}
+	return &output{listener: l, raw: raw}, nil
+}
+
+// serve streams the flow to every client until ctx is done.
+func (o *output) serve(ctx context.Context, f *flowbro) {
+	go func() {
+		<-ctx.Done()
+		o.listener.Close()
+	}()
+	for {
+		c, err := o.listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithFields(log.Fields{"err": err}).Error("Output stopped accepting clients.")
+			}
+			return
+		}
+		go o.stream(ctx, f, c)
+	}
+}
+
+func (o *output) stream(ctx context.Context, f *flowbro, c net.Conn) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		io.Copy(ioutil.Discard, c) // clients only listen; this returns once they hang up
+		cancel()
+	}()
+
+	var configJSON configJSON
+	json.Unmarshal(o.raw, &configJSON) // validated by newOutput
+	r := (&http.Request{Method: "GET", URL: &url.URL{Path: "/output"}, RemoteAddr: c.RemoteAddr().String()}).WithContext(ctx)
+	f.stream(&ndjsonConn{w: c, r: r}, viewer, o.raw, &configJSON, nil, nil)
+	c.Close()
+}
+
+func (o *output) addr() net.Addr {
+	return o.listener.Addr()
+}
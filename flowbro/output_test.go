@@ -0,0 +1,87 @@
+package flowbro
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseOutputAddress(t *testing.T) {
+	tests := []struct {
+		address, network, expected string
+		fails                      bool
+	}{
+		{address: "unix:/tmp/flowbro.sock", network: "unix", expected: "/tmp/flowbro.sock"},
+		{address: "tcp:localhost:9000", network: "tcp", expected: "localhost:9000"},
+		{address: "udp:localhost:9000", fails: true},
+		{address: "unix:", fails: true},
+		{address: "/tmp/flowbro.sock", fails: true},
+	}
+
+	for _, ts := range tests {
+		network, address, err := parseOutputAddress(ts.address)
+		if ts.fails != (err != nil) {
+			t.Errorf("on '%v': expected failure %v but got %v", ts.address, ts.fails, err)
+			continue
+		}
+		if network != ts.network || address != ts.expected {
+			t.Errorf("on '%v': expected %v %v but got %v %v", ts.address, ts.network, ts.expected, network, address)
+		}
+	}
+}
+
+func TestOutputOnUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixtures := filepath.Join(dir, "fixtures.json")
+	ioutil.WriteFile(fixtures, []byte(`{"topic":"payments","key":"1","value":{}}`), 0644)
+	conf, _ := json.Marshal(configJSON{Rules: []rule{{
+		Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "payments"}},
+		Events:   []event{{EventType: "message", SourceId: "a", TargetId: "b", FSMId: "{{.Key}}"}},
+	}}})
+	confPath := filepath.Join(dir, "payments.json")
+	ioutil.WriteFile(confPath, conf, 0644)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "flowbro.sock")
+	s, err := NewServer(WithListener(l), WithMockPath(fixtures), WithOutput("unix:"+socket, confPath))
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	c, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("Could not connect to the output. err=%v", err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	lines := bufio.NewScanner(c)
+	for lines.Scan() {
+		var e event
+		if err := json.Unmarshal(lines.Bytes(), &e); err != nil {
+			t.Fatalf("expected a JSON event per line but got %v", lines.Text())
+		}
+		if e.EventType == "message" {
+			if e.FSMId != "1" {
+				t.Errorf("expected the payment's event but got %+v", e)
+			}
+			return
+		}
+	}
+	t.Errorf("expected a message event before the output ended. err=%v", lines.Err())
+}
@@ -0,0 +1,177 @@
+package flowbro
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+)
+
+type pairJSON struct {
+	Name                string  `json:"name"`
+	Request             string  `json:"request"`
+	Response            string  `json:"response"`
+	Correlation         string  `json:"correlation"`
+	ResponseCorrelation string  `json:"responseCorrelation"`
+	TimeoutSeconds      float64 `json:"timeoutSeconds"`
+	ComponentId         string  `json:"componentId"`
+}
+
+// pair matches responses to the requests they answer, by the value of the
+// correlation expression on each side, e.g. value.requestId.
+type pair struct {
+	name                string
+	request             *regexp.Regexp
+	response            *regexp.Regexp
+	correlation         *celProgram
+	responseCorrelation *celProgram
+	timeout             time.Duration
+	componentId         string
+	open                map[string]openRequest
+}
+
+type openRequest struct {
+	id   string
+	m    message
+	seen time.Time
+}
+
+const defaultPairTimeout = 30 * time.Second
+
+// pairMaxOpen bounds the requests awaiting a response per pair; once reached
+// new requests aren't tracked until older ones time out.
+const pairMaxOpen = 100000
+
+var roundTrips = newHistogram("flowbro_round_trip_seconds", "Time between requests and their responses.", []float64{.01, .05, .1, .5, 1, 5, 10, 60}, "pair")
+
+func processPairs(pairsJSON []pairJSON) ([]*pair, error) {
+	pairs := []*pair{}
+	for _, p := range pairsJSON {
+		if len(p.Name) == 0 {
+			return nil, fmt.Errorf("Please define a name for your pair %v", p)
+		}
+		pr, err := newPair(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pair %v. err=%v", p.Name, err)
+		}
+		pairs = append(pairs, pr)
+	}
+	return pairs, nil
+}
+
+func newPair(p pairJSON) (*pair, error) {
+	if len(p.Request) == 0 || len(p.Response) == 0 || len(p.Correlation) == 0 {
+		return nil, fmt.Errorf("Please define request, response and correlation topics and expression")
+	}
+	request, err := regexp.Compile("^(?:" + p.Request + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid request topic regex %v. err=%v", p.Request, err)
+	}
+	response, err := regexp.Compile("^(?:" + p.Response + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid response topic regex %v. err=%v", p.Response, err)
+	}
+	correlation, err := compileCEL(p.Correlation)
+	if err != nil {
+		return nil, err
+	}
+	responseCorrelation := correlation
+	if len(p.ResponseCorrelation) > 0 {
+		if responseCorrelation, err = compileCEL(p.ResponseCorrelation); err != nil {
+			return nil, err
+		}
+	}
+	timeout := time.Duration(p.TimeoutSeconds * float64(time.Second))
+	if timeout <= 0 {
+		timeout = defaultPairTimeout
+	}
+	return &pair{
+		name:                p.Name,
+		request:             request,
+		response:            response,
+		correlation:         correlation,
+		responseCorrelation: responseCorrelation,
+		timeout:             timeout,
+		componentId:         p.ComponentId,
+		open:                map[string]openRequest{},
+	}, nil
+}
+
+// match returns the open request m responds to, if any, and starts waiting
+// for a response if m is a request.
+func (p *pair) match(m message, now time.Time) (openRequest, bool) {
+	var r openRequest
+	matched := false
+	if p.response.MatchString(m.Topic) {
+		if id, err := p.responseCorrelation.eval(m); err == nil {
+			if r, matched = p.open[fmt.Sprint(id)]; matched {
+				delete(p.open, fmt.Sprint(id))
+			}
+		}
+	}
+	if p.request.MatchString(m.Topic) && len(p.open) < pairMaxOpen {
+		if id, err := p.correlation.eval(m); err == nil {
+			p.open[fmt.Sprint(id)] = openRequest{id: fmt.Sprint(id), m: m, seen: now}
+		}
+	}
+	return r, matched
+}
+
+// expire returns, and forgets, the requests that didn't get a response
+// within the timeout.
+func (p *pair) expire(now time.Time) []openRequest {
+	expired := []openRequest{}
+	for id, r := range p.open {
+		if now.Sub(r.seen) >= p.timeout {
+			delete(p.open, id)
+			expired = append(expired, r)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].seen.Before(expired[j].seen) })
+	return expired
+}
+
+// matchPairs returns a paired event for every request m answers.
+func matchPairs(pairs []*pair, m message, now time.Time) []event {
+	events := []event{}
+	for _, p := range pairs {
+		if r, ok := p.match(m, now); ok {
+			events = append(events, p.paired(r.m, m))
+		}
+	}
+	return events
+}
+
+func (p *pair) paired(request, response message) event {
+	latency := response.Timestamp.Sub(request.Timestamp)
+	latencyMs := float64(latency) / float64(time.Millisecond)
+	roundTrips.observe(latency.Seconds(), p.name)
+	return event{
+		EventType: "paired",
+		SourceId:  p.componentId,
+		Text:      fmt.Sprintf("Pair [%v]: response on topic %v arrived %v after its request on topic %v.", p.name, response.Topic, latency, request.Topic),
+		JSON:      []map[string]interface{}{request.Value, response.Value},
+		LatencyMs: &latencyMs,
+		Cluster:   response.Cluster,
+	}
+}
+
+// expirePairs returns a pairTimeout event for every request that didn't get
+// a response within its pair's timeout.
+func expirePairs(pairs []*pair, now time.Time) []event {
+	events := []event{}
+	for _, p := range pairs {
+		for _, r := range p.expire(now) {
+			events = append(events, event{
+				EventType: "pairTimeout",
+				SourceId:  p.componentId,
+				Text:      fmt.Sprintf("Pair [%v]: request %v on topic %v got no response within %v.", p.name, r.id, r.m.Topic, p.timeout),
+				JSON:      []map[string]interface{}{r.m.Value},
+				Color:     "error",
+				Highlight: true,
+				Cluster:   r.m.Cluster,
+			})
+		}
+	}
+	return events
+}
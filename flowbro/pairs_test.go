@@ -0,0 +1,55 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPairs(t *testing.T) {
+	pairs, err := processPairs([]pairJSON{{Name: "payments", Request: "payment-requests", Response: "payment-responses", Correlation: "value.id", ResponseCorrelation: "value.requestId", TimeoutSeconds: 10}})
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	request := func(id string) message {
+		return message{Topic: "payment-requests", Value: newValueFrom(`{"id":"` + id + `"}`), Timestamp: start}
+	}
+	if events := matchPairs(pairs, request("a"), start); len(events) != 0 {
+		t.Errorf("expected no events for a request but got %+v", events)
+	}
+	matchPairs(pairs, request("b"), start.Add(5*time.Second))
+
+	response := message{Topic: "payment-responses", Value: newValueFrom(`{"requestId":"a"}`), Timestamp: start.Add(1500 * time.Millisecond)}
+	events := matchPairs(pairs, response, start.Add(2*time.Second))
+	if len(events) != 1 || events[0].EventType != "paired" || *events[0].LatencyMs != 1500 || len(events[0].JSON) != 2 {
+		t.Errorf("expected a paired event with a 1500ms latency but got %+v", events)
+	}
+	if events := matchPairs(pairs, response, start.Add(3*time.Second)); len(events) != 0 {
+		t.Errorf("expected a response to only pair once but got %+v", events)
+	}
+
+	if events := expirePairs(pairs, start.Add(9*time.Second)); len(events) != 0 {
+		t.Errorf("expected no timeouts yet but got %+v", events)
+	}
+	events = expirePairs(pairs, start.Add(15*time.Second))
+	if len(events) != 1 || events[0].EventType != "pairTimeout" || !events[0].Highlight {
+		t.Errorf("expected request b to time out but got %+v", events)
+	}
+	if len(pairs[0].open) != 0 {
+		t.Errorf("expected timed out requests to be forgotten")
+	}
+}
+
+func TestInvalidPairs(t *testing.T) {
+	configs := [][]pairJSON{
+		{{Name: "p", Request: "a", Response: "b"}},
+		{{Name: "p", Request: "(", Response: "b", Correlation: "key"}},
+		{{Name: "p", Request: "a", Response: "b", Correlation: "value.id =="}},
+	}
+	for i, c := range configs {
+		if _, err := processPairs(c); err == nil {
+			t.Errorf("on config %v: expected processPairs to fail", i)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Parquet files are written with a single row group holding one uncompressed,
+// PLAIN encoded data page per column, which every Parquet reader supports.
+// Only the parts of parquet.thrift needed for that are encoded below.
+const (
+	parquetBoolean   = 0
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+
+	parquetPlain = 0
+	parquetRLE   = 3
+)
+
+// parquetColumn holds the values of a column, with nil for missing values
+// of optional columns.
+type parquetColumn struct {
+	name      string
+	kind      int32
+	converted int32 // -1 if none
+	optional  bool
+	values    []interface{}
+}
+
+// parquetColumns lays out recorded messages as columns: what's known about
+// every message first, then a column per top level field of their values,
+// typed by the JSON they hold. Fields holding objects, lists or mixed types
+// are written as JSON text.
+func parquetColumns(ms []message, received []time.Time) []*parquetColumn {
+	columns := []*parquetColumn{
+		{name: "received", kind: parquetInt64, converted: parquetTimestampMillis},
+		{name: "timestamp", kind: parquetInt64, converted: parquetTimestampMillis},
+		{name: "cluster", kind: parquetByteArray, converted: parquetUTF8},
+		{name: "topic", kind: parquetByteArray, converted: parquetUTF8},
+		{name: "partition", kind: parquetInt32, converted: -1},
+		{name: "offset", kind: parquetInt64, converted: -1},
+		{name: "key", kind: parquetByteArray, converted: parquetUTF8},
+	}
+	for i, m := range ms {
+		columns[0].values = append(columns[0].values, millis(received[i]))
+		columns[1].values = append(columns[1].values, millis(m.Timestamp))
+		columns[2].values = append(columns[2].values, m.Cluster)
+		columns[3].values = append(columns[3].values, m.Topic)
+		columns[4].values = append(columns[4].values, m.Partition)
+		columns[5].values = append(columns[5].values, m.Offset)
+		columns[6].values = append(columns[6].values, m.Key)
+	}
+
+	kinds := map[string]int32{}
+	for _, m := range ms {
+		for f, v := range m.Value {
+			kind := int32(-1)
+			switch v.(type) {
+			case nil:
+				continue
+			case float64:
+				kind = parquetDouble
+			case bool:
+				kind = parquetBoolean
+			default:
+				kind = parquetByteArray
+			}
+			if k, ok := kinds[f]; ok && k != kind {
+				kind = parquetByteArray
+			}
+			kinds[f] = kind
+		}
+	}
+	fields := []string{}
+	for f := range kinds {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+
+	for _, f := range fields {
+		c := &parquetColumn{name: "value_" + f, kind: kinds[f], converted: -1, optional: true}
+		if c.kind == parquetByteArray {
+			c.converted = parquetUTF8
+		}
+		for _, m := range ms {
+			c.values = append(c.values, parquetValue(m.Value[f], c.kind))
+		}
+		columns = append(columns, c)
+	}
+	return columns
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func parquetValue(v interface{}, kind int32) interface{} {
+	if v == nil || kind != parquetByteArray {
+		return v
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	byt, _ := json.Marshal(v)
+	return string(byt)
+}
+
+// writeParquet writes the columns, which all hold rows values, as a Parquet
+// file.
+func writeParquet(w io.Writer, columns []*parquetColumn, rows int) error {
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+
+	chunks := [][]byte{}
+	total := int64(0)
+	for _, c := range columns {
+		offset := int64(file.Len())
+		page := c.page()
+		header := compactStruct(
+			compactI32(1, 0), // DATA_PAGE
+			compactI32(2, int32(len(page))),
+			compactI32(3, int32(len(page))),
+			compactField(5, compactStructType, compactStruct(
+				compactI32(1, int32(rows)),
+				compactI32(2, parquetPlain),
+				compactI32(3, parquetRLE),
+				compactI32(4, parquetRLE),
+			)),
+		)
+		file.Write(header)
+		file.Write(page)
+		size := int64(len(header) + len(page))
+		total += size
+
+		chunks = append(chunks, compactStruct(
+			compactI64(2, offset),
+			compactField(3, compactStructType, compactStruct(
+				compactI32(1, c.kind),
+				compactField(2, compactListType, compactList(compactI32Type, compactZigzag(parquetPlain), compactZigzag(parquetRLE))),
+				compactField(3, compactListType, compactList(compactBinaryType, compactBinary([]byte(c.name)))),
+				compactI32(4, 0), // UNCOMPRESSED
+				compactI64(5, int64(rows)),
+				compactI64(6, size),
+				compactI64(7, size),
+				compactI64(9, offset),
+			)),
+		))
+	}
+
+	schema := [][]byte{compactStruct(
+		compactField(4, compactBinaryType, compactBinary([]byte("flowbro"))),
+		compactI32(5, int32(len(columns))),
+	)}
+	for _, c := range columns {
+		repetition := int32(parquetRequired)
+		if c.optional {
+			repetition = parquetOptional
+		}
+		fields := [][]byte{
+			compactI32(1, c.kind),
+			compactI32(3, repetition),
+			compactField(4, compactBinaryType, compactBinary([]byte(c.name))),
+		}
+		if c.converted >= 0 {
+			fields = append(fields, compactI32(6, c.converted))
+		}
+		schema = append(schema, compactStruct(fields...))
+	}
+
+	footer := compactStruct(
+		compactI32(1, 1),
+		compactField(2, compactListType, compactList(compactStructType, schema...)),
+		compactI64(3, int64(rows)),
+		compactField(4, compactListType, compactList(compactStructType, compactStruct(
+			compactField(1, compactListType, compactList(compactStructType, chunks...)),
+			compactI64(2, total),
+			compactI64(3, int64(rows)),
+		))),
+		compactField(6, compactBinaryType, compactBinary([]byte("flowbro"))),
+	)
+	file.Write(footer)
+	binary.Write(&file, binary.LittleEndian, uint32(len(footer)))
+	file.WriteString("PAR1")
+
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// page encodes the column's definition levels, if it's optional, and its
+// non-nil values.
+func (c *parquetColumn) page() []byte {
+	var b bytes.Buffer
+	if c.optional {
+		levels := make([]bool, len(c.values))
+		for i, v := range c.values {
+			levels[i] = v != nil
+		}
+		packed := bitPackedRun(levels)
+		binary.Write(&b, binary.LittleEndian, uint32(len(packed)))
+		b.Write(packed)
+	}
+
+	bools := []bool{}
+	for _, v := range c.values {
+		switch t := v.(type) {
+		case nil:
+		case bool:
+			bools = append(bools, t)
+		case int32:
+			binary.Write(&b, binary.LittleEndian, t)
+		case int64:
+			binary.Write(&b, binary.LittleEndian, t)
+		case float64:
+			binary.Write(&b, binary.LittleEndian, math.Float64bits(t))
+		case string:
+			binary.Write(&b, binary.LittleEndian, uint32(len(t)))
+			b.WriteString(t)
+		}
+	}
+	if c.kind == parquetBoolean {
+		b.Write(packBits(bools))
+	}
+	return b.Bytes()
+}
+
+// bitPackedRun encodes levels of bit width 1 as a single bit-packed run of
+// the RLE/bit-packing hybrid encoding.
+func bitPackedRun(levels []bool) []byte {
+	groups := (len(levels) + 7) / 8
+	return append(compactVarint(uint64(groups<<1|1)), packBits(levels)...)
+}
+
+func packBits(bits []bool) []byte {
+	b := make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		if bit {
+			b[i/8] |= 1 << uint(i%8)
+		}
+	}
+	return b
+}
+
+// Thrift compact protocol.
+const (
+	compactI32Type    = 5
+	compactI64Type    = 6
+	compactBinaryType = 8
+	compactListType   = 9
+	compactStructType = 12
+)
+
+func compactVarint(v uint64) []byte {
+	b := []byte{}
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func compactZigzag(v int64) []byte {
+	return compactVarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func compactBinary(b []byte) []byte {
+	return append(compactVarint(uint64(len(b))), b...)
+}
+
+// compactField is a field of a struct, prefixed with its id and type; ids
+// are turned into deltas by compactStruct.
+func compactField(id int16, kind byte, value []byte) []byte {
+	return append([]byte{byte(id), kind}, value...)
+}
+
+func compactI32(id int16, v int32) []byte {
+	return compactField(id, compactI32Type, compactZigzag(int64(v)))
+}
+
+func compactI64(id int16, v int64) []byte {
+	return compactField(id, compactI64Type, compactZigzag(v))
+}
+
+// compactStruct writes fields, given in increasing id order, followed by a
+// stop byte.
+func compactStruct(fields ...[]byte) []byte {
+	b, last := []byte{}, int16(0)
+	for _, f := range fields {
+		id, kind, value := int16(f[0]), f[1], f[2:]
+		if delta := id - last; delta > 0 && delta <= 15 {
+			b = append(b, byte(delta)<<4|kind)
+		} else {
+			b = append(append(b, kind), compactZigzag(int64(id))...)
+		}
+		b = append(b, value...)
+		last = id
+	}
+	return append(b, 0)
+}
+
+func compactList(kind byte, elements ...[]byte) []byte {
+	var b []byte
+	if len(elements) < 15 {
+		b = []byte{byte(len(elements))<<4 | kind}
+	} else {
+		b = append([]byte{0xf0 | kind}, compactVarint(uint64(len(elements)))...)
+	}
+	for _, e := range elements {
+		b = append(b, e...)
+	}
+	return b
+}
+
+// writeRecordingParquet writes the recorded messages received between from
+// and to, and matching filter, as a Parquet file.
+func writeRecordingParquet(w io.Writer, dataDir, name string, from, to *time.Time, filter *celProgram) error {
+	ms, received := []message{}, []time.Time{}
+	err := eachRecorded(dataDir, name, from, to, func(r recordedMessage) error {
+		m, err := r.message()
+		if err != nil {
+			return err
+		}
+		if filter != nil {
+			if keep, err := filter.match(m); err != nil || !keep {
+				return nil
+			}
+		}
+		ms, received = append(ms, m), append(received, r.Received)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return writeParquet(w, parquetColumns(ms, received), len(ms))
+}
+
+func (f *flowbro) exportParquetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+		return
+	}
+	q := r.URL.Query()
+	from, err := parseTimeParam(r, "from")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	to, err := parseTimeParam(r, "to")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	var filter *celProgram
+	if len(q.Get("filter")) > 0 {
+		if filter, err = compileCEL(q.Get("filter")); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid filter. err=%v", err))
+			return
+		}
+	}
+	name := q.Get("recording")
+	if err := mayReadRecording(r, f.dataDir, name); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	var b bytes.Buffer
+	if err := writeRecordingParquet(&b, f.dataDir, name, from, to, filter); err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apache.parquet")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".parquet"))
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,64 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompactProtocol(t *testing.T) {
+	tests := []struct {
+		name     string
+		actual   []byte
+		expected []byte
+	}{
+		{name: "small field deltas", actual: compactStruct(compactI32(1, 1), compactI32(3, -2)), expected: []byte{0x15, 0x02, 0x25, 0x03, 0x00}},
+		{name: "large field delta", actual: compactStruct(compactI64(20, 300)), expected: []byte{0x06, 0x28, 0xd8, 0x04, 0x00}},
+		{name: "binary field", actual: compactStruct(compactField(4, compactBinaryType, compactBinary([]byte("id")))), expected: []byte{0x48, 0x02, 'i', 'd', 0x00}},
+		{name: "short list", actual: compactList(compactI32Type, compactZigzag(0), compactZigzag(3)), expected: []byte{0x25, 0x00, 0x06}},
+	}
+	for _, ts := range tests {
+		if !bytes.Equal(ts.actual, ts.expected) {
+			t.Errorf("on '%v': expected %x but got %x", ts.name, ts.expected, ts.actual)
+		}
+	}
+}
+
+func TestParquetColumnsAreTypedByTheirValues(t *testing.T) {
+	at := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := []message{
+		{Topic: "orders", Offset: 1, Value: newValueFrom(`{"total":10,"paid":true,"items":[1],"mixed":1}`), Timestamp: at},
+		{Topic: "orders", Offset: 2, Value: newValueFrom(`{"total":2.5,"mixed":"x"}`), Timestamp: at},
+	}
+	columns := parquetColumns(ms, []time.Time{at, at})
+
+	expected := map[string][]interface{}{
+		"value_total": {float64(10), 2.5},
+		"value_paid":  {true, nil},
+		"value_items": {"[1]", nil},
+		"value_mixed": {"1", "x"},
+		"offset":      {int64(1), int64(2)},
+	}
+	for _, c := range columns {
+		if values, ok := expected[c.name]; ok && !reflect.DeepEqual(c.values, values) {
+			t.Errorf("on '%v': expected %v but got %v", c.name, values, c.values)
+		}
+	}
+
+	var b bytes.Buffer
+	if err := writeParquet(&b, columns, len(ms)); err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	file := b.Bytes()
+	footer := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	if string(file[:4]) != "PAR1" || string(file[len(file)-4:]) != "PAR1" || footer <= 0 || footer > len(file)-12 {
+		t.Errorf("expected a framed Parquet file but got %x", file)
+	}
+
+	paid := &parquetColumn{kind: parquetBoolean, optional: true, values: []interface{}{true, nil, false, true}}
+	if page := paid.page(); !bytes.Equal(page, []byte{2, 0, 0, 0, 0x03, 0x0d, 0x05}) {
+		t.Errorf("expected definition levels 1101 and values 101 but got %x", page)
+	}
+}
@@ -0,0 +1,121 @@
+package flowbro
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// partitionsOf returns how many partitions topic has on brokers.
+var partitionsOf = func(brokers []string, topic string) (int32, error) {
+	client, err := sarama.NewClient(brokers, sarama.NewConfig())
+	if err != nil {
+		return 0, fmt.Errorf("Error creating client for %v. err=%v", strings.Join(brokers, ","), err)
+	}
+	defer client.Close()
+	ps, err := client.Partitions(topic)
+	if err != nil {
+		return 0, fmt.Errorf("Error getting partitions of topic %v. err=%v", topic, err)
+	}
+	return int32(len(ps)), nil
+}
+
+// keyPartitions tells which partition key maps to under the default
+// partitioner of flowbro's (and most Go) producers, FNV-1a, and under
+// murmur2, the default of Java producers and an option of librdkafka's.
+func keyPartitions(topic, key string, partitions int32) (map[string]int32, error) {
+	p, err := sarama.NewHashPartitioner(topic).Partition(&sarama.ProducerMessage{Topic: topic, Key: sarama.StringEncoder(key)}, partitions)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int32{
+		"default": p,
+		"murmur2": int32(murmur2([]byte(key))&0x7fffffff) % partitions,
+	}, nil
+}
+
+// murmur2 is the hash of Kafka's Java client, ported from its Utils.murmur2.
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// partitionForKeyHandler serves
+// /api/partition-for-key?topic=orders&key=order-42, with the topic's
+// partition count either given as partitions or looked up on brokers.
+func partitionForKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+		return
+	}
+	q := r.URL.Query()
+	topic, key := q.Get("topic"), q.Get("key")
+	if _, ok := q["key"]; !ok || len(topic) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Please define the topic and key, e.g. /api/partition-for-key?topic=orders&key=order-42&partitions=12"))
+		return
+	}
+
+	var partitions int32
+	switch {
+	case len(q.Get("partitions")) > 0:
+		n, err := strconv.Atoi(q.Get("partitions"))
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid partitions %v", q.Get("partitions")))
+			return
+		}
+		partitions = int32(n)
+	case len(q.Get("brokers")) > 0:
+		n, err := partitionsOf(strings.Split(q.Get("brokers"), ","), topic)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		if n == 0 {
+			writeError(w, http.StatusNotFound, fmt.Errorf("Topic %v has no partitions", topic))
+			return
+		}
+		partitions = n
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Please define either the topic's partitions or the brokers to look them up on"))
+		return
+	}
+
+	ps, err := keyPartitions(topic, key, partitions)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"topic": topic, "key": key, "partitions": partitions, "partition": ps})
+}
@@ -0,0 +1,72 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestMurmur2MatchesTheJavaClient(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected int32
+	}{
+		{"21", -973932308},
+		{"foobar", -790332482},
+		{"a-little-bit-long-string", -985981536},
+		{"a-little-bit-longer-string", -1486304829},
+		{"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", -58897971},
+		{"abc", 479470107},
+	}
+
+	for _, ts := range tests {
+		if actual := int32(murmur2([]byte(ts.key))); actual != ts.expected {
+			t.Errorf("on '%v': expected %v but got %v", ts.key, ts.expected, actual)
+		}
+	}
+}
+
+func TestPartitionForKeyHandler(t *testing.T) {
+	defer func(lookup func([]string, string) (int32, error)) { partitionsOf = lookup }(partitionsOf)
+	partitionsOf = func(brokers []string, topic string) (int32, error) {
+		if topic != "orders" {
+			return 0, fmt.Errorf("Error getting partitions of topic %v", topic)
+		}
+		return 10, nil
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		expected string
+	}{
+		{name: "given partitions", path: "/api/partition-for-key?topic=orders&key=order-42&partitions=12", status: http.StatusOK, expected: `{"topic":"orders","key":"order-42","partitions":12,"partition":{"default":8,"murmur2":0}}`},
+		{name: "looked up partitions", path: "/api/partition-for-key?topic=orders&key=foobar&brokers=kafka:9092", status: http.StatusOK, expected: `{"topic":"orders","key":"foobar","partitions":10,"partition":{"default":6,"murmur2":6}}`},
+		{name: "empty key", path: "/api/partition-for-key?topic=orders&key=&partitions=3", status: http.StatusOK},
+		{name: "unknown topic", path: "/api/partition-for-key?topic=payments&key=1&brokers=kafka:9092", status: http.StatusBadGateway},
+		{name: "missing key", path: "/api/partition-for-key?topic=orders&partitions=3", status: http.StatusBadRequest},
+		{name: "missing partitions", path: "/api/partition-for-key?topic=orders&key=1", status: http.StatusBadRequest},
+		{name: "invalid partitions", path: "/api/partition-for-key?topic=orders&key=1&partitions=0", status: http.StatusBadRequest},
+	}
+	for _, ts := range tests {
+		w := httptest.NewRecorder()
+		partitionForKeyHandler(w, httptest.NewRequest("GET", ts.path, nil))
+		if w.Code != ts.status {
+			t.Errorf("on '%v': expected status %v but got %v", ts.name, ts.status, w.Code)
+			continue
+		}
+		if len(ts.expected) == 0 {
+			continue
+		}
+		var actual, expected interface{}
+		json.Unmarshal(w.Body.Bytes(), &actual)
+		json.Unmarshal([]byte(ts.expected), &expected)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("on '%v': expected %v but got %v", ts.name, ts.expected, w.Body.String())
+		}
+	}
+}
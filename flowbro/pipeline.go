@@ -0,0 +1,214 @@
+package flowbro
+
+import (
+	"time"
+)
+
+// pipeline is what process puts every consumed message through, stage by
+// stage: offsets drops it by its offset, decode decodes and redacts it,
+// admit drops what replays filter out and mirror duplicates, check
+// annotates it, and keep runs scripts and the filter. Only what they keep is
+// then fanned out to recordings, search, watches, queries, pairs,
+// aggregations and discovery, and delivered to sinks, webhooks and the
+// rules.
+type pipeline struct {
+	ws         conn
+	config     *config
+	window     *replayWindow
+	replays    *replayFilter
+	throughput *throughput
+	discovery  *discovery
+	watching   watches
+	querying   queries
+	notices    []event
+}
+
+// run puts cMsg through every stage, returning the messages left for the
+// rules and the notices raised on the way.
+func (p *pipeline) run(cMsg *SourceMessage, now time.Time) ([]message, []event) {
+	p.notices = nil
+	p.account(cMsg)
+	if !p.offsets(cMsg) {
+		return nil, p.notices
+	}
+	m, ok := p.decode(cMsg)
+	if !ok {
+		return nil, p.notices
+	}
+	if m, ok = p.admit(cMsg, m, now); !ok {
+		return nil, p.notices
+	}
+	m = p.check(cMsg, m)
+	if m, ok = p.keep(cMsg, m); !ok {
+		return nil, p.notices
+	}
+	p.fanOut(m, now)
+	return p.deliver(m, now), p.notices
+}
+
+func (p *pipeline) account(cMsg *SourceMessage) {
+	consumedMessages.inc(cMsg.cluster, cMsg.Topic)
+	p.config.state.onMessage(cMsg)
+	if p.config.session != nil {
+		p.config.session.onMessage(cMsg.cluster, cMsg.Topic, cMsg.Partition, cMsg.Offset)
+	}
+	if p.throughput != nil {
+		p.throughput.onMessage(cMsg.cluster, cMsg.Topic, cMsg.Partition, cMsg.Offset, len(cMsg.Key)+len(cMsg.Value))
+	}
+}
+
+// offsets tells whether the message at cMsg's offset is wanted at all,
+// given gaps, the replay window and the catch-up.
+func (p *pipeline) offsets(cMsg *SourceMessage) bool {
+	config := p.config
+	if config.gaps != nil {
+		if e, ok := config.gaps.onMessage(cMsg.cluster, cMsg.view, cMsg.Topic, cMsg.Partition, cMsg.Offset); ok {
+			p.notices = append(p.notices, e)
+		}
+	}
+	if p.window != nil {
+		keep := p.window.keep(cMsg.Topic, cMsg.Partition, cMsg.Offset)
+		if e, ok := p.window.end(); ok {
+			p.notices = append(p.notices, e)
+		}
+		if !keep {
+			config.state.drop("window", 1)
+			return false
+		}
+	}
+	if config.catchUp != nil && !config.catchUp.onMessage(cMsg.Topic, cMsg.Partition, cMsg.Offset) {
+		config.state.drop("catchUp", 1)
+		return false
+	}
+	return true
+}
+
+// decode decodes and redacts cMsg, before anything else sees its value.
+// Messages of tables are kept in them instead of going on.
+func (p *pipeline) decode(cMsg *SourceMessage) (message, bool) {
+	config := p.config
+	if config.tables[cMsg.Topic] && cMsg.Value == nil {
+		if _, key, err := decodeKey(cMsg.Key, config.decoding(cMsg.Topic)); err == nil {
+			tables.remove(tableKeyOf(config.board, cMsg.cluster, cMsg.Topic, config.redactions), key)
+		}
+		return message{}, false
+	}
+	m, err := newMessage(*cMsg, config.decoding(cMsg.Topic))
+	if err != nil {
+		p.notices = append(p.notices, config.deadLetters.reject(cMsg, err, redacts(config.redactions, cMsg.Topic))...)
+		config.state.drop("undecodable", 1)
+		return m, false
+	}
+	m.Cluster, m.Brokers, m.View = cMsg.cluster, cMsg.brokers, cMsg.view
+	m = redact(config.redactions, m)
+	if config.tables[cMsg.Topic] {
+		tables.upsert(tableKeyOf(config.board, m.Cluster, m.Topic, config.redactions), m)
+		return m, false
+	}
+	if m.Timestamp.UnixNano() <= 0 {
+		m.Timestamp = time.Now()
+	} else {
+		latency := time.Since(m.Timestamp)
+		latencies.observe(latency.Seconds(), m.Topic)
+		if p.throughput != nil {
+			p.throughput.onLatency(m.Cluster, m.Topic, latency)
+		}
+	}
+	return m, true
+}
+
+// admit drops the replayed messages the replay filter leaves out and the
+// duplicates of mirrored topics.
+func (p *pipeline) admit(cMsg *SourceMessage, m message, now time.Time) (message, bool) {
+	if p.replays != nil {
+		keep, err := p.replays.keep(cMsg, m)
+		if err != nil {
+			p.notices = append(p.notices, event{EventType: "log", Text: err.Error(), Color: "error"})
+		}
+		if p.replays.done() {
+			p.replays.close()
+			p.replays = nil
+			p.notices = append(p.notices, event{EventType: "log", Text: "Finished the filtered replay.", Color: "happy"})
+		}
+		if !keep {
+			p.config.state.drop("replayFilter", 1)
+			return m, false
+		}
+	}
+	if p.config.mirrors != nil {
+		var keep bool
+		if m, keep = p.config.mirrors.dedupe(m, now); !keep {
+			p.config.state.drop("duplicate", 1)
+			return m, false
+		}
+	}
+	return m, true
+}
+
+// check looks for schema drift and disorder, and annotates m with its retry
+// group and diff.
+func (p *pipeline) check(cMsg *SourceMessage, m message) message {
+	config := p.config
+	if config.schemaDrift != nil {
+		p.notices = append(p.notices, config.schemaDrift.check(m, valueSchemaID(cMsg.Value, config.decoding(cMsg.Topic)))...)
+	}
+	var disorder []event
+	m, disorder = checkOrder(config.orderings, m)
+	p.notices = append(p.notices, disorder...)
+	if config.retryTopics != nil {
+		m = config.retryTopics.group(m)
+	}
+	return applyDiffs(config.diffs, m)
+}
+
+// keep runs the scripts and the filter, which decide what goes on.
+func (p *pipeline) keep(cMsg *SourceMessage, m message) (message, bool) {
+	config := p.config
+	m, keep := runScripts(config.scripts, m)
+	if !keep {
+		config.state.drop("script", 1)
+		return m, false
+	}
+	if config.filter != nil {
+		keep, err := config.filter.match(m)
+		if err != nil {
+			p.notices = append(p.notices, config.deadLetters.reject(cMsg, err, redacts(config.redactions, cMsg.Topic))...)
+		}
+		if err != nil || !keep {
+			config.state.drop("filter", 1)
+			return m, false
+		}
+	}
+	return m, true
+}
+
+// fanOut hands m to everything that observes the kept messages.
+func (p *pipeline) fanOut(m message, now time.Time) {
+	config := p.config
+	if config.recording != nil {
+		if err := config.recording.record(m, now); err != nil {
+			p.notices = append(p.notices, event{EventType: "log", Text: err.Error(), Color: "error"})
+		}
+	}
+	config.search.add(m, config.board, now)
+	p.notices = append(p.notices, p.watching.match(m)...)
+	p.notices = append(p.notices, p.querying.onMessage(m, now)...)
+	p.notices = append(p.notices, matchPairs(config.pairs, m, now)...)
+	aggregateMessage(config.aggregations, m, now)
+	if p.discovery != nil {
+		p.discovery.onMessage(m)
+	}
+}
+
+// deliver transforms m, forwards it to sinks and webhooks, and returns it
+// along with what it joined with, for the rules.
+func (p *pipeline) deliver(m message, now time.Time) []message {
+	config := p.config
+	m, err := applyTransforms(config.transforms, m)
+	if err != nil {
+		sendError(err.Error(), p.ws)
+	}
+	p.notices = append(p.notices, forwardToSinks(config.sinks, m)...)
+	p.notices = append(p.notices, forwardToWebhooks(config.webhooks, m)...)
+	return append([]message{m}, applyJoins(config.joins, m, now)...)
+}
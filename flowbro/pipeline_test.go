@@ -0,0 +1,34 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPipelineOnlyFansOutKeptAndRedactedMessages(t *testing.T) {
+	c, err := processConfig(&configJSON{
+		Kafka:      kafka{Filter: `value.id == 2`},
+		Redactions: []redactionJSON{{Topic: "users", Path: "$.email", Strategy: "drop"}},
+	})
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	c.search = newSearchIndex(10)
+	p := &pipeline{config: c}
+
+	kept := 0
+	for _, v := range []string{`{"id": 1, "email": "jane@example.com"}`, `{"id": 2, "email": "john@example.com"}`} {
+		ms, _ := p.run(&SourceMessage{Topic: "users", Value: []byte(v)}, time.Now())
+		kept += len(ms)
+	}
+	if kept != 1 {
+		t.Errorf("expected only the message matching the filter to go on, but got %v", kept)
+	}
+	all := func(string) bool { return true }
+	if hits, _ := c.search.search("users", 0, 10, all); len(hits) != 1 {
+		t.Errorf("expected only the message matching the filter to be searchable, but got %v", hits)
+	}
+	if hits, _ := c.search.search("john", 0, 10, all); len(hits) != 0 {
+		t.Errorf("expected redacted values not to be searchable, but got %v", hits)
+	}
+}
@@ -0,0 +1,534 @@
+package flowbro
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// protoFile is the subset of a parsed .proto schema needed to name and type
+// the fields of a protobuf payload. Unknown fields, and fields of messages
+// defined in imported files, are still decoded but keyed by field number.
+type protoFile struct {
+	pkg      string
+	messages []*protoMessage
+	byName   map[string]*protoMessage
+	enums    map[string]map[int64]string
+}
+
+type protoMessage struct {
+	name     string
+	fields   map[int64]protoField
+	messages []*protoMessage
+}
+
+type protoField struct {
+	name     string
+	typ      string
+	repeated bool
+	mapValue string
+}
+
+type protoParser struct {
+	tokens []string
+	i      int
+	file   *protoFile
+}
+
+func parseProtoSchema(src string) (*protoFile, error) {
+	p := &protoParser{
+		tokens: protoTokens(src),
+		file:   &protoFile{byName: map[string]*protoMessage{}, enums: map[string]map[int64]string{}},
+	}
+	for p.i < len(p.tokens) {
+		switch p.peek() {
+		case "message":
+			m, err := p.message("")
+			if err != nil {
+				return nil, err
+			}
+			p.file.messages = append(p.file.messages, m)
+		case "enum":
+			if err := p.enum(""); err != nil {
+				return nil, err
+			}
+		case "package":
+			p.next()
+			p.file.pkg = p.next()
+			p.skipStatement()
+		case "service":
+			p.skipBlock()
+		default:
+			p.skipStatement()
+		}
+	}
+	return p.file, nil
+}
+
+func protoTokens(src string) []string {
+	tokens := []string{}
+	rs := []rune(src)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '/':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			for i+1 < len(rs) && !(rs[i] == '*' && rs[i+1] == '/') {
+				i++
+			}
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			for j < len(rs) && rs[j] != r {
+				if rs[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(rs) {
+				j = len(rs) - 1
+			}
+			tokens = append(tokens, string(rs[i:j+1]))
+			i = j
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' || r == '-':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_' || rs[j] == '.' || rs[j] == '-') {
+				j++
+			}
+			tokens = append(tokens, string(rs[i:j]))
+			i = j - 1
+		default:
+			tokens = append(tokens, string(r))
+		}
+	}
+	return tokens
+}
+
+func (p *protoParser) peek() string {
+	if p.i < len(p.tokens) {
+		return p.tokens[p.i]
+	}
+	return ""
+}
+
+func (p *protoParser) next() string {
+	t := p.peek()
+	p.i++
+	return t
+}
+
+func (p *protoParser) skipStatement() {
+	for p.i < len(p.tokens) {
+		switch p.next() {
+		case ";":
+			return
+		case "{":
+			p.i--
+			p.skipBlock()
+			return
+		}
+	}
+}
+
+func (p *protoParser) skipBlock() {
+	for p.i < len(p.tokens) && p.peek() != "{" {
+		p.i++
+	}
+	depth := 0
+	for p.i < len(p.tokens) {
+		switch p.next() {
+		case "{":
+			depth++
+		case "}":
+			if depth--; depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+func (p *protoParser) message(scope string) (*protoMessage, error) {
+	p.next() // message
+	m := &protoMessage{name: scope + p.next(), fields: map[int64]protoField{}}
+	p.file.byName[m.name] = m
+	if p.next() != "{" {
+		return nil, fmt.Errorf("Invalid protobuf schema: expected { after message %v", m.name)
+	}
+	return m, p.messageBody(m)
+}
+
+func (p *protoParser) messageBody(m *protoMessage) error {
+	for p.i < len(p.tokens) {
+		switch t := p.peek(); t {
+		case "}":
+			p.next()
+			return nil
+		case "message":
+			nested, err := p.message(m.name + ".")
+			if err != nil {
+				return err
+			}
+			m.messages = append(m.messages, nested)
+		case "enum":
+			if err := p.enum(m.name + "."); err != nil {
+				return err
+			}
+		case "oneof":
+			p.next()
+			p.next()
+			p.next() // {
+			if err := p.messageBody(m); err != nil {
+				return err
+			}
+		case "option", "reserved", "extensions", "extend", ";":
+			p.skipStatement()
+		default:
+			if err := p.field(m); err != nil {
+				return err
+			}
+		}
+	}
+	return fmt.Errorf("Invalid protobuf schema: unterminated message %v", m.name)
+}
+
+func (p *protoParser) field(m *protoMessage) error {
+	f := protoField{}
+	switch p.peek() {
+	case "repeated":
+		f.repeated = true
+		p.next()
+	case "optional", "required":
+		p.next()
+	}
+
+	f.typ = p.next()
+	if f.typ == "map" {
+		p.next() // <
+		p.next() // key type
+		p.next() // ,
+		f.mapValue = p.next()
+		p.next() // >
+	}
+	f.name = p.next()
+	if p.next() != "=" {
+		return fmt.Errorf("Invalid protobuf schema: expected = after field %v of %v", f.name, m.name)
+	}
+	n, err := strconv.ParseInt(p.next(), 0, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid protobuf schema: bad number for field %v of %v", f.name, m.name)
+	}
+	p.skipStatement()
+	m.fields[n] = f
+	return nil
+}
+
+func (p *protoParser) enum(scope string) error {
+	p.next() // enum
+	name := scope + p.next()
+	values := map[int64]string{}
+	p.next() // {
+	for p.i < len(p.tokens) && p.peek() != "}" {
+		if p.peek() == "option" || p.peek() == "reserved" {
+			p.skipStatement()
+			continue
+		}
+		symbol := p.next()
+		if p.next() != "=" {
+			return fmt.Errorf("Invalid protobuf schema: bad value in enum %v", name)
+		}
+		if n, err := strconv.ParseInt(p.next(), 0, 64); err == nil {
+			values[n] = symbol
+		}
+		p.skipStatement()
+	}
+	p.next() // }
+	p.file.enums[name] = values
+	return nil
+}
+
+// resolve finds a message or enum type referenced from within scope,
+// following protobuf's innermost-scope-first lookup.
+func (f *protoFile) resolve(typ, scope string) (*protoMessage, map[int64]string) {
+	typ = strings.TrimPrefix(strings.TrimPrefix(typ, "."), f.pkg+".")
+	for {
+		name := typ
+		if len(scope) > 0 {
+			name = scope + "." + typ
+		}
+		if m, ok := f.byName[name]; ok {
+			return m, nil
+		}
+		if e, ok := f.enums[name]; ok {
+			return nil, e
+		}
+		if len(scope) == 0 {
+			return nil, nil
+		}
+		if i := strings.LastIndex(scope, "."); i >= 0 {
+			scope = scope[:i]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+// messageAt returns the message selected by Confluent's message indexes.
+func (f *protoFile) messageAt(indexes []int64) (*protoMessage, error) {
+	ms := f.messages
+	var m *protoMessage
+	for _, i := range indexes {
+		if i < 0 || i >= int64(len(ms)) {
+			return nil, fmt.Errorf("Message index %v not found in protobuf schema", indexes)
+		}
+		m = ms[i]
+		ms = m.messages
+	}
+	if m == nil {
+		return nil, fmt.Errorf("Protobuf schema defines no messages")
+	}
+	return m, nil
+}
+
+// confluentProtoIndexes reads the message indexes preceding protobuf
+// payloads in Confluent's wire format: a zigzag varint count followed by that
+// many zigzag varint indexes, with a lone 0 meaning the first message.
+func confluentProtoIndexes(b []byte) ([]int64, []byte, error) {
+	n, l := binary.Varint(b)
+	if l <= 0 {
+		return nil, nil, fmt.Errorf("Invalid protobuf message indexes")
+	}
+	b = b[l:]
+	if n == 0 {
+		return []int64{0}, b, nil
+	}
+	indexes := []int64{}
+	for ; n > 0; n-- {
+		i, l := binary.Varint(b)
+		if l <= 0 {
+			return nil, nil, fmt.Errorf("Invalid protobuf message indexes")
+		}
+		indexes, b = append(indexes, i), b[l:]
+	}
+	return indexes, b, nil
+}
+
+func protoToMap(f *protoFile, m *protoMessage, b []byte) (map[string]interface{}, error) {
+	v, err := protoDecodeMessage(f, m, b, 0)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode protobuf value. err=%v", err)
+	}
+	return v, nil
+}
+
+func protoDecodeMessage(f *protoFile, m *protoMessage, b []byte, depth int) (map[string]interface{}, error) {
+	if depth > 100 {
+		return nil, fmt.Errorf("nesting too deep")
+	}
+	out := map[string]interface{}{}
+	for len(b) > 0 {
+		key, l := binary.Uvarint(b)
+		if l <= 0 {
+			return nil, fmt.Errorf("invalid field key")
+		}
+		b = b[l:]
+		num, wire := int64(key>>3), key&7
+
+		var field protoField
+		known := false
+		if m != nil {
+			field, known = m.fields[num]
+		}
+		name := strconv.FormatInt(num, 10)
+		if known {
+			name = field.name
+		}
+
+		var raw []byte
+		var scalar uint64
+		switch wire {
+		case 0:
+			scalar, l = binary.Uvarint(b)
+			if l <= 0 {
+				return nil, fmt.Errorf("invalid varint in field %v", name)
+			}
+			b = b[l:]
+		case 1:
+			if len(b) < 8 {
+				return nil, fmt.Errorf("truncated field %v", name)
+			}
+			scalar, b = binary.LittleEndian.Uint64(b), b[8:]
+		case 5:
+			if len(b) < 4 {
+				return nil, fmt.Errorf("truncated field %v", name)
+			}
+			scalar, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case 2:
+			n, l := binary.Uvarint(b)
+			if l <= 0 || uint64(len(b)-l) < n {
+				return nil, fmt.Errorf("truncated field %v", name)
+			}
+			raw, b = b[l:l+int(n)], b[l+int(n):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %v in field %v", wire, name)
+		}
+
+		values := []interface{}{}
+		switch {
+		case wire != 2:
+			values = append(values, protoScalar(f, m, field, scalar, wire))
+		case known && field.mapValue != "":
+			entry, err := protoDecodeMessage(f, &protoMessage{fields: map[int64]protoField{
+				1: {name: "key", typ: "string"},
+				2: {name: "value", typ: field.mapValue},
+			}, name: m.name}, raw, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			mp, _ := out[name].(map[string]interface{})
+			if mp == nil {
+				mp = map[string]interface{}{}
+			}
+			mp[fmt.Sprint(entry["key"])] = entry["value"]
+			out[name] = mp
+			continue
+		case known && (field.typ == "string"):
+			values = append(values, string(raw))
+		case known && (field.typ == "bytes"):
+			values = append(values, base64.StdEncoding.EncodeToString(raw))
+		case known && protoPackable(f, m, field.typ):
+			wire := protoPackedWireType(f, m, field.typ)
+			for len(raw) > 0 {
+				var v uint64
+				switch wire {
+				case 0:
+					v, l = binary.Uvarint(raw)
+				case 1:
+					v, l = binary.LittleEndian.Uint64(raw), 8
+				case 5:
+					v, l = uint64(binary.LittleEndian.Uint32(raw)), 4
+				}
+				if l <= 0 || l > len(raw) {
+					return nil, fmt.Errorf("invalid packed field %v", name)
+				}
+				raw = raw[l:]
+				values = append(values, protoScalar(f, m, field, v, wire))
+			}
+		case known:
+			nested, _ := f.resolve(field.typ, m.name)
+			v, err := protoDecodeMessage(f, nested, raw, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		default: // without a schema, guess between text, nested messages and bytes
+			if protoLooksLikeText(raw) {
+				values = append(values, string(raw))
+			} else if v, err := protoDecodeMessage(f, nil, raw, depth+1); err == nil {
+				values = append(values, v)
+			} else {
+				values = append(values, base64.StdEncoding.EncodeToString(raw))
+			}
+		}
+
+		switch {
+		case known && !field.repeated:
+			out[name] = values[len(values)-1]
+		case known:
+			existing, _ := out[name].([]interface{})
+			out[name] = append(existing, values...)
+		default: // unknown fields become arrays once they repeat
+			for _, v := range values {
+				switch existing := out[name].(type) {
+				case nil:
+					out[name] = v
+				case []interface{}:
+					out[name] = append(existing, v)
+				default:
+					out[name] = []interface{}{existing, v}
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+func protoLooksLikeText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// protoPackable tells whether repeated fields of typ may be packed: those of
+// scalars and of enums, which are varints, may.
+func protoPackable(f *protoFile, m *protoMessage, typ string) bool {
+	return protoPackedWireType(f, m, typ) != 2
+}
+
+func protoPackedWireType(f *protoFile, m *protoMessage, typ string) uint64 {
+	if wire := protoWireType(typ); wire != 2 {
+		return wire
+	}
+	if _, enum := f.resolve(typ, m.name); enum != nil {
+		return 0
+	}
+	return 2
+}
+
+func protoWireType(typ string) uint64 {
+	switch typ {
+	case "int32", "int64", "uint32", "uint64", "sint32", "sint64", "bool":
+		return 0
+	case "fixed64", "sfixed64", "double":
+		return 1
+	case "fixed32", "sfixed32", "float":
+		return 5
+	}
+	return 2
+}
+
+func protoScalar(f *protoFile, m *protoMessage, field protoField, v uint64, wire uint64) interface{} {
+	switch field.typ {
+	case "int32":
+		return int64(int32(v))
+	case "int64", "sfixed64":
+		return int64(v)
+	case "uint32", "uint64", "fixed32", "fixed64":
+		return v
+	case "sint32", "sint64":
+		return int64(v>>1) ^ -int64(v&1)
+	case "sfixed32":
+		return int64(int32(v))
+	case "bool":
+		return v != 0
+	case "double":
+		return math.Float64frombits(v)
+	case "float":
+		return float64(math.Float32frombits(uint32(v)))
+	case "":
+		if wire == 0 {
+			return int64(v)
+		}
+		return v
+	}
+	if _, enum := f.resolve(field.typ, m.name); enum != nil {
+		if symbol, ok := enum[int64(v)]; ok {
+			return symbol
+		}
+	}
+	return int64(v)
+}
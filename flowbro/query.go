@@ -0,0 +1,782 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type queryJSON struct {
+	Id  string `json:"id"`
+	SQL string `json:"sql"`
+}
+
+// query is a streaming query in a small SQL dialect, e.g.
+//
+//	SELECT key, value.amount * 2 AS double FROM orders WHERE value.status = 'PAID'
+//	SELECT value.region, COUNT(*), SUM(value.amount) FROM orders GROUP BY value.region WINDOW TUMBLING (SIZE 1 MINUTE)
+//
+// Expressions are CEL, with SQL's AND, OR, NOT, = and <> on top. Queries
+// without aggregates send a queryRow event per matching message; those with
+// COUNT(*), COUNT(expr), COUNT(DISTINCT expr) or SUM(expr) are compiled into
+// aggregations sharing the WHERE filter, GROUP BY and window, and send a
+// queryResult event per closed window, its rows sorted by ORDER BY and cut
+// at LIMIT. With a sliding window, e.g.
+//
+//	SELECT key, COUNT(*) AS n FROM orders GROUP BY key WINDOW SLIDING (SIZE 1 MINUTE) ORDER BY n DESC LIMIT 10
+//
+// the result over the last window is kept up to date instead, and sent
+// whenever it changes.
+type query struct {
+	id      string
+	sql     string
+	topic   string
+	where   *celProgram
+	columns []queryColumn // all of the value if empty
+
+	aggregations []*aggregation // one per aggregate column
+	groupColumn  string         // the name of the GROUP BY column, if selected
+	orderBy      string         // the name of the column to sort results by
+	descending   bool
+	limit        int // of result rows, if positive
+
+	sliding   *slidingWindow
+	refreshed time.Time
+	last      string // the JSON of the rows last sent
+}
+
+type queryColumn struct {
+	name string
+	expr *celProgram
+}
+
+// queries are the queries of a connection, in the order they were added.
+type queries []*query
+
+const maxQueries = 10
+
+// querySQLKeywords start the clauses of a query, in the order they must
+// appear.
+var querySQLKeywords = []string{"SELECT", "FROM", "WHERE", "GROUP BY", "WINDOW", "ORDER BY", "LIMIT"}
+
+var (
+	queryAggregate = regexp.MustCompile(`(?is)^(COUNT|SUM)\s*\((.*)\)$`)
+	queryDistinct  = regexp.MustCompile(`(?is)^DISTINCT\s+(.+)$`)
+	queryAlias     = regexp.MustCompile(`(?is)^(.+?)\s+AS\s+([A-Za-z_][A-Za-z0-9_]*)$`)
+	queryOrderBy   = regexp.MustCompile(`(?is)^(.+?)(?:\s+(ASC|DESC))?$`)
+	queryWindow    = regexp.MustCompile(`(?i)^(?:(TUMBLING|SLIDING)\s*)?\(?\s*(?:SIZE\s+)?(\d+(?:\.\d+)?)\s*(SECOND|SECONDS|MINUTE|MINUTES|HOUR|HOURS)\s*\)?$`)
+	queryTopic     = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// materializedRefresh is how often the results of sliding queries are
+// recomputed.
+const materializedRefresh = time.Second
+
+var queryWindowUnits = map[string]time.Duration{
+	"SECOND": time.Second, "SECONDS": time.Second,
+	"MINUTE": time.Minute, "MINUTES": time.Minute,
+	"HOUR": time.Hour, "HOURS": time.Hour,
+}
+
+func processQueries(queriesJSON []queryJSON) (queries, error) {
+	qs := queries{}
+	for _, q := range queriesJSON {
+		compiled, err := compileQuery(q.Id, q.SQL)
+		if err != nil {
+			return nil, err
+		}
+		if qs.index(q.Id) >= 0 {
+			return nil, fmt.Errorf("Query id %v is used more than once", q.Id)
+		}
+		qs = append(qs, compiled)
+	}
+	return qs, nil
+}
+
+func compileQuery(id, sql string) (*query, error) {
+	if len(id) == 0 {
+		return nil, fmt.Errorf("Please define the id of the query %v", sql)
+	}
+	clauses, err := splitSQL(sql)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid query %v. err=%v", id, err)
+	}
+	q := &query{id: id, sql: sql, topic: strings.Trim(clauses["FROM"], "\"`")}
+	if !queryTopic.MatchString(q.topic) {
+		return nil, fmt.Errorf("Invalid query %v. err=FROM takes a topic name, not %q", id, clauses["FROM"])
+	}
+	if where, ok := clauses["WHERE"]; ok {
+		if q.where, err = compileCEL(sqlToCEL(where)); err != nil {
+			return nil, fmt.Errorf("Invalid WHERE of query %v. err=%v", id, err)
+		}
+	}
+
+	if limit, ok := clauses["LIMIT"]; ok {
+		if q.limit, err = strconv.Atoi(limit); err != nil || q.limit <= 0 {
+			return nil, fmt.Errorf("Invalid query %v. err=LIMIT takes a positive number, not %v", id, limit)
+		}
+	}
+	groupBy, grouped := clauses["GROUP BY"]
+	window, windowed := clauses["WINDOW"]
+	plain, aggregates := []string{}, []aggregationJSON{}
+	names := []string{}
+	for _, item := range splitTopLevel(clauses["SELECT"], ',') {
+		expr, name := item, item
+		if m := queryAlias.FindStringSubmatch(item); m != nil {
+			expr, name = strings.TrimSpace(m[1]), m[2]
+		}
+		names = append(names, name)
+		m := queryAggregate.FindStringSubmatch(expr)
+		if m == nil {
+			plain = append(plain, expr)
+			if expr != "*" {
+				col := queryColumn{name: name}
+				if col.expr, err = compileCEL(sqlToCEL(expr)); err != nil {
+					return nil, fmt.Errorf("Invalid column %v of query %v. err=%v", name, id, err)
+				}
+				q.columns = append(q.columns, col)
+			}
+			continue
+		}
+		a := aggregationJSON{Name: name, Topic: regexp.QuoteMeta(q.topic), Function: strings.ToLower(m[1]), Field: strings.TrimSpace(m[2])}
+		if d := queryDistinct.FindStringSubmatch(a.Field); d != nil {
+			if a.Function != "count" {
+				return nil, fmt.Errorf("Invalid column %v of query %v. err=only COUNT takes DISTINCT", name, id)
+			}
+			a.Function, a.Field = "distinct", strings.TrimSpace(d[1])
+		}
+		switch {
+		case a.Field == "*" && a.Function == "count":
+			a.Field = ""
+		case a.Field == "*":
+			return nil, fmt.Errorf("Invalid column %v of query %v. err=only COUNT takes *", name, id)
+		case a.Function == "count": // of messages with the field
+			a.Function, a.Field = "sum", fmt.Sprintf("has(%v) ? 1 : 0", sqlToCEL(a.Field))
+		default:
+			a.Field = sqlToCEL(a.Field)
+		}
+		aggregates = append(aggregates, a)
+	}
+	for i, name := range names {
+		for _, other := range names[:i] {
+			if name == other {
+				return nil, fmt.Errorf("Invalid query %v. err=column %v is selected more than once; please name them with AS", id, name)
+			}
+		}
+	}
+
+	if len(aggregates) == 0 {
+		if grouped || windowed || q.limit > 0 || len(clauses["ORDER BY"]) > 0 {
+			return nil, fmt.Errorf("Invalid query %v. err=GROUP BY, WINDOW, ORDER BY and LIMIT need COUNT or SUM columns", id)
+		}
+		if len(plain) > 1 && len(q.columns) < len(plain) {
+			return nil, fmt.Errorf("Invalid query %v. err=* can't be selected along with other columns", id)
+		}
+		return q, nil
+	}
+
+	group := `"all"`
+	if grouped {
+		if strings.Contains(groupBy, ",") {
+			return nil, fmt.Errorf("Invalid query %v. err=GROUP BY takes a single expression", id)
+		}
+		group = sqlToCEL(groupBy)
+	}
+	for i, expr := range plain {
+		if !grouped || expr != groupBy {
+			return nil, fmt.Errorf("Invalid query %v. err=%v must be the GROUP BY expression to be selected with aggregates", id, expr)
+		}
+		q.groupColumn = q.columns[i].name
+	}
+	q.columns = nil
+	if orderBy, ok := clauses["ORDER BY"]; ok {
+		m := queryOrderBy.FindStringSubmatch(orderBy)
+		q.orderBy, q.descending = strings.TrimSpace(m[1]), strings.EqualFold(m[2], "DESC")
+		found := false
+		for _, name := range names {
+			found = found || name == q.orderBy
+		}
+		if !found {
+			return nil, fmt.Errorf("Invalid query %v. err=ORDER BY takes the name of a selected column, not %v", id, q.orderBy)
+		}
+	}
+	var seconds float64
+	sliding := false
+	if windowed {
+		m := queryWindow.FindStringSubmatch(window)
+		if m == nil {
+			return nil, fmt.Errorf("Invalid query %v. err=WINDOW takes a size, e.g. WINDOW TUMBLING (SIZE 1 MINUTE) or WINDOW SLIDING (SIZE 1 MINUTE)", id)
+		}
+		n, _ := strconv.ParseFloat(m[2], 64)
+		seconds = n * queryWindowUnits[strings.ToUpper(m[3])].Seconds()
+		sliding = strings.EqualFold(m[1], "SLIDING")
+	}
+	where := ""
+	if q.where != nil {
+		where = q.where.src
+	}
+	for i := range aggregates {
+		aggregates[i].GroupBy, aggregates[i].WindowSeconds, aggregates[i].Filter = group, seconds, where
+	}
+	if q.aggregations, err = processAggregations(aggregates); err != nil {
+		return nil, fmt.Errorf("Invalid query %v. err=%v", id, err)
+	}
+	if sliding {
+		q.sliding = newSlidingWindow(q.aggregations[0].window, len(q.aggregations))
+	}
+	return q, nil
+}
+
+// splitSQL returns the clauses of sql by keyword, failing unless it has a
+// SELECT and a FROM and its clauses are in order.
+func splitSQL(sql string) (map[string]string, error) {
+	type start struct {
+		keyword     string
+		begin, body int
+	}
+	starts := []start{}
+	upper := strings.ToUpper(sql)
+	depth, quote := 0, rune(0)
+	for i, r := range sql {
+		switch {
+		case quote != 0:
+			if r == quote && sql[i-1] != '\\' {
+				quote = 0
+			}
+			continue
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+			continue
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		}
+		if depth > 0 || (i > 0 && !unicode.IsSpace(rune(sql[i-1]))) {
+			continue
+		}
+		for _, k := range querySQLKeywords {
+			end := i + len(k)
+			if strings.HasPrefix(upper[i:], k) && (end == len(sql) || unicode.IsSpace(rune(sql[end]))) {
+				starts = append(starts, start{keyword: k, begin: i, body: end})
+			}
+		}
+	}
+	clauses := map[string]string{}
+	for i, s := range starts {
+		end := len(sql)
+		if i+1 < len(starts) {
+			end = starts[i+1].begin
+		}
+		if _, ok := clauses[s.keyword]; ok {
+			return nil, fmt.Errorf("%v appears more than once", s.keyword)
+		}
+		if i > 0 && keywordIndex(s.keyword) < keywordIndex(starts[i-1].keyword) {
+			return nil, fmt.Errorf("%v must come before %v", s.keyword, starts[i-1].keyword)
+		}
+		if body := strings.TrimSpace(sql[s.body:end]); len(body) > 0 {
+			clauses[s.keyword] = body
+		} else {
+			return nil, fmt.Errorf("%v is empty", s.keyword)
+		}
+	}
+	if len(starts) == 0 || starts[0].begin != strings.Index(sql, strings.TrimSpace(sql)) || starts[0].keyword != "SELECT" {
+		return nil, fmt.Errorf("queries start with SELECT")
+	}
+	if _, ok := clauses["FROM"]; !ok {
+		return nil, fmt.Errorf("queries need a FROM topic")
+	}
+	return clauses, nil
+}
+
+func keywordIndex(keyword string) int {
+	for i, k := range querySQLKeywords {
+		if k == keyword {
+			return i
+		}
+	}
+	return -1
+}
+
+// splitTopLevel splits s at the separators outside of parentheses, brackets,
+// braces and strings.
+func splitTopLevel(s string, sep rune) []string {
+	parts := []string{}
+	depth, quote, begin := 0, rune(0), 0
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote && s[i-1] != '\\' {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(' || r == '[' || r == '{':
+			depth++
+		case r == ')' || r == ']' || r == '}':
+			depth--
+		case r == sep && depth == 0:
+			parts = append(parts, strings.TrimSpace(s[begin:i]))
+			begin = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[begin:]))
+}
+
+// sqlToCEL rewrites SQL's AND, OR and NOT and its = and <> comparisons of an
+// expression to CEL, leaving strings alone.
+func sqlToCEL(expr string) string {
+	var b strings.Builder
+	rs := []rune(expr)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '\'' || r == '"':
+			j := i + 1
+			for j < len(rs) && rs[j] != r {
+				if rs[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(rs) {
+				j = len(rs) - 1
+			}
+			b.WriteString(string(rs[i : j+1]))
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_') {
+				j++
+			}
+			word := string(rs[i:j])
+			selected := i > 0 && rs[i-1] == '.'
+			switch strings.ToUpper(word) {
+			case "AND":
+				if !selected {
+					word = "&&"
+				}
+			case "OR":
+				if !selected {
+					word = "||"
+				}
+			case "NOT":
+				if !selected {
+					word = "!"
+				}
+			}
+			b.WriteString(word)
+			i = j - 1
+		case r == '<' && i+1 < len(rs) && rs[i+1] == '>':
+			b.WriteString("!=")
+			i++
+		case r == '=' && (i == 0 || !strings.ContainsRune("=!<>", rs[i-1])) && (i+1 == len(rs) || rs[i+1] != '='):
+			b.WriteString("==")
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func (qs queries) index(id string) int {
+	for i, q := range qs {
+		if q.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// apply adds, replaces or stops the query of a query or stopQuery control,
+// e.g. {"action":"query","id":"big","sql":"SELECT key FROM orders WHERE value.amount > 100"}.
+// subscribe and unsubscribe do the same for the saved query of that id.
+func (qs *queries) apply(ctl control, saved *savedQueries) (string, error) {
+	if len(ctl.Id) == 0 {
+		return "", fmt.Errorf("Please define the id of the query")
+	}
+	i := qs.index(ctl.Id)
+	switch ctl.Action {
+	case "stopQuery", "unsubscribe":
+		if i < 0 {
+			return "", fmt.Errorf("Unknown query %v", ctl.Id)
+		}
+		*qs = append((*qs)[:i], (*qs)[i+1:]...)
+		if ctl.Action == "unsubscribe" {
+			return fmt.Sprintf("Unsubscribed from query %v", ctl.Id), nil
+		}
+		return fmt.Sprintf("Stopped query %v", ctl.Id), nil
+	case "subscribe":
+		sq, err := saved.get(ctl.Id)
+		if err != nil {
+			return "", err
+		}
+		ctl.SQL = sq.SQL
+	}
+	q, err := compileQuery(ctl.Id, ctl.SQL)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case i >= 0:
+		(*qs)[i] = q
+	case len(*qs) >= maxQueries:
+		return "", fmt.Errorf("Can't run more than %v queries at once; please stop one first", maxQueries)
+	default:
+		*qs = append(*qs, q)
+	}
+	switch {
+	case ctl.Action == "subscribe":
+		return fmt.Sprintf("Subscribed to query %v", q.id), nil
+	case i >= 0:
+		return fmt.Sprintf("Replaced query %v", q.id), nil
+	}
+	return fmt.Sprintf("Running query %v", q.id), nil
+}
+
+// onMessage returns the queryRow events of the queries m matches, and feeds
+// it to their aggregations.
+func (qs queries) onMessage(m message, now time.Time) []event {
+	events := []event{}
+	for _, q := range qs {
+		if m.Topic != q.topic {
+			continue
+		}
+		if q.sliding != nil {
+			q.sliding.onMessage(q.aggregations, m, now)
+			continue
+		}
+		if len(q.aggregations) > 0 {
+			aggregateMessage(q.aggregations, m, now)
+			continue
+		}
+		if q.where != nil {
+			if ok, _ := q.where.match(m); !ok {
+				continue
+			}
+		}
+		row := m.Value
+		if len(q.columns) > 0 {
+			row = map[string]interface{}{}
+			for _, c := range q.columns {
+				v, err := c.expr.eval(m)
+				if err != nil {
+					v = nil
+				}
+				row[c.name] = v
+			}
+		}
+		partition, offset, timestamp := m.Partition, m.Offset, m.Timestamp
+		events = append(events, event{EventType: "queryRow", Query: q.id, Topic: m.Topic, Partition: &partition, Offset: &offset, Key: m.Key, Cluster: m.Cluster, Timestamp: &timestamp, JSON: []map[string]interface{}{row}})
+	}
+	return events
+}
+
+// report returns a queryResult event for every window of the queries'
+// aggregations that closed, with a row per group holding every aggregate,
+// and for every sliding query whose result changed since it was last sent.
+func (qs queries) report(now time.Time) []event {
+	events := []event{}
+	for _, q := range qs {
+		if q.sliding != nil {
+			if e, ok := q.materialize(now); ok {
+				events = append(events, e)
+			}
+			continue
+		}
+		windows := [][]event{}
+		for _, a := range q.aggregations {
+			windows = append(windows, a.report(now))
+		}
+		if len(windows) == 0 {
+			continue
+		}
+		for w := range windows[0] {
+			seen, groups := map[string]bool{}, []string{}
+			values := make([]map[string]interface{}, len(q.aggregations))
+			for i := range q.aggregations {
+				values[i] = map[string]interface{}{}
+				for _, r := range windows[i][w].JSON {
+					g := fmt.Sprint(r["group"])
+					if !seen[g] {
+						seen[g] = true
+						groups = append(groups, g)
+					}
+					values[i][g] = r["value"]
+				}
+			}
+			sort.Strings(groups)
+			rows := q.shape(groups, values)
+			events = append(events, event{EventType: "queryResult", Query: q.id, Topic: q.topic, Text: fmt.Sprintf("Query [%v]: %v groups.", q.id, len(rows)), JSON: rows})
+		}
+	}
+	return events
+}
+
+// materialize returns the result of a sliding query if it's time to
+// recompute it and it changed since it was last sent.
+func (q *query) materialize(now time.Time) (event, bool) {
+	if now.Sub(q.refreshed) < materializedRefresh {
+		return event{}, false
+	}
+	q.refreshed = now
+	groups, values := q.sliding.result(now)
+	rows := q.shape(groups, values)
+	byt, _ := json.Marshal(rows)
+	if string(byt) == q.last {
+		return event{}, false
+	}
+	q.last = string(byt)
+	return event{
+		EventType: "queryResult",
+		Query:     q.id,
+		Topic:     q.topic,
+		Text:      fmt.Sprintf("Query [%v]: %v of %v groups in the last %v.", q.id, len(rows), len(groups), q.sliding.size),
+		JSON:      rows,
+	}, true
+}
+
+// shape turns the values of the aggregations by group into rows, sorted by
+// ORDER BY and cut at LIMIT. A row leaves out the columns of the aggregations
+// its group is missing from.
+func (q *query) shape(groups []string, values []map[string]interface{}) []map[string]interface{} {
+	rows := []map[string]interface{}{}
+	for _, g := range groups {
+		row := map[string]interface{}{}
+		if len(q.groupColumn) > 0 {
+			row[q.groupColumn] = g
+		}
+		for i, a := range q.aggregations {
+			if v, ok := values[i][g]; ok {
+				row[a.name] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+	if len(q.orderBy) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			if q.descending {
+				return queryLess(rows[j][q.orderBy], rows[i][q.orderBy])
+			}
+			return queryLess(rows[i][q.orderBy], rows[j][q.orderBy])
+		})
+	}
+	if q.limit > 0 && len(rows) > q.limit {
+		rows = rows[:q.limit]
+	}
+	return rows
+}
+
+// queryLess orders numbers numerically and anything else by its text.
+func queryLess(a, b interface{}) bool {
+	x, xok := celNumber(a)
+	y, yok := celNumber(b)
+	if xok && yok {
+		return x < y
+	}
+	return fmt.Sprint(a) < fmt.Sprint(b)
+}
+
+// slidingWindow keeps the aggregates of a query's last size in buckets of a
+// sixtieth of it, a second at least, so that the result over the window can
+// be recomputed at any time.
+type slidingWindow struct {
+	size, step time.Duration
+	columns    int
+	buckets    []*slidingBucket // oldest first
+}
+
+type slidingBucket struct {
+	start    time.Time
+	values   []map[string]float64 // by column and group
+	distinct []map[string]map[string]bool
+}
+
+func newSlidingWindow(size time.Duration, columns int) *slidingWindow {
+	step := size / 60
+	if step < time.Second {
+		step = time.Second
+	}
+	return &slidingWindow{size: size, step: step, columns: columns}
+}
+
+// expire drops the buckets that ended before the window that ends now.
+func (w *slidingWindow) expire(now time.Time) {
+	i := 0
+	for i < len(w.buckets) && !w.buckets[i].start.Add(w.step).After(now.Add(-w.size)) {
+		i++
+	}
+	w.buckets = w.buckets[i:]
+}
+
+func (w *slidingWindow) onMessage(aggregations []*aggregation, m message, now time.Time) {
+	w.expire(now)
+	start := now.Truncate(w.step)
+	if len(w.buckets) == 0 || w.buckets[len(w.buckets)-1].start.Before(start) {
+		b := &slidingBucket{start: start}
+		for i := 0; i < w.columns; i++ {
+			b.values = append(b.values, map[string]float64{})
+			b.distinct = append(b.distinct, map[string]map[string]bool{})
+		}
+		w.buckets = append(w.buckets, b)
+	}
+	b := w.buckets[len(w.buckets)-1]
+	for i, a := range aggregations {
+		group, v, ok := a.valueOf(m)
+		if !ok {
+			continue
+		}
+		if _, ok := b.values[i][group]; !ok && len(b.values[i]) >= aggregationMaxGroups {
+			continue
+		}
+		switch a.function {
+		case "count":
+			b.values[i][group]++
+		case "sum":
+			b.values[i][group] += v.(float64)
+		case "distinct":
+			if _, ok := b.distinct[i][group]; !ok {
+				b.distinct[i][group] = map[string]bool{}
+			}
+			b.distinct[i][group][fmt.Sprint(v)] = true
+			b.values[i][group] = 0
+		}
+	}
+}
+
+// result returns the sorted groups of the window that ends now, and the
+// values of every column by group.
+func (w *slidingWindow) result(now time.Time) ([]string, []map[string]interface{}) {
+	w.expire(now)
+	seen := map[string]bool{}
+	groups := []string{}
+	values := make([]map[string]interface{}, w.columns)
+	for i := range values {
+		values[i] = map[string]interface{}{}
+		distinct := map[string]map[string]bool{}
+		for _, b := range w.buckets {
+			for g, v := range b.values[i] {
+				if !seen[g] {
+					seen[g] = true
+					groups = append(groups, g)
+				}
+				if vs, ok := b.distinct[i][g]; ok {
+					if _, ok := distinct[g]; !ok {
+						distinct[g] = map[string]bool{}
+					}
+					for d := range vs {
+						distinct[g][d] = true
+					}
+					values[i][g] = float64(len(distinct[g]))
+					continue
+				}
+				sum, _ := values[i][g].(float64)
+				values[i][g] = sum + v
+			}
+		}
+	}
+	sort.Strings(groups)
+	return groups, values
+}
+
+type savedQuery struct {
+	Id      string    `json:"id"`
+	SQL     string    `json:"sql"`
+	Created time.Time `json:"created"`
+}
+
+// savedQueries are the queries kept on the server for clients to subscribe
+// to by id.
+type savedQueries struct {
+	store *store
+}
+
+func newSavedQueries(dataDir string) *savedQueries {
+	return &savedQueries{store: newStore(dataDir, "queries.json")}
+}
+
+func (s *savedQueries) get(id string) (savedQuery, error) {
+	if s == nil {
+		return savedQuery{}, fmt.Errorf("Unknown saved query %v", id)
+	}
+	sqs := []savedQuery{}
+	if err := s.store.read(&sqs); err != nil {
+		return savedQuery{}, err
+	}
+	for _, sq := range sqs {
+		if sq.Id == id {
+			return sq, nil
+		}
+	}
+	return savedQuery{}, fmt.Errorf("Unknown saved query %v", id)
+}
+
+// handler lists, saves and deletes saved queries on /api/queries. Saving a
+// query replaces the one of the same id.
+func (s *savedQueries) handler(w http.ResponseWriter, r *http.Request) {
+	sqs := []savedQuery{}
+	switch r.Method {
+	case "GET":
+		if err := s.store.read(&sqs); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		sort.Slice(sqs, func(i, j int) bool { return sqs[i].Id < sqs[j].Id })
+		writeJSON(w, http.StatusOK, sqs)
+	case "POST":
+		var sq savedQuery
+		if err := json.NewDecoder(r.Body).Decode(&sq); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid query. err=%v", err))
+			return
+		}
+		if _, err := compileQuery(sq.Id, sq.SQL); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		sq.Created = time.Now().UTC()
+		err := s.store.update(&sqs, func() error {
+			for i := range sqs {
+				if sqs[i].Id == sq.Id {
+					sqs[i] = sq
+					return nil
+				}
+			}
+			sqs = append(sqs, sq)
+			return nil
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, sq)
+	case "DELETE":
+		id := r.URL.Query().Get("id")
+		found := false
+		err := s.store.update(&sqs, func() error {
+			for i, sq := range sqs {
+				if sq.Id == id {
+					sqs, found = append(sqs[:i], sqs[i+1:]...), true
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, fmt.Errorf("Query %v not found", id))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+	}
+}
@@ -0,0 +1,246 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLToCEL(t *testing.T) {
+	tests := []struct {
+		sql      string
+		expected string
+	}{
+		{sql: "value.status = 'PAID'", expected: "value.status == 'PAID'"},
+		{sql: "value.a <> 1 and not value.b or value.c >= 2", expected: "value.a != 1 && ! value.b || value.c >= 2"},
+		{sql: "value.note = 'and = or'", expected: "value.note == 'and = or'"},
+		{sql: "value.and == 1 AND value.not != 2", expected: "value.and == 1 && value.not != 2"},
+	}
+	for _, ts := range tests {
+		if actual := sqlToCEL(ts.sql); actual != ts.expected {
+			t.Errorf("on '%v': expected %q but got %q", ts.sql, ts.expected, actual)
+		}
+	}
+}
+
+func TestCompileQuery(t *testing.T) {
+	tests := []struct {
+		sql          string
+		aggregations int
+		columns      int
+		fails        bool
+	}{
+		{sql: "SELECT * FROM orders"},
+		{sql: "select key, value.amount * 2 as double from orders where value.status = 'PAID'", columns: 2},
+		{sql: "SELECT value.from FROM `orders.v2` WHERE value.from = 'web'", columns: 1},
+		{sql: "SELECT COUNT(*) FROM orders", aggregations: 1},
+		{sql: "SELECT value.region, COUNT(*) AS orders, SUM(value.amount) AS total, COUNT(DISTINCT key) AS customers FROM orders WHERE value.amount > 0 GROUP BY value.region WINDOW TUMBLING (SIZE 5 MINUTES)", aggregations: 3},
+		{sql: "SELECT COUNT(value.coupon) AS coupons FROM orders WINDOW 30 SECONDS", aggregations: 1},
+		{sql: "FROM orders", fails: true},
+		{sql: "SELECT key", fails: true},
+		{sql: "SELECT key FROM orders, payments", fails: true},
+		{sql: "SELECT key FROM orders WHERE", fails: true},
+		{sql: "SELECT key FROM orders WHERE value.a == (", fails: true},
+		{sql: "SELECT key FROM orders GROUP BY key", fails: true},
+		{sql: "SELECT *, key FROM orders", fails: true},
+		{sql: "SELECT key, key FROM orders", fails: true},
+		{sql: "SELECT AVG(value.amount) FROM orders", fails: true},
+		{sql: "SELECT SUM(*) FROM orders", fails: true},
+		{sql: "SELECT SUM(DISTINCT value.amount) FROM orders", fails: true},
+		{sql: "SELECT key, COUNT(*) FROM orders GROUP BY value.region", fails: true},
+		{sql: "SELECT COUNT(*) FROM orders GROUP BY key, value.region", fails: true},
+		{sql: "SELECT COUNT(*) FROM orders WINDOW HOPPING", fails: true},
+		{sql: "SELECT COUNT(*) FROM orders WINDOW 1 MINUTE WHERE key == 'a'", fails: true},
+		{sql: "SELECT key AS k, COUNT(*) AS n FROM orders GROUP BY key WINDOW SLIDING (SIZE 1 MINUTE) ORDER BY n DESC LIMIT 10", aggregations: 1},
+		{sql: "SELECT key, COUNT(*) FROM orders GROUP BY key ORDER BY key", aggregations: 1},
+		{sql: "SELECT key, COUNT(*) AS n FROM orders GROUP BY key ORDER BY total", fails: true},
+		{sql: "SELECT key, COUNT(*) AS n FROM orders GROUP BY key LIMIT 0", fails: true},
+		{sql: "SELECT key, COUNT(*) AS n FROM orders GROUP BY key LIMIT 5 ORDER BY n", fails: true},
+		{sql: "SELECT key FROM orders LIMIT 5", fails: true},
+	}
+	for _, ts := range tests {
+		q, err := compileQuery("q", ts.sql)
+		if ts.fails != (err != nil) {
+			t.Errorf("on '%v': expected failure %v but got %v", ts.sql, ts.fails, err)
+			continue
+		}
+		if err == nil && (len(q.aggregations) != ts.aggregations || len(q.columns) != ts.columns) {
+			t.Errorf("on '%v': expected %v aggregations and %v columns but got %v and %v", ts.sql, ts.aggregations, ts.columns, len(q.aggregations), len(q.columns))
+		}
+	}
+}
+
+func TestQueryRows(t *testing.T) {
+	q, err := compileQuery("paid", "SELECT key, value.amount * 2 AS double FROM orders WHERE value.status = 'PAID' AND value.amount > 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	qs := queries{q}
+	now := time.Now()
+	tests := []struct {
+		m        message
+		expected string
+	}{
+		{m: message{Topic: "orders", Key: "1", Value: newValueFrom(`{"status":"PAID","amount":20}`)}, expected: `[{"double":40,"key":"1"}]`},
+		{m: message{Topic: "orders", Key: "2", Value: newValueFrom(`{"status":"PAID","amount":5}`)}},
+		{m: message{Topic: "orders", Key: "3", Value: newValueFrom(`{"status":"NEW","amount":50}`)}},
+		{m: message{Topic: "payments", Key: "4", Value: newValueFrom(`{"status":"PAID","amount":50}`)}},
+	}
+	for _, ts := range tests {
+		events := qs.onMessage(ts.m, now)
+		actual := ""
+		if len(events) > 0 {
+			if events[0].EventType != "queryRow" || events[0].Query != "paid" {
+				t.Errorf("on '%v': expected a queryRow of query paid but got %+v", ts.m.Key, events[0])
+			}
+			byt, _ := json.Marshal(events[0].JSON)
+			actual = string(byt)
+		}
+		if actual != ts.expected {
+			t.Errorf("on '%v': expected rows %v but got %v", ts.m.Key, ts.expected, actual)
+		}
+	}
+}
+
+func TestQueryResultsMergeAggregatesByGroup(t *testing.T) {
+	q, err := compileQuery("regions", "SELECT value.region AS region, COUNT(*) AS orders, SUM(value.amount) AS total FROM orders WHERE value.amount > 0 GROUP BY value.region WINDOW TUMBLING (SIZE 1 MINUTE)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	qs := queries{q}
+	start := q.aggregations[0].start
+	for _, v := range []string{
+		`{"region":"eu","amount":10}`,
+		`{"region":"us","amount":5}`,
+		`{"region":"eu","amount":30}`,
+		`{"region":"eu","amount":-1}`,
+	} {
+		qs.onMessage(message{Topic: "orders", Value: newValueFrom(v)}, start)
+	}
+	if events := qs.report(start.Add(time.Second)); len(events) != 0 {
+		t.Errorf("expected no results before the window closes but got %+v", events)
+	}
+	events := qs.report(start.Add(time.Minute))
+	if len(events) != 1 || events[0].EventType != "queryResult" || events[0].Query != "regions" {
+		t.Fatalf("expected a queryResult once the window closes but got %+v", events)
+	}
+	byt, _ := json.Marshal(events[0].JSON)
+	expected := `[{"orders":2,"region":"eu","total":40},{"orders":1,"region":"us","total":5}]`
+	if string(byt) != expected {
+		t.Errorf("expected rows %v but got %v", expected, string(byt))
+	}
+}
+
+func TestQueriesApply(t *testing.T) {
+	var qs queries
+	tests := []struct {
+		ctl      control
+		expected string
+		fails    bool
+	}{
+		{ctl: control{Action: "query", Id: "big", SQL: "SELECT key FROM orders WHERE value.amount > 100"}, expected: "Running query big"},
+		{ctl: control{Action: "query", Id: "big", SQL: "SELECT key FROM orders WHERE value.amount > 1000"}, expected: "Replaced query big"},
+		{ctl: control{Action: "query", SQL: "SELECT key FROM orders"}, fails: true},
+		{ctl: control{Action: "query", Id: "broken", SQL: "SELECT key"}, fails: true},
+		{ctl: control{Action: "stopQuery", Id: "big"}, expected: "Stopped query big"},
+		{ctl: control{Action: "stopQuery", Id: "big"}, fails: true},
+	}
+	for _, ts := range tests {
+		text, err := qs.apply(ts.ctl, nil)
+		if ts.fails != (err != nil) || text != ts.expected {
+			t.Errorf("on '%+v': expected %q (fails %v) but got %q and %v", ts.ctl, ts.expected, ts.fails, text, err)
+		}
+	}
+
+	for i := 0; i < maxQueries; i++ {
+		qs.apply(control{Action: "query", Id: strings.Repeat("q", i+1), SQL: "SELECT * FROM orders"}, nil)
+	}
+	if _, err := qs.apply(control{Action: "query", Id: "one too many", SQL: "SELECT * FROM orders"}, nil); err == nil {
+		t.Errorf("expected queries beyond %v to be refused", maxQueries)
+	}
+}
+
+func TestSlidingQueriesPushTheirResultWhenItChanges(t *testing.T) {
+	q, err := compileQuery("top", "SELECT key, COUNT(*) AS n, COUNT(DISTINCT value.user) AS users FROM orders GROUP BY key WINDOW SLIDING (SIZE 1 MINUTE) ORDER BY n DESC LIMIT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	qs := queries{q}
+	start := time.Now().Truncate(time.Minute)
+	send := func(key, user string, at time.Duration) {
+		qs.onMessage(message{Topic: "orders", Key: key, Value: newValueFrom(`{"user":"` + user + `"}`)}, start.Add(at))
+	}
+	send("a", "u1", 0)
+	send("b", "u1", 10*time.Second)
+	send("b", "u2", 20*time.Second)
+	send("c", "u1", 30*time.Second)
+	send("c", "u1", 40*time.Second)
+	send("c", "u2", 50*time.Second)
+
+	tests := []struct {
+		at       time.Duration
+		expected string
+	}{
+		{at: 55 * time.Second, expected: `[{"key":"c","n":3,"users":2},{"key":"b","n":2,"users":2}]`},
+		{at: 55*time.Second + 500*time.Millisecond}, // not refreshed yet
+		{at: 57 * time.Second}, // unchanged
+		{at: 75 * time.Second, expected: `[{"key":"c","n":3,"users":2},{"key":"b","n":1,"users":1}]`}, // b's first message left the window
+		{at: 2 * time.Minute, expected: `[]`},
+	}
+	for _, ts := range tests {
+		events := qs.report(start.Add(ts.at))
+		actual := ""
+		if len(events) > 0 {
+			byt, _ := json.Marshal(events[0].JSON)
+			actual = string(byt)
+		}
+		if actual != ts.expected {
+			t.Errorf("on '%v': expected rows %v but got %v", ts.at, ts.expected, actual)
+		}
+	}
+}
+
+func TestSavedQueries(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+	s := newSavedQueries(dir)
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{method: "POST", path: "/api/queries", body: `{"id":"top","sql":"SELECT key, COUNT(*) AS n FROM orders GROUP BY key WINDOW SLIDING (SIZE 1 MINUTE) ORDER BY n DESC LIMIT 10"}`, status: http.StatusCreated},
+		{method: "POST", path: "/api/queries", body: `{"id":"broken","sql":"SELECT key"}`, status: http.StatusBadRequest},
+		{method: "POST", path: "/api/queries", body: `{"sql":"SELECT key FROM orders"}`, status: http.StatusBadRequest},
+		{method: "POST", path: "/api/queries", body: `{"id":"gone","sql":"SELECT * FROM orders"}`, status: http.StatusCreated},
+		{method: "DELETE", path: "/api/queries?id=gone", status: http.StatusNoContent},
+		{method: "DELETE", path: "/api/queries?id=gone", status: http.StatusNotFound},
+		{method: "GET", path: "/api/queries", status: http.StatusOK},
+		{method: "PATCH", path: "/api/queries", status: http.StatusMethodNotAllowed},
+	}
+	for _, ts := range tests {
+		w := httptest.NewRecorder()
+		s.handler(w, httptest.NewRequest(ts.method, ts.path, strings.NewReader(ts.body)))
+		if w.Code != ts.status {
+			t.Errorf("on '%v %v': expected status %v but got %v: %v", ts.method, ts.path, ts.status, w.Code, w.Body.String())
+		}
+	}
+
+	var qs queries
+	if text, err := qs.apply(control{Action: "subscribe", Id: "top"}, s); err != nil || text != "Subscribed to query top" || qs[0].sliding == nil {
+		t.Errorf("expected to subscribe to the saved query but got %q and %v", text, err)
+	}
+	if _, err := qs.apply(control{Action: "subscribe", Id: "gone"}, s); err == nil {
+		t.Errorf("expected subscribing to a deleted query to fail")
+	}
+	if text, err := qs.apply(control{Action: "unsubscribe", Id: "top"}, s); err != nil || text != "Unsubscribed from query top" || len(qs) != 0 {
+		t.Errorf("expected to unsubscribe from the saved query but got %q and %v", text, err)
+	}
+}
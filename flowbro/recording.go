@@ -0,0 +1,354 @@
+package flowbro
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type recordingJSON struct {
+	Name string `json:"name"`
+}
+
+type replayJSON struct {
+	Recording string  `json:"recording"`
+	Speed     float64 `json:"speed"`
+	Filter    string  `json:"filter"`
+	ProduceTo string  `json:"produceTo"`
+}
+
+// recordedMessage is a consumed message as it entered the pipeline, after
+// decoding and redaction, and when flowbro received it. It reads as a mock
+// fixture, so recordings can also be served with -mock.
+type recordedMessage struct {
+	Received time.Time `json:"received"`
+	mockFixture
+}
+
+// recording persists the messages of a session, and the config they were
+// consumed with, under the recordings directory of the data directory.
+type recording struct {
+	file *os.File
+}
+
+// archive is a self-contained export of part of a recording, which another
+// flowbro can import and replay.
+type archive struct {
+	Version  int               `json:"version"`
+	Name     string            `json:"name"`
+	Exported time.Time         `json:"exported"`
+	From     *time.Time        `json:"from,omitempty"`
+	To       *time.Time        `json:"to,omitempty"`
+	Config   json.RawMessage   `json:"config"`
+	Stats    archiveStats      `json:"stats"`
+	Messages []recordedMessage `json:"messages"`
+}
+
+type archiveStats struct {
+	Messages int              `json:"messages"`
+	Topics   map[string]int64 `json:"topics"`
+	First    *time.Time       `json:"first,omitempty"`
+	Last     *time.Time       `json:"last,omitempty"`
+}
+
+const archiveVersion = 1
+
+func recordingDir(dataDir, name string) (string, error) {
+	if !safeName(name) {
+		return "", fmt.Errorf("Invalid recording name %v", name)
+	}
+	return filepath.Join(dataDir, "recordings", name), nil
+}
+
+// openRecording starts recording a session consumed with the config in raw,
+// for board if any, appending to the recording if it already exists.
+func openRecording(c *recordingJSON, dataDir string, raw json.RawMessage, board string) (*recording, error) {
+	if c == nil {
+		return nil, nil
+	}
+	dir, err := recordingDir(dataDir, c.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), raw, 0644); err != nil {
+		return nil, fmt.Errorf("Could not save config of recording %v. err=%v", c.Name, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "board"), []byte(board), 0644); err != nil {
+		return nil, fmt.Errorf("Could not save board of recording %v. err=%v", c.Name, err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "messages.jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open recording %v. err=%v", c.Name, err)
+	}
+	return &recording{file: f}, nil
+}
+
+// recordingBoard returns the board the recording name was recorded for, or
+// "" if none.
+func recordingBoard(dataDir, name string) string {
+	dir, err := recordingDir(dataDir, name)
+	if err != nil {
+		return ""
+	}
+	byt, err := ioutil.ReadFile(filepath.Join(dir, "board"))
+	if err != nil {
+		return ""
+	}
+	return string(byt)
+}
+
+// mayReadRecording fails unless the principal of r may read the recording
+// name.
+func mayReadRecording(r *http.Request, dataDir, name string) error {
+	if !principalOf(r).mayRead(recordingBoard(dataDir, name)) {
+		return fmt.Errorf("You may not read recording %v", name)
+	}
+	return nil
+}
+
+func (r *recording) record(m message, now time.Time) error {
+	value, err := json.Marshal(m.Value)
+	if err != nil {
+		return err
+	}
+	byt, err := json.Marshal(recordedMessage{
+		Received: now,
+		mockFixture: mockFixture{
+			Topic:     m.Topic,
+			Partition: m.Partition,
+			Offset:    m.Offset,
+			Key:       m.Key,
+			Value:     value,
+			Timestamp: m.Timestamp,
+			Cluster:   m.Cluster,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := r.file.Write(append(byt, '\n')); err != nil {
+		return fmt.Errorf("Could not record message. err=%v", err)
+	}
+	return nil
+}
+
+func (r *recording) close() {
+	if r != nil {
+		r.file.Close()
+	}
+}
+
+// eachRecorded calls f with every recorded message received between from and
+// to, either of which may be nil, stopping at the first error.
+func eachRecorded(dataDir, name string, from, to *time.Time, f func(recordedMessage) error) error {
+	dir, err := recordingDir(dataDir, name)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filepath.Join(dir, "messages.jsonl"))
+	if err != nil {
+		return fmt.Errorf("Could not open recording %v. err=%v", name, err)
+	}
+	defer file.Close()
+
+	d := json.NewDecoder(bufio.NewReader(file))
+	for d.More() {
+		var m recordedMessage
+		if err := d.Decode(&m); err != nil {
+			return fmt.Errorf("Could not read recording %v. err=%v", name, err)
+		}
+		if (from != nil && m.Received.Before(*from)) || (to != nil && m.Received.After(*to)) {
+			continue
+		}
+		if err := f(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readRecording(dataDir, name string, from, to *time.Time) ([]recordedMessage, error) {
+	ms := []recordedMessage{}
+	err := eachRecorded(dataDir, name, from, to, func(m recordedMessage) error {
+		ms = append(ms, m)
+		return nil
+	})
+	return ms, err
+}
+
+// message returns the recorded message as it entered the pipeline.
+func (m recordedMessage) message() (message, error) {
+	value := map[string]interface{}{}
+	if err := json.Unmarshal(m.Value, &value); err != nil {
+		return message{}, fmt.Errorf("Could not read recorded value at offset %v of topic %v. err=%v", m.Offset, m.Topic, err)
+	}
+	return message{
+		Key:       m.Key,
+		Value:     value,
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Timestamp: m.Timestamp,
+		Cluster:   m.Cluster,
+	}, nil
+}
+
+func exportRecording(dataDir, name string, from, to *time.Time, now time.Time) (archive, error) {
+	ms, err := readRecording(dataDir, name, from, to)
+	if err != nil {
+		return archive{}, err
+	}
+	dir, _ := recordingDir(dataDir, name)
+	config, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		return archive{}, fmt.Errorf("Could not read config of recording %v. err=%v", name, err)
+	}
+
+	stats := archiveStats{Messages: len(ms), Topics: map[string]int64{}}
+	for i := range ms {
+		stats.Topics[ms[i].Topic]++
+		if stats.First == nil || ms[i].Received.Before(*stats.First) {
+			stats.First = &ms[i].Received
+		}
+		if stats.Last == nil || ms[i].Received.After(*stats.Last) {
+			stats.Last = &ms[i].Received
+		}
+	}
+	return archive{Version: archiveVersion, Name: name, Exported: now, From: from, To: to, Config: config, Stats: stats, Messages: ms}, nil
+}
+
+// importArchive saves a as the recording named name, replacing any recording
+// by that name.
+func importArchive(dataDir, name string, a archive) error {
+	if a.Version != archiveVersion {
+		return fmt.Errorf("Unsupported archive version %v", a.Version)
+	}
+	dir, err := recordingDir(dataDir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if len(a.Config) == 0 {
+		a.Config = json.RawMessage("{}")
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), a.Config, 0644); err != nil {
+		return err
+	}
+	sort.SliceStable(a.Messages, func(i, j int) bool { return a.Messages[i].Received.Before(a.Messages[j].Received) })
+	lines := []byte{}
+	for _, m := range a.Messages {
+		byt, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, byt...), '\n')
+	}
+	tmp := filepath.Join(dir, "messages.jsonl.tmp")
+	if err := ioutil.WriteFile(tmp, lines, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(dir, "messages.jsonl"))
+}
+
+// replaySource serves the messages of a recording as if they were coming
+// from Kafka, keeping the time between them, divided by speed.
+type replaySource struct {
+	dataDir string
+	c       *replayJSON
+	mockSource
+}
+
+func (s *replaySource) Start(ctx context.Context) (<-chan *SourceMessage, error) {
+	ms, err := readRecording(s.dataDir, s.c.Recording, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load the recording. err=%v", err)
+	}
+	speed := s.c.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	fixtures := []mockFixture{}
+	for i, m := range ms {
+		f := m.mockFixture
+		if i > 0 {
+			f.DelayMs = int64(float64(m.Received.Sub(ms[i-1].Received)/time.Millisecond) / speed)
+		}
+		fixtures = append(fixtures, f)
+	}
+
+	return s.push(ctx, fixtures), nil
+}
+
+func parseTimeParam(r *http.Request, name string) (*time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if len(v) == 0 {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %v %v; use RFC3339, e.g. 2017-01-01T10:00:00Z", name, v)
+	}
+	return &t, nil
+}
+
+func (f *flowbro) exportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+		return
+	}
+	from, err := parseTimeParam(r, "from")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	to, err := parseTimeParam(r, "to")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	name := r.URL.Query().Get("recording")
+	if err := mayReadRecording(r, f.dataDir, name); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	a, err := exportRecording(f.dataDir, name, from, to, time.Now().UTC())
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".json"))
+	writeJSON(w, http.StatusOK, a)
+}
+
+func (f *flowbro) importHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+		return
+	}
+	var a archive
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid archive. err=%v", err))
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if len(name) == 0 {
+		name = a.Name
+	}
+	if err := importArchive(f.dataDir, name, a); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"recording": name, "messages": len(a.Messages)})
+}
@@ -0,0 +1,72 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecordingsCanBeExportedImportedAndReplayed(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+
+	r, err := openRecording(&recordingJSON{Name: "incident"}, dir, json.RawMessage(`{"rules":[]}`), "")
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, topic := range []string{"orders", "payments", "orders"} {
+		m := message{Topic: topic, Offset: int64(i), Key: "a", Value: newValueFrom(`{"id":1}`), Timestamp: start}
+		if err := r.record(m, start.Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("shouldn't have failed recording but did with %v", err)
+		}
+	}
+	r.close()
+
+	from, to := start.Add(time.Second), start.Add(time.Minute)
+	a, err := exportRecording(dir, "incident", &from, &to, start)
+	if err != nil {
+		t.Fatalf("shouldn't have failed exporting but did with %v", err)
+	}
+	if a.Stats.Messages != 2 || a.Stats.Topics["orders"] != 1 || a.Stats.Topics["payments"] != 1 || string(a.Config) != `{"rules":[]}` {
+		t.Errorf("expected the 2 messages from the second on with their config but got %+v", a)
+	}
+
+	byt, _ := json.Marshal(a)
+	var imported archive
+	json.Unmarshal(byt, &imported)
+	if err := importArchive(dir, "copy", imported); err != nil {
+		t.Fatalf("shouldn't have failed importing but did with %v", err)
+	}
+
+	src := &replaySource{dataDir: dir, c: &replayJSON{Recording: "copy", Speed: 1000}}
+	defer src.Close()
+	c, err := src.Start(context.Background())
+	if err != nil {
+		t.Fatalf("shouldn't have failed replaying but did with %v", err)
+	}
+	for _, expected := range []string{"payments", "orders"} {
+		select {
+		case m := <-c:
+			if m.Topic != expected || string(m.Value) != `{"id":1}` {
+				t.Errorf("expected a replayed message on %v but got %+v", expected, *m)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a replayed message on %v", expected)
+		}
+	}
+}
+
+func TestRecordingsRejectUnsafeNames(t *testing.T) {
+	for _, name := range []string{"", "../x", ".hidden"} {
+		if _, err := openRecording(&recordingJSON{Name: name}, os.TempDir(), nil, ""); err == nil {
+			t.Errorf("on '%v': expected an error", name)
+		}
+	}
+	if err := importArchive(os.TempDir(), "x", archive{Version: 99}); err == nil {
+		t.Errorf("expected unknown archive versions to be rejected")
+	}
+}
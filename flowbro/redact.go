@@ -0,0 +1,123 @@
+package flowbro
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+type redactionJSON struct {
+	Topic    string `json:"topic"`
+	Path     string `json:"path"`
+	Strategy string `json:"strategy"`
+	Keep     int    `json:"keep"`
+	Salt     string `json:"salt"`
+}
+
+// redaction hides personal data selected by a JSONPath from the values of the
+// topics matching its regex. Values are redacted as soon as they are decoded,
+// before any other stage sees them, so nothing kept, compared, recorded or
+// sent to the UI, webhooks or sinks carries the original data. Redactions
+// set on the server with withRedactions come first and apply to every
+// connection whatever its config says.
+type redaction struct {
+	redactionJSON
+	topic *regexp.Regexp
+	path  jsonPath
+}
+
+var redactionStrategies = map[string]bool{"drop": true, "hash": true, "mask": true}
+
+const defaultMaskKeep = 4
+
+func processRedactions(redactionsJSON []redactionJSON) ([]redaction, error) {
+	redactions := []redaction{}
+	for _, r := range redactionsJSON {
+		if !redactionStrategies[r.Strategy] {
+			return redactions, fmt.Errorf("Unknown redaction strategy %v for path %v; use drop, hash or mask", r.Strategy, r.Path)
+		}
+		topic, err := regexp.Compile("^(?:" + r.Topic + ")$")
+		if err != nil {
+			return redactions, fmt.Errorf("Invalid topic regex %v for redaction. err=%v", r.Topic, err)
+		}
+		path, err := parseJSONPath(r.Path)
+		if err != nil {
+			return redactions, err
+		}
+		if r.Strategy == "mask" && r.Keep == 0 {
+			r.Keep = defaultMaskKeep
+		}
+		redactions = append(redactions, redaction{redactionJSON: r, topic: topic, path: path})
+	}
+	return redactions, nil
+}
+
+// readRedactions reads the JSON array of redactions in the file at path.
+func readRedactions(path string) ([]redactionJSON, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read redactions. err=%v", err)
+	}
+	var redactions []redactionJSON
+	if err := json.Unmarshal(byt, &redactions); err != nil {
+		return nil, fmt.Errorf("Invalid redactions file %v. err=%v", path, err)
+	}
+	return redactions, nil
+}
+
+// redact applies every redaction matching m's topic to its value. Values
+// that couldn't be decoded lose their raw bytes instead, which can't be
+// redacted.
+func redact(redactions []redaction, m message) message {
+	for _, r := range redactions {
+		if !r.topic.MatchString(m.Topic) || m.Value == nil {
+			continue
+		}
+		if _, ok := m.Value["decodeError"]; ok {
+			delete(m.Value, "raw")
+			continue
+		}
+		r.path.update(m.Value, r.apply)
+	}
+	return m
+}
+
+// redacts tells whether any of redactions applies to topic.
+func redacts(redactions []redaction, topic string) bool {
+	for _, r := range redactions {
+		if r.topic.MatchString(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r redaction) apply(v interface{}) (interface{}, bool) {
+	switch r.Strategy {
+	case "drop":
+		return nil, false
+	case "hash":
+		s, ok := v.(string)
+		if !ok {
+			byt, _ := json.Marshal(v)
+			s = string(byt)
+		}
+		sum := sha256.Sum256([]byte(r.Salt + s))
+		return "sha256:" + hex.EncodeToString(sum[:8]), true
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return "****", true
+	}
+	rs := []rune(s)
+	keep := r.Keep
+	if keep < 0 || keep >= len(rs) {
+		keep = 0
+	}
+	return strings.Repeat("*", len(rs)-keep) + string(rs[len(rs)-keep:]), true
+}
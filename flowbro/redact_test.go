@@ -0,0 +1,83 @@
+package flowbro
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name       string
+		redactions []redactionJSON
+		value      string
+		expected   string
+	}{
+		{
+			name:       "drop a nested field",
+			redactions: []redactionJSON{{Topic: "users", Path: "$.customer.ssn", Strategy: "drop"}},
+			value:      `{"customer":{"name":"Ann","ssn":"123-45-6789"}}`,
+			expected:   `{"customer":{"name":"Ann"}}`,
+		},
+		{
+			name:       "mask keeps the last characters",
+			redactions: []redactionJSON{{Topic: "users", Path: "$.card", Strategy: "mask"}},
+			value:      `{"card":"4111111111111111"}`,
+			expected:   `{"card":"************1111"}`,
+		},
+		{
+			name:       "mask short and non-string values entirely",
+			redactions: []redactionJSON{{Topic: "users", Path: "$['pin']", Strategy: "mask"}, {Topic: "users", Path: "$.age", Strategy: "mask"}},
+			value:      `{"pin":"1234","age":42}`,
+			expected:   `{"pin":"****","age":"****"}`,
+		},
+		{
+			name:       "hash is stable so values still correlate",
+			redactions: []redactionJSON{{Topic: "users", Path: "$.emails[*]", Strategy: "hash"}},
+			value:      `{"emails":["a@b.c","a@b.c"]}`,
+			expected:   `{"emails":["sha256:d648b243a3e817ea","sha256:d648b243a3e817ea"]}`,
+		},
+		{
+			name:       "recursive descent",
+			redactions: []redactionJSON{{Topic: "users", Path: "$..email", Strategy: "drop"}},
+			value:      `{"email":"x","friends":[{"email":"y","name":"Bo"}]}`,
+			expected:   `{"friends":[{"name":"Bo"}]}`,
+		},
+		{
+			name:       "raw values that couldn't be decoded are dropped",
+			redactions: []redactionJSON{{Topic: "users", Path: "$.email", Strategy: "hash"}},
+			value:      `{"raw":"eyJlbWFpbCI6InhAeS56In0=","decodeError":"Schema registry unavailable"}`,
+			expected:   `{"decodeError":"Schema registry unavailable"}`,
+		},
+		{
+			name:       "other topics are left alone",
+			redactions: []redactionJSON{{Topic: "other", Path: "$.email", Strategy: "drop"}},
+			value:      `{"email":"x"}`,
+			expected:   `{"email":"x"}`,
+		},
+	}
+
+	for _, ts := range tests {
+		redactions, err := processRedactions(ts.redactions)
+		if err != nil {
+			t.Errorf("on '%v': shouldn't have failed, but did with %v", ts.name, err)
+			continue
+		}
+		actual := redact(redactions, message{Topic: "users", Value: newValueFrom(ts.value)})
+		if expected := newValueFrom(ts.expected); !reflect.DeepEqual(actual.Value, expected) {
+			t.Errorf("on '%v': expected %v but got %v", ts.name, expected, actual.Value)
+		}
+	}
+}
+
+func TestInvalidRedactions(t *testing.T) {
+	for _, r := range []redactionJSON{
+		{Path: "$.email", Strategy: "shred"},
+		{Path: "email", Strategy: "drop"},
+		{Path: "$.emails[x]", Strategy: "drop"},
+		{Path: "$", Strategy: "drop"},
+	} {
+		if _, err := processRedactions([]redactionJSON{r}); err == nil {
+			t.Errorf("expected %v to be rejected", r)
+		}
+	}
+}
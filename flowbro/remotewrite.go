@@ -0,0 +1,202 @@
+package flowbro
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/golang/snappy"
+)
+
+type remoteWriteJSON struct {
+	URL                string            `json:"url"`
+	IntervalSeconds    float64           `json:"intervalSeconds"`
+	Labels             map[string]string `json:"labels"`
+	Username           string            `json:"username"`
+	Password           string            `json:"password"`
+	BearerToken        string            `json:"bearerToken"`
+	CAFile             string            `json:"caFile"`
+	InsecureSkipVerify bool              `json:"insecureSkipVerify"`
+}
+
+const defaultRemoteWriteInterval = 15 * time.Second
+
+var remoteWrites = newCounter("flowbro_remote_writes_total", "Pushes of metrics via Prometheus remote write, by outcome.", "outcome")
+
+// remoteWrite pushes every metric flowbro exposes on /metrics, including the
+// throughput and latency of each edge of the flow, to a Prometheus remote
+// write endpoint, so short-lived runs still feed long-term dashboards.
+type remoteWrite struct {
+	url      string
+	interval time.Duration
+	labels   [][2]string
+	username string
+	password string
+	token    string
+	client   *http.Client
+	policy   connectionPolicy
+}
+
+func readRemoteWrite(path string) (*remoteWrite, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read remote write config. err=%v", err)
+	}
+	var c remoteWriteJSON
+	if err := json.Unmarshal(byt, &c); err != nil {
+		return nil, fmt.Errorf("Invalid remote write config %v. err=%v", path, err)
+	}
+	return newRemoteWrite(c)
+}
+
+func newRemoteWrite(c remoteWriteJSON) (*remoteWrite, error) {
+	if len(c.URL) == 0 {
+		return nil, fmt.Errorf("Please define the url to remote write to")
+	}
+	if c.IntervalSeconds < 0 {
+		return nil, fmt.Errorf("Invalid remote write intervalSeconds %v; it can't be negative", c.IntervalSeconds)
+	}
+	tlsConfig, err := newTLSConfig(c.CAFile, "", "", c.InsecureSkipVerify)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TLS settings for remote write. err=%v", err)
+	}
+	rw := &remoteWrite{
+		url:      c.URL,
+		interval: defaultRemoteWriteInterval,
+		username: c.Username,
+		password: c.Password,
+		token:    c.BearerToken,
+		client:   &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		policy:   connectionPolicy{retries: 3, initialBackoff: reconnectBase, maxBackoff: reconnectMax},
+	}
+	if c.IntervalSeconds > 0 {
+		rw.interval = time.Duration(c.IntervalSeconds * float64(time.Second))
+	}
+	for name, value := range c.Labels {
+		if name == "__name__" || len(name) == 0 {
+			return nil, fmt.Errorf("Invalid remote write label %q", name)
+		}
+		rw.labels = append(rw.labels, [2]string{name, value})
+	}
+	return rw, nil
+}
+
+// run pushes every interval until ctx is done, and once more then, so the
+// last counts of a run aren't lost.
+func (rw *remoteWrite) run(ctx context.Context) {
+	ticker := time.NewTicker(rw.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rw.push(ctx, time.Now())
+		case <-ctx.Done():
+			final, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+			rw.push(final, time.Now())
+			cancel()
+			return
+		}
+	}
+}
+
+// push sends the current value of every series, retrying connection errors,
+// 429s and 5xxs.
+func (rw *remoteWrite) push(ctx context.Context, now time.Time) error {
+	body := snappy.Encode(nil, encodeWriteRequest(collectMetrics(), rw.labels, now))
+	for attempt := 1; ; attempt++ {
+		retry, err := rw.post(ctx, body)
+		if err == nil {
+			remoteWrites.inc("success")
+			return nil
+		}
+		if !retry || rw.policy.exhausted(attempt) || ctx.Err() != nil || !sleep(ctx, rw.policy.backoff(attempt)) {
+			remoteWrites.inc("failure")
+			log.WithFields(log.Fields{"url": rw.url, "attempts": attempt, "err": err}).Error("Could not remote write metrics.")
+			return err
+		}
+	}
+}
+
+func (rw *remoteWrite) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", rw.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if len(rw.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+rw.token)
+	} else if len(rw.username) > 0 {
+		req.SetBasicAuth(rw.username, rw.password)
+	}
+	r, err := rw.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	r.Body.Close()
+	if r.StatusCode >= 300 {
+		return r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500, fmt.Errorf("%v answered with status %v", rw.url, r.StatusCode)
+	}
+	return false, nil
+}
+
+// encodeWriteRequest encodes series as the protobuf WriteRequest of
+// Prometheus' remote write protocol, each with the extra labels it doesn't
+// have already and a single sample at now:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []promSeries, extra [][2]string, now time.Time) []byte {
+	ms := now.UnixNano() / int64(time.Millisecond)
+	var req []byte
+	for _, s := range series {
+		labels, names := append([][2]string{}, s.labels...), map[string]bool{}
+		for _, l := range s.labels {
+			names[l[0]] = true
+		}
+		for _, l := range extra {
+			if !names[l[0]] {
+				labels = append(labels, l)
+			}
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+		var ts []byte
+		for _, l := range labels {
+			var label []byte
+			label = appendProtoBytes(label, 1, []byte(l[0]))
+			label = appendProtoBytes(label, 2, []byte(l[1]))
+			ts = appendProtoBytes(ts, 1, label)
+		}
+		var sample []byte
+		sample = appendProtoKey(sample, 1, 1)
+		sample = binary.LittleEndian.AppendUint64(sample, math.Float64bits(s.value))
+		sample = appendProtoKey(sample, 2, 0)
+		sample = binary.AppendUvarint(sample, uint64(ms))
+		ts = appendProtoBytes(ts, 2, sample)
+		req = appendProtoBytes(req, 1, ts)
+	}
+	return req
+}
+
+func appendProtoKey(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+// appendProtoBytes appends a length-delimited field.
+func appendProtoBytes(b []byte, field int, value []byte) []byte {
+	b = appendProtoKey(b, field, 2)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
@@ -0,0 +1,180 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// protoFields decodes the fields of a protobuf message, keeping the raw
+// bytes of length-delimited fields and the values of the others.
+func protoFields(t *testing.T, b []byte) (fields []int, values [][]byte) {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		switch key & 7 {
+		case 0:
+			_, n := binary.Uvarint(b)
+			values, b = append(values, b[:n]), b[n:]
+		case 1:
+			values, b = append(values, b[:8]), b[8:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			values, b = append(values, b[n:n+int(l)]), b[n+int(l):]
+		default:
+			t.Fatalf("unexpected wire type %v", key&7)
+		}
+		fields = append(fields, int(key>>3))
+	}
+	return fields, values
+}
+
+// decodeWriteRequest decodes series written by encodeWriteRequest into
+// their sorted labels and single sample.
+func decodeWriteRequest(t *testing.T, b []byte) (labels [][][2]string, samples []float64, timestamps []int64) {
+	_, series := protoFields(t, b)
+	for _, s := range series {
+		fields, values := protoFields(t, s)
+		ls := [][2]string{}
+		for i, f := range fields {
+			if f == 1 {
+				_, l := protoFields(t, values[i])
+				ls = append(ls, [2]string{string(l[0]), string(l[1])})
+				continue
+			}
+			_, sample := protoFields(t, values[i])
+			ts, _ := binary.Uvarint(sample[1])
+			samples = append(samples, math.Float64frombits(binary.LittleEndian.Uint64(sample[0])))
+			timestamps = append(timestamps, int64(ts))
+		}
+		labels = append(labels, ls)
+	}
+	return labels, samples, timestamps
+}
+
+func TestEncodeWriteRequest(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	series := []promSeries{
+		{labels: [][2]string{{"__name__", "edges_total"}, {"source", "shop"}, {"target", "billing"}}, value: 42},
+		{labels: [][2]string{{"__name__", "latency_seconds_sum"}, {"job", "mine"}}, value: 0.5},
+	}
+	labels, samples, timestamps := decodeWriteRequest(t, encodeWriteRequest(series, [][2]string{{"job", "flowbro"}, {"env", "dev"}}, now))
+
+	expected := [][][2]string{
+		{{"__name__", "edges_total"}, {"env", "dev"}, {"job", "flowbro"}, {"source", "shop"}, {"target", "billing"}},
+		{{"__name__", "latency_seconds_sum"}, {"env", "dev"}, {"job", "mine"}},
+	}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("expected sorted labels %v but got %v", expected, labels)
+	}
+	if !reflect.DeepEqual(samples, []float64{42, 0.5}) || !reflect.DeepEqual(timestamps, []int64{1500000000000, 1500000000000}) {
+		t.Errorf("expected samples 42 and 0.5 at 1500000000000 but got %v at %v", samples, timestamps)
+	}
+}
+
+func TestNewRemoteWrite(t *testing.T) {
+	ts := []struct {
+		c                remoteWriteJSON
+		expectedInterval time.Duration
+		fails            bool
+	}{
+		{remoteWriteJSON{URL: "http://prometheus/api/v1/write"}, defaultRemoteWriteInterval, false},
+		{remoteWriteJSON{URL: "http://prometheus/api/v1/write", IntervalSeconds: 2, Labels: map[string]string{"job": "flowbro"}}, 2 * time.Second, false},
+		{remoteWriteJSON{URL: "http://prometheus/api/v1/write", IntervalSeconds: -1}, 0, true},
+		{remoteWriteJSON{URL: "http://prometheus/api/v1/write", Labels: map[string]string{"__name__": "x"}}, 0, true},
+		{remoteWriteJSON{}, 0, true},
+	}
+
+	for _, tc := range ts {
+		rw, err := newRemoteWrite(tc.c)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("on '%+v': expected creating the remote write to fail", tc.c)
+			}
+			continue
+		}
+		if err != nil || rw.interval != tc.expectedInterval {
+			t.Errorf("on '%+v': expected interval %v but got %+v err=%v", tc.c, tc.expectedInterval, rw, err)
+		}
+	}
+}
+
+func TestRemoteWritePushes(t *testing.T) {
+	var l sync.Mutex
+	var bodies [][]byte
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		defer l.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Content-Encoding") != "snappy" || r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		compressed, _ := ioutil.ReadAll(r.Body)
+		body, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		bodies = append(bodies, body)
+	}))
+	defer server.Close()
+
+	rw, err := newRemoteWrite(remoteWriteJSON{URL: server.URL, BearerToken: "secret", Labels: map[string]string{"job": "flowbro"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw.policy.initialBackoff = time.Millisecond
+	edgeMessages.add(3, "remote", "write")
+	if err := rw.push(context.Background(), time.Now()); err != nil {
+		t.Fatalf("shouldn't have failed pushing after a retry but did with %v", err)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if attempts != 2 || len(bodies) != 1 {
+		t.Fatalf("expected a retry and a single push but got %v attempts and %v pushes", attempts, len(bodies))
+	}
+	labels, samples, _ := decodeWriteRequest(t, bodies[0])
+	for i, ls := range labels {
+		if reflect.DeepEqual(ls, [][2]string{{"__name__", "flowbro_flow_edge_messages_total"}, {"job", "flowbro"}, {"source", "remote"}, {"target", "write"}}) {
+			if samples[i] != 3 {
+				t.Errorf("expected 3 messages on the edge but got %v", samples[i])
+			}
+			return
+		}
+	}
+	t.Errorf("expected the edge's series to be pushed but got %v", labels)
+}
+
+func TestRemoteWriteGivesUpOnRejections(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	rw, err := newRemoteWrite(remoteWriteJSON{URL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rw.push(context.Background(), time.Now()); err == nil || !strings.Contains(err.Error(), "400") || attempts != 1 {
+		t.Errorf("expected a single rejected attempt but got %v attempts err=%v", attempts, err)
+	}
+}
@@ -0,0 +1,124 @@
+package flowbro
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// replayFilter only lets through replayed messages matching filter, and
+// re-produces them to produceTo if set. Replays of recordings are filtered
+// throughout; replays from old offsets only until each reseeked partition
+// reaches the offset it was at when it was reseeked.
+type replayFilter struct {
+	filter    *celProgram
+	until     map[string]map[int32]int64
+	produceTo string
+	brokers   []string
+	producers map[string]sarama.SyncProducer
+}
+
+// newReplayFilter returns nil if there's nothing to filter or re-produce;
+// until is nil for recordings.
+func newReplayFilter(filter, produceTo string, until map[string]map[int32]int64, brokers []string) (*replayFilter, error) {
+	if len(filter) == 0 && len(produceTo) == 0 {
+		return nil, nil
+	}
+	f := &replayFilter{until: until, produceTo: produceTo, brokers: brokers, producers: map[string]sarama.SyncProducer{}}
+	if len(filter) > 0 {
+		p, err := compileCEL(filter)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid replay filter. err=%v", err)
+		}
+		f.filter = p
+	}
+	return f, nil
+}
+
+// replayedUntil returns the offsets the reseeked partitions were at.
+func replayedUntil(seeked map[string][]int32, hwms map[string]map[int32]int64) map[string]map[int32]int64 {
+	until := map[string]map[int32]int64{}
+	for topic, ps := range seeked {
+		for _, p := range ps {
+			if hwm, ok := hwms[topic][p]; ok {
+				if _, ok := until[topic]; !ok {
+					until[topic] = map[int32]int64{}
+				}
+				until[topic][p] = hwm
+			}
+		}
+	}
+	return until
+}
+
+func (f *replayFilter) replaying(topic string, partition int32, offset int64) bool {
+	if f.until == nil {
+		return true
+	}
+	end, ok := f.until[topic][partition]
+	if !ok {
+		return false
+	}
+	if offset+1 >= end {
+		delete(f.until[topic], partition)
+		if len(f.until[topic]) == 0 {
+			delete(f.until, topic)
+		}
+	}
+	return offset < end
+}
+
+// keep tells whether m, consumed as cMsg, should go on, re-producing it if
+// configured.
+func (f *replayFilter) keep(cMsg *SourceMessage, m message) (bool, error) {
+	if !f.replaying(cMsg.Topic, cMsg.Partition, cMsg.Offset) {
+		return true, nil
+	}
+	if f.filter != nil {
+		if keep, err := f.filter.match(m); err != nil || !keep {
+			return false, nil
+		}
+	}
+	if len(f.produceTo) > 0 {
+		if err := f.produce(cMsg); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+func (f *replayFilter) produce(cMsg *SourceMessage) error {
+	brokers := f.brokers
+	if len(cMsg.brokers) > 0 {
+		brokers = strings.Split(cMsg.brokers, ",")
+	}
+	key := strings.Join(brokers, ",")
+	producer, ok := f.producers[key]
+	if !ok {
+		var err error
+		if producer, err = newSyncProducer(brokers); err != nil {
+			return fmt.Errorf("Could not create producer to re-produce replayed messages to topic %v. err=%v", f.produceTo, err)
+		}
+		f.producers[key] = producer
+	}
+	_, _, err := producer.SendMessage(&sarama.ProducerMessage{Topic: f.produceTo, Key: sarama.ByteEncoder(cMsg.Key), Value: sarama.ByteEncoder(cMsg.Value)})
+	if err != nil {
+		return fmt.Errorf("Could not re-produce message at offset %v of topic %v to topic %v. err=%v", cMsg.Offset, cMsg.Topic, f.produceTo, err)
+	}
+	return nil
+}
+
+// done tells whether every reseeked partition is past its replay.
+func (f *replayFilter) done() bool {
+	return f.until != nil && len(f.until) == 0
+}
+
+func (f *replayFilter) close() {
+	if f == nil {
+		return
+	}
+	for _, p := range f.producers {
+		p.Close()
+	}
+}
@@ -0,0 +1,75 @@
+package flowbro
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama/mocks"
+)
+
+func TestReplayFilterOnlyFiltersReplayedOffsets(t *testing.T) {
+	f, err := newReplayFilter("value.customerId == 123", "", nil, nil)
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	f.until = replayedUntil(map[string][]int32{"orders": {0, 1}}, map[string]map[int32]int64{"orders": {0: 3, 1: 5, 2: 9}})
+
+	consumed := func(partition int32, offset int64, value string) (*SourceMessage, message) {
+		cMsg := &SourceMessage{Topic: "orders", Partition: partition, Offset: offset, Value: []byte(value)}
+		return cMsg, message{Topic: "orders", Partition: partition, Offset: offset, Value: newValueFrom(value)}
+	}
+	tests := []struct {
+		name      string
+		partition int32
+		offset    int64
+		value     string
+		expected  bool
+		done      bool
+	}{
+		{name: "replayed match", partition: 0, offset: 1, value: `{"customerId":123}`, expected: true},
+		{name: "replayed non match", partition: 0, offset: 2, value: `{"customerId":456}`, expected: false},
+		{name: "partition not reseeked", partition: 2, offset: 1, value: `{"customerId":456}`, expected: true},
+		{name: "partition 0 past its replay", partition: 0, offset: 3, value: `{"customerId":456}`, expected: true},
+		{name: "last replayed offset of partition 1", partition: 1, offset: 4, value: `{"customerId":456}`, expected: false, done: true},
+		{name: "partition 1 past its replay", partition: 1, offset: 5, value: `{"customerId":456}`, expected: true, done: true},
+	}
+	for _, ts := range tests {
+		cMsg, m := consumed(ts.partition, ts.offset, ts.value)
+		keep, err := f.keep(cMsg, m)
+		if err != nil || keep != ts.expected {
+			t.Errorf("on '%v': expected keep to be %v but got %v, err=%v", ts.name, ts.expected, keep, err)
+		}
+		if f.done() != ts.done {
+			t.Errorf("on '%v': expected done to be %v", ts.name, ts.done)
+		}
+	}
+}
+
+func TestReplayFilterReproducesMatchingMessages(t *testing.T) {
+	f, err := newReplayFilter("value.customerId == 123", "orders-replayed", nil, []string{"localhost:9092"})
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndSucceed()
+	f.producers["localhost:9092"] = producer
+	defer f.close()
+
+	for _, v := range []string{`{"customerId":123}`, `{"customerId":456}`} {
+		cMsg := &SourceMessage{Topic: "orders", Value: []byte(v)}
+		if _, err := f.keep(cMsg, message{Topic: "orders", Value: newValueFrom(v)}); err != nil {
+			t.Errorf("shouldn't have failed but did with %v", err)
+		}
+	}
+	if f.done() {
+		t.Errorf("expected replays of recordings to never be done")
+	}
+}
+
+func TestReplayFilterIsOnlyNeededToFilterOrReproduce(t *testing.T) {
+	if f, err := newReplayFilter("", "", nil, nil); f != nil || err != nil {
+		t.Errorf("expected no replay filter but got %+v, err=%v", f, err)
+	}
+	if _, err := newReplayFilter("value.(", "", nil, nil); err == nil {
+		t.Errorf("expected an invalid filter to fail")
+	}
+}
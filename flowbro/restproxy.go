@@ -0,0 +1,284 @@
+package flowbro
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type restProxyJSON struct {
+	URL                string  `json:"url"`
+	Username           string  `json:"username"`
+	Password           string  `json:"password"`
+	APIKey             string  `json:"apiKey"`
+	APISecret          string  `json:"apiSecret"`
+	CAFile             string  `json:"caFile"`
+	CertFile           string  `json:"certFile"`
+	KeyFile            string  `json:"keyFile"`
+	InsecureSkipVerify bool    `json:"insecureSkipVerify"`
+	Group              string  `json:"group"`
+	PollSeconds        float64 `json:"pollSeconds"`
+}
+
+// restProxy is a Confluent REST Proxy that flowbro consumes through, for
+// networks where the brokers themselves are firewalled.
+type restProxy struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+	group    string
+	poll     time.Duration
+	policy   connectionPolicy
+}
+
+// restProxyRecord is a record fetched in the binary embedded format, with
+// its key and value base64 encoded.
+type restProxyRecord struct {
+	Topic     string `json:"topic"`
+	Key       []byte `json:"key"`
+	Value     []byte `json:"value"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"`
+}
+
+type restProxyStatusError struct {
+	status int
+	body   string
+}
+
+func (e restProxyStatusError) Error() string {
+	return fmt.Sprintf("REST proxy answered with status %v: %v", e.status, e.body)
+}
+
+const (
+	restProxyContentType = "application/vnd.kafka.v2+json"
+	restProxyBinary      = "application/vnd.kafka.binary.v2+json"
+	defaultRestProxyPoll = time.Second
+)
+
+func newRestProxy(c *restProxyJSON, policy connectionPolicy, dataDir string) (*restProxy, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if len(c.URL) == 0 {
+		return nil, fmt.Errorf("Please define the url of your REST proxy")
+	}
+	if c.PollSeconds < 0 {
+		return nil, fmt.Errorf("Invalid REST proxy pollSeconds %v; it can't be negative", c.PollSeconds)
+	}
+	url := strings.TrimSuffix(c.URL, "/")
+	if !strings.HasPrefix(url, "http") {
+		url = "http://" + url
+	}
+	tlsConfig, err := dataDirTLSConfig(dataDir, c.CAFile, c.CertFile, c.KeyFile, c.InsecureSkipVerify)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TLS settings for REST proxy. err=%v", err)
+	}
+	p := &restProxy{
+		url:      url,
+		username: c.Username,
+		password: c.Password,
+		client:   &http.Client{Timeout: policy.timeout, Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		group:    c.Group,
+		poll:     defaultRestProxyPoll,
+		policy:   policy,
+	}
+	if len(c.APIKey) > 0 {
+		p.username, p.password = c.APIKey, c.APISecret
+	}
+	if len(p.group) == 0 {
+		p.group = defaultClientId()
+	}
+	if !validClientId.MatchString(p.group) {
+		return nil, fmt.Errorf("Invalid REST proxy group %v; only letters, digits, '.', '_' and '-' are allowed", p.group)
+	}
+	if c.PollSeconds > 0 {
+		p.poll = time.Duration(c.PollSeconds * float64(time.Second))
+	}
+	return p, nil
+}
+
+func (p *restProxy) String() string {
+	return p.url
+}
+
+// restProxySource consumes the topics of config's consumers through a REST
+// proxy consumer instance of its own, which never commits offsets, so every
+// session sees every partition.
+type restProxySource struct {
+	proxy     *restProxy
+	consumers []consumerConfig
+	status    func(event) error
+
+	cluster  string
+	group    string
+	instance string
+	topics   []string
+	reset    string
+	life     *lifecycle
+}
+
+func (s *restProxySource) Start(ctx context.Context) (<-chan *SourceMessage, error) {
+	if err := s.plan(); err != nil {
+		return nil, err
+	}
+	if err := s.subscribe(ctx); err != nil {
+		return nil, fmt.Errorf("Could not consume through REST proxy %v. err=%v", s.proxy, err)
+	}
+	c := make(chan *SourceMessage)
+	s.life = newLifecycle(ctx)
+	s.life.spawn(func(ctx context.Context) { s.fetch(ctx, c) })
+	return c, nil
+}
+
+func (s *restProxySource) Close() {
+	if s.life == nil {
+		return
+	}
+	s.life.stop()
+	s.life.wait()
+	if err := s.delete(); err != nil {
+		log.WithFields(log.Fields{"proxy": s.proxy.url, "instance": s.instance, "err": err}).Warn("Could not delete REST proxy consumer instance.")
+	}
+}
+
+// plan works out the topics to subscribe to and where to start reading
+// them; the proxy's consumer instances can't be assigned single partitions
+// or offsets here, so only whole topics from the oldest or newest offset are
+// supported.
+func (s *restProxySource) plan() error {
+	topics, resets := map[string]bool{}, map[string]bool{}
+	for _, c := range s.consumers {
+		if c.partition >= 0 {
+			return fmt.Errorf("Consumer of topic %v asks for partition %v, but REST proxy sources consume whole topics", c.topic, c.partition)
+		}
+		switch c.offset {
+		case "oldest":
+			resets["earliest"] = true
+		case "newest":
+			resets["latest"] = true
+		default:
+			return fmt.Errorf("Consumer of topic %v asks for offset %v, but REST proxy sources only start at the oldest or newest offset", c.topic, c.offset)
+		}
+		if !topics[c.topic] {
+			topics[c.topic] = true
+			s.topics = append(s.topics, c.topic)
+		}
+		s.cluster = c.cluster
+	}
+	if len(s.topics) == 0 {
+		return fmt.Errorf("Please define consumers for the topics to read through REST proxy %v", s.proxy)
+	}
+	if len(resets) > 1 {
+		return fmt.Errorf("REST proxy sources start all topics at the same offset; please use either oldest or newest")
+	}
+	for r := range resets {
+		s.reset = r
+	}
+	s.group = s.proxy.group + "-" + newId()
+	return nil
+}
+
+// subscribe creates the consumer instance and subscribes it to the topics.
+func (s *restProxySource) subscribe(ctx context.Context) error {
+	s.instance = "flowbro"
+	body := map[string]string{"name": s.instance, "format": "binary", "auto.offset.reset": s.reset, "auto.commit.enable": "false"}
+	if err := s.proxy.do(ctx, "POST", "/consumers/"+s.group, body, nil); err != nil {
+		return err
+	}
+	return s.proxy.do(ctx, "POST", s.base()+"/subscription", map[string][]string{"topics": s.topics}, nil)
+}
+
+func (s *restProxySource) delete() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.proxy.do(ctx, "DELETE", s.base(), nil, nil)
+}
+
+func (s *restProxySource) base() string {
+	return "/consumers/" + s.group + "/instances/" + s.instance
+}
+
+// fetch polls the proxy for records until ctx is done, backing off while it
+// fails and creating the consumer instance again if the proxy dropped it.
+func (s *restProxySource) fetch(ctx context.Context, c chan<- *SourceMessage) {
+	for attempt := 0; ; {
+		records := []restProxyRecord{}
+		err := s.proxy.do(ctx, "GET", fmt.Sprintf("%v/records?timeout=%v", s.base(), int(s.proxy.poll/time.Millisecond)), nil, &records)
+		if se, ok := err.(restProxyStatusError); ok && se.status == http.StatusNotFound {
+			err = s.subscribe(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			attempt++
+			wait := s.proxy.policy.backoff(attempt)
+			log.WithFields(log.Fields{"proxy": s.proxy.url, "attempt": attempt, "wait": wait, "err": err}).Warn("REST proxy unreachable; retrying.")
+			if serr := s.status(event{EventType: "clusterStatus", Text: fmt.Sprintf("REST proxy %v is unreachable (attempt %v); retrying in %v. err=%v", s.proxy, attempt, wait, err), Color: "error", Cluster: s.cluster, Brokers: s.proxy.url}); serr != nil || !sleep(ctx, wait) {
+				return
+			}
+			continue
+		}
+		if attempt > 0 {
+			attempt = 0
+			s.status(event{EventType: "clusterStatus", Text: fmt.Sprintf("Reconnected to REST proxy %v.", s.proxy), Color: "happy", Cluster: s.cluster, Brokers: s.proxy.url})
+		}
+		for _, r := range records {
+			m := &SourceMessage{Topic: r.Topic, Partition: r.Partition, Offset: r.Offset, Key: r.Key, Value: r.Value, cluster: s.cluster, brokers: s.proxy.url}
+			select {
+			case c <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// do sends body as JSON to path on the proxy, decoding the answer into out
+// if set.
+func (p *restProxy) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		byt, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(byt)
+	}
+	req, err := http.NewRequest(method, p.url+path, r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", restProxyContentType)
+	req.Header.Set("Accept", restProxyBinary+", "+restProxyContentType)
+	if len(p.username) > 0 {
+		req.SetBasicAuth(p.username, p.password)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		byt, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return restProxyStatusError{status: resp.StatusCode, body: strings.TrimSpace(string(byt))}
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("Could not decode REST proxy response. err=%v", err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRestProxySourcePlan(t *testing.T) {
+	ts := []struct {
+		consumers      []consumerConfig
+		expectedTopics []string
+		expectedReset  string
+		fails          bool
+	}{
+		{[]consumerConfig{{topic: "a", partition: -1, offset: "newest"}, {topic: "b", partition: -1, offset: "newest"}, {topic: "a", partition: -1, offset: "newest"}}, []string{"a", "b"}, "latest", false},
+		{[]consumerConfig{{topic: "a", partition: -1, offset: "oldest"}}, []string{"a"}, "earliest", false},
+		{[]consumerConfig{{topic: "a", partition: -1, offset: "oldest"}, {topic: "b", partition: -1, offset: "newest"}}, nil, "", true},
+		{[]consumerConfig{{topic: "a", partition: 2, offset: "newest"}}, nil, "", true},
+		{[]consumerConfig{{topic: "a", partition: -1, offset: "42"}}, nil, "", true},
+		{[]consumerConfig{}, nil, "", true},
+	}
+
+	for _, tc := range ts {
+		s := &restProxySource{proxy: &restProxy{url: "http://proxy", group: "flowbro"}, consumers: tc.consumers}
+		err := s.plan()
+		if tc.fails {
+			if err == nil {
+				t.Errorf("on '%+v': expected planning to fail", tc.consumers)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on '%+v': shouldn't have failed but did with %v", tc.consumers, err)
+			continue
+		}
+		if strings.Join(s.topics, ",") != strings.Join(tc.expectedTopics, ",") || s.reset != tc.expectedReset || !strings.HasPrefix(s.group, "flowbro-") {
+			t.Errorf("on '%+v': expected topics %v from %v but got %v from %v in group %v", tc.consumers, tc.expectedTopics, tc.expectedReset, s.topics, s.reset, s.group)
+		}
+	}
+}
+
+func TestNewRestProxy(t *testing.T) {
+	ts := []struct {
+		c           restProxyJSON
+		expectedURL string
+		fails       bool
+	}{
+		{restProxyJSON{URL: "proxy:8082/"}, "http://proxy:8082", false},
+		{restProxyJSON{URL: "https://proxy", Group: "debug", PollSeconds: 2}, "https://proxy", false},
+		{restProxyJSON{URL: "https://proxy", Group: "de bug"}, "", true},
+		{restProxyJSON{URL: "https://proxy", PollSeconds: -1}, "", true},
+		{restProxyJSON{}, "", true},
+	}
+
+	for _, tc := range ts {
+		c := tc.c
+		p, err := newRestProxy(&c, defaultConnectionPolicy, "")
+		if tc.fails {
+			if err == nil {
+				t.Errorf("on '%+v': expected creating the REST proxy to fail", tc.c)
+			}
+			continue
+		}
+		if err != nil || p.url != tc.expectedURL {
+			t.Errorf("on '%+v': expected url %v but got %+v err=%v", tc.c, tc.expectedURL, p, err)
+		}
+	}
+}
+
+// fakeRestProxy serves a consumer instance answering with records once.
+type fakeRestProxy struct {
+	sync.Mutex
+	requests []string
+	served   bool
+}
+
+func (p *fakeRestProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.Lock()
+	defer p.Unlock()
+	p.requests = append(p.requests, r.Method+" "+r.URL.Path)
+	if user, _, _ := r.BasicAuth(); user != "key" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/subscription"):
+		var body map[string][]string
+		json.NewDecoder(r.Body).Decode(&body)
+		if len(body["topics"]) != 1 || body["topics"][0] != "orders" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	case r.Method == "POST":
+		json.NewEncoder(w).Encode(map[string]string{"instance_id": "flowbro", "base_uri": "http://unreachable"})
+	case r.Method == "GET" && !p.served:
+		p.served = true
+		w.Write([]byte(`[{"topic":"orders","key":"azE=","value":"eyJpZCI6MX0=","partition":2,"offset":7},{"topic":"orders","key":null,"value":"eyJpZCI6Mn0=","partition":0,"offset":3}]`))
+	case r.Method == "GET":
+		w.Write([]byte(`[]`))
+	}
+}
+
+func (p *fakeRestProxy) received() []string {
+	p.Lock()
+	defer p.Unlock()
+	return append([]string{}, p.requests...)
+}
+
+func TestRestProxySourceConsumes(t *testing.T) {
+	fake := &fakeRestProxy{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	proxy, err := newRestProxy(&restProxyJSON{URL: server.URL, APIKey: "key", Group: "debug", PollSeconds: 0.01}, defaultConnectionPolicy, "")
+	if err != nil {
+		t.Fatalf("shouldn't have failed creating the REST proxy but did with %v", err)
+	}
+	s := &restProxySource{proxy: proxy, consumers: []consumerConfig{{cluster: "eu", topic: "orders", partition: -1, offset: "newest"}}, status: func(event) error { return nil }}
+	c, err := s.Start(context.Background())
+	if err != nil {
+		t.Fatalf("shouldn't have failed starting the source but did with %v", err)
+	}
+
+	for _, expected := range []struct {
+		key, value string
+		partition  int32
+		offset     int64
+	}{{"k1", `{"id":1}`, 2, 7}, {"", `{"id":2}`, 0, 3}} {
+		select {
+		case m := <-c:
+			if string(m.Key) != expected.key || string(m.Value) != expected.value || m.Partition != expected.partition || m.Offset != expected.offset || m.cluster != "eu" {
+				t.Errorf("expected %+v but got %+v from cluster %v", expected, *m, m.cluster)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a message for %+v", expected)
+		}
+	}
+	s.Close()
+
+	requests := fake.received()
+	base := "/consumers/" + s.group + "/instances/flowbro"
+	if requests[0] != "POST /consumers/"+s.group || requests[1] != "POST "+base+"/subscription" || requests[2] != "GET "+base+"/records" {
+		t.Errorf("expected to create an instance, subscribe and fetch records but got %v", requests)
+	}
+	if last := requests[len(requests)-1]; last != "DELETE "+base {
+		t.Errorf("expected the instance to be deleted on close but got %v", requests)
+	}
+}
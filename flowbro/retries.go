@@ -0,0 +1,82 @@
+package flowbro
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+type retryTopicsJSON struct {
+	Retry      string `json:"retry"`
+	DeadLetter string `json:"deadLetter"`
+}
+
+// retryTopics groups retry and dead letter topics, e.g. orders-retry-1 and
+// orders-dlq, with the topic they retry, so they show up as the same edges.
+// Messages keep their actual topic in the topic tag, and how often they were
+// retried in the retry tag, or deadLetter if they gave up.
+type retryTopics struct {
+	retry      *regexp.Regexp
+	deadLetter *regexp.Regexp
+}
+
+const (
+	defaultRetrySuffix      = `-retry(?:-(\d+))?`
+	defaultDeadLetterSuffix = `-(?:dlq|dlt)`
+)
+
+var retriedMessages = newCounter("flowbro_retried_messages_total", "Messages consumed from retry and dead letter topics.", "topic", "retry")
+
+func processRetryTopics(c *retryTopicsJSON) (*retryTopics, error) {
+	if c == nil {
+		return nil, nil
+	}
+	retry, deadLetter := c.Retry, c.DeadLetter
+	if len(retry) == 0 {
+		retry = defaultRetrySuffix
+	}
+	if len(deadLetter) == 0 {
+		deadLetter = defaultDeadLetterSuffix
+	}
+
+	r := &retryTopics{}
+	var err error
+	if r.retry, err = regexp.Compile("^(.+?)(?:" + retry + ")$"); err != nil {
+		return nil, fmt.Errorf("Invalid retry topic suffix %v. err=%v", retry, err)
+	}
+	if r.deadLetter, err = regexp.Compile("^(.+?)(?:" + deadLetter + ")$"); err != nil {
+		return nil, fmt.Errorf("Invalid dead letter topic suffix %v. err=%v", deadLetter, err)
+	}
+	return r, nil
+}
+
+func (r *retryTopics) group(m message) message {
+	retry := ""
+	if match := r.deadLetter.FindStringSubmatch(m.Topic); match != nil {
+		retry = "deadLetter"
+		m = retagTopic(m, match[1])
+	} else if match := r.retry.FindStringSubmatch(m.Topic); match != nil {
+		retry = "1"
+		for _, g := range match[2:] {
+			if n, err := strconv.Atoi(g); err == nil {
+				retry = strconv.Itoa(n)
+			}
+		}
+		m = retagTopic(m, match[1])
+	}
+	if len(retry) == 0 {
+		return m
+	}
+	m.Tags["retry"] = retry
+	retriedMessages.inc(m.Topic, retry)
+	return m
+}
+
+func retagTopic(m message, topic string) message {
+	tags := map[string]string{"topic": m.Topic}
+	for k, v := range m.Tags {
+		tags[k] = v
+	}
+	m.Tags, m.Topic = tags, topic
+	return m
+}
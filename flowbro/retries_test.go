@@ -0,0 +1,45 @@
+package flowbro
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRetryTopicsGroup(t *testing.T) {
+	r, err := processRetryTopics(&retryTopicsJSON{})
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+
+	tests := []struct {
+		topic         string
+		expectedTopic string
+		expectedTags  map[string]string
+	}{
+		{topic: "orders", expectedTopic: "orders"},
+		{topic: "orders-retry", expectedTopic: "orders", expectedTags: map[string]string{"topic": "orders-retry", "retry": "1"}},
+		{topic: "orders-retry-3", expectedTopic: "orders", expectedTags: map[string]string{"topic": "orders-retry-3", "retry": "3"}},
+		{topic: "orders-dlq", expectedTopic: "orders", expectedTags: map[string]string{"topic": "orders-dlq", "retry": "deadLetter"}},
+		{topic: "payments-retry-2-dlt", expectedTopic: "payments-retry-2", expectedTags: map[string]string{"topic": "payments-retry-2-dlt", "retry": "deadLetter"}},
+	}
+
+	for _, ts := range tests {
+		m := r.group(message{Topic: ts.topic})
+		if m.Topic != ts.expectedTopic || !reflect.DeepEqual(m.Tags, ts.expectedTags) {
+			t.Errorf("on '%v': expected topic %v with tags %v but got %v with %v", ts.topic, ts.expectedTopic, ts.expectedTags, m.Topic, m.Tags)
+		}
+	}
+}
+
+func TestCustomRetryTopics(t *testing.T) {
+	r, err := processRetryTopics(&retryTopicsJSON{Retry: `\.retry\.(\d+)`, DeadLetter: `\.DLQ`})
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	if m := r.group(message{Topic: "orders.retry.2", Tags: map[string]string{"a": "b"}}); m.Topic != "orders" || m.Tags["retry"] != "2" || m.Tags["a"] != "b" {
+		t.Errorf("unexpected grouping %+v", m)
+	}
+	if _, err := processRetryTopics(&retryTopicsJSON{Retry: "("}); err == nil {
+		t.Errorf("expected an invalid retry suffix to fail")
+	}
+}
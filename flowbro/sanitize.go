@@ -0,0 +1,208 @@
+package flowbro
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type sanitizeJSON struct {
+	Mode           string `json:"mode"`
+	Replacement    string `json:"replacement"`
+	KeepWhitespace bool   `json:"keepWhitespace"`
+}
+
+var sanitizeModes = map[string]bool{"": true, "escape": true, "replace": true, "base64": true, "none": true}
+
+// sanitizer rewrites the strings of events that hold control characters or
+// invalid UTF-8, which break the UI's rendering: escape turns them into
+// visible escapes like \n, \x00 or \xff (doubling the string's backslashes
+// so it stays unambiguous), replace swaps each for the replacement and
+// base64 encodes the whole string, prefixed with base64:. Clean strings are
+// left untouched.
+type sanitizer struct {
+	mode           string
+	replacement    string
+	keepWhitespace bool
+}
+
+const base64Prefix = "base64:"
+
+func processSanitize(c *sanitizeJSON) (*sanitizer, error) {
+	if c == nil {
+		c = &sanitizeJSON{}
+	}
+	if !sanitizeModes[c.Mode] {
+		return nil, fmt.Errorf("Unknown sanitize mode %v; use escape, replace, base64 or none", c.Mode)
+	}
+	if c.Mode == "none" {
+		return nil, nil
+	}
+	s := &sanitizer{mode: c.Mode, replacement: c.Replacement, keepWhitespace: c.KeepWhitespace}
+	if len(s.mode) == 0 {
+		s.mode = "escape"
+	}
+	if s.mode == "replace" && len(s.replacement) == 0 {
+		s.replacement = string(utf8.RuneError)
+	}
+	if s.dirty(s.replacement) {
+		return nil, fmt.Errorf("Invalid sanitize replacement %q; it must be printable UTF-8", s.replacement)
+	}
+	return s, nil
+}
+
+// unsafe reports whether r, a rune of a string or utf8.RuneError for an
+// invalid byte, should be sanitized.
+func (s *sanitizer) unsafe(r rune, size int) bool {
+	switch {
+	case r == utf8.RuneError && size <= 1:
+		return true
+	case s.keepWhitespace && (r == '\t' || r == '\n' || r == '\r'):
+		return false
+	}
+	return r < 0x20 || r == 0x7f || (r >= 0x80 && r <= 0x9f)
+}
+
+func (s *sanitizer) dirty(str string) bool {
+	for i := 0; i < len(str); {
+		r, size := utf8.DecodeRuneInString(str[i:])
+		if s.unsafe(r, size) {
+			return true
+		}
+		i += size
+	}
+	return false
+}
+
+func (s *sanitizer) string(str string) string {
+	if s == nil || !s.dirty(str) {
+		return str
+	}
+	if s.mode == "base64" {
+		return base64Prefix + base64.StdEncoding.EncodeToString([]byte(str))
+	}
+	var b strings.Builder
+	for i := 0; i < len(str); {
+		r, size := utf8.DecodeRuneInString(str[i:])
+		switch {
+		case !s.unsafe(r, size):
+			if r == '\\' && s.mode == "escape" {
+				b.WriteString(`\\`)
+			} else {
+				b.WriteString(str[i : i+size])
+			}
+		case s.mode == "replace":
+			b.WriteString(s.replacement)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == utf8.RuneError || r < 0x80:
+			fmt.Fprintf(&b, `\x%02x`, str[i])
+		default:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// value sanitizes the strings, and object keys, of a decoded JSON value,
+// copying only the maps and slices that change so messages still buffered
+// or held by tables keep their values.
+func (s *sanitizer) value(v interface{}) (interface{}, bool) {
+	switch t := v.(type) {
+	case string:
+		clean := s.string(t)
+		return clean, clean != t
+	case map[string]interface{}:
+		m, changed := s.object(t)
+		return m, changed
+	case []interface{}:
+		var copied []interface{}
+		for i, e := range t {
+			clean, changed := s.value(e)
+			if !changed {
+				continue
+			}
+			if copied == nil {
+				copied = append([]interface{}{}, t...)
+			}
+			copied[i] = clean
+		}
+		if copied == nil {
+			return t, false
+		}
+		return copied, true
+	}
+	return v, false
+}
+
+func (s *sanitizer) object(m map[string]interface{}) (map[string]interface{}, bool) {
+	changed := false
+	for k, e := range m {
+		if _, c := s.value(e); c || s.dirty(k) {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return m, false
+	}
+	copied := make(map[string]interface{}, len(m))
+	for k, e := range m {
+		copied[s.string(k)], _ = s.value(e)
+	}
+	return copied, true
+}
+
+// events sanitizes the strings of events in place.
+func (s *sanitizer) events(events []event) {
+	if s == nil {
+		return
+	}
+	for i := range events {
+		e := &events[i]
+		e.EventType, e.SourceId, e.TargetId = s.string(e.EventType), s.string(e.SourceId), s.string(e.TargetId)
+		e.Text, e.FSMId, e.FSMIdAlias = s.string(e.Text), s.string(e.FSMId), s.string(e.FSMIdAlias)
+		if e.Key != nil {
+			e.Key, _ = s.value(e.Key)
+		}
+		if e.JSON != nil {
+			json := make([]map[string]interface{}, len(e.JSON))
+			for j, v := range e.JSON {
+				json[j], _ = s.object(v)
+			}
+			e.JSON = json
+		}
+		if e.Tags != nil {
+			tags := make(map[string]string, len(e.Tags))
+			for k, v := range e.Tags {
+				tags[s.string(k)] = s.string(v)
+			}
+			e.Tags = tags
+		}
+		if e.Diff != nil {
+			diff := make([]valueChange, len(e.Diff))
+			for j, c := range e.Diff {
+				c.Path = s.string(c.Path)
+				c.From, _ = s.value(c.From)
+				c.To, _ = s.value(c.To)
+				diff[j] = c
+			}
+			e.Diff = diff
+		}
+		if e.Rows != nil {
+			rows := make([]tableRow, len(e.Rows))
+			for j, r := range e.Rows {
+				r.Key = s.string(r.Key)
+				r.Value, _ = s.object(r.Value)
+				rows[j] = r
+			}
+			e.Rows = rows
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package flowbro
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/quick"
+	"unicode/utf8"
+)
+
+func TestSanitizeString(t *testing.T) {
+	ts := []struct {
+		c        sanitizeJSON
+		s        string
+		expected string
+	}{
+		{sanitizeJSON{}, "clean café \\ path", "clean café \\ path"},
+		{sanitizeJSON{}, "a\tb\nc\r\x00", `a\tb\nc\r\x00`},
+		{sanitizeJSON{}, "C:\\dir\n", `C:\\dir\n`},
+		{sanitizeJSON{}, "bad \xff\xfe byte", `bad \xff\xfe byte`},
+		{sanitizeJSON{}, "c1 \u0085 del \x7f", `c1 \u0085 del \x7f`},
+		{sanitizeJSON{KeepWhitespace: true}, "a\tb\n\x01", "a\tb\n\\x01"},
+		{sanitizeJSON{Mode: "replace"}, "a\x00b\xff", "a\ufffdb\ufffd"},
+		{sanitizeJSON{Mode: "replace", Replacement: "?"}, "C:\\dir\n", "C:\\dir?"},
+		{sanitizeJSON{Mode: "base64"}, "a\x00", "base64:YQA="},
+		{sanitizeJSON{Mode: "base64"}, "clean", "clean"},
+	}
+
+	for _, tc := range ts {
+		s, err := processSanitize(&tc.c)
+		if err != nil {
+			t.Errorf("on '%+v': shouldn't have failed but did with %v", tc.c, err)
+			continue
+		}
+		if actual := s.string(tc.s); actual != tc.expected {
+			t.Errorf("on '%+v' %q: expected %q but got %q", tc.c, tc.s, tc.expected, actual)
+		}
+	}
+}
+
+func TestProcessSanitize(t *testing.T) {
+	ts := []struct {
+		c        *sanitizeJSON
+		expected *sanitizer
+		fails    bool
+	}{
+		{nil, &sanitizer{mode: "escape"}, false},
+		{&sanitizeJSON{Mode: "replace"}, &sanitizer{mode: "replace", replacement: "\ufffd"}, false},
+		{&sanitizeJSON{Mode: "none"}, nil, false},
+		{&sanitizeJSON{Mode: "strip"}, nil, true},
+		{&sanitizeJSON{Mode: "replace", Replacement: "\x00"}, nil, true},
+	}
+
+	for _, tc := range ts {
+		s, err := processSanitize(tc.c)
+		if tc.fails != (err != nil) || !reflect.DeepEqual(s, tc.expected) {
+			t.Errorf("on '%+v': expected %+v (failing %v) but got %+v, err=%v", tc.c, tc.expected, tc.fails, s, err)
+		}
+	}
+}
+
+// unescape reverses the escape mode, for checking it loses nothing.
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'x':
+			n, _ := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			b.WriteByte(byte(n))
+			i += 2
+		case 'u':
+			n, _ := strconv.ParseUint(s[i+1:i+5], 16, 32)
+			b.WriteRune(rune(n))
+			i += 4
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func TestSanitizedStringsAreSafe(t *testing.T) {
+	for _, c := range []sanitizeJSON{{}, {KeepWhitespace: true}, {Mode: "replace"}, {Mode: "base64"}} {
+		s, _ := processSanitize(&c)
+		safe := func(b []byte) bool {
+			out := s.string(string(b))
+			return utf8.ValidString(out) && !s.dirty(out)
+		}
+		if err := quick.Check(safe, nil); err != nil {
+			t.Errorf("on '%+v': expected sanitized strings to be valid UTF-8 without control characters. err=%v", c, err)
+		}
+	}
+}
+
+func TestSanitizingLosesNothing(t *testing.T) {
+	escape, _ := processSanitize(&sanitizeJSON{Mode: "escape"})
+	escapes := func(b []byte) bool {
+		in := string(b)
+		return !escape.dirty(in) || unescape(escape.string(in)) == in
+	}
+	if err := quick.Check(escapes, nil); err != nil {
+		t.Errorf("expected escaped strings to unescape to the original. err=%v", err)
+	}
+
+	b64, _ := processSanitize(&sanitizeJSON{Mode: "base64"})
+	encodes := func(b []byte) bool {
+		in, out := string(b), b64.string(string(b))
+		if !b64.dirty(in) {
+			return out == in
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(out, base64Prefix))
+		return err == nil && string(decoded) == in
+	}
+	if err := quick.Check(encodes, nil); err != nil {
+		t.Errorf("expected base64 strings to decode to the original. err=%v", err)
+	}
+
+	cleanIsUntouched := func(in string) bool {
+		return escape.dirty(in) || escape.string(in) == in
+	}
+	if err := quick.Check(cleanIsUntouched, nil); err != nil {
+		t.Errorf("expected clean strings to be left untouched. err=%v", err)
+	}
+}
+
+func TestSanitizeEvents(t *testing.T) {
+	s, _ := processSanitize(nil)
+	value := map[string]interface{}{"name": "a\x00b", "nested": map[string]interface{}{"k\n": []interface{}{"ok", "\xff", 1.0}}, "clean": "yes"}
+	clean := map[string]interface{}{"clean": "yes"}
+	events := []event{{
+		EventType: "message",
+		Text:      "line\nbreak",
+		JSON:      []map[string]interface{}{value, clean},
+		Key:       "\x01",
+		Tags:      map[string]string{"t": "\t"},
+		Rows:      []tableRow{{Key: "\x02", Value: value}},
+	}}
+	s.events(events)
+
+	expectedValue := map[string]interface{}{"name": `a\x00b`, "nested": map[string]interface{}{`k\n`: []interface{}{"ok", `\xff`, 1.0}}, "clean": "yes"}
+	e := events[0]
+	if e.Text != `line\nbreak` || e.Key != `\x01` || e.Tags["t"] != `\t` || e.Rows[0].Key != `\x02` {
+		t.Errorf("expected the event's strings to be escaped but got %+v", e)
+	}
+	if !reflect.DeepEqual(e.JSON[0], expectedValue) || !reflect.DeepEqual(e.Rows[0].Value, expectedValue) {
+		t.Errorf("expected the values to be escaped but got %v", e.JSON[0])
+	}
+	if value["name"] != "a\x00b" {
+		t.Errorf("expected the message's own value to be left untouched but got %v", value)
+	}
+	if _, err := json.Marshal(events); err != nil {
+		t.Errorf("expected sanitized events to marshal but got err=%v", err)
+	}
+
+	var none *sanitizer
+	raw := []event{{Text: "\x00"}}
+	none.events(raw)
+	if raw[0].Text != "\x00" {
+		t.Errorf("expected events to be left alone without a sanitizer but got %q", raw[0].Text)
+	}
+}
@@ -0,0 +1,365 @@
+package flowbro
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type schemaRegistryJSON struct {
+	URL                string `json:"url"`
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+	APIKey             string `json:"apiKey"`
+	APISecret          string `json:"apiSecret"`
+	CAFile             string `json:"caFile"`
+	CertFile           string `json:"certFile"`
+	KeyFile            string `json:"keyFile"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+	CacheDir           string `json:"cacheDir"`
+}
+
+type registrySchema struct {
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType"`
+
+	avro  *avroSchema
+	proto *protoFile
+}
+
+// schemaRegistry fetches schemas by id from a Confluent-compatible registry.
+// Schemas never change for a given id, so they are cached in memory and,
+// optionally, on disk in the data directory. Schemas are fetched in the
+// background, and messages that can't wait for theirs are emitted raw, as are
+// those that come while the registry is considered unavailable after a failed
+// request, so a registry outage degrades decoding instead of stalling the
+// pipeline on timeouts.
+type schemaRegistry struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+	cacheDir string
+	wait     time.Duration // how long messages wait for their schema
+
+	l                sync.Mutex
+	schemas          map[int32]*registrySchema
+	fetches          map[int32]*schemaFetch
+	unavailableUntil time.Time
+}
+
+// schemaFetch is a schema being fetched; s and err are set once done is
+// closed.
+type schemaFetch struct {
+	done chan struct{}
+	s    *registrySchema
+	err  error
+}
+
+type registryUnavailableError struct {
+	err error
+}
+
+func (e registryUnavailableError) Error() string {
+	return fmt.Sprintf("Schema registry unavailable. err=%v", e.err)
+}
+
+const (
+	registryRetryAfter = 30 * time.Second
+	registryWait       = 200 * time.Millisecond
+)
+
+// newSchemaRegistry makes a client of the registry c configures. Clients send
+// these settings, so the cache directory and the TLS files are only names of
+// ones in the data directory.
+func newSchemaRegistry(c schemaRegistryJSON, dataDir string) (*schemaRegistry, error) {
+	if len(c.URL) == 0 {
+		return nil, fmt.Errorf("Please define the url of your schema registry")
+	}
+	url := strings.TrimSuffix(c.URL, "/")
+	if !strings.HasPrefix(url, "http") {
+		url = "http://" + url
+	}
+
+	tlsConfig, err := dataDirTLSConfig(dataDir, c.CAFile, c.CertFile, c.KeyFile, c.InsecureSkipVerify)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TLS settings for schema registry. err=%v", err)
+	}
+
+	r := &schemaRegistry{
+		url:      url,
+		username: c.Username,
+		password: c.Password,
+		client:   &http.Client{Timeout: time.Duration(5 * time.Second), Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		wait:     registryWait,
+		schemas:  map[int32]*registrySchema{},
+		fetches:  map[int32]*schemaFetch{},
+	}
+	if len(c.APIKey) > 0 {
+		r.username, r.password = c.APIKey, c.APISecret
+	}
+	if len(c.CacheDir) > 0 {
+		if !safeName(c.CacheDir) {
+			return nil, fmt.Errorf("Invalid schema cache dir %v; schemas are cached in a directory of the data directory", c.CacheDir)
+		}
+		sum := sha1.Sum([]byte(url))
+		r.cacheDir = filepath.Join(dataDir, c.CacheDir, hex.EncodeToString(sum[:4]))
+	}
+	return r, nil
+}
+
+func newTLSConfig(caFile, certFile, keyFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	c := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if len(caFile) > 0 {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in %v", caFile)
+		}
+	}
+	if len(certFile) > 0 || len(keyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// dataDirTLSConfig is newTLSConfig for settings clients send, whose files
+// must be named ones in the data directory.
+func dataDirTLSConfig(dataDir, caFile, certFile, keyFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	for _, f := range []*string{&caFile, &certFile, &keyFile} {
+		if len(*f) == 0 {
+			continue
+		}
+		if !safeName(*f) {
+			return nil, fmt.Errorf("Invalid TLS file %v; TLS files are read from the data directory", *f)
+		}
+		*f = filepath.Join(dataDir, *f)
+	}
+	return newTLSConfig(caFile, certFile, keyFile, insecureSkipVerify)
+}
+
+// schema returns the schema of id, waiting at most r.wait for it to be
+// fetched; the fetch goes on in the background, so later messages get it.
+func (r *schemaRegistry) schema(id int32) (*registrySchema, error) {
+	r.l.Lock()
+	if s, ok := r.schemas[id]; ok {
+		r.l.Unlock()
+		return s, nil
+	}
+	f, ok := r.fetches[id]
+	if !ok {
+		f = &schemaFetch{done: make(chan struct{})}
+		r.fetches[id] = f
+		go r.load(id, f)
+	}
+	r.l.Unlock()
+
+	select {
+	case <-f.done:
+		return f.s, f.err
+	case <-time.After(r.wait):
+		return nil, registryUnavailableError{fmt.Errorf("still fetching schema %v", id)}
+	}
+}
+
+// load reads schema id from the disk cache or else the registry, without
+// holding r.l, and hands it to whoever waits on f.
+func (r *schemaRegistry) load(id int32, f *schemaFetch) {
+	s, err := r.readCache(id)
+	if err != nil {
+		s, err = r.fetchUnlessUnavailable(id)
+		if err == nil {
+			r.writeCache(id, s)
+		}
+	}
+	if err == nil {
+		err = parseRegistrySchema(id, s)
+	}
+
+	r.l.Lock()
+	if err == nil {
+		r.schemas[id] = s
+	}
+	delete(r.fetches, id)
+	r.l.Unlock()
+	f.s, f.err = s, err
+	close(f.done)
+}
+
+func (r *schemaRegistry) fetchUnlessUnavailable(id int32) (*registrySchema, error) {
+	r.l.Lock()
+	until := r.unavailableUntil
+	r.l.Unlock()
+	if time.Now().Before(until) {
+		return nil, registryUnavailableError{fmt.Errorf("retrying after %v", until.Format(time.RFC3339))}
+	}
+	s, err := r.fetch(id)
+	if _, ok := err.(registryUnavailableError); ok {
+		r.l.Lock()
+		r.unavailableUntil = time.Now().Add(registryRetryAfter)
+		r.l.Unlock()
+	}
+	return s, err
+}
+
+func parseRegistrySchema(id int32, s *registrySchema) error {
+	var err error
+	switch s.SchemaType {
+	case "", "AVRO":
+		s.avro, err = parseAvroSchema(s.Schema)
+	case "PROTOBUF":
+		s.proto, err = parseProtoSchema(s.Schema)
+	case "JSON":
+	default:
+		err = fmt.Errorf("Schema %v is of unsupported type %v", id, s.SchemaType)
+	}
+	return err
+}
+
+func (r *schemaRegistry) fetch(id int32) (*registrySchema, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%v/schemas/ids/%v", r.url, id), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if len(r.username) > 0 {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, registryUnavailableError{err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("Schema %v not found on registry %v", id, r.url)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, registryUnavailableError{fmt.Errorf("registry %v rejected credentials with status %v", r.url, resp.StatusCode)}
+	case resp.StatusCode >= 300:
+		return nil, registryUnavailableError{fmt.Errorf("registry %v answered with status %v", r.url, resp.StatusCode)}
+	}
+
+	var s registrySchema
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("Invalid response from schema registry %v. err=%v", r.url, err)
+	}
+	return &s, nil
+}
+
+func (r *schemaRegistry) cachePath(id int32) string {
+	return filepath.Join(r.cacheDir, fmt.Sprintf("%v.json", id))
+}
+
+func (r *schemaRegistry) readCache(id int32) (*registrySchema, error) {
+	if len(r.cacheDir) == 0 {
+		return nil, fmt.Errorf("schema cache is disabled")
+	}
+	raw, err := ioutil.ReadFile(r.cachePath(id))
+	if err != nil {
+		return nil, err
+	}
+	var s registrySchema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *schemaRegistry) writeCache(id int32, s *registrySchema) {
+	if len(r.cacheDir) == 0 {
+		return
+	}
+	byt, err := json.Marshal(s)
+	if err == nil {
+		if err = os.MkdirAll(r.cacheDir, 0755); err == nil {
+			err = ioutil.WriteFile(r.cachePath(id), byt, 0644)
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"err": err, "id": id, "dir": r.cacheDir}).Warn("Failed to cache schema on disk.")
+	}
+}
+
+// decodeConfluent decodes a value framed in Confluent's wire format: a zero
+// magic byte and a big-endian schema id followed by the encoded payload. The
+// registry tells whether the payload is Avro, Protobuf or JSON Schema.
+func decodeConfluent(b []byte, r *schemaRegistry) (map[string]interface{}, error) {
+	v, err := decodeConfluentAny(b, r)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Value is not a record: %v", v)
+	}
+	return m, nil
+}
+
+// decodeConfluentAny is decodeConfluent for any value the schema allows, e.g.
+// keys of primitive schemas such as "string" or "long".
+func decodeConfluentAny(b []byte, r *schemaRegistry) (interface{}, error) {
+	if len(b) < 5 || b[0] != 0 {
+		return nil, fmt.Errorf("Value is not in schema registry wire format")
+	}
+	if r == nil {
+		return nil, fmt.Errorf("Please configure schemaRegistry to decode schema registry framed values")
+	}
+
+	id := int32(binary.BigEndian.Uint32(b[1:5]))
+	s, err := r.schema(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch s.SchemaType {
+	case "PROTOBUF":
+		indexes, payload, err := confluentProtoIndexes(b[5:])
+		if err != nil {
+			return nil, err
+		}
+		m, err := s.proto.messageAt(indexes)
+		if err != nil {
+			return nil, err
+		}
+		return protoToMap(s.proto, m, payload)
+	case "JSON":
+		var v interface{}
+		if err := json.Unmarshal(b[5:], &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return avroDecode(s.avro, b[5:])
+}
+
+// rawValue is what's emitted instead of a decoded value when decoding can't
+// currently happen, so messages still show up flagged with the reason.
+func rawValue(b []byte, err error) map[string]interface{} {
+	return map[string]interface{}{
+		"raw":         base64.StdEncoding.EncodeToString(b),
+		"decodeError": err.Error(),
+	}
+}
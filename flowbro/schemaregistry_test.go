@@ -0,0 +1,230 @@
+package flowbro
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testAvroSchema = `{"type":"record","name":"Order","namespace":"shop","fields":[
+	{"name":"id","type":"long"},
+	{"name":"status","type":{"type":"enum","name":"Status","symbols":["OK","ERROR"]}},
+	{"name":"note","type":["null","string"]},
+	{"name":"tags","type":{"type":"array","items":"string"}}
+]}`
+
+var testAvroValue = []byte{0, 0, 0, 0, 7, 0x54, 0x02, 0x02, 0x04, 'h', 'i', 0x02, 0x02, 'a', 0x00}
+
+// patientRegistry is a registry whose messages wait for their schemas, so that
+// tests don't depend on how fast the test registry answers.
+func patientRegistry(c schemaRegistryJSON, dataDir string) (*schemaRegistry, error) {
+	r, err := newSchemaRegistry(c, dataDir)
+	if err == nil {
+		r.wait = time.Minute
+	}
+	return r, err
+}
+
+func TestSchemaRegistryDecodesAvroWithAuthAndCache(t *testing.T) {
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if u, p, ok := r.BasicAuth(); !ok || u != "key" || p != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/schemas/ids/7" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"schema": testAvroSchema})
+	}))
+
+	dataDir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dataDir)
+
+	r, err := patientRegistry(schemaRegistryJSON{URL: s.URL, APIKey: "key", APISecret: "secret", CacheDir: "schemas"}, dataDir)
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+
+	expected := map[string]interface{}{"id": int64(42), "status": "ERROR", "note": "hi", "tags": []interface{}{"a"}}
+	actual, err := decodeValue(testAvroValue, decoding{format: "avro", registry: r})
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %+v but got %+v", expected, actual)
+	}
+	decodeValue(testAvroValue, decoding{format: "avro", registry: r})
+	if requests != 1 {
+		t.Errorf("expected schema to be fetched once, but it was fetched %v times", requests)
+	}
+
+	// a fresh client with the registry down still decodes thanks to the disk cache
+	s.Close()
+	r, _ = patientRegistry(schemaRegistryJSON{URL: s.URL, CacheDir: "schemas"}, dataDir)
+	if actual, err := decodeValue(testAvroValue, decoding{format: "avro", registry: r}); err != nil || !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %+v from the disk cache but got %+v, err=%v", expected, actual, err)
+	}
+}
+
+func TestSchemaRegistryDegradesWhenUnavailable(t *testing.T) {
+	s := httptest.NewServer(http.NotFoundHandler())
+	s.Close()
+
+	r, _ := patientRegistry(schemaRegistryJSON{URL: s.URL}, "")
+	actual, err := decodeValue(testAvroValue, decoding{format: "avro", registry: r})
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	if _, ok := actual["decodeError"]; !ok || actual["raw"] != "AAAAAAdUAgIEaGkCAmEA" {
+		t.Errorf("expected raw value flagged with a decode error but got %+v", actual)
+	}
+}
+
+func TestSchemaRegistryDoesNotStallOnSlowFetches(t *testing.T) {
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		writeJSON(w, http.StatusOK, map[string]string{"schema": testAvroSchema})
+	}))
+	defer s.Close()
+
+	r, _ := newSchemaRegistry(schemaRegistryJSON{URL: s.URL}, "")
+	start := time.Now()
+	actual, err := decodeValue(testAvroValue, decoding{format: "avro", registry: r})
+	if err != nil || actual["decodeError"] == nil {
+		t.Errorf("expected raw value flagged with a decode error while fetching but got %+v, err=%v", actual, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("expected decoding not to wait for the registry, but it took %v", time.Since(start))
+	}
+	close(release)
+	for i := 0; i < 20; i++ {
+		if actual, err = decodeValue(testAvroValue, decoding{format: "avro", registry: r}); err == nil && actual["decodeError"] == nil {
+			return
+		}
+	}
+	t.Errorf("expected the schema fetched in the background to be used but got %+v, err=%v", actual, err)
+}
+
+func TestSchemaRegistryKeepsFilesInTheDataDir(t *testing.T) {
+	tests := []struct {
+		name  string
+		c     schemaRegistryJSON
+		fails bool
+	}{
+		{name: "cache dir", c: schemaRegistryJSON{URL: "registry:8081", CacheDir: "schemas"}},
+		{name: "cache dir outside", c: schemaRegistryJSON{URL: "registry:8081", CacheDir: "../schemas"}, fails: true},
+		{name: "absolute cache dir", c: schemaRegistryJSON{URL: "registry:8081", CacheDir: "/tmp/schemas"}, fails: true},
+		{name: "absolute ca file", c: schemaRegistryJSON{URL: "registry:8081", CAFile: "/etc/ssl/private/ca.pem"}, fails: true},
+		{name: "key file outside", c: schemaRegistryJSON{URL: "registry:8081", CertFile: "cert.pem", KeyFile: "../key.pem"}, fails: true},
+	}
+	for _, ts := range tests {
+		if _, err := newSchemaRegistry(ts.c, "data"); ts.fails != (err != nil) {
+			t.Errorf("on '%v': expected failure %v but got %v", ts.name, ts.fails, err)
+		}
+	}
+}
+
+func TestSchemaRegistryDetectsProtobufAndJSONSchema(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/ids/8":
+			writeJSON(w, http.StatusOK, map[string]string{"schemaType": "PROTOBUF", "schema": `
+				syntax = "proto3";
+				package shop;
+				message Order {
+					int64 id = 1;
+					Status status = 2;
+					repeated string tags = 3;
+					Item item = 4;
+					map<string, int32> qty = 5;
+					repeated Status history = 6;
+					enum Status { OK = 0; ERROR = 1; }
+					message Item { string sku = 1; }
+				}`})
+		case "/schemas/ids/9":
+			writeJSON(w, http.StatusOK, map[string]string{"schemaType": "JSON", "schema": `{"type":"object"}`})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	r, _ := patientRegistry(schemaRegistryJSON{URL: s.URL}, "")
+
+	tests := []struct {
+		name     string
+		value    []byte
+		expected map[string]interface{}
+	}{
+		{
+			name:  "protobuf",
+			value: []byte{0, 0, 0, 0, 8, 0, 0x08, 0x2a, 0x10, 0x01, 0x1a, 0x01, 'a', 0x22, 0x04, 0x0a, 0x02, 'x', 'y', 0x2a, 0x05, 0x0a, 0x01, 'k', 0x10, 0x03, 0x32, 0x02, 0x00, 0x01},
+			expected: map[string]interface{}{
+				"id":      int64(42),
+				"status":  "ERROR",
+				"tags":    []interface{}{"a"},
+				"item":    map[string]interface{}{"sku": "xy"},
+				"qty":     map[string]interface{}{"k": int64(3)},
+				"history": []interface{}{"OK", "ERROR"},
+			},
+		},
+		{
+			name:     "json schema",
+			value:    append([]byte{0, 0, 0, 0, 9}, []byte(`{"id":1}`)...),
+			expected: map[string]interface{}{"id": float64(1)},
+		},
+	}
+
+	for _, ts := range tests {
+		actual, err := decodeValue(ts.value, decoding{registry: r})
+		if err != nil {
+			t.Errorf("'%v' shouldn't have failed, but did with %v", ts.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, ts.expected) {
+			t.Errorf("on '%v': expected %+v but got %+v", ts.name, ts.expected, actual)
+		}
+	}
+}
+
+func TestSchemaRegistryDecodesPrimitiveKeys(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/ids/10":
+			writeJSON(w, http.StatusOK, map[string]string{"schema": `"string"`})
+		case "/schemas/ids/11":
+			writeJSON(w, http.StatusOK, map[string]string{"schema": `"long"`})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer s.Close()
+	r, _ := patientRegistry(schemaRegistryJSON{URL: s.URL}, "")
+
+	tests := []struct {
+		name           string
+		key            []byte
+		expectedValue  interface{}
+		expectedString string
+	}{
+		{name: "string", key: []byte{0, 0, 0, 0, 10, 0x06, 'a', 'b', 'c'}, expectedValue: "abc", expectedString: "abc"},
+		{name: "long", key: []byte{0, 0, 0, 0, 11, 0x54}, expectedValue: int64(42), expectedString: "42"},
+	}
+	for _, ts := range tests {
+		v, str, err := decodeKey(ts.key, decoding{keyFormat: "registry", registry: r})
+		if err != nil {
+			t.Errorf("'%v' shouldn't have failed, but did with %v", ts.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, ts.expectedValue) || str != ts.expectedString {
+			t.Errorf("on '%v': expected %+v (%v) but got %+v (%v)", ts.name, ts.expectedValue, ts.expectedString, v, str)
+		}
+	}
+}
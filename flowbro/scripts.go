@@ -0,0 +1,145 @@
+package flowbro
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type scriptJSON struct {
+	Topic string `json:"topic"`
+	Name  string `json:"name"`
+}
+
+// script is a user-provided program that transforms the messages of the
+// topics it matches: it gets every message, and tells whether to drop it or
+// how to rewrite its key and value and tag it. Lua scripts run in flowbro's
+// own embedded interpreter, WebAssembly plugins in a WASI runtime.
+type script struct {
+	topic  *regexp.Regexp
+	name   string
+	runner scriptRunner
+}
+
+// scriptRunner runs a script in whatever it is written in.
+type scriptRunner interface {
+	start() error
+	run(m scriptMessage) (scriptVerdict, error)
+	stop()
+}
+
+type scriptMessage struct {
+	Topic     string                 `json:"topic"`
+	Partition int32                  `json:"partition"`
+	Offset    int64                  `json:"offset"`
+	Key       string                 `json:"key"`
+	Value     map[string]interface{} `json:"value"`
+	Timestamp time.Time              `json:"timestamp"`
+	Tags      map[string]string      `json:"tags,omitempty"`
+}
+
+type scriptVerdict struct {
+	Drop  bool                   `json:"drop"`
+	Key   *string                `json:"key"`
+	Value map[string]interface{} `json:"value"`
+	Tags  map[string]string      `json:"tags"`
+}
+
+const scriptTimeout = time.Second
+
+func newScript(s scriptJSON, dir string, wasmRuntime string) (*script, error) {
+	if len(s.Name) == 0 || strings.ContainsAny(s.Name, `/\`) || strings.HasPrefix(s.Name, ".") {
+		return nil, fmt.Errorf("Invalid script name %v; scripts are referred to by their file name in the scripts directory", s.Name)
+	}
+	topic, err := regexp.Compile("^(?:" + s.Topic + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("Invalid topic regex %v for script %v. err=%v", s.Topic, s.Name, err)
+	}
+
+	path := filepath.Join(dir, s.Name)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("Could not find script %v. err=%v", s.Name, err)
+	}
+
+	switch filepath.Ext(path) {
+	case ".lua":
+		return &script{topic: topic, name: s.Name, runner: &luaScript{name: s.Name, path: path}}, nil
+	case ".wasm":
+		plugin, err := newWasmPlugin(s.Name, path, wasmRuntime)
+		if err != nil {
+			return nil, err
+		}
+		return &script{topic: topic, name: s.Name, runner: plugin}, nil
+	}
+	return nil, fmt.Errorf("Unsupported script %v; scripts are Lua (.lua) or WebAssembly (.wasm) files", s.Name)
+}
+
+// transform runs m through the script, returning false if it must be dropped.
+func (s *script) transform(m message) (message, bool, error) {
+	v, err := s.runner.run(scriptMessage{Topic: m.Topic, Partition: m.Partition, Offset: m.Offset, Key: m.Key, Value: m.Value, Timestamp: m.Timestamp, Tags: m.Tags})
+	if err != nil {
+		return m, true, err
+	}
+	if v.Drop {
+		return m, false, nil
+	}
+	if v.Key != nil {
+		m.Key = *v.Key
+	}
+	if v.Value != nil {
+		m.Value = v.Value
+	}
+	for k, t := range v.Tags {
+		if m.Tags == nil {
+			m.Tags = map[string]string{}
+		}
+		m.Tags[k] = t
+	}
+	return m, true, nil
+}
+
+func startScripts(scriptsJSON []scriptJSON, dir string, wasmRuntime string) ([]*script, error) {
+	scripts := []*script{}
+	for _, sj := range scriptsJSON {
+		s, err := newScript(sj, dir, wasmRuntime)
+		if err != nil {
+			stopScripts(scripts)
+			return nil, err
+		}
+		if err := s.runner.start(); err != nil {
+			stopScripts(scripts)
+			return nil, err
+		}
+		scripts = append(scripts, s)
+	}
+	return scripts, nil
+}
+
+func stopScripts(scripts []*script) {
+	for _, s := range scripts {
+		s.runner.stop()
+	}
+}
+
+// runScripts passes m through every script matching its topic, in order.
+func runScripts(scripts []*script, m message) (message, bool) {
+	for _, s := range scripts {
+		if !s.topic.MatchString(m.Topic) {
+			continue
+		}
+		var keep bool
+		var err error
+		if m, keep, err = s.transform(m); err != nil {
+			log.WithFields(log.Fields{"err": err, "script": s.name, "topic": m.Topic, "offset": m.Offset}).Error("Script failed; passing message through untouched.")
+		}
+		if !keep {
+			return m, false
+		}
+	}
+	return m, true
+}
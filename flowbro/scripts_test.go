@@ -0,0 +1,50 @@
+package flowbro
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScriptsTagAndDropMessages(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "tag.lua"), []byte(`
+function transform(m)
+	if m.value.a == "drop-me" then
+		return {drop = true}
+	end
+	return {key = "rewritten", tags = {seen = "yes"}}
+end
+`), 0644)
+
+	scripts, err := startScripts([]scriptJSON{{Topic: "orders", Name: "tag.lua"}}, dir, "")
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	defer stopScripts(scripts)
+
+	m, keep := runScripts(scripts, message{Topic: "orders", Key: "1", Value: newValueFrom(`{"a":1}`)})
+	if !keep || m.Key != "rewritten" || m.Tags["seen"] != "yes" {
+		t.Errorf("expected message to be kept, rewritten and tagged, but got %+v (keep=%v)", m, keep)
+	}
+
+	if _, keep := runScripts(scripts, message{Topic: "orders", Value: newValueFrom(`{"a":"drop-me"}`)}); keep {
+		t.Errorf("expected message to be dropped")
+	}
+
+	m, keep = runScripts(scripts, message{Topic: "payments", Key: "1"})
+	if !keep || m.Key != "1" || m.Tags != nil {
+		t.Errorf("expected message on another topic to be untouched, but got %+v (keep=%v)", m, keep)
+	}
+
+	if _, err := startScripts([]scriptJSON{{Topic: "orders", Name: "../tag.lua"}}, dir, ""); err == nil {
+		t.Errorf("expected scripts outside the scripts directory to be rejected")
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "tag.py"), []byte("print('{}')"), 0755)
+	if _, err := startScripts([]scriptJSON{{Topic: "orders", Name: "tag.py"}}, dir, ""); err == nil {
+		t.Errorf("expected scripts needing an external interpreter to be rejected")
+	}
+}
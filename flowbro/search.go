@@ -0,0 +1,298 @@
+package flowbro
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// searchHit is a message found by a search, as it was consumed.
+type searchHit struct {
+	Seq       int64                  `json:"seq"`
+	Topic     string                 `json:"topic"`
+	Partition int32                  `json:"partition"`
+	Offset    int64                  `json:"offset"`
+	Cluster   string                 `json:"cluster,omitempty"`
+	Board     string                 `json:"board,omitempty"`
+	Key       string                 `json:"key"`
+	Timestamp time.Time              `json:"timestamp"`
+	Received  time.Time              `json:"received"`
+	Value     map[string]interface{} `json:"value"`
+}
+
+// searchIndex is an inverted index of the words in the keys and decoded
+// values of consumed messages, keeping the last capacity ones (or all of them
+// if capacity is 0). Messages are numbered in the order they were added.
+type searchIndex struct {
+	l        sync.Mutex
+	capacity int
+	docs     []searchHit // a ring buffer once full
+	first    int64       // seq of the oldest message kept
+	next     int64       // seq of the next message added
+	postings map[string][]int64
+	evicted  int // since postings were last compacted
+}
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 1000
+	searchCacheSize    = 8 // recordings kept indexed
+)
+
+func newSearchIndex(capacity int) *searchIndex {
+	return &searchIndex{capacity: capacity, postings: map[string][]int64{}}
+}
+
+// add indexes m, consumed for board if any, evicting the oldest message if
+// the index is full.
+func (s *searchIndex) add(m message, board string, received time.Time) {
+	if s == nil {
+		return
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	hit := searchHit{Seq: s.next, Topic: m.Topic, Partition: m.Partition, Offset: m.Offset, Cluster: m.Cluster, Board: board, Key: m.Key, Timestamp: m.Timestamp, Received: received, Value: m.Value}
+	if s.capacity > 0 && len(s.docs) == s.capacity {
+		s.docs[s.next%int64(s.capacity)] = hit
+		s.first++
+		s.evicted++
+	} else {
+		s.docs = append(s.docs, hit)
+	}
+	for _, w := range searchWords(m) {
+		s.postings[w] = append(s.postings[w], s.next)
+	}
+	s.next++
+	if s.capacity > 0 && s.evicted >= s.capacity {
+		s.compact()
+	}
+}
+
+// compact drops the evicted messages from the postings, which is otherwise
+// left to lookups, so words that aren't seen anymore don't pile up.
+func (s *searchIndex) compact() {
+	for w, seqs := range s.postings {
+		i := sort.Search(len(seqs), func(i int) bool { return seqs[i] >= s.first })
+		if i == len(seqs) {
+			delete(s.postings, w)
+			continue
+		}
+		s.postings[w] = append([]int64{}, seqs[i:]...)
+	}
+	s.evicted = 0
+}
+
+func (s *searchIndex) doc(seq int64) searchHit {
+	if s.capacity > 0 {
+		return s.docs[seq%int64(s.capacity)]
+	}
+	return s.docs[seq]
+}
+
+// search returns up to limit messages containing every word of q, newest
+// first and older than before if it's positive, and whether there are more,
+// of the boards readable tells.
+func (s *searchIndex) search(q string, before int64, limit int, readable func(board string) bool) ([]searchHit, bool) {
+	words := tokenize(q, nil)
+	s.l.Lock()
+	defer s.l.Unlock()
+	if before <= 0 || before > s.next {
+		before = s.next
+	}
+	lists := [][]int64{}
+	for _, w := range words {
+		seqs := s.postings[w]
+		i := sort.Search(len(seqs), func(i int) bool { return seqs[i] >= s.first })
+		lists = append(lists, seqs[i:])
+	}
+	sort.Slice(lists, func(i, j int) bool { return len(lists[i]) < len(lists[j]) })
+
+	hits := []searchHit{}
+	if len(lists) == 0 {
+		return hits, false
+	}
+	shortest := lists[0]
+	for i := sort.Search(len(shortest), func(i int) bool { return shortest[i] >= before }) - 1; i >= 0; i-- {
+		seq, all := shortest[i], true
+		for _, other := range lists[1:] {
+			j := sort.Search(len(other), func(j int) bool { return other[j] >= seq })
+			if j == len(other) || other[j] != seq {
+				all = false
+				break
+			}
+		}
+		if !all || !readable(s.doc(seq).Board) {
+			continue
+		}
+		if len(hits) == limit {
+			return hits, true
+		}
+		hits = append(hits, s.doc(seq))
+	}
+	return hits, false
+}
+
+// searchWords returns the distinct words of m's topic, key, and its value's
+// field names and scalar values.
+func searchWords(m message) []string {
+	seen := map[string]bool{}
+	tokenize(m.Topic, seen)
+	tokenize(m.Key, seen)
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, vv := range v {
+				tokenize(k, seen)
+				walk(vv)
+			}
+		case []interface{}:
+			for _, vv := range v {
+				walk(vv)
+			}
+		case nil:
+		default:
+			tokenize(fmt.Sprint(v), seen)
+		}
+	}
+	walk(m.Value)
+	words := []string{}
+	for w := range seen {
+		words = append(words, w)
+	}
+	return words
+}
+
+// tokenize splits s into lower-cased words of letters and digits, adding the
+// new ones to seen if set.
+func tokenize(s string, seen map[string]bool) []string {
+	words := []string{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+		if seen != nil {
+			if seen[w] {
+				continue
+			}
+			seen[w] = true
+		}
+		words = append(words, w)
+	}
+	return words
+}
+
+// recordingIndexes caches the search indexes of recordings until they grow.
+type recordingIndexes struct {
+	l       sync.Mutex
+	indexes map[string]recordingIndex
+}
+
+type recordingIndex struct {
+	size  int64
+	index *searchIndex
+}
+
+var searchedRecordings = &recordingIndexes{indexes: map[string]recordingIndex{}}
+
+// get returns the index of every message of the recording, indexing it again
+// if messages were recorded since it last was.
+func (r *recordingIndexes) get(dataDir, name string) (*searchIndex, error) {
+	dir, err := recordingDir(dataDir, name)
+	if err != nil {
+		return nil, err
+	}
+	board := recordingBoard(dataDir, name)
+	info, err := os.Stat(filepath.Join(dir, "messages.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("Could not open recording %v. err=%v", name, err)
+	}
+	key := filepath.Join(dataDir, name)
+	r.l.Lock()
+	defer r.l.Unlock()
+	if ri, ok := r.indexes[key]; ok && ri.size == info.Size() {
+		return ri.index, nil
+	}
+	index := newSearchIndex(0)
+	err = eachRecorded(dataDir, name, nil, nil, func(rm recordedMessage) error {
+		m, err := rm.message()
+		if err != nil {
+			return err
+		}
+		index.add(m, board, rm.Received)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(r.indexes) >= searchCacheSize {
+		for k := range r.indexes {
+			delete(r.indexes, k)
+			break
+		}
+	}
+	r.indexes[key] = recordingIndex{size: info.Size(), index: index}
+	return index, nil
+}
+
+// searchHandler serves /api/search?q=failed+payment, over the messages
+// consumed lately or those of a recording, newest first, of the boards the
+// caller may read. Pages of limit hits continue before the seq given as
+// cursor.
+func (f *flowbro) searchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+		return
+	}
+	query := r.URL.Query()
+	q := query.Get("q")
+	if len(tokenize(q, nil)) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Please define the words to search for, e.g. /api/search?q=failed+payment"))
+		return
+	}
+	limit := defaultSearchLimit
+	if l := query.Get("limit"); len(l) > 0 {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 || n > maxSearchLimit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid limit %v; please use 1 to %v", l, maxSearchLimit))
+			return
+		}
+		limit = n
+	}
+	var cursor int64
+	if c := query.Get("cursor"); len(c) > 0 {
+		n, err := strconv.ParseInt(c, 10, 64)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid cursor %v", c))
+			return
+		}
+		cursor = n
+	}
+
+	index := f.search
+	if name := query.Get("recording"); len(name) > 0 {
+		if err := mayReadRecording(r, f.dataDir, name); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+		var err error
+		if index, err = searchedRecordings.get(f.dataDir, name); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+	}
+	if index == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Consumed messages aren't kept for searching; please start flowbro with -search-history, or search a recording"))
+		return
+	}
+	hits, more := index.search(q, cursor, limit, principalOf(r).mayRead)
+	response := map[string]interface{}{"q": q, "hits": hits}
+	if more {
+		response["cursor"] = hits[len(hits)-1].Seq
+	}
+	writeJSON(w, http.StatusOK, response)
+}
@@ -0,0 +1,118 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSearchIndex(t *testing.T) {
+	s := newSearchIndex(4)
+	now := time.Now()
+	for i, v := range []string{
+		`{"status":"FAILED","reason":"card declined"}`,
+		`{"status":"PAID"}`,
+		`{"status":"payment_failed","items":[{"sku":"Café-42"}]}`,
+		`{"status":"FAILED"}`,
+		`{"status":"PAID","note":"failed once"}`,
+		`{"status":"FAILED","amount":42}`,
+	} {
+		s.add(message{Topic: "payments", Key: fmt.Sprintf("p-%v", i), Offset: int64(i), Value: newValueFrom(v)}, "", now)
+	}
+
+	tests := []struct {
+		q        string
+		before   int64
+		limit    int
+		expected []int64
+		more     bool
+	}{
+		{q: "failed", limit: 10, expected: []int64{5, 4, 3, 2}},
+		{q: "failed", limit: 2, expected: []int64{5, 4}, more: true},
+		{q: "failed", before: 4, limit: 2, expected: []int64{3, 2}},
+		{q: "Payment FAILED", limit: 10, expected: []int64{2}},
+		{q: "café", limit: 10, expected: []int64{2}},
+		{q: "42", limit: 10, expected: []int64{5, 2}},
+		{q: "p 5", limit: 10, expected: []int64{5}},
+		{q: "declined", limit: 10, expected: []int64{}}, // evicted
+		{q: "refunded", limit: 10, expected: []int64{}},
+	}
+	for _, ts := range tests {
+		hits, more := s.search(ts.q, ts.before, ts.limit, principal{}.mayRead)
+		actual := []int64{}
+		for _, h := range hits {
+			actual = append(actual, h.Seq)
+		}
+		if !reflect.DeepEqual(actual, ts.expected) || more != ts.more {
+			t.Errorf("on '%v' before %v: expected %v (more %v) but got %v (more %v)", ts.q, ts.before, ts.expected, ts.more, actual, more)
+		}
+	}
+	if _, ok := s.postings["declined"]; !ok {
+		t.Errorf("expected postings to be compacted only once capacity messages were evicted")
+	}
+	for i := 0; i < 2; i++ {
+		s.add(message{Topic: "payments", Value: newValueFrom(`{}`)}, "", now)
+	}
+	if _, ok := s.postings["declined"]; ok {
+		t.Errorf("expected the words of evicted messages to be compacted away")
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+	r, _ := openRecording(&recordingJSON{Name: "incident"}, dir, json.RawMessage(`{}`), "")
+	r.record(message{Topic: "payments", Key: "1", Value: newValueFrom(`{"status":"FAILED"}`)}, time.Now())
+	r.record(message{Topic: "payments", Key: "2", Value: newValueFrom(`{"status":"PAID"}`)}, time.Now())
+	r.close()
+	live := newSearchIndex(10)
+	live.add(message{Topic: "orders", Key: "3", Value: newValueFrom(`{"status":"FAILED"}`)}, "", time.Now())
+	live.add(message{Topic: "orders", Key: "4", Value: newValueFrom(`{"status":"FAILED"}`)}, "", time.Now())
+
+	tests := []struct {
+		name         string
+		f            *flowbro
+		path         string
+		status       int
+		expectedKeys []string
+		cursor       bool
+	}{
+		{name: "live", f: &flowbro{search: live}, path: "/api/search?q=failed", status: http.StatusOK, expectedKeys: []string{"4", "3"}},
+		{name: "live page", f: &flowbro{search: live}, path: "/api/search?q=failed&limit=1", status: http.StatusOK, expectedKeys: []string{"4"}, cursor: true},
+		{name: "next live page", f: &flowbro{search: live}, path: "/api/search?q=failed&limit=1&cursor=1", status: http.StatusOK, expectedKeys: []string{"3"}},
+		{name: "recording", f: &flowbro{dataDir: dir}, path: "/api/search?q=failed&recording=incident", status: http.StatusOK, expectedKeys: []string{"1"}},
+		{name: "unknown recording", f: &flowbro{dataDir: dir}, path: "/api/search?q=failed&recording=other", status: http.StatusNotFound},
+		{name: "no history", f: &flowbro{}, path: "/api/search?q=failed", status: http.StatusNotFound},
+		{name: "no words", f: &flowbro{search: live}, path: "/api/search?q=--", status: http.StatusBadRequest},
+		{name: "invalid limit", f: &flowbro{search: live}, path: "/api/search?q=failed&limit=5000", status: http.StatusBadRequest},
+	}
+	for _, ts := range tests {
+		w := httptest.NewRecorder()
+		ts.f.searchHandler(w, httptest.NewRequest("GET", ts.path, nil))
+		if w.Code != ts.status {
+			t.Errorf("on '%v': expected status %v but got %v", ts.name, ts.status, w.Code)
+			continue
+		}
+		if ts.status != http.StatusOK {
+			continue
+		}
+		var actual struct {
+			Hits   []searchHit `json:"hits"`
+			Cursor *int64      `json:"cursor"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &actual)
+		keys := []string{}
+		for _, h := range actual.Hits {
+			keys = append(keys, h.Key)
+		}
+		if !reflect.DeepEqual(keys, ts.expectedKeys) || (actual.Cursor != nil) != ts.cursor {
+			t.Errorf("on '%v': expected keys %v (cursor %v) but got %v", ts.name, ts.expectedKeys, ts.cursor, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package flowbro
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Server serves flowbro's UI, websockets and API, as configured by the
+// options it was created with.
+type Server struct {
+	f         *flowbro
+	port      int
+	listener  net.Listener
+	template  *template.Template
+	outputs   []*output
+	headless  *headless
+	reporters []reporter
+	publisher *busPublisher
+}
+
+// reporter sends metrics somewhere until ctx is done.
+type reporter interface {
+	run(ctx context.Context)
+}
+
+// Option configures a Server.
+type Option func(s *Server) error
+
+// DefaultPort is the port of localhost servers serve on unless created
+// WithPort.
+const DefaultPort = 41234
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// NewServer returns a Server configured by opts, listening on DefaultPort
+// unless they say otherwise.
+func NewServer(opts ...Option) (*Server, error) {
+	s := &Server{f: &flowbro{}, port: DefaultPort}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			return nil, err
+		}
+	}
+	if s.template == nil {
+		t, err := parseBasePageTemplate()
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse base page template. err=%v", err)
+		}
+		s.template = t
+	}
+	if s.listener == nil && s.headless == nil {
+		l, err := newListener(s.port)
+		if err != nil {
+			return nil, fmt.Errorf("Could not open listener on port %v. err=%v", s.port, err)
+		}
+		s.listener = l
+	}
+	return s, nil
+}
+
+// WithPort serves on port of localhost.
+func WithPort(port int) Option {
+	return func(s *Server) error {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("Invalid port %v", port)
+		}
+		s.port = port
+		return nil
+	}
+}
+
+// WithListener serves on l instead of a port of localhost.
+func WithListener(l net.Listener) Option {
+	return func(s *Server) error {
+		s.listener = l
+		return nil
+	}
+}
+
+// WithDataDir keeps bookmarks, annotations, sessions, recordings and the
+// other files flowbro writes in dir.
+func WithDataDir(dir string) Option {
+	return func(s *Server) error {
+		s.f.dataDir = dir
+		return nil
+	}
+}
+
+// WithScripts runs the scripts configs name from dir, and WebAssembly ones
+// with wasmRuntime.
+func WithScripts(dir, wasmRuntime string) Option {
+	return func(s *Server) error {
+		s.f.scriptsDir, s.f.wasmRuntime = dir, wasmRuntime
+		return nil
+	}
+}
+
+// WithBoardsDir serves the boards configured in dir.
+func WithBoardsDir(dir string) Option {
+	return func(s *Server) error {
+		s.f.boardsDir = dir
+		return nil
+	}
+}
+
+// withAuth requires every request to be authenticated by a.
+func withAuth(a authenticator) Option {
+	return func(s *Server) error {
+		s.f.auth = a
+		return nil
+	}
+}
+
+// WithAuthTokens requires every request to bear one of the tokens listed in
+// the JSON file at path, mapping each to a name and a viewer or operator
+// role.
+func WithAuthTokens(path string) Option {
+	return func(s *Server) error {
+		a, err := readTokens(path)
+		if err != nil {
+			return err
+		}
+		s.f.auth = a
+		return nil
+	}
+}
+
+// WithOIDC logs users in with the OpenID Connect provider configured in the
+// JSON file at path.
+func WithOIDC(path string) Option {
+	return func(s *Server) error {
+		a, err := readOIDC(path)
+		if err != nil {
+			return err
+		}
+		s.f.auth = a
+		return nil
+	}
+}
+
+// WithLDAP authenticates users against the LDAP or Active Directory server
+// configured in the JSON file at path.
+func WithLDAP(path string) Option {
+	return func(s *Server) error {
+		a, err := readLDAP(path)
+		if err != nil {
+			return err
+		}
+		s.f.auth = a
+		return nil
+	}
+}
+
+// WithMockPath serves the fixtures at path instead of connecting to Kafka.
+func WithMockPath(path string) Option {
+	return func(s *Server) error {
+		s.f.mockPath = path
+		return nil
+	}
+}
+
+// WithSources has connections consume the Source returned by newSource,
+// along with a notice telling clients about it, instead of the one their
+// config asks for.
+func WithSources(newSource func() (Source, string)) Option {
+	return withSourcesFor(func(*config, fsm, func(event) error) (Source, string) { return newSource() })
+}
+
+// withSourcesFor is WithSources for sources depending on the config of the
+// connection, its FSM and the status it reports.
+func withSourcesFor(newSource func(config *config, f fsm, status func(event) error) (Source, string)) Option {
+	return func(s *Server) error {
+		s.f.newSource = newSource
+		return nil
+	}
+}
+
+// withDecoders decodes the topics of every connection that doesn't decode
+// them itself with decoders.
+func withDecoders(decoders ...decoderJSON) Option {
+	return func(s *Server) error {
+		for _, d := range decoders {
+			if _, err := newDecoder(d, nil, s.f.dataDir); err != nil {
+				return err
+			}
+		}
+		s.f.decoders = append(s.f.decoders, decoders...)
+		return nil
+	}
+}
+
+// WithFilter only shows messages matching the expression to every
+// connection, on top of their own filters.
+func WithFilter(expr string) Option {
+	return func(s *Server) error {
+		if _, err := compileCEL(expr); err != nil {
+			return fmt.Errorf("Invalid filter. err=%v", err)
+		}
+		s.f.filter = andFilter(s.f.filter, expr)
+		return nil
+	}
+}
+
+// withRedactions redacts the values of every connection with redactions, on
+// top of and before their own redactions, which can't undo them.
+func withRedactions(redactions ...redactionJSON) Option {
+	return func(s *Server) error {
+		if _, err := processRedactions(redactions); err != nil {
+			return err
+		}
+		s.f.redactions = append(s.f.redactions, redactions...)
+		return nil
+	}
+}
+
+// WithRedactionsFile redacts the values of every connection with the JSON
+// array of redactions in the file at path, on top of and before their own
+// redactions.
+func WithRedactionsFile(path string) Option {
+	return func(s *Server) error {
+		redactions, err := readRedactions(path)
+		if err != nil {
+			return err
+		}
+		return withRedactions(redactions...)(s)
+	}
+}
+
+// WithHeartbeatTimeout closes connections whose client didn't send a
+// heartbeat within d.
+func WithHeartbeatTimeout(d time.Duration) Option {
+	return func(s *Server) error {
+		if d <= 0 {
+			return fmt.Errorf("Invalid heartbeat timeout %v", d)
+		}
+		s.f.heartbeatTimeout = d
+		return nil
+	}
+}
+
+// WithOutput also publishes the flow configured in the file at configPath
+// as NDJSON on address, unix:<path> or tcp:<host:port>.
+func WithOutput(address, configPath string) Option {
+	return func(s *Server) error {
+		o, err := newOutput(address, configPath)
+		if err != nil {
+			return err
+		}
+		s.outputs = append(s.outputs, o)
+		return nil
+	}
+}
+
+// WithHeadless prints the flow configured in the file at configPath to w,
+// as JSON lines or rendered through tmpl, instead of serving anything.
+func WithHeadless(configPath, tmpl string, w io.Writer) Option {
+	return func(s *Server) error {
+		h, err := newHeadless(configPath, tmpl, w)
+		if err != nil {
+			return err
+		}
+		s.headless = h
+		return nil
+	}
+}
+
+// WithTUI shows the flow configured in the file at configPath in the
+// terminal, taking commands from in, instead of serving anything.
+func WithTUI(configPath string, in io.Reader, out io.Writer) Option {
+	return func(s *Server) error {
+		h, err := newHeadless(configPath, "", out)
+		if err != nil {
+			return err
+		}
+		h.tui = newTUI(in, out, filepath.Base(configPath))
+		s.headless = h
+		return nil
+	}
+}
+
+// WithRemoteWrite also pushes the metrics exposed on /metrics to the
+// Prometheus remote write endpoint configured in the file at path.
+func WithRemoteWrite(path string) Option {
+	return func(s *Server) error {
+		rw, err := readRemoteWrite(path)
+		if err != nil {
+			return err
+		}
+		s.reporters = append(s.reporters, rw)
+		return nil
+	}
+}
+
+// WithStatsd also emits the counters and gauges exposed on /metrics to the
+// StatsD agent configured in the file at path.
+func WithStatsd(path string) Option {
+	return func(s *Server) error {
+		sd, err := readStatsd(path)
+		if err != nil {
+			return err
+		}
+		s.reporters = append(s.reporters, sd)
+		return nil
+	}
+}
+
+// WithGrafana serves the history of flowbro's metrics, sampled every
+// interval, to Grafana's JSON datasources on /api/grafana/.
+func WithGrafana(interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return fmt.Errorf("Invalid Grafana sampling interval %v", interval)
+		}
+		s.f.grafana = newGrafanaHistory(interval, defaultGrafanaRetention)
+		s.reporters = append(s.reporters, s.f.grafana)
+		return nil
+	}
+}
+
+// WithSearchHistory keeps the last capacity messages consumed by any
+// session searchable on /api/search.
+func WithSearchHistory(capacity int) Option {
+	return func(s *Server) error {
+		if capacity < 0 {
+			return fmt.Errorf("Invalid search history %v", capacity)
+		}
+		if capacity > 0 {
+			s.f.search = newSearchIndex(capacity)
+		}
+		return nil
+	}
+}
+
+// WithBusPublisher also publishes the events of every board to the event
+// bus configured in the file at path, for frontends to serve.
+func WithBusPublisher(path string) Option {
+	return func(s *Server) error {
+		c, err := readBus(path)
+		if err != nil {
+			return err
+		}
+		s.publisher = newBusPublisher(c)
+		return nil
+	}
+}
+
+// WithBusFrontend serves boards from the events published to the event bus
+// configured in the file at path, instead of consuming their topics.
+func WithBusFrontend(path string) Option {
+	return func(s *Server) error {
+		c, err := readBus(path)
+		if err != nil {
+			return err
+		}
+		s.f.bus = newBusFrontend(c)
+		return nil
+	}
+}
+
+// WithAlerts evaluates the alerts of the config in the file at path on the
+// server, notifying once whether or not anybody is watching, and sends
+// their events to every client.
+func WithAlerts(path string) Option {
+	return func(s *Server) error {
+		a, err := newServerAlerts(path)
+		if err != nil {
+			return err
+		}
+		s.f.alerts = a
+		return nil
+	}
+}
+
+// Run serves until ctx is done or the server has drained, then closes every
+// connection and waits for requests in flight, up to a timeout. Headless
+// servers print their flow until ctx is done instead. Reporters send metrics
+// until Run returns.
+func (s *Server) Run(ctx context.Context) error {
+	reporting, stop := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, r := range s.reporters {
+		wg.Add(1)
+		go func(r reporter) {
+			defer wg.Done()
+			r.run(reporting)
+		}(r)
+	}
+	defer func() {
+		stop()
+		wg.Wait()
+	}()
+	if s.headless != nil {
+		return s.headless.run(ctx, s.f)
+	}
+	srv := &http.Server{
+		Handler:     s.f.handler(s.template),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	go s.f.flows.watch(ctx, flowReloadInterval)
+	if s.publisher != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.publisher.run(reporting, s.f)
+		}()
+	}
+	if s.f.alerts != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.f.alerts.run(reporting, s.f)
+		}()
+	}
+	if s.f.bus != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.f.bus.run(reporting)
+		}()
+	}
+
+	errs := make(chan error, 1)
+	go func() { errs <- srv.Serve(s.listener) }()
+	for _, o := range s.outputs {
+		go o.serve(ctx, s.f)
+	}
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	case <-s.f.drain.done():
+		log.Println("Drained every connection; shutting down.")
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdown); err != nil {
+		return err
+	}
+	return nil
+}
+
+// addr returns the address the server listens on.
+func (s *Server) addr() net.Addr {
+	return s.listener.Addr()
+}
@@ -0,0 +1,352 @@
+package flowbro
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// chanSource serves the messages sent on its channel.
+type chanSource struct {
+	c chan *SourceMessage
+}
+
+func (s chanSource) Start(ctx context.Context) (<-chan *SourceMessage, error) { return s.c, nil }
+func (s chanSource) Close()                                                   {}
+
+func TestServerOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		opt   Option
+		fails bool
+	}{
+		{name: "port", opt: WithPort(0), fails: true},
+		{name: "filter", opt: WithFilter("value.id =="), fails: true},
+		{name: "decoder", opt: withDecoders(decoderJSON{Topic: "(", Format: "json"}), fails: true},
+		{name: "redaction", opt: withRedactions(redactionJSON{Topic: "users", Path: "email", Strategy: "drop"}), fails: true},
+		{name: "heartbeat timeout", opt: WithHeartbeatTimeout(0), fails: true},
+		{name: "alerts", opt: WithAlerts("missing-alerts.json"), fails: true},
+		{name: "bus publisher", opt: WithBusPublisher("missing-bus.json"), fails: true},
+		{name: "bus frontend", opt: WithBusFrontend("missing-bus.json"), fails: true},
+		{name: "valid filter", opt: WithFilter("value.id == 1")},
+	}
+
+	for _, ts := range tests {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewServer(WithListener(l), ts.opt)
+		if ts.fails != (err != nil) {
+			t.Errorf("on '%v': expected failure %v but got %v", ts.name, ts.fails, err)
+		}
+		l.Close()
+	}
+}
+
+func TestServerRunsUntilCancelled(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan *SourceMessage, 2)
+	s, err := NewServer(
+		WithListener(l),
+		WithSources(func() (Source, string) { return chanSource{messages}, "" }),
+		WithFilter(`value.id == 2`),
+		WithHeartbeatTimeout(time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- s.Run(ctx) }()
+
+	url := "ws://" + s.addr().String() + "/ws"
+	ws, err := websocket.Dial(url, "", "http://"+s.addr().String())
+	if err != nil {
+		t.Fatalf("Could not open WebSocket: %v", err)
+	}
+	defer ws.Close()
+	conf := configJSON{
+		Rules:         []rule{{Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "orders"}}, Events: []event{{EventType: "message", SourceId: "a", TargetId: "b", Text: "{{.Value.id}}"}}}},
+		HeartbeatUUID: "uuid",
+	}
+	if err := websocket.JSON.Send(ws, conf); err != nil {
+		t.Fatalf("Could not send config: %v", err)
+	}
+	messages <- &SourceMessage{Topic: "orders", Value: []byte(`{"id": 1}`)}
+	messages <- &SourceMessage{Topic: "orders", Value: []byte(`{"id": 2}`)}
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var received *event
+	for received == nil {
+		var es []event
+		if err := websocket.JSON.Receive(ws, &es); err != nil {
+			t.Fatalf("Didn't receive a message event. err=%v", err)
+		}
+		for i := range es {
+			if es[i].EventType == "message" {
+				received = &es[i]
+			}
+		}
+	}
+	if received.Text != "2" {
+		t.Errorf("expected only the message matching the server's filter but got %+v", received)
+	}
+
+	cancel()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("expected the server to stop cleanly but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the server to stop once cancelled")
+	}
+	for {
+		var es []event
+		if err := websocket.JSON.Receive(ws, &es); err != nil {
+			break
+		}
+	}
+}
+
+func TestServerRedactionsCantBeOverridden(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan *SourceMessage, 1)
+	s, err := NewServer(
+		WithListener(l),
+		WithSources(func() (Source, string) { return chanSource{messages}, "" }),
+		withRedactions(redactionJSON{Topic: "users", Path: "$.email", Strategy: "drop"}),
+	)
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	ws, err := websocket.Dial("ws://"+s.addr().String()+"/ws", "", "http://"+s.addr().String())
+	if err != nil {
+		t.Fatalf("Could not open WebSocket: %v", err)
+	}
+	defer ws.Close()
+	conf := configJSON{
+		Rules:         []rule{{Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "users"}}, Events: []event{{EventType: "message", SourceId: "a", TargetId: "b", Text: "{{.Value.id}} {{.Value.email}}"}}}},
+		Kafka:         kafka{Filter: `!has(value.email)`},
+		Redactions:    []redactionJSON{},
+		HeartbeatUUID: "uuid",
+	}
+	if err := websocket.JSON.Send(ws, conf); err != nil {
+		t.Fatalf("Could not send config: %v", err)
+	}
+	messages <- &SourceMessage{Topic: "users", Value: []byte(`{"id": 1, "email": "jane@example.com"}`)}
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var es []event
+		if err := websocket.JSON.Receive(ws, &es); err != nil {
+			t.Fatalf("Didn't receive a message event. err=%v", err)
+		}
+		for _, e := range es {
+			if e.EventType != "message" {
+				continue
+			}
+			if strings.Contains(e.Text, "jane") {
+				t.Errorf("expected the server's redaction to apply, but got %+v", e)
+			}
+			return
+		}
+	}
+}
+
+func TestServerDrainsBeforeShuttingDown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := make(chan *SourceMessage, 2)
+	s, err := NewServer(
+		WithListener(l),
+		WithSources(func() (Source, string) { return chanSource{messages}, "" }),
+		WithHeartbeatTimeout(time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	stopped := make(chan error)
+	go func() { stopped <- s.Run(context.Background()) }()
+
+	dial := func() *websocket.Conn {
+		ws, err := websocket.Dial("ws://"+s.addr().String()+"/ws", "", "http://"+s.addr().String())
+		if err != nil {
+			t.Fatalf("Could not open WebSocket: %v", err)
+		}
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return ws
+	}
+	stream := func(ws *websocket.Conn) {
+		conf := configJSON{
+			Rules:         []rule{{Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "orders"}}, Events: []event{{EventType: "message", SourceId: "a", TargetId: "b", Text: "{{.Value.id}}"}}}},
+			HeartbeatUUID: "uuid",
+		}
+		if err := websocket.JSON.Send(ws, conf); err != nil {
+			t.Fatalf("Could not send config: %v", err)
+		}
+	}
+	ws, refused := dial(), dial() // the server may be gone by the time refused would dial
+	defer ws.Close()
+	defer refused.Close()
+	stream(ws)
+	for started := false; !started; {
+		var es []event
+		if err := websocket.JSON.Receive(ws, &es); err != nil {
+			t.Fatalf("Didn't start sending messages. err=%v", err)
+		}
+		started = len(es) > 0 && es[0].Text == "Starting to send messages!"
+	}
+	messages <- &SourceMessage{Topic: "orders", Value: []byte(`{"id": 1}`)}
+	for len(messages) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp, err := http.Post("http://"+s.addr().String()+"/api/drain", "application/json", nil)
+	if err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("expected draining to start but got %v and %v", resp, err)
+	}
+	resp.Body.Close()
+
+	stream(refused)
+	var es []event
+	if err := websocket.JSON.Receive(refused, &es); err != nil || len(es) != 1 || !strings.Contains(es[0].Text, "draining") {
+		t.Errorf("expected new connections to be turned away but got %+v and %v", es, err)
+	}
+
+	var received []string
+	for {
+		var es []event
+		if err := websocket.JSON.Receive(ws, &es); err != nil {
+			break
+		}
+		for _, e := range es {
+			received = append(received, e.EventType+":"+e.Text)
+		}
+	}
+	if len(received) == 0 || received[len(received)-1] != "log:Flowbro is shutting down; every buffered message was sent. Please reconnect." {
+		t.Fatalf("expected the connection to be told once it was flushed but got %v", received)
+	}
+	if !strings.Contains(strings.Join(received, ","), "message:1,") {
+		t.Errorf("expected the buffered message to be sent before closing but got %v", received)
+	}
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("expected the server to stop cleanly but got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the server to stop once drained")
+	}
+}
+
+func TestServerEvaluatesAlertsOnce(t *testing.T) {
+	posts := make(chan string, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		byt, _ := ioutil.ReadAll(r.Body)
+		posts <- string(byt)
+	}))
+	defer hook.Close()
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+	alertsPath := filepath.Join(dir, "alerts.json")
+	ioutil.WriteFile(alertsPath, []byte(`{"alerts": [{"name": "errors", "topic": "payments", "expr": "value.status == 'ERROR'", "componentId": "Payments", "notifiers": [{"url": "`+hook.URL+`"}]}]}`), 0644)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerting, viewing := make(chan *SourceMessage, 1), make(chan *SourceMessage)
+	s, err := NewServer(
+		WithListener(l),
+		withSourcesFor(func(c *config, _ fsm, _ func(event) error) (Source, string) {
+			if len(c.alerts) > 0 {
+				return chanSource{alerting}, ""
+			}
+			return chanSource{viewing}, ""
+		}),
+		WithHeartbeatTimeout(time.Minute),
+		WithAlerts(alertsPath),
+	)
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx)
+
+	var clients []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		ws, err := websocket.Dial("ws://"+s.addr().String()+"/ws", "", "http://"+s.addr().String())
+		if err != nil {
+			t.Fatalf("Could not open WebSocket: %v", err)
+		}
+		defer ws.Close()
+		ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+		websocket.JSON.Send(ws, configJSON{HeartbeatUUID: "uuid"})
+		for started := false; !started; {
+			var es []event
+			if err := websocket.JSON.Receive(ws, &es); err != nil {
+				t.Fatalf("Didn't start sending messages. err=%v", err)
+			}
+			started = len(es) > 0 && es[0].Text == "Starting to send messages!"
+		}
+		clients = append(clients, ws)
+	}
+	alerting <- &SourceMessage{Topic: "payments", Value: []byte(`{"status": "ERROR"}`)}
+
+	for i, ws := range clients {
+		var alert *event
+		for alert == nil {
+			var es []event
+			if err := websocket.JSON.Receive(ws, &es); err != nil {
+				t.Fatalf("Client %v didn't receive the alert. err=%v", i, err)
+			}
+			for j := range es {
+				if es[j].EventType == "alert" {
+					alert = &es[j]
+				}
+			}
+		}
+		if alert.SourceId != "Payments" {
+			t.Errorf("expected client %v to be sent the alert of Payments but got %+v", i, alert)
+		}
+	}
+	select {
+	case p := <-posts:
+		if !strings.Contains(p, `"alert":"errors"`) {
+			t.Errorf("expected the alert to be notified but got %v", p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the alert to be notified")
+	}
+	select {
+	case p := <-posts:
+		t.Errorf("expected the alert to be notified once, not per client, but got %v", p)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,233 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// session is a named view kept on the server: the config it subscribes
+// with, the UI state it last saved (e.g. its filters), whether it's paused
+// and the next offset of every partition it consumed, so reopening it
+// resumes exactly where it was left. Only the principal who created it may
+// open, read or delete it.
+type session struct {
+	Name    string                                `json:"name"`
+	Owner   string                                `json:"owner,omitempty"`
+	Config  json.RawMessage                       `json:"config"`
+	View    json.RawMessage                       `json:"view,omitempty"`
+	Paused  bool                                  `json:"paused"`
+	Offsets map[string]map[string]map[int32]int64 `json:"offsets,omitempty"` // by cluster alias, topic and partition
+	Updated time.Time                             `json:"updated"`
+}
+
+// sessionView is what clients see of a session: everything but its config,
+// which may hold credentials.
+type sessionView struct {
+	Name    string                                `json:"name"`
+	View    json.RawMessage                       `json:"view,omitempty"`
+	Paused  bool                                  `json:"paused"`
+	Offsets map[string]map[string]map[int32]int64 `json:"offsets,omitempty"`
+	Updated time.Time                             `json:"updated"`
+}
+
+func (s session) withoutConfig() sessionView {
+	return sessionView{Name: s.Name, View: s.View, Paused: s.Paused, Offsets: s.Offsets, Updated: s.Updated}
+}
+
+// sessionSaveInterval is how often an open session's position is saved.
+const sessionSaveInterval = 5 * time.Second
+
+type sessions struct {
+	store *store
+}
+
+func newSessions(dataDir string) *sessions {
+	return &sessions{store: newStore(dataDir, "sessions.json")}
+}
+
+func (s *sessions) get(name string) (session, bool, error) {
+	ss := map[string]session{}
+	if err := s.store.read(&ss); err != nil {
+		return session{}, false, fmt.Errorf("Could not read sessions. err=%v", err)
+	}
+	sess, ok := ss[name]
+	return sess, ok, nil
+}
+
+func (s *sessions) put(sess session) error {
+	ss := map[string]session{}
+	err := s.store.update(&ss, func() error {
+		ss[sess.Name] = sess
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Could not save session %v. err=%v", sess.Name, err)
+	}
+	return nil
+}
+
+// handler lists the caller's sessions, returns one given its name, or
+// deletes one. Sessions are listed without their configs.
+func (s *sessions) handler(w http.ResponseWriter, r *http.Request) {
+	ss := map[string]session{}
+	name := r.URL.Query().Get("name")
+	owner := principalOf(r).Name
+	switch r.Method {
+	case "GET":
+		if err := s.store.read(&ss); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if len(name) > 0 {
+			sess, ok := ss[name]
+			if !ok || sess.Owner != owner {
+				writeError(w, http.StatusNotFound, fmt.Errorf("Session %v not found", name))
+				return
+			}
+			writeJSON(w, http.StatusOK, sess.withoutConfig())
+			return
+		}
+		list := []sessionView{}
+		for _, sess := range ss {
+			if sess.Owner == owner {
+				list = append(list, sess.withoutConfig())
+			}
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		writeJSON(w, http.StatusOK, list)
+	case "DELETE":
+		found := false
+		err := s.store.update(&ss, func() error {
+			sess, ok := ss[name]
+			if found = ok && sess.Owner == owner; found {
+				delete(ss, name)
+			}
+			return nil
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, fmt.Errorf("Session %v not found", name))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+	}
+}
+
+// open returns the session name of owner, creating it with the config raw
+// if it doesn't exist yet; an existing session keeps its own config.
+func (s *sessions) open(name, owner string, raw json.RawMessage) (*namedSession, error) {
+	if !safeName(name) {
+		return nil, fmt.Errorf("Invalid session name %v", name)
+	}
+	sess, ok, err := s.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if ok && sess.Owner != owner {
+		return nil, fmt.Errorf("Session %v belongs to someone else", name)
+	}
+	if !ok {
+		sess = session{Name: name, Owner: owner, Config: raw, Updated: time.Now().UTC()}
+		if err := s.put(sess); err != nil {
+			return nil, err
+		}
+	}
+	if sess.Offsets == nil {
+		sess.Offsets = map[string]map[string]map[int32]int64{}
+	}
+	return &namedSession{sessions: s, session: sess, restored: ok}, nil
+}
+
+// config returns the config a restored session was created with, keeping
+// the heartbeat UUID of the connecting client, or else raw and c.
+func (n *namedSession) config(raw json.RawMessage, c *configJSON) (json.RawMessage, *configJSON, error) {
+	if !n.restored {
+		return raw, c, nil
+	}
+	restored := &configJSON{}
+	if err := json.Unmarshal(n.session.Config, restored); err != nil {
+		return nil, nil, fmt.Errorf("Invalid config in session %v. err=%v", n.session.Name, err)
+	}
+	restored.HeartbeatUUID = c.HeartbeatUUID
+	return n.session.Config, restored, nil
+}
+
+// namedSession tracks the state of an open session, saving it periodically.
+type namedSession struct {
+	sessions *sessions
+	session  session
+	restored bool
+	dirty    bool
+	lastSave time.Time
+}
+
+// restore reseeks the partitions being consumed to where the session left
+// them, returning events telling the UI the view to restore.
+func (n *namedSession) restore(cs clusters) []event {
+	if !n.restored {
+		return nil
+	}
+	events := []event{}
+	for _, c := range cs {
+		for topic, ps := range n.session.Offsets[c.alias] {
+			for p, offset := range ps {
+				next := offset
+				if _, err := c.seek(topic, p, func(client sarama.Client, p int32) (int64, error) { return next, nil }); err != nil {
+					events = append(events, event{EventType: "log", Text: fmt.Sprintf("Could not restore the position of session %v. err=%v", n.session.Name, err), Color: "warning"})
+				}
+			}
+		}
+	}
+	e := event{EventType: "session", Text: fmt.Sprintf("Restored session %v.", n.session.Name), Color: "happy"}
+	if len(n.session.View) > 0 {
+		var view map[string]interface{}
+		if json.Unmarshal(n.session.View, &view) == nil {
+			e.JSON = []map[string]interface{}{view}
+		}
+	}
+	return append(events, e)
+}
+
+func (n *namedSession) onMessage(cluster, topic string, partition int32, offset int64) {
+	if _, ok := n.session.Offsets[cluster]; !ok {
+		n.session.Offsets[cluster] = map[string]map[int32]int64{}
+	}
+	if _, ok := n.session.Offsets[cluster][topic]; !ok {
+		n.session.Offsets[cluster][topic] = map[int32]int64{}
+	}
+	n.session.Offsets[cluster][topic][partition] = offset + 1
+	n.dirty = true
+}
+
+// pause remembers whether the session is paused, saving it right away.
+func (n *namedSession) pause(paused bool, now time.Time) error {
+	n.session.Paused, n.dirty = paused, true
+	return n.save(now, true)
+}
+
+// view remembers the UI state of the session, saving it right away.
+func (n *namedSession) view(view json.RawMessage, now time.Time) error {
+	n.session.View, n.dirty = view, true
+	return n.save(now, true)
+}
+
+// save persists the session if it changed, at most once per interval unless
+// forced.
+func (n *namedSession) save(now time.Time, force bool) error {
+	if n == nil || !n.dirty || (!force && now.Sub(n.lastSave) < sessionSaveInterval) {
+		return nil
+	}
+	n.session.Updated = now.UTC()
+	n.dirty, n.lastSave = false, now
+	return n.sessions.put(n.session)
+}
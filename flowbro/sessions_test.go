@@ -0,0 +1,180 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestNamedSessions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newSessions(dir)
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := s.open("../escape", "", nil); err == nil {
+		t.Errorf("expected invalid session names to be rejected")
+	}
+	n, err := s.open("debug", "alice", json.RawMessage(`{"fsmId":"42"}`))
+	if err != nil || n.restored {
+		t.Fatalf("expected a new session but got %+v, err=%v", n, err)
+	}
+	n.onMessage("eu", "orders", 1, 41)
+	if err := n.save(now, false); err != nil {
+		t.Fatal(err)
+	}
+	n.onMessage("eu", "orders", 1, 42)
+	n.save(now.Add(time.Second), false)
+	if sess, _, _ := s.get("debug"); sess.Offsets["eu"]["orders"][1] != 42 {
+		t.Errorf("expected the position to be saved at most once per interval but got %+v", sess.Offsets)
+	}
+	n.view(json.RawMessage(`{"filterIds":["a"]}`), now.Add(2*time.Second))
+
+	if _, err := s.open("debug", "bob", nil); err == nil {
+		t.Errorf("expected others' sessions not to be opened")
+	}
+	n, err = s.open("debug", "alice", json.RawMessage(`{"fsmId":"other"}`))
+	if err != nil || !n.restored {
+		t.Fatalf("expected the session to be restored but got %+v, err=%v", n, err)
+	}
+	if n.session.Offsets["eu"]["orders"][1] != 43 || !strings.Contains(string(n.session.View), "filterIds") {
+		t.Errorf("expected the session's position and view to be restored but got %+v", n.session)
+	}
+	raw, c, err := n.config(json.RawMessage(`{"fsmId":"other"}`), &configJSON{FSMId: "other", HeartbeatUUID: "uuid"})
+	if err != nil || !strings.Contains(string(raw), "42") || c.FSMId != "42" || c.HeartbeatUUID != "uuid" {
+		t.Errorf("expected the session's config with the client's heartbeat but got %s %+v, err=%v", raw, c, err)
+	}
+	if events := n.restore(clusters{}); len(events) != 1 || events[0].EventType != "session" || events[0].JSON[0]["filterIds"] == nil {
+		t.Errorf("expected a session event with the view but got %+v", events)
+	}
+}
+
+func TestSessionsHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newSessions(dir)
+	s.put(session{Name: "b"})
+	s.put(session{Name: "a"})
+
+	tests := []struct {
+		method, url string
+		status      int
+		body        string
+	}{
+		{"GET", "/api/sessions", http.StatusOK, `"name":"a"`},
+		{"GET", "/api/sessions?name=b", http.StatusOK, `"name":"b"`},
+		{"GET", "/api/sessions?name=c", http.StatusNotFound, "not found"},
+		{"DELETE", "/api/sessions?name=a", http.StatusNoContent, ""},
+		{"DELETE", "/api/sessions?name=a", http.StatusNotFound, "not found"},
+		{"POST", "/api/sessions", http.StatusMethodNotAllowed, "not allowed"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.handler(w, httptest.NewRequest(tt.method, tt.url, nil))
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("on '%v %v': expected %v with %q but got %v with %q", tt.method, tt.url, tt.status, tt.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSessionsAreOnlyListedToTheirOwners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newSessions(dir)
+	s.put(session{Name: "a", Owner: "alice", Config: json.RawMessage(`{"webhooks":[{"url":"https://hooks.example.com/secret"}]}`)})
+	s.put(session{Name: "b", Owner: "bob"})
+
+	tests := []struct {
+		method, url, owner string
+		status             int
+		body               string
+	}{
+		{"GET", "/api/sessions", "alice", http.StatusOK, `[{"name":"a","paused":false,"updated":"0001-01-01T00:00:00Z"}]`},
+		{"GET", "/api/sessions?name=a", "alice", http.StatusOK, `{"name":"a","paused":false,"updated":"0001-01-01T00:00:00Z"}`},
+		{"GET", "/api/sessions?name=a", "bob", http.StatusNotFound, "not found"},
+		{"GET", "/api/sessions", "carol", http.StatusOK, `[]`},
+		{"DELETE", "/api/sessions?name=a", "bob", http.StatusNotFound, "not found"},
+		{"DELETE", "/api/sessions?name=a", "alice", http.StatusNoContent, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, tt.url, nil)
+		s.handler(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal{Name: tt.owner, Role: operator})))
+		if w.Code != tt.status || !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("on '%v %v' as %v: expected %v with %q but got %v with %q", tt.method, tt.url, tt.owner, tt.status, tt.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSessionsArePausedAndRestoredOverWebSocket(t *testing.T) {
+	path := writeTempFile(t, `{"topic":"requests","value":{}}`)
+	defer os.Remove(path)
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := httptestServer(&flowbro{mockPath: path, dataDir: dir})
+	defer s.Close()
+
+	connect := func(conf configJSON) *websocket.Conn {
+		ws, err := websocket.Dial(strings.Replace(s.URL, "http", "ws", 1)+"/ws", "", s.URL)
+		if err != nil {
+			t.Fatalf("Could not open WebSocket: %v", err)
+		}
+		websocket.JSON.Send(ws, conf)
+		ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+		return ws
+	}
+	waitFor := func(ws *websocket.Conn, eventType, text string) event {
+		for {
+			var es []event
+			if err := websocket.JSON.Receive(ws, &es); err != nil {
+				t.Fatalf("Didn't receive %v %q. err=%v", eventType, text, err)
+			}
+			for _, e := range es {
+				if e.EventType == eventType && strings.Contains(e.Text, text) {
+					return e
+				}
+			}
+		}
+	}
+
+	ws := connect(configJSON{Session: "debug", FSMId: "42", HeartbeatUUID: "uuid"})
+	websocket.JSON.Send(ws, map[string]interface{}{"action": "pause"})
+	waitFor(ws, "log", "Paused consuming")
+	websocket.JSON.Send(ws, map[string]interface{}{"action": "view", "view": map[string]interface{}{"filterFSMId": "42"}})
+	waitFor(ws, "log", "Saved the view")
+	ws.Close()
+
+	ws = connect(configJSON{Session: "debug", HeartbeatUUID: "other"})
+	defer ws.Close()
+	e := waitFor(ws, "session", "Restored session debug")
+	if len(e.JSON) != 1 || e.JSON[0]["filterFSMId"] != "42" {
+		t.Errorf("expected the saved view to be restored but got %+v", e)
+	}
+	sess, _, _ := newSessions(dir).get("debug")
+	var conf configJSON
+	json.Unmarshal(sess.Config, &conf)
+	if !sess.Paused || conf.FSMId != "42" {
+		t.Errorf("expected the session to keep its config and stay paused but got %+v", sess)
+	}
+}
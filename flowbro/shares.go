@@ -0,0 +1,213 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// share is a short-lived, read-only view of a stream, opened with its token:
+// the config of a named session or of the request minting it, optionally
+// narrowed by a filter expression and a time range to replay. Its config
+// never leaves the server, as it may hold credentials: the session's is
+// resolved when the share is opened, and clients only see its view.
+type share struct {
+	Token   string          `json:"token"`
+	Session string          `json:"session,omitempty"`
+	Config  json.RawMessage `json:"config,omitempty"`
+	Filter  string          `json:"filter,omitempty"`
+	From    *int64          `json:"from,omitempty"` // milliseconds since epoch
+	To      *int64          `json:"to,omitempty"`
+	Expires time.Time       `json:"expires"`
+}
+
+// shareView is what clients see of a share.
+type shareView struct {
+	Token   string    `json:"token"`
+	Filter  string    `json:"filter,omitempty"`
+	From    *int64    `json:"from,omitempty"`
+	To      *int64    `json:"to,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+type shareRequest struct {
+	Session    string          `json:"session"`
+	Config     json.RawMessage `json:"config"`
+	Filter     string          `json:"filter"`
+	From       *int64          `json:"from"`
+	To         *int64          `json:"to"`
+	TTLSeconds float64         `json:"ttlSeconds"`
+}
+
+const (
+	defaultShareTTL = time.Hour
+	maxShareTTL     = 7 * 24 * time.Hour
+)
+
+type shares struct {
+	store    *store
+	sessions *sessions
+}
+
+func newShares(dataDir string, sessions *sessions) *shares {
+	return &shares{store: newStore(dataDir, "shares.json"), sessions: sessions}
+}
+
+// mint stores a new share for req, forgetting expired ones.
+func (s *shares) mint(req shareRequest, now time.Time) (share, error) {
+	if (len(req.Session) == 0) == (len(req.Config) == 0) {
+		return share{}, fmt.Errorf("Please define either the session or the config to share")
+	}
+	if (req.From == nil) != (req.To == nil) || (req.From != nil && *req.From >= *req.To) {
+		return share{}, fmt.Errorf("Please define both the from and to timestamps of the time range to share, from before to")
+	}
+	if len(req.Filter) > 0 {
+		if _, err := compileCEL(req.Filter); err != nil {
+			return share{}, fmt.Errorf("Invalid filter to share. err=%v", err)
+		}
+	}
+	ttl := time.Duration(req.TTLSeconds * float64(time.Second))
+	if ttl <= 0 {
+		ttl = defaultShareTTL
+	}
+	if ttl > maxShareTTL {
+		return share{}, fmt.Errorf("Shares can live at most %v", maxShareTTL)
+	}
+
+	sh := share{Token: newId() + newId(), Session: req.Session, Config: req.Config, Filter: req.Filter, From: req.From, To: req.To, Expires: now.Add(ttl).UTC()}
+	if _, _, err := s.config(sh, ""); err != nil {
+		return share{}, err
+	}
+
+	shs := map[string]share{}
+	err := s.store.update(&shs, func() error {
+		for token, old := range shs {
+			if now.After(old.Expires) {
+				delete(shs, token)
+			}
+		}
+		shs[sh.Token] = sh
+		return nil
+	})
+	if err != nil {
+		return share{}, fmt.Errorf("Could not save share. err=%v", err)
+	}
+	return sh, nil
+}
+
+// get returns the share with token, unless it expired.
+func (s *shares) get(token string, now time.Time) (share, error) {
+	shs := map[string]share{}
+	if err := s.store.read(&shs); err != nil {
+		return share{}, fmt.Errorf("Could not read shares. err=%v", err)
+	}
+	sh, ok := shs[token]
+	if !ok || now.After(sh.Expires) {
+		return share{}, fmt.Errorf("Share not found or expired")
+	}
+	return sh, nil
+}
+
+// config resolves the config sh is a view of, narrowed by its filter, with
+// the heartbeat UUID of the client viewing it. Viewers don't record nor keep
+// dead letters.
+func (s *shares) config(sh share, heartbeatUUID string) (json.RawMessage, *configJSON, error) {
+	raw := sh.Config
+	if len(sh.Session) > 0 {
+		sess, ok, err := s.sessions.get(sh.Session)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			return nil, nil, fmt.Errorf("Session %v not found", sh.Session)
+		}
+		raw = sess.Config
+	}
+	c := &configJSON{}
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, nil, fmt.Errorf("Invalid config to share. err=%v", err)
+	}
+	c.Kafka.Filter = andFilter(c.Kafka.Filter, sh.Filter)
+	c.HeartbeatUUID, c.Session, c.Recording, c.DeadLetter = heartbeatUUID, "", nil, nil
+	return raw, c, nil
+}
+
+// handler mints shares on POST, returns the view of one given its token on
+// GET, without its config, and revokes one on DELETE.
+func (s *shares) handler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	switch r.Method {
+	case "GET":
+		sh, err := s.get(token, time.Now())
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, sh.view())
+	case "POST":
+		var req shareRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid share. err=%v", err))
+			return
+		}
+		sh, err := s.mint(req, time.Now())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"token": sh.Token, "url": "/?share=" + sh.Token, "expires": sh.Expires})
+	case "DELETE":
+		shs, found := map[string]share{}, false
+		err := s.store.update(&shs, func() error {
+			_, found = shs[token]
+			delete(shs, token)
+			return nil
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !found {
+			writeError(w, http.StatusNotFound, fmt.Errorf("Share not found or expired"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+	}
+}
+
+func (sh share) view() shareView {
+	return shareView{Token: sh.Token, Filter: sh.Filter, From: sh.From, To: sh.To, Expires: sh.Expires}
+}
+
+// flow returns the configUpdate event drawing the flow of the shared config
+// raw, as its viewers don't have it.
+func (sh share) flow(raw json.RawMessage, c *configJSON) event {
+	var fl flowJSON
+	json.Unmarshal(raw, &fl)
+	return configUpdate("Opened a shared view", fl, c.Kafka.Filter)
+}
+
+// window reseeks every partition being consumed to the start of the shared
+// time range, returning the window bounding the replay and events telling
+// the viewer about it.
+func (sh share) window(cs clusters) (*replayWindow, []event) {
+	if sh.From == nil {
+		return nil, nil
+	}
+	text, seeked, err := replayWindowControl(control{From: sh.From, To: sh.To}, cs)
+	if err != nil {
+		return nil, []event{{EventType: "log", Text: err.Error(), Color: "warning"}}
+	}
+	w, err := newReplayWindow(cs, "", seeked, *sh.From, *sh.To)
+	if err != nil {
+		return nil, []event{{EventType: "log", Text: err.Error(), Color: "warning"}}
+	}
+	events := []event{{EventType: "log", Text: text, Color: "happy"}, w.begin()}
+	if e, ok := w.end(); ok {
+		events = append(events, e)
+	}
+	return w, events
+}
@@ -0,0 +1,186 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestMintShares(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sessions := newSessions(dir)
+	sessions.put(session{Name: "debug", Config: json.RawMessage(`{"kafka":{"filter":"topic == 'orders'"},"recording":{"name":"r"}}`)})
+	s := newShares(dir, sessions)
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	from, to := int64(1000), int64(2000)
+
+	tests := []struct {
+		req   shareRequest
+		valid bool
+	}{
+		{shareRequest{Session: "debug"}, true},
+		{shareRequest{Config: json.RawMessage(`{}`), Filter: "key == '1'", From: &from, To: &to}, true},
+		{shareRequest{}, false},
+		{shareRequest{Session: "debug", Config: json.RawMessage(`{}`)}, false},
+		{shareRequest{Session: "missing"}, false},
+		{shareRequest{Config: json.RawMessage(`[]`)}, false},
+		{shareRequest{Session: "debug", Filter: "key =="}, false},
+		{shareRequest{Session: "debug", From: &from}, false},
+		{shareRequest{Session: "debug", From: &to, To: &from}, false},
+		{shareRequest{Session: "debug", TTLSeconds: 30 * 24 * 3600}, false},
+	}
+	for _, tt := range tests {
+		sh, err := s.mint(tt.req, now)
+		if (err == nil) != tt.valid {
+			t.Errorf("on '%+v': expected valid=%v but got err=%v", tt.req, tt.valid, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got, err := s.get(sh.Token, now.Add(time.Minute)); err != nil || got.Token != sh.Token {
+			t.Errorf("on '%+v': expected the share to be found but got %+v, err=%v", tt.req, got, err)
+		}
+		if _, err := s.get(sh.Token, now.Add(2*time.Hour)); err == nil {
+			t.Errorf("on '%+v': expected the share to expire after an hour", tt.req)
+		}
+	}
+
+	sh, _ := s.mint(shareRequest{Session: "debug", Filter: "key == '1'"}, now)
+	_, c, err := s.config(sh, "uuid")
+	if err != nil || c.Kafka.Filter != "(topic == 'orders') && (key == '1')" || c.HeartbeatUUID != "uuid" || c.Recording != nil {
+		t.Errorf("expected the session's filter narrowed by the share's, without recording, but got %+v, err=%v", c, err)
+	}
+}
+
+func TestSharesHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := newShares(dir, newSessions(dir))
+
+	w := httptest.NewRecorder()
+	s.handler(w, httptest.NewRequest("POST", "/api/share", bytes.NewBufferString(`{"config":{"fsmId":"42"}}`)))
+	var minted struct {
+		Token, URL string
+	}
+	json.NewDecoder(w.Body).Decode(&minted)
+	if w.Code != http.StatusCreated || len(minted.Token) == 0 || minted.URL != "/?share="+minted.Token {
+		t.Fatalf("expected a share to be minted but got %v with %+v", w.Code, minted)
+	}
+
+	tests := []struct {
+		method, url string
+		body        string
+		status      int
+	}{
+		{"GET", "/api/share?token=" + minted.Token, "", http.StatusOK},
+		{"GET", "/api/share?token=nope", "", http.StatusNotFound},
+		{"POST", "/api/share", "{", http.StatusBadRequest},
+		{"POST", "/api/share", "{}", http.StatusBadRequest},
+		{"PUT", "/api/share", "", http.StatusMethodNotAllowed},
+		{"DELETE", "/api/share?token=" + minted.Token, "", http.StatusNoContent},
+		{"GET", "/api/share?token=" + minted.Token, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		s.handler(w, httptest.NewRequest(tt.method, tt.url, bytes.NewBufferString(tt.body)))
+		if w.Code != tt.status {
+			t.Errorf("on '%v %v': expected %v but got %v with %v", tt.method, tt.url, tt.status, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestSharesDontEchoTheirConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sessions := newSessions(dir)
+	sessions.put(session{Name: "debug", Config: json.RawMessage(`{"kafka":{"filter":"topic == 'orders'"},"webhooks":[{"url":"https://hooks.example.com/secret"}]}`)})
+	s := newShares(dir, sessions)
+	sh, err := s.mint(shareRequest{Session: "debug", Filter: "key == '1'"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.handler(w, httptest.NewRequest("GET", "/api/share?token="+sh.Token, nil))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "secret") || strings.Contains(w.Body.String(), "config") || !strings.Contains(w.Body.String(), "key == '1'") {
+		t.Errorf("expected only the share's token, filter and expiry but got %v with %v", w.Code, w.Body.String())
+	}
+
+	sessions.put(session{Name: "debug", Config: json.RawMessage(`{"kafka":{"filter":"topic == 'payments'"}}`)})
+	_, c, err := s.config(sh, "")
+	if err != nil || c.Kafka.Filter != "(topic == 'payments') && (key == '1')" {
+		t.Errorf("expected the session's config to be resolved when the share is opened but got %+v, err=%v", c, err)
+	}
+}
+
+func TestSharedViewsAreReadOnly(t *testing.T) {
+	path := writeTempFile(t, `{"topic":"requests","key":"1","value":{}}
+{"topic":"requests","key":"2","value":{}}
+`)
+	defer os.Remove(path)
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := &flowbro{mockPath: path, dataDir: dir}
+	s := httptestServer(f)
+	defer s.Close()
+	conf, _ := json.Marshal(configJSON{Rules: []rule{{
+		Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "requests"}},
+		Events:   []event{{EventType: "message", SourceId: "a", TargetId: "b", FSMId: "{{.Key}}"}},
+	}}})
+	sh, err := f.shares.mint(shareRequest{Config: conf, Filter: "key == '2'"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws, err := websocket.Dial(strings.Replace(s.URL, "http", "ws", 1)+"/ws", "", s.URL)
+	if err != nil {
+		t.Fatalf("Could not open WebSocket: %v", err)
+	}
+	defer ws.Close()
+	websocket.JSON.Send(ws, configJSON{Share: sh.Token, HeartbeatUUID: "uuid"})
+	websocket.JSON.Send(ws, map[string]interface{}{"action": "pause"})
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	messages, readOnly := []event{}, false
+	for {
+		var es []event
+		if err := websocket.JSON.Receive(ws, &es); err != nil {
+			break
+		}
+		for _, e := range es {
+			readOnly = readOnly || strings.Contains(e.Text, "read-only")
+			if e.EventType == "message" {
+				messages = append(messages, e)
+			}
+		}
+	}
+	if len(messages) != 1 || messages[0].FSMId != "2" {
+		t.Errorf("expected only the message matching the shared filter but got %+v", messages)
+	}
+	if !readOnly {
+		t.Errorf("expected controls to be refused on a shared view")
+	}
+}
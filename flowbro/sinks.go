@@ -0,0 +1,144 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+type kafkaSinkJSON struct {
+	Name    string `json:"name"`
+	Topics  string `json:"topics"`
+	Filter  string `json:"filter"`
+	Topic   string `json:"topic"`
+	Cluster string `json:"cluster"`
+	Brokers string `json:"brokers"`
+}
+
+// kafkaSink re-produces the messages of the matching topics, as shown on
+// the board after transforms, to an output topic, possibly on another
+// cluster.
+type kafkaSink struct {
+	name     string
+	topics   *regexp.Regexp
+	filter   *celProgram
+	topic    string
+	brokers  []string
+	producer sarama.SyncProducer
+}
+
+var forwarded = newCounter("flowbro_forwarded_messages_total", "Messages produced by Kafka sinks.", "sink")
+
+// processKafkaSinks resolves the brokers of every sink: those of the named
+// cluster, the given brokers, or else the default brokers.
+func processKafkaSinks(sinksJSON []kafkaSinkJSON, clusters map[string][]string, brokers []string) ([]*kafkaSink, error) {
+	sinks := []*kafkaSink{}
+	for _, s := range sinksJSON {
+		if len(s.Name) == 0 || len(s.Topics) == 0 || len(s.Topic) == 0 {
+			return nil, fmt.Errorf("Please define name, topics and topic for your kafka sink %v", s)
+		}
+		sink := &kafkaSink{name: s.Name, topic: s.Topic, brokers: brokers}
+		switch {
+		case len(s.Cluster) > 0:
+			b, ok := clusters[s.Cluster]
+			if !ok {
+				return nil, fmt.Errorf("Unknown cluster %v for kafka sink %v", s.Cluster, s.Name)
+			}
+			sink.brokers = b
+		case len(s.Brokers) > 0:
+			sink.brokers = strings.Split(s.Brokers, ",")
+		}
+		topics, err := regexp.Compile("^(?:" + s.Topics + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid topic regex for kafka sink %v. err=%v", s.Name, err)
+		}
+		sink.topics = topics
+		if topics.MatchString(s.Topic) {
+			return nil, fmt.Errorf("Kafka sink %v would forward the messages it produces to topic %v; please narrow its topics", s.Name, s.Topic)
+		}
+		if len(s.Filter) > 0 {
+			filter, err := compileCEL(s.Filter)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid filter for kafka sink %v. err=%v", s.Name, err)
+			}
+			sink.filter = filter
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// openKafkaSinks creates a producer for every sink, closing them all if any
+// fails.
+func openKafkaSinks(sinks []*kafkaSink) error {
+	for _, s := range sinks {
+		producer, err := newSyncProducer(s.brokers)
+		if err != nil {
+			closeKafkaSinks(sinks)
+			return fmt.Errorf("Could not create producer for kafka sink %v. err=%v", s.name, err)
+		}
+		s.producer = producer
+	}
+	return nil
+}
+
+func closeKafkaSinks(sinks []*kafkaSink) {
+	for _, s := range sinks {
+		if s.producer != nil {
+			s.producer.Close()
+			s.producer = nil
+		}
+	}
+}
+
+// forwardToSinks produces m to every open sink matching it, keeping its raw
+// key, returning log events for the sinks that failed.
+func forwardToSinks(sinks []*kafkaSink, m message) []event {
+	events := []event{}
+	for _, s := range sinks {
+		if s.producer == nil || !s.topics.MatchString(m.Topic) {
+			continue
+		}
+		if s.filter != nil {
+			keep, err := s.filter.match(m)
+			if err != nil {
+				events = append(events, event{EventType: "log", Text: fmt.Sprintf("Kafka sink [%v] could not filter message of topic %v. err=%v", s.name, m.Topic, err), Color: "error"})
+			}
+			if err != nil || !keep {
+				continue
+			}
+		}
+		if err := s.produce(m); err != nil {
+			events = append(events, event{EventType: "log", Text: err.Error(), Color: "error"})
+			continue
+		}
+		forwarded.inc(s.name)
+	}
+	return events
+}
+
+func (s *kafkaSink) produce(m message) error {
+	value := m.Value
+	if m.Output != nil {
+		value = m.Output
+	}
+	byt, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("Kafka sink [%v] could not encode message of topic %v. err=%v", s.name, m.Topic, err)
+	}
+	key := m.KeyRaw
+	if key == nil && len(m.Key) > 0 {
+		key = []byte(m.Key)
+	}
+	pm := &sarama.ProducerMessage{Topic: s.topic, Value: sarama.ByteEncoder(byt)}
+	if key != nil {
+		pm.Key = sarama.ByteEncoder(key)
+	}
+	if _, _, err := s.producer.SendMessage(pm); err != nil {
+		return fmt.Errorf("Kafka sink [%v] could not produce to topic %v. err=%v", s.name, s.topic, err)
+	}
+	return nil
+}
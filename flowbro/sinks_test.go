@@ -0,0 +1,96 @@
+package flowbro
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+func TestProcessKafkaSinks(t *testing.T) {
+	clusters := map[string][]string{"us": {"us1:9092", "us2:9092"}}
+	ts := []struct {
+		sink            kafkaSinkJSON
+		expectedBrokers []string
+		fails           bool
+	}{
+		{kafkaSinkJSON{Name: "s", Topics: "orders", Topic: "debug"}, []string{"localhost:9092"}, false},
+		{kafkaSinkJSON{Name: "s", Topics: "orders", Topic: "debug", Cluster: "us"}, []string{"us1:9092", "us2:9092"}, false},
+		{kafkaSinkJSON{Name: "s", Topics: "orders", Topic: "debug", Brokers: "a:1,b:2"}, []string{"a:1", "b:2"}, false},
+		{kafkaSinkJSON{Name: "s", Topics: "orders", Topic: "debug", Cluster: "eu"}, nil, true},
+		{kafkaSinkJSON{Name: "s", Topics: "orders", Topic: "debug", Filter: "value.("}, nil, true},
+		{kafkaSinkJSON{Name: "s", Topics: "orders(", Topic: "debug"}, nil, true},
+		{kafkaSinkJSON{Name: "s", Topics: "orders.*", Topic: "orders-debug"}, nil, true},
+		{kafkaSinkJSON{Name: "s", Topic: "debug"}, nil, true},
+		{kafkaSinkJSON{Topics: "orders", Topic: "debug"}, nil, true},
+		{kafkaSinkJSON{Name: "s", Topics: "orders"}, nil, true},
+	}
+
+	for _, tc := range ts {
+		sinks, err := processKafkaSinks([]kafkaSinkJSON{tc.sink}, clusters, []string{"localhost:9092"})
+		if tc.fails {
+			if err == nil {
+				t.Errorf("on '%+v': expected processing the sink to fail", tc.sink)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on '%+v': shouldn't have failed but did with %v", tc.sink, err)
+			continue
+		}
+		if !reflect.DeepEqual(sinks[0].brokers, tc.expectedBrokers) {
+			t.Errorf("on '%+v': expected brokers %v but got %v", tc.sink, tc.expectedBrokers, sinks[0].brokers)
+		}
+	}
+}
+
+func TestForwardToSinks(t *testing.T) {
+	sinks, err := processKafkaSinks([]kafkaSinkJSON{
+		{Name: "big", Topics: "orders", Topic: "big-orders", Filter: "value.amount > 10"},
+		{Name: "all", Topics: "orders|payments", Topic: "debug"},
+	}, nil, nil)
+	if err != nil {
+		t.Fatalf("shouldn't have failed processing the sinks but did with %v", err)
+	}
+	big, all := mocks.NewSyncProducer(t, nil), mocks.NewSyncProducer(t, nil)
+	sinks[0].producer, sinks[1].producer = big, all
+
+	expectValue := func(expected string) mocks.ValueChecker {
+		return func(val []byte) error {
+			if string(val) != expected {
+				return fmt.Errorf("expected value %v but got %s", expected, val)
+			}
+			return nil
+		}
+	}
+	big.ExpectSendMessageWithCheckerFunctionAndSucceed(expectValue(`{"amount":20}`))
+	all.ExpectSendMessageWithCheckerFunctionAndSucceed(expectValue(`{"amount":20}`))
+	all.ExpectSendMessageWithCheckerFunctionAndSucceed(expectValue(`{"shown":true}`))
+	all.ExpectSendMessageAndFail(sarama.ErrLeaderNotAvailable)
+
+	ts := []struct {
+		m              message
+		expectedErrors int
+	}{
+		{message{Topic: "orders", Key: "k", Value: map[string]interface{}{"amount": 20.0}}, 0},
+		{message{Topic: "payments", Value: map[string]interface{}{"amount": 20.0}, Output: map[string]interface{}{"shown": true}}, 0},
+		{message{Topic: "refunds", Value: map[string]interface{}{"amount": 20.0}}, 0},
+		{message{Topic: "orders", Value: map[string]interface{}{"amount": 5.0}}, 1},
+	}
+
+	for _, tc := range ts {
+		if events := forwardToSinks(sinks, tc.m); len(events) != tc.expectedErrors {
+			t.Errorf("on '%+v': expected %v error events but got %+v", tc.m, tc.expectedErrors, events)
+		}
+	}
+	closeKafkaSinks(sinks)
+}
+
+func TestForwardToClosedSinks(t *testing.T) {
+	sinks, _ := processKafkaSinks([]kafkaSinkJSON{{Name: "s", Topics: "orders", Topic: "debug"}}, nil, nil)
+	if events := forwardToSinks(sinks, message{Topic: "orders"}); len(events) != 0 {
+		t.Errorf("expected sinks without producers to be skipped but got %+v", events)
+	}
+}
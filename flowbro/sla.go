@@ -0,0 +1,98 @@
+package flowbro
+
+import (
+	"fmt"
+	"time"
+)
+
+type slaJSON struct {
+	From          string  `json:"from"`
+	To            string  `json:"to"`
+	Correlation   string  `json:"correlation"`
+	ToCorrelation string  `json:"toCorrelation"`
+	WithinSeconds float64 `json:"withinSeconds"`
+}
+
+var slaBreaches = newCounter("flowbro_sla_breaches_total", "Flows that didn't go from one stage to the next within their alert's SLA.", "alert")
+
+// processSLA tracks flows open between the from and to stages of an SLA
+// alert, reusing the request/response matching of pairs.
+func processSLA(name, componentId string, c *slaJSON) (*pair, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if c.WithinSeconds <= 0 {
+		return nil, fmt.Errorf("Please define withinSeconds for the SLA")
+	}
+	return newPair(pairJSON{
+		Name:                name,
+		Request:             c.From,
+		Response:            c.To,
+		Correlation:         c.Correlation,
+		ResponseCorrelation: c.ToCorrelation,
+		TimeoutSeconds:      c.WithinSeconds,
+		ComponentId:         componentId,
+	})
+}
+
+// slaBreach counts a breach and returns the event telling clients about it.
+func slaBreach(sla *pair, text string, m message) event {
+	slaBreaches.inc(sla.name)
+	return event{
+		EventType: "slaBreach",
+		SourceId:  sla.componentId,
+		Text:      text,
+		JSON:      []map[string]interface{}{m.Value},
+		Color:     "error",
+		Highlight: true,
+		Cluster:   m.Cluster,
+	}
+}
+
+// checkSLA returns the breach text if the flow from reached its next stage
+// in to later than the SLA allows, judging by their Kafka timestamps.
+func checkSLA(sla *pair, r openRequest, to message) (string, bool) {
+	took := to.Timestamp.Sub(r.m.Timestamp)
+	if took <= sla.timeout {
+		return "", false
+	}
+	return fmt.Sprintf("SLA [%v]: flow %v took %v from topic %v to topic %v, over the SLA of %v.", sla.name, r.id, took, r.m.Topic, to.Topic, sla.timeout), true
+}
+
+func expiredSLAText(sla *pair, r openRequest) string {
+	return fmt.Sprintf("SLA [%v]: flow %v on topic %v didn't reach the next stage within %v.", sla.name, r.id, r.m.Topic, sla.timeout)
+}
+
+// onSLAMessage returns the breach events for alert i's SLA caused by m, and
+// the alert event if the breach fired the alert.
+func (a *alerter) onSLAMessage(i int, m message, now time.Time) []event {
+	sla := a.alerts[i].sla
+	r, ok := sla.match(m, now)
+	if !ok {
+		return nil
+	}
+	text, breached := checkSLA(sla, r, m)
+	if !breached {
+		return nil
+	}
+	return a.breach(i, text, m, now)
+}
+
+// checkSLAs returns the breach events for alert i's flows still open after
+// the SLA.
+func (a *alerter) checkSLAs(i int, now time.Time) []event {
+	events := []event{}
+	sla := a.alerts[i].sla
+	for _, r := range sla.expire(now) {
+		events = append(events, a.breach(i, expiredSLAText(sla, r), r.m, now)...)
+	}
+	return events
+}
+
+func (a *alerter) breach(i int, text string, m message, now time.Time) []event {
+	events := []event{slaBreach(a.alerts[i].sla, text, m)}
+	if e, ok := a.fire(i, text, now); ok {
+		events = append(events, e)
+	}
+	return events
+}
@@ -0,0 +1,71 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSLAAlertsReportBreaches(t *testing.T) {
+	alerts, err := processAlerts([]alertJSON{
+		{Name: "shipping", ComponentId: "Shipping", SLA: &slaJSON{From: "orders", To: "shipments", Correlation: "value.orderId", WithinSeconds: 300}},
+	})
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	a := newAlerter(alerts, nil)
+	a.notify = nil
+
+	now := time.Now()
+	order := func(id string, at time.Time) message {
+		return message{Topic: "orders", Timestamp: at, Value: newValueFrom(`{"orderId":"` + id + `"}`)}
+	}
+	shipment := func(id string, at time.Time) message {
+		return message{Topic: "shipments", Timestamp: at, Value: newValueFrom(`{"orderId":"` + id + `"}`)}
+	}
+	tests := []struct {
+		name     string
+		m        *message
+		check    bool
+		at       time.Time
+		expected []string
+	}{
+		{name: "order 1 placed", m: ptrMessage(order("1", now)), at: now, expected: []string{}},
+		{name: "order 1 shipped in time", m: ptrMessage(shipment("1", now.Add(time.Minute))), at: now, expected: []string{}},
+		{name: "order 2 placed", m: ptrMessage(order("2", now)), at: now, expected: []string{}},
+		{name: "order 2 shipped late", m: ptrMessage(shipment("2", now.Add(10*time.Minute))), at: now, expected: []string{"slaBreach", "alert"}},
+		{name: "unknown order shipped", m: ptrMessage(shipment("3", now)), at: now, expected: []string{}},
+		{name: "order 4 placed", m: ptrMessage(order("4", now)), at: now.Add(time.Second), expected: []string{}},
+		{name: "order 5 placed", m: ptrMessage(order("5", now)), at: now.Add(2 * time.Second), expected: []string{}},
+		{name: "orders 4 and 5 still open", check: true, at: now.Add(time.Minute), expected: []string{}},
+		{name: "orders 4 and 5 never shipped", check: true, at: now.Add(6 * time.Minute), expected: []string{"slaBreach", "alert", "slaBreach"}},
+	}
+
+	for _, ts := range tests {
+		var es []event
+		if ts.check {
+			es = a.check(ts.at)
+		} else {
+			es = a.onMessage(*ts.m, ts.at)
+		}
+		if len(es) != len(ts.expected) {
+			t.Errorf("on '%v': expected %v events but got %+v", ts.name, ts.expected, es)
+			continue
+		}
+		for i, e := range es {
+			if e.EventType != ts.expected[i] || e.SourceId != "Shipping" {
+				t.Errorf("on '%v': expected %v event from Shipping but got %+v", ts.name, ts.expected[i], e)
+			}
+		}
+	}
+}
+
+func TestSLAAlertsNeedWithin(t *testing.T) {
+	_, err := processAlerts([]alertJSON{{Name: "shipping", SLA: &slaJSON{From: "orders", To: "shipments", Correlation: "value.orderId"}}})
+	if err == nil {
+		t.Errorf("expected an error for an SLA without withinSeconds")
+	}
+}
+
+func ptrMessage(m message) *message {
+	return &m
+}
@@ -0,0 +1,136 @@
+package flowbro
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+type snapshotJSON struct {
+	Topics         []string `json:"topics"`
+	MaxKeys        int      `json:"maxKeys"`
+	TimeoutSeconds float64  `json:"timeoutSeconds"`
+}
+
+const (
+	defaultSnapshotMaxKeys = 10000
+	defaultSnapshotTimeout = 30 * time.Second
+)
+
+// snapshot is the latest value per key of a topic, read from its beginning
+// when a client connects so stateful diagrams start from the current state
+// rather than from whatever changes next.
+type snapshot struct {
+	rows    map[string]tableRow
+	maxKeys int
+}
+
+// read reads every partition of topic up to its current end, giving up
+// after timeout and keeping what was read by then.
+func (c *cluster) read(topic string, timeout time.Duration, f func(*sarama.ConsumerMessage)) error {
+	if c.client == nil {
+		return nil
+	}
+	partitions, err := c.client.Partitions(topic)
+	if err != nil {
+		return err
+	}
+	consumer, err := sarama.NewConsumerFromClient(c.client)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	deadline := time.After(timeout)
+	for _, p := range partitions {
+		oldest, err := c.client.GetOffset(topic, p, sarama.OffsetOldest)
+		if err != nil {
+			return err
+		}
+		newest, err := c.client.GetOffset(topic, p, sarama.OffsetNewest)
+		if err != nil {
+			return err
+		}
+		if oldest >= newest {
+			continue
+		}
+		pc, err := consumer.ConsumePartition(topic, p, oldest)
+		if err != nil {
+			return err
+		}
+		for done := false; !done; {
+			select {
+			case m := <-pc.Messages():
+				f(m)
+				done = m.Offset+1 >= newest
+			case <-deadline:
+				pc.Close()
+				return fmt.Errorf("Timed out reading topic %v", topic)
+			}
+		}
+		pc.Close()
+	}
+	return nil
+}
+
+func (s *snapshot) add(m message) {
+	if _, ok := s.rows[m.Key]; !ok && len(s.rows) >= s.maxKeys {
+		return
+	}
+	s.rows[m.Key] = tableRow{Key: m.Key, Value: m.Value, Cluster: m.Cluster, Partition: m.Partition, Offset: m.Offset, Timestamp: m.Timestamp}
+}
+
+func (s *snapshot) event(topic string, err error) event {
+	rows := []tableRow{}
+	for _, r := range s.rows {
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Key < rows[j].Key })
+	e := event{EventType: "snapshot", Topic: topic, Rows: rows, Text: fmt.Sprintf("Snapshot of the latest value of %v keys of topic %v.", len(rows), topic)}
+	if err != nil {
+		e.Text = fmt.Sprintf("Partial snapshot of the latest value of %v keys of topic %v. err=%v", len(rows), topic, err)
+		e.Color = "warning"
+	}
+	return e
+}
+
+// snapshotEvents returns a snapshot event for every selected topic, read
+// from the clusters of the session.
+func snapshotEvents(c *snapshotJSON, cs clusters, config *config) []event {
+	maxKeys, timeout := c.MaxKeys, time.Duration(c.TimeoutSeconds*float64(time.Second))
+	if maxKeys <= 0 {
+		maxKeys = defaultSnapshotMaxKeys
+	}
+	if timeout <= 0 {
+		timeout = defaultSnapshotTimeout
+	}
+
+	events := []event{}
+	for _, topic := range c.Topics {
+		s := &snapshot{rows: map[string]tableRow{}, maxKeys: maxKeys}
+		var err error
+		for _, cl := range cs {
+			err = cl.read(topic, timeout, func(cm *sarama.ConsumerMessage) {
+				if cm.Value == nil {
+					if _, key, err := decodeKey(cm.Key, config.decoding(topic)); err == nil {
+						delete(s.rows, key)
+					}
+					return
+				}
+				m, err := newMessage(*fromConsumerMessage(cm), config.decoding(topic))
+				if err != nil {
+					return
+				}
+				m.Cluster = cl.alias
+				s.add(redact(config.redactions, m))
+			})
+			if err != nil {
+				break
+			}
+		}
+		events = append(events, s.event(topic, err))
+	}
+	return events
+}
@@ -0,0 +1,30 @@
+package flowbro
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSnapshotsAreBoundedByKeyCount(t *testing.T) {
+	s := &snapshot{rows: map[string]tableRow{}, maxKeys: 2}
+	s.add(message{Key: "b", Offset: 1, Value: newValueFrom(`{"state":"NEW"}`)})
+	s.add(message{Key: "a", Offset: 2, Value: newValueFrom(`{"state":"NEW"}`)})
+	s.add(message{Key: "c", Offset: 3, Value: newValueFrom(`{"state":"NEW"}`)})
+	s.add(message{Key: "b", Offset: 4, Value: newValueFrom(`{"state":"PAID"}`)})
+
+	e := s.event("orders", nil)
+	if e.EventType != "snapshot" || e.Topic != "orders" || len(e.Rows) != 2 || e.Rows[0].Key != "a" || e.Rows[1].Offset != 4 {
+		t.Errorf("expected a snapshot of the latest values of keys a and b but got %+v", e)
+	}
+	if e := s.event("orders", errors.New("timed out")); e.Color != "warning" || len(e.Rows) != 2 {
+		t.Errorf("expected a partial snapshot warning but got %+v", e)
+	}
+}
+
+func TestSnapshotEventsWithoutKafka(t *testing.T) {
+	config, _ := processConfig(&configJSON{})
+	events := snapshotEvents(&snapshotJSON{Topics: []string{"orders", "payments"}}, clusters{&cluster{}}, config)
+	if len(events) != 2 || len(events[0].Rows) != 0 || events[1].Topic != "payments" {
+		t.Errorf("expected empty snapshots of both topics but got %+v", events)
+	}
+}
@@ -0,0 +1,64 @@
+package flowbro
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Source feeds the messages of a connection into its pipeline, until ctx is
+// done. Closing it returns once everything it started has stopped.
+type Source interface {
+	Start(ctx context.Context) (<-chan *SourceMessage, error)
+	Close()
+}
+
+// ClusterSource is a Source reading Kafka clusters, whose partitions and
+// offsets controls like seeking act on and the state of streams reports.
+type ClusterSource interface {
+	Source
+	kafkaClusters() clusters
+}
+
+// SourceMessage is a message as sources feed it into the pipeline, before
+// it's decoded, along with the cluster and view it was read in, if any.
+type SourceMessage struct {
+	Topic     string
+	Partition int32
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Timestamp time.Time
+
+	cluster string
+	brokers string
+	view    string
+}
+
+// newSource returns the source config asks for, along with a notice telling
+// the client about it, if any. Kafka clusters are set up with f and report
+// their status to status.
+func newSource(config *config, f fsm, status func(event) error) (Source, string) {
+	switch {
+	case config.tutorial:
+		return &tutorialSource{}, "Starting tutorial. Flowbro is not really connected to a Kafka broker; messages are being mocked."
+	case config.replay != nil:
+		// recorded values were decoded when they were recorded
+		config.decoders, config.decodings = nil, map[string]decoding{}
+		return &replaySource{dataDir: config.dataDir, c: config.replay}, fmt.Sprintf("Replaying recording %v; Flowbro is not connected to a Kafka broker.", config.replay.Recording)
+	case config.mockPath != "":
+		return &mockSource{path: config.mockPath}, fmt.Sprintf("Serving mocked messages from %v; Flowbro is not connected to a Kafka broker.", config.mockPath)
+	case config.restProxy != nil:
+		return &restProxySource{proxy: config.restProxy, consumers: config.consumers, status: status}, fmt.Sprintf("Consuming through REST proxy %v; seeking isn't available.", config.restProxy)
+	}
+	return &kafkaSource{config: config, fsm: f, status: status}, ""
+}
+
+// sourceClusters returns the Kafka clusters src consumes, which controls
+// like seeking act on.
+func sourceClusters(src Source) clusters {
+	if cs, ok := src.(ClusterSource); ok {
+		return cs.kafkaClusters()
+	}
+	return clusters{}
+}
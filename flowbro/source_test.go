@@ -0,0 +1,57 @@
+package flowbro
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNewSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *config
+		expected string
+	}{
+		{name: "tutorial", config: &config{tutorial: true, mockPath: "fixtures.json"}, expected: "*flowbro.tutorialSource"},
+		{name: "replay", config: &config{replay: &replayJSON{Recording: "r"}, mockPath: "fixtures.json"}, expected: "*flowbro.replaySource"},
+		{name: "mock", config: &config{mockPath: "fixtures.json"}, expected: "*flowbro.mockSource"},
+		{name: "kafka", config: &config{}, expected: "*flowbro.kafkaSource"},
+	}
+
+	for _, ts := range tests {
+		src, _ := newSource(ts.config, fsm{}, nil)
+		if actual := fmt.Sprintf("%T", src); actual != ts.expected {
+			t.Errorf("on '%v': expected %v but got %v", ts.name, ts.expected, actual)
+		}
+	}
+}
+
+func TestMockSource(t *testing.T) {
+	path := writeTempFile(t, `{"topic": "orders", "value": {"id": 1}}
+{"topic": "orders", "value": {"id": 2}}`)
+
+	src := &mockSource{path: path}
+	c, err := src.Start(context.Background())
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	for _, expected := range []int64{0, 1} {
+		select {
+		case m := <-c:
+			if m.Topic != "orders" || m.Offset != expected {
+				t.Errorf("expected offset %v of topic orders but got %+v", expected, *m)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected a message at offset %v", expected)
+		}
+	}
+	src.Close()
+
+	if _, err := (&mockSource{path: path + ".missing"}).Start(context.Background()); err == nil {
+		t.Errorf("expected a missing fixtures file to fail")
+	}
+	if sourceClusters(src) == nil || len(sourceClusters(src)) != 0 {
+		t.Errorf("expected a mock source not to consume any clusters")
+	}
+}
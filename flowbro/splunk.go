@@ -0,0 +1,184 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+type splunkJSON struct {
+	URL                string  `json:"url"`
+	Token              string  `json:"token"`
+	Index              string  `json:"index"`
+	Source             string  `json:"source"`
+	SourceType         string  `json:"sourceType"`
+	Host               string  `json:"host"`
+	EventTypes         string  `json:"eventTypes"`
+	BatchSize          int     `json:"batchSize"`
+	FlushSeconds       float64 `json:"flushSeconds"`
+	Retries            *int    `json:"retries"`
+	CAFile             string  `json:"caFile"`
+	InsecureSkipVerify bool    `json:"insecureSkipVerify"`
+}
+
+// splunkSink posts the events of the flow, as sent to the UI, to a Splunk
+// HTTP Event Collector in batches, so flows can be indexed next to other
+// security and ops data.
+type splunkSink struct {
+	batchingSink
+	url        string
+	token      string
+	index      string
+	source     string
+	sourceType string
+	host       string
+	eventTypes *regexp.Regexp
+	client     *http.Client
+}
+
+// splunkEvent is the envelope HEC expects around every event.
+type splunkEvent struct {
+	Time       float64 `json:"time"`
+	Host       string  `json:"host,omitempty"`
+	Source     string  `json:"source,omitempty"`
+	SourceType string  `json:"sourcetype,omitempty"`
+	Index      string  `json:"index,omitempty"`
+	Event      event   `json:"event"`
+}
+
+const (
+	defaultSplunkPath       = "/services/collector/event"
+	defaultSplunkSource     = "flowbro"
+	defaultSplunkSourceType = "_json"
+)
+
+var (
+	splunkPosts   = newCounter("flowbro_splunk_posts_total", "Batches of events posted to Splunk, by outcome.", "outcome")
+	splunkDropped = newCounter("flowbro_splunk_dropped_events_total", "Events dropped because the Splunk queue was full.")
+)
+
+func processSplunk(c *splunkJSON) (*splunkSink, error) {
+	if c == nil {
+		return nil, nil
+	}
+	if len(c.URL) == 0 || len(c.Token) == 0 {
+		return nil, fmt.Errorf("Please define the url and token of your Splunk HTTP Event Collector")
+	}
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("Invalid Splunk url %v; please use http(s)://<host:port>", c.URL)
+	}
+	if len(u.Path) == 0 || u.Path == "/" {
+		u.Path = defaultSplunkPath
+	}
+	if c.BatchSize < 0 || c.FlushSeconds < 0 || c.Retries != nil && *c.Retries < 0 {
+		return nil, fmt.Errorf("Invalid Splunk sink; batchSize, flushSeconds and retries can't be negative")
+	}
+	tlsConfig, err := newTLSConfig(c.CAFile, "", "", c.InsecureSkipVerify)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid TLS settings for Splunk. err=%v", err)
+	}
+	s := &splunkSink{
+		url:        u.String(),
+		token:      c.Token,
+		index:      c.Index,
+		source:     defaultSplunkSource,
+		sourceType: defaultSplunkSourceType,
+		host:       c.Host,
+		client:     &http.Client{Timeout: 5 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}
+	s.batchingSink = newBatchingSink("Splunk", "events", s, func(outcome string) {
+		if outcome == "dropped" {
+			splunkDropped.inc()
+		} else {
+			splunkPosts.inc(outcome)
+		}
+	})
+	if len(c.Source) > 0 {
+		s.source = c.Source
+	}
+	if len(c.SourceType) > 0 {
+		s.sourceType = c.SourceType
+	}
+	if len(c.EventTypes) > 0 {
+		if s.eventTypes, err = regexp.Compile("^(?:" + c.EventTypes + ")$"); err != nil {
+			return nil, fmt.Errorf("Invalid eventTypes regex for Splunk. err=%v", err)
+		}
+	}
+	if c.BatchSize > 0 {
+		s.batchSize = c.BatchSize
+	}
+	if c.FlushSeconds > 0 {
+		s.flush = time.Duration(c.FlushSeconds * float64(time.Second))
+	}
+	if c.Retries != nil {
+		s.policy.retries = *c.Retries
+	}
+	return s, nil
+}
+
+// startSplunk runs s in a goroutine of life, until it stops; until then
+// events aren't queued.
+func startSplunk(s *splunkSink, life *lifecycle) {
+	if s != nil {
+		s.start(life)
+	}
+}
+
+// forwardToSplunk queues the events of the matching types, dropping them if
+// the queue is full, and returns the failures of earlier batches.
+func forwardToSplunk(s *splunkSink, events []event) []event {
+	if s == nil || !s.started() {
+		return nil
+	}
+	for _, e := range events {
+		if s.eventTypes == nil || s.eventTypes.MatchString(e.EventType) {
+			s.queue(e)
+		}
+	}
+	return s.reported()
+}
+
+// encode renders batch as HEC expects it: one JSON envelope after the
+// other, timed by the events' timestamps, or else now.
+func (s *splunkSink) encode(batch []interface{}, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, item := range batch {
+		e := item.(event)
+		at := now
+		if e.Timestamp != nil {
+			at = *e.Timestamp
+		}
+		env := splunkEvent{Time: float64(at.UnixNano()/int64(time.Millisecond)) / 1000, Host: s.host, Source: s.source, SourceType: s.sourceType, Index: s.index, Event: e}
+		if err := enc.Encode(env); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// post returns whether a failed post may succeed when retried: connection
+// errors, 429s, 503s (HEC's busy answer) and other 5xxs may, other
+// rejections, like an invalid token, won't.
+func (s *splunkSink) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Splunk "+s.token)
+	req.Header.Set("Content-Type", "application/json")
+	r, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	r.Body.Close()
+	if r.StatusCode >= 300 {
+		return r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500, fmt.Errorf("%v answered with status %v", s.url, r.StatusCode)
+	}
+	return false, nil
+}
@@ -0,0 +1,123 @@
+package flowbro
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProcessSplunk(t *testing.T) {
+	none := 0
+	ts := []struct {
+		c           *splunkJSON
+		expectedURL string
+		fails       bool
+	}{
+		{&splunkJSON{URL: "https://splunk:8088", Token: "t"}, "https://splunk:8088/services/collector/event", false},
+		{&splunkJSON{URL: "http://splunk:8088/services/collector", Token: "t", EventTypes: "message|alert", Retries: &none}, "http://splunk:8088/services/collector", false},
+		{&splunkJSON{URL: "https://splunk:8088"}, "", true},
+		{&splunkJSON{URL: "splunk:8088", Token: "t"}, "", true},
+		{&splunkJSON{URL: "https://splunk:8088", Token: "t", EventTypes: "("}, "", true},
+		{&splunkJSON{URL: "https://splunk:8088", Token: "t", BatchSize: -1}, "", true},
+	}
+
+	for _, tc := range ts {
+		s, err := processSplunk(tc.c)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("on '%+v': expected the config to be rejected", tc.c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on '%+v': shouldn't have failed but did with %v", tc.c, err)
+			continue
+		}
+		if s.url != tc.expectedURL {
+			t.Errorf("on '%+v': expected url %v but got %v", tc.c, tc.expectedURL, s.url)
+		}
+	}
+}
+
+func TestSplunkBody(t *testing.T) {
+	s, _ := processSplunk(&splunkJSON{URL: "https://splunk:8088", Token: "t", Index: "flows", Host: "flowbro-1"})
+	at := time.Unix(1500000000, 250000000)
+	body, err := s.encode([]interface{}{event{EventType: "message", SourceId: "shop", TargetId: "billing", Timestamp: &at}, event{EventType: "alert", Text: "late"}}, time.Unix(1600000000, 0))
+	if err != nil {
+		t.Fatalf("shouldn't have failed rendering but did with %v", err)
+	}
+
+	expected := []string{
+		`{"time":1500000000.25,"host":"flowbro-1","source":"flowbro","sourcetype":"_json","index":"flows","event":{"eventType":"message","sourceId":"shop","targetId":"billing"`,
+		`{"time":1600000000,"host":"flowbro-1","source":"flowbro","sourcetype":"_json","index":"flows","event":{"eventType":"alert","sourceId":"","targetId":"","text":"late"`,
+	}
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("expected %v envelopes but got %s", len(expected), body)
+	}
+	for i, l := range lines {
+		if !strings.HasPrefix(l, expected[i]) {
+			t.Errorf("on envelope %v: expected %v... but got %v", i, expected[i], l)
+		}
+	}
+}
+
+func TestSplunkPostsBatches(t *testing.T) {
+	var l sync.Mutex
+	received, posts := []string{}, 0
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Splunk secret" {
+			rw.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		l.Lock()
+		defer l.Unlock()
+		posts++
+		for sc := bufio.NewScanner(bytes.NewReader(body)); sc.Scan(); {
+			var env splunkEvent
+			json.Unmarshal(sc.Bytes(), &env)
+			received = append(received, env.Event.EventType)
+		}
+	}))
+	defer server.Close()
+
+	s, err := processSplunk(&splunkJSON{URL: server.URL, Token: "secret", EventTypes: "message|alert", BatchSize: 2, FlushSeconds: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures := forwardToSplunk(s, []event{{EventType: "message"}}); failures != nil {
+		t.Errorf("expected nothing to be queued before starting but got %v", failures)
+	}
+	life := newLifecycle(context.Background())
+	startSplunk(s, life)
+	forwardToSplunk(s, []event{{EventType: "message"}, {EventType: "stats"}, {EventType: "alert"}, {EventType: "message"}})
+	life.stop()
+	life.wait()
+
+	l.Lock()
+	defer l.Unlock()
+	if strings.Join(received, ",") != "message,alert,message" || posts != 2 {
+		t.Errorf("expected the matching events in a full batch and a final one but got %v in %v posts", received, posts)
+	}
+}
+
+func TestSplunkDoesntRetryRejections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	s, _ := processSplunk(&splunkJSON{URL: server.URL, Token: "wrong"})
+	if retry, err := s.post([]byte("{}")); retry || err == nil {
+		t.Errorf("expected the rejected batch not to be retried but got %v, %v", retry, err)
+	}
+}
@@ -0,0 +1,248 @@
+package flowbro
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamState is what /api/state tells of a connection. Its consumer loop
+// counts messages into it as they go by and refreshes the rest every tick.
+type streamState struct {
+	l sync.Mutex
+
+	id        string
+	kind      string
+	remote    string
+	user      string
+	config    string
+	board     string
+	fsmId     string
+	connected time.Time
+	clusters  []clusterState
+
+	filter   string
+	paused   bool
+	buffered int
+	pending  int
+	consumed int64
+	dropped  map[string]int64
+	offsets  map[partitionKey]int64
+}
+
+type partitionKey struct {
+	cluster, view, topic string
+	partition            int32
+}
+
+type clusterState struct {
+	Alias   string   `json:"alias"`
+	Brokers []string `json:"brokers"`
+	View    string   `json:"view,omitempty"`
+}
+
+type partitionOffset struct {
+	Cluster   string `json:"cluster"`
+	View      string `json:"view,omitempty"`
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Offset    int64  `json:"offset"` // the last consumed
+}
+
+type clientState struct {
+	Id        string            `json:"id"`
+	Kind      string            `json:"kind"`
+	Remote    string            `json:"remote"`
+	User      string            `json:"user,omitempty"`
+	Config    string            `json:"config,omitempty"`
+	Board     string            `json:"board,omitempty"`
+	FSMId     string            `json:"fsmId,omitempty"`
+	Filter    string            `json:"filter,omitempty"`
+	Connected time.Time         `json:"connected"`
+	Paused    bool              `json:"paused"`
+	Clusters  []clusterState    `json:"clusters"`
+	Buffered  int               `json:"buffered"` // messages waiting to become events
+	Pending   int               `json:"pending"`  // events waiting to be sent
+	Consumed  int64             `json:"consumed"`
+	Dropped   map[string]int64  `json:"dropped"` // consumed messages that weren't sent, by reason
+	Offsets   []partitionOffset `json:"offsets"`
+}
+
+// streamRegistry holds the state of every open connection.
+type streamRegistry struct {
+	sync.Mutex
+	streams map[string]*streamState
+}
+
+var activeStreams = &streamRegistry{streams: map[string]*streamState{}}
+
+func newStreamState(kind string, r *http.Request, config *config, cs clusters) *streamState {
+	s := &streamState{
+		id:        newId(),
+		kind:      kind,
+		config:    config.flowName,
+		board:     config.board,
+		fsmId:     config.fsmId,
+		connected: time.Now().UTC(),
+		clusters:  []clusterState{},
+		dropped:   map[string]int64{},
+		offsets:   map[partitionKey]int64{},
+	}
+	if r != nil {
+		s.remote, s.user = r.RemoteAddr, principalOf(r).Name
+	}
+	if config.filter != nil {
+		s.filter = config.filter.src
+	}
+	for _, c := range cs {
+		s.clusters = append(s.clusters, clusterState{Alias: c.alias, Brokers: c.brokers, View: c.view})
+	}
+	return s
+}
+
+func (r *streamRegistry) add(s *streamState) {
+	r.Lock()
+	defer r.Unlock()
+	r.streams[s.id] = s
+}
+
+func (r *streamRegistry) remove(s *streamState) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.streams, s.id)
+}
+
+func (r *streamRegistry) list() []*streamState {
+	r.Lock()
+	defer r.Unlock()
+	ss := []*streamState{}
+	for _, s := range r.streams {
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+func (s *streamState) onMessage(m *SourceMessage) {
+	if s == nil {
+		return
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.consumed++
+	s.offsets[partitionKey{m.cluster, m.view, m.Topic, m.Partition}] = m.Offset
+}
+
+// drop counts n consumed messages that won't be sent because of reason.
+func (s *streamState) drop(reason string, n int) {
+	if s == nil || n <= 0 {
+		return
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.dropped[reason] += int64(n)
+}
+
+// tick refreshes what the consumer loop holds on to.
+func (s *streamState) tick(config *config, paused bool, buffered, pending int) {
+	if s == nil {
+		return
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.paused, s.buffered, s.pending, s.filter = paused, buffered, pending, ""
+	if config.filter != nil {
+		s.filter = config.filter.src
+	}
+}
+
+func (s *streamState) client() clientState {
+	s.l.Lock()
+	defer s.l.Unlock()
+	c := clientState{
+		Id:        s.id,
+		Kind:      s.kind,
+		Remote:    s.remote,
+		User:      s.user,
+		Config:    s.config,
+		Board:     s.board,
+		FSMId:     s.fsmId,
+		Filter:    s.filter,
+		Connected: s.connected,
+		Paused:    s.paused,
+		Clusters:  s.clusters,
+		Buffered:  s.buffered,
+		Pending:   s.pending,
+		Consumed:  s.consumed,
+		Dropped:   map[string]int64{},
+		Offsets:   []partitionOffset{},
+	}
+	for reason, n := range s.dropped {
+		c.Dropped[reason] = n
+	}
+	for k, o := range s.offsets {
+		c.Offsets = append(c.Offsets, partitionOffset{Cluster: k.cluster, View: k.view, Topic: k.topic, Partition: k.partition, Offset: o})
+	}
+	sort.Slice(c.Offsets, func(i, j int) bool {
+		a, b := c.Offsets[i], c.Offsets[j]
+		if a.Cluster != b.Cluster || a.View != b.View || a.Topic != b.Topic {
+			return a.Cluster+"\x00"+a.View+"\x00"+a.Topic < b.Cluster+"\x00"+b.View+"\x00"+b.Topic
+		}
+		return a.Partition < b.Partition
+	})
+	return c
+}
+
+// stateHandler serves /api/state: the clusters being consumed, every
+// connected client with what it filters, the offsets it's at, how many
+// messages it has buffered and how many it dropped, and totals across them,
+// of the boards the caller may read.
+func stateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+		return
+	}
+	p := principalOf(r)
+	clients := []clientState{}
+	for _, s := range activeStreams.list() {
+		if p.mayRead(s.board) {
+			clients = append(clients, s.client())
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Connected.Before(clients[j].Connected) })
+
+	type clusterClients struct {
+		Alias   string   `json:"alias"`
+		Brokers []string `json:"brokers"`
+		Clients int      `json:"clients"`
+	}
+	clusters, byBrokers := []*clusterClients{}, map[string]*clusterClients{}
+	buffered, pending, dropped := 0, 0, map[string]int64{}
+	for _, c := range clients {
+		buffered, pending = buffered+c.Buffered, pending+c.Pending
+		for reason, n := range c.Dropped {
+			dropped[reason] += n
+		}
+		seen := map[string]bool{} // clusters a client reads more than one view of
+		for _, cl := range c.Clusters {
+			k := cl.Alias + "\x00" + strings.Join(cl.Brokers, ",")
+			if _, ok := byBrokers[k]; !ok {
+				byBrokers[k] = &clusterClients{Alias: cl.Alias, Brokers: cl.Brokers}
+				clusters = append(clusters, byBrokers[k])
+			}
+			if !seen[k] {
+				seen[k] = true
+				byBrokers[k].Clients++
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"clusters": clusters,
+		"clients":  clients,
+		"buffered": buffered,
+		"pending":  pending,
+		"dropped":  dropped,
+	})
+}
@@ -0,0 +1,80 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStateHandlerReportsEveryClient(t *testing.T) {
+	filter, _ := compileCEL("value.amount > 100")
+	cs := clusters{
+		{alias: "eu", brokers: []string{"eu-1:9092"}},
+		{alias: "eu", brokers: []string{"eu-1:9092"}, view: "replay"},
+		{alias: "us", brokers: []string{"us-1:9092"}},
+	}
+	first := newStreamState("websocket", httptest.NewRequest("GET", "/ws", nil), &config{flowName: "shop", filter: filter}, cs[:2])
+	second := newStreamState("stream", nil, &config{fsmId: "order-42"}, cs)
+	second.connected = first.connected.Add(time.Second)
+	defer func(r *streamRegistry) { activeStreams = r }(activeStreams)
+	activeStreams = &streamRegistry{streams: map[string]*streamState{}}
+	activeStreams.add(first)
+	activeStreams.add(second)
+
+	for _, m := range []*SourceMessage{
+		{Topic: "orders", Partition: 1, Offset: 7, cluster: "eu"},
+		{Topic: "orders", Partition: 0, Offset: 3, cluster: "eu"},
+		{Topic: "orders", Partition: 1, Offset: 8, cluster: "eu"},
+	} {
+		first.onMessage(m)
+	}
+	first.drop("filter", 2)
+	first.drop("seeked", 0)
+	first.tick(&config{filter: filter}, true, 5, 2)
+	second.drop("filter", 1)
+	second.drop("script", 1)
+
+	w := httptest.NewRecorder()
+	stateHandler(w, httptest.NewRequest("GET", "/api/state", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %v", w.Code)
+	}
+	var actual struct {
+		Clusters []struct {
+			Alias   string `json:"alias"`
+			Clients int    `json:"clients"`
+		} `json:"clusters"`
+		Clients  []clientState    `json:"clients"`
+		Buffered int              `json:"buffered"`
+		Dropped  map[string]int64 `json:"dropped"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &actual)
+
+	if len(actual.Clients) != 2 {
+		t.Fatalf("expected both clients but got %v", w.Body.String())
+	}
+	c := actual.Clients[0]
+	expectedOffsets := []partitionOffset{{Cluster: "eu", Topic: "orders", Partition: 0, Offset: 3}, {Cluster: "eu", Topic: "orders", Partition: 1, Offset: 8}}
+	if c.Config != "shop" || c.Filter != "value.amount > 100" || !c.Paused || c.Buffered != 5 || c.Pending != 2 || c.Consumed != 3 ||
+		!reflect.DeepEqual(c.Offsets, expectedOffsets) || !reflect.DeepEqual(c.Dropped, map[string]int64{"filter": 2}) {
+		t.Errorf("expected the first client's state but got %+v", c)
+	}
+	if actual.Clients[1].FSMId != "order-42" || actual.Clients[1].Kind != "stream" {
+		t.Errorf("expected the second client's state but got %+v", actual.Clients[1])
+	}
+	if actual.Buffered != 5 || !reflect.DeepEqual(actual.Dropped, map[string]int64{"filter": 3, "script": 1}) {
+		t.Errorf("expected totals across clients but got %v buffered and %v dropped", actual.Buffered, actual.Dropped)
+	}
+	if len(actual.Clusters) != 2 || actual.Clusters[0].Alias != "eu" || actual.Clusters[0].Clients != 2 || actual.Clusters[1].Clients != 1 {
+		t.Errorf("expected eu to be read by both clients and us by one but got %+v", actual.Clusters)
+	}
+
+	w = httptest.NewRecorder()
+	stateHandler(w, httptest.NewRequest("POST", "/api/state", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected POST to be refused but got %v", w.Code)
+	}
+}
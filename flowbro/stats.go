@@ -0,0 +1,190 @@
+package flowbro
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+type statsJSON struct {
+	IntervalSeconds float64 `json:"intervalSeconds"`
+}
+
+const defaultStatsInterval = 5 * time.Second
+
+var partitionLag = newGauge("flowbro_partition_lag", "Messages between the last offset consumed and the high water mark of a partition, as of the last stats report.", "cluster", "topic", "partition")
+
+// partitionStats measures how fast each partition is being consumed, and is
+// sent periodically as partitionStats events so the UI can annotate edges
+// with live throughput.
+type partitionStats struct {
+	MessagesPerSecond float64 `json:"messagesPerSecond"`
+	BytesPerSecond    float64 `json:"bytesPerSecond"`
+	Offset            int64   `json:"offset"`
+	Lag               int64   `json:"lag"`
+
+	messages int64
+	bytes    int64
+}
+
+// latencyStats summarize, per topic, how long messages took from their
+// Kafka timestamp to reaching flowbro, and are sent periodically as
+// latencyStats events so flows with delayed delivery become visible.
+type latencyStats struct {
+	Count  int64   `json:"count"`
+	MeanMs float64 `json:"meanMs"`
+	P50Ms  float64 `json:"p50Ms"`
+	P95Ms  float64 `json:"p95Ms"`
+	P99Ms  float64 `json:"p99Ms"`
+	MaxMs  float64 `json:"maxMs"`
+
+	samples []float64
+	sum     float64
+}
+
+// latencySampleLimit bounds the samples kept per topic and interval to
+// compute percentiles; beyond it samples are replaced at random.
+const latencySampleLimit = 10000
+
+// throughput accumulates partitionStats and latencyStats between reports.
+type throughput struct {
+	interval       time.Duration
+	lastReport     time.Time
+	highWaterMarks func(cluster string) map[string]map[int32]int64
+	stats          map[string]map[string]map[int32]*partitionStats
+	latencies      map[string]map[string]*latencyStats
+}
+
+func newThroughput(c *statsJSON, highWaterMarks func(cluster string) map[string]map[int32]int64, now time.Time) *throughput {
+	if c == nil {
+		return nil
+	}
+	interval := time.Duration(c.IntervalSeconds * float64(time.Second))
+	if interval <= 0 {
+		interval = defaultStatsInterval
+	}
+	return &throughput{
+		interval:       interval,
+		lastReport:     now,
+		highWaterMarks: highWaterMarks,
+		stats:          map[string]map[string]map[int32]*partitionStats{},
+		latencies:      map[string]map[string]*latencyStats{},
+	}
+}
+
+func (t *throughput) onMessage(cluster, topic string, partition int32, offset int64, bytes int) {
+	if _, ok := t.stats[cluster]; !ok {
+		t.stats[cluster] = map[string]map[int32]*partitionStats{}
+	}
+	if _, ok := t.stats[cluster][topic]; !ok {
+		t.stats[cluster][topic] = map[int32]*partitionStats{}
+	}
+	s, ok := t.stats[cluster][topic][partition]
+	if !ok {
+		s = &partitionStats{}
+		t.stats[cluster][topic][partition] = s
+	}
+	s.messages++
+	s.bytes += int64(bytes)
+	s.Offset = offset
+}
+
+func (t *throughput) onLatency(cluster, topic string, latency time.Duration) {
+	if _, ok := t.latencies[cluster]; !ok {
+		t.latencies[cluster] = map[string]*latencyStats{}
+	}
+	l, ok := t.latencies[cluster][topic]
+	if !ok {
+		l = &latencyStats{}
+		t.latencies[cluster][topic] = l
+	}
+	ms := float64(latency) / float64(time.Millisecond)
+	l.Count++
+	l.sum += ms
+	if ms > l.MaxMs {
+		l.MaxMs = ms
+	}
+	if len(l.samples) < latencySampleLimit {
+		l.samples = append(l.samples, ms)
+	} else if i := rand.Int63n(l.Count); i < latencySampleLimit {
+		l.samples[i] = ms
+	}
+}
+
+// report returns a partitionStats event per partition seen so far, once
+// every interval, and starts measuring the next interval.
+func (t *throughput) report(now time.Time) []event {
+	elapsed := now.Sub(t.lastReport)
+	if elapsed < t.interval {
+		return nil
+	}
+	t.lastReport = now
+
+	events := []event{}
+	for cluster, topics := range t.stats {
+		hwms := t.highWaterMarks(cluster)
+		for topic, partitions := range topics {
+			for p, s := range partitions {
+				s.MessagesPerSecond = float64(s.messages) / elapsed.Seconds()
+				s.BytesPerSecond = float64(s.bytes) / elapsed.Seconds()
+				s.Lag = 0
+				if hwm, ok := hwms[topic][p]; ok && hwm > s.Offset+1 {
+					s.Lag = hwm - s.Offset - 1
+				}
+				s.messages, s.bytes = 0, 0
+				partitionLag.set(float64(s.Lag), cluster, topic, fmt.Sprint(p))
+
+				stats, partition := *s, p
+				events = append(events, event{EventType: "partitionStats", Cluster: cluster, Topic: topic, Partition: &partition, Stats: &stats})
+			}
+		}
+	}
+	for cluster, topics := range t.latencies {
+		for topic, l := range topics {
+			if l.Count == 0 {
+				continue
+			}
+			sort.Float64s(l.samples)
+			stats := latencyStats{
+				Count:  l.Count,
+				MeanMs: l.sum / float64(l.Count),
+				P50Ms:  percentile(l.samples, 0.5),
+				P95Ms:  percentile(l.samples, 0.95),
+				P99Ms:  percentile(l.samples, 0.99),
+				MaxMs:  l.MaxMs,
+			}
+			topics[topic] = &latencyStats{}
+			events = append(events, event{EventType: "latencyStats", Cluster: cluster, Topic: topic, Latency: &stats})
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		if a.EventType != b.EventType {
+			return a.EventType < b.EventType
+		}
+		return a.Partition != nil && *a.Partition < *b.Partition
+	})
+	return events
+}
+
+// percentile returns the nearest-rank percentile p of sorted samples.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
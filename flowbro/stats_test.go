@@ -0,0 +1,74 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThroughputReport(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	hwms := func(cluster string) map[string]map[int32]int64 {
+		return map[string]map[int32]int64{"orders": {0: 110, 1: 8}}
+	}
+	tp := newThroughput(&statsJSON{IntervalSeconds: 2}, hwms, start)
+
+	for o := int64(90); o < 100; o++ {
+		tp.onMessage("eu", "orders", 0, o, 100)
+	}
+	tp.onMessage("eu", "orders", 1, 7, 50)
+
+	if events := tp.report(start.Add(time.Second)); len(events) != 0 {
+		t.Errorf("expected no stats before the interval elapsed but got %+v", events)
+	}
+
+	events := tp.report(start.Add(2 * time.Second))
+	expected := []partitionStats{
+		{MessagesPerSecond: 5, BytesPerSecond: 500, Offset: 99, Lag: 10},
+		{MessagesPerSecond: 0.5, BytesPerSecond: 25, Offset: 7, Lag: 0},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %v events but got %+v", len(expected), events)
+	}
+	for i, e := range events {
+		if e.EventType != "partitionStats" || e.Cluster != "eu" || e.Topic != "orders" || *e.Partition != int32(i) {
+			t.Errorf("on event %v: unexpected event %+v", i, e)
+		}
+		if e.Stats.MessagesPerSecond != expected[i].MessagesPerSecond || e.Stats.BytesPerSecond != expected[i].BytesPerSecond ||
+			e.Stats.Offset != expected[i].Offset || e.Stats.Lag != expected[i].Lag {
+			t.Errorf("on event %v: expected %+v but got %+v", i, expected[i], *e.Stats)
+		}
+	}
+
+	events = tp.report(start.Add(4 * time.Second))
+	if len(events) != 2 || events[0].Stats.MessagesPerSecond != 0 {
+		t.Errorf("expected idle partitions to report zero throughput but got %+v", events)
+	}
+}
+
+func TestLatencyReport(t *testing.T) {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	tp := newThroughput(&statsJSON{IntervalSeconds: 1}, func(string) map[string]map[int32]int64 { return nil }, start)
+	for ms := 1; ms <= 100; ms++ {
+		tp.onLatency("eu", "orders", time.Duration(ms)*time.Millisecond)
+	}
+
+	events := tp.report(start.Add(time.Second))
+	if len(events) != 1 || events[0].EventType != "latencyStats" || events[0].Topic != "orders" || events[0].Cluster != "eu" {
+		t.Fatalf("expected a single latencyStats event for orders of cluster eu but got %+v", events)
+	}
+	expected := latencyStats{Count: 100, MeanMs: 50.5, P50Ms: 50, P95Ms: 95, P99Ms: 99, MaxMs: 100}
+	if actual := *events[0].Latency; actual.Count != expected.Count || actual.MeanMs != expected.MeanMs || actual.P50Ms != expected.P50Ms ||
+		actual.P95Ms != expected.P95Ms || actual.P99Ms != expected.P99Ms || actual.MaxMs != expected.MaxMs {
+		t.Errorf("expected %+v but got %+v", expected, actual)
+	}
+
+	if events := tp.report(start.Add(2 * time.Second)); len(events) != 0 {
+		t.Errorf("expected no latency stats for an interval without messages but got %+v", events)
+	}
+}
+
+func TestThroughputIsOptIn(t *testing.T) {
+	if newThroughput(nil, nil, time.Now()) != nil {
+		t.Errorf("expected no throughput stats without a stats config")
+	}
+}
@@ -0,0 +1,163 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+type statsdJSON struct {
+	Address         string            `json:"address"`
+	Prefix          *string           `json:"prefix"`
+	IntervalSeconds float64           `json:"intervalSeconds"`
+	DogStatsD       bool              `json:"dogStatsD"`
+	Tags            map[string]string `json:"tags"`
+}
+
+const (
+	defaultStatsdInterval = 10 * time.Second
+	defaultStatsdPrefix   = "flowbro."
+	statsdPacketSize      = 1432 // fits an ethernet frame
+)
+
+var statsdUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// statsd emits the counters and gauges flowbro exposes on /metrics, e.g.
+// messages consumed per topic, partition lag and connected clients, to a
+// StatsD or DogStatsD agent over UDP. Counters are sent as the increase
+// since the previous flush; histograms are left out.
+type statsd struct {
+	address  string
+	prefix   string
+	interval time.Duration
+	dog      bool
+	tags     []string
+	conn     net.Conn
+	last     map[string]float64
+}
+
+func readStatsd(path string) (*statsd, error) {
+	byt, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read StatsD config. err=%v", err)
+	}
+	var c statsdJSON
+	if err := json.Unmarshal(byt, &c); err != nil {
+		return nil, fmt.Errorf("Invalid StatsD config %v. err=%v", path, err)
+	}
+	return newStatsd(c)
+}
+
+func newStatsd(c statsdJSON) (*statsd, error) {
+	if len(c.Address) == 0 {
+		return nil, fmt.Errorf("Please define the address of your StatsD agent, e.g. localhost:8125")
+	}
+	if c.IntervalSeconds < 0 {
+		return nil, fmt.Errorf("Invalid StatsD intervalSeconds %v; it can't be negative", c.IntervalSeconds)
+	}
+	if len(c.Tags) > 0 && !c.DogStatsD {
+		return nil, fmt.Errorf("Plain StatsD has no tags; set dogStatsD to tag metrics")
+	}
+	conn, err := net.Dial("udp", c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("Could not reach StatsD agent %v. err=%v", c.Address, err)
+	}
+	s := &statsd{address: c.Address, prefix: defaultStatsdPrefix, interval: defaultStatsdInterval, dog: c.DogStatsD, conn: conn, last: map[string]float64{}}
+	if c.Prefix != nil {
+		s.prefix = *c.Prefix
+	}
+	if c.IntervalSeconds > 0 {
+		s.interval = time.Duration(c.IntervalSeconds * float64(time.Second))
+	}
+	for name, value := range c.Tags {
+		s.tags = append(s.tags, name+":"+value)
+	}
+	sort.Strings(s.tags)
+	return s, nil
+}
+
+// run flushes every interval until ctx is done, and once more then.
+func (s *statsd) run(ctx context.Context) {
+	defer s.conn.Close()
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-ctx.Done():
+			s.flush()
+			return
+		}
+	}
+}
+
+func (s *statsd) flush() {
+	var packet []byte
+	for _, l := range s.lines(collectMetrics()) {
+		if len(packet) > 0 && len(packet)+1+len(l) > statsdPacketSize {
+			s.send(packet)
+			packet = nil
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, l...)
+	}
+	if len(packet) > 0 {
+		s.send(packet)
+	}
+}
+
+func (s *statsd) send(packet []byte) {
+	if _, err := s.conn.Write(packet); err != nil {
+		log.WithFields(log.Fields{"address": s.address, "err": err}).Warn("Could not send metrics to StatsD.")
+	}
+}
+
+// lines formats series as StatsD lines. Metric names lose their flowbro_
+// prefix for the configured one; labels become DogStatsD tags, or parts of
+// the name for plain StatsD, e.g. flowbro.consumed_messages_total.eu.orders.
+func (s *statsd) lines(series []promSeries) []string {
+	lines := []string{}
+	for _, ps := range series {
+		if ps.kind != "counter" && ps.kind != "gauge" {
+			continue
+		}
+		name, tags := "", append([]string{}, s.tags...)
+		for _, l := range ps.labels {
+			switch {
+			case l[0] == "__name__":
+				name = s.prefix + strings.TrimPrefix(l[1], "flowbro_")
+			case s.dog:
+				tags = append(tags, l[0]+":"+l[1])
+			default:
+				name += "." + statsdUnsafe.ReplaceAllString(l[1], "_")
+			}
+		}
+		line := fmt.Sprintf("%v:%v|g", name, ps.value)
+		if ps.kind == "counter" {
+			key := name + "|" + strings.Join(tags, ",")
+			delta := ps.value - s.last[key]
+			s.last[key] = ps.value
+			if delta <= 0 {
+				continue
+			}
+			line = fmt.Sprintf("%v:%v|c", name, delta)
+		}
+		if len(tags) > 0 {
+			line += "|#" + strings.Join(tags, ",")
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	return lines
+}
@@ -0,0 +1,104 @@
+package flowbro
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsdLines(t *testing.T) {
+	consumed := [][2]string{{"__name__", "flowbro_consumed_messages_total"}, {"cluster", "eu"}, {"topic", "orders.v1"}}
+	lag := [][2]string{{"__name__", "flowbro_partition_lag"}, {"cluster", "eu"}, {"topic", "orders.v1"}, {"partition", "0"}}
+	latency := [][2]string{{"__name__", "flowbro_message_latency_seconds_count"}, {"topic", "orders.v1"}}
+	empty := ""
+	ts := []struct {
+		c        statsdJSON
+		series   [][]promSeries
+		expected []string
+	}{
+		{
+			c: statsdJSON{Address: "localhost:8125"},
+			series: [][]promSeries{
+				{{labels: consumed, value: 5, kind: "counter"}, {labels: lag, value: 7, kind: "gauge"}, {labels: latency, value: 5, kind: "histogram"}},
+				{{labels: consumed, value: 8, kind: "counter"}, {labels: lag, value: 3, kind: "gauge"}},
+			},
+			expected: []string{"flowbro.consumed_messages_total.eu.orders.v1:3|c", "flowbro.partition_lag.eu.orders.v1.0:3|g"},
+		},
+		{
+			c: statsdJSON{Address: "localhost:8125", DogStatsD: true, Prefix: &empty, Tags: map[string]string{"env": "dev"}},
+			series: [][]promSeries{
+				{{labels: consumed, value: 5, kind: "counter"}},
+				{{labels: consumed, value: 5, kind: "counter"}, {labels: lag, value: 3, kind: "gauge"}},
+			},
+			expected: []string{"partition_lag:3|g|#env:dev,cluster:eu,topic:orders.v1,partition:0"},
+		},
+	}
+
+	for _, tc := range ts {
+		s, err := newStatsd(tc.c)
+		if err != nil {
+			t.Errorf("on '%+v': shouldn't have failed but did with %v", tc.c, err)
+			continue
+		}
+		var lines []string
+		for _, series := range tc.series {
+			lines = s.lines(series)
+		}
+		if !reflect.DeepEqual(lines, tc.expected) {
+			t.Errorf("on '%+v': expected %v but got %v", tc.c, tc.expected, lines)
+		}
+	}
+}
+
+func TestNewStatsd(t *testing.T) {
+	ts := []struct {
+		c     statsdJSON
+		fails bool
+	}{
+		{statsdJSON{Address: "localhost:8125", IntervalSeconds: 1}, false},
+		{statsdJSON{Address: "localhost:8125", DogStatsD: true, Tags: map[string]string{"env": "dev"}}, false},
+		{statsdJSON{Address: "localhost:8125", Tags: map[string]string{"env": "dev"}}, true},
+		{statsdJSON{Address: "localhost:8125", IntervalSeconds: -1}, true},
+		{statsdJSON{Address: "nowhere"}, true},
+		{statsdJSON{}, true},
+	}
+
+	for _, tc := range ts {
+		_, err := newStatsd(tc.c)
+		if tc.fails != (err != nil) {
+			t.Errorf("on '%+v': expected failing to be %v but got err=%v", tc.c, tc.fails, err)
+		}
+	}
+}
+
+func TestStatsdFlushesOnStop(t *testing.T) {
+	agent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer agent.Close()
+
+	s, err := newStatsd(statsdJSON{Address: agent.LocalAddr().String(), IntervalSeconds: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumedMessages.add(2, "statsd", "flushes")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.run(ctx)
+
+	agent.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := make([]byte, statsdPacketSize)
+	for {
+		n, _, err := agent.ReadFrom(b)
+		if err != nil {
+			t.Fatalf("expected the consumed messages to be emitted. err=%v", err)
+		}
+		if strings.Contains(string(b[:n]), "flowbro.consumed_messages_total.statsd.flushes:2|c") {
+			return
+		}
+	}
+}
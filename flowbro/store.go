@@ -0,0 +1,82 @@
+package flowbro
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// store persists a JSON document on disk; writes go through a temporary file
+// so a crash never leaves a half-written document behind.
+type store struct {
+	path string
+	l    sync.Mutex
+}
+
+func newStore(dir, name string) *store {
+	return &store{path: filepath.Join(dir, name)}
+}
+
+// update loads the document into v, lets f modify it and saves it back.
+func (s *store) update(v interface{}, f func() error) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if err := s.load(v); err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	return s.save(v)
+}
+
+// read loads the document into v.
+func (s *store) read(v interface{}) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.load(v)
+}
+
+func (s *store) load(v interface{}) error {
+	raw, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func (s *store) save(v interface{}) error {
+	byt, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, byt, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func newId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// safeName tells if name can be used as a file name in a directory without
+// escaping it.
+func safeName(name string) bool {
+	return len(name) > 0 && !strings.ContainsAny(name, `/\`) && !strings.HasPrefix(name, ".")
+}
@@ -0,0 +1,229 @@
+package flowbro
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type syslogJSON struct {
+	Address            string `json:"address"`
+	Facility           string `json:"facility"`
+	AppName            string `json:"appName"`
+	Hostname           string `json:"hostname"`
+	AlertsOnly         bool   `json:"alertsOnly"`
+	EventTypes         string `json:"eventTypes"`
+	CAFile             string `json:"caFile"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+// syslogSink forwards the events of the flow, or only alerts, to a syslog
+// server as RFC 5424 messages over UDP, TCP or TLS, for SIEMs that only
+// speak syslog. Messages are sent one by one; those that can't be sent,
+// even after reconnecting once, are dropped and reported.
+type syslogSink struct {
+	batchingSink
+	network    string
+	address    string
+	tls        *tls.Config
+	facility   int
+	appName    string
+	hostname   string
+	eventTypes *regexp.Regexp
+	conn       net.Conn
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+const (
+	defaultSyslogFacility = "local0"
+	defaultSyslogAppName  = "flowbro"
+	syslogTimeout         = 5 * time.Second
+
+	// syslogSDID names flowbro's structured data element; 32473 is the
+	// private enterprise number reserved for documentation.
+	syslogSDID = "flowbro@32473"
+)
+
+var syslogMessages = newCounter("flowbro_syslog_messages_total", "Events forwarded to syslog, by outcome.", "outcome")
+
+func processSyslog(c *syslogJSON) (*syslogSink, error) {
+	if c == nil {
+		return nil, nil
+	}
+	u, err := url.Parse(c.Address)
+	if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp" && u.Scheme != "tls") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("Invalid syslog address %v; please use udp://, tcp:// or tls://<host:port>", c.Address)
+	}
+	if c.AlertsOnly && len(c.EventTypes) > 0 {
+		return nil, fmt.Errorf("Please use either alertsOnly or eventTypes for syslog")
+	}
+	s := &syslogSink{network: u.Scheme, address: u.Host, appName: defaultSyslogAppName, hostname: c.Hostname}
+	s.batchingSink = newBatchingSink("syslog "+u.Host, "events", s, func(outcome string) { syslogMessages.inc(outcome) })
+	s.batchSize = 1
+	s.policy = connectionPolicy{retries: 1, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond}
+	if s.network == "tls" {
+		if s.tls, err = newTLSConfig(c.CAFile, "", "", c.InsecureSkipVerify); err != nil {
+			return nil, fmt.Errorf("Invalid TLS settings for syslog. err=%v", err)
+		}
+		s.network = "tcp"
+	}
+	facility := c.Facility
+	if len(facility) == 0 {
+		facility = defaultSyslogFacility
+	}
+	var ok bool
+	if s.facility, ok = syslogFacilities[facility]; !ok {
+		return nil, fmt.Errorf("Unknown syslog facility %v; use kern, user, daemon, auth, syslog or local0 to local7, among others", facility)
+	}
+	if len(c.AppName) > 0 {
+		s.appName = c.AppName
+	}
+	if len(s.hostname) == 0 {
+		if s.hostname, err = os.Hostname(); err != nil {
+			s.hostname = "-"
+		}
+	}
+	switch {
+	case c.AlertsOnly:
+		s.eventTypes = regexp.MustCompile("^alert$")
+	case len(c.EventTypes) > 0:
+		if s.eventTypes, err = regexp.Compile("^(?:" + c.EventTypes + ")$"); err != nil {
+			return nil, fmt.Errorf("Invalid eventTypes regex for syslog. err=%v", err)
+		}
+	}
+	return s, nil
+}
+
+// startSyslog runs s in a goroutine of life, until it stops; until then
+// events aren't queued.
+func startSyslog(s *syslogSink, life *lifecycle) {
+	if s != nil {
+		s.start(life)
+	}
+}
+
+// forwardToSyslog queues the events of the matching types, dropping them if
+// the queue is full, and returns the failures of earlier sends.
+func forwardToSyslog(s *syslogSink, events []event) []event {
+	if s == nil || !s.started() {
+		return nil
+	}
+	for _, e := range events {
+		if s.eventTypes == nil || s.eventTypes.MatchString(e.EventType) {
+			s.queue(e)
+		}
+	}
+	return s.reported()
+}
+
+// encode renders the event of batch, octet counted over TCP as RFC 6587 and
+// 5425 frame messages.
+func (s *syslogSink) encode(batch []interface{}, now time.Time) ([]byte, error) {
+	msg := s.format(batch[0].(event), now)
+	if s.network == "tcp" {
+		msg = fmt.Sprintf("%v %v", len(msg), msg)
+	}
+	return []byte(msg), nil
+}
+
+// post writes body, closing the connection if it broke so that a retry
+// reconnects.
+func (s *syslogSink) post(body []byte) (bool, error) {
+	if s.conn == nil {
+		var err error
+		if s.conn, err = s.dial(); err != nil {
+			return true, err
+		}
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := s.conn.Write(body); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return true, err
+	}
+	return false, nil
+}
+
+func (s *syslogSink) close() {
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+func (s *syslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	if s.tls != nil {
+		return tls.DialWithDialer(dialer, "tcp", s.address, s.tls)
+	}
+	return dialer.Dial(s.network, s.address)
+}
+
+// format renders e as an RFC 5424 message, e.g.
+// <131>1 2017-07-14T02:40:00.000Z host flowbro - alert [flowbro@32473 source="billing"] Billing is late
+// with the event type as MSGID, its flow as structured data and its text,
+// or else its JSON, as MSG.
+func (s *syslogSink) format(e event, now time.Time) string {
+	at := now
+	if e.Timestamp != nil {
+		at = *e.Timestamp
+	}
+	params := []string{}
+	for _, p := range [][2]string{{"source", e.SourceId}, {"target", e.TargetId}, {"fsmId", e.FSMId}, {"cluster", e.Cluster}, {"topic", e.Topic}} {
+		if len(p[1]) > 0 {
+			params = append(params, fmt.Sprintf(`%v="%v"`, p[0], syslogParamEscaper.Replace(p[1])))
+		}
+	}
+	sd := "-"
+	if len(params) > 0 {
+		sd = "[" + syslogSDID + " " + strings.Join(params, " ") + "]"
+	}
+	msg := e.Text
+	if len(msg) == 0 {
+		byt, _ := json.Marshal(e)
+		msg = string(byt)
+	}
+	pri := s.facility*8 + syslogSeverity(e)
+	return fmt.Sprintf("<%v>1 %v %v %v - %v %v %v", pri, at.UTC().Format("2006-01-02T15:04:05.000Z07:00"), syslogHeader(s.hostname, 255), syslogHeader(s.appName, 48), syslogHeader(e.EventType, 32), sd, msg)
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogSeverity is critical for alerts, error or warning for events
+// colored so, and informational otherwise.
+func syslogSeverity(e event) int {
+	switch {
+	case e.EventType == "alert":
+		return 2
+	case e.Color == "error":
+		return 3
+	case e.Color == "warning":
+		return 4
+	}
+	return 6
+}
+
+// syslogHeader makes s a valid header field: printable ASCII without
+// spaces, at most max long, or - if empty.
+func syslogHeader(s string, max int) string {
+	b := []byte{}
+	for i := 0; i < len(s) && len(b) < max; i++ {
+		if s[i] > 32 && s[i] < 127 {
+			b = append(b, s[i])
+		}
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
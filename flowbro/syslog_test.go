@@ -0,0 +1,129 @@
+package flowbro
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessSyslog(t *testing.T) {
+	ts := []struct {
+		c                *syslogJSON
+		expectedNetwork  string
+		expectedFacility int
+		fails            bool
+	}{
+		{&syslogJSON{Address: "udp://siem:514"}, "udp", 16, false},
+		{&syslogJSON{Address: "tcp://siem:601", Facility: "auth", AlertsOnly: true}, "tcp", 4, false},
+		{&syslogJSON{Address: "tls://siem:6514", InsecureSkipVerify: true}, "tcp", 16, false},
+		{&syslogJSON{Address: "siem:514"}, "", 0, true},
+		{&syslogJSON{Address: "udp://siem:514", Facility: "local9"}, "", 0, true},
+		{&syslogJSON{Address: "udp://siem:514", AlertsOnly: true, EventTypes: "alert"}, "", 0, true},
+		{&syslogJSON{Address: "udp://siem:514", EventTypes: "("}, "", 0, true},
+	}
+
+	for _, tc := range ts {
+		s, err := processSyslog(tc.c)
+		if tc.fails {
+			if err == nil {
+				t.Errorf("on '%+v': expected the config to be rejected", tc.c)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on '%+v': shouldn't have failed but did with %v", tc.c, err)
+			continue
+		}
+		if s.network != tc.expectedNetwork || s.facility != tc.expectedFacility {
+			t.Errorf("on '%+v': expected network %v and facility %v but got %v and %v", tc.c, tc.expectedNetwork, tc.expectedFacility, s.network, s.facility)
+		}
+	}
+}
+
+func TestSyslogFormat(t *testing.T) {
+	s, _ := processSyslog(&syslogJSON{Address: "udp://siem:514", Hostname: "flow host", AppName: "flowbro"})
+	now := time.Date(2017, 7, 14, 2, 40, 0, 0, time.UTC)
+	at := time.Date(2017, 7, 14, 2, 39, 59, 500000000, time.FixedZone("CEST", 2*3600))
+
+	ts := []struct {
+		e        event
+		expected string
+	}{
+		{
+			event{EventType: "alert", SourceId: "billing", Text: "Billing is late", Color: "error"},
+			`<130>1 2017-07-14T02:40:00.000Z flowhost flowbro - alert [flowbro@32473 source="billing"] Billing is late`,
+		},
+		{
+			event{EventType: "message", SourceId: "shop", TargetId: "bill]ing", FSMId: `a"b\`, Text: "order", Timestamp: &at},
+			`<134>1 2017-07-14T00:39:59.500Z flowhost flowbro - message [flowbro@32473 source="shop" target="bill\]ing" fsmId="a\"b\\"] order`,
+		},
+		{
+			event{EventType: "log", Text: "Lagging", Color: "warning"},
+			`<132>1 2017-07-14T02:40:00.000Z flowhost flowbro - log - Lagging`,
+		},
+		{
+			event{EventType: "stats"},
+			`<134>1 2017-07-14T02:40:00.000Z flowhost flowbro - stats - {"eventType":"stats"`,
+		},
+	}
+
+	for _, tc := range ts {
+		if actual := s.format(tc.e, now); !strings.HasPrefix(actual, tc.expected) {
+			t.Errorf("on '%+v': expected %v but got %v", tc.e, tc.expected, actual)
+		}
+	}
+}
+
+func TestSyslogForwardsAlertsOverTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		length, _ := r.ReadString(' ')
+		msg := make([]byte, len("<130>1"))
+		r.Read(msg)
+		received <- length + string(msg)
+	}()
+
+	s, err := processSyslog(&syslogJSON{Address: "tcp://" + l.Addr().String(), AlertsOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	life := newLifecycle(context.Background())
+	startSyslog(s, life)
+	forwardToSyslog(s, []event{{EventType: "message", Text: "skipped"}, {EventType: "alert", Text: "late"}})
+
+	select {
+	case msg := <-received:
+		if !strings.HasSuffix(msg, " <130>1") {
+			t.Errorf("expected an octet-counted alert but got %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("expected the alert to be forwarded")
+	}
+	life.stop()
+	life.wait()
+}
+
+func TestSyslogRetriesUnsentEvents(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := l.Addr().String()
+	l.Close()
+
+	s, _ := processSyslog(&syslogJSON{Address: "tcp://" + address})
+	if retry, err := s.post([]byte("<130>1")); !retry || err == nil {
+		t.Errorf("expected the unsent event to be retried, reconnecting, but got %v, %v", retry, err)
+	}
+}
@@ -0,0 +1,195 @@
+package flowbro
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tableRow is the latest value of a key of a compacted topic.
+type tableRow struct {
+	Key       string                 `json:"key"`
+	Value     map[string]interface{} `json:"value"`
+	Cluster   string                 `json:"cluster,omitempty"`
+	Partition int32                  `json:"partition"`
+	Offset    int64                  `json:"offset"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+// tableRegistry holds the latest value per key of the topics consumed as
+// tables by any session, like a KTable, for /api/table/{topic}. Tables are
+// kept apart per board, so they are only read by who may read the board, per
+// cluster, and per redactions applied to their values, so readers get tables
+// redacted at least as much as they ask for.
+type tableRegistry struct {
+	l      sync.Mutex
+	tables map[tableKey]map[string]tableRow
+}
+
+type tableKey struct {
+	board, cluster, topic string
+	redactions            string // the sorted fingerprints of the redactions applied
+}
+
+const defaultTableLimit = 1000
+
+var tables = &tableRegistry{tables: map[tableKey]map[string]tableRow{}}
+
+// tableKeyOf returns the key of the table of topic consumed from cluster for
+// board, with redactions applied.
+func tableKeyOf(board, cluster, topic string, redactions []redaction) tableKey {
+	return tableKey{board: board, cluster: cluster, topic: topic, redactions: strings.Join(redactionFingerprints(redactions, topic), ",")}
+}
+
+// redactionFingerprints returns the sorted fingerprints of the redactions
+// applying to topic.
+func redactionFingerprints(redactions []redaction, topic string) []string {
+	fps := []string{}
+	for _, r := range redactions {
+		if r.topic.MatchString(topic) {
+			byt, _ := json.Marshal(r.redactionJSON)
+			sum := sha256.Sum256(byt)
+			fps = append(fps, hex.EncodeToString(sum[:8]))
+		}
+	}
+	sort.Strings(fps)
+	return fps
+}
+
+func (r *tableRegistry) upsert(k tableKey, m message) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	if _, ok := r.tables[k]; !ok {
+		r.tables[k] = map[string]tableRow{}
+	}
+	r.tables[k][m.Key] = tableRow{Key: m.Key, Value: m.Value, Cluster: m.Cluster, Partition: m.Partition, Offset: m.Offset, Timestamp: m.Timestamp}
+}
+
+// remove forgets key on a tombstone, i.e. a message without a value.
+func (r *tableRegistry) remove(k tableKey, key string) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	delete(r.tables[k], key)
+}
+
+// find returns the key of the table of topic consumed from cluster for board
+// that has at least redactions applied, preferring the least redacted.
+func (r *tableRegistry) find(board, cluster, topic string, redactions []redaction) (tableKey, bool) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	found, ok := tableKey{}, false
+	for k := range r.tables {
+		if k.board != board || k.cluster != cluster || k.topic != topic || !redactedAtLeast(k, redactions) {
+			continue
+		}
+		if !ok || len(k.redactions) < len(found.redactions) || (len(k.redactions) == len(found.redactions) && k.redactions < found.redactions) {
+			found, ok = k, true
+		}
+	}
+	return found, ok
+}
+
+func redactedAtLeast(k tableKey, redactions []redaction) bool {
+	applied := map[string]bool{}
+	for _, fp := range strings.Split(k.redactions, ",") {
+		applied[fp] = true
+	}
+	for _, fp := range redactionFingerprints(redactions, k.topic) {
+		if !applied[fp] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *tableRegistry) get(k tableKey, key string) (tableRow, bool) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	row, ok := r.tables[k][key]
+	return row, ok
+}
+
+// rows returns up to limit rows of the table, by key, and how many there are.
+func (r *tableRegistry) rows(k tableKey, limit int) ([]tableRow, int) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	t := r.tables[k]
+	keys := []string{}
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	rows := []tableRow{}
+	for i := 0; i < len(keys) && i < limit; i++ {
+		rows = append(rows, t[keys[i]])
+	}
+	return rows, len(keys)
+}
+
+// tableHandler serves /api/table/{topic}?key=..., or the first limit rows of
+// the topic without a key, as consumed from the cluster parameter, for the
+// board of the board parameter if any, and redacted with at least the
+// redactions parameter, a JSON list of redactions.
+func tableHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %v not allowed", r.Method))
+		return
+	}
+	topic := strings.TrimPrefix(r.URL.Path, "/api/table/")
+	if len(topic) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Please define the topic, e.g. /api/table/orders"))
+		return
+	}
+
+	q := r.URL.Query()
+	board := q.Get("board")
+	if !principalOf(r).mayRead(board) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("You may not read the tables of board %v", board))
+		return
+	}
+	limit := defaultTableLimit
+	if l := q.Get("limit"); len(l) > 0 {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid limit %v", l))
+			return
+		}
+		limit = n
+	}
+	redactionsJSON := []redactionJSON{}
+	if rs := q.Get("redactions"); len(rs) > 0 {
+		if err := json.Unmarshal([]byte(rs), &redactionsJSON); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid redactions %v. err=%v", rs, err))
+			return
+		}
+	}
+	redactions, err := processRedactions(redactionsJSON)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	k, ok := tables.find(board, q.Get("cluster"), topic, redactions)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("Topic %v isn't consumed as a table", topic))
+		return
+	}
+	if _, ok := q["key"]; ok {
+		row, ok := tables.get(k, q.Get("key"))
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("No key %v in table %v", q.Get("key"), topic))
+			return
+		}
+		writeJSON(w, http.StatusOK, row)
+		return
+	}
+	rows, total := tables.rows(k, limit)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"topic": topic, "total": total, "rows": rows})
+}
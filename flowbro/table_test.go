@@ -0,0 +1,82 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTablesKeepTheLatestValuePerKey(t *testing.T) {
+	tables.upsert(tableKeyOf("", "", "order-states", nil), message{Topic: "order-states", Key: "42", Offset: 1, Value: newValueFrom(`{"state":"NEW"}`)})
+	tables.upsert(tableKeyOf("", "", "order-states", nil), message{Topic: "order-states", Key: "42", Offset: 2, Value: newValueFrom(`{"state":"PAID"}`)})
+	tables.upsert(tableKeyOf("", "", "order-states", nil), message{Topic: "order-states", Key: "43", Offset: 3, Value: newValueFrom(`{"state":"NEW"}`)})
+	tables.upsert(tableKeyOf("", "", "order-states", nil), message{Topic: "order-states", Key: "44", Offset: 4, Value: newValueFrom(`{"state":"NEW"}`)})
+	tables.remove(tableKeyOf("", "", "order-states", nil), "44")
+
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		expected string
+	}{
+		{name: "latest value of a key", path: "/api/table/order-states?key=42", status: http.StatusOK, expected: `{"key":"42","value":{"state":"PAID"},"partition":0,"offset":2,"timestamp":"0001-01-01T00:00:00Z"}`},
+		{name: "deleted key", path: "/api/table/order-states?key=44", status: http.StatusNotFound},
+		{name: "rows", path: "/api/table/order-states?limit=1", status: http.StatusOK, expected: `{"rows":[{"key":"42","value":{"state":"PAID"},"partition":0,"offset":2,"timestamp":"0001-01-01T00:00:00Z"}],"topic":"order-states","total":2}`},
+		{name: "invalid limit", path: "/api/table/order-states?limit=-1", status: http.StatusBadRequest},
+		{name: "unknown table", path: "/api/table/payments", status: http.StatusNotFound},
+		{name: "missing topic", path: "/api/table/", status: http.StatusBadRequest},
+	}
+	for _, ts := range tests {
+		w := httptest.NewRecorder()
+		tableHandler(w, httptest.NewRequest("GET", ts.path, nil))
+		if w.Code != ts.status {
+			t.Errorf("on '%v': expected status %v but got %v", ts.name, ts.status, w.Code)
+			continue
+		}
+		if len(ts.expected) == 0 {
+			continue
+		}
+		var actual, expected interface{}
+		json.Unmarshal(w.Body.Bytes(), &actual)
+		json.Unmarshal([]byte(ts.expected), &expected)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("on '%v': expected %v but got %v", ts.name, ts.expected, w.Body.String())
+		}
+	}
+}
+
+func TestTablesAreKeptApartPerClusterAndRedactions(t *testing.T) {
+	redactions, _ := processRedactions([]redactionJSON{{Topic: "customers", Path: "$.email", Strategy: "drop"}})
+	tables.upsert(tableKeyOf("", "eu", "customers", nil), message{Topic: "customers", Key: "1", Cluster: "eu", Value: newValueFrom(`{"email":"jane@example.com"}`)})
+	tables.upsert(tableKeyOf("", "us", "customers", redactions), message{Topic: "customers", Key: "1", Cluster: "us", Value: newValueFrom(`{}`)})
+
+	redacted := url.QueryEscape(`[{"topic": "customers", "path": "$.email", "strategy": "drop"}]`)
+	tests := []struct {
+		name     string
+		path     string
+		status   int
+		expected string
+	}{
+		{name: "cluster", path: "/api/table/customers?key=1&cluster=eu", status: http.StatusOK, expected: "jane"},
+		{name: "other cluster", path: "/api/table/customers?key=1&cluster=us", status: http.StatusOK, expected: `"cluster":"us"`},
+		{name: "more redacted table", path: "/api/table/customers?key=1&cluster=us&redactions=" + redacted, status: http.StatusOK, expected: `"cluster":"us"`},
+		{name: "less redacted table", path: "/api/table/customers?key=1&cluster=eu&redactions=" + redacted, status: http.StatusNotFound},
+		{name: "unknown cluster", path: "/api/table/customers?key=1&cluster=ap", status: http.StatusNotFound},
+		{name: "invalid redactions", path: "/api/table/customers?key=1&redactions=%5B", status: http.StatusBadRequest},
+	}
+	for _, ts := range tests {
+		w := httptest.NewRecorder()
+		tableHandler(w, httptest.NewRequest("GET", ts.path, nil))
+		if w.Code != ts.status {
+			t.Errorf("on '%v': expected status %v but got %v", ts.name, ts.status, w.Code)
+			continue
+		}
+		if !strings.Contains(w.Body.String(), ts.expected) {
+			t.Errorf("on '%v': expected %v in %v", ts.name, ts.expected, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package flowbro
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// rateLimiter spaces out calls to wait so they happen at most perSecond
+// times per second. It is safe to share between goroutines.
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+	l        sync.Mutex
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+func (r *rateLimiter) wait() {
+	r.l.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	sleep := r.next.Sub(now)
+	r.next = r.next.Add(r.interval)
+	r.l.Unlock()
+
+	time.Sleep(sleep)
+}
+
+// throttle forwards the messages of in at the pace allowed by r. Since
+// partition consumers only fetch when their messages are drained, a slow
+// pace also keeps flowbro from pulling more than needed from the brokers.
+func throttle(in <-chan *sarama.ConsumerMessage, r *rateLimiter) <-chan *sarama.ConsumerMessage {
+	if r == nil {
+		return in
+	}
+	out := make(chan *sarama.ConsumerMessage)
+	go func() {
+		for msg := range in {
+			r.wait()
+			out <- msg
+		}
+		close(out)
+	}()
+	return out
+}
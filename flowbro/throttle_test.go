@@ -0,0 +1,38 @@
+package flowbro
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestThrottle(t *testing.T) {
+	in := make(chan *sarama.ConsumerMessage)
+	go func() {
+		for i := 0; i < 10; i++ {
+			in <- &sarama.ConsumerMessage{Offset: int64(i)}
+		}
+		close(in)
+	}()
+
+	start := time.Now()
+	out := throttle(in, newRateLimiter(200))
+	count := 0
+	for range out {
+		count++
+	}
+	if count != 10 {
+		t.Errorf("expected all 10 messages but got %v", count)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("expected 10 messages at 200/s to take at least 40ms but took %v", elapsed)
+	}
+}
+
+func TestNoLimitDoesntThrottle(t *testing.T) {
+	in := make(chan *sarama.ConsumerMessage)
+	if newRateLimiter(0) != nil || throttle(in, nil) != (<-chan *sarama.ConsumerMessage)(in) {
+		t.Errorf("expected a zero rate not to throttle")
+	}
+}
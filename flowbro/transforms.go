@@ -0,0 +1,170 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+type transformJSON struct {
+	Topic     string   `json:"topic"`
+	Template  string   `json:"template"`
+	Fields    []string `json:"fields"`
+	Flatten   bool     `json:"flatten"`
+	Separator string   `json:"separator"`
+}
+
+// transform shapes the JSON sent to the UI for the messages of the topics
+// matching its regex. Rules still match against the original value.
+type transform struct {
+	topic    *regexp.Regexp
+	template *template.Template
+	fields   [][]string
+	flatten  string // the separator of flattened keys, if flattening
+}
+
+const defaultFlattenSeparator = "."
+
+// transformFuncs are available to transform templates on top of text/template's.
+var transformFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		byt, err := json.Marshal(v)
+		return string(byt), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"add":   func(a, b float64) float64 { return a + b },
+	"sub":   func(a, b float64) float64 { return a - b },
+	"mul":   func(a, b float64) float64 { return a * b },
+	"div":   func(a, b float64) float64 { return a / b },
+}
+
+func processTransforms(transformsJSON []transformJSON) ([]transform, error) {
+	transforms := []transform{}
+	for _, t := range transformsJSON {
+		topic, err := regexp.Compile("^(?:" + t.Topic + ")$")
+		if err != nil {
+			return transforms, fmt.Errorf("Invalid topic regex %v for transform. err=%v", t.Topic, err)
+		}
+		tr := transform{topic: topic}
+		if t.Flatten {
+			if tr.flatten = t.Separator; len(tr.flatten) == 0 {
+				tr.flatten = defaultFlattenSeparator
+			}
+		} else if len(t.Separator) > 0 {
+			return transforms, fmt.Errorf("Separator set in transform of topic %v without flatten", t.Topic)
+		}
+		for _, f := range t.Fields {
+			if len(f) == 0 {
+				return transforms, fmt.Errorf("Empty field in transform of topic %v", t.Topic)
+			}
+			tr.fields = append(tr.fields, strings.Split(f, "."))
+		}
+		if len(t.Template) > 0 {
+			if tr.template, err = template.New(t.Topic).Funcs(transformFuncs).Option("missingkey=zero").Parse(t.Template); err != nil {
+				return transforms, fmt.Errorf("Invalid template for transform of topic %v. err=%v", t.Topic, err)
+			}
+		}
+		transforms = append(transforms, tr)
+	}
+	return transforms, nil
+}
+
+// applyTransforms sets m's output by running the first transform matching its topic.
+func applyTransforms(transforms []transform, m message) (message, error) {
+	for _, t := range transforms {
+		if !t.topic.MatchString(m.Topic) {
+			continue
+		}
+		out, err := t.apply(m)
+		if err != nil {
+			return m, err
+		}
+		m.Output = out
+		return m, nil
+	}
+	return m, nil
+}
+
+func (t transform) apply(m message) (map[string]interface{}, error) {
+	out := m.Value
+	if t.template != nil {
+		var b bytes.Buffer
+		if err := t.template.Execute(&b, m); err != nil {
+			return nil, fmt.Errorf("Could not execute template for topic %v. err=%v", m.Topic, err)
+		}
+		out = map[string]interface{}{}
+		if err := json.Unmarshal(b.Bytes(), &out); err != nil {
+			return nil, fmt.Errorf("Template for topic %v didn't produce a JSON object. err=%v output=%v", m.Topic, err, b.String())
+		}
+	}
+	if len(t.fields) > 0 {
+		out = project(out, t.fields)
+	}
+	if len(t.flatten) > 0 {
+		out = flatten(out, t.flatten)
+	}
+	return out, nil
+}
+
+// flatten returns a copy of v without nesting, keyed by the joined paths of
+// its leaves, e.g. {"address.city": "Paris", "items.0.id": 1}. Empty objects
+// and arrays are leaves.
+func flatten(v map[string]interface{}, separator string) map[string]interface{} {
+	out := map[string]interface{}{}
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			if len(t) > 0 {
+				for k, e := range t {
+					walk(prefix+separator+k, e)
+				}
+				return
+			}
+		case []interface{}:
+			if len(t) > 0 {
+				for i, e := range t {
+					walk(fmt.Sprintf("%v%v%v", prefix, separator, i), e)
+				}
+				return
+			}
+		}
+		out[prefix[len(separator):]] = v
+	}
+	for k, e := range v {
+		walk(separator+k, e)
+	}
+	return out
+}
+
+// project returns a copy of v holding only the fields at the given paths,
+// keeping their nesting; paths missing from v are skipped.
+func project(v map[string]interface{}, paths [][]string) map[string]interface{} {
+	out := map[string]interface{}{}
+	for _, path := range paths {
+		src, dst := v, out
+		for i, name := range path {
+			f, ok := src[name]
+			if !ok {
+				break
+			}
+			if i == len(path)-1 {
+				dst[name] = f
+				break
+			}
+			next, ok := f.(map[string]interface{})
+			if !ok {
+				break
+			}
+			if _, ok := dst[name].(map[string]interface{}); !ok {
+				dst[name] = map[string]interface{}{}
+			}
+			src, dst = next, dst[name].(map[string]interface{})
+		}
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package flowbro
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyTransforms(t *testing.T) {
+	transforms, err := processTransforms([]transformJSON{
+		{Topic: "orders", Template: `{"order": {{json .Value.id}}, "total": {{mul .Value.price .Value.quantity}}, "status": {{json (lower .Value.status)}}}`},
+		{Topic: "orders|payments"},
+		{Topic: "users", Fields: []string{"id", "address.city", "address.zip", "missing.field"}},
+		{Topic: "customers", Flatten: true},
+		{Topic: "accounts", Fields: []string{"id", "owner"}, Flatten: true, Separator: "_"},
+	})
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		m        message
+		expected map[string]interface{}
+	}{
+		{
+			name:     "renames and computes fields",
+			m:        message{Topic: "orders", Value: newValueFrom(`{"id":"o1","price":2.5,"quantity":4,"status":"PAID","noise":[1,2,3]}`)},
+			expected: newValueFrom(`{"order":"o1","total":10,"status":"paid"}`),
+		},
+		{
+			name:     "transforms without template pass the value through",
+			m:        message{Topic: "payments", Value: newValueFrom(`{"id":"p1"}`)},
+			expected: newValueFrom(`{"id":"p1"}`),
+		},
+		{
+			name:     "fields whitelist keeps nesting",
+			m:        message{Topic: "users", Value: newValueFrom(`{"id":"u1","name":"Ann","address":{"city":"Paris","zip":"75001","street":"Rue"},"history":[1,2,3]}`)},
+			expected: newValueFrom(`{"id":"u1","address":{"city":"Paris","zip":"75001"}}`),
+		},
+		{
+			name:     "flattens nested objects and arrays",
+			m:        message{Topic: "customers", Value: newValueFrom(`{"id":"c1","address":{"city":"Paris","geo":{"lat":48.8}},"items":[{"sku":"a"},2],"tags":[],"meta":{}}`)},
+			expected: newValueFrom(`{"id":"c1","address.city":"Paris","address.geo.lat":48.8,"items.0.sku":"a","items.1":2,"tags":[],"meta":{}}`),
+		},
+		{
+			name:     "flattens the projected fields with a separator",
+			m:        message{Topic: "accounts", Value: newValueFrom(`{"id":"a1","owner":{"name":"Ann"},"balance":{"eur":1}}`)},
+			expected: newValueFrom(`{"id":"a1","owner_name":"Ann"}`),
+		},
+		{
+			name:     "unmatched topics have no output",
+			m:        message{Topic: "other", Value: newValueFrom(`{"id":"x"}`)},
+			expected: nil,
+		},
+	}
+
+	for _, ts := range tests {
+		actual, err := applyTransforms(transforms, ts.m)
+		if err != nil {
+			t.Errorf("on '%v': shouldn't have failed, but did with %v", ts.name, err)
+		}
+		if !reflect.DeepEqual(actual.Output, ts.expected) {
+			t.Errorf("on '%v': expected output %v but got %v", ts.name, ts.expected, actual.Output)
+		}
+		if !reflect.DeepEqual(actual.Value, ts.m.Value) {
+			t.Errorf("on '%v': expected value to be untouched but got %v", ts.name, actual.Value)
+		}
+	}
+}
+
+func TestTransformErrors(t *testing.T) {
+	if _, err := processTransforms([]transformJSON{{Topic: "orders", Template: `{{.Value.id`}}); err == nil {
+		t.Errorf("expected invalid templates to be rejected at config load")
+	}
+
+	if _, err := processTransforms([]transformJSON{{Topic: "orders", Separator: "_"}}); err == nil {
+		t.Errorf("expected a separator without flatten to be rejected at config load")
+	}
+
+	transforms, _ := processTransforms([]transformJSON{{Topic: "orders", Template: `not json`}})
+	if _, err := applyTransforms(transforms, message{Topic: "orders", Value: newValueFrom(`{}`)}); err == nil {
+		t.Errorf("expected templates not producing JSON objects to fail")
+	}
+}
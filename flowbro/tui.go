@@ -0,0 +1,274 @@
+package flowbro
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	tuiHistory       = 1000
+	tuiRefresh       = 250 * time.Millisecond
+	tuiStatsInterval = time.Second
+	defaultTUIWidth  = 80
+	defaultTUIHeight = 24
+)
+
+// tui shows a flow in the terminal, for servers where opening a browser
+// isn't possible: the throughput of every topic on top, from partitionStats
+// events, and a scrolling list of the other events below. It reads commands
+// a line at a time, so it needs no terminal library: "/text" only lists
+// events containing text, "/" lists them all again, "p" pauses and resumes
+// the list and "q" quits.
+type tui struct {
+	in     io.Reader
+	out    io.Writer
+	title  string
+	width  int
+	height int
+	r      *http.Request
+
+	l      sync.Mutex
+	topics map[string]map[int32]partitionStats
+	lines  []tuiLine
+	frozen []tuiLine
+	filter string
+	paused bool
+	dirty  bool
+	closed bool
+}
+
+type tuiLine struct {
+	at   time.Time
+	text string
+}
+
+func newTUI(in io.Reader, out io.Writer, title string) *tui {
+	return &tui{
+		in:     in,
+		out:    out,
+		title:  title,
+		width:  envInt("COLUMNS", defaultTUIWidth),
+		height: envInt("LINES", defaultTUIHeight),
+		topics: map[string]map[int32]partitionStats{},
+		dirty:  true,
+	}
+}
+
+// envInt reads the terminal's size, which shells only pass on once
+// exported, e.g. with `export COLUMNS LINES`.
+func envInt(name string, fallback int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil && n > 0 {
+		return n
+	}
+	return fallback
+}
+
+// run shows the flow stream sends it until ctx is done or the user quits.
+func (t *tui) run(ctx context.Context, stream func(c conn)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	t.r = (&http.Request{Method: "GET", URL: &url.URL{Path: "/tui"}, RemoteAddr: "terminal"}).WithContext(ctx)
+
+	go func() {
+		lines := bufio.NewScanner(t.in)
+		for lines.Scan() {
+			if t.command(lines.Text()) {
+				cancel()
+				return
+			}
+		}
+	}()
+	go func() {
+		ticker := time.NewTicker(tuiRefresh)
+		defer ticker.Stop()
+		for {
+			t.draw(time.Now())
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	stream(t)
+	cancel()
+	fmt.Fprintln(t.out)
+	return nil
+}
+
+// command applies a line typed by the user, returning true to quit.
+func (t *tui) command(line string) bool {
+	line = strings.TrimSpace(line)
+	t.l.Lock()
+	defer t.l.Unlock()
+	switch {
+	case line == "q":
+		return true
+	case line == "p":
+		t.paused = !t.paused
+		t.frozen = append([]tuiLine{}, t.lines...)
+	case strings.HasPrefix(line, "/"):
+		t.filter = strings.TrimSpace(line[1:])
+	default:
+		return false
+	}
+	t.dirty = true
+	return false
+}
+
+func (t *tui) owned() {}
+
+func (t *tui) Write(b []byte) (int, error) {
+	var events []event
+	if err := json.Unmarshal(b, &events); err != nil {
+		return 0, err
+	}
+
+	t.l.Lock()
+	defer t.l.Unlock()
+	if t.closed {
+		return 0, io.ErrClosedPipe
+	}
+	now := time.Now()
+	for _, e := range events {
+		switch e.EventType {
+		case "partitionStats":
+			if e.Stats == nil || e.Partition == nil {
+				continue
+			}
+			name := e.Topic
+			if len(e.Cluster) > 0 {
+				name = e.Cluster + "/" + e.Topic
+			}
+			if _, ok := t.topics[name]; !ok {
+				t.topics[name] = map[int32]partitionStats{}
+			}
+			t.topics[name][*e.Partition] = *e.Stats
+		case "latencyStats":
+		default:
+			t.lines = append(t.lines, tuiLine{at: now, text: formatTUIEvent(e)})
+		}
+	}
+	if len(t.lines) > tuiHistory {
+		t.lines = append([]tuiLine{}, t.lines[len(t.lines)-tuiHistory:]...)
+	}
+	t.dirty = true
+	return len(b), nil
+}
+
+func (t *tui) Close() error {
+	t.l.Lock()
+	defer t.l.Unlock()
+	t.closed = true
+	return nil
+}
+
+func (t *tui) Request() *http.Request {
+	return t.r
+}
+
+// formatTUIEvent describes e on one line, messages as the edge they cross.
+func formatTUIEvent(e event) string {
+	if e.EventType != "message" {
+		return fmt.Sprintf("%v: %v", e.EventType, strings.TrimSpace(e.Text))
+	}
+	s := fmt.Sprintf("[%v] %v -> %v", e.FSMId, e.SourceId, e.TargetId)
+	if len(e.Text) > 0 {
+		s += ": " + e.Text
+	}
+	if e.Count > 1 {
+		s += fmt.Sprintf(" (x%v)", e.Count)
+	}
+	return s
+}
+
+// draw redraws the screen if anything changed since the last time.
+func (t *tui) draw(now time.Time) {
+	t.l.Lock()
+	if !t.dirty {
+		t.l.Unlock()
+		return
+	}
+	t.dirty = false
+	screen := t.render(now)
+	t.l.Unlock()
+	io.WriteString(t.out, "\x1b[H\x1b[2J"+screen)
+}
+
+// render lays the screen out to fit the terminal: the header, up to a third
+// of it for topics, then as many of the latest matching events as fit, and
+// the commands as footer. It must be called with t.l held.
+func (t *tui) render(now time.Time) string {
+	header := fmt.Sprintf("flowbro · %v · %v", t.title, now.Format("15:04:05"))
+	if len(t.filter) > 0 {
+		header += fmt.Sprintf(" · filter %q", t.filter)
+	}
+	if t.paused {
+		header += " · paused"
+	}
+	rows := []string{header, fmt.Sprintf("%-40v %10v %10v %10v", "TOPIC", "MSG/S", "KB/S", "LAG")}
+
+	names := []string{}
+	for name := range t.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if max := t.height / 3; len(names) > max {
+		names = names[:max]
+	}
+	for _, name := range names {
+		var total partitionStats
+		for _, s := range t.topics[name] {
+			total.MessagesPerSecond += s.MessagesPerSecond
+			total.BytesPerSecond += s.BytesPerSecond
+			total.Lag += s.Lag
+		}
+		rows = append(rows, fmt.Sprintf("%-40v %10.1f %10.1f %10v", name, total.MessagesPerSecond, total.BytesPerSecond/1024, total.Lag))
+	}
+
+	lines := t.lines
+	if t.paused {
+		lines = t.frozen
+	}
+	matching := []string{}
+	filter := strings.ToLower(t.filter)
+	for _, l := range lines {
+		text := l.at.Format("15:04:05") + " " + l.text
+		if len(filter) == 0 || strings.Contains(strings.ToLower(text), filter) {
+			matching = append(matching, text)
+		}
+	}
+	rows = append(rows, "", fmt.Sprintf("EVENTS (%v of %v)", len(matching), len(lines)))
+	if room := t.height - len(rows) - 1; len(matching) > room {
+		if room < 0 {
+			room = 0
+		}
+		matching = matching[len(matching)-room:]
+	}
+	rows = append(rows, matching...)
+	rows = append(rows, `/text filters · / clears · p pauses · q quits`)
+
+	for i, row := range rows {
+		rows[i] = truncate(strings.Replace(row, "\n", " ", -1), t.width)
+	}
+	return strings.Join(rows, "\n")
+}
+
+// truncate cuts s to width runes.
+func truncate(s string, width int) string {
+	if r := []rune(s); len(r) > width {
+		return string(r[:width])
+	}
+	return s
+}
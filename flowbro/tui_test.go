@@ -0,0 +1,126 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTUICommands(t *testing.T) {
+	ts := []struct {
+		commands       []string
+		expectedFilter string
+		expectedPaused bool
+		expectedQuit   bool
+	}{
+		{[]string{"/payments"}, "payments", false, false},
+		{[]string{"/payments", "/"}, "", false, false},
+		{[]string{"p"}, "", true, false},
+		{[]string{"p", " p "}, "", false, false},
+		{[]string{"hello"}, "", false, false},
+		{[]string{"/ a b ", "q"}, "a b", false, true},
+	}
+
+	for _, tc := range ts {
+		u := newTUI(nil, ioutil.Discard, "test")
+		quit := false
+		for _, c := range tc.commands {
+			quit = u.command(c)
+		}
+		if u.filter != tc.expectedFilter || u.paused != tc.expectedPaused || quit != tc.expectedQuit {
+			t.Errorf("on '%v': expected filter %q, paused %v and quit %v but got %q, %v and %v", tc.commands, tc.expectedFilter, tc.expectedPaused, tc.expectedQuit, u.filter, u.paused, quit)
+		}
+	}
+}
+
+func TestTUIRender(t *testing.T) {
+	u := newTUI(nil, ioutil.Discard, "payments.json")
+	u.width, u.height = 80, 10
+	p0, p1 := int32(0), int32(1)
+	events := []event{
+		{EventType: "partitionStats", Cluster: "eu", Topic: "payments", Partition: &p0, Stats: &partitionStats{MessagesPerSecond: 2, BytesPerSecond: 1024, Lag: 3}},
+		{EventType: "partitionStats", Cluster: "eu", Topic: "payments", Partition: &p1, Stats: &partitionStats{MessagesPerSecond: 1.5, Lag: 4}},
+	}
+	for i := 0; i < 10; i++ {
+		events = append(events, event{EventType: "message", FSMId: "order-" + string(rune('a'+i)), SourceId: "shop", TargetId: "billing", Text: "paid\nin full"})
+	}
+	events = append(events, event{EventType: "log", Text: "Consuming " + strings.Repeat("x", 100)})
+	b, _ := json.Marshal(events)
+	if _, err := u.Write(b); err != nil {
+		t.Fatalf("shouldn't have failed writing events but did with %v", err)
+	}
+
+	rows := strings.Split(u.render(time.Now()), "\n")
+	if len(rows) != u.height {
+		t.Errorf("expected the screen to fill the %v rows but got %v: %v", u.height, len(rows), rows)
+	}
+	for _, r := range rows {
+		if len([]rune(r)) > u.width {
+			t.Errorf("expected rows to fit %v columns but got %q", u.width, r)
+		}
+	}
+	if !strings.HasPrefix(rows[2], "eu/payments") || !strings.Contains(rows[2], "3.5") || !strings.HasSuffix(rows[2], " 7") {
+		t.Errorf("expected the topic's throughput summed over partitions but got %q", rows[2])
+	}
+	if !strings.Contains(rows[len(rows)-2], "log: Consuming") || !strings.Contains(rows[len(rows)-3], "[order-j] shop -> billing: paid in full") {
+		t.Errorf("expected the latest events last but got %v", rows)
+	}
+
+	u.command("/order-c")
+	rows = strings.Split(u.render(time.Now()), "\n")
+	if !strings.Contains(rows[0], `filter "order-c"`) || !strings.Contains(rows[4], "EVENTS (1 of 11)") || !strings.Contains(rows[5], "[order-c]") {
+		t.Errorf("expected only the matching event to be listed but got %v", rows)
+	}
+
+	u.command("/")
+	u.command("p")
+	u.Write(b)
+	rows = strings.Split(u.render(time.Now()), "\n")
+	if !strings.Contains(rows[0], "paused") || !strings.Contains(rows[4], "EVENTS (11 of 11)") {
+		t.Errorf("expected the list not to move while paused but got %v", rows)
+	}
+}
+
+func TestTUIShowsFlow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flowbro")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fixtures := filepath.Join(dir, "fixtures.json")
+	ioutil.WriteFile(fixtures, []byte(`{"topic":"payments","key":"1","value":{}}`), 0644)
+	conf, _ := json.Marshal(configJSON{Rules: []rule{{
+		Patterns: []pattern{{Field: "{{.Topic}}", Pattern: "payments"}},
+		Events:   []event{{EventType: "message", SourceId: "a", TargetId: "b", FSMId: "{{.Key}}"}},
+	}}})
+	confPath := filepath.Join(dir, "payments.json")
+	ioutil.WriteFile(confPath, conf, 0644)
+
+	in, w := io.Pipe()
+	var out lockedBuffer
+	s, err := NewServer(WithMockPath(fixtures), WithTUI(confPath, in, &out))
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Run(context.Background()) }()
+
+	for deadline := time.Now().Add(2 * time.Second); !strings.Contains(out.String(), "[1] a -> b") && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(out.String(), "flowbro · payments.json") || !strings.Contains(out.String(), "[1] a -> b") {
+		t.Errorf("expected the payment's event to be shown but got %q", out.String())
+	}
+	w.Write([]byte("q\n"))
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected the tui to quit on q")
+	}
+}
@@ -1,20 +1,32 @@
-package main
+package flowbro
 
 import (
+	"context"
 	"time"
 
 	"github.com/Shopify/sarama"
 )
 
-func tutorial() chan *sarama.ConsumerMessage {
-	c := make(chan *sarama.ConsumerMessage)
+// tutorialSource serves the messages of the tutorial.
+type tutorialSource struct {
+	life *lifecycle
+}
 
-	go pushTutorialMessages(c)
+func (s *tutorialSource) Start(ctx context.Context) (<-chan *SourceMessage, error) {
+	c := make(chan *SourceMessage)
+	s.life = newLifecycle(ctx)
+	s.life.spawn(func(ctx context.Context) { pushTutorialMessages(ctx, c) })
+	return c, nil
+}
 
-	return c
+func (s *tutorialSource) Close() {
+	if s.life != nil {
+		s.life.stop()
+		s.life.wait()
+	}
 }
 
-func pushTutorialMessages(c chan *sarama.ConsumerMessage) {
+func pushTutorialMessages(ctx context.Context, c chan *SourceMessage) {
 	es := tutorialEvents()
 	i := 0
 	for {
@@ -23,9 +35,15 @@ func pushTutorialMessages(c chan *sarama.ConsumerMessage) {
 		}
 		switch es[i].kind {
 		case "sleep":
-			time.Sleep(es[i].duration)
+			if !sleep(ctx, es[i].duration) {
+				return
+			}
 		case "message":
-			c <- es[i].message
+			select {
+			case c <- fromConsumerMessage(es[i].message):
+			case <-ctx.Done():
+				return
+			}
 		}
 		if es[i].repeat > 0 {
 			es[i].repeat -= 1
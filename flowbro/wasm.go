@@ -0,0 +1,131 @@
+package flowbro
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// wasmMagic starts every WebAssembly module.
+var wasmMagic = []byte("\x00asm")
+
+// wasmPlugin runs a WebAssembly module under a WASI runtime, such as
+// wasmtime, as a co-process for the whole session: the module reads one JSON
+// message per line on stdin and answers each with one JSON line on stdout.
+// Flowbro doesn't embed a WebAssembly runtime, so the runtime's sandbox is
+// what keeps modules from the host, and it must be installed next to flowbro.
+type wasmPlugin struct {
+	name    string
+	command []string
+
+	cmd   *exec.Cmd
+	in    io.WriteCloser
+	lines chan string
+	done  chan struct{} // closed on stop, so the reader of a stuck module ends
+}
+
+func newWasmPlugin(name, path, runtime string) (*wasmPlugin, error) {
+	if len(strings.Fields(runtime)) == 0 {
+		return nil, fmt.Errorf("Cannot load WebAssembly plugin %v: no WASI runtime configured", name)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open WebAssembly plugin %v. err=%v", name, err)
+	}
+	defer f.Close()
+	magic := make([]byte, len(wasmMagic))
+	if _, err := io.ReadFull(f, magic); err != nil || !bytes.Equal(magic, wasmMagic) {
+		return nil, fmt.Errorf("WebAssembly plugin %v is not a WebAssembly module", name)
+	}
+	return &wasmPlugin{name: name, command: append(strings.Fields(runtime), path)}, nil
+}
+
+func (s *wasmPlugin) start() error {
+	s.cmd = exec.Command(s.command[0], s.command[1:]...)
+	s.cmd.Stderr = os.Stderr
+
+	in, err := s.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := s.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := s.cmd.Start(); err != nil {
+		return fmt.Errorf("Could not start script %v. err=%v", s.name, err)
+	}
+
+	s.in, s.lines, s.done = in, make(chan string), make(chan struct{})
+	go func(lines chan string, done chan struct{}) {
+		r := bufio.NewReader(out)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			select {
+			case lines <- line:
+			case <-done:
+				return
+			}
+		}
+	}(s.lines, s.done)
+	return nil
+}
+
+func (s *wasmPlugin) stop() {
+	if s.cmd == nil {
+		return
+	}
+	close(s.done)
+	s.in.Close()
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+	s.cmd = nil
+}
+
+// run hands m to the module and waits for its verdict. A module that
+// crashed or timed out is restarted on the next message.
+func (s *wasmPlugin) run(m scriptMessage) (scriptVerdict, error) {
+	var v scriptVerdict
+	if s.cmd == nil {
+		if err := s.start(); err != nil {
+			return v, err
+		}
+	}
+
+	byt, err := json.Marshal(m)
+	if err != nil {
+		return v, err
+	}
+	if _, err := s.in.Write(append(byt, '\n')); err != nil {
+		s.stop()
+		return v, fmt.Errorf("Script %v is not accepting messages. err=%v", s.name, err)
+	}
+
+	var line string
+	var ok bool
+	select {
+	case line, ok = <-s.lines:
+		if !ok {
+			s.stop()
+			return v, fmt.Errorf("Script %v exited", s.name)
+		}
+	case <-time.After(scriptTimeout):
+		s.stop()
+		return v, fmt.Errorf("Script %v didn't answer within %v", s.name, scriptTimeout)
+	}
+
+	if err := json.Unmarshal([]byte(line), &v); err != nil {
+		return v, fmt.Errorf("Script %v answered with invalid JSON. err=%v", s.name, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,115 @@
+package flowbro
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestWasmPluginsRunThroughTheRuntime(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "filter.wasm"), []byte{0, 'a', 's', 'm'}, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "text.wasm"), []byte("#!/bin/sh"), 0644)
+
+	s, err := newScript(scriptJSON{Topic: ".*", Name: "filter.wasm"}, dir, "wasmtime run")
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	if expected := []string{"wasmtime", "run", filepath.Join(dir, "filter.wasm")}; !reflect.DeepEqual(s.runner.(*wasmPlugin).command, expected) {
+		t.Errorf("expected command %v but got %v", expected, s.runner.(*wasmPlugin).command)
+	}
+
+	if _, err := newScript(scriptJSON{Topic: ".*", Name: "filter.wasm"}, dir, ""); err == nil {
+		t.Errorf("expected WebAssembly plugins without a runtime to be rejected")
+	}
+	if _, err := newScript(scriptJSON{Topic: ".*", Name: "text.wasm"}, dir, "wasmtime run"); err == nil {
+		t.Errorf("expected files that aren't WebAssembly modules to be rejected")
+	}
+}
+
+// wasmPluginSource tags big orders and drops the others. It waits for input
+// itself, since not every WASI runtime blocks reads of stdin.
+const wasmPluginSource = `package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+type stdin struct{}
+
+func (stdin) Read(b []byte) (int, error) {
+	for {
+		n, err := syscall.Read(0, b)
+		if err != syscall.EAGAIN {
+			return n, err
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func main() {
+	in := bufio.NewScanner(stdin{})
+	for in.Scan() {
+		var m map[string]map[string]float64
+		json.Unmarshal(in.Bytes(), &m)
+		if m["value"]["amount"] > 1000 {
+			fmt.Println("{\"tags\": {\"big\": \"yes\"}}")
+		} else {
+			fmt.Println("{\"drop\": true}")
+		}
+	}
+}
+`
+
+// nodeWASI runs the module given as its argument with Node's WASI, for
+// hosts without wasmtime.
+const nodeWASI = `const { WASI } = require('wasi')
+const fs = require('fs')
+const wasi = new WASI({ version: 'preview1', args: process.argv.slice(2) })
+WebAssembly.instantiate(fs.readFileSync(process.argv[2]), wasi.getImportObject()).then(({ instance }) => wasi.start(instance))
+`
+
+func TestWasmPluginsTransformMessages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a WebAssembly module")
+	}
+	dir, _ := ioutil.TempDir("", "flowbro")
+	defer os.RemoveAll(dir)
+
+	runtime := "wasmtime run"
+	if _, err := exec.LookPath("wasmtime"); err != nil {
+		if _, err := exec.LookPath("node"); err != nil {
+			t.Skip("needs wasmtime or node to run WebAssembly modules")
+		}
+		ioutil.WriteFile(filepath.Join(dir, "wasi.js"), []byte(nodeWASI), 0644)
+		runtime = "node --no-warnings " + filepath.Join(dir, "wasi.js")
+	}
+	ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(wasmPluginSource), 0644)
+	build := exec.Command("go", "build", "-o", filepath.Join(dir, "orders.wasm"), "main.go")
+	build.Dir, build.Env = dir, append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm", "GO111MODULE=off")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Skipf("could not build a WASI module: %v %s", err, out)
+	}
+
+	scripts, err := startScripts([]scriptJSON{{Topic: "orders", Name: "orders.wasm"}}, dir, runtime)
+	if err != nil {
+		t.Fatalf("shouldn't have failed, but did with %v", err)
+	}
+	defer stopScripts(scripts)
+
+	m, keep := runScripts(scripts, message{Topic: "orders", Value: newValueFrom(`{"amount": 2000}`)})
+	if !keep || m.Tags["big"] != "yes" {
+		t.Errorf("expected the big order to be kept and tagged, but got %+v (keep=%v)", m, keep)
+	}
+	if _, keep := runScripts(scripts, message{Topic: "orders", Value: newValueFrom(`{"amount": 10}`)}); keep {
+		t.Errorf("expected the small order to be dropped")
+	}
+}
@@ -0,0 +1,111 @@
+package flowbro
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// watch is a grep on the live stream a client registered: every consumed
+// message whose key and value match its regexes is sent back as a watchHit
+// event, whatever the rules make of it.
+type watch struct {
+	id    string
+	key   *regexp.Regexp
+	value *regexp.Regexp // against the value's JSON
+	hits  int64
+}
+
+// watches are the watches of a connection, in the order they were added.
+type watches []*watch
+
+const maxWatches = 10
+
+// apply adds or removes the watch of a watch or unwatch control, e.g.
+// {"action":"watch","id":"declines","keyRegex":"^order-","valueRegex":"declined"}.
+func (ws *watches) apply(ctl control) (string, error) {
+	if len(ctl.Id) == 0 {
+		return "", fmt.Errorf("Please define the id of the watch")
+	}
+	i := ws.index(ctl.Id)
+	if ctl.Action == "unwatch" {
+		if i < 0 {
+			return "", fmt.Errorf("Unknown watch %v", ctl.Id)
+		}
+		w := (*ws)[i]
+		*ws = append((*ws)[:i], (*ws)[i+1:]...)
+		return fmt.Sprintf("Stopped watch %v after %v hits", w.id, w.hits), nil
+	}
+
+	if len(ctl.KeyRegex) == 0 && len(ctl.ValueRegex) == 0 {
+		return "", fmt.Errorf("Please define a keyRegex, a valueRegex or both for watch %v", ctl.Id)
+	}
+	w := &watch{id: ctl.Id}
+	var err error
+	if len(ctl.KeyRegex) > 0 {
+		if w.key, err = regexp.Compile(ctl.KeyRegex); err != nil {
+			return "", fmt.Errorf("Invalid keyRegex for watch %v. err=%v", ctl.Id, err)
+		}
+	}
+	if len(ctl.ValueRegex) > 0 {
+		if w.value, err = regexp.Compile(ctl.ValueRegex); err != nil {
+			return "", fmt.Errorf("Invalid valueRegex for watch %v. err=%v", ctl.Id, err)
+		}
+	}
+	if i >= 0 {
+		(*ws)[i] = w
+		return fmt.Sprintf("Replaced watch %v", w.id), nil
+	}
+	if len(*ws) >= maxWatches {
+		return "", fmt.Errorf("Can't watch more than %v regexes at once; please unwatch one first", maxWatches)
+	}
+	*ws = append(*ws, w)
+	return fmt.Sprintf("Watching for %v", w.id), nil
+}
+
+func (ws watches) index(id string) int {
+	for i, w := range ws {
+		if w.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// match returns a watchHit event for every watch m matches, counting its
+// hits so far.
+func (ws watches) match(m message) []event {
+	if len(ws) == 0 {
+		return nil
+	}
+	var value []byte
+	events := []event{}
+	for _, w := range ws {
+		if w.key != nil && !w.key.MatchString(m.Key) {
+			continue
+		}
+		if w.value != nil {
+			if value == nil {
+				value, _ = json.Marshal(m.Value)
+			}
+			if !w.value.Match(value) {
+				continue
+			}
+		}
+		w.hits++
+		partition, offset, timestamp := m.Partition, m.Offset, m.Timestamp
+		events = append(events, event{
+			EventType: "watchHit",
+			Watch:     w.id,
+			Count:     w.hits,
+			Key:       m.Key,
+			Topic:     m.Topic,
+			Partition: &partition,
+			Offset:    &offset,
+			Cluster:   m.Cluster,
+			Timestamp: &timestamp,
+			JSON:      []map[string]interface{}{m.Value},
+		})
+	}
+	return events
+}
@@ -0,0 +1,70 @@
+package flowbro
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWatchesApply(t *testing.T) {
+	var ws watches
+	tests := []struct {
+		ctl      control
+		expected string
+		fails    bool
+	}{
+		{ctl: control{Action: "watch", Id: "declines", ValueRegex: "declined"}, expected: "Watching for declines"},
+		{ctl: control{Action: "watch", Id: "declines", KeyRegex: "^order-", ValueRegex: "declined"}, expected: "Replaced watch declines"},
+		{ctl: control{Action: "watch", Id: "vip", KeyRegex: "^vip-"}, expected: "Watching for vip"},
+		{ctl: control{Action: "watch", ValueRegex: "x"}, fails: true},
+		{ctl: control{Action: "watch", Id: "empty"}, fails: true},
+		{ctl: control{Action: "watch", Id: "broken", KeyRegex: "("}, fails: true},
+		{ctl: control{Action: "unwatch", Id: "vip"}, expected: "Stopped watch vip after 0 hits"},
+		{ctl: control{Action: "unwatch", Id: "vip"}, fails: true},
+	}
+
+	for _, ts := range tests {
+		text, err := ws.apply(ts.ctl)
+		if ts.fails != (err != nil) || text != ts.expected {
+			t.Errorf("on '%+v': expected %q (fails %v) but got %q and %v", ts.ctl, ts.expected, ts.fails, text, err)
+		}
+	}
+	if len(ws) != 1 || ws[0].id != "declines" || ws[0].key == nil {
+		t.Errorf("expected only the replaced watch to be left but got %+v", ws)
+	}
+
+	for i := len(ws); i < maxWatches; i++ {
+		ws.apply(control{Action: "watch", Id: strings.Repeat("w", i), KeyRegex: "."})
+	}
+	if _, err := ws.apply(control{Action: "watch", Id: "one too many", KeyRegex: "."}); err == nil {
+		t.Errorf("expected watches beyond %v to be refused", maxWatches)
+	}
+}
+
+func TestWatchesMatchKeysAndValues(t *testing.T) {
+	var ws watches
+	ws.apply(control{Action: "watch", Id: "declines", ValueRegex: `"status":"DECLINED"`})
+	ws.apply(control{Action: "watch", Id: "order-42", KeyRegex: `^order-42$`})
+
+	tests := []struct {
+		m        message
+		expected []string
+	}{
+		{message{Key: "order-41", Value: newValueFrom(`{"status":"PAID"}`)}, []string{}},
+		{message{Key: "order-41", Value: newValueFrom(`{"status":"DECLINED"}`)}, []string{"declines:1"}},
+		{message{Key: "order-42", Value: newValueFrom(`{"status":"DECLINED"}`)}, []string{"declines:2", "order-42:1"}},
+		{message{Key: "order-420", Value: newValueFrom(`{"status":"NEW"}`)}, []string{}},
+	}
+	for _, ts := range tests {
+		actual := []string{}
+		for _, e := range ws.match(ts.m) {
+			if e.EventType != "watchHit" || e.Key != ts.m.Key || len(e.JSON) != 1 {
+				t.Errorf("on '%v': expected a watchHit carrying the message but got %+v", ts.m.Key, e)
+			}
+			actual = append(actual, fmt.Sprintf("%v:%v", e.Watch, e.Count))
+		}
+		if strings.Join(actual, ",") != strings.Join(ts.expected, ",") {
+			t.Errorf("on '%v': expected hits %v but got %v", ts.m.Key, ts.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"text/template"
+	"time"
+)
+
+type webhookJSON struct {
+	Name         string  `json:"name"`
+	Topics       string  `json:"topics"`
+	Filter       string  `json:"filter"`
+	URL          string  `json:"url"`
+	Template     string  `json:"template"`
+	ContentType  string  `json:"contentType"`
+	BatchSize    int     `json:"batchSize"`
+	FlushSeconds float64 `json:"flushSeconds"`
+	Retries      *int    `json:"retries"`
+}
+
+// webhook posts the messages of the matching topics, as shown on the board
+// after transforms, to an HTTP endpoint in batches, so flows can trigger
+// downstream automation.
+type webhook struct {
+	batchingSink
+	name        string
+	topics      *regexp.Regexp
+	filter      *celProgram
+	url         string
+	template    *template.Template
+	contentType string
+	client      *http.Client
+}
+
+// webhookBatch is what webhook templates render, e.g.
+// {"text": "{{len .Messages}} big orders"}.
+type webhookBatch struct {
+	Webhook  string    `json:"webhook"`
+	Messages []message `json:"messages"`
+}
+
+var (
+	webhookPosts   = newCounter("flowbro_webhook_posts_total", "Batches posted by webhooks, by outcome.", "webhook", "outcome")
+	webhookDropped = newCounter("flowbro_webhook_dropped_messages_total", "Messages webhooks dropped because their queue was full.", "webhook")
+)
+
+func processWebhooks(webhooksJSON []webhookJSON) ([]*webhook, error) {
+	webhooks := []*webhook{}
+	for _, w := range webhooksJSON {
+		if len(w.Name) == 0 || len(w.Topics) == 0 || len(w.URL) == 0 {
+			return nil, fmt.Errorf("Please define name, topics and url for your webhook %v", w)
+		}
+		if w.BatchSize < 0 || w.FlushSeconds < 0 || w.Retries != nil && *w.Retries < 0 {
+			return nil, fmt.Errorf("Invalid webhook %v; batchSize, flushSeconds and retries can't be negative", w.Name)
+		}
+		topics, err := regexp.Compile("^(?:" + w.Topics + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid topic regex for webhook %v. err=%v", w.Name, err)
+		}
+		hook := &webhook{
+			name:        w.Name,
+			topics:      topics,
+			url:         w.URL,
+			contentType: "application/json",
+			client:      &http.Client{Timeout: time.Duration(5 * time.Second)},
+		}
+		hook.batchingSink = newBatchingSink(fmt.Sprintf("webhook [%v]", w.Name), "messages", hook, func(outcome string) {
+			if outcome == "dropped" {
+				webhookDropped.inc(w.Name)
+			} else {
+				webhookPosts.inc(w.Name, outcome)
+			}
+		})
+		if len(w.Filter) > 0 {
+			if hook.filter, err = compileCEL(w.Filter); err != nil {
+				return nil, fmt.Errorf("Invalid filter for webhook %v. err=%v", w.Name, err)
+			}
+		}
+		if len(w.Template) > 0 {
+			if hook.template, err = template.New(w.Name).Funcs(transformFuncs).Option("missingkey=zero").Parse(w.Template); err != nil {
+				return nil, fmt.Errorf("Invalid template for webhook %v. err=%v", w.Name, err)
+			}
+		}
+		if len(w.ContentType) > 0 {
+			hook.contentType = w.ContentType
+		}
+		if w.BatchSize > 0 {
+			hook.batchSize = w.BatchSize
+		}
+		if w.FlushSeconds > 0 {
+			hook.flush = time.Duration(w.FlushSeconds * float64(time.Second))
+		}
+		if w.Retries != nil {
+			hook.policy.retries = *w.Retries
+		}
+		webhooks = append(webhooks, hook)
+	}
+	return webhooks, nil
+}
+
+// startWebhooks runs every webhook in a goroutine of life, until it stops.
+func startWebhooks(webhooks []*webhook, life *lifecycle) {
+	for _, w := range webhooks {
+		w.start(life)
+	}
+}
+
+// forwardToWebhooks queues m on every started webhook matching it, dropping
+// it if the webhook's queue is full.
+func forwardToWebhooks(webhooks []*webhook, m message) []event {
+	events := []event{}
+	for _, w := range webhooks {
+		if !w.started() || !w.topics.MatchString(m.Topic) {
+			continue
+		}
+		if w.filter != nil {
+			keep, err := w.filter.match(m)
+			if err != nil {
+				events = append(events, event{EventType: "log", Text: fmt.Sprintf("Webhook [%v] could not filter message of topic %v. err=%v", w.name, m.Topic, err), Color: "error"})
+			}
+			if err != nil || !keep {
+				continue
+			}
+		}
+		if m.Output != nil {
+			m.Value, m.Output = m.Output, nil
+		}
+		w.queue(m)
+	}
+	return events
+}
+
+// webhookFailures drains the events about batches that couldn't be
+// delivered.
+func webhookFailures(webhooks []*webhook) []event {
+	events := []event{}
+	for _, w := range webhooks {
+		events = append(events, w.reported()...)
+	}
+	return events
+}
+
+// post returns whether a failed post may succeed when retried: connection
+// errors, 429s and 5xxs may, other rejections won't.
+func (w *webhook) post(body []byte) (bool, error) {
+	r, err := w.client.Post(w.url, w.contentType, bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	r.Body.Close()
+	if r.StatusCode >= 300 {
+		return r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500, fmt.Errorf("%v answered with status %v", w.url, r.StatusCode)
+	}
+	return false, nil
+}
+
+func (w *webhook) encode(batch []interface{}, now time.Time) ([]byte, error) {
+	b := webhookBatch{Webhook: w.name, Messages: []message{}}
+	for _, m := range batch {
+		b.Messages = append(b.Messages, m.(message))
+	}
+	if w.template == nil {
+		return json.Marshal(b)
+	}
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, b); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
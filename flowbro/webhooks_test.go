@@ -0,0 +1,133 @@
+package flowbro
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProcessWebhooks(t *testing.T) {
+	zero, negative := 0, -1
+	ts := []struct {
+		webhook webhookJSON
+		fails   bool
+	}{
+		{webhookJSON{Name: "w", Topics: "orders", URL: "http://localhost"}, false},
+		{webhookJSON{Name: "w", Topics: "orders", URL: "http://localhost", Filter: "value.amount > 1", Template: `{"n": {{len .Messages}}}`, Retries: &zero}, false},
+		{webhookJSON{Name: "w", Topics: "orders", URL: "http://localhost", Template: "{{"}, true},
+		{webhookJSON{Name: "w", Topics: "orders", URL: "http://localhost", Filter: "value.("}, true},
+		{webhookJSON{Name: "w", Topics: "orders(", URL: "http://localhost"}, true},
+		{webhookJSON{Name: "w", Topics: "orders", URL: "http://localhost", Retries: &negative}, true},
+		{webhookJSON{Name: "w", Topics: "orders", URL: "http://localhost", BatchSize: -1}, true},
+		{webhookJSON{Name: "w", Topics: "orders"}, true},
+		{webhookJSON{Topics: "orders", URL: "http://localhost"}, true},
+	}
+
+	for _, tc := range ts {
+		if _, err := processWebhooks([]webhookJSON{tc.webhook}); (err != nil) != tc.fails {
+			t.Errorf("on '%+v': expected failing to be %v but got err=%v", tc.webhook, tc.fails, err)
+		}
+	}
+}
+
+// webhookServer answers with the given statuses in turn, then with 200s,
+// recording the bodies it receives.
+type webhookServer struct {
+	sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func (s *webhookServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	byt, _ := ioutil.ReadAll(r.Body)
+	s.Lock()
+	defer s.Unlock()
+	s.bodies = append(s.bodies, string(byt))
+	if len(s.statuses) > 0 {
+		w.WriteHeader(s.statuses[0])
+		s.statuses = s.statuses[1:]
+	}
+}
+
+func (s *webhookServer) received() []string {
+	s.Lock()
+	defer s.Unlock()
+	return append([]string{}, s.bodies...)
+}
+
+func TestWebhookBatchesMessages(t *testing.T) {
+	s := &webhookServer{}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	webhooks, err := processWebhooks([]webhookJSON{{Name: "big", Topics: "orders", Filter: "value.amount > 10", URL: server.URL, BatchSize: 2, FlushSeconds: 60}})
+	if err != nil {
+		t.Fatalf("shouldn't have failed processing the webhook but did with %v", err)
+	}
+	life := newLifecycle(context.Background())
+	startWebhooks(webhooks, life)
+
+	for _, m := range []message{
+		{Topic: "orders", Value: map[string]interface{}{"amount": 20.0}},
+		{Topic: "orders", Value: map[string]interface{}{"amount": 5.0}},
+		{Topic: "payments", Value: map[string]interface{}{"amount": 20.0}},
+		{Topic: "orders", Value: map[string]interface{}{"amount": 30.0}, Output: map[string]interface{}{"shown": true}},
+		{Topic: "orders", Value: map[string]interface{}{"amount": 40.0}},
+	} {
+		forwardToWebhooks(webhooks, m)
+	}
+	life.stop()
+	life.wait()
+
+	bodies := s.received()
+	if len(bodies) != 2 {
+		t.Fatalf("expected a full batch and the rest sent on stop but got %v", bodies)
+	}
+	var b webhookBatch
+	if err := json.Unmarshal([]byte(bodies[0]), &b); err != nil {
+		t.Fatalf("expected a JSON batch but got %v", bodies[0])
+	}
+	if b.Webhook != "big" || len(b.Messages) != 2 || b.Messages[0].Value["amount"] != 20.0 || b.Messages[1].Value["shown"] != true {
+		t.Errorf("expected the two big orders as shown but got %+v", b)
+	}
+	if !strings.Contains(bodies[1], `"amount":40`) {
+		t.Errorf("expected the last order to be sent on stop but got %v", bodies[1])
+	}
+}
+
+func TestWebhookPostsTellWhatToRetry(t *testing.T) {
+	ts := []struct {
+		status   int
+		fails    bool
+		retrying bool
+	}{
+		{200, false, false},
+		{500, true, true},
+		{503, true, true},
+		{429, true, true},
+		{400, true, false},
+	}
+
+	for _, tc := range ts {
+		server := httptest.NewServer(&webhookServer{statuses: []int{tc.status}})
+		webhooks, _ := processWebhooks([]webhookJSON{{Name: "w", Topics: "orders", URL: server.URL}})
+		retry, err := webhooks[0].post([]byte("{}"))
+		server.Close()
+		if (err != nil) != tc.fails || retry != tc.retrying {
+			t.Errorf("on %v: expected failing %v and retrying %v but got %v, %v", tc.status, tc.fails, tc.retrying, retry, err)
+		}
+	}
+}
+
+func TestWebhookTemplates(t *testing.T) {
+	webhooks, _ := processWebhooks([]webhookJSON{{Name: "w", Topics: "orders", URL: "http://localhost", Template: "{{len .Messages}}"}})
+	if body, err := webhooks[0].encode([]interface{}{message{Topic: "orders"}}, time.Now()); err != nil || string(body) != "1" {
+		t.Errorf("expected the rendered template but got %s, %v", body, err)
+	}
+}
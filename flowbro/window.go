@@ -0,0 +1,99 @@
+package flowbro
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// replayWindow bounds a replay of every consumed partition between two
+// timestamps: messages past the end of the window are dropped, and once
+// every partition reaches it the window is over.
+type replayWindow struct {
+	from, to time.Time
+	seeked   map[string]map[int32]bool
+	ends     map[string]map[int32]int64
+	ended    bool
+}
+
+// newReplayWindow resolves where the window ends on every reseeked partition
+// of the clusters, leaving out those without messages in the window.
+func newReplayWindow(cs clusters, alias string, seeked map[string][]int32, from, to int64) (*replayWindow, error) {
+	w := &replayWindow{from: millisTime(from), to: millisTime(to), seeked: map[string]map[int32]bool{}, ends: map[string]map[int32]int64{}}
+	for topic, ps := range seeked {
+		w.seeked[topic] = map[int32]bool{}
+		for _, p := range ps {
+			w.seeked[topic][p] = true
+		}
+	}
+	for _, c := range cs {
+		if c.client == nil || (len(alias) > 0 && c.alias != alias) {
+			continue
+		}
+		for topic, ps := range seeked {
+			for _, p := range ps {
+				start, end, err := windowOffsets(c.client, topic, p, from, to)
+				if err != nil {
+					return nil, fmt.Errorf("Could not resolve the window to replay on topic %v partition %v. err=%v", topic, p, err)
+				}
+				if start < 0 || start >= end {
+					continue
+				}
+				if _, ok := w.ends[topic]; !ok {
+					w.ends[topic] = map[int32]int64{}
+				}
+				w.ends[topic][p] = end
+			}
+		}
+	}
+	return w, nil
+}
+
+// windowOffsets returns the first offset in the window and the first after
+// it, which is the newest offset if nothing was produced after the window.
+func windowOffsets(client sarama.Client, topic string, partition int32, from, to int64) (int64, int64, error) {
+	start, err := client.GetOffset(topic, partition, from)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := client.GetOffset(topic, partition, to+1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < 0 {
+		if end, err = client.GetOffset(topic, partition, sarama.OffsetNewest); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, end, nil
+}
+
+// keep tells whether the message at offset is within the window.
+func (w *replayWindow) keep(topic string, partition int32, offset int64) bool {
+	if !w.seeked[topic][partition] {
+		return true
+	}
+	end, ok := w.ends[topic][partition]
+	if ok && offset+1 >= end {
+		delete(w.ends[topic], partition)
+		if len(w.ends[topic]) == 0 {
+			delete(w.ends, topic)
+		}
+	}
+	return ok && offset < end
+}
+
+func (w *replayWindow) begin() event {
+	return event{EventType: "replayWindowBegin", Text: fmt.Sprintf("Replaying from %v to %v.", w.from.Format(time.RFC3339), w.to.Format(time.RFC3339)), Color: "happy", Timestamp: &w.from}
+}
+
+// end returns the replayWindowEnd event once every partition reached the end
+// of the window.
+func (w *replayWindow) end() (event, bool) {
+	if w.ended || len(w.ends) > 0 {
+		return event{}, false
+	}
+	w.ended = true
+	return event{EventType: "replayWindowEnd", Text: fmt.Sprintf("Finished replaying up to %v.", w.to.Format(time.RFC3339)), Color: "happy", Timestamp: &w.to}, true
+}
@@ -0,0 +1,43 @@
+package flowbro
+
+import (
+	"testing"
+)
+
+func TestReplayWindowStopsAtItsEnd(t *testing.T) {
+	w, err := newReplayWindow(clusters{&cluster{}}, "", map[string][]int32{"orders": {0, 1, 2}}, 1483228800000, 1483232400000)
+	if err != nil {
+		t.Fatalf("shouldn't have failed but did with %v", err)
+	}
+	// partition 2 had nothing in the window
+	w.ends = map[string]map[int32]int64{"orders": {0: 10, 1: 5}}
+
+	if e := w.begin(); e.EventType != "replayWindowBegin" || !e.Timestamp.Equal(w.from) {
+		t.Errorf("expected a begin marker at the start of the window but got %+v", e)
+	}
+	tests := []struct {
+		name      string
+		topic     string
+		partition int32
+		offset    int64
+		expected  bool
+		ended     bool
+	}{
+		{name: "in the window", topic: "orders", partition: 0, offset: 8, expected: true},
+		{name: "partition without messages in the window", topic: "orders", partition: 2, offset: 3, expected: false},
+		{name: "topic not reseeked", topic: "payments", partition: 0, offset: 3, expected: true},
+		{name: "last offset of partition 1", topic: "orders", partition: 1, offset: 4, expected: true},
+		{name: "past the window on partition 1", topic: "orders", partition: 1, offset: 5, expected: false},
+		{name: "past the window on partition 0", topic: "orders", partition: 0, offset: 12, expected: false, ended: true},
+		{name: "ended once", topic: "orders", partition: 0, offset: 13, expected: false},
+	}
+	for _, ts := range tests {
+		if keep := w.keep(ts.topic, ts.partition, ts.offset); keep != ts.expected {
+			t.Errorf("on '%v': expected keep to be %v", ts.name, ts.expected)
+		}
+		e, ended := w.end()
+		if ended != ts.ended || (ended && (e.EventType != "replayWindowEnd" || !e.Timestamp.Equal(w.to))) {
+			t.Errorf("on '%v': expected ended to be %v but got %+v", ts.name, ts.ended, e)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package flowbro
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// xmlToMap converts an XML document into its JSON representation: every
+// element becomes an object keyed by child element names (repeated children
+// become arrays), attributes are prefixed with "@" and mixed text is kept under
+// "#text". Elements holding only text become plain strings.
+func xmlToMap(b []byte) (map[string]interface{}, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("XML value has no root element")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse XML value. err=%v", err)
+		}
+		if start, ok := t.(xml.StartElement); ok {
+			v, err := xmlElement(d, start)
+			if err != nil {
+				return nil, fmt.Errorf("Could not parse XML value. err=%v", err)
+			}
+			return map[string]interface{}{start.Name.Local: v}, nil
+		}
+	}
+}
+
+func xmlElement(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	m := map[string]interface{}{}
+	for _, a := range start.Attr {
+		m["@"+a.Name.Local] = a.Value
+	}
+
+	var text bytes.Buffer
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			v, err := xmlElement(d, t)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			switch existing := m[name].(type) {
+			case nil:
+				m[name] = v
+			case []interface{}:
+				m[name] = append(existing, v)
+			default:
+				m[name] = []interface{}{existing, v}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(m) == 0 {
+				return s, nil
+			}
+			if len(s) > 0 {
+				m["#text"] = s
+			}
+			return m, nil
+		}
+	}
+}
@@ -1,24 +1,157 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"log"
+	"os"
+	"os/signal"
 
+	"github.com/marianogappa/flowbro/flowbro"
 	"github.com/pkg/profile"
 )
 
-var cpuprofile = flag.Bool("cpuprofile", false, "write cpu profile to file")
+var (
+	cpuprofile  = flag.Bool("cpuprofile", false, "write cpu profile to file")
+	mockPath    = flag.String("mock", "", "serve scripted messages from this fixtures file instead of connecting to Kafka")
+	dataDir     = flag.String("data-dir", "data", "directory where bookmarks and annotations are persisted")
+	scriptsDir  = flag.String("scripts-dir", "scripts", "directory holding the message transformation scripts configs may refer to")
+	wasmRuntime = flag.String("wasm-runtime", "wasmtime run", "WASI runtime command used to run .wasm plugins from the scripts directory")
+	authTokens  = flag.String("auth-tokens", "", "require the bearer tokens listed in this JSON file, mapping each to a name and a viewer or operator role")
+	oidcConfig  = flag.String("oidc", "", "log users in with the OpenID Connect provider configured in this JSON file")
+	ldapConfig  = flag.String("ldap", "", "authenticate users against the LDAP or Active Directory server configured in this JSON file")
+	outputAddr  = flag.String("output", "", "also publish the events of the -output-config flow as NDJSON on unix:<path> or tcp:<host:port>")
+	outputConf  = flag.String("output-config", "", "config file of the flow published on -output")
+	noServer    = flag.Bool("no-server", false, "print the events of the -config flow to stdout instead of serving the UI")
+	tuiMode     = flag.Bool("tui", false, "show the events of the -config flow in the terminal instead of serving the UI")
+	configPath  = flag.String("config", "", "config file of the flow printed with -no-server or shown with -tui")
+	eventFormat = flag.String("template", "", "with -no-server, print each event rendered through this Go template instead of as a JSON line")
+	remoteConf  = flag.String("remote-write", "", "also push flowbro's metrics to the Prometheus remote write endpoint configured in this JSON file")
+	statsdConf  = flag.String("statsd", "", "also emit flowbro's counters and gauges to the StatsD or DogStatsD agent configured in this JSON file")
+	grafana     = flag.Bool("grafana", false, "keep a day of flowbro's metrics for Grafana JSON datasources on /api/grafana/")
+	searchSize  = flag.Int("search-history", 0, "keep the last N messages consumed by any session searchable on /api/search")
+	redactConf  = flag.String("redactions", "", "redact the values of every session with the redactions in this JSON file, whatever their configs say")
+	boardsDir   = flag.String("boards-dir", "", "serve each <board>.json config in this directory on /ws/<board>, instead of accepting configs from clients on /ws")
+	alertsConf  = flag.String("alerts", "", "evaluate and notify the alerts of this config on the server, whether or not anybody is watching, sending them to every client")
+	busConf     = flag.String("bus", "", "share events between instances on the Kafka topic configured in this JSON file, as a -bus-mode publisher or frontend")
+	busMode     = flag.String("bus-mode", "", "with -bus, publish the events of every -boards-dir board to it, or serve boards on /ws/<board> from it as a frontend")
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "loadgen" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		if err := flowbro.RunLoadgen(ctx, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	flag.Parse()
 	if *cpuprofile {
 		defer profile.Start().Stop()
 	}
 
-	port := 41234
-	listener := mustGetListener(port)
-	baseTemplate := mustParseBasePageTemplate()
+	opts := []flowbro.Option{
+		flowbro.WithPort(flowbro.DefaultPort),
+		flowbro.WithMockPath(*mockPath),
+		flowbro.WithDataDir(*dataDir),
+		flowbro.WithScripts(*scriptsDir, *wasmRuntime),
+		flowbro.WithBoardsDir(*boardsDir),
+	}
+	if len(*outputAddr) > 0 {
+		if len(*outputConf) == 0 {
+			log.Fatal("Please define the flow to publish on -output with -output-config")
+		}
+		opts = append(opts, flowbro.WithOutput(*outputAddr, *outputConf))
+	}
+	if *noServer || *tuiMode {
+		if len(*configPath) == 0 {
+			log.Fatal("Please define the flow to show with -config")
+		}
+		if *noServer && *tuiMode {
+			log.Fatal("Please use either -no-server or -tui")
+		}
+		if len(*outputAddr) > 0 {
+			log.Fatal("Please use -output only when serving the UI")
+		}
+	}
+	if *noServer {
+		opts = append(opts, flowbro.WithHeadless(*configPath, *eventFormat, os.Stdout))
+	}
+	if *tuiMode {
+		opts = append(opts, flowbro.WithTUI(*configPath, os.Stdin, os.Stdout))
+	}
+	if len(*remoteConf) > 0 {
+		opts = append(opts, flowbro.WithRemoteWrite(*remoteConf))
+	}
+	if len(*statsdConf) > 0 {
+		opts = append(opts, flowbro.WithStatsd(*statsdConf))
+	}
+	if *grafana {
+		opts = append(opts, flowbro.WithGrafana(flowbro.DefaultGrafanaInterval))
+	}
+	if *searchSize != 0 {
+		opts = append(opts, flowbro.WithSearchHistory(*searchSize))
+	}
+	if len(*redactConf) > 0 {
+		opts = append(opts, flowbro.WithRedactionsFile(*redactConf))
+	}
+	if len(*alertsConf) > 0 {
+		if *noServer || *tuiMode {
+			log.Fatal("Please use -alerts only when serving the UI")
+		}
+		opts = append(opts, flowbro.WithAlerts(*alertsConf))
+	}
+	switch {
+	case len(*busConf) == 0 && len(*busMode) > 0:
+		log.Fatal("Please define the event bus with -bus")
+	case len(*busConf) == 0:
+	case *noServer || *tuiMode:
+		log.Fatal("Please use -bus only when serving the UI")
+	case *busMode == "publisher":
+		if len(*boardsDir) == 0 {
+			log.Fatal("Please define the boards to publish with -boards-dir")
+		}
+		opts = append(opts, flowbro.WithBusPublisher(*busConf))
+	case *busMode == "frontend":
+		opts = append(opts, flowbro.WithBusFrontend(*busConf))
+	default:
+		log.Fatal("Please use -bus-mode publisher or frontend")
+	}
+	providers := 0
+	for _, c := range []string{*authTokens, *oidcConfig, *ldapConfig} {
+		if len(c) > 0 {
+			providers++
+		}
+	}
+	if providers > 1 {
+		log.Fatal("Please use only one of -auth-tokens, -oidc and -ldap")
+	}
+	if len(*authTokens) > 0 {
+		opts = append(opts, flowbro.WithAuthTokens(*authTokens))
+	}
+	if len(*oidcConfig) > 0 {
+		opts = append(opts, flowbro.WithOIDC(*oidcConfig))
+	}
+	if len(*ldapConfig) > 0 {
+		opts = append(opts, flowbro.WithLDAP(*ldapConfig))
+	}
 
-	fmt.Printf("Flowbro is your bro on localhost:%v!\n", port)
-	serve(&flowbro{}, baseTemplate, listener)
+	s, err := flowbro.NewServer(opts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *noServer || *tuiMode {
+		if err := s.Run(ctx); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	fmt.Printf("Flowbro is your bro on localhost:%v!\n", flowbro.DefaultPort)
+	if err := s.Run(ctx); err != nil {
+		log.Println("Flowbro server went down: ", err)
+	}
 }
@@ -8,6 +8,7 @@ const init = (configFile) => {
         xhr.onreadystatechange = function(){
           if(xhr.status == 200 && xhr.readyState == 4){
             config = JSON.parse(xhr.responseText)
+            config.config = configFile
             config.heartbeatUUID = guid()
             console.log(config)
           }
@@ -17,6 +18,30 @@ const init = (configFile) => {
     }
 }
 
+// initShare opens a read-only view shared with /api/share; the server keeps
+// its config and sends the flow to draw once connected
+const initShare = (token) => {
+    var xhr = new XMLHttpRequest()
+    xhr.onreadystatechange = function(){
+      if(xhr.status == 200 && xhr.readyState == 4){
+        const shared = JSON.parse(xhr.responseText)
+        config = {
+            title: 'Shared view',
+            components: [],
+            rules: [],
+            kafka: {filter: shared.filter || ''},
+            webSocketAddress: window.location.host,
+            eventSeparationIntervalMilliseconds: 500,
+            animationLengthMilliseconds: 1000,
+            share: token,
+            heartbeatUUID: guid()
+        }
+      }
+    }
+    xhr.open("GET",`/api/share?token=${encodeURIComponent(token)}`,true)
+    xhr.send()
+}
+
 const log = (message, _color, event) => {
     event = event ? event : {sourceId: '', targetId: '', count: 0, aggregate: false, json: null}
 
@@ -176,6 +201,9 @@ const run = (timeout) => {
             config.kafka.brokers = brokersOverride
             log(`Overriding brokers to [${brokersOverride}]`)
         }
+        if (sessionName) {
+            config.session = sessionName
+        }
         if (fsmId) {
             config.fsmId = fsmId
             config.kafka.offset = String(offset)
@@ -211,6 +239,15 @@ const showNextUiEvent = () => {
     let event = eventQueue.shift()
 
     while (typeof event !== 'undefined' && event.eventType != 'message') {
+        if (event.eventType == 'session' && Array.isArray(event.json) && event.json.length == 1) {
+            restoreView(event.json[0])
+        }
+        if (event.eventType == 'configUpdate' && Array.isArray(event.json) && event.json.length == 1) {
+            applyConfigUpdate(event.json[0])
+        }
+        if (event.eventType == 'alert' && event.sourceId && _(`[id='component_${safeId(event.sourceId)}']`)) {
+            highlightElement(_(`[id='component_${safeId(event.sourceId)}']`))
+        }
         if (event.text) {
             log(event.text, event.color, event)
         }
@@ -237,9 +274,52 @@ const showNextUiEvent = () => {
     }
 }
 
+let socket
+
+// sendControl asks the server to act on the session, e.g.
+// sendControl({action: 'seek', topic: 'orders', partition: 0, offset: 42})
+const sendControl = (control) => {
+    if (socket && socket.readyState == WebSocket.OPEN) {
+        socket.send(JSON.stringify(control))
+    }
+}
+
+// saveView remembers the current filters in the named session, to restore
+// them when the session is reopened; sendControl({action: 'pause'}) and
+// sendControl({action: 'resume'}) pause and resume consuming.
+const saveView = () => sendControl({action: 'view', view: {filterFSMId: filterFSMId, filterIds: filterIds}})
+
+const restoreView = (view) => {
+    filterFSMId = view.filterFSMId || undefined
+    filterIds.splice(0, filterIds.length, ...(view.filterIds || []))
+}
+
+// lookupTable logs the latest value of key on a topic consumed as a table,
+// redacted as this config asks, e.g. lookupTable('order-states', '42'), or
+// lookupTable('order-states', '42', 'us') for a table of cluster us.
+const lookupTable = (topic, key, cluster) => {
+    const xhr = new XMLHttpRequest()
+    xhr.onreadystatechange = () => {
+        if (xhr.readyState == 4) {
+            const row = JSON.parse(xhr.responseText)
+            if (xhr.status == 200) {
+                log(`Table ${topic}, key ${key}:`, 'info', {json: [row.value]})
+            } else {
+                log(row.error, 'error')
+            }
+        }
+    }
+    const board = config.board ? `&board=${encodeURIComponent(config.board)}` : ''
+    const ofCluster = cluster ? `&cluster=${encodeURIComponent(cluster)}` : ''
+    const redactions = config.redactions ? `&redactions=${encodeURIComponent(JSON.stringify(config.redactions))}` : ''
+    xhr.open('GET', `/api/table/${encodeURIComponent(topic)}?key=${encodeURIComponent(key)}${board}${ofCluster}${redactions}`, true)
+    xhr.send()
+}
+
 const openWebSocket = () => {
-    const wsUrl = "ws://" + config.webSocketAddress + "/ws"
+    const wsUrl = "ws://" + config.webSocketAddress + (config.board ? `/ws/${config.board}` : "/ws")
     const ws = new WebSocket(wsUrl)
+    socket = ws
 
     ws.onopen = (event) => {
         log(`WebSocket open on [${wsUrl}]!`, 'happy')
@@ -273,6 +353,21 @@ const processUiEvents = (events) => {
     }
 }
 
+// applyConfigUpdate redraws the flow after its config changed on the server,
+// keeping the new rules and filter for when the connection is reopened
+const applyConfigUpdate = (flow) => {
+    config.title = flow.title
+    config.components = flow.components
+    config.rules = flow.rules
+    config.kafka.filter = flow.filter
+    if (flow.colourPalette) {
+        config.colourPalette = flow.colourPalette
+    }
+    document.querySelectorAll('.component').forEach(element => element.parentNode.removeChild(element))
+    _('#title').innerHTML = textLimit(config.title, 25)
+    loadComponents(config)
+}
+
 const loadComponents = (config) => {
     let colorRing = colorGenerator(config.colourPalette)
     for (let i in config.components) {
@@ -301,6 +396,11 @@ const loadComponents = (config) => {
         title.className = 'component_title'
         title.innerHTML = component.id
         element.appendChild(title)
+        if (component.icon) {
+            const icon = document.createElement('img')
+            icon.src = component.icon
+            element.insertBefore(icon, title)
+        }
         element.style.backgroundColor = component.backgroundColor ? component.backgroundColor : colorRing.next().value
         title.style.marginTop = "-" + (parseInt(title.offsetHeight) / 2) + "px"
         title.style.width = parseInt(element.style.width) - 20 - 2 // 20 = padding
@@ -500,6 +600,12 @@ if (fsmIdParam) {
     }
 }
 
+// Session query param
+const sessionName = getParameterByName('session', 'no_lowercase')
+
+// Share query param
+const shareToken = getParameterByName('share', 'no_lowercase')
+
 try {
     const inlineConfigParam = getParameterByName('inlineConfig', 'no_lowercase')
     if (inlineConfigParam !== null) {
@@ -507,7 +613,9 @@ try {
         eval(inlineConfig)
     }
 } finally {
-    if (typeof config === 'undefined') {
+    if (typeof config === 'undefined' && shareToken) {
+        initShare(shareToken)
+    } else if (typeof config === 'undefined') {
         init(getParameterByName('config') || 'config-example')
     }
 }